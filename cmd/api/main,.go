@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/dyrober/AgencyCRM/internal/config"
+	"github.com/dyrober/AgencyCRM/internal/mailer"
 	"github.com/dyrober/AgencyCRM/internal/repository"
 	"github.com/dyrober/AgencyCRM/internal/server"
 	"github.com/dyrober/AgencyCRM/internal/service"
@@ -24,17 +25,49 @@ func main() {
 	}
 
 	//Now we need to connect to the DB
-	db, err := repository.NewPostgresDB(cfg.DB)
+	repo, err := openStore(cfg.DB)
 	if err != nil {
 		log.Fatalf("Failed to connect to the Database: %v", err)
 	}
-	defer db.Close()
+	defer repo.Close()
 
 	//create the objects(layers) for the project
-	repo := repository.NewRepository(db)
 	svc := service.NewService(repo)
+
+	//Send email over SMTP when a server is configured, otherwise keep it in memory
+	var mail mailer.Mailer = mailer.NewCapture()
+	if cfg.SMTP.Host != "" {
+		mail = mailer.NewSMTP(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password)
+	} else {
+		log.Printf("Warning: SMTP_HOST is not set, emails are captured and not delivered")
+	}
+	if cfg.UnsubscribeSecret == "" {
+		log.Printf("Warning: UNSUBSCRIBE_SECRET is not set, unsubscribe links stop working on restart")
+	}
+	svc.UseMailer(mail, service.EmailSettings{
+		From:              cfg.SMTP.From,
+		TrackingURL:       cfg.PublicURL,
+		UnsubscribeSecret: cfg.UnsubscribeSecret,
+		PrivacyContact:    cfg.PrivacyEmail,
+	})
+
 	srv := server.NewServer(cfg, svc)
 
+	//Keep lead scores fresh and run automations in the background
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	svc.StartScoring(workerCtx, cfg.ScoringInterval)
+	svc.StartAutomations(workerCtx, cfg.AutomationPoll)
+	svc.StartWebhooks(workerCtx, cfg.WebhookPoll)
+	svc.StartSequences(workerCtx, cfg.SequencePoll)
+	svc.StartPrivacyReminders(workerCtx, time.Hour)
+	svc.StartTrashPurge(workerCtx, time.Hour, cfg.TrashRetention)
+	svc.StartIdempotencyKeyPurge(workerCtx, time.Hour)
+	svc.StartChangeFeed(workerCtx)
+	if cfg.InboundMaildir != "" {
+		svc.StartInbound(workerCtx, mailer.Maildir(cfg.InboundMaildir), cfg.InboundPoll)
+	}
+
 	//Start the server in a go routine
 	go func() {
 		log.Printf("Starting server on %s", cfg.ServerAddress)
@@ -66,3 +99,44 @@ func main() {
 
 	log.Println("Server shutdown correctly")
 }
+
+// openStore connects the repository for the configured database driver
+func openStore(cfg config.DBConfig) (repository.Store, error) {
+	switch cfg.Driver {
+	case config.DriverPgxPool:
+		pool, err := repository.NewPostgresPool(cfg)
+		if err != nil {
+			return nil, err
+		}
+		repo := repository.NewPoolRepository(pool)
+		if err := repo.UseReplicas(cfg); err != nil {
+			repo.Close()
+			return nil, err
+		}
+		return repo, nil
+	case config.DriverSQLite:
+		db, err := repository.NewSQLiteDB(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewSQLiteRepository(db), nil
+	case config.DriverMemory:
+		store := repository.NewMockRepository()
+		if err := repository.SeedFixtures(context.Background(), store); err != nil {
+			return nil, err
+		}
+		log.Println("Using the in-memory store, nothing is saved across restarts")
+		return store, nil
+	}
+
+	db, err := repository.NewPostgresDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	repo := repository.NewRepository(db)
+	if err := repo.UseReplicas(cfg); err != nil {
+		repo.Close()
+		return nil, err
+	}
+	return repo, nil
+}
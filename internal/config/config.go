@@ -2,8 +2,10 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -17,16 +19,84 @@ type Config struct {
 	DB                 DBConfig
 	StaticDir          string
 	TemplatesDir       string
+	ScoringInterval    time.Duration
+	AutomationPoll     time.Duration
+	WebhookPoll        time.Duration
+	FormRateLimit      int
+	// TrustedProxies are the reverse proxies in front of the app, only they
+	// are believed about the client address they forward
+	TrustedProxies []netip.Prefix
+	// PublicURL is where client sites and email recipients reach the app
+	PublicURL string
+	// UnsubscribeSecret signs the unsubscribe links in emails
+	UnsubscribeSecret string
+	SMTP              SMTPConfig
+	// InboundMaildir is polled for received email, ingestion is off when empty
+	InboundMaildir string
+	InboundPoll    time.Duration
+	SequencePoll   time.Duration
+	// PrivacyEmail receives reminders of data subject requests nearing their deadline
+	PrivacyEmail string
+	// TrashRetention is how long deleted records can be restored before they
+	// are deleted for good
+	TrashRetention time.Duration
+	// IdempotencyKeyTTL is how long a POST's Idempotency-Key replays its
+	// response to retries
+	IdempotencyKeyTTL time.Duration
 }
 
+// Database drivers
+const (
+	// DriverPostgres runs every query through database/sql
+	DriverPostgres = "postgres"
+	// DriverPgxPool runs list reads, bulk inserts and erasures on a native
+	// pgx pool and the rest through database/sql on the same pool
+	DriverPgxPool = "pgxpool"
+	// DriverSQLite keeps everything in a single SQLite file, for single-node
+	// and demo deployments that don't run Postgres
+	DriverSQLite = "sqlite"
+	// DriverMemory keeps everything in memory, seeded with sample records, so
+	// the app runs with no database at all. Nothing survives a restart.
+	DriverMemory = "memory"
+)
+
 // This holds the configs for the DB
 type DBConfig struct {
+	Driver   string
 	Host     string
 	Port     int
 	User     string
 	Password string
 	DBName   string
 	SSLMode  string
+	// Path is the database file of the sqlite driver
+	Path string
+	// MaxConns and MinConns bound the pool. MinConns is only kept warm by
+	// the pgxpool driver.
+	MaxConns int
+	MinConns int
+	// MaxConnLifetime and MaxConnIdleTime recycle old and unused connections
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+	// ReplicaDSNs are the connection strings of read replicas for lists,
+	// search and reports. They are checked every ReplicaCheckInterval and
+	// skipped while unreachable or lagging by more than ReplicaMaxLag.
+	ReplicaDSNs          []string
+	ReplicaCheckInterval time.Duration
+	ReplicaMaxLag        time.Duration
+	// ReadYourWritesWindow is how long a client's reads stay on the primary
+	// after it writes, so it sees its own changes
+	ReadYourWritesWindow time.Duration
+}
+
+// This holds the configs for outgoing email. Without a host, email is
+// captured in memory instead of delivered.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
 }
 
 // DSN returns the PostgresSQL connection string
@@ -53,19 +123,209 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid SERVER_WRITE_TIMEOUT: %w", err)
 	}
 
+	scoringInterval, err := strconv.Atoi(getEnv("SCORING_INTERVAL", "60"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SCORING_INTERVAL: %w", err)
+	}
+	if scoringInterval <= 0 {
+		return nil, fmt.Errorf("invalid SCORING_INTERVAL: must be a positive number of minutes")
+	}
+
+	automationPoll, err := strconv.Atoi(getEnv("AUTOMATION_POLL_INTERVAL", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTOMATION_POLL_INTERVAL: %w", err)
+	}
+	if automationPoll <= 0 {
+		return nil, fmt.Errorf("invalid AUTOMATION_POLL_INTERVAL: must be a positive number of seconds")
+	}
+
+	webhookPoll, err := strconv.Atoi(getEnv("WEBHOOK_POLL_INTERVAL", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_POLL_INTERVAL: %w", err)
+	}
+	if webhookPoll <= 0 {
+		return nil, fmt.Errorf("invalid WEBHOOK_POLL_INTERVAL: must be a positive number of seconds")
+	}
+
+	formRateLimit, err := strconv.Atoi(getEnv("FORM_RATE_LIMIT", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FORM_RATE_LIMIT: %w", err)
+	}
+	if formRateLimit <= 0 {
+		return nil, fmt.Errorf("invalid FORM_RATE_LIMIT: must be a positive number of submissions per minute")
+	}
+
+	var trustedProxies []netip.Prefix
+	for _, proxy := range strings.Split(getEnv("TRUSTED_PROXIES", ""), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if !strings.Contains(proxy, "/") {
+			var addr netip.Addr
+			addr, err = netip.ParseAddr(proxy)
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+
+	inboundPoll, err := strconv.Atoi(getEnv("INBOUND_POLL_INTERVAL", "30"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid INBOUND_POLL_INTERVAL: %w", err)
+	}
+	if inboundPoll <= 0 {
+		return nil, fmt.Errorf("invalid INBOUND_POLL_INTERVAL: must be a positive number of seconds")
+	}
+
+	sequencePoll, err := strconv.Atoi(getEnv("SEQUENCE_POLL_INTERVAL", "60"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SEQUENCE_POLL_INTERVAL: %w", err)
+	}
+	if sequencePoll <= 0 {
+		return nil, fmt.Errorf("invalid SEQUENCE_POLL_INTERVAL: must be a positive number of seconds")
+	}
+
+	trashRetention, err := strconv.Atoi(getEnv("TRASH_RETENTION_DAYS", "30"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRASH_RETENTION_DAYS: %w", err)
+	}
+	if trashRetention <= 0 {
+		return nil, fmt.Errorf("invalid TRASH_RETENTION_DAYS: must be a positive number of days")
+	}
+
+	idempotencyKeyTTL, err := strconv.Atoi(getEnv("IDEMPOTENCY_KEY_TTL_HOURS", "24"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IDEMPOTENCY_KEY_TTL_HOURS: %w", err)
+	}
+	if idempotencyKeyTTL <= 0 {
+		return nil, fmt.Errorf("invalid IDEMPOTENCY_KEY_TTL_HOURS: must be a positive number of hours")
+	}
+
+	driver := getEnv("DB_DRIVER", DriverPostgres)
+	if driver != DriverPostgres && driver != DriverPgxPool && driver != DriverSQLite && driver != DriverMemory {
+		return nil, fmt.Errorf("invalid DB_DRIVER: must be %s, %s, %s or %s", DriverPostgres, DriverPgxPool, DriverSQLite, DriverMemory)
+	}
+
+	maxConns, err := strconv.Atoi(getEnv("DB_MAX_CONNS", "25"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_MAX_CONNS: %w", err)
+	}
+	if maxConns <= 0 {
+		return nil, fmt.Errorf("invalid DB_MAX_CONNS: must be a positive number of connections")
+	}
+
+	minConns, err := strconv.Atoi(getEnv("DB_MIN_CONNS", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_MIN_CONNS: %w", err)
+	}
+	if minConns < 0 || minConns > maxConns {
+		return nil, fmt.Errorf("invalid DB_MIN_CONNS: must be between 0 and DB_MAX_CONNS")
+	}
+
+	maxConnLifetime, err := strconv.Atoi(getEnv("DB_MAX_CONN_LIFETIME", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_MAX_CONN_LIFETIME: %w", err)
+	}
+	if maxConnLifetime <= 0 {
+		return nil, fmt.Errorf("invalid DB_MAX_CONN_LIFETIME: must be a positive number of minutes")
+	}
+
+	maxConnIdleTime, err := strconv.Atoi(getEnv("DB_MAX_CONN_IDLE_TIME", "30"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_MAX_CONN_IDLE_TIME: %w", err)
+	}
+	if maxConnIdleTime <= 0 {
+		return nil, fmt.Errorf("invalid DB_MAX_CONN_IDLE_TIME: must be a positive number of minutes")
+	}
+
+	var replicaDSNs []string
+	for _, dsn := range strings.Split(getEnv("DB_REPLICA_DSNS", ""), ",") {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			replicaDSNs = append(replicaDSNs, dsn)
+		}
+	}
+	if len(replicaDSNs) > 0 && driver != DriverPostgres && driver != DriverPgxPool {
+		return nil, fmt.Errorf("invalid DB_REPLICA_DSNS: replicas need the %s or %s driver", DriverPostgres, DriverPgxPool)
+	}
+
+	replicaCheckInterval, err := strconv.Atoi(getEnv("DB_REPLICA_CHECK_INTERVAL", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_REPLICA_CHECK_INTERVAL: %w", err)
+	}
+	if replicaCheckInterval <= 0 {
+		return nil, fmt.Errorf("invalid DB_REPLICA_CHECK_INTERVAL: must be a positive number of seconds")
+	}
+
+	replicaMaxLag, err := strconv.Atoi(getEnv("DB_REPLICA_MAX_LAG", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_REPLICA_MAX_LAG: %w", err)
+	}
+	if replicaMaxLag <= 0 {
+		return nil, fmt.Errorf("invalid DB_REPLICA_MAX_LAG: must be a positive number of seconds")
+	}
+
+	// a replica can fall behind by up to the max lag plus the time until it
+	// is checked again, the window has to outlast that
+	readYourWritesWindow, err := strconv.Atoi(getEnv("DB_READ_YOUR_WRITES_WINDOW", "15"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_READ_YOUR_WRITES_WINDOW: %w", err)
+	}
+	if readYourWritesWindow < replicaMaxLag+replicaCheckInterval {
+		return nil, fmt.Errorf("invalid DB_READ_YOUR_WRITES_WINDOW: must be at least DB_REPLICA_MAX_LAG plus DB_REPLICA_CHECK_INTERVAL seconds")
+	}
+
+	smtpPort, err := strconv.Atoi(getEnv("SMTP_PORT", "587"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+	}
+
 	return &Config{
 		ServerAddress:      getEnv("SERVER_ADDRESS", ":8080"),
 		ServerReadTimeout:  time.Duration(readTimeout) * time.Second,
 		ServerWriteTimeout: time.Duration(writeTimeout) * time.Second,
 		StaticDir:          getEnv("STATIC_DIR", "/app/web/static"),
 		TemplatesDir:       getEnv("TEMPLATES_DIR", "/app/web/templates"),
+		ScoringInterval:    time.Duration(scoringInterval) * time.Minute,
+		AutomationPoll:     time.Duration(automationPoll) * time.Second,
+		WebhookPoll:        time.Duration(webhookPoll) * time.Second,
+		FormRateLimit:      formRateLimit,
+		TrustedProxies:     trustedProxies,
+		PublicURL:          strings.TrimSuffix(getEnv("PUBLIC_URL", "http://localhost:8080"), "/"),
+		UnsubscribeSecret:  getEnv("UNSUBSCRIBE_SECRET", ""),
+		SMTP: SMTPConfig{
+			Host:     getEnv("SMTP_HOST", ""),
+			Port:     smtpPort,
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+			From:     getEnv("MAIL_FROM", "crm@localhost"),
+		},
+		InboundMaildir:    getEnv("INBOUND_MAILDIR", ""),
+		InboundPoll:       time.Duration(inboundPoll) * time.Second,
+		SequencePoll:      time.Duration(sequencePoll) * time.Second,
+		PrivacyEmail:      getEnv("PRIVACY_EMAIL", ""),
+		TrashRetention:    time.Duration(trashRetention) * 24 * time.Hour,
+		IdempotencyKeyTTL: time.Duration(idempotencyKeyTTL) * time.Hour,
 		DB: DBConfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     dbPort,
-			User:     getEnv("DB_USER", "postgres"),
-			Password: getEnv("DB_PASSWORD", "postgres"),
-			DBName:   getEnv("DB_NAME", "myapp"),
-			SSLMode:  getEnv("DB_SSLMODE", "disable"),
+			Driver:          driver,
+			Host:            getEnv("DB_HOST", "localhost"),
+			Port:            dbPort,
+			User:            getEnv("DB_USER", "postgres"),
+			Password:        getEnv("DB_PASSWORD", "postgres"),
+			DBName:          getEnv("DB_NAME", "myapp"),
+			SSLMode:         getEnv("DB_SSLMODE", "disable"),
+			Path:            getEnv("DB_PATH", "crm.db"),
+			MaxConns:        maxConns,
+			MinConns:        minConns,
+			MaxConnLifetime: time.Duration(maxConnLifetime) * time.Minute,
+			MaxConnIdleTime: time.Duration(maxConnIdleTime) * time.Minute,
+
+			ReplicaDSNs:          replicaDSNs,
+			ReplicaCheckInterval: time.Duration(replicaCheckInterval) * time.Second,
+			ReplicaMaxLag:        time.Duration(replicaMaxLag) * time.Second,
+			ReadYourWritesWindow: time.Duration(readYourWritesWindow) * time.Second,
 		},
 	}, nil
 }
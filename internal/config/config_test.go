@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -55,6 +56,108 @@ func TestLoad(t *testing.T) {
 	if cfg.DB.DBName != "testdb" {
 		t.Errorf("Expected DB.DBName to be 'testdb', got '%s'", cfg.DB.DBName)
 	}
+
+	if cfg.ScoringInterval != time.Hour {
+		t.Errorf("Expected ScoringInterval to default to 1h, got %s", cfg.ScoringInterval)
+	}
+
+	if cfg.DB.Driver != DriverPostgres || cfg.DB.MaxConns != 25 || cfg.DB.MaxConnLifetime != 5*time.Minute {
+		t.Errorf("Expected the database/sql driver with 25 connections living 5m by default, got %+v", cfg.DB)
+	}
+}
+
+func TestLoad_DBPool(t *testing.T) {
+	t.Setenv("DB_DRIVER", DriverPgxPool)
+	t.Setenv("DB_MAX_CONNS", "10")
+	t.Setenv("DB_MIN_CONNS", "2")
+	t.Setenv("DB_MAX_CONN_IDLE_TIME", "1")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.DB.Driver != DriverPgxPool || cfg.DB.MaxConns != 10 || cfg.DB.MinConns != 2 || cfg.DB.MaxConnIdleTime != time.Minute {
+		t.Errorf("Unexpected pool settings: %+v", cfg.DB)
+	}
+
+	t.Setenv("DB_MIN_CONNS", "11")
+	if _, err := Load(); err == nil {
+		t.Error("Expected more min than max connections to be rejected")
+	}
+	t.Setenv("DB_MIN_CONNS", "0")
+	t.Setenv("DB_DRIVER", "mysql")
+	if _, err := Load(); err == nil {
+		t.Error("Expected an unknown driver to be rejected")
+	}
+}
+
+func TestLoad_DBSQLite(t *testing.T) {
+	t.Setenv("DB_DRIVER", DriverSQLite)
+	t.Setenv("DB_PATH", "/var/lib/crm/crm.db")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.DB.Driver != DriverSQLite || cfg.DB.Path != "/var/lib/crm/crm.db" {
+		t.Errorf("Unexpected SQLite settings: %+v", cfg.DB)
+	}
+}
+
+func TestLoad_DBMemory(t *testing.T) {
+	t.Setenv("DB_DRIVER", DriverMemory)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.DB.Driver != DriverMemory {
+		t.Errorf("Expected driver %q, got %q", DriverMemory, cfg.DB.Driver)
+	}
+}
+
+func TestLoad_DBReplicas(t *testing.T) {
+	t.Setenv("DB_REPLICA_DSNS", "host=replica1 dbname=crm, postgres://replica2/crm ,")
+	t.Setenv("DB_REPLICA_MAX_LAG", "20")
+	t.Setenv("DB_READ_YOUR_WRITES_WINDOW", "30")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(cfg.DB.ReplicaDSNs) != 2 || cfg.DB.ReplicaDSNs[0] != "host=replica1 dbname=crm" || cfg.DB.ReplicaDSNs[1] != "postgres://replica2/crm" {
+		t.Errorf("Unexpected replica DSNs: %q", cfg.DB.ReplicaDSNs)
+	}
+	if cfg.DB.ReplicaCheckInterval != 5*time.Second || cfg.DB.ReplicaMaxLag != 20*time.Second || cfg.DB.ReadYourWritesWindow != 30*time.Second {
+		t.Errorf("Unexpected replica settings: %+v", cfg.DB)
+	}
+
+	t.Setenv("DB_READ_YOUR_WRITES_WINDOW", "10")
+	if _, err := Load(); err == nil {
+		t.Error("Expected a window shorter than replicas can lag to be rejected")
+	}
+	t.Setenv("DB_READ_YOUR_WRITES_WINDOW", "30")
+	t.Setenv("DB_DRIVER", DriverSQLite)
+	if _, err := Load(); err == nil {
+		t.Error("Expected replicas of a SQLite database to be rejected")
+	}
+}
+
+func TestLoad_TrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1,")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(cfg.TrustedProxies) != 2 || cfg.TrustedProxies[0].String() != "10.0.0.0/8" || cfg.TrustedProxies[1].String() != "192.0.2.1/32" {
+		t.Errorf("Unexpected trusted proxies: %v", cfg.TrustedProxies)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "proxy.internal")
+	if _, err := Load(); err == nil {
+		t.Error("Expected an error for a proxy that isn't an address")
+	}
 }
 
 func TestDBConfig_DSN(t *testing.T) {
@@ -1,33 +1,1043 @@
 package domain
 
 import (
+	"encoding/json"
 	"time"
 )
 
-// represents a user
+// represents a user. Version goes up with every change to the user and is
+// sent as its ETag, as it is for the other editable records.
 type User struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID             int                 `json:"id"`
+	Name           string              `json:"name"`
+	Email          string              `json:"email"`
+	CustomFields   map[string]any      `json:"custom_fields,omitempty"`
+	Tags           []string            `json:"tags,omitempty"`
+	Score          int                 `json:"score"`
+	ScoreBreakdown []ScoreContribution `json:"score_breakdown,omitempty"`
+	OwnerID        *int                `json:"owner_id,omitempty"`
+	OutOfOffice    bool                `json:"out_of_office"`
+	CreatedAt      time.Time           `json:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at"`
+	Version        int                 `json:"version"`
 }
 
 // CreateUserRequest represents the request to create a new user
 type CreateUserRequest struct {
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	Name         string         `json:"name"`
+	Email        string         `json:"email"`
+	CustomFields map[string]any `json:"custom_fields,omitempty"`
 }
 
 // UserResponse represents the user data returned in API responses
 type UserResponse struct {
+	ID             int                 `json:"id"`
+	Name           string              `json:"name"`
+	Email          string              `json:"email"`
+	CustomFields   map[string]any      `json:"custom_fields,omitempty"`
+	Tags           []string            `json:"tags,omitempty"`
+	Score          int                 `json:"score"`
+	ScoreBreakdown []ScoreContribution `json:"score_breakdown,omitempty"`
+	OwnerID        *int                `json:"owner_id,omitempty"`
+	OutOfOffice    bool                `json:"out_of_office"`
+	CreatedAt      time.Time           `json:"created_at"`
+	Version        int                 `json:"version"`
+}
+
+// ListOptions controls filtering and sorting of list queries
+type ListOptions struct {
+	// Sort is a column name or "cf.<key>" for a custom field, prefixed with "-" for descending order
+	Sort string
+	// CustomFilters matches records whose custom field contains the value
+	CustomFilters map[string]any
+}
+
+// ErrorResponse represents an error response
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// SearchResult represents a single hit returned by the global search
+type SearchResult struct {
+	Type    string  `json:"type"`
+	ID      int     `json:"id"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Rank    float64 `json:"rank"`
+}
+
+// Entities that support custom fields
+const (
+	EntityUser = "user"
+)
+
+// CustomFieldType is the data type of a custom field
+type CustomFieldType string
+
+// Supported custom field types
+const (
+	CustomFieldText        CustomFieldType = "text"
+	CustomFieldNumber      CustomFieldType = "number"
+	CustomFieldDate        CustomFieldType = "date"
+	CustomFieldSelect      CustomFieldType = "select"
+	CustomFieldMultiSelect CustomFieldType = "multi_select"
+	CustomFieldBoolean     CustomFieldType = "boolean"
+	CustomFieldUser        CustomFieldType = "user"
+)
+
+// CustomFieldDefinition represents an admin-defined field on an entity. With
+// TrackHistory every change to the field's value on a record is kept.
+type CustomFieldDefinition struct {
+	ID           int             `json:"id"`
+	Entity       string          `json:"entity"`
+	Key          string          `json:"key"`
+	Label        string          `json:"label"`
+	Type         CustomFieldType `json:"type"`
+	Options      []string        `json:"options,omitempty"`
+	TrackHistory bool            `json:"track_history"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	Version      int             `json:"version"`
+}
+
+// CreateCustomFieldRequest represents the request to define a new custom field
+type CreateCustomFieldRequest struct {
+	Entity       string          `json:"entity"`
+	Key          string          `json:"key"`
+	Label        string          `json:"label"`
+	Type         CustomFieldType `json:"type"`
+	Options      []string        `json:"options,omitempty"`
+	TrackHistory bool            `json:"track_history,omitempty"`
+}
+
+// UpdateCustomFieldRequest represents a partial update to a custom field
+type UpdateCustomFieldRequest struct {
+	Label        *string `json:"label,omitempty"`
+	TrackHistory *bool   `json:"track_history,omitempty"`
+}
+
+// Tag represents an entry in the tag registry
+type Tag struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	UsageCount int       `json:"usage_count"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CreateTagRequest represents the request to register a tag
+type CreateTagRequest struct {
+	Name string `json:"name"`
+}
+
+// BulkTagRequest represents the request to tag or untag many records at once
+type BulkTagRequest struct {
+	Entity string   `json:"entity"`
+	IDs    []int    `json:"ids"`
+	Tags   []string `json:"tags"`
+}
+
+// Segment represents a saved filter expression evaluated on demand
+type Segment struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Entity      string    `json:"entity"`
+	Expression  string    `json:"expression"`
+	MemberCount int       `json:"member_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int       `json:"version"`
+}
+
+// CreateSegmentRequest represents the request to save a new segment
+type CreateSegmentRequest struct {
+	Name       string `json:"name"`
+	Entity     string `json:"entity"`
+	Expression string `json:"expression"`
+}
+
+// ScoringRuleType decides how a scoring rule awards points
+type ScoringRuleType string
+
+// Supported scoring rule types
+const (
+	// ScoringRuleCondition awards the points when the expression matches
+	ScoringRuleCondition ScoringRuleType = "condition"
+	// ScoringRuleRecency awards the points decayed by the record's age
+	ScoringRuleRecency ScoringRuleType = "recency"
+)
+
+// ScoringRule represents an admin-defined rule contributing to a record's score
+type ScoringRule struct {
+	ID           int             `json:"id"`
+	Name         string          `json:"name"`
+	Entity       string          `json:"entity"`
+	Type         ScoringRuleType `json:"type"`
+	Expression   string          `json:"expression,omitempty"`
+	Points       int             `json:"points"`
+	HalfLifeDays int             `json:"half_life_days,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	Version      int             `json:"version"`
+}
+
+// CreateScoringRuleRequest represents the request to define a scoring rule
+type CreateScoringRuleRequest struct {
+	Name         string          `json:"name"`
+	Entity       string          `json:"entity"`
+	Type         ScoringRuleType `json:"type"`
+	Expression   string          `json:"expression,omitempty"`
+	Points       int             `json:"points"`
+	HalfLifeDays int             `json:"half_life_days,omitempty"`
+}
+
+// ScoreContribution is one rule's share of a record's score
+type ScoreContribution struct {
+	RuleID int    `json:"rule_id"`
+	Rule   string `json:"rule"`
+	Points int    `json:"points"`
+}
+
+// AssignmentStrategy decides how an assignment rule picks an owner among its members
+type AssignmentStrategy string
+
+// Supported assignment strategies
+const (
+	// AssignRoundRobin takes turns through the members
+	AssignRoundRobin AssignmentStrategy = "round_robin"
+	// AssignWeighted takes turns, giving each member as many turns as their weight
+	AssignWeighted AssignmentStrategy = "weighted"
+	// AssignLoadBalanced picks the member owning the fewest records
+	AssignLoadBalanced AssignmentStrategy = "load_balanced"
+)
+
+// Reasons recorded on assignments
+const (
+	AssignmentReasonRule       = "rule"
+	AssignmentReasonManual     = "manual"
+	AssignmentReasonAutomation = "automation"
+)
+
+// AssignmentMember is a user that an assignment rule can pick
+type AssignmentMember struct {
+	UserID int `json:"user_id"`
+	Weight int `json:"weight,omitempty"`
+}
+
+// AssignmentRule routes new records matching its expression to one of its members.
+// Rules are tried in position order and the first match with an available member wins.
+type AssignmentRule struct {
+	ID         int                `json:"id"`
+	Name       string             `json:"name"`
+	Entity     string             `json:"entity"`
+	Position   int                `json:"position"`
+	Expression string             `json:"expression,omitempty"`
+	Strategy   AssignmentStrategy `json:"strategy"`
+	Members    []AssignmentMember `json:"members"`
+	CreatedAt  time.Time          `json:"created_at"`
+	UpdatedAt  time.Time          `json:"updated_at"`
+	Version    int                `json:"version"`
+}
+
+// CreateAssignmentRuleRequest represents the request to define an assignment rule
+type CreateAssignmentRuleRequest struct {
+	Name       string             `json:"name"`
+	Entity     string             `json:"entity"`
+	Position   int                `json:"position"`
+	Expression string             `json:"expression,omitempty"`
+	Strategy   AssignmentStrategy `json:"strategy"`
+	Members    []AssignmentMember `json:"members"`
+}
+
+// Assignment records a change of owner on a record
+type Assignment struct {
+	ID              int       `json:"id"`
+	Entity          string    `json:"entity"`
+	EntityID        int       `json:"entity_id"`
+	OwnerID         int       `json:"owner_id"`
+	PreviousOwnerID *int      `json:"previous_owner_id,omitempty"`
+	RuleID          *int      `json:"rule_id,omitempty"`
+	Reason          string    `json:"reason"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ReassignRequest represents the request to move many records to a new owner
+type ReassignRequest struct {
+	Entity  string `json:"entity"`
+	IDs     []int  `json:"ids"`
+	OwnerID int    `json:"owner_id"`
+}
+
+// OutOfOfficeRequest represents the request to change a user's availability
+type OutOfOfficeRequest struct {
+	OutOfOffice bool `json:"out_of_office"`
+}
+
+// UpdateUserRequest represents a partial update to a user. Omitted fields are
+// left alone and a null custom field value clears that field.
+type UpdateUserRequest struct {
+	Name         *string        `json:"name,omitempty"`
+	Email        *string        `json:"email,omitempty"`
+	CustomFields map[string]any `json:"custom_fields,omitempty"`
+}
+
+// AutomationTriggerType decides which events start an automation
+type AutomationTriggerType string
+
+// Supported automation triggers
+const (
+	// TriggerRecordCreated fires when a record is created
+	TriggerRecordCreated AutomationTriggerType = "record_created"
+	// TriggerRecordUpdated fires on every update to a record
+	TriggerRecordUpdated AutomationTriggerType = "record_updated"
+	// TriggerFieldChanged fires when an update changes the trigger's field
+	TriggerFieldChanged AutomationTriggerType = "field_changed"
+	// TriggerDateReached fires once when the trigger's date field plus the offset passes
+	TriggerDateReached AutomationTriggerType = "date_reached"
+)
+
+// AutomationTrigger describes when an automation runs. Field is "name", "email",
+// "created_at" or "cf.<key>".
+type AutomationTrigger struct {
+	Type       AutomationTriggerType `json:"type"`
+	Field      string                `json:"field,omitempty"`
+	OffsetDays int                   `json:"offset_days,omitempty"`
+}
+
+// AutomationActionType is something an automation does to the record
+type AutomationActionType string
+
+// Supported automation actions
+const (
+	ActionUpdateField AutomationActionType = "update_field"
+	ActionAddTag      AutomationActionType = "add_tag"
+	ActionAssignOwner AutomationActionType = "assign_owner"
+	ActionCallWebhook AutomationActionType = "call_webhook"
+)
+
+// AutomationAction is one step of an automation. Only the fields its type uses are set.
+type AutomationAction struct {
+	Type    AutomationActionType `json:"type"`
+	Field   string               `json:"field,omitempty"`
+	Value   any                  `json:"value,omitempty"`
+	Tag     string               `json:"tag,omitempty"`
+	OwnerID int                  `json:"owner_id,omitempty"`
+	URL     string               `json:"url,omitempty"`
+}
+
+// Automation runs its actions on records that hit the trigger and match the condition
+type Automation struct {
+	ID        int                `json:"id"`
+	Name      string             `json:"name"`
+	Entity    string             `json:"entity"`
+	Enabled   bool               `json:"enabled"`
+	Trigger   AutomationTrigger  `json:"trigger"`
+	Condition string             `json:"condition,omitempty"`
+	Actions   []AutomationAction `json:"actions"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+	Version   int                `json:"version"`
+}
+
+// CreateAutomationRequest represents the request to define an automation
+type CreateAutomationRequest struct {
+	Name      string             `json:"name"`
+	Entity    string             `json:"entity"`
+	Enabled   *bool              `json:"enabled,omitempty"`
+	Trigger   AutomationTrigger  `json:"trigger"`
+	Condition string             `json:"condition,omitempty"`
+	Actions   []AutomationAction `json:"actions"`
+}
+
+// Automation run statuses
+const (
+	RunPending   = "pending"
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+	RunSkipped   = "skipped"
+)
+
+// AutomationRun is one execution of an automation against a record
+type AutomationRun struct {
+	ID            int       `json:"id"`
+	AutomationID  int       `json:"automation_id"`
+	Entity        string    `json:"entity"`
+	EntityID      int       `json:"entity_id"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	Depth         int       `json:"depth"`
+	DedupeKey     string    `json:"-"`
+	Log           string    `json:"log"`
+	Error         string    `json:"error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Events published to webhook subscribers
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	// EventAll subscribes to every event
+	EventAll = "*"
+)
+
+// OutboxEvent is a change recorded in the same transaction as the data it describes
+type OutboxEvent struct {
+	ID        int             `json:"id"`
+	Event     string          `json:"event"`
+	Entity    string          `json:"entity"`
+	EntityID  int             `json:"entity_id"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// WebhookSubscription sends the listed events to a URL, signed with the secret
+type WebhookSubscription struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version"`
+}
+
+// CreateWebhookRequest represents the request to subscribe to events.
+// A secret is generated when none is given.
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events"`
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySending   = "sending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one attempt history of sending an event to a subscription
+type WebhookDelivery struct {
+	ID             int             `json:"id"`
+	SubscriptionID int             `json:"subscription_id"`
+	OutboxID       int             `json:"outbox_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseCode   int             `json:"response_code,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// Form is a public web-to-lead form that marketing sites post submissions to
+type Form struct {
+	ID   int    `json:"id"`
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	// Entity is the kind of record submissions create
+	Entity string `json:"entity"`
+	// FieldMappings maps submitted field names to "name", "email" or "cf.<key>"
+	FieldMappings map[string]string `json:"field_mappings"`
+	// AllowedOrigins lists the sites allowed to post from a browser, "*" allows any
+	AllowedOrigins []string `json:"allowed_origins"`
+	// HoneypotField is a hidden field humans leave empty
+	HoneypotField string `json:"honeypot_field,omitempty"`
+	RequireToken  bool   `json:"require_token"`
+	// Secret signs the form tokens
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version"`
+}
+
+// CreateFormRequest represents the request to define a form
+type CreateFormRequest struct {
+	Name           string            `json:"name"`
+	Entity         string            `json:"entity"`
+	FieldMappings  map[string]string `json:"field_mappings"`
+	AllowedOrigins []string          `json:"allowed_origins"`
+	HoneypotField  string            `json:"honeypot_field,omitempty"`
+	RequireToken   bool              `json:"require_token"`
+}
+
+// Form submission outcomes
+const (
+	SubmissionCreated = "created"
+	SubmissionMerged  = "merged"
+	SubmissionSpam    = "spam"
+)
+
+// FormSubmission logs a submission and the record it created or merged into
+type FormSubmission struct {
+	ID       int               `json:"id"`
+	FormID   int               `json:"form_id"`
+	EntityID *int              `json:"entity_id,omitempty"`
+	Status   string            `json:"status"`
+	IP       string            `json:"ip"`
+	Referrer string            `json:"referrer,omitempty"`
+	UTM      map[string]string `json:"utm,omitempty"`
+	Data     map[string]string `json:"data"`
+	// CreatedAt is when the submission was received
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FormInput is a submission as received from a visitor
+type FormInput struct {
+	Fields   map[string]string
+	IP       string
+	Referrer string
+}
+
+// EmailTemplate is a reusable email. Subject and bodies are Go templates
+// with merge fields such as {{.FirstName}} or {{.CustomFields.company}}.
+type EmailTemplate struct {
 	ID        int       `json:"id"`
 	Name      string    `json:"name"`
+	Subject   string    `json:"subject"`
+	BodyText  string    `json:"body_text"`
+	BodyHTML  string    `json:"body_html"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version"`
+}
+
+// CreateEmailTemplateRequest represents the request to save an email template
+type CreateEmailTemplateRequest struct {
+	Name     string `json:"name"`
+	Subject  string `json:"subject"`
+	BodyText string `json:"body_text"`
+	BodyHTML string `json:"body_html"`
+}
+
+// SendEmailRequest represents the request to email a record, either from a
+// template or with the subject and bodies given inline
+type SendEmailRequest struct {
+	TemplateID *int   `json:"template_id,omitempty"`
+	Subject    string `json:"subject,omitempty"`
+	BodyText   string `json:"body_text,omitempty"`
+	BodyHTML   string `json:"body_html,omitempty"`
+}
+
+// Email statuses
+const (
+	EmailSent     = "sent"
+	EmailFailed   = "failed"
+	EmailReceived = "received"
+)
+
+// Email directions
+const (
+	EmailOutbound = "outbound"
+	EmailInbound  = "inbound"
+)
+
+// Email is a message sent to a record
+type Email struct {
+	ID         int    `json:"id"`
+	Entity     string `json:"entity"`
+	EntityID   int    `json:"entity_id"`
+	TemplateID *int   `json:"template_id,omitempty"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Subject    string `json:"subject"`
+	BodyText   string `json:"body_text,omitempty"`
+	BodyHTML   string `json:"body_html,omitempty"`
+	MessageID  string `json:"message_id"`
+	Direction  string `json:"direction"`
+	// InReplyTo is the Message-ID of the email this one replies to
+	InReplyTo string `json:"in_reply_to,omitempty"`
+	// ThreadID is the first email of the thread, empty for the first email itself
+	ThreadID *int   `json:"thread_id,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	// TrackingToken identifies the email in open and click tracking URLs
+	TrackingToken string `json:"-"`
+	// Links are the original targets of the tracked links, by position
+	Links      []string   `json:"links,omitempty"`
+	OpenCount  int        `json:"open_count"`
+	ClickCount int        `json:"click_count"`
+	OpenedAt   *time.Time `json:"opened_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Email tracking event types
+const (
+	EmailEventOpen  = "open"
+	EmailEventClick = "click"
+)
+
+// EmailEvent is an open or click of a tracked email
+type EmailEvent struct {
+	ID        int       `json:"id"`
+	EmailID   int       `json:"email_id"`
+	Type      string    `json:"type"`
+	URL       string    `json:"url,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EmailAttachment is a file received with an inbound email
+type EmailAttachment struct {
+	ID          int       `json:"id"`
+	EmailID     int       `json:"email_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	Data        []byte    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Activity types
+const (
+	ActivityEmailSent     = "email_sent"
+	ActivityEmailReceived = "email_received"
+	ActivityTask          = "task"
+)
+
+// Activity is an entry on a record's timeline
+type Activity struct {
+	ID       int    `json:"id"`
+	Entity   string `json:"entity"`
+	EntityID int    `json:"entity_id"`
+	Type     string `json:"type"`
+	Summary  string `json:"summary"`
+	// EmailID links email activities to the email
+	EmailID   *int      `json:"email_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SequenceStepType is the kind of a sequence step
+type SequenceStepType string
+
+// Sequence step types
+const (
+	StepEmail SequenceStepType = "email"
+	StepWait  SequenceStepType = "wait"
+	StepTask  SequenceStepType = "task"
+)
+
+// SequenceStep is one step of an outreach sequence
+type SequenceStep struct {
+	Type SequenceStepType `json:"type"`
+	// TemplateID is the email template sent by email steps
+	TemplateID int `json:"template_id,omitempty"`
+	// Days is how many business days a wait step pauses for
+	Days int `json:"days,omitempty"`
+	// Task is what a task step asks the record's owner to do
+	Task string `json:"task,omitempty"`
+}
+
+// Sequence is a series of emails, waits and tasks records are enrolled in
+type Sequence struct {
+	ID        int            `json:"id"`
+	Name      string         `json:"name"`
+	Entity    string         `json:"entity"`
+	Steps     []SequenceStep `json:"steps"`
+	Stats     *SequenceStats `json:"stats,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Version   int            `json:"version"`
+}
+
+// CreateSequenceRequest represents the request to create a sequence
+type CreateSequenceRequest struct {
+	Name   string         `json:"name"`
+	Entity string         `json:"entity"`
+	Steps  []SequenceStep `json:"steps"`
+}
+
+// SequenceStats counts a sequence's enrollments by outcome
+type SequenceStats struct {
+	Enrolled     int `json:"enrolled"`
+	Active       int `json:"active"`
+	Completed    int `json:"completed"`
+	Replied      int `json:"replied"`
+	Bounced      int `json:"bounced"`
+	Unsubscribed int `json:"unsubscribed"`
+	Removed      int `json:"removed"`
+	Failed       int `json:"failed"`
+}
+
+// Enrollment statuses. Only active enrollments run.
+const (
+	EnrollmentActive       = "active"
+	EnrollmentCompleted    = "completed"
+	EnrollmentReplied      = "replied"
+	EnrollmentBounced      = "bounced"
+	EnrollmentUnsubscribed = "unsubscribed"
+	EnrollmentRemoved      = "removed"
+	EnrollmentFailed       = "failed"
+)
+
+// SequenceEnrollment tracks a record's progress through a sequence
+type SequenceEnrollment struct {
+	ID         int    `json:"id"`
+	SequenceID int    `json:"sequence_id"`
+	Entity     string `json:"entity"`
+	EntityID   int    `json:"entity_id"`
+	Status     string `json:"status"`
+	// Step is the index of the next step to run
+	Step      int       `json:"step"`
+	Attempts  int       `json:"attempts"`
+	NextRunAt time.Time `json:"next_run_at"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// EnrollmentRequest represents the request to enroll records in, or remove
+// them from, a sequence
+type EnrollmentRequest struct {
+	Entity string `json:"entity"`
+	IDs    []int  `json:"ids"`
+}
+
+// Consent channels
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelPhone = "phone"
+)
+
+// PurposeMarketing is the consent purpose unsubscribe links withdraw
+const PurposeMarketing = "marketing"
+
+// Consent statuses
+const (
+	ConsentGranted   = "granted"
+	ConsentWithdrawn = "withdrawn"
+)
+
+// Lawful bases for processing under GDPR Article 6
+const (
+	BasisConsent            = "consent"
+	BasisContract           = "contract"
+	BasisLegalObligation    = "legal_obligation"
+	BasisVitalInterests     = "vital_interests"
+	BasisPublicTask         = "public_task"
+	BasisLegitimateInterest = "legitimate_interest"
+)
+
+// Consent is one change to a record's permission to be contacted on a
+// channel for a purpose. Consents are never updated, so the history is the
+// audit trail and the latest entry is the current state.
+type Consent struct {
+	ID          int    `json:"id"`
+	Entity      string `json:"entity"`
+	EntityID    int    `json:"entity_id"`
+	Channel     string `json:"channel"`
+	Purpose     string `json:"purpose"`
+	Status      string `json:"status"`
+	LawfulBasis string `json:"lawful_basis"`
+	// Source says where the change came from, e.g. "web_form" or "unsubscribe_link"
+	Source    string    `json:"source"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ConsentRequest represents the request to record a consent change
+type ConsentRequest struct {
+	Entity      string `json:"entity"`
+	EntityID    int    `json:"entity_id"`
+	Channel     string `json:"channel"`
+	Purpose     string `json:"purpose"`
+	Status      string `json:"status"`
+	LawfulBasis string `json:"lawful_basis"`
+	Source      string `json:"source"`
+}
+
+// ConsentSummary holds a record's current consents, one per channel and
+// purpose, and the full history of changes, newest first
+type ConsentSummary struct {
+	Current []*Consent `json:"current"`
+	History []*Consent `json:"history"`
+}
+
+// Suppression reasons
+const (
+	SuppressionUnsubscribed = "unsubscribed"
+	SuppressionBounced      = "bounced"
+	SuppressionComplaint    = "complaint"
+	SuppressionManual       = "manual"
+)
+
+// EmailSuppression is an address no email is sent to
+type EmailSuppression struct {
+	ID        int       `json:"id"`
 	Email     string    `json:"email"`
+	Reason    string    `json:"reason"`
+	Source    string    `json:"source"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
-// ErrorResponse represents an error response
-type ErrorResponse struct {
-	Error string `json:"error"`
+// CreateSuppressionRequest represents the request to suppress an address
+type CreateSuppressionRequest struct {
+	Email  string `json:"email"`
+	Reason string `json:"reason"`
+}
+
+// Privacy request types
+const (
+	PrivacyExport  = "export"
+	PrivacyErasure = "erasure"
+)
+
+// Privacy request statuses
+const (
+	PrivacyPending   = "pending"
+	PrivacyCompleted = "completed"
+)
+
+// PrivacyRequest tracks a data subject's request to get or erase their
+// data until it is fulfilled
+type PrivacyRequest struct {
+	ID       int    `json:"id"`
+	Type     string `json:"type"`
+	Entity   string `json:"entity"`
+	EntityID int    `json:"entity_id"`
+	Status   string `json:"status"`
+	Note     string `json:"note,omitempty"`
+	// DueAt is the legal deadline, one month after the request was received
+	DueAt       time.Time  `json:"due_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	RemindedAt  *time.Time `json:"reminded_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// PrivacyRequestInput represents the request to log a data subject's request
+type PrivacyRequestInput struct {
+	Type     string `json:"type"`
+	Entity   string `json:"entity"`
+	EntityID int    `json:"entity_id"`
+	Note     string `json:"note"`
+}
+
+// PrivacySubject represents the request to export or erase a record's data,
+// fulfilling a logged privacy request when RequestID is set
+type PrivacySubject struct {
+	Entity    string `json:"entity"`
+	EntityID  int    `json:"entity_id"`
+	RequestID *int   `json:"request_id,omitempty"`
+}
+
+// PersonalData is everything stored about a record, as compiled for an export
+type PersonalData struct {
+	Record          *User                 `json:"record"`
+	Emails          []*Email              `json:"emails"`
+	EmailEvents     []*EmailEvent         `json:"email_events"`
+	Attachments     []*EmailAttachment    `json:"attachments"`
+	Activities      []*Activity           `json:"activities"`
+	Consents        []*Consent            `json:"consents"`
+	Enrollments     []*SequenceEnrollment `json:"sequence_enrollments"`
+	FormSubmissions []*FormSubmission     `json:"form_submissions"`
+	Assignments     []*Assignment         `json:"assignments"`
+	AutomationRuns  []*AutomationRun      `json:"automation_runs"`
+	AuditEvents     []*AuditEvent         `json:"audit_events"`
+	FieldHistory    []*FieldChange        `json:"field_history"`
+}
+
+// Audit actions
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+	// AuditErase is a GDPR erasure, logged without a diff
+	AuditErase = "erase"
+	// AuditRestore takes a record back out of the trash
+	AuditRestore = "restore"
+	// AuditPurge deletes a trashed record for good
+	AuditPurge = "purge"
+)
+
+// Kinds of audited data besides entities such as EntityUser
+const (
+	AuditCustomField    = "custom_field"
+	AuditTag            = "tag"
+	AuditSegment        = "segment"
+	AuditScoringRule    = "scoring_rule"
+	AuditAssignmentRule = "assignment_rule"
+	AuditAutomation     = "automation"
+	AuditWebhook        = "webhook"
+	AuditForm           = "form"
+	AuditEmailTemplate  = "email_template"
+	AuditSequence       = "sequence"
+	AuditConsent        = "consent"
+	AuditSuppression    = "suppression"
+	AuditPrivacyRequest = "privacy_request"
+)
+
+// Actor is who makes a change and the request it came in, as recorded in
+// the audit log
+type Actor struct {
+	Name      string `json:"name"`
+	RequestID string `json:"request_id,omitempty"`
+	IP        string `json:"ip,omitempty"`
+}
+
+// AuditChange is the old and new value of a changed field
+type AuditChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// AuditEvent records one change to data. Events are append-only and each
+// one's hash covers the previous event's hash, so editing or removing an
+// event breaks the chain.
+type AuditEvent struct {
+	ID       int    `json:"id"`
+	Actor    string `json:"actor"`
+	Entity   string `json:"entity"`
+	EntityID int    `json:"entity_id"`
+	Action   string `json:"action"`
+	// Changes maps changed fields, nested ones as "custom_fields.company", to
+	// their old and new values
+	Changes map[string]AuditChange `json:"changes"`
+	// ChangesHash is the hash of Changes, it stays when Changes are redacted
+	ChangesHash string `json:"changes_hash"`
+	// Redacted is set when a GDPR erasure cleared Changes
+	Redacted  bool      `json:"redacted,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash"`
+}
+
+// AuditFilter narrows down audit events, zero values match everything
+type AuditFilter struct {
+	Entity    string
+	EntityID  int
+	Actor     string
+	Action    string
+	RequestID string
+	Since     *time.Time
+	Until     *time.Time
+	// AfterID pages through events, which are listed oldest first
+	AfterID int
+	Limit   int
+}
+
+// AuditVerification is the result of checking the audit log's hash chain
+type AuditVerification struct {
+	Valid   bool `json:"valid"`
+	Checked int  `json:"checked"`
+	// BrokenAt is the first event that doesn't match the chain
+	BrokenAt *int `json:"broken_at,omitempty"`
+	// Head is the hash of the last event. Keeping a copy elsewhere also
+	// catches the newest events being removed.
+	Head string `json:"head"`
+}
+
+// FieldChange is one change to a tracked field of a record. Custom fields
+// are named like "custom_fields.budget".
+type FieldChange struct {
+	ID        int       `json:"id"`
+	Entity    string    `json:"entity"`
+	EntityID  int       `json:"entity_id"`
+	Field     string    `json:"field"`
+	From      any       `json:"from"`
+	To        any       `json:"to"`
+	Actor     string    `json:"actor"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// TrashItem is a deleted record that can still be restored until it is
+// purged. Entity is EntityUser or one of the audited kinds, e.g. AuditForm.
+type TrashItem struct {
+	Entity    string    `json:"entity"`
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by"`
+}
+
+// IdempotencyKey is a client's Idempotency-Key and the response its first
+// request got, replayed to retries until ExpiresAt. StatusCode is 0 while the
+// first request is still running. RequestHash tells retries from a different
+// request reusing the key.
+type IdempotencyKey struct {
+	Key             string            `json:"key"`
+	RequestHash     string            `json:"request_hash"`
+	StatusCode      int               `json:"status_code"`
+	ResponseHeaders map[string]string `json:"response_headers"`
+	ResponseBody    []byte            `json:"response_body"`
+	CreatedAt       time.Time         `json:"created_at"`
+	ExpiresAt       time.Time         `json:"expires_at"`
+}
+
+// bulk operations
+const (
+	BulkCreate = "create"
+	BulkUpdate = "update"
+	BulkDelete = "delete"
+)
+
+// BulkRequest is a batch of operations on one kind of record. Atomic
+// operations are applied all or none, otherwise each succeeds or fails alone.
+type BulkRequest struct {
+	Atomic     bool            `json:"atomic"`
+	Operations []BulkOperation `json:"operations"`
+}
+
+// BulkOperation creates a record from Data, or updates or deletes the record
+// with ID. Updates and deletes with a Version only apply while the record is
+// still at that version.
+type BulkOperation struct {
+	Op      string            `json:"op"`
+	ID      int               `json:"id,omitempty"`
+	Version int               `json:"version,omitempty"`
+	Data    UpdateUserRequest `json:"data"`
+}
+
+// BulkResult is the outcome of one operation, Status is the HTTP status the
+// operation would have had on its own
+type BulkResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	ID     int    `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkResponse lists the outcome of every operation in request order
+type BulkResponse struct {
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []BulkResult `json:"results"`
+}
+
+// UserWrite is one write of a batch. Updates and deletes, which move the
+// user to the trash, only apply while the user is at User.Version.
+type UserWrite struct {
+	Op        string
+	User      User
+	DeletedBy string
+}
+
+// What happened to the record of a ChangeEvent
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	// ChangeDeleted is sent when a record is trashed as well as when it is
+	// deleted for good, a restored record is created again
+	ChangeDeleted = "deleted"
+)
+
+// ChangeEvent tells change feed subscribers a record changed. It carries no
+// data, subscribers fetch the record to see what changed.
+type ChangeEvent struct {
+	Entity string    `json:"entity"`
+	ID     int       `json:"id"`
+	Op     string    `json:"op"`
+	At     time.Time `json:"at"`
 }
@@ -0,0 +1,36 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// Capture keeps sent messages in memory instead of delivering them. It is
+// used in tests and when no SMTP server is configured.
+type Capture struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewCapture creates an empty capturing mailer
+func NewCapture() *Capture {
+	return &Capture{}
+}
+
+// Send validates and stores the message
+func (c *Capture) Send(ctx context.Context, msg Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first
+func (c *Capture) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Message(nil), c.messages...)
+}
@@ -0,0 +1,50 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Maildir reads messages delivered to a maildir, such as one filled by the
+// mail server that receives the CRM's BCC address
+type Maildir string
+
+// Pending lists the messages in new/, oldest first by name
+func (d Maildir) Pending() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(string(d), "new"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read maildir: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Open opens a pending message
+func (d Maildir) Open(name string) (*os.File, error) {
+	return os.Open(filepath.Join(string(d), "new", name))
+}
+
+// Done moves a message to cur/ with the seen flag, or the trashed flag when
+// it couldn't be used, so it isn't read again
+func (d Maildir) Done(name string, trashed bool) error {
+	flag := "S"
+	if trashed {
+		flag = "ST"
+	}
+	if err := os.MkdirAll(filepath.Join(string(d), "cur"), 0o700); err != nil {
+		return err
+	}
+	return os.Rename(
+		filepath.Join(string(d), "new", name),
+		filepath.Join(string(d), "cur", name+":2,"+flag),
+	)
+}
@@ -0,0 +1,145 @@
+// Package mailer sends email messages
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Message is an email with a plain text body, an HTML body or both
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+	// Headers holds extra headers such as Message-ID or In-Reply-To
+	Headers map[string]string
+}
+
+// Validate checks the message has addresses and a body
+func (m Message) Validate() error {
+	if _, err := mail.ParseAddress(m.From); err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+	if len(m.To) == 0 {
+		return errors.New("no recipients")
+	}
+	for _, to := range m.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", to, err)
+		}
+	}
+	if m.Text == "" && m.HTML == "" {
+		return errors.New("empty body")
+	}
+	return nil
+}
+
+// Bytes renders the message in RFC 5322 format, as multipart/alternative
+// when it has both a text and an HTML body
+func (m Message) Bytes() ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	headers := map[string]string{
+		"From":         m.From,
+		"To":           strings.Join(m.To, ", "),
+		"Subject":      mime.QEncoding.Encode("utf-8", m.Subject),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"MIME-Version": "1.0",
+	}
+	for key, value := range m.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(key)] = value
+	}
+	// Message-ID is canonicalised to Message-Id above, keep the usual spelling
+	if id, ok := headers["Message-Id"]; ok {
+		delete(headers, "Message-Id")
+		headers["Message-ID"] = id
+	}
+
+	if m.Text != "" && m.HTML != "" {
+		writer := multipart.NewWriter(&buf)
+		headers["Content-Type"] = "multipart/alternative; boundary=" + writer.Boundary()
+		writeHeaders(&buf, headers)
+		if err := writePart(writer, "text/plain; charset=utf-8", m.Text); err != nil {
+			return nil, err
+		}
+		if err := writePart(writer, "text/html; charset=utf-8", m.HTML); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	contentType, body := "text/plain; charset=utf-8", m.Text
+	if m.HTML != "" {
+		contentType, body = "text/html; charset=utf-8", m.HTML
+	}
+	headers["Content-Type"] = contentType
+	headers["Content-Transfer-Encoding"] = "quoted-printable"
+	writeHeaders(&buf, headers)
+	if err := writeQuotedPrintable(&buf, body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeHeaders writes the headers in a stable order followed by the blank line
+func writeHeaders(buf *bytes.Buffer, headers map[string]string) {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		// header values can't span lines, drop anything that would inject one
+		value := strings.NewReplacer("\r", "", "\n", "").Replace(headers[key])
+		fmt.Fprintf(buf, "%s: %s\r\n", key, value)
+	}
+	buf.WriteString("\r\n")
+}
+
+// writePart adds a quoted-printable part to a multipart body
+func writePart(writer *multipart.Writer, contentType, body string) error {
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeQuotedPrintable writes a single part body
+func writeQuotedPrintable(buf *bytes.Buffer, body string) error {
+	qp := quotedprintable.NewWriter(buf)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
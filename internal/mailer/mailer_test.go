@@ -0,0 +1,262 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageBytes(t *testing.T) {
+	msg := Message{
+		From:    "CRM <crm@agency.test>",
+		To:      []string{"ada@example.com"},
+		Subject: "Café plans",
+		Text:    "Hello Ada",
+		HTML:    "<p>Hello Ada</p>",
+		Headers: map[string]string{"Message-ID": "<abc@agency.test>", "X-Injected": "a\r\nBcc: evil@example.com"},
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject")); subject != "Café plans" {
+		t.Errorf("unexpected subject %q", subject)
+	}
+	if parsed.Header.Get("Message-ID") != "<abc@agency.test>" {
+		t.Errorf("unexpected Message-ID %q", parsed.Header.Get("Message-ID"))
+	}
+	if parsed.Header.Get("Bcc") != "" {
+		t.Error("header values must not inject headers")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected content type %q %v", mediaType, err)
+	}
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	var types []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, part.Header.Get("Content-Type"))
+	}
+	if len(types) != 2 || !strings.HasPrefix(types[0], "text/plain") || !strings.HasPrefix(types[1], "text/html") {
+		t.Errorf("unexpected parts %v", types)
+	}
+
+	if _, err := (Message{From: "crm@agency.test", To: []string{"ada@example.com"}}).Bytes(); err == nil {
+		t.Error("expected an error for an empty body")
+	}
+}
+
+// fakeSMTP accepts one message without TLS or auth and returns what it received
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		reply("220 fake ESMTP")
+
+		var transcript strings.Builder
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 fake")
+			case strings.HasPrefix(command, "DATA"):
+				reply("354 go ahead")
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					transcript.WriteString(line)
+				}
+				reply("250 queued")
+			case strings.HasPrefix(command, "QUIT"):
+				reply("221 bye")
+				received <- transcript.String()
+				return
+			default:
+				transcript.WriteString(line)
+				reply("250 ok")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestSMTPSend(t *testing.T) {
+	addr, received := fakeSMTP(t)
+	host, portText, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portText)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := NewSMTP(host, port, "", "").Send(ctx, Message{
+		From:    "CRM <crm@agency.test>",
+		To:      []string{"Ada <ada@example.com>"},
+		Subject: "Hello",
+		Text:    "Hi Ada",
+	})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	transcript := <-received
+	for _, want := range []string{"MAIL FROM:<crm@agency.test>", "RCPT TO:<ada@example.com>", "Subject: Hello", "Hi Ada"} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("expected %q in the SMTP transcript:\n%s", want, transcript)
+		}
+	}
+}
+
+// inboundMessage is a reply with a Latin-1 text body, an HTML body and a PDF attachment
+const inboundMessage = "From: =?UTF-8?Q?Ad=C3=A1?= <Ada@Example.com>\r\n" +
+	"To: Sales <sales@agency.test>, bad address\r\n" +
+	"Cc: grace@example.com\r\n" +
+	"Subject: =?UTF-8?Q?Re:_Caf=C3=A9_plans?=\r\n" +
+	"Message-ID: <reply@example.com>\r\n" +
+	"In-Reply-To: <abc@agency.test>\r\n" +
+	"References: <root@agency.test> <abc@agency.test>\r\n" +
+	"Date: Mon, 05 Oct 2026 10:00:00 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Caf=E9 sounds good\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Café sounds good</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=\"quote.pdf\"\r\n" +
+	"Content-Disposition: attachment; filename=\"quote.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQK\r\n" +
+	"--outer--\r\n"
+
+func TestParse(t *testing.T) {
+	in, err := Parse(strings.NewReader(inboundMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if in.From.Address != "Ada@Example.com" || in.Subject != "Re: Café plans" {
+		t.Errorf("unexpected sender or subject: %v %q", in.From, in.Subject)
+	}
+	if len(in.Cc) != 1 || in.Cc[0].Address != "grace@example.com" {
+		t.Errorf("unexpected Cc: %v", in.Cc)
+	}
+	if in.MessageID != "<reply@example.com>" || in.InReplyTo != "<abc@agency.test>" || len(in.References) != 2 {
+		t.Errorf("unexpected threading headers: %q %q %v", in.MessageID, in.InReplyTo, in.References)
+	}
+	if in.Text != "Café sounds good" || in.HTML != "<p>Café sounds good</p>" {
+		t.Errorf("unexpected bodies: %q %q", in.Text, in.HTML)
+	}
+	if len(in.Attachments) != 1 || in.Attachments[0].Filename != "quote.pdf" || string(in.Attachments[0].Data) != "%PDF-1.4\n" {
+		t.Errorf("unexpected attachments: %+v", in.Attachments)
+	}
+
+	bounce, err := Parse(strings.NewReader("From: MAILER-DAEMON@mx.example.com\r\n" +
+		"Content-Type: multipart/report; report-type=delivery-status; boundary=r\r\n\r\n" +
+		"--r\r\nContent-Type: text/plain\r\n\r\nDelivery failed\r\n" +
+		"--r\r\nContent-Type: message/delivery-status\r\n\r\n" +
+		"Reporting-MTA: dns; mx.example.com\r\n\r\n" +
+		"Final-Recipient: rfc822; Ada@Example.com\r\nAction: failed\r\nStatus: 5.1.1\r\n\r\n" +
+		"Final-Recipient: rfc822; grace@example.com\r\nAction: delayed\r\nStatus: 4.4.1\r\n" +
+		"--r--\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bounce.Bounced) != 1 || bounce.Bounced[0] != "ada@example.com" {
+		t.Errorf("unexpected bounced recipients: %v", bounce.Bounced)
+	}
+
+	if _, err := Parse(strings.NewReader("Subject: no sender\r\n\r\nbody")); err == nil {
+		t.Error("expected an error for a message without a sender")
+	}
+}
+
+func TestMaildir(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"2.host", "1.host", ".hidden"} {
+		if err := os.WriteFile(filepath.Join(dir, "new", name), []byte(inboundMessage), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	maildir := Maildir(dir)
+	pending, err := maildir.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0] != "1.host" {
+		t.Fatalf("unexpected pending messages: %v", pending)
+	}
+	if err := maildir.Done("1.host", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := maildir.Done("2.host", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"1.host:2,S", "2.host:2,ST"} {
+		if _, err := os.Stat(filepath.Join(dir, "cur", name)); err != nil {
+			t.Errorf("expected %s in cur: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxPartDepth stops deeply nested multiparts from recursing forever
+const maxPartDepth = 10
+
+// Inbound is a received message broken into the parts the CRM keeps
+type Inbound struct {
+	MessageID  string
+	InReplyTo  string
+	References []string
+	From       *mail.Address
+	To         []*mail.Address
+	Cc         []*mail.Address
+	Subject    string
+	Date       time.Time
+	// Text and HTML are the first plain text and HTML bodies found
+	Text        string
+	HTML        string
+	Attachments []Attachment
+	// Bounced lists the recipients a delivery status notification reports
+	// as failed
+	Bounced []string
+}
+
+// Attachment is a file carried by a received message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Parse reads an RFC 5322 message, walking its MIME parts for the bodies and
+// attachments. Text is converted to UTF-8 from UTF-8, ASCII or Latin-1.
+func Parse(r io.Reader) (*Inbound, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+
+	var decoder mime.WordDecoder
+	in := &Inbound{
+		MessageID:  strings.TrimSpace(msg.Header.Get("Message-ID")),
+		InReplyTo:  firstMessageID(msg.Header.Get("In-Reply-To")),
+		References: strings.Fields(msg.Header.Get("References")),
+	}
+	if subject, err := decoder.DecodeHeader(msg.Header.Get("Subject")); err == nil {
+		in.Subject = subject
+	} else {
+		in.Subject = msg.Header.Get("Subject")
+	}
+	if from, err := msg.Header.AddressList("From"); err == nil && len(from) > 0 {
+		in.From = from[0]
+	} else {
+		return nil, errors.New("invalid message: no sender")
+	}
+	// a malformed recipient list shouldn't lose the message
+	in.To, _ = msg.Header.AddressList("To")
+	in.Cc, _ = msg.Header.AddressList("Cc")
+	if date, err := msg.Header.Date(); err == nil {
+		in.Date = date
+	}
+
+	if err := in.readPart(textproto.MIMEHeader(msg.Header), msg.Body, 0); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// readPart collects a body or attachment, descending into multiparts
+func (in *Inbound) readPart(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	if depth > maxPartDepth {
+		return errors.New("invalid message: parts nested too deeply")
+	}
+
+	contentType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		contentType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(contentType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("invalid message part: %w", err)
+			}
+			if err := in.readPart(part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("invalid message part: %w", err)
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	isBody := disposition != "attachment" && filename == ""
+	if contentType == "message/delivery-status" {
+		in.Bounced = append(in.Bounced, failedRecipients(data)...)
+	}
+
+	switch {
+	case isBody && contentType == "text/plain" && in.Text == "":
+		in.Text = toUTF8(data, params["charset"])
+	case isBody && contentType == "text/html" && in.HTML == "":
+		in.HTML = toUTF8(data, params["charset"])
+	case !isBody || !strings.HasPrefix(contentType, "text/"):
+		if filename == "" {
+			filename = "attachment"
+			if contentType == "message/rfc822" {
+				filename = "message.eml"
+			}
+		}
+		in.Attachments = append(in.Attachments, Attachment{Filename: filename, ContentType: contentType, Data: data})
+	}
+	return nil
+}
+
+// decodeTransfer undoes a part's Content-Transfer-Encoding. Quoted-printable
+// parts of a multipart are already decoded by the multipart reader.
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// toUTF8 converts text in a charset to UTF-8. Latin-1 maps byte for byte,
+// other charsets are kept when they are valid UTF-8 and replaced otherwise.
+func toUTF8(data []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	return string(bytes.ToValidUTF8(data, []byte("\uFFFD")))
+}
+
+// failedRecipients reads the per-recipient blocks of an RFC 3464 delivery
+// status and returns the addresses whose action is failed
+func failedRecipients(data []byte) []string {
+	var failed []string
+	var recipient, action string
+	flush := func() {
+		if action == "failed" && recipient != "" {
+			failed = append(failed, recipient)
+		}
+		recipient, action = "", ""
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "final-recipient":
+			// the value is typed, as in "rfc822; ada@example.com"
+			if _, address, ok := strings.Cut(value, ";"); ok {
+				recipient = strings.ToLower(strings.Trim(strings.TrimSpace(address), "<>"))
+			}
+		case "action":
+			action = strings.ToLower(strings.TrimSpace(value))
+		}
+	}
+	flush()
+	return failed
+}
+
+// firstMessageID returns the first <id> of a header such as In-Reply-To
+func firstMessageID(value string) string {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
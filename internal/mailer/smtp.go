@@ -0,0 +1,89 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTP delivers messages through an SMTP server, upgrading the connection
+// with STARTTLS when the server offers it
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// NewSMTP creates an SMTP mailer. Authentication is skipped without a username.
+func NewSMTP(host string, port int, username, password string) *SMTP {
+	return &SMTP{Host: host, Port: port, Username: username, Password: password}
+}
+
+// Send delivers the message, honouring the context deadline while connecting and sending
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("sender rejected: %w", err)
+	}
+	for _, to := range msg.To {
+		address, err := mail.ParseAddress(to)
+		if err != nil {
+			return err
+		}
+		if err := client.Rcpt(address.Address); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", address.Address, err)
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return client.Quit()
+}
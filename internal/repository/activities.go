@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreateActivity adds an entry to a record's timeline
+func (r *Repository) CreateActivity(ctx context.Context, activity domain.Activity) (int, error) {
+	query := `
+	INSERT INTO activities (entity, entity_id, type, summary, email_id, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id
+	`
+
+	createdAt := activity.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	var id int
+	err := r.conn(ctx).QueryRowContext(ctx, query,
+		activity.Entity,
+		activity.EntityID,
+		activity.Type,
+		activity.Summary,
+		activity.EmailID,
+		createdAt).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create an activity: %w", err)
+	}
+	return id, nil
+}
+
+// GetActivities lists the latest 100 activities of a record
+func (r *Repository) GetActivities(ctx context.Context, entity string, entityID int) ([]*domain.Activity, error) {
+	query := `
+	SELECT id, entity, entity_id, type, summary, email_id, created_at
+	FROM activities WHERE entity = $1 AND entity_id = $2
+	ORDER BY created_at DESC, id DESC LIMIT 100
+	`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get activities: %w", err)
+	}
+	return scanActivities(rows)
+}
+
+// scanActivities scans and closes activity rows
+func scanActivities(rows *sql.Rows) ([]*domain.Activity, error) {
+	defer rows.Close()
+
+	var activities []*domain.Activity
+	for rows.Next() {
+		var activity domain.Activity
+		var emailID sql.NullInt64
+		if err := rows.Scan(
+			&activity.ID,
+			&activity.Entity,
+			&activity.EntityID,
+			&activity.Type,
+			&activity.Summary,
+			&emailID,
+			&activity.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan activity row: %w", err)
+		}
+		if emailID.Valid {
+			id := int(emailID.Int64)
+			activity.EmailID = &id
+		}
+		activities = append(activities, &activity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over activity rows: %w", err)
+	}
+	return activities, nil
+}
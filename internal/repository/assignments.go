@@ -0,0 +1,302 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreateAssignmentRule stores a new assignment rule
+func (r *Repository) CreateAssignmentRule(ctx context.Context, rule domain.AssignmentRule) (int, error) {
+	members, err := json.Marshal(rule.Members)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode assignment members: %w", err)
+	}
+
+	query := `
+	INSERT INTO assignment_rules (name, entity, position, expression, strategy, members, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id
+	`
+
+	now := time.Now()
+	var id int
+	err = r.conn(ctx).QueryRowContext(ctx, query,
+		rule.Name,
+		rule.Entity,
+		rule.Position,
+		rule.Expression,
+		rule.Strategy,
+		members,
+		now,
+		now).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create an assignment rule: %w", err)
+	}
+	return id, nil
+}
+
+// assignmentRuleColumns lists the columns scanAssignmentRule expects
+const assignmentRuleColumns = `id, name, entity, position, expression, strategy, members, created_at, updated_at, version`
+
+// GetAssignmentRules lists the assignment rules for an entity in the order they are tried
+func (r *Repository) GetAssignmentRules(ctx context.Context, entity string) ([]*domain.AssignmentRule, error) {
+	query := `
+	SELECT ` + assignmentRuleColumns + `
+	FROM assignment_rules
+	WHERE entity = $1 AND deleted_at IS NULL
+	ORDER BY position, id
+	`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, entity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assignment rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []*domain.AssignmentRule
+	for rows.Next() {
+		rule, err := scanAssignmentRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan assignment rule row: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over assignment rule rows: %w", err)
+	}
+	return rules, nil
+}
+
+// GetAssignmentRule retrieves a live assignment rule by ID
+func (r *Repository) GetAssignmentRule(ctx context.Context, id int) (*domain.AssignmentRule, error) {
+	query := `SELECT ` + assignmentRuleColumns + ` FROM assignment_rules WHERE id = $1 AND deleted_at IS NULL`
+
+	rule, err := scanAssignmentRule(r.conn(ctx).QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("assignment rule not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get assignment rule: %w", err)
+	}
+	return rule, nil
+}
+
+// scanAssignmentRule reads a row selected with assignmentRuleColumns
+func scanAssignmentRule(row RowScanner) (*domain.AssignmentRule, error) {
+	var rule domain.AssignmentRule
+	var members []byte
+	if err := row.Scan(
+		&rule.ID,
+		&rule.Name,
+		&rule.Entity,
+		&rule.Position,
+		&rule.Expression,
+		&rule.Strategy,
+		&members,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+		&rule.Version,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(members, &rule.Members); err != nil {
+		return nil, fmt.Errorf("failed to decode assignment members: %w", err)
+	}
+	return &rule, nil
+}
+
+// DeleteAssignmentRule removes an assignment rule. Past assignments keep their rule ID.
+func (r *Repository) DeleteAssignmentRule(ctx context.Context, id int) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM assignment_rules WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete assignment rule: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete assignment rule: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("assignment rule not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// NextAssignmentTurn advances the rule's rotation in a single statement, so
+// concurrent creates never get the same turn
+func (r *Repository) NextAssignmentTurn(ctx context.Context, ruleID int) (int, error) {
+	var turn int
+	err := r.conn(ctx).QueryRowContext(ctx,
+		`UPDATE assignment_rules SET turn = turn + 1 WHERE id = $1 RETURNING turn - 1`,
+		ruleID).Scan(&turn)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("assignment rule not found: %w", ErrNotFound)
+		}
+		return 0, fmt.Errorf("failed to advance assignment rule: %w", err)
+	}
+	return turn, nil
+}
+
+// GetAvailableUsers returns the IDs of the given users that are not out of office
+func (r *Repository) GetAvailableUsers(ctx context.Context, ids []int) ([]int, error) {
+	rows, err := r.conn(ctx).QueryContext(ctx,
+		`SELECT id FROM users WHERE id = ANY($1) AND NOT out_of_office AND deleted_at IS NULL ORDER BY id`, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get available users: %w", err)
+	}
+	defer rows.Close()
+
+	var available []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan available user row: %w", err)
+		}
+		available = append(available, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over available user rows: %w", err)
+	}
+	return available, nil
+}
+
+// CountOwnedRecords counts the records each user owns. Users owning nothing are left out.
+func (r *Repository) CountOwnedRecords(ctx context.Context, entity string, ownerIDs []int) (map[int]int, error) {
+	table, ok := entityTables[entity]
+	if !ok {
+		return nil, fmt.Errorf("failed to count owned records: unknown entity %q", entity)
+	}
+
+	// table comes from entityTables, never from user input
+	query := fmt.Sprintf(`SELECT owner_id, COUNT(*) FROM %s WHERE owner_id = ANY($1) GROUP BY owner_id`, table)
+	rows, err := r.conn(ctx).QueryContext(ctx, query, ownerIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count owned records: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var ownerID, count int
+		if err := rows.Scan(&ownerID, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan owned record count: %w", err)
+		}
+		counts[ownerID] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over owned record counts: %w", err)
+	}
+	return counts, nil
+}
+
+// AssignOwner updates the owner and writes the audit rows in one statement, so
+// an assignment is never logged without happening or the other way round
+func (r *Repository) AssignOwner(ctx context.Context, entity string, ids []int, ownerID int, ruleID *int, reason string) (int, error) {
+	table, ok := entityTables[entity]
+	if !ok {
+		return 0, fmt.Errorf("failed to assign owner: unknown entity %q", entity)
+	}
+
+	// table comes from entityTables, never from user input
+	query := fmt.Sprintf(`
+	WITH previous AS (
+		SELECT id, owner_id FROM %[1]s
+		WHERE id = ANY($1) AND owner_id IS DISTINCT FROM $2
+		FOR UPDATE
+	), changed AS (
+		UPDATE %[1]s e SET owner_id = $2, updated_at = $5, version = e.version + 1
+		FROM previous p
+		WHERE e.id = p.id
+		RETURNING e.id, p.owner_id AS previous_owner_id
+	)
+	INSERT INTO assignments (entity, entity_id, owner_id, previous_owner_id, rule_id, reason, created_at)
+	SELECT $3::text, id, $2, previous_owner_id, $4::integer, $6::text, $5::timestamp FROM changed
+	`, table)
+
+	result, err := r.conn(ctx).ExecContext(ctx, query, ids, ownerID, entity, ruleID, time.Now(), reason)
+	if err != nil {
+		return 0, fmt.Errorf("failed to assign owner: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to assign owner: %w", err)
+	}
+	return int(affected), nil
+}
+
+// GetAssignments lists the assignment history of a record, newest first
+func (r *Repository) GetAssignments(ctx context.Context, entity string, entityID int) ([]*domain.Assignment, error) {
+	query := `
+	SELECT id, entity, entity_id, owner_id, previous_owner_id, rule_id, reason, created_at
+	FROM assignments
+	WHERE entity = $1 AND entity_id = $2
+	ORDER BY id DESC
+	`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assignments: %w", err)
+	}
+	return scanAssignments(rows)
+}
+
+// SetOutOfOffice changes whether a user can be picked by assignment rules
+func (r *Repository) SetOutOfOffice(ctx context.Context, userID int, outOfOffice bool, version int) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `
+	UPDATE users SET out_of_office = $1, updated_at = $2, version = version + 1
+	WHERE id = $3 AND deleted_at IS NULL AND ($4 = 0 OR version = $4)
+	`, outOfOffice, time.Now(), userID, version)
+	if err != nil {
+		return fmt.Errorf("failed to update user availability: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update user availability: %w", err)
+	}
+	if affected == 0 {
+		return notFoundOrConflict(ctx, r.conn(ctx), "users", "user", userID)
+	}
+	return nil
+}
+
+// scanAssignments scans and closes assignment rows
+func scanAssignments(rows *sql.Rows) ([]*domain.Assignment, error) {
+	defer rows.Close()
+
+	var assignments []*domain.Assignment
+	for rows.Next() {
+		var a domain.Assignment
+		var previousOwnerID, ruleID sql.NullInt64
+		if err := rows.Scan(
+			&a.ID,
+			&a.Entity,
+			&a.EntityID,
+			&a.OwnerID,
+			&previousOwnerID,
+			&ruleID,
+			&a.Reason,
+			&a.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan assignment row: %w", err)
+		}
+		if previousOwnerID.Valid {
+			id := int(previousOwnerID.Int64)
+			a.PreviousOwnerID = &id
+		}
+		if ruleID.Valid {
+			id := int(ruleID.Int64)
+			a.RuleID = &id
+		}
+		assignments = append(assignments, &a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over assignment rows: %w", err)
+	}
+	return assignments, nil
+}
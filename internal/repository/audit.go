@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// auditEventColumns lists the columns scanAuditEvents expects
+const auditEventColumns = `id, actor, entity, entity_id, action, changes, changes_hash, redacted, request_id, ip,
+	created_at, prev_hash, hash`
+
+// AuditGenesisHash is the previous hash of the first audit event
+var AuditGenesisHash = strings.Repeat("0", 64)
+
+// AuditChangesHash hashes the changes of an audit event
+func AuditChangesHash(changes map[string]domain.AuditChange) string {
+	if changes == nil {
+		changes = map[string]domain.AuditChange{}
+	}
+	// map keys are marshaled in order, so equal changes hash the same
+	data, err := json.Marshal(changes)
+	if err != nil {
+		data = []byte(err.Error())
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditHash computes the hash of an audit event from its fields, its
+// changes hash and the hash of the event before it
+func AuditHash(event domain.AuditEvent) string {
+	h := sha256.New()
+	for _, field := range []string{
+		event.PrevHash,
+		event.Actor,
+		event.Entity,
+		fmt.Sprint(event.EntityID),
+		event.Action,
+		event.ChangesHash,
+		event.RequestID,
+		event.IP,
+		event.CreatedAt.UTC().Format(time.RFC3339Nano),
+	} {
+		// length prefixes keep field boundaries unambiguous
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AppendAuditEvent chains an event onto the end of the audit log. Appends
+// are serialized with a table lock so every event links to the one before.
+func (r *Repository) AppendAuditEvent(ctx context.Context, event domain.AuditEvent) (int, error) {
+	changes, err := json.Marshal(event.Changes)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal audit changes: %w", err)
+	}
+	if event.Changes == nil {
+		changes = []byte("{}")
+	}
+
+	var id int
+	err = r.inTx(ctx, nil, func(tx DBConnection) error {
+		// conflicts with itself and with writes, not with reads. SQLite
+		// transactions hold the write lock from the start, see NewSQLiteDB.
+		if r.dialect == dialectPostgres {
+			if _, err := tx.ExecContext(ctx, `LOCK TABLE audit_events IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+				return fmt.Errorf("failed to lock audit log: %w", err)
+			}
+		}
+		err := tx.QueryRowContext(ctx, `SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&event.PrevHash)
+		if err == sql.ErrNoRows {
+			event.PrevHash = AuditGenesisHash
+		} else if err != nil {
+			return fmt.Errorf("failed to get last audit event: %w", err)
+		}
+
+		// stored timestamps keep microseconds, hash what will be read back
+		event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		event.ChangesHash = AuditChangesHash(event.Changes)
+		event.Hash = AuditHash(event)
+
+		err = tx.QueryRowContext(ctx, `
+		INSERT INTO audit_events (actor, entity, entity_id, action, changes, changes_hash, request_id, ip, created_at, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id
+		`,
+			event.Actor,
+			event.Entity,
+			event.EntityID,
+			event.Action,
+			changes,
+			event.ChangesHash,
+			event.RequestID,
+			event.IP,
+			event.CreatedAt,
+			event.PrevHash,
+			event.Hash).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("failed to append audit event: %w", err)
+		}
+		return nil
+	})
+	return id, err
+}
+
+// GetAuditEvents lists the audit events matching the filter, oldest first
+func (r *Repository) GetAuditEvents(ctx context.Context, filter domain.AuditFilter) ([]*domain.AuditEvent, error) {
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events
+	WHERE id > $1
+		AND ($2 = '' OR entity = $2)
+		AND ($3 = 0 OR entity_id = $3)
+		AND ($4 = '' OR actor = $4)
+		AND ($5 = '' OR action = $5)
+		AND ($6 = '' OR request_id = $6)
+		AND ($7::timestamp IS NULL OR created_at >= $7)
+		AND ($8::timestamp IS NULL OR created_at < $8)
+	ORDER BY id
+	LIMIT $9`
+
+	rows, err := r.reader(ctx).QueryContext(ctx, query,
+		filter.AfterID,
+		filter.Entity,
+		filter.EntityID,
+		filter.Actor,
+		filter.Action,
+		filter.RequestID,
+		utcOrNil(filter.Since),
+		utcOrNil(filter.Until),
+		filter.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit events: %w", err)
+	}
+	return scanAuditEvents(rows)
+}
+
+// utcOrNil converts an optional time to UTC, which audit timestamps are stored in
+func utcOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// scanAuditEvents scans and closes audit event rows
+func scanAuditEvents(rows *sql.Rows) ([]*domain.AuditEvent, error) {
+	defer rows.Close()
+
+	var events []*domain.AuditEvent
+	for rows.Next() {
+		var event domain.AuditEvent
+		var changes []byte
+		if err := rows.Scan(
+			&event.ID,
+			&event.Actor,
+			&event.Entity,
+			&event.EntityID,
+			&event.Action,
+			&changes,
+			&event.ChangesHash,
+			&event.Redacted,
+			&event.RequestID,
+			&event.IP,
+			&event.CreatedAt,
+			&event.PrevHash,
+			&event.Hash,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event row: %w", err)
+		}
+		if err := json.Unmarshal(changes, &event.Changes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit changes: %w", err)
+		}
+		events = append(events, &event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over audit event rows: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// automationColumns lists the columns scanAutomation expects
+const automationColumns = `id, name, entity, enabled, trigger, condition, actions, created_at, updated_at, version`
+
+// automationRunColumns lists the columns scanAutomationRun expects
+const automationRunColumns = `id, automation_id, entity, entity_id, status, attempts, depth,
+	COALESCE(dedupe_key, ''), log, error, next_attempt_at, created_at, updated_at`
+
+// CreateAutomation stores a new automation
+func (r *Repository) CreateAutomation(ctx context.Context, automation domain.Automation) (int, error) {
+	trigger, err := json.Marshal(automation.Trigger)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode automation trigger: %w", err)
+	}
+	actions, err := json.Marshal(automation.Actions)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode automation actions: %w", err)
+	}
+
+	query := `
+	INSERT INTO automations (name, entity, enabled, trigger, condition, actions, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id
+	`
+
+	now := time.Now()
+	var id int
+	err = r.conn(ctx).QueryRowContext(ctx, query,
+		automation.Name,
+		automation.Entity,
+		automation.Enabled,
+		trigger,
+		automation.Condition,
+		actions,
+		now,
+		now).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create an automation: %w", err)
+	}
+	return id, nil
+}
+
+// GetAutomations lists the automations for an entity
+func (r *Repository) GetAutomations(ctx context.Context, entity string) ([]*domain.Automation, error) {
+	query := `SELECT ` + automationColumns + ` FROM automations WHERE entity = $1 AND deleted_at IS NULL ORDER BY id`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, entity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get automations: %w", err)
+	}
+	defer rows.Close()
+
+	var automations []*domain.Automation
+	for rows.Next() {
+		automation, err := scanAutomation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan automation row: %w", err)
+		}
+		automations = append(automations, automation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over automation rows: %w", err)
+	}
+	return automations, nil
+}
+
+// GetAutomation retrieves an automation by ID
+func (r *Repository) GetAutomation(ctx context.Context, id int) (*domain.Automation, error) {
+	query := `SELECT ` + automationColumns + ` FROM automations WHERE id = $1 AND deleted_at IS NULL`
+
+	automation, err := scanAutomation(r.conn(ctx).QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("automation not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get automation: %w", err)
+	}
+	return automation, nil
+}
+
+// DeleteAutomation removes an automation along with its runs
+func (r *Repository) DeleteAutomation(ctx context.Context, id int) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM automations WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete automation: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete automation: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("automation not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// CreateAutomationRun queues a run, relying on the unique dedupe index so
+// date triggers fire once per record even with several workers scanning
+func (r *Repository) CreateAutomationRun(ctx context.Context, run domain.AutomationRun) (int, bool, error) {
+	query := `
+	INSERT INTO automation_runs (automation_id, entity, entity_id, status, attempts, depth, dedupe_key,
+		log, error, next_attempt_at, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, 0, $5, NULLIF($6, ''), $7, $8, $9, $10, $10)
+	ON CONFLICT DO NOTHING
+	RETURNING id
+	`
+
+	var id int
+	err := r.conn(ctx).QueryRowContext(ctx, query,
+		run.AutomationID,
+		run.Entity,
+		run.EntityID,
+		run.Status,
+		run.Depth,
+		run.DedupeKey,
+		run.Log,
+		run.Error,
+		run.NextAttemptAt,
+		time.Now()).Scan(&id)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("failed to create an automation run: %w", err)
+	}
+	return id, true, nil
+}
+
+// ClaimAutomationRuns picks due runs with SKIP LOCKED so concurrent workers
+// never claim the same run. Runs of a trashed automation wait until it is
+// restored or purged.
+func (r *Repository) ClaimAutomationRuns(ctx context.Context, now time.Time, limit int) ([]*domain.AutomationRun, error) {
+	query := `
+	UPDATE automation_runs SET status = $1, attempts = attempts + 1, updated_at = $2
+	WHERE id IN (
+		SELECT id FROM automation_runs
+		WHERE status = $3 AND next_attempt_at <= $2
+			AND automation_id IN (SELECT id FROM automations WHERE deleted_at IS NULL)
+		ORDER BY next_attempt_at, id
+		LIMIT $4
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ` + automationRunColumns
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, domain.RunRunning, now, domain.RunPending, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim automation runs: %w", err)
+	}
+	return scanAutomationRuns(rows)
+}
+
+// FinishAutomationRun stores the status, log and next attempt of a run
+func (r *Repository) FinishAutomationRun(ctx context.Context, run domain.AutomationRun) error {
+	_, err := r.conn(ctx).ExecContext(ctx, `
+	UPDATE automation_runs SET status = $1, log = $2, error = $3, next_attempt_at = $4, updated_at = $5
+	WHERE id = $6
+	`, run.Status, run.Log, run.Error, run.NextAttemptAt, time.Now(), run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to finish automation run: %w", err)
+	}
+	return nil
+}
+
+// GetAutomationRuns lists the latest 100 runs of an automation
+func (r *Repository) GetAutomationRuns(ctx context.Context, automationID int) ([]*domain.AutomationRun, error) {
+	query := `SELECT ` + automationRunColumns + ` FROM automation_runs WHERE automation_id = $1 ORDER BY id DESC LIMIT 100`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, automationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get automation runs: %w", err)
+	}
+	return scanAutomationRuns(rows)
+}
+
+// scanAutomation scans a row selected with automationColumns
+func scanAutomation(row RowScanner) (*domain.Automation, error) {
+	var automation domain.Automation
+	var trigger, actions []byte
+	if err := row.Scan(
+		&automation.ID,
+		&automation.Name,
+		&automation.Entity,
+		&automation.Enabled,
+		&trigger,
+		&automation.Condition,
+		&actions,
+		&automation.CreatedAt,
+		&automation.UpdatedAt,
+		&automation.Version,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(trigger, &automation.Trigger); err != nil {
+		return nil, fmt.Errorf("failed to decode automation trigger: %w", err)
+	}
+	if err := json.Unmarshal(actions, &automation.Actions); err != nil {
+		return nil, fmt.Errorf("failed to decode automation actions: %w", err)
+	}
+	return &automation, nil
+}
+
+// scanAutomationRuns scans and closes rows selected with automationRunColumns
+func scanAutomationRuns(rows *sql.Rows) ([]*domain.AutomationRun, error) {
+	defer rows.Close()
+
+	var runs []*domain.AutomationRun
+	for rows.Next() {
+		var run domain.AutomationRun
+		if err := rows.Scan(
+			&run.ID,
+			&run.AutomationID,
+			&run.Entity,
+			&run.EntityID,
+			&run.Status,
+			&run.Attempts,
+			&run.Depth,
+			&run.DedupeKey,
+			&run.Log,
+			&run.Error,
+			&run.NextAttemptAt,
+			&run.CreatedAt,
+			&run.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan automation run row: %w", err)
+		}
+		runs = append(runs, &run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over automation run rows: %w", err)
+	}
+	return runs, nil
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// errWriteFailed rolls back the transaction of an atomic batch that had a
+// failed write, the write's own error is returned with the others
+var errWriteFailed = errors.New("a write of an atomic batch failed")
+
+// WriteUsers applies a batch of writes in one transaction. Unless atomic,
+// each write runs in a savepoint so a failed one is rolled back alone.
+func (r *Repository) WriteUsers(ctx context.Context, writes []domain.UserWrite, atomic bool) ([]error, error) {
+	errs := make([]error, len(writes))
+	err := r.inTx(ctx, nil, func(tx DBConnection) error {
+		for i := range writes {
+			if !atomic {
+				if _, err := tx.ExecContext(ctx, `SAVEPOINT user_write`); err != nil {
+					return fmt.Errorf("failed to write users: %w", err)
+				}
+			}
+
+			var err error
+			errs[i] = writeUser(ctx, tx, &writes[i])
+			switch {
+			case errs[i] != nil && atomic:
+				return errWriteFailed
+			case errs[i] != nil:
+				_, err = tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT user_write`)
+			case !atomic:
+				_, err = tx.ExecContext(ctx, `RELEASE SAVEPOINT user_write`)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write users: %w", err)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errWriteFailed) {
+		return errs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// writeUser applies one write in tx, setting the ID of created users
+func writeUser(ctx context.Context, tx DBConnection, write *domain.UserWrite) error {
+	switch write.Op {
+	case domain.BulkCreate:
+		id, err := createUser(ctx, tx, write.User)
+		if err != nil {
+			return err
+		}
+		write.User.ID = id
+		return nil
+	case domain.BulkUpdate:
+		return updateUser(ctx, tx, write.User)
+	case domain.BulkDelete:
+		return trashRow(ctx, tx, domain.EntityUser, write.User.ID, write.User.Version, write.DeletedBy)
+	}
+	return fmt.Errorf("unknown user write %q", write.Op)
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// changeChannel is the channel the triggers of migration 020 notify
+const changeChannel = "crm_changes"
+
+// ListenChanges calls fn with the changes the database notifies, holding one
+// connection of the pool for as long as it listens
+func (r *Repository) ListenChanges(ctx context.Context, fn func(domain.ChangeEvent)) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a connection to listen on: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("listening for changes needs a pgx connection, got %T", driverConn)
+		}
+		pgConn := stdlibConn.Conn()
+		// the connection is left listening, it isn't given back to the pool
+		defer pgConn.Close(context.Background())
+
+		if _, err := pgConn.Exec(ctx, `LISTEN `+changeChannel); err != nil {
+			return fmt.Errorf("failed to listen for changes: %w", err)
+		}
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("failed to wait for changes: %w", err)
+			}
+
+			var change domain.ChangeEvent
+			if err := json.Unmarshal([]byte(notification.Payload), &change); err != nil {
+				// not one of ours, someone else notified the channel
+				continue
+			}
+			fn(change)
+		}
+	})
+}
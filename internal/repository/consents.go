@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreateConsent records a consent change
+func (r *Repository) CreateConsent(ctx context.Context, consent domain.Consent) (int, error) {
+	query := `
+	INSERT INTO consents (entity, entity_id, channel, purpose, status, lawful_basis, source, ip, user_agent, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	RETURNING id
+	`
+
+	var id int
+	err := r.conn(ctx).QueryRowContext(ctx, query,
+		consent.Entity,
+		consent.EntityID,
+		consent.Channel,
+		consent.Purpose,
+		consent.Status,
+		consent.LawfulBasis,
+		consent.Source,
+		consent.IP,
+		consent.UserAgent,
+		time.Now()).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create a consent: %w", err)
+	}
+	return id, nil
+}
+
+// GetConsents lists every consent change of a record, newest first
+func (r *Repository) GetConsents(ctx context.Context, entity string, entityID int) ([]*domain.Consent, error) {
+	query := `
+	SELECT id, entity, entity_id, channel, purpose, status, lawful_basis, source, ip, user_agent, created_at
+	FROM consents WHERE entity = $1 AND entity_id = $2
+	ORDER BY id DESC
+	`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get consents: %w", err)
+	}
+	return scanConsents(rows)
+}
+
+// AddSuppression adds an address to the suppression list. An address that is
+// already suppressed keeps its first reason.
+func (r *Repository) AddSuppression(ctx context.Context, suppression domain.EmailSuppression) error {
+	_, err := r.conn(ctx).ExecContext(ctx, `
+	INSERT INTO email_suppressions (email, reason, source, created_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (email) DO NOTHING
+	`, suppression.Email, suppression.Reason, suppression.Source, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to add suppression: %w", err)
+	}
+	return nil
+}
+
+// GetSuppression retrieves the suppression of an address
+func (r *Repository) GetSuppression(ctx context.Context, email string) (*domain.EmailSuppression, error) {
+	var suppression domain.EmailSuppression
+	err := r.conn(ctx).QueryRowContext(ctx, `
+	SELECT id, email, reason, source, created_at FROM email_suppressions WHERE email = $1
+	`, email).Scan(
+		&suppression.ID,
+		&suppression.Email,
+		&suppression.Reason,
+		&suppression.Source,
+		&suppression.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("suppression not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get suppression: %w", err)
+	}
+	return &suppression, nil
+}
+
+// GetSuppressions lists every suppressed address, newest first
+func (r *Repository) GetSuppressions(ctx context.Context) ([]*domain.EmailSuppression, error) {
+	rows, err := r.conn(ctx).QueryContext(ctx, `
+	SELECT id, email, reason, source, created_at FROM email_suppressions ORDER BY id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get suppressions: %w", err)
+	}
+	defer rows.Close()
+
+	var suppressions []*domain.EmailSuppression
+	for rows.Next() {
+		var suppression domain.EmailSuppression
+		if err := rows.Scan(
+			&suppression.ID,
+			&suppression.Email,
+			&suppression.Reason,
+			&suppression.Source,
+			&suppression.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan suppression row: %w", err)
+		}
+		suppressions = append(suppressions, &suppression)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over suppression rows: %w", err)
+	}
+	return suppressions, nil
+}
+
+// DeleteSuppression removes an address from the suppression list
+func (r *Repository) DeleteSuppression(ctx context.Context, id int) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM email_suppressions WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete suppression: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete suppression: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("suppression not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// LiftSuppression removes an address's suppression when it was suppressed
+// for the given reason, leaving other reasons such as bounces in place
+func (r *Repository) LiftSuppression(ctx context.Context, email, reason string) error {
+	_, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM email_suppressions WHERE email = $1 AND reason = $2`, email, reason)
+	if err != nil {
+		return fmt.Errorf("failed to lift suppression: %w", err)
+	}
+	return nil
+}
+
+// scanConsents scans and closes consent rows
+func scanConsents(rows *sql.Rows) ([]*domain.Consent, error) {
+	defer rows.Close()
+
+	var consents []*domain.Consent
+	for rows.Next() {
+		var consent domain.Consent
+		if err := rows.Scan(
+			&consent.ID,
+			&consent.Entity,
+			&consent.EntityID,
+			&consent.Channel,
+			&consent.Purpose,
+			&consent.Status,
+			&consent.LawfulBasis,
+			&consent.Source,
+			&consent.IP,
+			&consent.UserAgent,
+			&consent.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan consent row: %w", err)
+		}
+		consents = append(consents, &consent)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over consent rows: %w", err)
+	}
+	return consents, nil
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreateCustomField stores a new custom field definition
+func (r *Repository) CreateCustomField(ctx context.Context, def domain.CustomFieldDefinition) (int, error) {
+	query := `
+	INSERT INTO custom_field_definitions (entity, key, label, type, options, track_history, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id
+	`
+
+	options, err := json.Marshal(def.Options)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode custom field options: %w", err)
+	}
+
+	now := time.Now()
+	var id int
+	err = r.conn(ctx).QueryRowContext(ctx, query,
+		def.Entity,
+		def.Key,
+		def.Label,
+		def.Type,
+		options,
+		def.TrackHistory,
+		now,
+		now).Scan(&id)
+
+	if uniqueViolation(err) {
+		return 0, fmt.Errorf("custom field %q: %w", def.Key, ErrDuplicate)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create a custom field: %w", err)
+	}
+
+	return id, nil
+}
+
+// customFieldColumns lists the columns scanCustomField expects
+const customFieldColumns = `id, entity, key, label, type, options, track_history, created_at, updated_at, version`
+
+// GetCustomFields lists the custom field definitions for an entity
+func (r *Repository) GetCustomFields(ctx context.Context, entity string) ([]*domain.CustomFieldDefinition, error) {
+	query := `
+	SELECT ` + customFieldColumns + `
+	FROM custom_field_definitions
+	WHERE entity = $1 AND deleted_at IS NULL
+	ORDER BY id
+	`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, entity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get custom fields: %w", err)
+	}
+	defer rows.Close()
+
+	var defs []*domain.CustomFieldDefinition
+	for rows.Next() {
+		def, err := scanCustomField(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan custom field row: %w", err)
+		}
+		defs = append(defs, def)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over custom field rows: %w", err)
+	}
+	return defs, nil
+}
+
+// GetCustomField retrieves a custom field definition by ID
+func (r *Repository) GetCustomField(ctx context.Context, id int) (*domain.CustomFieldDefinition, error) {
+	row := r.conn(ctx).QueryRowContext(ctx, `SELECT `+customFieldColumns+` FROM custom_field_definitions WHERE id = $1 AND deleted_at IS NULL`, id)
+	def, err := scanCustomField(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("custom field not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get custom field: %w", err)
+	}
+	return def, nil
+}
+
+// UpdateCustomField saves a definition's label and history tracking, its key
+// and type can't change once values are stored
+func (r *Repository) UpdateCustomField(ctx context.Context, def domain.CustomFieldDefinition) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `
+	UPDATE custom_field_definitions SET label = $1, track_history = $2, updated_at = $3, version = version + 1
+	WHERE id = $4 AND deleted_at IS NULL AND ($5 = 0 OR version = $5)
+	`, def.Label, def.TrackHistory, time.Now(), def.ID, def.Version)
+	if err != nil {
+		return fmt.Errorf("failed to update custom field: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update custom field: %w", err)
+	}
+	if affected == 0 {
+		return notFoundOrConflict(ctx, r.conn(ctx), "custom_field_definitions", "custom field", def.ID)
+	}
+	return nil
+}
+
+// DeleteCustomField removes a custom field definition. Values already stored on
+// records are left in place so re-creating the field brings them back.
+func (r *Repository) DeleteCustomField(ctx context.Context, id int) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM custom_field_definitions WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete custom field: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete custom field: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("custom field not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// encodeCustomFields marshals custom field values for the JSONB column
+func encodeCustomFields(fields map[string]any) ([]byte, error) {
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode custom fields: %w", err)
+	}
+	return data, nil
+}
+
+// scanCustomField scans a row selected with customFieldColumns
+func scanCustomField(row RowScanner) (*domain.CustomFieldDefinition, error) {
+	var def domain.CustomFieldDefinition
+	var options []byte
+	if err := row.Scan(
+		&def.ID,
+		&def.Entity,
+		&def.Key,
+		&def.Label,
+		&def.Type,
+		&options,
+		&def.TrackHistory,
+		&def.CreatedAt,
+		&def.UpdatedAt,
+		&def.Version,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(options, &def.Options); err != nil {
+		return nil, fmt.Errorf("failed to decode custom field options: %w", err)
+	}
+	return &def, nil
+}
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dialect is the SQL a Repository's database speaks. Queries are written for
+// Postgres, SQLite runs them through sqliteConn, which rewrites what it
+// doesn't understand.
+type dialect int
+
+const (
+	dialectPostgres dialect = iota
+	dialectSQLite
+)
+
+// conn returns c speaking the dialect
+func (d dialect) conn(c DBConnection) DBConnection {
+	if d == dialectSQLite {
+		return sqliteConn{c}
+	}
+	return c
+}
+
+// jsonContains returns a condition that is true when the JSON value of the
+// custom field named by the key parameter contains the JSON encoded value
+// parameter: the value itself, or an element of an array
+func (d dialect) jsonContains(key, value string) string {
+	if d == dialectSQLite {
+		return fmt.Sprintf(`EXISTS (SELECT 1 FROM json_each(custom_fields, %s) je
+			WHERE je.type = json_type(%s) AND je.value IS json_extract(%s, '$'))`, sqliteJSONPath(key), value, value)
+	}
+	return fmt.Sprintf("custom_fields->%s::text @> %s::jsonb", key, value)
+}
+
+// textEquals returns a condition that is true when the custom field named by
+// the key parameter holds the text value parameter, or is an array holding it
+func (d dialect) textEquals(key, value string) string {
+	if d == dialectSQLite {
+		return fmt.Sprintf(`EXISTS (SELECT 1 FROM json_each(custom_fields, %s) je
+			WHERE CASE je.type WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(je.value AS TEXT) END = %s)`,
+			sqliteJSONPath(key), value)
+	}
+	return fmt.Sprintf("(custom_fields->>%s::text = %s OR custom_fields->%s::text @> to_jsonb(%s::text))", key, value, key, value)
+}
+
+// customField returns the sortable value of the custom field named by the key
+// parameter. SQLite's JSON text would sort numbers as strings, its SQL values
+// don't.
+func (d dialect) customField(key string) string {
+	if d == dialectSQLite {
+		return fmt.Sprintf("json_extract(custom_fields, %s)", sqliteJSONPath(key))
+	}
+	return fmt.Sprintf("custom_fields->%s::text", key)
+}
+
+// sqliteJSONPath returns the JSON path to the object key held by a parameter
+func sqliteJSONPath(key string) string {
+	return fmt.Sprintf(`'$."' || %s || '"'`, key)
+}
+
+// sqliteRewrites turn the Postgres in a query into SQLite, in order. They
+// only see the query outside its string literals and quoted identifiers.
+var sqliteRewrites = []struct {
+	pattern *regexp.Regexp
+	replace string
+}{
+	// SQLite has no casts for parameters to pick a type from
+	{regexp.MustCompile(`::(text\[\]|(text|timestamp|integer|int|jsonb)\b)`), ``},
+	// arrays are passed as JSON, see sqliteArgs
+	{regexp.MustCompile(`= ANY\((\$\d+)\)`), `IN (SELECT value FROM json_each($1))`},
+	// writers take turns on the whole database, there are no row locks
+	{regexp.MustCompile(`\s+FOR UPDATE( SKIP LOCKED)?`), ``},
+	// LIKE ignores case already but has no default escape character
+	{regexp.MustCompile(`ILIKE (\$\d+)`), `LIKE $1 ESCAPE '\'`},
+	{regexp.MustCompile(`jsonb_build_object\(`), `json_object(`},
+	{regexp.MustCompile(`jsonb_build_array\(`), `json_array(`},
+	{regexp.MustCompile(`json_agg\(`), `json_group_array(`},
+}
+
+// sqlitePostgresOnly matches what is left of Postgres in a rewritten query.
+// SQLite would reject most of it, and silently read the JSON operators and
+// casts of other types differently.
+var sqlitePostgresOnly = regexp.MustCompile(`(?i)::\w*|\bILIKE\b|\bANY\s*\(|\bFOR\s+UPDATE\b|\bDISTINCT\s+ON\b|` +
+	`\bjsonb\w*|\bjson_agg\b|\barray_agg\b|\bunnest\b|\bto_jsonb?\b|\bts_\w+|\w*to_tsquery\b|\bto_tsvector\b|` +
+	`\bpg_\w+|\bnextval\b|\bgenerate_series\b|\bnow\s*\(\)|\bINTERVAL\b|@>|<@|->>?|~\*`)
+
+// sqliteQuery rewrites a Postgres query for SQLite. It fails on a query with
+// Postgres left in it after the rewrites, those need a SQLite version in
+// SQLiteRepository.
+func sqliteQuery(query string) (string, error) {
+	var rewritten strings.Builder
+	for i, part := range splitSQLLiterals(query) {
+		// odd parts are literals
+		if i%2 == 0 {
+			for _, rewrite := range sqliteRewrites {
+				part = rewrite.pattern.ReplaceAllString(part, rewrite.replace)
+			}
+			if token := sqlitePostgresOnly.FindString(part); token != "" {
+				return "", fmt.Errorf("query can't run on SQLite, %q is Postgres only: %s", token, strings.TrimSpace(query))
+			}
+		}
+		rewritten.WriteString(part)
+	}
+	return rewritten.String(), nil
+}
+
+// splitSQLLiterals splits a query at its string literals and quoted
+// identifiers, alternating the SQL around them and the quoted text, quotes
+// included. Quotes are escaped by doubling them, as in both dialects.
+func splitSQLLiterals(query string) []string {
+	var parts []string
+	start := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote == 0 && (c == '\'' || c == '"'):
+			parts = append(parts, query[start:i])
+			start, quote = i, c
+		case quote != 0 && c == quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			parts = append(parts, query[start:i+1])
+			start, quote = i+1, 0
+		}
+	}
+	// an unterminated literal is left for SQLite to reject
+	if quote != 0 {
+		parts = append(parts, query[start:], "")
+		return parts
+	}
+	return append(parts, query[start:])
+}
+
+// sqliteTimeFormat is how times are stored in SQLite: UTC with a fixed number
+// of digits, so they compare as text like Postgres compares timestamps
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000"
+
+// sqliteArgs converts query arguments to what SQLite stores: arrays become
+// JSON, JSON documents become text rather than blobs and times become
+// sqliteTimeFormat text
+func sqliteArgs(args []any) []any {
+	converted := make([]any, len(args))
+	for i, arg := range args {
+		// a failing Valuer is left for the driver to report
+		if valuer, ok := arg.(driver.Valuer); ok {
+			if value, err := valuer.Value(); err == nil {
+				arg = value
+			}
+		}
+
+		switch v := arg.(type) {
+		case []int, []int64, []string:
+			// slices of numbers and strings always encode
+			encoded, _ := json.Marshal(v)
+			arg = string(encoded)
+		case []byte:
+			if json.Valid(v) {
+				arg = string(v)
+			}
+		case time.Time:
+			arg = v.UTC().Format(sqliteTimeFormat)
+		case *time.Time:
+			if v == nil {
+				arg = nil
+			} else {
+				arg = v.UTC().Format(sqliteTimeFormat)
+			}
+		}
+		converted[i] = arg
+	}
+	return converted
+}
+
+// sqliteConn runs Postgres queries on a SQLite connection or transaction. A
+// query sqliteQuery can't rewrite is never run, its error is returned instead.
+type sqliteConn struct {
+	c DBConnection
+}
+
+// failingArg is a query argument that fails to convert with err. It gets an
+// error into a sql.Row, which only database/sql can build, before the query
+// it comes with reaches the driver.
+type failingArg struct {
+	err error
+}
+
+func (a failingArg) Value() (driver.Value, error) {
+	return nil, a.err
+}
+
+func (s sqliteConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	rewritten, err := sqliteQuery(query)
+	if err != nil {
+		return s.c.QueryRowContext(ctx, `SELECT $1`, failingArg{err})
+	}
+	return s.c.QueryRowContext(ctx, rewritten, sqliteArgs(args)...)
+}
+
+func (s sqliteConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	rewritten, err := sqliteQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.c.QueryContext(ctx, rewritten, sqliteArgs(args)...)
+}
+
+func (s sqliteConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	rewritten, err := sqliteQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.c.ExecContext(ctx, rewritten, sqliteArgs(args)...)
+}
@@ -0,0 +1,397 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// emailColumns lists the columns scanEmail expects
+const emailColumns = `id, entity, entity_id, template_id, from_address, to_address, subject, body_text,
+	body_html, message_id, direction, in_reply_to, thread_id, status, error, tracking_token, links,
+	open_count, click_count, opened_at, created_at`
+
+// CreateEmailTemplate stores a new email template
+func (r *Repository) CreateEmailTemplate(ctx context.Context, template domain.EmailTemplate) (int, error) {
+	query := `
+	INSERT INTO email_templates (name, subject, body_text, body_html, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id
+	`
+
+	now := time.Now()
+	var id int
+	err := r.conn(ctx).QueryRowContext(ctx, query,
+		template.Name,
+		template.Subject,
+		template.BodyText,
+		template.BodyHTML,
+		now,
+		now).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create an email template: %w", err)
+	}
+	return id, nil
+}
+
+// GetEmailTemplates lists every email template
+func (r *Repository) GetEmailTemplates(ctx context.Context) ([]*domain.EmailTemplate, error) {
+	rows, err := r.conn(ctx).QueryContext(ctx, `
+	SELECT id, name, subject, body_text, body_html, created_at, updated_at, version
+	FROM email_templates WHERE deleted_at IS NULL ORDER BY name, id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email templates: %w", err)
+	}
+	defer rows.Close()
+
+	var templates []*domain.EmailTemplate
+	for rows.Next() {
+		template, err := scanEmailTemplate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan email template row: %w", err)
+		}
+		templates = append(templates, template)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over email template rows: %w", err)
+	}
+	return templates, nil
+}
+
+// GetEmailTemplate retrieves an email template by ID
+func (r *Repository) GetEmailTemplate(ctx context.Context, id int) (*domain.EmailTemplate, error) {
+	template, err := scanEmailTemplate(r.conn(ctx).QueryRowContext(ctx, `
+	SELECT id, name, subject, body_text, body_html, created_at, updated_at, version
+	FROM email_templates WHERE id = $1 AND deleted_at IS NULL
+	`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("email template not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get email template: %w", err)
+	}
+	return template, nil
+}
+
+// DeleteEmailTemplate removes an email template, emails sent from it keep their content
+func (r *Repository) DeleteEmailTemplate(ctx context.Context, id int) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM email_templates WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete email template: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete email template: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("email template not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// CreateEmail stores an email sent to a record
+func (r *Repository) CreateEmail(ctx context.Context, email domain.Email) (int, error) {
+	return insertEmail(ctx, r.conn(ctx), email)
+}
+
+// CreateInboundEmail stores a received email with its attachments and the
+// timeline entries of the records it was matched to, in one transaction
+func (r *Repository) CreateInboundEmail(ctx context.Context, email domain.Email, attachments []domain.EmailAttachment, activities []domain.Activity) (int, error) {
+	var id int
+	err := r.inTx(ctx, nil, func(tx DBConnection) error {
+		var err error
+		id, err = insertEmail(ctx, tx, email)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, attachment := range attachments {
+			if _, err := tx.ExecContext(ctx, `
+			INSERT INTO email_attachments (email_id, filename, content_type, size, data, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			`, id, attachment.Filename, attachment.ContentType, len(attachment.Data), attachment.Data, now); err != nil {
+				return fmt.Errorf("failed to store an email attachment: %w", err)
+			}
+		}
+		for _, activity := range activities {
+			if _, err := tx.ExecContext(ctx, `
+			INSERT INTO activities (entity, entity_id, type, summary, email_id, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			`, activity.Entity, activity.EntityID, activity.Type, activity.Summary, id, now); err != nil {
+				return fmt.Errorf("failed to create an activity: %w", err)
+			}
+		}
+		return nil
+	})
+	return id, err
+}
+
+// insertEmail adds an email row on q. Emails without a
+// tracking token store NULL so the unique index ignores them.
+func insertEmail(ctx context.Context, q execQuerier, email domain.Email) (int, error) {
+	links, err := json.Marshal(email.Links)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode email links: %w", err)
+	}
+	direction := email.Direction
+	if direction == "" {
+		direction = domain.EmailOutbound
+	}
+	token := sql.NullString{String: email.TrackingToken, Valid: email.TrackingToken != ""}
+
+	query := `
+	INSERT INTO emails (entity, entity_id, template_id, from_address, to_address, subject, body_text,
+		body_html, message_id, direction, in_reply_to, thread_id, status, error, tracking_token, links, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+	RETURNING id
+	`
+
+	var id int
+	err = q.QueryRowContext(ctx, query,
+		email.Entity,
+		email.EntityID,
+		email.TemplateID,
+		email.From,
+		email.To,
+		email.Subject,
+		email.BodyText,
+		email.BodyHTML,
+		email.MessageID,
+		direction,
+		email.InReplyTo,
+		email.ThreadID,
+		email.Status,
+		email.Error,
+		token,
+		links,
+		time.Now()).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create an email: %w", err)
+	}
+	return id, nil
+}
+
+// GetEmails lists the latest 100 emails of a record
+func (r *Repository) GetEmails(ctx context.Context, entity string, entityID int) ([]*domain.Email, error) {
+	query := `SELECT ` + emailColumns + ` FROM emails WHERE entity = $1 AND entity_id = $2 ORDER BY id DESC LIMIT 100`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get emails: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []*domain.Email
+	for rows.Next() {
+		email, err := scanEmail(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan email row: %w", err)
+		}
+		emails = append(emails, email)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over email rows: %w", err)
+	}
+	return emails, nil
+}
+
+// GetEmail retrieves an email by ID
+func (r *Repository) GetEmail(ctx context.Context, id int) (*domain.Email, error) {
+	query := `SELECT ` + emailColumns + ` FROM emails WHERE id = $1`
+
+	email, err := scanEmail(r.conn(ctx).QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("email not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get email: %w", err)
+	}
+	return email, nil
+}
+
+// GetEmailByMessageID retrieves the first email stored with a Message-ID
+func (r *Repository) GetEmailByMessageID(ctx context.Context, messageID string) (*domain.Email, error) {
+	query := `SELECT ` + emailColumns + ` FROM emails WHERE message_id = $1 ORDER BY id LIMIT 1`
+
+	email, err := scanEmail(r.conn(ctx).QueryRowContext(ctx, query, messageID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("email not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get email: %w", err)
+	}
+	return email, nil
+}
+
+// GetEmailAttachments lists an email's attachments without their content
+func (r *Repository) GetEmailAttachments(ctx context.Context, emailID int) ([]*domain.EmailAttachment, error) {
+	rows, err := r.conn(ctx).QueryContext(ctx, `
+	SELECT id, email_id, filename, content_type, size, created_at
+	FROM email_attachments WHERE email_id = $1 ORDER BY id
+	`, emailID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []*domain.EmailAttachment
+	for rows.Next() {
+		var attachment domain.EmailAttachment
+		if err := rows.Scan(
+			&attachment.ID,
+			&attachment.EmailID,
+			&attachment.Filename,
+			&attachment.ContentType,
+			&attachment.Size,
+			&attachment.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan email attachment row: %w", err)
+		}
+		attachments = append(attachments, &attachment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over email attachment rows: %w", err)
+	}
+	return attachments, nil
+}
+
+// GetEmailAttachment retrieves an attachment of an email with its content
+func (r *Repository) GetEmailAttachment(ctx context.Context, emailID, id int) (*domain.EmailAttachment, error) {
+	var attachment domain.EmailAttachment
+	err := r.conn(ctx).QueryRowContext(ctx, `
+	SELECT id, email_id, filename, content_type, size, data, created_at
+	FROM email_attachments WHERE email_id = $1 AND id = $2
+	`, emailID, id).Scan(
+		&attachment.ID,
+		&attachment.EmailID,
+		&attachment.Filename,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.Data,
+		&attachment.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("email attachment not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get email attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+// GetEmailByToken retrieves an email by its tracking token
+func (r *Repository) GetEmailByToken(ctx context.Context, token string) (*domain.Email, error) {
+	query := `SELECT ` + emailColumns + ` FROM emails WHERE tracking_token = $1`
+
+	email, err := scanEmail(r.conn(ctx).QueryRowContext(ctx, query, token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("email not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get email: %w", err)
+	}
+	return email, nil
+}
+
+// RecordEmailEvent logs an open or click and bumps the matching counter in one statement
+func (r *Repository) RecordEmailEvent(ctx context.Context, event domain.EmailEvent) error {
+	query := `
+	WITH logged AS (
+		INSERT INTO email_events (email_id, type, url, ip, user_agent, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	)
+	UPDATE emails SET
+		open_count = open_count + CASE WHEN $2 = 'open' THEN 1 ELSE 0 END,
+		click_count = click_count + CASE WHEN $2 = 'click' THEN 1 ELSE 0 END,
+		opened_at = CASE WHEN $2 = 'open' THEN COALESCE(opened_at, $6) ELSE opened_at END
+	WHERE id = $1
+	`
+
+	_, err := r.conn(ctx).ExecContext(ctx, query,
+		event.EmailID,
+		event.Type,
+		event.URL,
+		event.IP,
+		event.UserAgent,
+		time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record email event: %w", err)
+	}
+	return nil
+}
+
+// scanEmailTemplate scans an email template row
+func scanEmailTemplate(row RowScanner) (*domain.EmailTemplate, error) {
+	var template domain.EmailTemplate
+	if err := row.Scan(
+		&template.ID,
+		&template.Name,
+		&template.Subject,
+		&template.BodyText,
+		&template.BodyHTML,
+		&template.CreatedAt,
+		&template.UpdatedAt,
+		&template.Version,
+	); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// scanEmail scans a row selected with emailColumns
+func scanEmail(row RowScanner) (*domain.Email, error) {
+	var email domain.Email
+	var templateID, threadID sql.NullInt64
+	var token sql.NullString
+	var links []byte
+	var openedAt sql.NullTime
+	if err := row.Scan(
+		&email.ID,
+		&email.Entity,
+		&email.EntityID,
+		&templateID,
+		&email.From,
+		&email.To,
+		&email.Subject,
+		&email.BodyText,
+		&email.BodyHTML,
+		&email.MessageID,
+		&email.Direction,
+		&email.InReplyTo,
+		&threadID,
+		&email.Status,
+		&email.Error,
+		&token,
+		&links,
+		&email.OpenCount,
+		&email.ClickCount,
+		&openedAt,
+		&email.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if templateID.Valid {
+		id := int(templateID.Int64)
+		email.TemplateID = &id
+	}
+	if threadID.Valid {
+		id := int(threadID.Int64)
+		email.ThreadID = &id
+	}
+	email.TrackingToken = token.String
+	if openedAt.Valid {
+		email.OpenedAt = &openedAt.Time
+	}
+	if err := json.Unmarshal(links, &email.Links); err != nil {
+		return nil, fmt.Errorf("failed to decode email links: %w", err)
+	}
+	return &email, nil
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// fieldChangeColumns lists the columns scanFieldChanges expects
+const fieldChangeColumns = `id, entity, entity_id, field, old_value, new_value, actor, changed_at`
+
+// RecordFieldChanges stores changes to tracked fields, all at the same time.
+// Like audit events they are timestamped in UTC.
+func (r *Repository) RecordFieldChanges(ctx context.Context, changes []domain.FieldChange) error {
+	return r.inTx(ctx, nil, func(tx DBConnection) error {
+		now := time.Now().UTC()
+		for _, change := range changes {
+			from, err := encodeFieldValue(change.From)
+			if err != nil {
+				return err
+			}
+			to, err := encodeFieldValue(change.To)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `
+			INSERT INTO field_history (entity, entity_id, field, old_value, new_value, actor, changed_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			`, change.Entity, change.EntityID, change.Field, from, to, change.Actor, now); err != nil {
+				return fmt.Errorf("failed to record field change: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// GetFieldHistory lists the changes to a record's tracked fields made after
+// since, or all of them when since is nil, newest first
+func (r *Repository) GetFieldHistory(ctx context.Context, entity string, entityID int, since *time.Time) ([]*domain.FieldChange, error) {
+	rows, err := r.reader(ctx).QueryContext(ctx, `
+	SELECT `+fieldChangeColumns+` FROM field_history
+	WHERE entity = $1 AND entity_id = $2 AND ($3::timestamp IS NULL OR changed_at > $3)
+	ORDER BY changed_at DESC, id DESC
+	`, entity, entityID, utcOrNil(since))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get field history: %w", err)
+	}
+	return scanFieldChanges(rows)
+}
+
+// encodeFieldValue marshals a field value for a JSONB column, unset values are NULL
+func encodeFieldValue(value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode field value: %w", err)
+	}
+	return data, nil
+}
+
+// scanFieldChanges scans and closes field history rows
+func scanFieldChanges(rows *sql.Rows) ([]*domain.FieldChange, error) {
+	defer rows.Close()
+
+	var changes []*domain.FieldChange
+	for rows.Next() {
+		var change domain.FieldChange
+		var from, to []byte
+		if err := rows.Scan(
+			&change.ID,
+			&change.Entity,
+			&change.EntityID,
+			&change.Field,
+			&from,
+			&to,
+			&change.Actor,
+			&change.ChangedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan field history row: %w", err)
+		}
+		for _, value := range []struct {
+			data []byte
+			dest *any
+		}{{from, &change.From}, {to, &change.To}} {
+			if value.data == nil {
+				continue
+			}
+			if err := json.Unmarshal(value.data, value.dest); err != nil {
+				return nil, fmt.Errorf("failed to decode field value: %w", err)
+			}
+		}
+		changes = append(changes, &change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over field history rows: %w", err)
+	}
+	return changes, nil
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// fixtureUsers are the sample users SeedFixtures creates, the first two being
+// the ones the Postgres schema migration inserts
+var fixtureUsers = []domain.User{
+	{Name: "John Doe", Email: "john@example.com", CustomFields: map[string]any{
+		"company": "Doe Consulting", "industry": "Consulting", "budget": 12000, "newsletter": true,
+	}},
+	{Name: "Jane Smith", Email: "jane@example.com", CustomFields: map[string]any{
+		"company": "Smith & Co", "industry": "Technology", "budget": 45000, "newsletter": false,
+	}},
+	{Name: "Ada Lovelace", Email: "ada@example.com", CustomFields: map[string]any{
+		"company": "Analytical Engines", "industry": "Technology", "budget": 80000, "newsletter": true,
+	}},
+	{Name: "Grace Hopper", Email: "grace@example.com", CustomFields: map[string]any{
+		"company": "Compiler Works", "industry": "Technology", "budget": 30000,
+	}},
+	{Name: "Alan Turing", Email: "alan@example.com", CustomFields: map[string]any{
+		"company": "Bletchley Partners", "industry": "Finance", "budget": 5000, "newsletter": true,
+	}},
+}
+
+// fixtureTags tag the users of fixtureUsers, by index
+var fixtureTags = map[string][]int{
+	"vip":     {1, 2},
+	"webinar": {0, 2, 4},
+}
+
+// SeedFixtures fills a store with sample users, custom fields, tags, a
+// segment, a scoring rule and an email template, so the app has something to
+// show when it runs on the in-memory store
+func SeedFixtures(ctx context.Context, store Store) error {
+	return store.InTx(ctx, func(ctx context.Context) error {
+		defs := []domain.CustomFieldDefinition{
+			{Entity: domain.EntityUser, Key: "company", Label: "Company", Type: domain.CustomFieldText},
+			{Entity: domain.EntityUser, Key: "industry", Label: "Industry", Type: domain.CustomFieldSelect,
+				Options: []string{"Consulting", "Finance", "Technology"}},
+			{Entity: domain.EntityUser, Key: "budget", Label: "Budget", Type: domain.CustomFieldNumber, TrackHistory: true},
+			{Entity: domain.EntityUser, Key: "newsletter", Label: "Newsletter", Type: domain.CustomFieldBoolean},
+		}
+		for _, def := range defs {
+			if _, err := store.CreateCustomField(ctx, def); err != nil {
+				return fmt.Errorf("failed to seed custom field %s: %w", def.Key, err)
+			}
+		}
+
+		ids := make([]int, len(fixtureUsers))
+		for i, user := range fixtureUsers {
+			id, err := store.CreateUser(ctx, user)
+			if err != nil {
+				return fmt.Errorf("failed to seed user %s: %w", user.Email, err)
+			}
+			ids[i] = id
+		}
+		if _, err := store.AssignOwner(ctx, domain.EntityUser, ids[2:], ids[0], nil, domain.AssignmentReasonManual); err != nil {
+			return fmt.Errorf("failed to seed owners: %w", err)
+		}
+
+		for tag, users := range fixtureTags {
+			tagged := make([]int, len(users))
+			for i, user := range users {
+				tagged[i] = ids[user]
+			}
+			if _, err := store.AddTags(ctx, domain.EntityUser, tagged, []string{tag}); err != nil {
+				return fmt.Errorf("failed to seed tag %s: %w", tag, err)
+			}
+		}
+
+		if _, err := store.CreateSegment(ctx, domain.Segment{
+			Name: "Tech VIPs", Entity: domain.EntityUser, Expression: "tag:vip AND cf.industry:Technology",
+		}); err != nil {
+			return fmt.Errorf("failed to seed segment: %w", err)
+		}
+		if _, err := store.CreateScoringRule(ctx, domain.ScoringRule{
+			Name: "Webinar attendee", Entity: domain.EntityUser, Type: domain.ScoringRuleCondition,
+			Expression: "tag:webinar", Points: 10,
+		}); err != nil {
+			return fmt.Errorf("failed to seed scoring rule: %w", err)
+		}
+		if _, err := store.CreateEmailTemplate(ctx, domain.EmailTemplate{
+			Name:     "Welcome",
+			Subject:  "Welcome, {{.FirstName}}",
+			BodyText: "Hi {{.FirstName}},\n\nThanks for getting in touch.",
+		}); err != nil {
+			return fmt.Errorf("failed to seed email template: %w", err)
+		}
+		return nil
+	})
+}
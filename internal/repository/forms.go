@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// formColumns lists the columns scanForm expects
+const formColumns = `id, key, name, entity, field_mappings, allowed_origins, honeypot_field,
+	require_token, secret, created_at, updated_at, version`
+
+// CreateForm stores a new form
+func (r *Repository) CreateForm(ctx context.Context, form domain.Form) (int, error) {
+	mappings, err := json.Marshal(form.FieldMappings)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode form field mappings: %w", err)
+	}
+	origins, err := json.Marshal(form.AllowedOrigins)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode form origins: %w", err)
+	}
+
+	query := `
+	INSERT INTO forms (key, name, entity, field_mappings, allowed_origins, honeypot_field,
+		require_token, secret, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	RETURNING id
+	`
+
+	now := time.Now()
+	var id int
+	err = r.conn(ctx).QueryRowContext(ctx, query,
+		form.Key,
+		form.Name,
+		form.Entity,
+		mappings,
+		origins,
+		form.HoneypotField,
+		form.RequireToken,
+		form.Secret,
+		now,
+		now).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create a form: %w", err)
+	}
+	return id, nil
+}
+
+// GetForms lists every form
+func (r *Repository) GetForms(ctx context.Context) ([]*domain.Form, error) {
+	rows, err := r.conn(ctx).QueryContext(ctx, `SELECT `+formColumns+` FROM forms WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get forms: %w", err)
+	}
+	defer rows.Close()
+
+	var forms []*domain.Form
+	for rows.Next() {
+		form, err := scanForm(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan form row: %w", err)
+		}
+		forms = append(forms, form)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over form rows: %w", err)
+	}
+	return forms, nil
+}
+
+// GetForm retrieves a form by ID
+func (r *Repository) GetForm(ctx context.Context, id int) (*domain.Form, error) {
+	return r.getForm(ctx, `SELECT `+formColumns+` FROM forms WHERE id = $1 AND deleted_at IS NULL`, id)
+}
+
+// GetFormByKey retrieves a form by its public key
+func (r *Repository) GetFormByKey(ctx context.Context, key string) (*domain.Form, error) {
+	return r.getForm(ctx, `SELECT `+formColumns+` FROM forms WHERE key = $1 AND deleted_at IS NULL`, key)
+}
+
+// getForm runs a query selecting a single form
+func (r *Repository) getForm(ctx context.Context, query string, arg any) (*domain.Form, error) {
+	form, err := scanForm(r.conn(ctx).QueryRowContext(ctx, query, arg))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("form not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get form: %w", err)
+	}
+	return form, nil
+}
+
+// DeleteForm removes a form along with its submissions
+func (r *Repository) DeleteForm(ctx context.Context, id int) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM forms WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete form: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete form: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("form not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// CreateFormSubmission logs a form submission
+func (r *Repository) CreateFormSubmission(ctx context.Context, submission domain.FormSubmission) (int, error) {
+	utm, err := json.Marshal(emptyIfNil(submission.UTM))
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode submission utm: %w", err)
+	}
+	data, err := json.Marshal(emptyIfNil(submission.Data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode submission data: %w", err)
+	}
+
+	query := `
+	INSERT INTO form_submissions (form_id, entity_id, status, ip, referrer, utm, data, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id
+	`
+
+	var id int
+	err = r.conn(ctx).QueryRowContext(ctx, query,
+		submission.FormID,
+		submission.EntityID,
+		submission.Status,
+		submission.IP,
+		submission.Referrer,
+		utm,
+		data,
+		time.Now()).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create a form submission: %w", err)
+	}
+	return id, nil
+}
+
+// GetFormSubmissions lists the latest 100 submissions of a form
+func (r *Repository) GetFormSubmissions(ctx context.Context, formID int) ([]*domain.FormSubmission, error) {
+	query := `
+	SELECT id, form_id, entity_id, status, ip, referrer, utm, data, created_at
+	FROM form_submissions WHERE form_id = $1 ORDER BY id DESC LIMIT 100
+	`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, formID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get form submissions: %w", err)
+	}
+	return scanFormSubmissions(rows)
+}
+
+// scanForm scans a row selected with formColumns
+func scanForm(row RowScanner) (*domain.Form, error) {
+	var form domain.Form
+	var mappings, origins []byte
+	if err := row.Scan(
+		&form.ID,
+		&form.Key,
+		&form.Name,
+		&form.Entity,
+		&mappings,
+		&origins,
+		&form.HoneypotField,
+		&form.RequireToken,
+		&form.Secret,
+		&form.CreatedAt,
+		&form.UpdatedAt,
+		&form.Version,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(mappings, &form.FieldMappings); err != nil {
+		return nil, fmt.Errorf("failed to decode form field mappings: %w", err)
+	}
+	if err := json.Unmarshal(origins, &form.AllowedOrigins); err != nil {
+		return nil, fmt.Errorf("failed to decode form origins: %w", err)
+	}
+	return &form, nil
+}
+
+// emptyIfNil stores a missing map as an empty JSON object
+func emptyIfNil(values map[string]string) map[string]string {
+	if values == nil {
+		return map[string]string{}
+	}
+	return values
+}
+
+// scanFormSubmissions scans and closes form submission rows
+func scanFormSubmissions(rows *sql.Rows) ([]*domain.FormSubmission, error) {
+	defer rows.Close()
+
+	var submissions []*domain.FormSubmission
+	for rows.Next() {
+		var submission domain.FormSubmission
+		var entityID sql.NullInt64
+		var utm, data []byte
+		if err := rows.Scan(
+			&submission.ID,
+			&submission.FormID,
+			&entityID,
+			&submission.Status,
+			&submission.IP,
+			&submission.Referrer,
+			&utm,
+			&data,
+			&submission.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan form submission row: %w", err)
+		}
+		if entityID.Valid {
+			id := int(entityID.Int64)
+			submission.EntityID = &id
+		}
+		if err := json.Unmarshal(utm, &submission.UTM); err != nil {
+			return nil, fmt.Errorf("failed to decode submission utm: %w", err)
+		}
+		if err := json.Unmarshal(data, &submission.Data); err != nil {
+			return nil, fmt.Errorf("failed to decode submission data: %w", err)
+		}
+		submissions = append(submissions, &submission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over form submission rows: %w", err)
+	}
+	return submissions, nil
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// idempotencyKeyColumns lists the columns scanIdempotencyKey expects
+const idempotencyKeyColumns = `key, request_hash, status_code, response_headers, response_body, created_at, expires_at`
+
+// ClaimIdempotencyKey stores a new key, taking over an expired one, or
+// returns the live key already stored. The upsert locks a taken key's row
+// even when it doesn't update it, so it can't go away before it's read.
+func (r *Repository) ClaimIdempotencyKey(ctx context.Context, key domain.IdempotencyKey) (*domain.IdempotencyKey, error) {
+	var existing *domain.IdempotencyKey
+	err := r.inTx(ctx, nil, func(tx DBConnection) error {
+		var claimed string
+		err := tx.QueryRowContext(ctx, `
+		INSERT INTO idempotency_keys (key, request_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET
+			request_hash = EXCLUDED.request_hash,
+			status_code = 0,
+			response_headers = '{}',
+			response_body = '',
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+		RETURNING key
+		`, key.Key, key.RequestHash, key.CreatedAt, key.ExpiresAt).Scan(&claimed)
+		if err == nil {
+			return nil
+		}
+		if err != sql.ErrNoRows {
+			return fmt.Errorf("failed to claim idempotency key: %w", err)
+		}
+
+		existing, err = scanIdempotencyKey(tx.QueryRowContext(ctx,
+			`SELECT `+idempotencyKeyColumns+` FROM idempotency_keys WHERE key = $1`, key.Key))
+		if err != nil {
+			return fmt.Errorf("failed to get idempotency key: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// CompleteIdempotencyKey saves the response of a claimed key's request and
+// keeps it until expiresAt
+func (r *Repository) CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, headers map[string]string, body []byte, expiresAt time.Time) error {
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headersJSON, err := json.Marshal(headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode response headers: %w", err)
+	}
+	if body == nil {
+		body = []byte{}
+	}
+
+	result, err := r.conn(ctx).ExecContext(ctx, `
+	UPDATE idempotency_keys SET status_code = $1, response_headers = $2, response_body = $3, expires_at = $4
+	WHERE key = $5 AND status_code = 0
+	`, statusCode, headersJSON, body, expiresAt, key)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("idempotency key not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes a key whose request hasn't completed
+func (r *Repository) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	if _, err := r.conn(ctx).ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE key = $1 AND status_code = 0`, key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes keys that expired before now
+func (r *Repository) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	result, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+	return int(affected), nil
+}
+
+// scanIdempotencyKey reads one idempotency key in idempotencyKeyColumns order
+func scanIdempotencyKey(row RowScanner) (*domain.IdempotencyKey, error) {
+	var key domain.IdempotencyKey
+	var headersJSON []byte
+	if err := row.Scan(&key.Key, &key.RequestHash, &key.StatusCode, &headersJSON, &key.ResponseBody,
+		&key.CreatedAt, &key.ExpiresAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(headersJSON, &key.ResponseHeaders); err != nil {
+		return nil, fmt.Errorf("failed to decode response headers: %w", err)
+	}
+	return &key, nil
+}
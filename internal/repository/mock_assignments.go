@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreateAssignmentRule adds an assignment rule to the in-memory map
+func (m *MockRepository) CreateAssignmentRule(ctx context.Context, rule domain.AssignmentRule) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	id := m.nextAssignmentRule
+	now := time.Now()
+
+	rule.ID = id
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	rule.Version = 1
+	m.assignmentRules[id] = &rule
+
+	m.nextAssignmentRule++
+	return id, nil
+}
+
+// GetAssignmentRules lists the in-memory rules for an entity by position then ID
+func (m *MockRepository) GetAssignmentRules(ctx context.Context, entity string) ([]*domain.AssignmentRule, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var rules []*domain.AssignmentRule
+	for _, rule := range m.assignmentRules {
+		if rule.Entity == entity {
+			copied := *rule
+			rules = append(rules, &copied)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Position != rules[j].Position {
+			return rules[i].Position < rules[j].Position
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules, nil
+}
+
+// GetAssignmentRule retrieves an in-memory assignment rule by ID
+func (m *MockRepository) GetAssignmentRule(ctx context.Context, id int) (*domain.AssignmentRule, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	rule, exists := m.assignmentRules[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	copied := *rule
+	return &copied, nil
+}
+
+// DeleteAssignmentRule removes an assignment rule from the in-memory map
+func (m *MockRepository) DeleteAssignmentRule(ctx context.Context, id int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	m.untrash(domain.AuditAssignmentRule, id)
+	if _, exists := m.assignmentRules[id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.assignmentRules, id)
+	delete(m.assignmentTurns, id)
+	return nil
+}
+
+// NextAssignmentTurn returns the rule's current turn and advances it
+func (m *MockRepository) NextAssignmentTurn(ctx context.Context, ruleID int) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	if _, exists := m.assignmentRules[ruleID]; !exists {
+		return 0, ErrNotFound
+	}
+	turn := m.assignmentTurns[ruleID]
+	m.assignmentTurns[ruleID] = turn + 1
+	return turn, nil
+}
+
+// GetAvailableUsers returns the given in-memory users that are not out of office
+func (m *MockRepository) GetAvailableUsers(ctx context.Context, ids []int) ([]int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var available []int
+	for _, id := range ids {
+		if user, exists := m.users[id]; exists && !user.OutOfOffice && !slices.Contains(available, id) {
+			available = append(available, id)
+		}
+	}
+	sort.Ints(available)
+	return available, nil
+}
+
+// CountOwnedRecords counts the in-memory users owned by each of the owners
+func (m *MockRepository) CountOwnedRecords(ctx context.Context, entity string, ownerIDs []int) (map[int]int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	counts := make(map[int]int)
+	for _, user := range m.users {
+		if user.OwnerID != nil && slices.Contains(ownerIDs, *user.OwnerID) {
+			counts[*user.OwnerID]++
+		}
+	}
+	return counts, nil
+}
+
+// AssignOwner moves the in-memory users to a new owner and logs the changes
+func (m *MockRepository) AssignOwner(ctx context.Context, entity string, ids []int, ownerID int, ruleID *int, reason string) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	changed := 0
+	now := time.Now()
+	for _, id := range ids {
+		user, exists := m.users[id]
+		if !exists || (user.OwnerID != nil && *user.OwnerID == ownerID) {
+			continue
+		}
+
+		owner := ownerID
+		m.assignments = append(m.assignments, &domain.Assignment{
+			ID:              len(m.assignments) + 1,
+			Entity:          entity,
+			EntityID:        id,
+			OwnerID:         ownerID,
+			PreviousOwnerID: user.OwnerID,
+			RuleID:          ruleID,
+			Reason:          reason,
+			CreatedAt:       now,
+		})
+		user.OwnerID = &owner
+		user.UpdatedAt = now
+		user.Version++
+		changed++
+	}
+	return changed, nil
+}
+
+// GetAssignments lists the in-memory assignment history of a record, newest first
+func (m *MockRepository) GetAssignments(ctx context.Context, entity string, entityID int) ([]*domain.Assignment, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var assignments []*domain.Assignment
+	for i := len(m.assignments) - 1; i >= 0; i-- {
+		a := m.assignments[i]
+		if a.Entity == entity && a.EntityID == entityID {
+			copied := *a
+			assignments = append(assignments, &copied)
+		}
+	}
+	return assignments, nil
+}
+
+// SetOutOfOffice changes an in-memory user's availability
+func (m *MockRepository) SetOutOfOffice(ctx context.Context, userID int, outOfOffice bool, version int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	user, exists := m.users[userID]
+	if !exists {
+		return ErrNotFound
+	}
+	if !versionMatches(user.Version, version) {
+		return ErrVersionConflict
+	}
+	user.OutOfOffice = outOfOffice
+	user.UpdatedAt = time.Now()
+	user.Version++
+	return nil
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// AppendAuditEvent chains an event onto the in-memory audit log
+func (m *MockRepository) AppendAuditEvent(ctx context.Context, event domain.AuditEvent) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	event.ID = len(m.auditEvents) + 1
+	event.PrevHash = AuditGenesisHash
+	if len(m.auditEvents) > 0 {
+		event.PrevHash = m.auditEvents[len(m.auditEvents)-1].Hash
+	}
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	event.ChangesHash = AuditChangesHash(event.Changes)
+	event.Hash = AuditHash(event)
+	m.auditEvents = append(m.auditEvents, &event)
+	return event.ID, nil
+}
+
+// GetAuditEvents lists the in-memory audit events matching the filter, oldest first
+func (m *MockRepository) GetAuditEvents(ctx context.Context, filter domain.AuditFilter) ([]*domain.AuditEvent, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var events []*domain.AuditEvent
+	for _, event := range m.auditEvents {
+		if len(events) == filter.Limit {
+			break
+		}
+		if event.ID <= filter.AfterID ||
+			filter.Entity != "" && event.Entity != filter.Entity ||
+			filter.EntityID != 0 && event.EntityID != filter.EntityID ||
+			filter.Actor != "" && event.Actor != filter.Actor ||
+			filter.Action != "" && event.Action != filter.Action ||
+			filter.RequestID != "" && event.RequestID != filter.RequestID ||
+			filter.Since != nil && event.CreatedAt.Before(*filter.Since) ||
+			filter.Until != nil && !event.CreatedAt.Before(*filter.Until) {
+			continue
+		}
+		copied := *event
+		copied.Changes = maps.Clone(event.Changes)
+		events = append(events, &copied)
+	}
+	return events, nil
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreateAutomation adds an automation to the in-memory map
+func (m *MockRepository) CreateAutomation(ctx context.Context, automation domain.Automation) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	id := m.nextAutomation
+	now := time.Now()
+
+	automation.ID = id
+	automation.CreatedAt = now
+	automation.UpdatedAt = now
+	automation.Version = 1
+	m.automations[id] = &automation
+
+	m.nextAutomation++
+	return id, nil
+}
+
+// GetAutomations lists the in-memory automations for an entity ordered by ID
+func (m *MockRepository) GetAutomations(ctx context.Context, entity string) ([]*domain.Automation, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var automations []*domain.Automation
+	for _, automation := range m.automations {
+		if automation.Entity == entity {
+			copied := *automation
+			automations = append(automations, &copied)
+		}
+	}
+	sort.Slice(automations, func(i, j int) bool {
+		return automations[i].ID < automations[j].ID
+	})
+	return automations, nil
+}
+
+// GetAutomation retrieves an in-memory automation by ID
+func (m *MockRepository) GetAutomation(ctx context.Context, id int) (*domain.Automation, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	automation, exists := m.automations[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	copied := *automation
+	return &copied, nil
+}
+
+// DeleteAutomation removes an automation and its runs from memory
+func (m *MockRepository) DeleteAutomation(ctx context.Context, id int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	m.untrash(domain.AuditAutomation, id)
+	if _, exists := m.automations[id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.automations, id)
+
+	runs := m.automationRuns[:0]
+	for _, run := range m.automationRuns {
+		if run.AutomationID != id {
+			runs = append(runs, run)
+		}
+	}
+	m.automationRuns = runs
+	return nil
+}
+
+// CreateAutomationRun queues an in-memory run, honouring the dedupe key
+func (m *MockRepository) CreateAutomationRun(ctx context.Context, run domain.AutomationRun) (int, bool, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	if run.DedupeKey != "" {
+		for _, existing := range m.automationRuns {
+			if existing.AutomationID == run.AutomationID && existing.Entity == run.Entity &&
+				existing.EntityID == run.EntityID && existing.DedupeKey == run.DedupeKey {
+				return 0, false, nil
+			}
+		}
+	}
+
+	now := time.Now()
+	run.ID = m.nextAutomationRun
+	m.nextAutomationRun++
+	run.Attempts = 0
+	run.CreatedAt = now
+	run.UpdatedAt = now
+	m.automationRuns = append(m.automationRuns, &run)
+	return run.ID, true, nil
+}
+
+// ClaimAutomationRuns marks due in-memory runs as running, oldest first
+func (m *MockRepository) ClaimAutomationRuns(ctx context.Context, now time.Time, limit int) ([]*domain.AutomationRun, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var claimed []*domain.AutomationRun
+	for _, run := range m.automationRuns {
+		if len(claimed) == limit {
+			break
+		}
+		if run.Status != domain.RunPending || run.NextAttemptAt.After(now) {
+			continue
+		}
+		if _, live := m.automations[run.AutomationID]; !live {
+			continue
+		}
+		run.Status = domain.RunRunning
+		run.Attempts++
+		run.UpdatedAt = now
+		copied := *run
+		claimed = append(claimed, &copied)
+	}
+	return claimed, nil
+}
+
+// FinishAutomationRun stores the outcome of an in-memory run
+func (m *MockRepository) FinishAutomationRun(ctx context.Context, run domain.AutomationRun) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	for _, existing := range m.automationRuns {
+		if existing.ID == run.ID {
+			existing.Status = run.Status
+			existing.Log = run.Log
+			existing.Error = run.Error
+			existing.NextAttemptAt = run.NextAttemptAt
+			existing.UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// GetAutomationRuns lists the in-memory runs of an automation, newest first
+func (m *MockRepository) GetAutomationRuns(ctx context.Context, automationID int) ([]*domain.AutomationRun, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var runs []*domain.AutomationRun
+	for i := len(m.automationRuns) - 1; i >= 0; i-- {
+		if run := m.automationRuns[i]; run.AutomationID == automationID {
+			copied := *run
+			runs = append(runs, &copied)
+		}
+	}
+	return runs, nil
+}
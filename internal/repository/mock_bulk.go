@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// WriteUsers applies the writes to the in-memory users. An atomic batch that
+// fails puts the store back as it was, like a rollback.
+func (m *MockRepository) WriteUsers(ctx context.Context, writes []domain.UserWrite, atomic bool) ([]error, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	rollback := m.snapshot()
+	errs := make([]error, len(writes))
+	for i := range writes {
+		write := &writes[i]
+		switch write.Op {
+		case domain.BulkCreate:
+			write.User.ID, errs[i] = m.CreateUser(ctx, write.User)
+		case domain.BulkUpdate:
+			errs[i] = m.UpdateUser(ctx, write.User)
+		case domain.BulkDelete:
+			errs[i] = m.TrashRecord(ctx, domain.EntityUser, write.User.ID, write.User.Version, write.DeletedBy)
+		default:
+			errs[i] = fmt.Errorf("unknown user write %q", write.Op)
+		}
+		if errs[i] != nil && atomic {
+			rollback()
+			return errs, nil
+		}
+	}
+	return errs, nil
+}
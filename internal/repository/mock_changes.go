@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// mockChangePoll is how often the in-memory ListenChanges looks for changes
+const mockChangePoll = 200 * time.Millisecond
+
+// ListenChanges compares the in-memory users with the ones it saw at its last
+// poll and reports those added, changed and gone. A change undone before the
+// next poll goes unseen.
+func (m *MockRepository) ListenChanges(ctx context.Context, fn func(domain.ChangeEvent)) error {
+	seen := m.userCopies(ctx)
+
+	ticker := time.NewTicker(mockChangePoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			current := m.userCopies(ctx)
+			var changes []domain.ChangeEvent
+			for id, user := range current {
+				if previous, ok := seen[id]; !ok {
+					changes = append(changes, domain.ChangeEvent{Entity: domain.EntityUser, ID: id, Op: domain.ChangeCreated, At: now})
+				} else if !reflect.DeepEqual(previous, user) {
+					changes = append(changes, domain.ChangeEvent{Entity: domain.EntityUser, ID: id, Op: domain.ChangeUpdated, At: now})
+				}
+			}
+			for id := range seen {
+				if _, ok := current[id]; !ok {
+					changes = append(changes, domain.ChangeEvent{Entity: domain.EntityUser, ID: id, Op: domain.ChangeDeleted, At: now})
+				}
+			}
+			sort.Slice(changes, func(i, j int) bool {
+				return changes[i].ID < changes[j].ID
+			})
+
+			seen = current
+			for _, change := range changes {
+				fn(change)
+			}
+		}
+	}
+}
+
+// userCopies copies the in-memory users, by ID
+func (m *MockRepository) userCopies(ctx context.Context) map[int]*domain.User {
+	_, unlock := m.lock(ctx)
+	defer unlock()
+
+	users := make(map[int]*domain.User, len(m.users))
+	for id, user := range m.users {
+		users[id] = m.withTags(user)
+	}
+	return users
+}
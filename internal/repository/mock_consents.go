@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreateConsent appends a consent change to the in-memory history
+func (m *MockRepository) CreateConsent(ctx context.Context, consent domain.Consent) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	consent.ID = len(m.consents) + 1
+	consent.CreatedAt = time.Now()
+	m.consents = append(m.consents, &consent)
+	return consent.ID, nil
+}
+
+// GetConsents lists a record's in-memory consent changes, newest first
+func (m *MockRepository) GetConsents(ctx context.Context, entity string, entityID int) ([]*domain.Consent, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var consents []*domain.Consent
+	for i := len(m.consents) - 1; i >= 0; i-- {
+		if m.consents[i].Entity == entity && m.consents[i].EntityID == entityID {
+			copied := *m.consents[i]
+			consents = append(consents, &copied)
+		}
+	}
+	return consents, nil
+}
+
+// AddSuppression adds an address to the in-memory suppression list unless it is already there
+func (m *MockRepository) AddSuppression(ctx context.Context, suppression domain.EmailSuppression) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	if _, err := m.GetSuppression(ctx, suppression.Email); err == nil {
+		return nil
+	}
+	suppression.ID = m.nextSuppression
+	suppression.CreatedAt = time.Now()
+	m.suppressions = append(m.suppressions, &suppression)
+	m.nextSuppression++
+	return nil
+}
+
+// GetSuppression retrieves the in-memory suppression of an address
+func (m *MockRepository) GetSuppression(ctx context.Context, email string) (*domain.EmailSuppression, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	for _, suppression := range m.suppressions {
+		if suppression.Email == email {
+			copied := *suppression
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetSuppressions lists the in-memory suppressions, newest first
+func (m *MockRepository) GetSuppressions(ctx context.Context) ([]*domain.EmailSuppression, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var suppressions []*domain.EmailSuppression
+	for i := len(m.suppressions) - 1; i >= 0; i-- {
+		copied := *m.suppressions[i]
+		suppressions = append(suppressions, &copied)
+	}
+	return suppressions, nil
+}
+
+// DeleteSuppression removes an address from the in-memory suppression list
+func (m *MockRepository) DeleteSuppression(ctx context.Context, id int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	for i, suppression := range m.suppressions {
+		if suppression.ID == id {
+			m.suppressions = append(m.suppressions[:i], m.suppressions[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// LiftSuppression removes an in-memory suppression with the given reason
+func (m *MockRepository) LiftSuppression(ctx context.Context, email, reason string) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	for i, suppression := range m.suppressions {
+		if suppression.Email == email && suppression.Reason == reason {
+			m.suppressions = append(m.suppressions[:i], m.suppressions[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
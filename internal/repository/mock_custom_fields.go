@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreateCustomField adds a field definition to the in-memory map, enforcing the
+// same unique (entity, key) index on live fields as the database
+func (m *MockRepository) CreateCustomField(ctx context.Context, def domain.CustomFieldDefinition) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	if err := m.checkCustomFieldKeyFree(def.Entity, def.Key, 0); err != nil {
+		return 0, err
+	}
+
+	id := m.nextCustomField
+	now := time.Now()
+
+	def.ID = id
+	def.CreatedAt = now
+	def.UpdatedAt = now
+	def.Version = 1
+	m.customFields[id] = &def
+
+	m.nextCustomField++
+	return id, nil
+}
+
+// checkCustomFieldKeyFree fails like the unique index on live fields when a
+// live field other than the one with ID id has the entity's key
+func (m *MockRepository) checkCustomFieldKeyFree(entity, key string, id int) error {
+	for _, existing := range m.customFields {
+		if existing.Entity == entity && existing.Key == key && existing.ID != id {
+			return fmt.Errorf("custom field %q: %w", key, ErrDuplicate)
+		}
+	}
+	return nil
+}
+
+// GetCustomFields lists the field definitions for an entity ordered by ID
+func (m *MockRepository) GetCustomFields(ctx context.Context, entity string) ([]*domain.CustomFieldDefinition, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var defs []*domain.CustomFieldDefinition
+	for _, def := range m.customFields {
+		if def.Entity == entity {
+			copied := *def
+			defs = append(defs, &copied)
+		}
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].ID < defs[j].ID
+	})
+	return defs, nil
+}
+
+// GetCustomField retrieves an in-memory field definition by ID
+func (m *MockRepository) GetCustomField(ctx context.Context, id int) (*domain.CustomFieldDefinition, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	def, exists := m.customFields[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	copied := *def
+	return &copied, nil
+}
+
+// UpdateCustomField saves an in-memory definition's label and history tracking
+func (m *MockRepository) UpdateCustomField(ctx context.Context, def domain.CustomFieldDefinition) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	existing, exists := m.customFields[def.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if !versionMatches(existing.Version, def.Version) {
+		return ErrVersionConflict
+	}
+	existing.Label = def.Label
+	existing.TrackHistory = def.TrackHistory
+	existing.UpdatedAt = time.Now()
+	existing.Version++
+	return nil
+}
+
+// DeleteCustomField removes a field definition from the in-memory map
+func (m *MockRepository) DeleteCustomField(ctx context.Context, id int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	m.untrash(domain.AuditCustomField, id)
+	if _, exists := m.customFields[id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.customFields, id)
+	return nil
+}
+
+// matchesCustomFilters mimics the JSONB @> containment used by the SQL filter:
+// scalar values must be equal and arrays must contain the wanted value
+func matchesCustomFilters(fields map[string]any, filters map[string]any) bool {
+	for key, want := range filters {
+		have, ok := fields[key]
+		if !ok {
+			return false
+		}
+		have, want = normalizeJSON(have), normalizeJSON(want)
+		if values, isArray := have.([]any); isArray {
+			found := false
+			for _, value := range values {
+				if reflect.DeepEqual(value, want) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+			continue
+		}
+		if !reflect.DeepEqual(have, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortUsers applies a ListOptions sort to users that are already in default order
+func sortUsers(users []*domain.User, sortOpt string) {
+	desc := strings.HasPrefix(sortOpt, "-")
+	sortOpt = strings.TrimPrefix(sortOpt, "-")
+
+	var value func(u *domain.User) any
+	if key, ok := strings.CutPrefix(sortOpt, "cf."); ok && key != "" {
+		value = func(u *domain.User) any { return normalizeJSON(u.CustomFields[key]) }
+	} else {
+		switch sortOpt {
+		case "id":
+			value = func(u *domain.User) any { return float64(u.ID) }
+		case "name":
+			value = func(u *domain.User) any { return u.Name }
+		case "email":
+			value = func(u *domain.User) any { return u.Email }
+		case "score":
+			value = func(u *domain.User) any { return float64(u.Score) }
+		case "created_at":
+			value = func(u *domain.User) any { return u.CreatedAt.Format(time.RFC3339Nano) }
+		case "updated_at":
+			value = func(u *domain.User) any { return u.UpdatedAt.Format(time.RFC3339Nano) }
+		default:
+			return
+		}
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		a, b := value(users[i]), value(users[j])
+		// missing values always sort last, like NULLS LAST
+		if a == nil || b == nil {
+			return a != nil
+		}
+		cmp := compareValues(a, b)
+		if desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+}
+
+// compareValues orders two decoded JSON values of the same kind
+func compareValues(a, b any) int {
+	switch av := a.(type) {
+	case float64:
+		if bv, ok := b.(float64); ok {
+			switch {
+			case av < bv:
+				return -1
+			case av > bv:
+				return 1
+			}
+			return 0
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			switch {
+			case av == bv:
+				return 0
+			case !av:
+				return -1
+			}
+			return 1
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// normalizeJSON round-trips a value through JSON so Go values compare the same
+// way the database sees them (e.g. int and float64 are both numbers)
+func normalizeJSON(value any) any {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
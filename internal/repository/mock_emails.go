@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreateEmailTemplate adds an email template to the in-memory map
+func (m *MockRepository) CreateEmailTemplate(ctx context.Context, template domain.EmailTemplate) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	id := m.nextEmailTemplate
+	now := time.Now()
+
+	template.ID = id
+	template.CreatedAt = now
+	template.UpdatedAt = now
+	template.Version = 1
+	m.emailTemplates[id] = &template
+
+	m.nextEmailTemplate++
+	return id, nil
+}
+
+// GetEmailTemplates lists the in-memory email templates ordered by ID
+func (m *MockRepository) GetEmailTemplates(ctx context.Context) ([]*domain.EmailTemplate, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var templates []*domain.EmailTemplate
+	for id := 1; id < m.nextEmailTemplate; id++ {
+		if template, exists := m.emailTemplates[id]; exists {
+			copied := *template
+			templates = append(templates, &copied)
+		}
+	}
+	return templates, nil
+}
+
+// GetEmailTemplate retrieves an in-memory email template by ID
+func (m *MockRepository) GetEmailTemplate(ctx context.Context, id int) (*domain.EmailTemplate, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	template, exists := m.emailTemplates[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	copied := *template
+	return &copied, nil
+}
+
+// DeleteEmailTemplate removes an email template from memory
+func (m *MockRepository) DeleteEmailTemplate(ctx context.Context, id int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	m.untrash(domain.AuditEmailTemplate, id)
+	if _, exists := m.emailTemplates[id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.emailTemplates, id)
+	for _, email := range m.emails {
+		if email.TemplateID != nil && *email.TemplateID == id {
+			email.TemplateID = nil
+		}
+	}
+	return nil
+}
+
+// CreateEmail logs an in-memory email
+func (m *MockRepository) CreateEmail(ctx context.Context, email domain.Email) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	email.ID = len(m.emails) + 1
+	if email.Direction == "" {
+		email.Direction = domain.EmailOutbound
+	}
+	email.CreatedAt = time.Now()
+	m.emails = append(m.emails, &email)
+	return email.ID, nil
+}
+
+// CreateInboundEmail logs an in-memory received email with its attachments and activities
+func (m *MockRepository) CreateInboundEmail(ctx context.Context, email domain.Email, attachments []domain.EmailAttachment, activities []domain.Activity) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	id, err := m.CreateEmail(ctx, email)
+	if err != nil {
+		return 0, err
+	}
+	for _, attachment := range attachments {
+		attachment.ID = len(m.emailAttachments) + 1
+		attachment.EmailID = id
+		attachment.Size = len(attachment.Data)
+		attachment.CreatedAt = time.Now()
+		m.emailAttachments = append(m.emailAttachments, &attachment)
+	}
+	for _, activity := range activities {
+		activity.EmailID = &id
+		if _, err := m.CreateActivity(ctx, activity); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// GetEmail retrieves an in-memory email by ID
+func (m *MockRepository) GetEmail(ctx context.Context, id int) (*domain.Email, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	if id < 1 || id > len(m.emails) {
+		return nil, ErrNotFound
+	}
+	copied := *m.emails[id-1]
+	return &copied, nil
+}
+
+// GetEmailByMessageID retrieves the first in-memory email with a Message-ID
+func (m *MockRepository) GetEmailByMessageID(ctx context.Context, messageID string) (*domain.Email, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	for _, email := range m.emails {
+		if email.MessageID == messageID {
+			copied := *email
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetEmailAttachments lists an in-memory email's attachments without their content
+func (m *MockRepository) GetEmailAttachments(ctx context.Context, emailID int) ([]*domain.EmailAttachment, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var attachments []*domain.EmailAttachment
+	for _, attachment := range m.emailAttachments {
+		if attachment.EmailID == emailID {
+			copied := *attachment
+			copied.Data = nil
+			attachments = append(attachments, &copied)
+		}
+	}
+	return attachments, nil
+}
+
+// GetEmailAttachment retrieves an in-memory attachment of an email with its content
+func (m *MockRepository) GetEmailAttachment(ctx context.Context, emailID, id int) (*domain.EmailAttachment, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	for _, attachment := range m.emailAttachments {
+		if attachment.EmailID == emailID && attachment.ID == id {
+			copied := *attachment
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetEmails lists a record's in-memory emails, newest first
+func (m *MockRepository) GetEmails(ctx context.Context, entity string, entityID int) ([]*domain.Email, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var emails []*domain.Email
+	for i := len(m.emails) - 1; i >= 0; i-- {
+		if m.emails[i].Entity == entity && m.emails[i].EntityID == entityID {
+			copied := *m.emails[i]
+			emails = append(emails, &copied)
+		}
+	}
+	return emails, nil
+}
+
+// GetEmailByToken retrieves an in-memory email by its tracking token
+func (m *MockRepository) GetEmailByToken(ctx context.Context, token string) (*domain.Email, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	for _, email := range m.emails {
+		if email.TrackingToken == token {
+			copied := *email
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// RecordEmailEvent logs an in-memory open or click and updates the email's counters
+func (m *MockRepository) RecordEmailEvent(ctx context.Context, event domain.EmailEvent) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	event.ID = len(m.emailEvents) + 1
+	event.CreatedAt = time.Now()
+	m.emailEvents = append(m.emailEvents, &event)
+
+	for _, email := range m.emails {
+		if email.ID != event.EmailID {
+			continue
+		}
+		switch event.Type {
+		case domain.EmailEventOpen:
+			email.OpenCount++
+			if email.OpenedAt == nil {
+				openedAt := event.CreatedAt
+				email.OpenedAt = &openedAt
+			}
+		case domain.EmailEventClick:
+			email.ClickCount++
+		}
+	}
+	return nil
+}
+
+// CreateActivity adds an in-memory timeline entry
+func (m *MockRepository) CreateActivity(ctx context.Context, activity domain.Activity) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	activity.ID = len(m.activities) + 1
+	if activity.CreatedAt.IsZero() {
+		activity.CreatedAt = time.Now()
+	}
+	m.activities = append(m.activities, &activity)
+	return activity.ID, nil
+}
+
+// GetActivities lists a record's in-memory activities, newest first
+func (m *MockRepository) GetActivities(ctx context.Context, entity string, entityID int) ([]*domain.Activity, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var activities []*domain.Activity
+	for i := len(m.activities) - 1; i >= 0; i-- {
+		if m.activities[i].Entity == entity && m.activities[i].EntityID == entityID {
+			copied := *m.activities[i]
+			activities = append(activities, &copied)
+		}
+	}
+	return activities, nil
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// RecordFieldChanges appends changes to the in-memory field history
+func (m *MockRepository) RecordFieldChanges(ctx context.Context, changes []domain.FieldChange) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	now := time.Now().UTC()
+	for _, change := range changes {
+		change.ID = len(m.fieldHistory) + 1
+		// values come back from JSONB as decoded JSON
+		change.From, change.To = normalizeJSON(change.From), normalizeJSON(change.To)
+		change.ChangedAt = now
+		m.fieldHistory = append(m.fieldHistory, &change)
+	}
+	return nil
+}
+
+// GetFieldHistory lists the in-memory changes to a record made after since,
+// newest first
+func (m *MockRepository) GetFieldHistory(ctx context.Context, entity string, entityID int, since *time.Time) ([]*domain.FieldChange, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var changes []*domain.FieldChange
+	for i := len(m.fieldHistory) - 1; i >= 0; i-- {
+		change := m.fieldHistory[i]
+		if change.Entity != entity || change.EntityID != entityID || since != nil && !change.ChangedAt.After(*since) {
+			continue
+		}
+		copied := *change
+		changes = append(changes, &copied)
+	}
+	return changes, nil
+}
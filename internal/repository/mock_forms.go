@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreateForm adds a form to the in-memory map
+func (m *MockRepository) CreateForm(ctx context.Context, form domain.Form) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	id := m.nextForm
+	now := time.Now()
+
+	form.ID = id
+	form.CreatedAt = now
+	form.UpdatedAt = now
+	form.Version = 1
+	m.forms[id] = &form
+
+	m.nextForm++
+	return id, nil
+}
+
+// GetForms lists the in-memory forms ordered by ID
+func (m *MockRepository) GetForms(ctx context.Context) ([]*domain.Form, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var forms []*domain.Form
+	for id := 1; id < m.nextForm; id++ {
+		if form, exists := m.forms[id]; exists {
+			copied := *form
+			forms = append(forms, &copied)
+		}
+	}
+	return forms, nil
+}
+
+// GetForm retrieves an in-memory form by ID
+func (m *MockRepository) GetForm(ctx context.Context, id int) (*domain.Form, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	form, exists := m.forms[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	copied := *form
+	return &copied, nil
+}
+
+// GetFormByKey retrieves an in-memory form by its public key
+func (m *MockRepository) GetFormByKey(ctx context.Context, key string) (*domain.Form, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	for _, form := range m.forms {
+		if form.Key == key {
+			copied := *form
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// DeleteForm removes a form and its submissions from memory
+func (m *MockRepository) DeleteForm(ctx context.Context, id int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	m.untrash(domain.AuditForm, id)
+	if _, exists := m.forms[id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.forms, id)
+
+	submissions := m.formSubmissions[:0]
+	for _, submission := range m.formSubmissions {
+		if submission.FormID != id {
+			submissions = append(submissions, submission)
+		}
+	}
+	m.formSubmissions = submissions
+	return nil
+}
+
+// CreateFormSubmission logs an in-memory form submission
+func (m *MockRepository) CreateFormSubmission(ctx context.Context, submission domain.FormSubmission) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	submission.ID = m.nextFormSubmission
+	m.nextFormSubmission++
+	submission.CreatedAt = time.Now()
+	m.formSubmissions = append(m.formSubmissions, &submission)
+	return submission.ID, nil
+}
+
+// GetFormSubmissions lists the in-memory submissions of a form, newest first
+func (m *MockRepository) GetFormSubmissions(ctx context.Context, formID int) ([]*domain.FormSubmission, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var submissions []*domain.FormSubmission
+	for i := len(m.formSubmissions) - 1; i >= 0; i-- {
+		if submission := m.formSubmissions[i]; submission.FormID == formID {
+			copied := *submission
+			submissions = append(submissions, &copied)
+		}
+	}
+	return submissions, nil
+}
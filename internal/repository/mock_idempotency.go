@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// ClaimIdempotencyKey stores a new or expired in-memory key, or returns a copy
+// of the live one
+func (m *MockRepository) ClaimIdempotencyKey(ctx context.Context, key domain.IdempotencyKey) (*domain.IdempotencyKey, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	if existing, exists := m.idempotencyKeys[key.Key]; exists && existing.ExpiresAt.After(key.CreatedAt) {
+		copied := *existing
+		return &copied, nil
+	}
+	key.StatusCode = 0
+	key.ResponseHeaders = map[string]string{}
+	key.ResponseBody = nil
+	m.idempotencyKeys[key.Key] = &key
+	return nil, nil
+}
+
+// CompleteIdempotencyKey saves the response of a claimed in-memory key
+func (m *MockRepository) CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, headers map[string]string, body []byte, expiresAt time.Time) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	existing, exists := m.idempotencyKeys[key]
+	if !exists || existing.StatusCode != 0 {
+		return ErrNotFound
+	}
+	existing.StatusCode = statusCode
+	existing.ResponseHeaders = make(map[string]string, len(headers))
+	for name, value := range headers {
+		existing.ResponseHeaders[name] = value
+	}
+	existing.ResponseBody = append([]byte(nil), body...)
+	existing.ExpiresAt = expiresAt
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes an in-memory key that hasn't completed
+func (m *MockRepository) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	if existing, exists := m.idempotencyKeys[key]; exists && existing.StatusCode == 0 {
+		delete(m.idempotencyKeys, key)
+	}
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes in-memory keys that expired before now
+func (m *MockRepository) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	deleted := 0
+	for name, key := range m.idempotencyKeys {
+		if !key.ExpiresAt.After(now) {
+			delete(m.idempotencyKeys, name)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
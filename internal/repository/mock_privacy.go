@@ -0,0 +1,283 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreatePrivacyRequest adds a data subject request to the in-memory map
+func (m *MockRepository) CreatePrivacyRequest(ctx context.Context, request domain.PrivacyRequest) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	now := time.Now()
+	request.ID = m.nextPrivacyRequest
+	request.CreatedAt = now
+	request.UpdatedAt = now
+	m.privacyRequests[request.ID] = &request
+	m.nextPrivacyRequest++
+	return request.ID, nil
+}
+
+// GetPrivacyRequests lists the in-memory requests with a status, or all of
+// them when status is empty, soonest deadline first
+func (m *MockRepository) GetPrivacyRequests(ctx context.Context, status string) ([]*domain.PrivacyRequest, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var requests []*domain.PrivacyRequest
+	for _, request := range m.privacyRequests {
+		if status == "" || request.Status == status {
+			copied := *request
+			requests = append(requests, &copied)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if !requests[i].DueAt.Equal(requests[j].DueAt) {
+			return requests[i].DueAt.Before(requests[j].DueAt)
+		}
+		return requests[i].ID < requests[j].ID
+	})
+	return requests, nil
+}
+
+// GetPrivacyRequest retrieves an in-memory data subject request by ID
+func (m *MockRepository) GetPrivacyRequest(ctx context.Context, id int) (*domain.PrivacyRequest, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	request, exists := m.privacyRequests[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	copied := *request
+	return &copied, nil
+}
+
+// CompletePrivacyRequest marks an in-memory request as fulfilled
+func (m *MockRepository) CompletePrivacyRequest(ctx context.Context, id int, completedAt time.Time) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	request, exists := m.privacyRequests[id]
+	if !exists {
+		return ErrNotFound
+	}
+	request.Status = domain.PrivacyCompleted
+	request.CompletedAt = &completedAt
+	request.UpdatedAt = completedAt
+	return nil
+}
+
+// ClaimPrivacyReminder marks a pending in-memory request as reminded unless it
+// already was after since
+func (m *MockRepository) ClaimPrivacyReminder(ctx context.Context, id int, now, since time.Time) (bool, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	request, exists := m.privacyRequests[id]
+	if !exists || request.Status != domain.PrivacyPending {
+		return false, nil
+	}
+	if request.RemindedAt != nil && request.RemindedAt.After(since) {
+		return false, nil
+	}
+	request.RemindedAt = &now
+	return true, nil
+}
+
+// GetPersonalData compiles everything held in memory about a record
+func (m *MockRepository) GetPersonalData(ctx context.Context, entity string, entityID int) (*domain.PersonalData, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	record, err := m.GetUser(ctx, entityID)
+	if err != nil {
+		return nil, err
+	}
+	data := &domain.PersonalData{Record: record}
+
+	emailIDs := map[int]bool{}
+	for _, email := range m.emails {
+		if email.Entity == entity && email.EntityID == entityID {
+			copied := *email
+			data.Emails = append(data.Emails, &copied)
+			emailIDs[email.ID] = true
+		}
+	}
+	for _, event := range m.emailEvents {
+		if emailIDs[event.EmailID] {
+			copied := *event
+			data.EmailEvents = append(data.EmailEvents, &copied)
+		}
+	}
+	for _, attachment := range m.emailAttachments {
+		if emailIDs[attachment.EmailID] {
+			copied := *attachment
+			data.Attachments = append(data.Attachments, &copied)
+		}
+	}
+	for _, activity := range m.activities {
+		if activity.Entity == entity && activity.EntityID == entityID {
+			copied := *activity
+			data.Activities = append(data.Activities, &copied)
+		}
+	}
+	for _, consent := range m.consents {
+		if consent.Entity == entity && consent.EntityID == entityID {
+			copied := *consent
+			data.Consents = append(data.Consents, &copied)
+		}
+	}
+	for _, enrollment := range m.enrollments {
+		if enrollment.Entity == entity && enrollment.EntityID == entityID {
+			copied := *enrollment
+			data.Enrollments = append(data.Enrollments, &copied)
+		}
+	}
+	for _, submission := range m.formSubmissions {
+		if m.isRecordSubmission(submission, entity, entityID) {
+			copied := *submission
+			data.FormSubmissions = append(data.FormSubmissions, &copied)
+		}
+	}
+	for _, assignment := range m.assignments {
+		if assignment.Entity == entity && assignment.EntityID == entityID {
+			copied := *assignment
+			data.Assignments = append(data.Assignments, &copied)
+		}
+	}
+	for _, run := range m.automationRuns {
+		if run.Entity == entity && run.EntityID == entityID {
+			copied := *run
+			data.AutomationRuns = append(data.AutomationRuns, &copied)
+		}
+	}
+	for _, event := range m.auditEvents {
+		if event.Entity == entity && event.EntityID == entityID {
+			copied := *event
+			data.AuditEvents = append(data.AuditEvents, &copied)
+		}
+	}
+	for _, change := range m.fieldHistory {
+		if change.Entity == entity && change.EntityID == entityID {
+			copied := *change
+			data.FieldHistory = append(data.FieldHistory, &copied)
+		}
+	}
+	return data, nil
+}
+
+// ErasePersonalData anonymizes an in-memory record and everything logged about it
+func (m *MockRepository) ErasePersonalData(ctx context.Context, entity string, entityID int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	user, exists := m.users[entityID]
+	if !exists {
+		return ErrNotFound
+	}
+	user.Name = "Erased"
+	user.Email = fmt.Sprintf("erased-%d@erased.invalid", entityID)
+	user.CustomFields = nil
+	user.ScoreBreakdown = nil
+	user.UpdatedAt = time.Now()
+	user.Version++
+
+	emailIDs := map[int]bool{}
+	for _, email := range m.emails {
+		if email.Entity == entity && email.EntityID == entityID {
+			email.From, email.To, email.Subject = "", "", ""
+			email.BodyText, email.BodyHTML = "", ""
+			email.InReplyTo, email.Error = "", ""
+			email.Links = nil
+			emailIDs[email.ID] = true
+		}
+	}
+	attachments := m.emailAttachments[:0]
+	for _, attachment := range m.emailAttachments {
+		if !emailIDs[attachment.EmailID] {
+			attachments = append(attachments, attachment)
+		}
+	}
+	m.emailAttachments = attachments
+	for _, event := range m.emailEvents {
+		if emailIDs[event.EmailID] {
+			event.IP, event.UserAgent = "", ""
+		}
+	}
+	for _, activity := range m.activities {
+		if activity.Entity == entity && activity.EntityID == entityID {
+			activity.Summary = ""
+		}
+	}
+	consentIDs := map[int]bool{}
+	for _, consent := range m.consents {
+		if consent.Entity == entity && consent.EntityID == entityID {
+			consent.IP, consent.UserAgent = "", ""
+			consentIDs[consent.ID] = true
+		}
+	}
+	for _, submission := range m.formSubmissions {
+		if m.isRecordSubmission(submission, entity, entityID) {
+			submission.IP, submission.Referrer = "", ""
+			submission.Data = map[string]string{}
+		}
+	}
+	for _, run := range m.automationRuns {
+		if run.Entity == entity && run.EntityID == entityID {
+			run.Log, run.Error = "", ""
+		}
+	}
+	for _, enrollment := range m.enrollments {
+		if enrollment.Entity == entity && enrollment.EntityID == entityID {
+			if enrollment.Status == domain.EnrollmentActive {
+				enrollment.Status = domain.EnrollmentRemoved
+			}
+			enrollment.Error = ""
+		}
+	}
+
+	erased, _ := json.Marshal(map[string]any{"id": entityID, "erased": true})
+	outboxIDs := map[int]bool{}
+	for _, event := range m.outbox {
+		if event.Entity == entity && event.EntityID == entityID {
+			event.Payload = erased
+			outboxIDs[event.ID] = true
+		}
+	}
+	for _, delivery := range m.webhookDeliveries {
+		if outboxIDs[delivery.OutboxID] {
+			delivery.Payload = erased
+		}
+	}
+	for _, event := range m.auditEvents {
+		if event.Entity == entity && event.EntityID == entityID ||
+			event.Entity == domain.AuditConsent && consentIDs[event.EntityID] {
+			event.Changes = map[string]domain.AuditChange{}
+			event.Redacted = true
+		}
+	}
+	history := m.fieldHistory[:0]
+	for _, change := range m.fieldHistory {
+		if change.Entity != entity || change.EntityID != entityID {
+			history = append(history, change)
+		}
+	}
+	m.fieldHistory = history
+	return nil
+}
+
+// isRecordSubmission reports whether a form submission created or updated a record
+func (m *MockRepository) isRecordSubmission(submission *domain.FormSubmission, entity string, entityID int) bool {
+	if submission.EntityID == nil || *submission.EntityID != entityID {
+		return false
+	}
+	form, exists := m.forms[submission.FormID]
+	return exists && form.Entity == entity
+}
@@ -2,27 +2,146 @@ package repository
 
 import (
 	"context"
+	"fmt"
+	"maps"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dyrober/AgencyCRM/internal/domain"
 )
 
-// MockRepository implements the UserRepository interface for testing
+// MockRepository implements the Store interface in memory, for tests and for
+// running the API without a database. It is safe for concurrent use: every
+// call holds the lock for its duration, InTx for the whole unit of work.
 type MockRepository struct {
+	mu sync.Mutex
+	mockStore
+}
+
+// mockStore is the data of a MockRepository, kept apart from its lock so a
+// unit of work can copy it whole
+type mockStore struct {
 	users  map[int]*domain.User
 	nextID int
+
+	customFields    map[int]*domain.CustomFieldDefinition
+	nextCustomField int
+
+	tags        map[string]*domain.Tag
+	tagLinks    map[tagLink]bool
+	nextTagID   int
+	segments    map[int]*domain.Segment
+	nextSegment int
+
+	scoringRules    map[int]*domain.ScoringRule
+	nextScoringRule int
+
+	assignmentRules    map[int]*domain.AssignmentRule
+	assignmentTurns    map[int]int
+	nextAssignmentRule int
+	assignments        []*domain.Assignment
+
+	automations       map[int]*domain.Automation
+	nextAutomation    int
+	automationRuns    []*domain.AutomationRun
+	nextAutomationRun int
+
+	outbox              []*domain.OutboxEvent
+	outboxProcessed     map[int]bool
+	webhooks            map[int]*domain.WebhookSubscription
+	nextWebhook         int
+	webhookDeliveries   []*domain.WebhookDelivery
+	nextWebhookDelivery int
+
+	forms              map[int]*domain.Form
+	nextForm           int
+	formSubmissions    []*domain.FormSubmission
+	nextFormSubmission int
+
+	emailTemplates    map[int]*domain.EmailTemplate
+	nextEmailTemplate int
+	emails            []*domain.Email
+	emailEvents       []*domain.EmailEvent
+	emailAttachments  []*domain.EmailAttachment
+	activities        []*domain.Activity
+
+	sequences      map[int]*domain.Sequence
+	nextSequence   int
+	enrollments    []*domain.SequenceEnrollment
+	nextEnrollment int
+
+	consents        []*domain.Consent
+	suppressions    []*domain.EmailSuppression
+	nextSuppression int
+
+	privacyRequests    map[int]*domain.PrivacyRequest
+	nextPrivacyRequest int
+
+	auditEvents  []*domain.AuditEvent
+	fieldHistory []*domain.FieldChange
+
+	trash map[trashKey]*trashedRecord
+
+	idempotencyKeys map[string]*domain.IdempotencyKey
 }
 
-// Ensure MockRepository implements UserRepository
-var _ UserRepository = (*MockRepository)(nil)
+// Ensure MockRepository implements Store
+var _ Store = (*MockRepository)(nil)
 
 // NewMockRepository creates a new mock repository instance
 func NewMockRepository() *MockRepository {
-	return &MockRepository{
+	return &MockRepository{mockStore: mockStore{
 		users:  make(map[int]*domain.User),
 		nextID: 1,
-	}
+
+		customFields:    make(map[int]*domain.CustomFieldDefinition),
+		nextCustomField: 1,
+
+		tags:        make(map[string]*domain.Tag),
+		tagLinks:    make(map[tagLink]bool),
+		nextTagID:   1,
+		segments:    make(map[int]*domain.Segment),
+		nextSegment: 1,
+
+		scoringRules:    make(map[int]*domain.ScoringRule),
+		nextScoringRule: 1,
+
+		assignmentRules:    make(map[int]*domain.AssignmentRule),
+		assignmentTurns:    make(map[int]int),
+		nextAssignmentRule: 1,
+
+		automations:    make(map[int]*domain.Automation),
+		nextAutomation: 1,
+
+		nextAutomationRun: 1,
+
+		outboxProcessed:     make(map[int]bool),
+		webhooks:            make(map[int]*domain.WebhookSubscription),
+		nextWebhook:         1,
+		nextWebhookDelivery: 1,
+
+		forms:              make(map[int]*domain.Form),
+		nextForm:           1,
+		nextFormSubmission: 1,
+
+		emailTemplates:    make(map[int]*domain.EmailTemplate),
+		nextEmailTemplate: 1,
+
+		sequences:      make(map[int]*domain.Sequence),
+		nextSequence:   1,
+		nextEnrollment: 1,
+
+		nextSuppression: 1,
+
+		privacyRequests:    make(map[int]*domain.PrivacyRequest),
+		nextPrivacyRequest: 1,
+
+		trash: make(map[trashKey]*trashedRecord),
+
+		idempotencyKeys: make(map[string]*domain.IdempotencyKey),
+	}}
 }
 
 // Close is a no-op for the mock
@@ -32,25 +151,52 @@ func (m *MockRepository) Close() error {
 
 // GetUser retrieves a user by ID from the in-memory map
 func (m *MockRepository) GetUser(ctx context.Context, id int) (*domain.User, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
 	user, exists := m.users[id]
 	if !exists {
 		return nil, ErrNotFound
 	}
-	return user, nil
+	return m.withTags(user), nil
+}
+
+// GetUserByEmail finds an in-memory user by email address, ignoring case
+func (m *MockRepository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var found *domain.User
+	for _, user := range m.users {
+		if strings.EqualFold(user.Email, email) && (found == nil || user.ID < found.ID) {
+			found = user
+		}
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return m.withTags(found), nil
 }
 
-// GetUsers retrieves all users from the in-memory map, sorted by ID in descending order
-func (m *MockRepository) GetUsers(ctx context.Context) ([]*domain.User, error) {
+// GetUsers retrieves the users matching opts from the in-memory map, sorted by ID in descending order
+// unless opts asks for a different order
+func (m *MockRepository) GetUsers(ctx context.Context, opts domain.ListOptions) ([]*domain.User, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
 	users := make([]*domain.User, 0, len(m.users))
 
 	for _, user := range m.users {
-		users = append(users, user)
+		if matchesCustomFilters(user.CustomFields, opts.CustomFilters) {
+			users = append(users, m.withTags(user))
+		}
 	}
 
 	// Sort by ID in descending order to match SQL ORDER BY id DESC
 	sort.Slice(users, func(i, j int) bool {
 		return users[i].ID > users[j].ID
 	})
+	sortUsers(users, opts.Sort)
 
 	// Limit to 100 users to match SQL LIMIT 100
 	if len(users) > 100 {
@@ -60,24 +206,131 @@ func (m *MockRepository) GetUsers(ctx context.Context) ([]*domain.User, error) {
 	return users, nil
 }
 
-// CreateUser adds a new user to the in-memory map
+// GetUserBatch lists up to limit users with an ID above afterID, lowest ID first
+func (m *MockRepository) GetUserBatch(ctx context.Context, afterID, limit int) ([]*domain.User, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var users []*domain.User
+	for _, user := range m.users {
+		if user.ID > afterID {
+			users = append(users, m.withTags(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+// GetUsersByID lists the in-memory users among ids, lowest ID first
+func (m *MockRepository) GetUsersByID(ctx context.Context, ids []int) ([]*domain.User, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var users []*domain.User
+	for _, id := range ids {
+		if user, exists := m.users[id]; exists {
+			users = append(users, m.withTags(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+// CreateUser adds a new user to the in-memory map and records a user.created event
 func (m *MockRepository) CreateUser(ctx context.Context, user domain.User) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	if err := m.checkEmailFree(user.Email, 0); err != nil {
+		return 0, err
+	}
+
 	// Assign an ID and timestamps
 	id := m.nextID
 	now := time.Now()
 
 	m.users[id] = &domain.User{
-		ID:        id,
-		Name:      user.Name,
-		Email:     user.Email,
-		CreatedAt: now,
-		UpdatedAt: now,
+		ID:           id,
+		Name:         user.Name,
+		Email:        user.Email,
+		CustomFields: maps.Clone(user.CustomFields),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		Version:      1,
 	}
 
 	m.nextID++
+	m.recordOutboxEvent(domain.EventUserCreated, domain.EntityUser, id, m.withTags(m.users[id]))
 	return id, nil
 }
 
+// UpdateUser saves the editable fields of an in-memory user and records a user.updated event
+func (m *MockRepository) UpdateUser(ctx context.Context, user domain.User) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	existing, exists := m.users[user.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if !versionMatches(existing.Version, user.Version) {
+		return ErrVersionConflict
+	}
+	if err := m.checkEmailFree(user.Email, user.ID); err != nil {
+		return err
+	}
+	existing.Name = user.Name
+	existing.Email = user.Email
+	existing.CustomFields = maps.Clone(user.CustomFields)
+	existing.UpdatedAt = time.Now()
+	existing.Version++
+	m.recordOutboxEvent(domain.EventUserUpdated, domain.EntityUser, user.ID, m.withTags(existing))
+	return nil
+}
+
+// checkEmailFree fails like the unique index on the email of live users when
+// a live user other than the one with ID id has the email address, in any
+// case. Trashed users are out of m.users, so they don't hold on to theirs.
+func (m *MockRepository) checkEmailFree(email string, id int) error {
+	for _, user := range m.users {
+		if strings.EqualFold(user.Email, email) && user.ID != id {
+			return fmt.Errorf("user email %q: %w", email, ErrDuplicate)
+		}
+	}
+	return nil
+}
+
+// DeleteUser erases and removes an in-memory user, live or trashed, and
+// unsets it as the owner of other users like ON DELETE SET NULL
+func (m *MockRepository) DeleteUser(ctx context.Context, id int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	m.untrash(domain.EntityUser, id)
+	if err := m.ErasePersonalData(ctx, domain.EntityUser, id); err != nil {
+		return err
+	}
+	for link := range m.tagLinks {
+		if link.entity == domain.EntityUser && link.entityID == id {
+			delete(m.tagLinks, link)
+		}
+	}
+	delete(m.users, id)
+	for _, user := range m.users {
+		if user.OwnerID != nil && *user.OwnerID == id {
+			user.OwnerID = nil
+		}
+	}
+	return nil
+}
+
 // ErrNotFound is used to simulate database not found errors
 var ErrNotFound = ErrorNotFound("record not found")
 
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/segment"
+)
+
+// TestMockRepository_Concurrent runs writes, reads and units of work from many
+// goroutines at once, for the race detector to check
+func TestMockRepository_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMockRepository()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, err := repo.CreateUser(ctx, domain.User{
+				Name: "Concurrent", Email: fmt.Sprintf("concurrent%d@example.com", i),
+				CustomFields: map[string]any{"n": i},
+			})
+			if err != nil {
+				t.Errorf("CreateUser: %v", err)
+				return
+			}
+			if _, err := repo.AddTags(ctx, domain.EntityUser, []int{id}, []string{"concurrent"}); err != nil {
+				t.Errorf("AddTags: %v", err)
+			}
+
+			// a failing unit of work leaves nothing behind, even with other
+			// goroutines writing meanwhile
+			err = repo.InTx(ctx, func(ctx context.Context) error {
+				user, err := repo.GetUser(ctx, id)
+				if err != nil {
+					return err
+				}
+				user.Name = "Renamed"
+				if err := repo.UpdateUser(ctx, *user); err != nil {
+					return err
+				}
+				return errors.New("roll back")
+			})
+			if err == nil {
+				t.Error("Expected the unit of work to fail")
+			}
+
+			user, err := repo.GetUser(ctx, id)
+			if err != nil {
+				t.Errorf("GetUser: %v", err)
+				return
+			}
+			if user.Name != "Concurrent" {
+				t.Errorf("Expected the rename to be rolled back, got %q", user.Name)
+			}
+			// callers get copies they may change
+			user.CustomFields["n"] = -1
+
+			if _, err := repo.GetUsers(ctx, domain.ListOptions{}); err != nil {
+				t.Errorf("GetUsers: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	users, err := repo.GetUsers(ctx, domain.ListOptions{})
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(users) != 20 {
+		t.Fatalf("Expected 20 users, got %d", len(users))
+	}
+	for _, user := range users {
+		if user.CustomFields["n"] == -1 || len(user.Tags) != 1 {
+			t.Errorf("Unexpected user %+v", user)
+		}
+	}
+}
+
+func TestMockRepository_DuplicateEmail(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMockRepository()
+
+	if _, err := repo.CreateUser(ctx, domain.User{Name: "Ada", Email: "ada@example.com"}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	id, err := repo.CreateUser(ctx, domain.User{Name: "Grace", Email: "grace@example.com"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if _, err := repo.CreateUser(ctx, domain.User{Name: "Ada", Email: "ada@example.com"}); err == nil {
+		t.Error("Expected a duplicate email to be rejected on create")
+	}
+	if err := repo.UpdateUser(ctx, domain.User{ID: id, Name: "Grace", Email: "ada@example.com"}); err == nil {
+		t.Error("Expected a duplicate email to be rejected on update")
+	}
+	if err := repo.UpdateUser(ctx, domain.User{ID: id, Name: "Grace Hopper", Email: "grace@example.com"}); err != nil {
+		t.Errorf("Expected a user to keep its own email, got %v", err)
+	}
+}
+
+func TestSeedFixtures(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMockRepository()
+
+	if err := SeedFixtures(ctx, repo); err != nil {
+		t.Fatalf("SeedFixtures: %v", err)
+	}
+
+	users, err := repo.GetUsers(ctx, domain.ListOptions{})
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(users) != len(fixtureUsers) {
+		t.Fatalf("Expected %d users, got %d", len(fixtureUsers), len(users))
+	}
+
+	segments, err := repo.GetSegments(ctx)
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("Expected the fixture segment, got %v, %v", segments, err)
+	}
+	expr, err := segment.Parse(segments[0].Expression, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to parse the fixture segment: %v", err)
+	}
+	count, err := repo.CountSegmentUsers(ctx, expr)
+	if err != nil {
+		t.Fatalf("CountSegmentUsers: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected the segment to match 2 users, got %d", count)
+	}
+}
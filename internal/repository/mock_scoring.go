@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreateScoringRule adds a scoring rule to the in-memory map
+func (m *MockRepository) CreateScoringRule(ctx context.Context, rule domain.ScoringRule) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	id := m.nextScoringRule
+	now := time.Now()
+
+	rule.ID = id
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	rule.Version = 1
+	m.scoringRules[id] = &rule
+
+	m.nextScoringRule++
+	return id, nil
+}
+
+// GetScoringRules lists the in-memory scoring rules for an entity ordered by ID
+func (m *MockRepository) GetScoringRules(ctx context.Context, entity string) ([]*domain.ScoringRule, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var rules []*domain.ScoringRule
+	for _, rule := range m.scoringRules {
+		if rule.Entity == entity {
+			copied := *rule
+			rules = append(rules, &copied)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].ID < rules[j].ID
+	})
+	return rules, nil
+}
+
+// GetScoringRule retrieves an in-memory scoring rule by ID
+func (m *MockRepository) GetScoringRule(ctx context.Context, id int) (*domain.ScoringRule, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	rule, exists := m.scoringRules[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	copied := *rule
+	return &copied, nil
+}
+
+// DeleteScoringRule removes a scoring rule from the in-memory map
+func (m *MockRepository) DeleteScoringRule(ctx context.Context, id int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	m.untrash(domain.AuditScoringRule, id)
+	if _, exists := m.scoringRules[id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.scoringRules, id)
+	return nil
+}
+
+// UpdateUserScore stores the score on the in-memory user
+func (m *MockRepository) UpdateUserScore(ctx context.Context, id int, score int, breakdown []domain.ScoreContribution) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	user, exists := m.users[id]
+	if !exists {
+		return ErrNotFound
+	}
+	user.Score = score
+	user.ScoreBreakdown = breakdown
+	return nil
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// Search does a case-insensitive substring match over the in-memory users,
+// ranking name matches above email matches like the weighted tsvector does
+func (m *MockRepository) Search(ctx context.Context, query string, limit int) ([]*domain.SearchResult, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	needle := strings.ToLower(strings.TrimSpace(query))
+	results := []*domain.SearchResult{}
+	if needle == "" {
+		return results, nil
+	}
+
+	for _, user := range m.users {
+		var rank float64
+		var snippet string
+		switch {
+		case strings.Contains(strings.ToLower(user.Name), needle):
+			rank = 1
+			snippet = highlight(user.Name, needle) + " " + user.Email
+		case strings.Contains(strings.ToLower(user.Email), needle):
+			rank = 0.5
+			snippet = user.Name + " " + highlight(user.Email, needle)
+		default:
+			continue
+		}
+		results = append(results, &domain.SearchResult{
+			Type:    "user",
+			ID:      user.ID,
+			Title:   user.Name,
+			Snippet: snippet,
+			Rank:    rank,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].ID > results[j].ID
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// highlight wraps the first case-insensitive occurrence of needle in <mark> tags
+func highlight(text, needle string) string {
+	idx := strings.Index(strings.ToLower(text), needle)
+	if idx < 0 {
+		return text
+	}
+	end := idx + len(needle)
+	return text[:idx] + "<mark>" + text[idx:end] + "</mark>" + text[end:]
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/segment"
+)
+
+// CreateSegment saves a segment in the in-memory map
+func (m *MockRepository) CreateSegment(ctx context.Context, seg domain.Segment) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	id := m.nextSegment
+	now := time.Now()
+
+	seg.ID = id
+	seg.CreatedAt = now
+	seg.UpdatedAt = now
+	seg.Version = 1
+	m.segments[id] = &seg
+
+	m.nextSegment++
+	return id, nil
+}
+
+// GetSegment retrieves a segment by ID from the in-memory map
+func (m *MockRepository) GetSegment(ctx context.Context, id int) (*domain.Segment, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	seg, exists := m.segments[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	copied := *seg
+	return &copied, nil
+}
+
+// GetSegments lists the in-memory segments ordered by name
+func (m *MockRepository) GetSegments(ctx context.Context) ([]*domain.Segment, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	segments := make([]*domain.Segment, 0, len(m.segments))
+	for _, seg := range m.segments {
+		copied := *seg
+		segments = append(segments, &copied)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		if segments[i].Name != segments[j].Name {
+			return segments[i].Name < segments[j].Name
+		}
+		return segments[i].ID < segments[j].ID
+	})
+	return segments, nil
+}
+
+// DeleteSegment removes a segment from the in-memory map
+func (m *MockRepository) DeleteSegment(ctx context.Context, id int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	m.untrash(domain.AuditSegment, id)
+	if _, exists := m.segments[id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.segments, id)
+	return nil
+}
+
+// GetSegmentUsers evaluates the expression against every user, newest first
+func (m *MockRepository) GetSegmentUsers(ctx context.Context, expr segment.Expr) ([]*domain.User, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	users := []*domain.User{}
+	for _, user := range m.users {
+		tagged := m.withTags(user)
+		if segment.Match(expr, userRecord(tagged)) {
+			users = append(users, tagged)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID > users[j].ID
+	})
+	if len(users) > 100 {
+		users = users[:100]
+	}
+	return users, nil
+}
+
+// CountSegmentUsers counts the users matching the expression
+func (m *MockRepository) CountSegmentUsers(ctx context.Context, expr segment.Expr) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	count := 0
+	for _, user := range m.users {
+		if segment.Match(expr, userRecord(m.withTags(user))) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// userRecord adapts a user for in-memory segment evaluation
+func userRecord(user *domain.User) segment.Record {
+	return segment.Record{
+		Name:         user.Name,
+		Email:        user.Email,
+		Tags:         user.Tags,
+		CustomFields: user.CustomFields,
+		CreatedAt:    user.CreatedAt,
+	}
+}
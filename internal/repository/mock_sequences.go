@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreateSequence adds a sequence to the in-memory map
+func (m *MockRepository) CreateSequence(ctx context.Context, sequence domain.Sequence) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	id := m.nextSequence
+	now := time.Now()
+
+	sequence.ID = id
+	sequence.CreatedAt = now
+	sequence.UpdatedAt = now
+	sequence.Version = 1
+	m.sequences[id] = &sequence
+
+	m.nextSequence++
+	return id, nil
+}
+
+// GetSequences lists the in-memory sequences ordered by ID
+func (m *MockRepository) GetSequences(ctx context.Context) ([]*domain.Sequence, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var sequences []*domain.Sequence
+	for id := 1; id < m.nextSequence; id++ {
+		if sequence, exists := m.sequences[id]; exists {
+			copied := *sequence
+			sequences = append(sequences, &copied)
+		}
+	}
+	return sequences, nil
+}
+
+// GetSequence retrieves an in-memory sequence by ID
+func (m *MockRepository) GetSequence(ctx context.Context, id int) (*domain.Sequence, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	sequence, exists := m.sequences[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	copied := *sequence
+	return &copied, nil
+}
+
+// DeleteSequence removes a sequence and its enrollments from memory
+func (m *MockRepository) DeleteSequence(ctx context.Context, id int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	m.untrash(domain.AuditSequence, id)
+	if _, exists := m.sequences[id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.sequences, id)
+
+	enrollments := m.enrollments[:0]
+	for _, enrollment := range m.enrollments {
+		if enrollment.SequenceID != id {
+			enrollments = append(enrollments, enrollment)
+		}
+	}
+	m.enrollments = enrollments
+	return nil
+}
+
+// GetSequenceStats counts the in-memory enrollments by sequence and status
+func (m *MockRepository) GetSequenceStats(ctx context.Context) (map[int]*domain.SequenceStats, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	stats := map[int]*domain.SequenceStats{}
+	for _, enrollment := range m.enrollments {
+		if stats[enrollment.SequenceID] == nil {
+			stats[enrollment.SequenceID] = &domain.SequenceStats{}
+		}
+		addEnrollmentCount(stats[enrollment.SequenceID], enrollment.Status, 1)
+	}
+	return stats, nil
+}
+
+// EnrollInSequence enrolls existing in-memory users not already active in the sequence
+func (m *MockRepository) EnrollInSequence(ctx context.Context, sequenceID int, entity string, ids []int, startAt time.Time) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	enrolled := 0
+	for _, id := range ids {
+		if _, exists := m.users[id]; !exists || m.activeEnrollment(sequenceID, entity, id) {
+			continue
+		}
+		now := time.Now()
+		m.enrollments = append(m.enrollments, &domain.SequenceEnrollment{
+			ID:         m.nextEnrollment,
+			SequenceID: sequenceID,
+			Entity:     entity,
+			EntityID:   id,
+			Status:     domain.EnrollmentActive,
+			NextRunAt:  startAt,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		})
+		m.nextEnrollment++
+		enrolled++
+	}
+	return enrolled, nil
+}
+
+// activeEnrollment reports whether a record is active in an in-memory sequence
+func (m *MockRepository) activeEnrollment(sequenceID int, entity string, id int) bool {
+	for _, enrollment := range m.enrollments {
+		if enrollment.SequenceID == sequenceID && enrollment.Entity == entity && enrollment.EntityID == id &&
+			enrollment.Status == domain.EnrollmentActive {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSequenceEnrollments lists a sequence's in-memory enrollments, newest first
+func (m *MockRepository) GetSequenceEnrollments(ctx context.Context, sequenceID int) ([]*domain.SequenceEnrollment, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var enrollments []*domain.SequenceEnrollment
+	for i := len(m.enrollments) - 1; i >= 0; i-- {
+		if m.enrollments[i].SequenceID == sequenceID {
+			copied := *m.enrollments[i]
+			enrollments = append(enrollments, &copied)
+		}
+	}
+	return enrollments, nil
+}
+
+// StopEnrollments ends active in-memory enrollments, in every sequence when sequenceID is 0
+func (m *MockRepository) StopEnrollments(ctx context.Context, sequenceID int, entity string, ids []int, status string) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	stopped := 0
+	for _, enrollment := range m.enrollments {
+		if enrollment.Status != domain.EnrollmentActive || enrollment.Entity != entity || !slices.Contains(ids, enrollment.EntityID) {
+			continue
+		}
+		if sequenceID != 0 && enrollment.SequenceID != sequenceID {
+			continue
+		}
+		enrollment.Status = status
+		enrollment.UpdatedAt = time.Now()
+		stopped++
+	}
+	return stopped, nil
+}
+
+// ClaimSequenceEnrollments picks due in-memory enrollments and leases them
+func (m *MockRepository) ClaimSequenceEnrollments(ctx context.Context, now, leaseUntil time.Time, limit int) ([]*domain.SequenceEnrollment, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var claimed []*domain.SequenceEnrollment
+	for _, enrollment := range m.enrollments {
+		if len(claimed) == limit {
+			break
+		}
+		if enrollment.Status != domain.EnrollmentActive || enrollment.NextRunAt.After(now) {
+			continue
+		}
+		if _, live := m.sequences[enrollment.SequenceID]; !live {
+			continue
+		}
+		enrollment.Attempts++
+		enrollment.NextRunAt = leaseUntil
+		enrollment.UpdatedAt = now
+		copied := *enrollment
+		claimed = append(claimed, &copied)
+	}
+	return claimed, nil
+}
+
+// FinishSequenceStep stores an in-memory enrollment's progress unless it was stopped meanwhile
+func (m *MockRepository) FinishSequenceStep(ctx context.Context, enrollment domain.SequenceEnrollment) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	for _, existing := range m.enrollments {
+		if existing.ID != enrollment.ID || existing.Status != domain.EnrollmentActive {
+			continue
+		}
+		existing.Status = enrollment.Status
+		existing.Step = enrollment.Step
+		existing.Attempts = enrollment.Attempts
+		existing.NextRunAt = enrollment.NextRunAt
+		existing.Error = enrollment.Error
+		existing.UpdatedAt = time.Now()
+	}
+	return nil
+}
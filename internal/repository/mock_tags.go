@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// tagLink mirrors a row of the entity_tags table
+type tagLink struct {
+	tagID    int
+	entity   string
+	entityID int
+}
+
+// GetTags lists the in-memory tag registry with usage counts, ordered by name
+func (m *MockRepository) GetTags(ctx context.Context) ([]*domain.Tag, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	counts := make(map[int]int)
+	for link := range m.tagLinks {
+		counts[link.tagID]++
+	}
+
+	tags := make([]*domain.Tag, 0, len(m.tags))
+	for _, tag := range m.tags {
+		copied := *tag
+		copied.UsageCount = counts[tag.ID]
+		tags = append(tags, &copied)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
+
+// CreateTag registers a tag, returning the existing ID when the name is taken
+func (m *MockRepository) CreateTag(ctx context.Context, name string) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	if tag, exists := m.tags[name]; exists {
+		return tag.ID, nil
+	}
+	tag := &domain.Tag{ID: m.nextTagID, Name: name, CreatedAt: time.Now()}
+	m.tags[name] = tag
+	m.nextTagID++
+	return tag.ID, nil
+}
+
+// AddTags registers new tags and links them to the users that exist
+func (m *MockRepository) AddTags(ctx context.Context, entity string, ids []int, tags []string) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	if entity != domain.EntityUser {
+		return 0, fmt.Errorf("failed to add tags: unknown entity %q", entity)
+	}
+
+	added := 0
+	tagged := map[int]bool{}
+	for _, name := range tags {
+		tagID, _ := m.CreateTag(ctx, name)
+		for _, id := range ids {
+			if _, exists := m.users[id]; !exists {
+				continue
+			}
+			link := tagLink{tagID: tagID, entity: entity, entityID: id}
+			if !m.tagLinks[link] {
+				m.tagLinks[link] = true
+				tagged[id] = true
+				added++
+			}
+		}
+	}
+	m.bumpUserVersions(tagged)
+	return added, nil
+}
+
+// RemoveTags unlinks the tags from the records, leaving the registry alone
+func (m *MockRepository) RemoveTags(ctx context.Context, entity string, ids []int, tags []string) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	removed := 0
+	untagged := map[int]bool{}
+	for _, name := range tags {
+		tag, exists := m.tags[name]
+		if !exists {
+			continue
+		}
+		for _, id := range ids {
+			link := tagLink{tagID: tag.ID, entity: entity, entityID: id}
+			if m.tagLinks[link] {
+				delete(m.tagLinks, link)
+				if entity == domain.EntityUser {
+					untagged[id] = true
+				}
+				removed++
+			}
+		}
+	}
+	m.bumpUserVersions(untagged)
+	return removed, nil
+}
+
+// bumpUserVersions gives each of the in-memory users a new version
+func (m *MockRepository) bumpUserVersions(ids map[int]bool) {
+	for id := range ids {
+		if user, exists := m.users[id]; exists {
+			user.Version++
+		}
+	}
+}
+
+// withTags returns a copy of the user with its tag names filled in. The
+// custom fields are copied too, callers may change them.
+func (m *MockRepository) withTags(user *domain.User) *domain.User {
+	copied := *user
+	copied.CustomFields = maps.Clone(user.CustomFields)
+	copied.Tags = nil
+	for name, tag := range m.tags {
+		if m.tagLinks[tagLink{tagID: tag.ID, entity: domain.EntityUser, entityID: user.ID}] {
+			copied.Tags = append(copied.Tags, name)
+		}
+	}
+	sort.Strings(copied.Tags)
+	return &copied
+}
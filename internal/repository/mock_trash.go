@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// trashKey identifies a record in the in-memory trash
+type trashKey struct {
+	entity string
+	id     int
+}
+
+// trashedRecord is a record moved out of its map while it is in the trash,
+// restore puts it back
+type trashedRecord struct {
+	item    domain.TrashItem
+	record  any
+	restore func()
+}
+
+// trashRecord moves a record out of records, which leaves it out of every
+// read, and into the trash. describe returns the record's name and a pointer
+// to its version.
+func trashRecord[T any](m *MockRepository, records map[int]*T, entity string, id, version int, deletedBy string, describe func(*T) (string, *int)) error {
+	record, exists := records[id]
+	if !exists {
+		return ErrNotFound
+	}
+	name, current := describe(record)
+	if !versionMatches(*current, version) {
+		return ErrVersionConflict
+	}
+	*current++
+	delete(records, id)
+	m.trash[trashKey{entity, id}] = &trashedRecord{
+		item: domain.TrashItem{
+			Entity:    entity,
+			ID:        id,
+			Name:      name,
+			DeletedAt: time.Now(),
+			DeletedBy: deletedBy,
+		},
+		record: record,
+		restore: func() {
+			records[id] = record
+			*current++
+		},
+	}
+	return nil
+}
+
+// TrashRecord moves an in-memory record to the trash
+func (m *MockRepository) TrashRecord(ctx context.Context, entity string, id, version int, deletedBy string) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	switch entity {
+	case domain.EntityUser:
+		return trashRecord(m, m.users, entity, id, version, deletedBy, func(u *domain.User) (string, *int) { return u.Name, &u.Version })
+	case domain.AuditCustomField:
+		return trashRecord(m, m.customFields, entity, id, version, deletedBy, func(d *domain.CustomFieldDefinition) (string, *int) { return d.Label, &d.Version })
+	case domain.AuditSegment:
+		return trashRecord(m, m.segments, entity, id, version, deletedBy, func(s *domain.Segment) (string, *int) { return s.Name, &s.Version })
+	case domain.AuditScoringRule:
+		return trashRecord(m, m.scoringRules, entity, id, version, deletedBy, func(r *domain.ScoringRule) (string, *int) { return r.Name, &r.Version })
+	case domain.AuditAssignmentRule:
+		return trashRecord(m, m.assignmentRules, entity, id, version, deletedBy, func(r *domain.AssignmentRule) (string, *int) { return r.Name, &r.Version })
+	case domain.AuditAutomation:
+		return trashRecord(m, m.automations, entity, id, version, deletedBy, func(a *domain.Automation) (string, *int) { return a.Name, &a.Version })
+	case domain.AuditWebhook:
+		return trashRecord(m, m.webhooks, entity, id, version, deletedBy, func(w *domain.WebhookSubscription) (string, *int) { return w.URL, &w.Version })
+	case domain.AuditForm:
+		return trashRecord(m, m.forms, entity, id, version, deletedBy, func(f *domain.Form) (string, *int) { return f.Name, &f.Version })
+	case domain.AuditEmailTemplate:
+		return trashRecord(m, m.emailTemplates, entity, id, version, deletedBy, func(t *domain.EmailTemplate) (string, *int) { return t.Name, &t.Version })
+	case domain.AuditSequence:
+		return trashRecord(m, m.sequences, entity, id, version, deletedBy, func(s *domain.Sequence) (string, *int) { return s.Name, &s.Version })
+	}
+	return fmt.Errorf("%s records can't be trashed", entity)
+}
+
+// untrash puts a trashed record back in its map and reports whether it was
+// trashed. The DeleteX methods call it first so they purge trashed records
+// like the database does.
+func (m *MockRepository) untrash(entity string, id int) bool {
+	key := trashKey{entity, id}
+	trashed, exists := m.trash[key]
+	if !exists {
+		return false
+	}
+	trashed.restore()
+	delete(m.trash, key)
+	return true
+}
+
+// RestoreRecord takes an in-memory record back out of the trash, failing like
+// the unique indexes on live rows when a live record took its key meanwhile
+func (m *MockRepository) RestoreRecord(ctx context.Context, entity string, id int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	trashed, exists := m.trash[trashKey{entity, id}]
+	if !exists {
+		return ErrNotFound
+	}
+	switch record := trashed.record.(type) {
+	case *domain.User:
+		if err := m.checkEmailFree(record.Email, record.ID); err != nil {
+			return err
+		}
+	case *domain.CustomFieldDefinition:
+		if err := m.checkCustomFieldKeyFree(record.Entity, record.Key, record.ID); err != nil {
+			return err
+		}
+	}
+	m.untrash(entity, id)
+	return nil
+}
+
+// GetTrashItem retrieves an in-memory trashed record
+func (m *MockRepository) GetTrashItem(ctx context.Context, entity string, id int) (*domain.TrashItem, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	trashed, exists := m.trash[trashKey{entity, id}]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	item := trashed.item
+	return &item, nil
+}
+
+// GetTrash lists the in-memory trash, newest first
+func (m *MockRepository) GetTrash(ctx context.Context, entity string, before *time.Time) ([]*domain.TrashItem, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var items []*domain.TrashItem
+	for key, trashed := range m.trash {
+		if entity != "" && key.entity != entity {
+			continue
+		}
+		if before != nil && !trashed.item.DeletedAt.Before(*before) {
+			continue
+		}
+		item := trashed.item
+		items = append(items, &item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].DeletedAt.Equal(items[j].DeletedAt) {
+			return items[i].DeletedAt.After(items[j].DeletedAt)
+		}
+		if items[i].Entity != items[j].Entity {
+			return items[i].Entity < items[j].Entity
+		}
+		return items[i].ID < items[j].ID
+	})
+	return items, nil
+}
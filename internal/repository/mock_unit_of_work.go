@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"maps"
+)
+
+// InTx runs fn against the in-memory store and, when it fails, puts
+// everything back as it was, like a rollback. Nested calls do the same for
+// their own part. The store stays locked until fn returns, so fn runs once:
+// there is nothing to conflict with.
+func (m *MockRepository) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	rollback := m.snapshot()
+	if err := fn(ctx); err != nil {
+		rollback()
+		return err
+	}
+	return nil
+}
+
+type mockLockKey struct{}
+
+// lock locks the store for a call made with ctx. It returns the context to
+// pass on to the calls made while holding the lock, which don't take it
+// again, and the func releasing it.
+func (m *MockRepository) lock(ctx context.Context) (context.Context, func()) {
+	if holder, _ := ctx.Value(mockLockKey{}).(*MockRepository); holder == m {
+		return ctx, func() {}
+	}
+	m.mu.Lock()
+	return context.WithValue(ctx, mockLockKey{}, m), m.mu.Unlock
+}
+
+// snapshot copies the whole store and returns a func that restores the copy.
+// Records are copied since writes change them in place. The maps are refilled
+// rather than replaced since trashed records restore themselves into the map
+// they came from.
+func (m *MockRepository) snapshot() func() {
+	saved := m.mockStore
+	saved.users = clonePointers(m.users)
+	saved.customFields = clonePointers(m.customFields)
+	saved.tags = clonePointers(m.tags)
+	saved.tagLinks = maps.Clone(m.tagLinks)
+	saved.segments = clonePointers(m.segments)
+	saved.scoringRules = clonePointers(m.scoringRules)
+	saved.assignmentRules = clonePointers(m.assignmentRules)
+	saved.assignmentTurns = maps.Clone(m.assignmentTurns)
+	saved.assignments = cloneElements(m.assignments)
+	saved.automations = clonePointers(m.automations)
+	saved.automationRuns = cloneElements(m.automationRuns)
+	saved.outbox = cloneElements(m.outbox)
+	saved.outboxProcessed = maps.Clone(m.outboxProcessed)
+	saved.webhooks = clonePointers(m.webhooks)
+	saved.webhookDeliveries = cloneElements(m.webhookDeliveries)
+	saved.forms = clonePointers(m.forms)
+	saved.formSubmissions = cloneElements(m.formSubmissions)
+	saved.emailTemplates = clonePointers(m.emailTemplates)
+	saved.emails = cloneElements(m.emails)
+	saved.emailEvents = cloneElements(m.emailEvents)
+	saved.emailAttachments = cloneElements(m.emailAttachments)
+	saved.activities = cloneElements(m.activities)
+	saved.sequences = clonePointers(m.sequences)
+	saved.enrollments = cloneElements(m.enrollments)
+	saved.consents = cloneElements(m.consents)
+	saved.suppressions = cloneElements(m.suppressions)
+	saved.privacyRequests = clonePointers(m.privacyRequests)
+	saved.auditEvents = cloneElements(m.auditEvents)
+	saved.fieldHistory = cloneElements(m.fieldHistory)
+	saved.trash = clonePointers(m.trash)
+	saved.idempotencyKeys = clonePointers(m.idempotencyKeys)
+
+	return func() {
+		saved.users = refill(m.users, saved.users)
+		saved.customFields = refill(m.customFields, saved.customFields)
+		saved.tags = refill(m.tags, saved.tags)
+		saved.tagLinks = refill(m.tagLinks, saved.tagLinks)
+		saved.segments = refill(m.segments, saved.segments)
+		saved.scoringRules = refill(m.scoringRules, saved.scoringRules)
+		saved.assignmentRules = refill(m.assignmentRules, saved.assignmentRules)
+		saved.assignmentTurns = refill(m.assignmentTurns, saved.assignmentTurns)
+		saved.automations = refill(m.automations, saved.automations)
+		saved.outboxProcessed = refill(m.outboxProcessed, saved.outboxProcessed)
+		saved.webhooks = refill(m.webhooks, saved.webhooks)
+		saved.forms = refill(m.forms, saved.forms)
+		saved.emailTemplates = refill(m.emailTemplates, saved.emailTemplates)
+		saved.sequences = refill(m.sequences, saved.sequences)
+		saved.privacyRequests = refill(m.privacyRequests, saved.privacyRequests)
+		saved.trash = refill(m.trash, saved.trash)
+		saved.idempotencyKeys = refill(m.idempotencyKeys, saved.idempotencyKeys)
+		m.mockStore = saved
+	}
+}
+
+// clonePointers copies a map along with the values its pointers point to
+func clonePointers[K comparable, V any](src map[K]*V) map[K]*V {
+	dst := make(map[K]*V, len(src))
+	for key, value := range src {
+		copied := *value
+		dst[key] = &copied
+	}
+	return dst
+}
+
+// cloneElements copies a slice along with the values its pointers point to
+func cloneElements[V any](src []*V) []*V {
+	dst := make([]*V, len(src))
+	for i, value := range src {
+		copied := *value
+		dst[i] = &copied
+	}
+	return dst
+}
+
+// refill replaces the contents of dst with those of src and returns dst
+func refill[K comparable, V any](dst, src map[K]V) map[K]V {
+	clear(dst)
+	maps.Copy(dst, src)
+	return dst
+}
@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// recordOutboxEvent appends an event to the in-memory outbox
+func (m *MockRepository) recordOutboxEvent(event, entity string, entityID int, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		data = []byte("null")
+	}
+	m.outbox = append(m.outbox, &domain.OutboxEvent{
+		ID:        len(m.outbox) + 1,
+		Event:     event,
+		Entity:    entity,
+		EntityID:  entityID,
+		Payload:   data,
+		CreatedAt: time.Now(),
+	})
+}
+
+// CreateWebhookSubscription adds a webhook subscription to the in-memory map
+func (m *MockRepository) CreateWebhookSubscription(ctx context.Context, subscription domain.WebhookSubscription) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	id := m.nextWebhook
+	now := time.Now()
+
+	subscription.ID = id
+	subscription.CreatedAt = now
+	subscription.UpdatedAt = now
+	subscription.Version = 1
+	m.webhooks[id] = &subscription
+
+	m.nextWebhook++
+	return id, nil
+}
+
+// GetWebhookSubscriptions lists the in-memory webhook subscriptions ordered by ID
+func (m *MockRepository) GetWebhookSubscriptions(ctx context.Context) ([]*domain.WebhookSubscription, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var subscriptions []*domain.WebhookSubscription
+	for id := 1; id < m.nextWebhook; id++ {
+		if subscription, exists := m.webhooks[id]; exists {
+			copied := *subscription
+			subscriptions = append(subscriptions, &copied)
+		}
+	}
+	return subscriptions, nil
+}
+
+// GetWebhookSubscription retrieves an in-memory webhook subscription by ID
+func (m *MockRepository) GetWebhookSubscription(ctx context.Context, id int) (*domain.WebhookSubscription, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	subscription, exists := m.webhooks[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	copied := *subscription
+	return &copied, nil
+}
+
+// DeleteWebhookSubscription removes a subscription and its deliveries from memory
+func (m *MockRepository) DeleteWebhookSubscription(ctx context.Context, id int) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	m.untrash(domain.AuditWebhook, id)
+	if _, exists := m.webhooks[id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.webhooks, id)
+
+	deliveries := m.webhookDeliveries[:0]
+	for _, delivery := range m.webhookDeliveries {
+		if delivery.SubscriptionID != id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	m.webhookDeliveries = deliveries
+	return nil
+}
+
+// FanOutOutbox queues in-memory deliveries for unprocessed outbox events
+func (m *MockRepository) FanOutOutbox(ctx context.Context, now time.Time, limit int) (int, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	processed := 0
+	for _, event := range m.outbox {
+		if processed == limit {
+			break
+		}
+		if m.outboxProcessed[event.ID] {
+			continue
+		}
+		for id := 1; id < m.nextWebhook; id++ {
+			subscription, exists := m.webhooks[id]
+			if !exists || !subscribedTo(subscription, event.Event) {
+				continue
+			}
+			m.webhookDeliveries = append(m.webhookDeliveries, &domain.WebhookDelivery{
+				ID:             m.nextWebhookDelivery,
+				SubscriptionID: id,
+				OutboxID:       event.ID,
+				Event:          event.Event,
+				Payload:        event.Payload,
+				Status:         domain.DeliveryPending,
+				NextAttemptAt:  now,
+				CreatedAt:      now,
+				UpdatedAt:      now,
+			})
+			m.nextWebhookDelivery++
+		}
+		m.outboxProcessed[event.ID] = true
+		processed++
+	}
+	return processed, nil
+}
+
+// subscribedTo reports whether a subscription lists the event or every event
+func subscribedTo(subscription *domain.WebhookSubscription, event string) bool {
+	for _, subscribed := range subscription.Events {
+		if subscribed == event || subscribed == domain.EventAll {
+			return true
+		}
+	}
+	return false
+}
+
+// ClaimWebhookDeliveries marks due in-memory deliveries as sending, oldest first
+func (m *MockRepository) ClaimWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]*domain.WebhookDelivery, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var claimed []*domain.WebhookDelivery
+	for _, delivery := range m.webhookDeliveries {
+		if len(claimed) == limit {
+			break
+		}
+		if delivery.Status != domain.DeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		if _, live := m.webhooks[delivery.SubscriptionID]; !live {
+			continue
+		}
+		delivery.Status = domain.DeliverySending
+		delivery.Attempts++
+		delivery.UpdatedAt = now
+		copied := *delivery
+		claimed = append(claimed, &copied)
+	}
+	return claimed, nil
+}
+
+// FinishWebhookDelivery stores the outcome of an in-memory delivery
+func (m *MockRepository) FinishWebhookDelivery(ctx context.Context, delivery domain.WebhookDelivery) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	for _, existing := range m.webhookDeliveries {
+		if existing.ID == delivery.ID {
+			existing.Status = delivery.Status
+			existing.ResponseCode = delivery.ResponseCode
+			existing.Error = delivery.Error
+			existing.NextAttemptAt = delivery.NextAttemptAt
+			existing.DeliveredAt = delivery.DeliveredAt
+			existing.UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// GetWebhookDeliveries lists the in-memory deliveries of a subscription, newest first
+func (m *MockRepository) GetWebhookDeliveries(ctx context.Context, subscriptionID int) ([]*domain.WebhookDelivery, error) {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	var deliveries []*domain.WebhookDelivery
+	for i := len(m.webhookDeliveries) - 1; i >= 0; i-- {
+		if delivery := m.webhookDeliveries[i]; delivery.SubscriptionID == subscriptionID {
+			copied := *delivery
+			deliveries = append(deliveries, &copied)
+		}
+	}
+	return deliveries, nil
+}
+
+// RedeliverWebhook queues an in-memory delivery to be sent again
+func (m *MockRepository) RedeliverWebhook(ctx context.Context, id int, now time.Time) error {
+	ctx, unlock := m.lock(ctx)
+	defer unlock()
+
+	for _, delivery := range m.webhookDeliveries {
+		if delivery.ID == id {
+			delivery.Status = domain.DeliveryPending
+			delivery.Attempts = 0
+			delivery.Error = ""
+			delivery.NextAttemptAt = now
+			delivery.UpdatedAt = now
+			return nil
+		}
+	}
+	return ErrNotFound
+}
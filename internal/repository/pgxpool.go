@@ -0,0 +1,333 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/config"
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// NewPostgresPool opens a native pgx pool sized by cfg
+func NewPostgresPool(cfg config.DBConfig) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse DSN: %w", err)
+	}
+	poolConfig.ConnConfig.RuntimeParams["application_name"] = applicationName
+	poolConfig.MaxConns = int32(cfg.MaxConns)
+	poolConfig.MinConns = int32(cfg.MinConns)
+	poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create pool: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("unable to connect to db: %w", err)
+	}
+	return pool, nil
+}
+
+// PoolRepository is a Repository on a native pgx pool. Lists are read without
+// database/sql in between, field history and bulk-created users are inserted
+// with COPY and erasures send all their statements in one batch, in a unit of
+// work too, on its connection. Everything else goes through the embedded
+// Repository on the same pool.
+type PoolRepository struct {
+	*Repository
+	pool *pgxpool.Pool
+}
+
+// Ensure PoolRepository implements Store
+var _ Store = (*PoolRepository)(nil)
+
+func NewPoolRepository(pool *pgxpool.Pool) *PoolRepository {
+	return &PoolRepository{
+		Repository: NewRepository(stdlib.OpenDBFromPool(pool)),
+		pool:       pool,
+	}
+}
+
+// Close closes the database/sql handle and then the pool under it
+func (p *PoolRepository) Close() error {
+	err := p.Repository.Close()
+	p.pool.Close()
+	return err
+}
+
+// GetUsers lists users matching the filters in opts. Reads going to a replica
+// take the database/sql path, replicas have no native pool.
+func (p *PoolRepository) GetUsers(ctx context.Context, opts domain.ListOptions) ([]*domain.User, error) {
+	if _, ok := p.unitOfWork(ctx); ok || p.replicaReads(ctx) {
+		return p.Repository.GetUsers(ctx, opts)
+	}
+
+	query, args, err := usersQuery(p.dialect, opts)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	defer rows.Close()
+	var users []*domain.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user row: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over user rows: %w", err)
+	}
+	return users, nil
+}
+
+// RecordFieldChanges copies the changes into field_history in one statement
+func (p *PoolRepository) RecordFieldChanges(ctx context.Context, changes []domain.FieldChange) error {
+	now := time.Now().UTC()
+	rows := make([][]any, 0, len(changes))
+	for _, change := range changes {
+		from, err := encodeFieldValue(change.From)
+		if err != nil {
+			return err
+		}
+		to, err := encodeFieldValue(change.To)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, []any{change.Entity, change.EntityID, change.Field, from, to, change.Actor, now})
+	}
+
+	copyChanges := func(q pgxQuerier) error {
+		_, err := q.CopyFrom(ctx, pgx.Identifier{"field_history"},
+			[]string{"entity", "entity_id", "field", "old_value", "new_value", "actor", "changed_at"},
+			pgx.CopyFromRows(rows))
+		if err != nil {
+			return fmt.Errorf("failed to record field changes: %w", err)
+		}
+		return nil
+	}
+	// one COPY needs no transaction of its own
+	if _, ok := p.unitOfWork(ctx); ok {
+		return p.inPgxTx(ctx, copyChanges)
+	}
+	return copyChanges(p.pool)
+}
+
+// pgxQuerier is a pgx pool, connection or transaction
+type pgxQuerier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, rows pgx.CopyFromSource) (int64, error)
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+// inPgxTx runs fn in a transaction on the pool, or in a unit of work on the
+// native connection under it, in a savepoint of the transaction already open
+// there. The unit of work's sql.Tx isn't used until fn returns.
+func (p *PoolRepository) inPgxTx(ctx context.Context, fn func(q pgxQuerier) error) error {
+	conn, ok := p.unitOfWorkConn(ctx)
+	if !ok {
+		return pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error { return fn(tx) })
+	}
+	return conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+		if _, err := pgxConn.Exec(ctx, `SAVEPOINT unit_of_work`); err != nil {
+			return fmt.Errorf("failed to create savepoint: %w", err)
+		}
+		if err := fn(pgxConn); err != nil {
+			if _, rollbackErr := pgxConn.Exec(ctx, `ROLLBACK TO SAVEPOINT unit_of_work`); rollbackErr != nil {
+				return errors.Join(err, fmt.Errorf("failed to roll back savepoint: %w", rollbackErr))
+			}
+			return err
+		}
+		if _, err := pgxConn.Exec(ctx, `RELEASE SAVEPOINT unit_of_work`); err != nil {
+			return fmt.Errorf("failed to release savepoint: %w", err)
+		}
+		return nil
+	})
+}
+
+// WriteUsers copies a batch that only creates users into the table with COPY,
+// with their user.created events and tags, in one transaction. Batches with
+// other writes, and batches COPY rejects because an email is taken, are
+// written row by row by the embedded Repository, which reports each write's
+// error.
+func (p *PoolRepository) WriteUsers(ctx context.Context, writes []domain.UserWrite, atomic bool) ([]error, error) {
+	for _, write := range writes {
+		if write.Op != domain.BulkCreate {
+			return p.Repository.WriteUsers(ctx, writes, atomic)
+		}
+	}
+
+	var ids []int
+	err := p.inPgxTx(ctx, func(q pgxQuerier) error {
+		var err error
+		ids, err = copyUsers(ctx, q, writes)
+		return err
+	})
+	if uniqueViolation(err) {
+		return p.Repository.WriteUsers(ctx, writes, atomic)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range writes {
+		writes[i].User.ID = ids[i]
+	}
+	return make([]error, len(writes)), nil
+}
+
+// copyUsers inserts the users created by writes with COPY, after taking IDs
+// for them from the users sequence, then their user.created events and their
+// tags. It returns the IDs in the order of writes.
+func copyUsers(ctx context.Context, q pgxQuerier, writes []domain.UserWrite) ([]int, error) {
+	rows, err := q.Query(ctx,
+		`SELECT nextval(pg_get_serial_sequence('users', 'id')) FROM generate_series(1, $1) ORDER BY 1`, len(writes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve user IDs: %w", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve user IDs: %w", err)
+	}
+
+	now := time.Now()
+	users := make([][]any, len(writes))
+	events := make([][]any, len(writes))
+	var taggedIDs []int
+	var tags []string
+	for i, write := range writes {
+		customFields, err := encodeCustomFields(write.User.CustomFields)
+		if err != nil {
+			return nil, err
+		}
+		users[i] = []any{ids[i], write.User.Name, write.User.Email, customFields, now, now}
+
+		payload, err := json.Marshal(domain.User{
+			ID:           ids[i],
+			Name:         write.User.Name,
+			Email:        write.User.Email,
+			CustomFields: write.User.CustomFields,
+			Tags:         write.User.Tags,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+			Version:      1,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s event: %w", domain.EventUserCreated, err)
+		}
+		events[i] = []any{domain.EventUserCreated, domain.EntityUser, ids[i], payload, now}
+
+		for _, tag := range write.User.Tags {
+			taggedIDs = append(taggedIDs, ids[i])
+			tags = append(tags, tag)
+		}
+	}
+
+	if _, err := q.CopyFrom(ctx, pgx.Identifier{"users"},
+		[]string{"id", "name", "email", "custom_fields", "created_at", "updated_at"},
+		pgx.CopyFromRows(users)); err != nil {
+		return nil, fmt.Errorf("failed to copy users: %w", err)
+	}
+	if _, err := q.CopyFrom(ctx, pgx.Identifier{"outbox"},
+		[]string{"event", "entity", "entity_id", "payload", "created_at"},
+		pgx.CopyFromRows(events)); err != nil {
+		return nil, fmt.Errorf("failed to record %s events: %w", domain.EventUserCreated, err)
+	}
+
+	if len(tags) > 0 {
+		if _, err := q.Exec(ctx, `
+		INSERT INTO tags (name, created_at)
+		SELECT DISTINCT unnest($1::text[]), $2::timestamp
+		ON CONFLICT (name) DO NOTHING
+		`, tags, now); err != nil {
+			return nil, fmt.Errorf("failed to register tags: %w", err)
+		}
+		if _, err := q.Exec(ctx, `
+		INSERT INTO entity_tags (tag_id, entity, entity_id, created_at)
+		SELECT t.id, $1, u.id, $2
+		FROM unnest($3::int[], $4::text[]) AS u(id, name)
+		JOIN tags t ON t.name = u.name
+		ON CONFLICT DO NOTHING
+		`, domain.EntityUser, now, taggedIDs, tags); err != nil {
+			return nil, fmt.Errorf("failed to tag users: %w", err)
+		}
+	}
+	return ids, nil
+}
+
+// ErasePersonalData anonymizes a record and everything logged about it in
+// one transaction and one round trip
+func (p *PoolRepository) ErasePersonalData(ctx context.Context, entity string, entityID int) error {
+	return p.erase(ctx, entity, entityID)
+}
+
+// DeleteUser erases a user and then deletes it for good, in one transaction
+// and one round trip
+func (p *PoolRepository) DeleteUser(ctx context.Context, id int) error {
+	return p.erase(ctx, domain.EntityUser, id,
+		batchStatement{"user tags", `DELETE FROM entity_tags WHERE entity = $1 AND entity_id = $2`, []any{domain.EntityUser, id}},
+		batchStatement{"user", `DELETE FROM users WHERE id = $1`, []any{id}})
+}
+
+// batchStatement is a statement queued after an erasure
+type batchStatement struct {
+	what  string
+	query string
+	args  []any
+}
+
+// erase sends the statements of an erasure, followed by then, as one batch
+// in a transaction. Nothing is changed unless the record exists.
+func (p *PoolRepository) erase(ctx context.Context, entity string, entityID int, then ...batchStatement) error {
+	batch := &pgx.Batch{}
+	batch.Queue(eraseRecord, time.Now(), entityID)
+	for _, statement := range erasures {
+		batch.Queue(statement.query, entity, entityID)
+	}
+	for _, statement := range then {
+		batch.Queue(statement.query, statement.args...)
+	}
+
+	return p.inPgxTx(ctx, func(q pgxQuerier) error {
+		results := q.SendBatch(ctx, batch)
+		defer results.Close()
+
+		tag, err := results.Exec()
+		if err != nil {
+			return fmt.Errorf("failed to erase record: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("record not found: %w", ErrNotFound)
+		}
+		for _, statement := range erasures {
+			if _, err := results.Exec(); err != nil {
+				return fmt.Errorf("failed to erase %s: %w", statement.what, err)
+			}
+		}
+		for _, statement := range then {
+			if _, err := results.Exec(); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", statement.what, err)
+			}
+		}
+		return results.Close()
+	})
+}
@@ -0,0 +1,305 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/config"
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// newTestPoolRepository opens a PoolRepository on the test database
+func newTestPoolRepository(tb testing.TB) *PoolRepository {
+	tb.Helper()
+	if testDriver != config.DriverPostgres {
+		tb.Skip("The pgxpool driver needs Postgres; set REPO_TESTS=true to run")
+	}
+	pool, err := NewPostgresPool(testDBConfig())
+	if err != nil {
+		tb.Fatalf("Failed to open pool: %v", err)
+	}
+	repo := NewPoolRepository(pool)
+	tb.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+// seedUsers creates n users and returns their IDs
+func seedUsers(tb testing.TB, n int) []int {
+	tb.Helper()
+	ctx := context.Background()
+	prefix := time.Now().UnixNano()
+	ids := make([]int, n)
+	for i := range ids {
+		id, err := testRepo.CreateUser(ctx, domain.User{
+			Name:         fmt.Sprintf("Seeded %d", i),
+			Email:        fmt.Sprintf("seeded-%d-%d@example.com", prefix, i),
+			CustomFields: map[string]any{"budget": float64(i)},
+		})
+		if err != nil {
+			tb.Fatalf("Failed to create user: %v", err)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+// userCreates builds n writes creating users with unique emails
+func userCreates(n int) []domain.UserWrite {
+	prefix := time.Now().UnixNano()
+	writes := make([]domain.UserWrite, n)
+	for i := range writes {
+		writes[i] = domain.UserWrite{Op: domain.BulkCreate, User: domain.User{
+			Name:         fmt.Sprintf("Imported %d", i),
+			Email:        fmt.Sprintf("imported-%d-%d@example.com", prefix, i),
+			CustomFields: map[string]any{"budget": float64(i)},
+		}}
+	}
+	return writes
+}
+
+// fieldChanges builds n changes to a record's budget
+func fieldChanges(entityID, n int) []domain.FieldChange {
+	changes := make([]domain.FieldChange, n)
+	for i := range changes {
+		changes[i] = domain.FieldChange{
+			Entity:   domain.EntityUser,
+			EntityID: entityID,
+			Field:    "cf.budget",
+			From:     float64(i),
+			To:       float64(i + 1),
+			Actor:    "bench",
+		}
+	}
+	return changes
+}
+
+func TestPoolRepository(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pool := newTestPoolRepository(t)
+	ids := seedUsers(t, 3)
+
+	// the native list reads what database/sql reads
+	opts := domain.ListOptions{CustomFilters: map[string]any{"budget": float64(2)}}
+	want, err := testRepo.GetUsers(ctx, opts)
+	if err != nil {
+		t.Fatalf("Failed to get users: %v", err)
+	}
+	got, err := pool.GetUsers(ctx, opts)
+	if err != nil {
+		t.Fatalf("Failed to get users from the pool: %v", err)
+	}
+	if len(got) != len(want) || len(got) == 0 || got[0].ID != want[0].ID || got[0].Email != want[0].Email {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	// copied field history reads back like inserted history
+	if err := pool.RecordFieldChanges(ctx, fieldChanges(ids[0], 3)); err != nil {
+		t.Fatalf("Failed to record field changes: %v", err)
+	}
+	history, err := pool.GetFieldHistory(ctx, domain.EntityUser, ids[0], nil)
+	if err != nil {
+		t.Fatalf("Failed to get field history: %v", err)
+	}
+	if len(history) != 3 || history[0].To != float64(3) {
+		t.Errorf("Expected the copied changes, got %+v", history)
+	}
+
+	// copied users read back like inserted ones, with their events and tags
+	writes := userCreates(3)
+	writes[0].User.Tags = []string{"imported"}
+	errs, err := pool.WriteUsers(ctx, writes, true)
+	if err != nil || errors.Join(errs...) != nil {
+		t.Fatalf("Failed to copy users: %v %v", err, errs)
+	}
+	for _, write := range writes {
+		user, err := pool.GetUser(ctx, write.User.ID)
+		if err != nil || user.Email != write.User.Email || user.Version != 1 || user.CustomFields["budget"] == nil {
+			t.Errorf("Expected the copied user %+v, got %+v %v", write.User, user, err)
+		}
+	}
+	if user, _ := pool.GetUser(ctx, writes[0].User.ID); user == nil || len(user.Tags) != 1 || user.Tags[0] != "imported" {
+		t.Errorf("Expected the copied user to be tagged, got %+v", user)
+	}
+	var events int
+	err = testDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM outbox WHERE event = $1 AND entity_id = ANY($2)`,
+		domain.EventUserCreated, []int{writes[0].User.ID, writes[1].User.ID, writes[2].User.ID}).Scan(&events)
+	if err != nil || events != 3 {
+		t.Errorf("Expected 3 user.created events, got %d %v", events, err)
+	}
+
+	// a taken email falls back to writing row by row, in and out of a unit of work
+	taken := userCreates(2)
+	taken[1].User.Email = writes[0].User.Email
+	errs, err = pool.WriteUsers(ctx, taken, false)
+	if err != nil || errs[0] != nil || !errors.Is(errs[1], ErrDuplicate) {
+		t.Errorf("Expected only the second write to fail as a duplicate, got %v %v", err, errs)
+	}
+	err = pool.InTx(ctx, func(ctx context.Context) error {
+		writes := userCreates(2)
+		errs, err := pool.WriteUsers(ctx, writes, true)
+		if err != nil || errors.Join(errs...) != nil {
+			return fmt.Errorf("copy in a unit of work: %v %v", err, errs)
+		}
+		if _, err := pool.GetUser(ctx, writes[1].User.ID); err != nil {
+			return fmt.Errorf("read a copied user in a unit of work: %w", err)
+		}
+		writes = userCreates(1)
+		writes[0].User.Email = taken[0].User.Email
+		errs, err = pool.WriteUsers(ctx, writes, true)
+		if err != nil || !errors.Is(errs[0], ErrDuplicate) {
+			return fmt.Errorf("expected a duplicate in a unit of work, got %v %v", err, errs)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Failed to copy users in a unit of work: %v", err)
+	}
+
+	// field history, erasures and deletes take their fast paths in a unit of
+	// work too, and roll back with it
+	rolledBack := errors.New("roll back")
+	err = pool.InTx(ctx, func(ctx context.Context) error {
+		if err := pool.RecordFieldChanges(ctx, fieldChanges(ids[2], 2)); err != nil {
+			return err
+		}
+		if history, err := pool.GetFieldHistory(ctx, domain.EntityUser, ids[2], nil); err != nil || len(history) != 2 {
+			return fmt.Errorf("expected the copied changes in the unit of work, got %d %v", len(history), err)
+		}
+		if err := pool.ErasePersonalData(ctx, domain.EntityUser, 999999); !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("expected ErrNotFound in the unit of work, got %v", err)
+		}
+		if err := pool.DeleteUser(ctx, ids[2]); err != nil {
+			return err
+		}
+		return rolledBack
+	})
+	if !errors.Is(err, rolledBack) {
+		t.Fatalf("Failed to run unit of work: %v", err)
+	}
+	if history, _ := pool.GetFieldHistory(ctx, domain.EntityUser, ids[2], nil); len(history) != 0 {
+		t.Errorf("Expected the copied changes to roll back, got %d", len(history))
+	}
+	if _, err := pool.GetUser(ctx, ids[2]); err != nil {
+		t.Errorf("Expected the delete to roll back, got %v", err)
+	}
+
+	// a batched erasure of a missing record changes nothing
+	if err := pool.ErasePersonalData(ctx, domain.EntityUser, 999999); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := pool.ErasePersonalData(ctx, domain.EntityUser, ids[0]); err != nil {
+		t.Fatalf("Failed to erase personal data: %v", err)
+	}
+	erased, err := pool.GetUser(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("Failed to get erased user: %v", err)
+	}
+	if erased.Name != "Erased" {
+		t.Errorf("Expected the user to be erased, got %q", erased.Name)
+	}
+	if history, _ := pool.GetFieldHistory(ctx, domain.EntityUser, ids[0], nil); len(history) != 0 {
+		t.Errorf("Expected the erasure to delete field history, got %d changes", len(history))
+	}
+
+	if err := pool.DeleteUser(ctx, ids[1]); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if _, err := pool.GetUser(ctx, ids[1]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the user to be deleted, got %v", err)
+	}
+
+	// in a unit of work the pool's reads see its writes
+	err = pool.InTx(ctx, func(ctx context.Context) error {
+		if err := pool.UpdateUser(ctx, domain.User{ID: ids[2], Name: "In a unit", Email: "in-a-unit@example.com"}); err != nil {
+			return err
+		}
+		users, err := pool.GetUsers(ctx, domain.ListOptions{})
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if user.ID == ids[2] && user.Name != "In a unit" {
+				t.Errorf("Expected the unit of work to read its own write, got %q", user.Name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to run unit of work: %v", err)
+	}
+}
+
+func BenchmarkGetUsers(b *testing.B) {
+	ctx := context.Background()
+	seedUsers(b, 100)
+	stores := []struct {
+		name  string
+		store Store
+	}{
+		{"database-sql", testRepo},
+		{"pgxpool", newTestPoolRepository(b)},
+	}
+	for _, s := range stores {
+		b.Run(s.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := s.store.GetUsers(ctx, domain.ListOptions{}); err != nil {
+					b.Fatalf("Failed to get users: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRecordFieldChanges(b *testing.B) {
+	ctx := context.Background()
+	ids := seedUsers(b, 1)
+	changes := fieldChanges(ids[0], 500)
+	stores := []struct {
+		name  string
+		store Store
+	}{
+		{"database-sql", testRepo},
+		{"pgxpool", newTestPoolRepository(b)},
+	}
+	for _, s := range stores {
+		b.Run(s.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				// in a unit of work, as the service records them
+				err := s.store.InTx(ctx, func(ctx context.Context) error {
+					return s.store.RecordFieldChanges(ctx, changes)
+				})
+				if err != nil {
+					b.Fatalf("Failed to record field changes: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCreateUsers(b *testing.B) {
+	ctx := context.Background()
+	stores := []struct {
+		name  string
+		store Store
+	}{
+		{"insert", testRepo},
+		{"copy", newTestPoolRepository(b)},
+	}
+	for _, s := range stores {
+		b.Run(s.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				writes := userCreates(500)
+				b.StartTimer()
+				errs, err := s.store.WriteUsers(ctx, writes, true)
+				if err != nil || errors.Join(errs...) != nil {
+					b.Fatalf("Failed to create users: %v %v", err, errs)
+				}
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,21 +13,16 @@ import (
 	"github.com/jackc/pgx/v5/stdlib"
 )
 
+// applicationName identifies the app's connections in pg_stat_activity
+const applicationName = "CRMandLead"
+
 // new postgres connection and db
 func NewPostgresDB(cfg config.DBConfig) (*sql.DB, error) {
-	connConfig, err := pgx.ParseConfig(cfg.DSN())
+	db, err := openPostgresDB(cfg.DSN(), cfg)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse DSN: %w", err)
+		return nil, err
 	}
 
-	//set con pool params
-	connConfig.RuntimeParams["application_name"] = "CRMandLead"
-	//convert to adapt
-	db := stdlib.OpenDB(*connConfig)
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(25)
-	db.SetConnMaxLifetime(5 * time.Minute)
-
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -36,51 +32,147 @@ func NewPostgresDB(cfg config.DBConfig) (*sql.DB, error) {
 	return db, nil
 }
 
+// openPostgresDB opens a database/sql handle on dsn with the pool settings
+// of cfg, without connecting yet
+func openPostgresDB(dsn string, cfg config.DBConfig) (*sql.DB, error) {
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse DSN: %w", err)
+	}
+
+	//set con pool params
+	connConfig.RuntimeParams["application_name"] = applicationName
+	//convert to adapt
+	db := stdlib.OpenDB(*connConfig)
+	db.SetMaxOpenConns(cfg.MaxConns)
+	db.SetMaxIdleConns(cfg.MaxConns)
+	db.SetConnMaxLifetime(cfg.MaxConnLifetime)
+	db.SetConnMaxIdleTime(cfg.MaxConnIdleTime)
+	return db, nil
+}
+
 func (r *Repository) Close() error {
+	if r.replicas != nil {
+		return errors.Join(r.replicas.close(), r.db.Close())
+	}
 	return r.db.Close()
 }
 
 // Get a user by ID
 func (r *Repository) GetUser(ctx context.Context, id int) (*domain.User, error) {
-	query := `SELECT id, name, email, created_at, updated_at FROM users WHERE id = $1`
-	var user domain.User
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&user.ID,
-		&user.Name,
-		&user.Email,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND deleted_at IS NULL`
+	user, err := scanUser(r.conn(ctx).QueryRowContext(ctx, query, id))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found: %w", err)
+			return nil, fmt.Errorf("user not found: %w", ErrNotFound)
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
+}
+
+// GetUserByEmail retrieves a user by email address, ignoring case
+func (r *Repository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL ORDER BY id LIMIT 1`
+	user, err := scanUser(r.conn(ctx).QueryRowContext(ctx, query, email))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get user by email: %w", err)
+	}
+	return user, nil
 }
 
-// create a user
+// create a user, recording a user.created event in the same transaction
 func (r *Repository) CreateUser(ctx context.Context, user domain.User) (int, error) {
+	var id int
+	err := r.inTx(ctx, nil, func(tx DBConnection) error {
+		var err error
+		id, err = createUser(ctx, tx, user)
+		return err
+	})
+	return id, err
+}
+
+// createUser inserts a user and its user.created event in tx
+func createUser(ctx context.Context, tx DBConnection, user domain.User) (int, error) {
 	query := `
-	INSERT INTO users (name, email, created_at, updated_at)
-	VALUES ($1, $2, $3, $4)
-	RETURNING id
-	`
+	INSERT INTO users (name, email, custom_fields, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING ` + userColumns
+
+	customFields, err := encodeCustomFields(user.CustomFields)
+	if err != nil {
+		return 0, err
+	}
 
 	now := time.Now()
-	var id int
-	err := r.db.QueryRowContext(ctx, query,
+	created, err := scanUser(tx.QueryRowContext(ctx, query,
 		user.Name,
 		user.Email,
+		customFields,
 		now,
-		now).Scan(&id)
+		now))
 
+	if uniqueViolation(err) {
+		return 0, fmt.Errorf("user email %q: %w", user.Email, ErrDuplicate)
+	}
 	if err != nil {
 		return 0, fmt.Errorf("failed to create a user: %w", err)
 	}
+	if err := insertOutboxEvent(ctx, tx, domain.EventUserCreated, domain.EntityUser, created.ID, created); err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
+
+// UpdateUser saves the editable fields of a user at the expected version,
+// recording a user.updated event in the same transaction
+func (r *Repository) UpdateUser(ctx context.Context, user domain.User) error {
+	return r.inTx(ctx, nil, func(tx DBConnection) error {
+		return updateUser(ctx, tx, user)
+	})
+}
+
+// updateUser saves a user and its user.updated event in tx
+func updateUser(ctx context.Context, tx DBConnection, user domain.User) error {
+	customFields, err := encodeCustomFields(user.CustomFields)
+	if err != nil {
+		return err
+	}
+
+	updated, err := scanUser(tx.QueryRowContext(ctx,
+		`UPDATE users SET name = $1, email = $2, custom_fields = $3, updated_at = $4, version = version + 1
+		WHERE id = $5 AND deleted_at IS NULL AND ($6 = 0 OR version = $6) RETURNING `+userColumns,
+		user.Name, user.Email, customFields, time.Now(), user.ID, user.Version))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return notFoundOrConflict(ctx, tx, "users", "user", user.ID)
+		}
+		if uniqueViolation(err) {
+			return fmt.Errorf("user email %q: %w", user.Email, ErrDuplicate)
+		}
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	return insertOutboxEvent(ctx, tx, domain.EventUserUpdated, domain.EntityUser, updated.ID, updated)
+}
 
-	return id, nil
+// DeleteUser deletes a user for good. What was logged about them is erased
+// first, in the same transaction, so nothing personal outlives the record.
+func (r *Repository) DeleteUser(ctx context.Context, id int) error {
+	return r.inTx(ctx, nil, func(tx DBConnection) error {
+		if err := erasePersonalData(ctx, tx, domain.EntityUser, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM entity_tags WHERE entity = $1 AND entity_id = $2`, domain.EntityUser, id); err != nil {
+			return fmt.Errorf("failed to delete user tags: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+		return nil
+	})
 }
@@ -3,40 +3,68 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/dyrober/AgencyCRM/internal/config"
 	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/segment"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 var (
-	testRepo *Repository
+	testRepo Store
 	testDB   *sql.DB
+	// testDriver is the backend the tests run against, config.DriverSQLite,
+	// config.DriverPostgres or config.DriverMemory
+	testDriver string
 )
 
-// TestMain sets up and tears down the test database
+// TestMain sets up and tears down the test database. The tests run against
+// SQLite unless TEST_DB_DRIVER says otherwise or REPO_TESTS asks for Postgres.
 func TestMain(m *testing.M) {
-	// Skip repository tests if not explicitly enabled
+	testDriver = os.Getenv("TEST_DB_DRIVER")
+	if testDriver == "" && os.Getenv("REPO_TESTS") == "true" {
+		testDriver = config.DriverPostgres
+	} else if testDriver == "" {
+		testDriver = config.DriverSQLite
+	}
+
+	// Skip Postgres tests if not explicitly enabled
 	// This prevents them from running during regular unit test runs
-	if os.Getenv("REPO_TESTS") != "true" {
+	if testDriver == config.DriverPostgres && os.Getenv("REPO_TESTS") != "true" {
 		fmt.Println("Skipping repository tests; set REPO_TESTS=true to run")
 		os.Exit(0)
 	}
 
+	// the in-memory store has nothing to set up and keeps nothing to tear down
+	if testDriver == config.DriverMemory {
+		testRepo = NewMockRepository()
+		os.Exit(m.Run())
+	}
+
 	// Set up test database
 	var err error
-	testDB, err = setupTestDB()
+	if testDriver == config.DriverSQLite {
+		testDB, err = setupTestSQLiteDB()
+	} else {
+		testDB, err = setupTestDB()
+	}
 	if err != nil {
 		fmt.Printf("Failed to set up test database: %v\n", err)
 		os.Exit(1)
 	}
 
 	// Create repository with test database
-	testRepo = NewRepository(testDB)
+	if testDriver == config.DriverSQLite {
+		testRepo = NewSQLiteRepository(testDB)
+	} else {
+		testRepo = NewRepository(testDB)
+	}
 
 	// Run the tests
 	code := m.Run()
@@ -49,17 +77,25 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
+// testDBConfig returns the configuration of the test database
+func testDBConfig() config.DBConfig {
+	return config.DBConfig{
+		Host:            getEnvOrDefault("TEST_DB_HOST", "localhost"),
+		Port:            getEnvIntOrDefault("TEST_DB_PORT", 5432),
+		User:            getEnvOrDefault("TEST_DB_USER", "postgres"),
+		Password:        getEnvOrDefault("TEST_DB_PASSWORD", "postgres"),
+		DBName:          getEnvOrDefault("TEST_DB_NAME", "myapp_test"),
+		SSLMode:         "disable",
+		MaxConns:        5,
+		MaxConnLifetime: time.Minute,
+		MaxConnIdleTime: time.Minute,
+	}
+}
+
 // setupTestDB creates a connection to the test database and sets up the schema
 func setupTestDB() (*sql.DB, error) {
 	// Create database configuration for tests
-	dbConfig := config.DBConfig{
-		Host:     getEnvOrDefault("TEST_DB_HOST", "localhost"),
-		Port:     getEnvIntOrDefault("TEST_DB_PORT", 5432),
-		User:     getEnvOrDefault("TEST_DB_USER", "postgres"),
-		Password: getEnvOrDefault("TEST_DB_PASSWORD", "postgres"),
-		DBName:   getEnvOrDefault("TEST_DB_NAME", "myapp_test"),
-		SSLMode:  "disable",
-	}
+	dbConfig := testDBConfig()
 
 	// Connect to database
 	db, err := sql.Open("pgx", dbConfig.DSN())
@@ -81,29 +117,461 @@ func setupTestDB() (*sql.DB, error) {
 	return db, nil
 }
 
+// setupTestSQLiteDB creates a SQLite database in a temporary directory with
+// the schema of the SQLite migrations
+func setupTestSQLiteDB() (*sql.DB, error) {
+	dir, err := os.MkdirTemp("", "repository-test")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create test database directory: %w", err)
+	}
+	dbConfig := testDBConfig()
+	dbConfig.Path = filepath.Join(dir, "test.db")
+	return NewSQLiteDB(dbConfig)
+}
+
 // createTestSchema sets up the necessary tables for testing
 func createTestSchema(db *sql.DB) error {
 	// Clear any existing data and recreate tables
 	_, err := db.Exec(`
+		DROP TABLE IF EXISTS idempotency_keys;
+		DROP TABLE IF EXISTS consents;
+		DROP TABLE IF EXISTS email_suppressions;
+		DROP TABLE IF EXISTS privacy_requests;
+		DROP TABLE IF EXISTS audit_events;
+		DROP TABLE IF EXISTS field_history;
+		DROP TABLE IF EXISTS sequence_enrollments;
+		DROP TABLE IF EXISTS sequences;
 		DROP TABLE IF EXISTS users;
+		DROP TABLE IF EXISTS custom_field_definitions;
+		DROP TABLE IF EXISTS entity_tags;
+		DROP TABLE IF EXISTS tags;
+		DROP TABLE IF EXISTS segments;
+		DROP TABLE IF EXISTS scoring_rules;
+		DROP TABLE IF EXISTS assignment_rules;
+		DROP TABLE IF EXISTS assignments;
+		DROP TABLE IF EXISTS automation_runs;
+		DROP TABLE IF EXISTS automations;
+		DROP TABLE IF EXISTS webhook_deliveries;
+		DROP TABLE IF EXISTS webhook_subscriptions;
+		DROP TABLE IF EXISTS outbox;
+		DROP TABLE IF EXISTS form_submissions;
+		DROP TABLE IF EXISTS forms;
+		DROP TABLE IF EXISTS activities;
+		DROP TABLE IF EXISTS email_attachments;
+		DROP TABLE IF EXISTS email_events;
+		DROP TABLE IF EXISTS emails;
+		DROP TABLE IF EXISTS email_templates;
 		
+		CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
 		CREATE TABLE users (
 			id SERIAL PRIMARY KEY,
 			name VARCHAR(255) NOT NULL,
-			email VARCHAR(255) NOT NULL UNIQUE,
+			email VARCHAR(255) NOT NULL,
 			created_at TIMESTAMP NOT NULL,
-			updated_at TIMESTAMP NOT NULL
+			updated_at TIMESTAMP NOT NULL,
+			custom_fields JSONB NOT NULL DEFAULT '{}',
+			score INTEGER NOT NULL DEFAULT 0,
+			score_breakdown JSONB NOT NULL DEFAULT '[]',
+			scored_at TIMESTAMP,
+			owner_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			out_of_office BOOLEAN NOT NULL DEFAULT FALSE,
+			deleted_at TIMESTAMP,
+			deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+			version INTEGER NOT NULL DEFAULT 1,
+			search_vector tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+				setweight(to_tsvector('simple', coalesce(email, '')), 'B')
+			) STORED
 		);
 		
 		CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+		CREATE UNIQUE INDEX idx_users_email_live ON users(LOWER(email)) WHERE deleted_at IS NULL;
+
+		CREATE TABLE custom_field_definitions (
+			id SERIAL PRIMARY KEY,
+			entity VARCHAR(50) NOT NULL,
+			key VARCHAR(63) NOT NULL,
+			label VARCHAR(255) NOT NULL,
+			type VARCHAR(20) NOT NULL,
+			options JSONB NOT NULL DEFAULT '[]',
+			track_history BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			deleted_at TIMESTAMP,
+			deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+			version INTEGER NOT NULL DEFAULT 1
+		);
+
+		CREATE UNIQUE INDEX idx_custom_field_definitions_key_live
+			ON custom_field_definitions(entity, key) WHERE deleted_at IS NULL;
+
+		CREATE TABLE tags (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(50) NOT NULL UNIQUE,
+			created_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE entity_tags (
+			tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+			entity VARCHAR(50) NOT NULL,
+			entity_id INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (tag_id, entity, entity_id)
+		);
+
+		CREATE TABLE segments (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			entity VARCHAR(50) NOT NULL,
+			expression TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			deleted_at TIMESTAMP,
+			deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+			version INTEGER NOT NULL DEFAULT 1
+		);
+
+		CREATE TABLE scoring_rules (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			entity VARCHAR(50) NOT NULL,
+			type VARCHAR(20) NOT NULL,
+			expression TEXT NOT NULL DEFAULT '',
+			points INTEGER NOT NULL,
+			half_life_days INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			deleted_at TIMESTAMP,
+			deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+			version INTEGER NOT NULL DEFAULT 1
+		);
+
+		CREATE TABLE assignment_rules (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			entity VARCHAR(50) NOT NULL,
+			position INTEGER NOT NULL DEFAULT 0,
+			expression TEXT NOT NULL DEFAULT '',
+			strategy VARCHAR(20) NOT NULL,
+			members JSONB NOT NULL DEFAULT '[]',
+			turn INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			deleted_at TIMESTAMP,
+			deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+			version INTEGER NOT NULL DEFAULT 1
+		);
+
+		CREATE TABLE assignments (
+			id SERIAL PRIMARY KEY,
+			entity VARCHAR(50) NOT NULL,
+			entity_id INTEGER NOT NULL,
+			owner_id INTEGER NOT NULL,
+			previous_owner_id INTEGER,
+			rule_id INTEGER,
+			reason VARCHAR(20) NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE automations (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			entity VARCHAR(50) NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			trigger JSONB NOT NULL,
+			condition TEXT NOT NULL DEFAULT '',
+			actions JSONB NOT NULL DEFAULT '[]',
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			deleted_at TIMESTAMP,
+			deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+			version INTEGER NOT NULL DEFAULT 1
+		);
+
+		CREATE TABLE automation_runs (
+			id SERIAL PRIMARY KEY,
+			automation_id INTEGER NOT NULL REFERENCES automations(id) ON DELETE CASCADE,
+			entity VARCHAR(50) NOT NULL,
+			entity_id INTEGER NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			depth INTEGER NOT NULL DEFAULT 0,
+			dedupe_key VARCHAR(100),
+			log TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+
+		CREATE UNIQUE INDEX idx_automation_runs_dedupe ON automation_runs(automation_id, entity, entity_id, dedupe_key);
+
+		CREATE TABLE outbox (
+			id SERIAL PRIMARY KEY,
+			event VARCHAR(100) NOT NULL,
+			entity VARCHAR(50) NOT NULL,
+			entity_id INTEGER NOT NULL,
+			payload JSONB NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			processed_at TIMESTAMP
+		);
+
+		CREATE TABLE webhook_subscriptions (
+			id SERIAL PRIMARY KEY,
+			url TEXT NOT NULL,
+			secret VARCHAR(255) NOT NULL,
+			events JSONB NOT NULL DEFAULT '[]',
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			deleted_at TIMESTAMP,
+			deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+			version INTEGER NOT NULL DEFAULT 1
+		);
+
+		CREATE TABLE webhook_deliveries (
+			id SERIAL PRIMARY KEY,
+			subscription_id INTEGER NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+			outbox_id INTEGER NOT NULL REFERENCES outbox(id),
+			event VARCHAR(100) NOT NULL,
+			payload JSONB NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			response_code INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMP NOT NULL,
+			delivered_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE forms (
+			id SERIAL PRIMARY KEY,
+			key VARCHAR(64) NOT NULL UNIQUE,
+			name VARCHAR(255) NOT NULL,
+			entity VARCHAR(50) NOT NULL,
+			field_mappings JSONB NOT NULL DEFAULT '{}',
+			allowed_origins JSONB NOT NULL DEFAULT '[]',
+			honeypot_field VARCHAR(100) NOT NULL DEFAULT '',
+			require_token BOOLEAN NOT NULL DEFAULT FALSE,
+			secret VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			deleted_at TIMESTAMP,
+			deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+			version INTEGER NOT NULL DEFAULT 1
+		);
+
+		CREATE TABLE form_submissions (
+			id SERIAL PRIMARY KEY,
+			form_id INTEGER NOT NULL REFERENCES forms(id) ON DELETE CASCADE,
+			entity_id INTEGER,
+			status VARCHAR(20) NOT NULL,
+			ip VARCHAR(64) NOT NULL DEFAULT '',
+			referrer TEXT NOT NULL DEFAULT '',
+			utm JSONB NOT NULL DEFAULT '{}',
+			data JSONB NOT NULL DEFAULT '{}',
+			created_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE email_templates (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			subject TEXT NOT NULL,
+			body_text TEXT NOT NULL DEFAULT '',
+			body_html TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			deleted_at TIMESTAMP,
+			deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+			version INTEGER NOT NULL DEFAULT 1
+		);
+
+		CREATE TABLE emails (
+			id SERIAL PRIMARY KEY,
+			entity VARCHAR(50) NOT NULL,
+			entity_id INTEGER NOT NULL,
+			template_id INTEGER REFERENCES email_templates(id) ON DELETE SET NULL,
+			from_address TEXT NOT NULL,
+			to_address TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			body_text TEXT NOT NULL DEFAULT '',
+			body_html TEXT NOT NULL DEFAULT '',
+			message_id VARCHAR(255) NOT NULL,
+			direction VARCHAR(10) NOT NULL DEFAULT 'outbound',
+			in_reply_to VARCHAR(255) NOT NULL DEFAULT '',
+			thread_id INTEGER REFERENCES emails(id) ON DELETE SET NULL,
+			status VARCHAR(20) NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			tracking_token VARCHAR(64) UNIQUE,
+			links JSONB NOT NULL DEFAULT '[]',
+			open_count INTEGER NOT NULL DEFAULT 0,
+			click_count INTEGER NOT NULL DEFAULT 0,
+			opened_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE email_events (
+			id SERIAL PRIMARY KEY,
+			email_id INTEGER NOT NULL REFERENCES emails(id) ON DELETE CASCADE,
+			type VARCHAR(20) NOT NULL,
+			url TEXT NOT NULL DEFAULT '',
+			ip VARCHAR(64) NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE activities (
+			id SERIAL PRIMARY KEY,
+			entity VARCHAR(50) NOT NULL,
+			entity_id INTEGER NOT NULL,
+			type VARCHAR(50) NOT NULL,
+			summary TEXT NOT NULL DEFAULT '',
+			email_id INTEGER REFERENCES emails(id) ON DELETE SET NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE email_attachments (
+			id SERIAL PRIMARY KEY,
+			email_id INTEGER NOT NULL REFERENCES emails(id) ON DELETE CASCADE,
+			filename VARCHAR(255) NOT NULL,
+			content_type VARCHAR(255) NOT NULL,
+			size INTEGER NOT NULL,
+			data BYTEA NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE sequences (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			entity VARCHAR(50) NOT NULL,
+			steps JSONB NOT NULL DEFAULT '[]',
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			deleted_at TIMESTAMP,
+			deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+			version INTEGER NOT NULL DEFAULT 1
+		);
+
+		CREATE TABLE sequence_enrollments (
+			id SERIAL PRIMARY KEY,
+			sequence_id INTEGER NOT NULL REFERENCES sequences(id) ON DELETE CASCADE,
+			entity VARCHAR(50) NOT NULL,
+			entity_id INTEGER NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			step INTEGER NOT NULL DEFAULT 0,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_run_at TIMESTAMP NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+
+		CREATE UNIQUE INDEX idx_sequence_enrollments_active
+			ON sequence_enrollments(sequence_id, entity, entity_id) WHERE status = 'active';
+
+		CREATE TABLE consents (
+			id SERIAL PRIMARY KEY,
+			entity VARCHAR(50) NOT NULL,
+			entity_id INTEGER NOT NULL,
+			channel VARCHAR(20) NOT NULL,
+			purpose VARCHAR(50) NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			lawful_basis VARCHAR(30) NOT NULL,
+			source VARCHAR(100) NOT NULL,
+			ip VARCHAR(45) NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE email_suppressions (
+			id SERIAL PRIMARY KEY,
+			email VARCHAR(255) NOT NULL UNIQUE,
+			reason VARCHAR(20) NOT NULL,
+			source VARCHAR(100) NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE privacy_requests (
+			id SERIAL PRIMARY KEY,
+			type VARCHAR(20) NOT NULL,
+			entity VARCHAR(50) NOT NULL,
+			entity_id INTEGER NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			note TEXT NOT NULL DEFAULT '',
+			due_at TIMESTAMP NOT NULL,
+			completed_at TIMESTAMP,
+			reminded_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE audit_events (
+			id SERIAL PRIMARY KEY,
+			actor VARCHAR(255) NOT NULL,
+			entity VARCHAR(50) NOT NULL,
+			entity_id INTEGER NOT NULL,
+			action VARCHAR(20) NOT NULL,
+			changes JSONB NOT NULL DEFAULT '{}',
+			changes_hash CHAR(64) NOT NULL,
+			redacted BOOLEAN NOT NULL DEFAULT FALSE,
+			request_id VARCHAR(255) NOT NULL DEFAULT '',
+			ip VARCHAR(64) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			prev_hash CHAR(64) NOT NULL,
+			hash CHAR(64) NOT NULL UNIQUE
+		);
+
+		CREATE TABLE field_history (
+			id SERIAL PRIMARY KEY,
+			entity VARCHAR(50) NOT NULL,
+			entity_id INTEGER NOT NULL,
+			field VARCHAR(100) NOT NULL,
+			old_value JSONB,
+			new_value JSONB,
+			actor VARCHAR(255) NOT NULL,
+			changed_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE idempotency_keys (
+			key VARCHAR(255) PRIMARY KEY,
+			request_hash VARCHAR(64) NOT NULL,
+			status_code INTEGER NOT NULL DEFAULT 0,
+			response_headers JSONB NOT NULL DEFAULT '{}',
+			response_body BYTEA NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		);
 	`)
+	if err != nil {
+		return err
+	}
+
+	// the change feed triggers, as the migration creates them
+	changeFeed, err := os.ReadFile("../../migrations/020_change_feed.sql")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(string(changeFeed))
 	return err
 }
 
 // teardownTestDB closes the database connection and performs cleanup
 func teardownTestDB(db *sql.DB) error {
+	if testDriver == config.DriverSQLite {
+		// the whole database goes with its directory
+		var path string
+		if err := db.QueryRow(`SELECT file FROM pragma_database_list WHERE name = 'main'`).Scan(&path); err != nil {
+			return err
+		}
+		if err := db.Close(); err != nil {
+			return err
+		}
+		return os.RemoveAll(filepath.Dir(path))
+	}
+
 	// Clean up data (not dropping tables to avoid schema validation errors in other tests)
-	_, err := db.Exec(`TRUNCATE TABLE users RESTART IDENTITY`)
+	_, err := db.Exec(`TRUNCATE TABLE users, custom_field_definitions, tags, entity_tags, segments, scoring_rules, assignment_rules, assignments, automations, automation_runs, outbox, webhook_subscriptions, webhook_deliveries, forms, form_submissions, email_templates, emails, email_events, activities, email_attachments, sequences, sequence_enrollments, consents, email_suppressions, privacy_requests, audit_events, field_history, idempotency_keys RESTART IDENTITY`)
 	if err != nil {
 		return err
 	}
@@ -261,9 +729,1563 @@ func TestRepository_CreateUser(t *testing.T) {
 		// This should fail due to unique constraint on email
 		_, err = testRepo.CreateUser(ctx, user2)
 
-		// Verify we got an error
-		if err == nil {
-			t.Fatal("Expected error for duplicate email, got nil")
+		if !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("Expected ErrDuplicate for a duplicate email, got %v", err)
+		}
+
+		// addresses are the same whatever their case
+		user2.Email = "Duplicate@Example.com"
+		if _, err := testRepo.CreateUser(ctx, user2); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("Expected ErrDuplicate for the email in another case, got %v", err)
+		}
+	})
+}
+
+// Test the Search function
+func TestRepository_Search(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	testID := fmt.Sprintf("%d", time.Now().UnixNano())
+	searchUser := domain.User{
+		Name:  "Searchable Zebediah " + testID,
+		Email: fmt.Sprintf("zeb_%s@example.com", testID),
+	}
+
+	userID, err := testRepo.CreateUser(ctx, searchUser)
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	t.Run("prefix match on name", func(t *testing.T) {
+		results, err := testRepo.Search(ctx, "zebed", 10)
+		if err != nil {
+			t.Fatalf("Failed to search: %v", err)
+		}
+
+		if len(results) == 0 || results[0].ID != userID {
+			t.Fatalf("Expected user %d as the top result, got %+v", userID, results)
+		}
+
+		if results[0].Type != "user" {
+			t.Errorf("Expected result type 'user', got '%s'", results[0].Type)
+		}
+	})
+
+	t.Run("partial email match", func(t *testing.T) {
+		results, err := testRepo.Search(ctx, "zeb_"+testID[:6], 10)
+		if err != nil {
+			t.Fatalf("Failed to search: %v", err)
+		}
+
+		found := false
+		for _, result := range results {
+			if result.ID == userID {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected user %d in results, got %+v", userID, results)
+		}
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		results, err := testRepo.Search(ctx, "qqqqxxxxnomatch", 10)
+		if err != nil {
+			t.Fatalf("Failed to search: %v", err)
+		}
+
+		if len(results) != 0 {
+			t.Errorf("Expected no results, got %d", len(results))
+		}
+	})
+}
+
+func TestPrefixTSQuery(t *testing.T) {
+	tests := map[string]string{
+		"john":          "john:*",
+		"John  Smith":   "john:* & smith:*",
+		"a&b|c:*!":      "a:* & b:* & c:*",
+		"jane@acme.com": "jane:* & acme:* & com:*",
+		"   ":           "",
+	}
+	for input, expected := range tests {
+		if got := prefixTSQuery(input); got != expected {
+			t.Errorf("prefixTSQuery(%q) = %q, want %q", input, got, expected)
+		}
+	}
+}
+
+// Test custom field definitions and filtering users on their values
+func TestRepository_CustomFields(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fieldID, err := testRepo.CreateCustomField(ctx, domain.CustomFieldDefinition{
+		Entity:  domain.EntityUser,
+		Key:     "channels",
+		Label:   "Channels",
+		Type:    domain.CustomFieldMultiSelect,
+		Options: []string{"email", "phone"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create custom field: %v", err)
+	}
+
+	t.Run("list definitions", func(t *testing.T) {
+		defs, err := testRepo.GetCustomFields(ctx, domain.EntityUser)
+		if err != nil {
+			t.Fatalf("Failed to get custom fields: %v", err)
+		}
+
+		if len(defs) != 1 || defs[0].ID != fieldID {
+			t.Fatalf("Expected the created field, got %+v", defs)
+		}
+
+		if len(defs[0].Options) != 2 {
+			t.Errorf("Expected 2 options, got %v", defs[0].Options)
+		}
+	})
+
+	t.Run("duplicate keys", func(t *testing.T) {
+		_, err := testRepo.CreateCustomField(ctx, domain.CustomFieldDefinition{
+			Entity: domain.EntityUser,
+			Key:    "channels",
+			Label:  "Other Channels",
+			Type:   domain.CustomFieldText,
+		})
+		if !errors.Is(err, ErrDuplicate) {
+			t.Errorf("Expected ErrDuplicate, got %v", err)
+		}
+	})
+
+	t.Run("filter and sort by value", func(t *testing.T) {
+		testID := fmt.Sprintf("%d", time.Now().UnixNano())
+		phoneID, err := testRepo.CreateUser(ctx, domain.User{
+			Name:         "Phone User",
+			Email:        fmt.Sprintf("phone_%s@example.com", testID),
+			CustomFields: map[string]any{"channels": []string{"email", "phone"}, "budget": 10},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		_, err = testRepo.CreateUser(ctx, domain.User{
+			Name:         "Email User",
+			Email:        fmt.Sprintf("email_%s@example.com", testID),
+			CustomFields: map[string]any{"channels": []string{"email"}, "budget": 20},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+
+		users, err := testRepo.GetUsers(ctx, domain.ListOptions{CustomFilters: map[string]any{"channels": "phone"}})
+		if err != nil {
+			t.Fatalf("Failed to get users: %v", err)
+		}
+		if len(users) != 1 || users[0].ID != phoneID {
+			t.Fatalf("Expected only user %d, got %+v", phoneID, users)
+		}
+
+		users, err = testRepo.GetUsers(ctx, domain.ListOptions{
+			Sort:          "cf.budget",
+			CustomFilters: map[string]any{"channels": "email"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to get users: %v", err)
+		}
+		if len(users) != 2 || users[0].ID != phoneID {
+			t.Errorf("Expected user %d first when sorting by budget, got %+v", phoneID, users)
+		}
+	})
+
+	t.Run("delete definition", func(t *testing.T) {
+		if err := testRepo.DeleteCustomField(ctx, fieldID); err != nil {
+			t.Fatalf("Failed to delete custom field: %v", err)
+		}
+
+		if err := testRepo.DeleteCustomField(ctx, fieldID); err == nil {
+			t.Fatal("Expected error deleting a missing custom field, got nil")
+		}
+	})
+}
+
+// Test tagging users and evaluating segments in SQL
+func TestRepository_TagsAndSegments(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	testID := fmt.Sprintf("%d", time.Now().UnixNano())
+	taggedID, err := testRepo.CreateUser(ctx, domain.User{
+		Name:  "Tagged User",
+		Email: fmt.Sprintf("tagged_%s@example.com", testID),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	otherID, err := testRepo.CreateUser(ctx, domain.User{
+		Name:  "Other User",
+		Email: fmt.Sprintf("other_%s@example.com", testID),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	tag := "webinar_" + testID
+
+	t.Run("add tags", func(t *testing.T) {
+		// 9999999 doesn't exist and must be skipped
+		added, err := testRepo.AddTags(ctx, domain.EntityUser, []int{taggedID, otherID, 9999999}, []string{tag, "vip"})
+		if err != nil {
+			t.Fatalf("Failed to add tags: %v", err)
+		}
+		if added != 4 {
+			t.Errorf("Expected 4 links, got %d", added)
+		}
+
+		// adding again is a no-op
+		added, err = testRepo.AddTags(ctx, domain.EntityUser, []int{taggedID}, []string{tag})
+		if err != nil {
+			t.Fatalf("Failed to add tags: %v", err)
+		}
+		if added != 0 {
+			t.Errorf("Expected 0 new links, got %d", added)
+		}
+	})
+
+	t.Run("remove tags", func(t *testing.T) {
+		removed, err := testRepo.RemoveTags(ctx, domain.EntityUser, []int{otherID}, []string{tag})
+		if err != nil {
+			t.Fatalf("Failed to remove tags: %v", err)
+		}
+		if removed != 1 {
+			t.Errorf("Expected 1 removed link, got %d", removed)
+		}
+
+		user, err := testRepo.GetUser(ctx, otherID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if len(user.Tags) != 1 || user.Tags[0] != "vip" {
+			t.Errorf("Expected only the vip tag, got %v", user.Tags)
 		}
 	})
+
+	t.Run("segment members", func(t *testing.T) {
+		expr, err := segment.Parse("tag:"+tag+" AND NOT email:other_", time.Now())
+		if err != nil {
+			t.Fatalf("Failed to parse expression: %v", err)
+		}
+
+		users, err := testRepo.GetSegmentUsers(ctx, expr)
+		if err != nil {
+			t.Fatalf("Failed to get segment users: %v", err)
+		}
+		if len(users) != 1 || users[0].ID != taggedID {
+			t.Fatalf("Expected only user %d, got %+v", taggedID, users)
+		}
+
+		count, err := testRepo.CountSegmentUsers(ctx, expr)
+		if err != nil {
+			t.Fatalf("Failed to count segment users: %v", err)
+		}
+		if count != 1 {
+			t.Errorf("Expected 1 member, got %d", count)
+		}
+	})
+
+	t.Run("segment crud", func(t *testing.T) {
+		id, err := testRepo.CreateSegment(ctx, domain.Segment{Name: "VIPs", Entity: domain.EntityUser, Expression: "tag:vip"})
+		if err != nil {
+			t.Fatalf("Failed to create segment: %v", err)
+		}
+
+		seg, err := testRepo.GetSegment(ctx, id)
+		if err != nil {
+			t.Fatalf("Failed to get segment: %v", err)
+		}
+		if seg.Expression != "tag:vip" {
+			t.Errorf("Expected expression 'tag:vip', got '%s'", seg.Expression)
+		}
+
+		if err := testRepo.DeleteSegment(ctx, id); err != nil {
+			t.Fatalf("Failed to delete segment: %v", err)
+		}
+		if _, err := testRepo.GetSegment(ctx, id); err == nil {
+			t.Fatal("Expected error for deleted segment, got nil")
+		}
+	})
+}
+
+func TestRepository_Scoring(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ruleID, err := testRepo.CreateScoringRule(ctx, domain.ScoringRule{
+		Name:       "Webinar attendee",
+		Entity:     domain.EntityUser,
+		Type:       domain.ScoringRuleCondition,
+		Expression: "tag:webinar",
+		Points:     25,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scoring rule: %v", err)
+	}
+
+	rules, err := testRepo.GetScoringRules(ctx, domain.EntityUser)
+	if err != nil {
+		t.Fatalf("Failed to get scoring rules: %v", err)
+	}
+	if len(rules) == 0 || rules[len(rules)-1].ID != ruleID || rules[len(rules)-1].Points != 25 {
+		t.Fatalf("Expected rule %d in %+v", ruleID, rules)
+	}
+	rule, err := testRepo.GetScoringRule(ctx, ruleID)
+	if err != nil || rule.Expression != "tag:webinar" || rule.Version != 1 {
+		t.Errorf("Unexpected scoring rule: %+v %v", rule, err)
+	}
+	if _, err := testRepo.GetScoringRule(ctx, ruleID+1000); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing rule, got %v", err)
+	}
+
+	userID, err := testRepo.CreateUser(ctx, domain.User{
+		Name:  "Scored User",
+		Email: fmt.Sprintf("scored_%d@example.com", time.Now().UnixNano()),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	breakdown := []domain.ScoreContribution{{RuleID: ruleID, Rule: "Webinar attendee", Points: 25}}
+	if err := testRepo.UpdateUserScore(ctx, userID, 25, breakdown); err != nil {
+		t.Fatalf("Failed to update score: %v", err)
+	}
+	user, err := testRepo.GetUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if user.Score != 25 || len(user.ScoreBreakdown) != 1 || user.ScoreBreakdown[0].RuleID != ruleID {
+		t.Errorf("Expected the stored score and breakdown, got %d %+v", user.Score, user.ScoreBreakdown)
+	}
+
+	batch, err := testRepo.GetUserBatch(ctx, userID-1, 1)
+	if err != nil {
+		t.Fatalf("Failed to get user batch: %v", err)
+	}
+	if len(batch) != 1 || batch[0].ID != userID {
+		t.Errorf("Expected a batch with user %d, got %+v", userID, batch)
+	}
+
+	if err := testRepo.UpdateUserScore(ctx, 9999999, 1, nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound scoring a missing user, got %v", err)
+	}
+	if err := testRepo.DeleteScoringRule(ctx, ruleID); err != nil {
+		t.Fatalf("Failed to delete scoring rule: %v", err)
+	}
+}
+
+func TestRepository_Assignments(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	testID := time.Now().UnixNano()
+	var ids []int
+	for _, name := range []string{"rep", "away", "lead"} {
+		id, err := testRepo.CreateUser(ctx, domain.User{
+			Name:  name,
+			Email: fmt.Sprintf("%s_%d@example.com", name, testID),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rep, away, lead := ids[0], ids[1], ids[2]
+
+	ruleID, err := testRepo.CreateAssignmentRule(ctx, domain.AssignmentRule{
+		Name:     "Inbound",
+		Entity:   domain.EntityUser,
+		Strategy: domain.AssignRoundRobin,
+		Members:  []domain.AssignmentMember{{UserID: rep, Weight: 1}, {UserID: away, Weight: 1}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create assignment rule: %v", err)
+	}
+	rule, err := testRepo.GetAssignmentRule(ctx, ruleID)
+	if err != nil || len(rule.Members) != 2 || rule.Members[1].UserID != away || rule.Version != 1 {
+		t.Errorf("Unexpected assignment rule: %+v %v", rule, err)
+	}
+
+	for want := 0; want < 2; want++ {
+		turn, err := testRepo.NextAssignmentTurn(ctx, ruleID)
+		if err != nil {
+			t.Fatalf("Failed to advance turn: %v", err)
+		}
+		if turn != want {
+			t.Errorf("Expected turn %d, got %d", want, turn)
+		}
+	}
+
+	if err := testRepo.SetOutOfOffice(ctx, away, true, 0); err != nil {
+		t.Fatalf("Failed to set out of office: %v", err)
+	}
+	available, err := testRepo.GetAvailableUsers(ctx, []int{rep, away})
+	if err != nil {
+		t.Fatalf("Failed to get available users: %v", err)
+	}
+	if len(available) != 1 || available[0] != rep {
+		t.Errorf("Expected only %d available, got %v", rep, available)
+	}
+
+	changed, err := testRepo.AssignOwner(ctx, domain.EntityUser, []int{lead}, rep, &ruleID, domain.AssignmentReasonRule)
+	if err != nil {
+		t.Fatalf("Failed to assign owner: %v", err)
+	}
+	if changed != 1 {
+		t.Errorf("Expected 1 change, got %d", changed)
+	}
+	// assigning to the same owner again is not logged
+	changed, err = testRepo.AssignOwner(ctx, domain.EntityUser, []int{lead}, rep, nil, domain.AssignmentReasonManual)
+	if err != nil {
+		t.Fatalf("Failed to assign owner: %v", err)
+	}
+	if changed != 0 {
+		t.Errorf("Expected no change, got %d", changed)
+	}
+
+	counts, err := testRepo.CountOwnedRecords(ctx, domain.EntityUser, []int{rep, away})
+	if err != nil {
+		t.Fatalf("Failed to count owned records: %v", err)
+	}
+	if counts[rep] != 1 || counts[away] != 0 {
+		t.Errorf("Unexpected owned counts: %v", counts)
+	}
+
+	history, err := testRepo.GetAssignments(ctx, domain.EntityUser, lead)
+	if err != nil {
+		t.Fatalf("Failed to get assignments: %v", err)
+	}
+	if len(history) != 1 || history[0].OwnerID != rep || history[0].RuleID == nil || *history[0].RuleID != ruleID {
+		t.Errorf("Unexpected history: %+v", history)
+	}
+
+	user, err := testRepo.GetUser(ctx, lead)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if user.OwnerID == nil || *user.OwnerID != rep {
+		t.Errorf("Expected owner %d, got %v", rep, user.OwnerID)
+	}
+}
+
+func TestRepository_Automations(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	automationID, err := testRepo.CreateAutomation(ctx, domain.Automation{
+		Name:    "Welcome",
+		Entity:  domain.EntityUser,
+		Enabled: true,
+		Trigger: domain.AutomationTrigger{Type: domain.TriggerDateReached, Field: "created_at", OffsetDays: 3},
+		Actions: []domain.AutomationAction{{Type: domain.ActionAddTag, Tag: "welcomed"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create automation: %v", err)
+	}
+	automation, err := testRepo.GetAutomation(ctx, automationID)
+	if err != nil {
+		t.Fatalf("Failed to get automation: %v", err)
+	}
+	if automation.Trigger.OffsetDays != 3 || len(automation.Actions) != 1 || automation.Actions[0].Tag != "welcomed" {
+		t.Errorf("Automation didn't round trip: %+v", automation)
+	}
+
+	run := domain.AutomationRun{
+		AutomationID:  automationID,
+		Entity:        domain.EntityUser,
+		EntityID:      1,
+		Status:        domain.RunPending,
+		DedupeKey:     "2024-01-01",
+		NextAttemptAt: time.Now().Add(-time.Second),
+	}
+	if _, created, err := testRepo.CreateAutomationRun(ctx, run); err != nil || !created {
+		t.Fatalf("Failed to queue run: %v %v", created, err)
+	}
+	if _, created, err := testRepo.CreateAutomationRun(ctx, run); err != nil || created {
+		t.Errorf("Expected the duplicate run to be ignored: %v %v", created, err)
+	}
+
+	claimed, err := testRepo.ClaimAutomationRuns(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatalf("Failed to claim runs: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].Attempts != 1 || claimed[0].Status != domain.RunRunning {
+		t.Fatalf("Unexpected claimed runs: %+v", claimed)
+	}
+	// claimed runs aren't handed out twice
+	if again, _ := testRepo.ClaimAutomationRuns(ctx, time.Now(), 10); len(again) != 0 {
+		t.Errorf("Expected nothing left to claim, got %+v", again)
+	}
+
+	claimed[0].Status = domain.RunSucceeded
+	claimed[0].Log = "action 1 add_tag: tagged welcomed"
+	if err := testRepo.FinishAutomationRun(ctx, *claimed[0]); err != nil {
+		t.Fatalf("Failed to finish run: %v", err)
+	}
+	runs, err := testRepo.GetAutomationRuns(ctx, automationID)
+	if err != nil {
+		t.Fatalf("Failed to get runs: %v", err)
+	}
+	if len(runs) != 1 || runs[0].Status != domain.RunSucceeded || runs[0].DedupeKey != "2024-01-01" {
+		t.Errorf("Unexpected runs: %+v", runs)
+	}
+
+	if err := testRepo.DeleteAutomation(ctx, automationID); err != nil {
+		t.Fatalf("Failed to delete automation: %v", err)
+	}
+	if _, err := testRepo.GetAutomation(ctx, automationID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestRepository_Webhooks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// events written by earlier tests have no subscription to go to
+	for {
+		processed, err := testRepo.FanOutOutbox(ctx, time.Now(), 100)
+		if err != nil {
+			t.Fatalf("Failed to fan out earlier events: %v", err)
+		}
+		if processed == 0 {
+			break
+		}
+	}
+
+	subscriptionID, err := testRepo.CreateWebhookSubscription(ctx, domain.WebhookSubscription{
+		URL:    "https://example.com/hook",
+		Secret: "0123456789abcdef",
+		Events: []string{domain.EventUserCreated},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create webhook subscription: %v", err)
+	}
+
+	// the user and its event are written together
+	userID, err := testRepo.CreateUser(ctx, domain.User{Name: "Lead", Email: "lead@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := testRepo.UpdateUser(ctx, domain.User{ID: userID, Name: "Renamed", Email: "lead@example.com"}); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+
+	processed, err := testRepo.FanOutOutbox(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatalf("Failed to fan out outbox: %v", err)
+	}
+	if processed != 2 {
+		t.Errorf("Expected 2 processed events, got %d", processed)
+	}
+	if processed, _ := testRepo.FanOutOutbox(ctx, time.Now(), 10); processed != 0 {
+		t.Errorf("Expected events to be processed once, got %d", processed)
+	}
+
+	// only the subscribed event was queued
+	claimed, err := testRepo.ClaimWebhookDeliveries(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatalf("Failed to claim deliveries: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].Event != domain.EventUserCreated || claimed[0].Attempts != 1 {
+		t.Fatalf("Unexpected claimed deliveries: %+v", claimed)
+	}
+	if again, _ := testRepo.ClaimWebhookDeliveries(ctx, time.Now(), 10); len(again) != 0 {
+		t.Errorf("Expected nothing left to claim, got %+v", again)
+	}
+
+	delivered := time.Now()
+	claimed[0].Status = domain.DeliveryDelivered
+	claimed[0].ResponseCode = 200
+	claimed[0].DeliveredAt = &delivered
+	if err := testRepo.FinishWebhookDelivery(ctx, *claimed[0]); err != nil {
+		t.Fatalf("Failed to finish delivery: %v", err)
+	}
+	deliveries, err := testRepo.GetWebhookDeliveries(ctx, subscriptionID)
+	if err != nil {
+		t.Fatalf("Failed to get deliveries: %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != domain.DeliveryDelivered || deliveries[0].DeliveredAt == nil {
+		t.Errorf("Unexpected deliveries: %+v", deliveries)
+	}
+
+	if err := testRepo.RedeliverWebhook(ctx, deliveries[0].ID, time.Now()); err != nil {
+		t.Fatalf("Failed to redeliver: %v", err)
+	}
+	if err := testRepo.RedeliverWebhook(ctx, 99, time.Now()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound redelivering a missing delivery, got %v", err)
+	}
+
+	if err := testRepo.DeleteWebhookSubscription(ctx, subscriptionID); err != nil {
+		t.Fatalf("Failed to delete webhook subscription: %v", err)
+	}
+	if _, err := testRepo.GetWebhookSubscription(ctx, subscriptionID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestRepository_Forms(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	formID, err := testRepo.CreateForm(ctx, domain.Form{
+		Key:            "abc123",
+		Name:           "Contact us",
+		Entity:         domain.EntityUser,
+		FieldMappings:  map[string]string{"email": "email"},
+		AllowedOrigins: []string{"https://client.example"},
+		HoneypotField:  "website",
+		Secret:         "secret",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create form: %v", err)
+	}
+	form, err := testRepo.GetFormByKey(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("Failed to get form by key: %v", err)
+	}
+	if form.ID != formID || form.FieldMappings["email"] != "email" || form.AllowedOrigins[0] != "https://client.example" {
+		t.Errorf("Form didn't round trip: %+v", form)
+	}
+	if _, err := testRepo.GetFormByKey(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing key, got %v", err)
+	}
+
+	userID, err := testRepo.CreateUser(ctx, domain.User{Name: "Ada", Email: "Ada@Example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	user, err := testRepo.GetUserByEmail(ctx, "ada@example.com")
+	if err != nil || user.ID != userID {
+		t.Errorf("Expected to find the user ignoring case, got %+v %v", user, err)
+	}
+
+	if _, err := testRepo.CreateFormSubmission(ctx, domain.FormSubmission{
+		FormID:   formID,
+		EntityID: &userID,
+		Status:   domain.SubmissionCreated,
+		IP:       "203.0.113.7",
+		UTM:      map[string]string{"utm_source": "ads"},
+		Data:     map[string]string{"email": "ada@example.com"},
+	}); err != nil {
+		t.Fatalf("Failed to log submission: %v", err)
+	}
+	submissions, err := testRepo.GetFormSubmissions(ctx, formID)
+	if err != nil {
+		t.Fatalf("Failed to get submissions: %v", err)
+	}
+	if len(submissions) != 1 || *submissions[0].EntityID != userID || submissions[0].UTM["utm_source"] != "ads" {
+		t.Errorf("Unexpected submissions: %+v", submissions)
+	}
+
+	if err := testRepo.DeleteForm(ctx, formID); err != nil {
+		t.Fatalf("Failed to delete form: %v", err)
+	}
+	if _, err := testRepo.GetForm(ctx, formID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestRepository_Email(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	templateID, err := testRepo.CreateEmailTemplate(ctx, domain.EmailTemplate{
+		Name:     "Welcome",
+		Subject:  "Hi {{.FirstName}}",
+		BodyText: "Welcome aboard",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create email template: %v", err)
+	}
+	template, err := testRepo.GetEmailTemplate(ctx, templateID)
+	if err != nil || template.Subject != "Hi {{.FirstName}}" {
+		t.Fatalf("Unexpected email template: %+v %v", template, err)
+	}
+
+	emailID, err := testRepo.CreateEmail(ctx, domain.Email{
+		Entity:        domain.EntityUser,
+		EntityID:      1,
+		TemplateID:    &templateID,
+		From:          "crm@example.com",
+		To:            "ada@example.com",
+		Subject:       "Hi Ada",
+		BodyText:      "Welcome aboard",
+		MessageID:     "<token@example.com>",
+		Status:        domain.EmailSent,
+		TrackingToken: "token",
+		Links:         []string{"https://example.com/pricing"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create email: %v", err)
+	}
+	if _, err := testRepo.CreateActivity(ctx, domain.Activity{
+		Entity:   domain.EntityUser,
+		EntityID: 1,
+		Type:     domain.ActivityEmailSent,
+		Summary:  "Email sent: Hi Ada",
+		EmailID:  &emailID,
+	}); err != nil {
+		t.Fatalf("Failed to create activity: %v", err)
+	}
+
+	for _, eventType := range []string{domain.EmailEventOpen, domain.EmailEventOpen, domain.EmailEventClick} {
+		if err := testRepo.RecordEmailEvent(ctx, domain.EmailEvent{EmailID: emailID, Type: eventType}); err != nil {
+			t.Fatalf("Failed to record %s: %v", eventType, err)
+		}
+	}
+	email, err := testRepo.GetEmailByToken(ctx, "token")
+	if err != nil {
+		t.Fatalf("Failed to get email by token: %v", err)
+	}
+	if email.OpenCount != 2 || email.ClickCount != 1 || email.OpenedAt == nil || email.Links[0] != "https://example.com/pricing" {
+		t.Errorf("Unexpected tracking counters: %+v", email)
+	}
+
+	// deleting the template keeps the email it sent
+	if err := testRepo.DeleteEmailTemplate(ctx, templateID); err != nil {
+		t.Fatalf("Failed to delete email template: %v", err)
+	}
+	emails, err := testRepo.GetEmails(ctx, domain.EntityUser, 1)
+	if err != nil || len(emails) != 1 || emails[0].TemplateID != nil {
+		t.Errorf("Unexpected emails after template delete: %+v %v", emails, err)
+	}
+
+	activities, err := testRepo.GetActivities(ctx, domain.EntityUser, 1)
+	if err != nil || len(activities) != 1 || *activities[0].EmailID != emailID {
+		t.Errorf("Unexpected activities: %+v %v", activities, err)
+	}
+	if _, err := testRepo.GetEmailByToken(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing token, got %v", err)
+	}
+}
+
+func TestRepository_InboundEmail(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rootID, err := testRepo.CreateEmail(ctx, domain.Email{
+		Entity:        domain.EntityUser,
+		EntityID:      1,
+		From:          "crm@example.com",
+		To:            "ada@example.com",
+		Subject:       "Proposal",
+		MessageID:     "<root@example.com>",
+		Status:        domain.EmailSent,
+		TrackingToken: "root-token",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create email: %v", err)
+	}
+
+	// two inbound emails without tracking tokens must not collide
+	var replyID int
+	for i, messageID := range []string{"<reply1@example.com>", "<reply2@example.com>"} {
+		replyID, err = testRepo.CreateInboundEmail(ctx, domain.Email{
+			Entity:    domain.EntityUser,
+			EntityID:  1,
+			From:      "ada@example.com",
+			To:        "crm@example.com",
+			Subject:   "Re: Proposal",
+			MessageID: messageID,
+			Direction: domain.EmailInbound,
+			InReplyTo: "<root@example.com>",
+			ThreadID:  &rootID,
+			Status:    domain.EmailReceived,
+		}, []domain.EmailAttachment{
+			{Filename: "quote.pdf", ContentType: "application/pdf", Data: []byte("%PDF")},
+		}, []domain.Activity{
+			{Entity: domain.EntityUser, EntityID: 1, Type: domain.ActivityEmailReceived, Summary: "Email received"},
+			{Entity: domain.EntityUser, EntityID: 2, Type: domain.ActivityEmailSent, Summary: "Email sent"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create inbound email %d: %v", i, err)
+		}
+	}
+
+	reply, err := testRepo.GetEmailByMessageID(ctx, "<reply2@example.com>")
+	if err != nil {
+		t.Fatalf("Failed to get email by Message-ID: %v", err)
+	}
+	if reply.ID != replyID || reply.Direction != domain.EmailInbound || *reply.ThreadID != rootID || reply.TrackingToken != "" {
+		t.Errorf("Inbound email didn't round trip: %+v", reply)
+	}
+
+	attachments, err := testRepo.GetEmailAttachments(ctx, replyID)
+	if err != nil || len(attachments) != 1 || attachments[0].Size != 4 || attachments[0].Data != nil {
+		t.Fatalf("Unexpected attachments: %+v %v", attachments, err)
+	}
+	attachment, err := testRepo.GetEmailAttachment(ctx, replyID, attachments[0].ID)
+	if err != nil || string(attachment.Data) != "%PDF" {
+		t.Errorf("Unexpected attachment content: %+v %v", attachment, err)
+	}
+
+	activities, err := testRepo.GetActivities(ctx, domain.EntityUser, 2)
+	if err != nil || len(activities) != 2 || *activities[0].EmailID != replyID {
+		t.Errorf("Unexpected activities: %+v %v", activities, err)
+	}
+}
+
+func TestRepository_Sequences(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var userIDs []int
+	for _, email := range []string{"seq_ada@example.com", "seq_grace@example.com"} {
+		id, err := testRepo.CreateUser(ctx, domain.User{Name: "Sequence User", Email: email})
+		if err != nil {
+			t.Fatalf("Failed to create test user: %v", err)
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	sequenceID, err := testRepo.CreateSequence(ctx, domain.Sequence{
+		Name:   "Outreach",
+		Entity: domain.EntityUser,
+		Steps:  []domain.SequenceStep{{Type: domain.StepWait, Days: 2}, {Type: domain.StepTask, Task: "Call"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create sequence: %v", err)
+	}
+	sequence, err := testRepo.GetSequence(ctx, sequenceID)
+	if err != nil || len(sequence.Steps) != 2 || sequence.Steps[1].Task != "Call" {
+		t.Fatalf("Sequence didn't round trip: %+v %v", sequence, err)
+	}
+
+	// missing records are skipped, and so are records already enrolled
+	now := time.Now().Truncate(time.Second)
+	enrolled, err := testRepo.EnrollInSequence(ctx, sequenceID, domain.EntityUser, append(userIDs, 999999), now)
+	if err != nil || enrolled != 2 {
+		t.Fatalf("Expected 2 enrollments, got %d: %v", enrolled, err)
+	}
+	enrolled, err = testRepo.EnrollInSequence(ctx, sequenceID, domain.EntityUser, userIDs, now)
+	if err != nil || enrolled != 0 {
+		t.Fatalf("Expected no new enrollments, got %d: %v", enrolled, err)
+	}
+
+	claimed, err := testRepo.ClaimSequenceEnrollments(ctx, now, now.Add(time.Minute), 10)
+	if err != nil || len(claimed) != 2 || claimed[0].Attempts != 1 {
+		t.Fatalf("Unexpected claimed enrollments: %+v %v", claimed, err)
+	}
+	// leased enrollments aren't claimed again
+	again, err := testRepo.ClaimSequenceEnrollments(ctx, now, now.Add(time.Minute), 10)
+	if err != nil || len(again) != 0 {
+		t.Fatalf("Expected no enrollments to claim, got %+v %v", again, err)
+	}
+
+	// a reply arriving while the step runs wins over the step's progress
+	stopped, err := testRepo.StopEnrollments(ctx, 0, domain.EntityUser, []int{userIDs[0]}, domain.EnrollmentReplied)
+	if err != nil || stopped != 1 {
+		t.Fatalf("Expected 1 stopped enrollment, got %d: %v", stopped, err)
+	}
+	for _, enrollment := range claimed {
+		enrollment.Step = 1
+		enrollment.Attempts = 0
+		enrollment.NextRunAt = now.AddDate(0, 0, 2)
+		if err := testRepo.FinishSequenceStep(ctx, *enrollment); err != nil {
+			t.Fatalf("Failed to finish sequence step: %v", err)
+		}
+	}
+
+	enrollments, err := testRepo.GetSequenceEnrollments(ctx, sequenceID)
+	if err != nil || len(enrollments) != 2 {
+		t.Fatalf("Unexpected enrollments: %+v %v", enrollments, err)
+	}
+	for _, enrollment := range enrollments {
+		switch enrollment.EntityID {
+		case userIDs[0]:
+			if enrollment.Status != domain.EnrollmentReplied || enrollment.Step != 0 {
+				t.Errorf("Replied enrollment was advanced: %+v", enrollment)
+			}
+		case userIDs[1]:
+			if enrollment.Status != domain.EnrollmentActive || enrollment.Step != 1 {
+				t.Errorf("Active enrollment wasn't advanced: %+v", enrollment)
+			}
+		}
+	}
+
+	stats, err := testRepo.GetSequenceStats(ctx)
+	if err != nil || stats[sequenceID] == nil || *stats[sequenceID] != (domain.SequenceStats{Enrolled: 2, Active: 1, Replied: 1}) {
+		t.Errorf("Unexpected sequence stats: %+v %v", stats[sequenceID], err)
+	}
+
+	if err := testRepo.DeleteSequence(ctx, sequenceID); err != nil {
+		t.Fatalf("Failed to delete sequence: %v", err)
+	}
+	if _, err := testRepo.GetSequence(ctx, sequenceID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestRepository_Consent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, status := range []string{domain.ConsentGranted, domain.ConsentWithdrawn} {
+		if _, err := testRepo.CreateConsent(ctx, domain.Consent{
+			Entity:      domain.EntityUser,
+			EntityID:    1,
+			Channel:     domain.ChannelEmail,
+			Purpose:     domain.PurposeMarketing,
+			Status:      status,
+			LawfulBasis: domain.BasisConsent,
+			Source:      "web_form",
+			IP:          "203.0.113.7",
+		}); err != nil {
+			t.Fatalf("Failed to create consent: %v", err)
+		}
+	}
+	consents, err := testRepo.GetConsents(ctx, domain.EntityUser, 1)
+	if err != nil || len(consents) != 2 || consents[0].Status != domain.ConsentWithdrawn || consents[0].IP != "203.0.113.7" {
+		t.Fatalf("Unexpected consents: %+v %v", consents, err)
+	}
+
+	// the first reason sticks
+	for _, reason := range []string{domain.SuppressionBounced, domain.SuppressionUnsubscribed} {
+		if err := testRepo.AddSuppression(ctx, domain.EmailSuppression{Email: "ada@example.com", Reason: reason, Source: "test"}); err != nil {
+			t.Fatalf("Failed to add suppression: %v", err)
+		}
+	}
+	suppression, err := testRepo.GetSuppression(ctx, "ada@example.com")
+	if err != nil || suppression.Reason != domain.SuppressionBounced {
+		t.Fatalf("Unexpected suppression: %+v %v", suppression, err)
+	}
+
+	// lifting only removes a suppression with the same reason
+	if err := testRepo.LiftSuppression(ctx, "ada@example.com", domain.SuppressionUnsubscribed); err != nil {
+		t.Fatalf("Failed to lift suppression: %v", err)
+	}
+	suppressions, err := testRepo.GetSuppressions(ctx)
+	if err != nil || len(suppressions) != 1 {
+		t.Fatalf("Unexpected suppressions: %+v %v", suppressions, err)
+	}
+
+	if err := testRepo.DeleteSuppression(ctx, suppression.ID); err != nil {
+		t.Fatalf("Failed to delete suppression: %v", err)
+	}
+	if _, err := testRepo.GetSuppression(ctx, "ada@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestRepository_Privacy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	userID, err := testRepo.CreateUser(ctx, domain.User{Name: "Privacy Subject", Email: "subject@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	emailID, err := testRepo.CreateInboundEmail(ctx, domain.Email{
+		Entity:    domain.EntityUser,
+		EntityID:  userID,
+		From:      "subject@example.com",
+		To:        "sales@agency.example",
+		Subject:   "Signed contract",
+		BodyText:  "Attached",
+		MessageID: "<privacy@example.com>",
+		Status:    domain.EmailReceived,
+	}, []domain.EmailAttachment{{Filename: "contract.txt", ContentType: "text/plain", Size: 6, Data: []byte("signed")}}, []domain.Activity{
+		{Entity: domain.EntityUser, EntityID: userID, Type: domain.ActivityEmailReceived, Summary: "Signed contract"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create email: %v", err)
+	}
+
+	data, err := testRepo.GetPersonalData(ctx, domain.EntityUser, userID)
+	if err != nil {
+		t.Fatalf("Failed to get personal data: %v", err)
+	}
+	if data.Record.Email != "subject@example.com" || len(data.Emails) != 1 || len(data.Activities) != 1 {
+		t.Fatalf("Unexpected personal data: %+v", data)
+	}
+	if len(data.Attachments) != 1 || string(data.Attachments[0].Data) != "signed" {
+		t.Fatalf("Unexpected attachments: %+v", data.Attachments)
+	}
+
+	consentID, err := testRepo.CreateConsent(ctx, domain.Consent{
+		Entity: domain.EntityUser, EntityID: userID, Channel: domain.ChannelEmail, Purpose: domain.PurposeMarketing,
+		Status: domain.ConsentWithdrawn, LawfulBasis: domain.BasisConsent, Source: "unsubscribe_link",
+		IP: "203.0.113.7", UserAgent: "Mail",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create consent: %v", err)
+	}
+	if _, err := testRepo.AppendAuditEvent(ctx, domain.AuditEvent{
+		Actor: "system", Entity: domain.AuditConsent, EntityID: consentID, Action: domain.AuditCreate,
+		Changes: map[string]domain.AuditChange{"ip": {To: "203.0.113.7"}, "user_agent": {To: "Mail"}},
+	}); err != nil {
+		t.Fatalf("Failed to append audit event: %v", err)
+	}
+
+	if err := testRepo.ErasePersonalData(ctx, domain.EntityUser, userID); err != nil {
+		t.Fatalf("Failed to erase personal data: %v", err)
+	}
+	// the consent's audit events go with the IP and user agent on the consent
+	consentEvents, err := testRepo.GetAuditEvents(ctx, domain.AuditFilter{Entity: domain.AuditConsent, EntityID: consentID, Limit: 10})
+	if err != nil || len(consentEvents) != 1 || !consentEvents[0].Redacted || len(consentEvents[0].Changes) != 0 {
+		t.Errorf("Expected the consent audit event to be redacted, got %+v %v", consentEvents, err)
+	}
+	data, err = testRepo.GetPersonalData(ctx, domain.EntityUser, userID)
+	if err != nil {
+		t.Fatalf("Failed to get personal data: %v", err)
+	}
+	if data.Record.Email != fmt.Sprintf("erased-%d@erased.invalid", userID) || data.Record.Name != "Erased" {
+		t.Errorf("Expected the record to be erased, got %+v", data.Record)
+	}
+	if len(data.Emails) != 1 || data.Emails[0].Subject != "" || data.Emails[0].ID != emailID {
+		t.Errorf("Expected the email to be kept but emptied, got %+v", data.Emails)
+	}
+	if len(data.Attachments) != 0 || len(data.Activities) != 1 || data.Activities[0].Summary != "" {
+		t.Errorf("Unexpected data after erasure: %+v %+v", data.Attachments, data.Activities)
+	}
+	if err := testRepo.ErasePersonalData(ctx, domain.EntityUser, 999999); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound erasing a missing record, got %v", err)
+	}
+
+	now := time.Now()
+	id, err := testRepo.CreatePrivacyRequest(ctx, domain.PrivacyRequest{
+		Type:     domain.PrivacyExport,
+		Entity:   domain.EntityUser,
+		EntityID: userID,
+		Status:   domain.PrivacyPending,
+		DueAt:    now.AddDate(0, 0, 3),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create privacy request: %v", err)
+	}
+	pending, err := testRepo.GetPrivacyRequests(ctx, domain.PrivacyPending)
+	if err != nil || len(pending) != 1 || pending[0].ID != id {
+		t.Fatalf("Unexpected pending requests: %+v %v", pending, err)
+	}
+
+	// a reminder is claimed once a day
+	if claimed, err := testRepo.ClaimPrivacyReminder(ctx, id, now, now.Add(-24*time.Hour)); err != nil || !claimed {
+		t.Fatalf("Expected to claim the reminder, got %v %v", claimed, err)
+	}
+	if claimed, err := testRepo.ClaimPrivacyReminder(ctx, id, now.Add(time.Hour), now.Add(-23*time.Hour)); err != nil || claimed {
+		t.Fatalf("Expected the reminder to be claimed already, got %v %v", claimed, err)
+	}
+
+	if err := testRepo.CompletePrivacyRequest(ctx, id, now); err != nil {
+		t.Fatalf("Failed to complete privacy request: %v", err)
+	}
+	request, err := testRepo.GetPrivacyRequest(ctx, id)
+	if err != nil || request.Status != domain.PrivacyCompleted || request.CompletedAt == nil || request.RemindedAt == nil {
+		t.Fatalf("Unexpected privacy request: %+v %v", request, err)
+	}
+	if _, err := testRepo.GetPrivacyRequest(ctx, id+1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing request, got %v", err)
+	}
+}
+
+func TestRepository_Audit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	before, err := testRepo.GetAuditEvents(ctx, domain.AuditFilter{Limit: 1000})
+	if err != nil {
+		t.Fatalf("Failed to get audit events: %v", err)
+	}
+
+	changes := map[string]domain.AuditChange{"name": {To: "Audited"}}
+	first, err := testRepo.AppendAuditEvent(ctx, domain.AuditEvent{
+		Actor: "jane", Entity: domain.EntityUser, EntityID: 4242, Action: domain.AuditCreate, Changes: changes, RequestID: "req-1",
+	})
+	if err != nil {
+		t.Fatalf("Failed to append audit event: %v", err)
+	}
+	second, err := testRepo.AppendAuditEvent(ctx, domain.AuditEvent{
+		Actor: "system", Entity: domain.EntityUser, EntityID: 4242, Action: domain.AuditErase,
+	})
+	if err != nil {
+		t.Fatalf("Failed to append audit event: %v", err)
+	}
+
+	events, err := testRepo.GetAuditEvents(ctx, domain.AuditFilter{Entity: domain.EntityUser, EntityID: 4242, Limit: 10})
+	if err != nil || len(events) != 2 || events[0].ID != first || events[1].ID != second {
+		t.Fatalf("Unexpected audit events: %+v %v", events, err)
+	}
+	if events[0].Changes["name"].To != "Audited" || events[0].RequestID != "req-1" {
+		t.Errorf("Unexpected first event: %+v", events[0])
+	}
+	if len(before) > 0 && events[0].PrevHash != before[len(before)-1].Hash {
+		t.Errorf("Expected the first event to link to the last one")
+	}
+	if events[1].PrevHash != events[0].Hash {
+		t.Errorf("Expected the second event to link to the first")
+	}
+	// the hash read back matches the one computed on append
+	for _, event := range events {
+		if event.Hash != AuditHash(*event) || event.ChangesHash != AuditChangesHash(event.Changes) {
+			t.Errorf("Hashes of event %d don't match its contents", event.ID)
+		}
+	}
+
+	if events, err := testRepo.GetAuditEvents(ctx, domain.AuditFilter{Actor: "jane", AfterID: first, Limit: 10}); err != nil || len(events) != 0 {
+		t.Errorf("Expected no events after the filters, got %+v %v", events, err)
+	}
+	since := time.Now().Add(-time.Minute)
+	if events, err := testRepo.GetAuditEvents(ctx, domain.AuditFilter{Action: domain.AuditErase, Since: &since, Limit: 10}); err != nil || len(events) != 1 {
+		t.Errorf("Expected the erase event, got %+v %v", events, err)
+	}
+}
+
+func TestRepository_FieldHistory(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fieldID, err := testRepo.CreateCustomField(ctx, domain.CustomFieldDefinition{
+		Entity: domain.EntityUser, Key: "history_budget", Label: "Budget", Type: domain.CustomFieldNumber,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create custom field: %v", err)
+	}
+	if err := testRepo.UpdateCustomField(ctx, domain.CustomFieldDefinition{ID: fieldID, Label: "Annual budget", TrackHistory: true}); err != nil {
+		t.Fatalf("Failed to update custom field: %v", err)
+	}
+	def, err := testRepo.GetCustomField(ctx, fieldID)
+	if err != nil || def.Label != "Annual budget" || !def.TrackHistory {
+		t.Fatalf("Unexpected custom field: %+v %v", def, err)
+	}
+	if _, err := testRepo.GetCustomField(ctx, 999999); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing field, got %v", err)
+	}
+
+	before := time.Now()
+	if err := testRepo.RecordFieldChanges(ctx, []domain.FieldChange{
+		{Entity: domain.EntityUser, EntityID: 4343, Field: "name", From: "Ada", To: "Ada Lovelace", Actor: "jane"},
+		{Entity: domain.EntityUser, EntityID: 4343, Field: "custom_fields.history_budget", From: nil, To: 100, Actor: "jane"},
+	}); err != nil {
+		t.Fatalf("Failed to record field changes: %v", err)
+	}
+
+	changes, err := testRepo.GetFieldHistory(ctx, domain.EntityUser, 4343, nil)
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("Unexpected field history: %+v %v", changes, err)
+	}
+	// newest first, changes made together by ID
+	if changes[0].Field != "custom_fields.history_budget" || changes[0].From != nil || changes[0].To != float64(100) {
+		t.Errorf("Unexpected custom field change: %+v", changes[0])
+	}
+	if changes[1].From != "Ada" || changes[1].To != "Ada Lovelace" || changes[1].Actor != "jane" {
+		t.Errorf("Unexpected name change: %+v", changes[1])
+	}
+
+	since := before.Add(-time.Minute)
+	if changes, err := testRepo.GetFieldHistory(ctx, domain.EntityUser, 4343, &since); err != nil || len(changes) != 2 {
+		t.Errorf("Expected the changes after since, got %+v %v", changes, err)
+	}
+	later := time.Now().Add(time.Minute)
+	if changes, err := testRepo.GetFieldHistory(ctx, domain.EntityUser, 4343, &later); err != nil || len(changes) != 0 {
+		t.Errorf("Expected no changes after later, got %+v %v", changes, err)
+	}
+}
+
+func TestRepository_Trash(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	userID, err := testRepo.CreateUser(ctx, domain.User{Name: "Trashed", Email: "trashed@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	segmentID, err := testRepo.CreateSegment(ctx, domain.Segment{Name: "Trashed segment", Entity: domain.EntityUser, Expression: "tag:vip"})
+	if err != nil {
+		t.Fatalf("Failed to create segment: %v", err)
+	}
+
+	before := time.Now().Add(-time.Minute)
+	if err := testRepo.TrashRecord(ctx, domain.EntityUser, userID, 0, "jane"); err != nil {
+		t.Fatalf("Failed to trash user: %v", err)
+	}
+	if err := testRepo.TrashRecord(ctx, domain.AuditSegment, segmentID, 0, "jane"); err != nil {
+		t.Fatalf("Failed to trash segment: %v", err)
+	}
+	if err := testRepo.TrashRecord(ctx, domain.EntityUser, userID, 0, "jane"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound trashing a trashed user, got %v", err)
+	}
+	if err := testRepo.TrashRecord(ctx, domain.AuditSuppression, 1, 0, "jane"); err == nil {
+		t.Error("Expected an error trashing a suppression")
+	}
+
+	if _, err := testRepo.GetUser(ctx, userID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a trashed user to be hidden, got %v", err)
+	}
+	if _, err := testRepo.GetSegment(ctx, segmentID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a trashed segment to be hidden, got %v", err)
+	}
+	users, err := testRepo.GetUsers(ctx, domain.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get users: %v", err)
+	}
+	for _, user := range users {
+		if user.ID == userID {
+			t.Error("Expected a trashed user to be left out of the list")
+		}
+	}
+
+	items, err := testRepo.GetTrash(ctx, "", nil)
+	if err != nil || len(items) != 2 {
+		t.Fatalf("Unexpected trash: %+v %v", items, err)
+	}
+	// newest first
+	if items[0].Entity != domain.AuditSegment || items[0].Name != "Trashed segment" || items[1].DeletedBy != "jane" {
+		t.Errorf("Unexpected trash items: %+v %+v", items[0], items[1])
+	}
+	if items, err := testRepo.GetTrash(ctx, domain.EntityUser, &before); err != nil || len(items) != 0 {
+		t.Errorf("Expected nothing trashed before, got %+v %v", items, err)
+	}
+	item, err := testRepo.GetTrashItem(ctx, domain.EntityUser, userID)
+	if err != nil || item.Name != "Trashed" {
+		t.Errorf("Unexpected trash item: %+v %v", item, err)
+	}
+
+	if err := testRepo.RestoreRecord(ctx, domain.EntityUser, userID); err != nil {
+		t.Fatalf("Failed to restore user: %v", err)
+	}
+	if _, err := testRepo.GetUser(ctx, userID); err != nil {
+		t.Errorf("Expected the restored user, got %v", err)
+	}
+	if err := testRepo.RestoreRecord(ctx, domain.EntityUser, userID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound restoring a live user, got %v", err)
+	}
+
+	// the DeleteX methods purge trashed records
+	if err := testRepo.DeleteSegment(ctx, segmentID); err != nil {
+		t.Fatalf("Failed to purge segment: %v", err)
+	}
+	if err := testRepo.DeleteUser(ctx, userID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if items, err := testRepo.GetTrash(ctx, "", nil); err != nil || len(items) != 0 {
+		t.Errorf("Expected an empty trash, got %+v %v", items, err)
+	}
+	if err := testRepo.DeleteUser(ctx, userID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting a deleted user, got %v", err)
+	}
+}
+
+func TestRepository_TrashedKeys(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email := fmt.Sprintf("reused_%d@example.com", time.Now().UnixNano())
+	trashedID, err := testRepo.CreateUser(ctx, domain.User{Name: "Trashed", Email: email})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := testRepo.TrashRecord(ctx, domain.EntityUser, trashedID, 0, "jane"); err != nil {
+		t.Fatalf("Failed to trash user: %v", err)
+	}
+	// a trashed user's email address is free for a new user
+	liveID, err := testRepo.CreateUser(ctx, domain.User{Name: "Live", Email: email})
+	if err != nil {
+		t.Fatalf("Failed to reuse the email of a trashed user: %v", err)
+	}
+	if err := testRepo.RestoreRecord(ctx, domain.EntityUser, trashedID); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Expected ErrDuplicate restoring a user whose email was taken, got %v", err)
+	}
+	if user, err := testRepo.GetUserByEmail(ctx, email); err != nil || user.ID != liveID {
+		t.Errorf("Expected the new user to keep the email, got %+v %v", user, err)
+	}
+	if _, err := testRepo.GetTrashItem(ctx, domain.EntityUser, trashedID); err != nil {
+		t.Errorf("Expected the user to stay in the trash, got %v", err)
+	}
+
+	fieldID, err := testRepo.CreateCustomField(ctx, domain.CustomFieldDefinition{
+		Entity: domain.EntityUser, Key: "reused", Label: "Trashed", Type: domain.CustomFieldText,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create custom field: %v", err)
+	}
+	if err := testRepo.TrashRecord(ctx, domain.AuditCustomField, fieldID, 0, "jane"); err != nil {
+		t.Fatalf("Failed to trash custom field: %v", err)
+	}
+	if _, err := testRepo.CreateCustomField(ctx, domain.CustomFieldDefinition{
+		Entity: domain.EntityUser, Key: "reused", Label: "Live", Type: domain.CustomFieldText,
+	}); err != nil {
+		t.Fatalf("Failed to reuse the key of a trashed custom field: %v", err)
+	}
+	if err := testRepo.RestoreRecord(ctx, domain.AuditCustomField, fieldID); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Expected ErrDuplicate restoring a custom field whose key was taken, got %v", err)
+	}
+}
+
+func TestRepository_Versions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	id, err := testRepo.CreateUser(ctx, domain.User{Name: "Versioned", Email: "versioned@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	user, err := testRepo.GetUser(ctx, id)
+	if err != nil || user.Version != 1 {
+		t.Fatalf("Expected a new user at version 1, got %+v %v", user, err)
+	}
+
+	user.Name = "Versioned twice"
+	if err := testRepo.UpdateUser(ctx, *user); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	if err := testRepo.UpdateUser(ctx, *user); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict updating a stale user, got %v", err)
+	}
+	if err := testRepo.SetOutOfOffice(ctx, id, true, 1); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict for a stale out of office, got %v", err)
+	}
+	if err := testRepo.SetOutOfOffice(ctx, id, true, 2); err != nil {
+		t.Fatalf("Failed to set out of office: %v", err)
+	}
+	if _, err := testRepo.AddTags(ctx, domain.EntityUser, []int{id}, []string{"versioned"}); err != nil {
+		t.Fatalf("Failed to add tags: %v", err)
+	}
+	if err := testRepo.TrashRecord(ctx, domain.EntityUser, id, 3, "jane"); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict trashing a stale user, got %v", err)
+	}
+
+	user, err = testRepo.GetUser(ctx, id)
+	if err != nil || user.Version != 4 {
+		t.Fatalf("Expected the user at version 4, got %+v %v", user, err)
+	}
+	if err := testRepo.TrashRecord(ctx, domain.EntityUser, id, 4, "jane"); err != nil {
+		t.Fatalf("Failed to trash user: %v", err)
+	}
+	user.Version = 0
+	if err := testRepo.UpdateUser(ctx, *user); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound updating a trashed user, got %v", err)
+	}
+}
+
+func TestRepository_IdempotencyKeys(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	key := domain.IdempotencyKey{Key: "create-ada", RequestHash: "hash", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if stored, err := testRepo.ClaimIdempotencyKey(ctx, key); err != nil || stored != nil {
+		t.Fatalf("Expected to claim a new key, got %+v %v", stored, err)
+	}
+	stored, err := testRepo.ClaimIdempotencyKey(ctx, key)
+	if err != nil || stored == nil || stored.StatusCode != 0 {
+		t.Fatalf("Expected the running key back, got %+v %v", stored, err)
+	}
+
+	if err := testRepo.CompleteIdempotencyKey(ctx, "create-ada", 201, map[string]string{"Content-Type": "application/json"}, []byte(`{"id":1}`), now.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to complete key: %v", err)
+	}
+	if err := testRepo.CompleteIdempotencyKey(ctx, "create-ada", 201, nil, nil, now.Add(time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound completing a key twice, got %v", err)
+	}
+	stored, err = testRepo.ClaimIdempotencyKey(ctx, key)
+	if err != nil || stored == nil {
+		t.Fatalf("Failed to get the stored key: %v", err)
+	}
+	if stored.StatusCode != 201 || string(stored.ResponseBody) != `{"id":1}` || stored.ResponseHeaders["Content-Type"] != "application/json" {
+		t.Errorf("Unexpected stored response: %+v", stored)
+	}
+	if err := testRepo.ReleaseIdempotencyKey(ctx, "create-ada"); err != nil {
+		t.Fatalf("Failed to release key: %v", err)
+	}
+	if stored, _ := testRepo.ClaimIdempotencyKey(ctx, key); stored == nil {
+		t.Error("Expected releasing to leave a completed key alone")
+	}
+
+	// an expired key is taken over
+	later := key
+	later.RequestHash = "other hash"
+	later.CreatedAt = now.Add(2 * time.Hour)
+	later.ExpiresAt = now.Add(3 * time.Hour)
+	if stored, err := testRepo.ClaimIdempotencyKey(ctx, later); err != nil || stored != nil {
+		t.Fatalf("Expected to claim an expired key, got %+v %v", stored, err)
+	}
+
+	// a running key is claimed for a lease, completing it keeps it longer
+	leased := domain.IdempotencyKey{Key: "lease-ada", RequestHash: "hash", CreatedAt: now, ExpiresAt: now.Add(time.Minute)}
+	if stored, err := testRepo.ClaimIdempotencyKey(ctx, leased); err != nil || stored != nil {
+		t.Fatalf("Expected to claim a new key, got %+v %v", stored, err)
+	}
+	retry := leased
+	retry.CreatedAt = now.Add(2 * time.Minute)
+	retry.ExpiresAt = now.Add(3 * time.Minute)
+	if stored, err := testRepo.ClaimIdempotencyKey(ctx, retry); err != nil || stored != nil {
+		t.Fatalf("Expected to take over a key whose lease ran out, got %+v %v", stored, err)
+	}
+	if err := testRepo.CompleteIdempotencyKey(ctx, "lease-ada", 201, nil, nil, now.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to complete key: %v", err)
+	}
+	retry.CreatedAt = now.Add(10 * time.Minute)
+	if stored, err := testRepo.ClaimIdempotencyKey(ctx, retry); err != nil || stored == nil || stored.StatusCode != 201 {
+		t.Errorf("Expected the completed key to outlive its lease, got %+v %v", stored, err)
+	}
+
+	deleted, err := testRepo.DeleteExpiredIdempotencyKeys(ctx, now.Add(4*time.Hour))
+	if err != nil || deleted != 2 {
+		t.Errorf("Expected 2 expired keys deleted, got %d %v", deleted, err)
+	}
+}
+
+func TestRepository_WriteUsers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	id, err := testRepo.CreateUser(ctx, domain.User{Name: "Bulk", Email: "bulk@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	writes := []domain.UserWrite{
+		{Op: domain.BulkCreate, User: domain.User{Name: "Bulk created", Email: "bulk-created@example.com"}},
+		{Op: domain.BulkUpdate, User: domain.User{ID: id, Name: "Bulk updated", Email: "bulk@example.com", Version: 2}},
+		{Op: domain.BulkUpdate, User: domain.User{ID: id, Name: "Bulk updated", Email: "bulk@example.com", Version: 1}},
+	}
+	errs, err := testRepo.WriteUsers(ctx, writes, false)
+	if err != nil {
+		t.Fatalf("Failed to write users: %v", err)
+	}
+	if errs[0] != nil || errs[2] != nil || !errors.Is(errs[1], ErrVersionConflict) {
+		t.Errorf("Unexpected write errors: %v", errs)
+	}
+	if writes[0].User.ID == 0 {
+		t.Error("Expected the created user's ID to be set")
+	}
+	users, err := testRepo.GetUsersByID(ctx, []int{id, writes[0].User.ID})
+	if err != nil || len(users) != 2 {
+		t.Fatalf("Expected both users, got %v %v", users, err)
+	}
+	if users[0].Name != "Bulk updated" || users[0].Version != 2 {
+		t.Errorf("Expected the second update to apply after the first failed, got %+v", users[0])
+	}
+
+	// a failed atomic batch writes nothing
+	writes = []domain.UserWrite{
+		{Op: domain.BulkDelete, User: domain.User{ID: id}, DeletedBy: "jane"},
+		{Op: domain.BulkDelete, User: domain.User{ID: 999999}, DeletedBy: "jane"},
+	}
+	errs, err = testRepo.WriteUsers(ctx, writes, true)
+	if err != nil {
+		t.Fatalf("Failed to write users: %v", err)
+	}
+	if errs[0] != nil || !errors.Is(errs[1], ErrNotFound) {
+		t.Errorf("Unexpected write errors: %v", errs)
+	}
+	if _, err := testRepo.GetUser(ctx, id); err != nil {
+		t.Errorf("Expected the rolled back delete to leave the user, got %v", err)
+	}
+}
+
+func TestRepository_InTx(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	failed := errors.New("give up")
+	var rolledBack int
+	err := testRepo.InTx(ctx, func(ctx context.Context) error {
+		id, err := testRepo.CreateUser(ctx, domain.User{Name: "Rolled back", Email: "rolled-back@example.com"})
+		if err != nil {
+			return err
+		}
+		rolledBack = id
+		if _, err := testRepo.GetUser(ctx, id); err != nil {
+			t.Errorf("Expected the unit of work to see its own user: %v", err)
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("Expected fn's error, got %v", err)
+	}
+	if _, err := testRepo.GetUser(ctx, rolledBack); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the user to be rolled back, got %v", err)
+	}
+
+	var kept int
+	err = testRepo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		kept, err = testRepo.CreateUser(ctx, domain.User{Name: "Kept", Email: "kept@example.com"})
+		if err != nil {
+			return err
+		}
+		// a failed nested unit only undoes its own writes
+		nested := testRepo.InTx(ctx, func(ctx context.Context) error {
+			if err := testRepo.UpdateUser(ctx, domain.User{ID: kept, Name: "Renamed", Email: "kept@example.com"}); err != nil {
+				return err
+			}
+			return failed
+		})
+		if !errors.Is(nested, failed) {
+			t.Errorf("Expected the nested error, got %v", nested)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to run unit of work: %v", err)
+	}
+	user, err := testRepo.GetUser(ctx, kept)
+	if err != nil {
+		t.Fatalf("Expected the user to be committed: %v", err)
+	}
+	if user.Name != "Kept" {
+		t.Errorf("Expected the nested rename to be rolled back, got %q", user.Name)
+	}
+}
+
+func TestRepository_ListenChanges(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	changes := make(chan domain.ChangeEvent, 10)
+	listenCtx, stop := context.WithCancel(ctx)
+	listening := make(chan error, 1)
+	go func() {
+		listening <- testRepo.ListenChanges(listenCtx, func(change domain.ChangeEvent) { changes <- change })
+	}()
+	// give the listener time to start before writing
+	time.Sleep(300 * time.Millisecond)
+
+	testID := fmt.Sprintf("%d", time.Now().UnixNano())
+	id, err := testRepo.CreateUser(ctx, domain.User{Name: "Changed " + testID, Email: "changed_" + testID + "@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	expect := func(op string) {
+		t.Helper()
+		select {
+		case change := <-changes:
+			if change.Entity != domain.EntityUser || change.ID != id || change.Op != op || change.At.IsZero() {
+				t.Errorf("Expected user %d to be %s, got %+v", id, op, change)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for user %d to be %s", id, op)
+		}
+	}
+	expect(domain.ChangeCreated)
+
+	user, err := testRepo.GetUser(ctx, id)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	user.Name = "Renamed " + testID
+	if err := testRepo.UpdateUser(ctx, *user); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	expect(domain.ChangeUpdated)
+
+	if err := testRepo.TrashRecord(ctx, domain.EntityUser, id, 0, "tester"); err != nil {
+		t.Fatalf("Failed to trash user: %v", err)
+	}
+	expect(domain.ChangeDeleted)
+
+	stop()
+	if err := <-listening; err != nil {
+		t.Errorf("Expected the listener to stop cleanly, got %v", err)
+	}
 }
@@ -0,0 +1,404 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// privacyRequestColumns lists the columns scanPrivacyRequest expects
+const privacyRequestColumns = `id, type, entity, entity_id, status, note, due_at, completed_at, reminded_at,
+	created_at, updated_at`
+
+// recordEmails selects the IDs of a record's emails, with the entity as $1 and the ID as $2
+const recordEmails = `SELECT id FROM emails WHERE entity = $1 AND entity_id = $2`
+
+// CreatePrivacyRequest stores a new data subject request
+func (r *Repository) CreatePrivacyRequest(ctx context.Context, request domain.PrivacyRequest) (int, error) {
+	query := `
+	INSERT INTO privacy_requests (type, entity, entity_id, status, note, due_at, completed_at, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id
+	`
+
+	now := time.Now()
+	var id int
+	err := r.conn(ctx).QueryRowContext(ctx, query,
+		request.Type,
+		request.Entity,
+		request.EntityID,
+		request.Status,
+		request.Note,
+		request.DueAt,
+		request.CompletedAt,
+		now,
+		now).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create a privacy request: %w", err)
+	}
+	return id, nil
+}
+
+// GetPrivacyRequests lists the requests with a status, or every request when
+// status is empty, soonest deadline first
+func (r *Repository) GetPrivacyRequests(ctx context.Context, status string) ([]*domain.PrivacyRequest, error) {
+	query := `SELECT ` + privacyRequestColumns + ` FROM privacy_requests WHERE ($1 = '' OR status = $1) ORDER BY due_at, id`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get privacy requests: %w", err)
+	}
+	defer rows.Close()
+
+	var requests []*domain.PrivacyRequest
+	for rows.Next() {
+		request, err := scanPrivacyRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan privacy request row: %w", err)
+		}
+		requests = append(requests, request)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over privacy request rows: %w", err)
+	}
+	return requests, nil
+}
+
+// GetPrivacyRequest retrieves a data subject request by ID
+func (r *Repository) GetPrivacyRequest(ctx context.Context, id int) (*domain.PrivacyRequest, error) {
+	query := `SELECT ` + privacyRequestColumns + ` FROM privacy_requests WHERE id = $1`
+	request, err := scanPrivacyRequest(r.conn(ctx).QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("privacy request not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get privacy request: %w", err)
+	}
+	return request, nil
+}
+
+// CompletePrivacyRequest marks a request as fulfilled
+func (r *Repository) CompletePrivacyRequest(ctx context.Context, id int, completedAt time.Time) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `
+	UPDATE privacy_requests SET status = $1, completed_at = $2, updated_at = $2 WHERE id = $3
+	`, domain.PrivacyCompleted, completedAt, id)
+	if err != nil {
+		return fmt.Errorf("failed to complete privacy request: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to complete privacy request: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("privacy request not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// ClaimPrivacyReminder marks a pending request as reminded at now, unless it
+// was already reminded after since, and reports whether it did. Only one of
+// several workers racing for the same request wins.
+func (r *Repository) ClaimPrivacyReminder(ctx context.Context, id int, now, since time.Time) (bool, error) {
+	result, err := r.conn(ctx).ExecContext(ctx, `
+	UPDATE privacy_requests SET reminded_at = $1
+	WHERE id = $2 AND status = $3 AND (reminded_at IS NULL OR reminded_at <= $4)
+	`, now, id, domain.PrivacyPending, since)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim privacy reminder: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim privacy reminder: %w", err)
+	}
+	return affected == 1, nil
+}
+
+// GetPersonalData compiles everything stored about a record. It reads in one
+// repeatable read transaction, so the export is a consistent snapshot.
+func (r *Repository) GetPersonalData(ctx context.Context, entity string, entityID int) (*domain.PersonalData, error) {
+	var data *domain.PersonalData
+	err := r.inTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, func(tx DBConnection) error {
+		var err error
+		data, err = readPersonalData(ctx, tx, entity, entityID)
+		return err
+	})
+	return data, err
+}
+
+// readPersonalData runs the queries of an export in tx
+func readPersonalData(ctx context.Context, tx DBConnection, entity string, entityID int) (*domain.PersonalData, error) {
+	var data domain.PersonalData
+	var err error
+	data.Record, err = scanUser(tx.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, entityID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("record not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get record: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+emailColumns+` FROM emails WHERE entity = $1 AND entity_id = $2 ORDER BY id`, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get emails: %w", err)
+	}
+	for rows.Next() {
+		email, err := scanEmail(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan email row: %w", err)
+		}
+		data.Emails = append(data.Emails, email)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over email rows: %w", err)
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+	SELECT id, email_id, type, url, ip, user_agent, created_at
+	FROM email_events WHERE email_id IN (`+recordEmails+`) ORDER BY id
+	`, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email events: %w", err)
+	}
+	for rows.Next() {
+		var event domain.EmailEvent
+		if err := rows.Scan(&event.ID, &event.EmailID, &event.Type, &event.URL, &event.IP, &event.UserAgent, &event.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan email event row: %w", err)
+		}
+		data.EmailEvents = append(data.EmailEvents, &event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over email event rows: %w", err)
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+	SELECT id, email_id, filename, content_type, size, data, created_at
+	FROM email_attachments WHERE email_id IN (`+recordEmails+`) ORDER BY id
+	`, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email attachments: %w", err)
+	}
+	for rows.Next() {
+		var attachment domain.EmailAttachment
+		if err := rows.Scan(
+			&attachment.ID,
+			&attachment.EmailID,
+			&attachment.Filename,
+			&attachment.ContentType,
+			&attachment.Size,
+			&attachment.Data,
+			&attachment.CreatedAt,
+		); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan email attachment row: %w", err)
+		}
+		data.Attachments = append(data.Attachments, &attachment)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over email attachment rows: %w", err)
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+	SELECT id, entity, entity_id, type, summary, email_id, created_at
+	FROM activities WHERE entity = $1 AND entity_id = $2 ORDER BY id
+	`, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get activities: %w", err)
+	}
+	if data.Activities, err = scanActivities(rows); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+	SELECT id, entity, entity_id, channel, purpose, status, lawful_basis, source, ip, user_agent, created_at
+	FROM consents WHERE entity = $1 AND entity_id = $2 ORDER BY id
+	`, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get consents: %w", err)
+	}
+	if data.Consents, err = scanConsents(rows); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+	SELECT `+enrollmentColumns+` FROM sequence_enrollments WHERE entity = $1 AND entity_id = $2 ORDER BY id
+	`, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sequence enrollments: %w", err)
+	}
+	if data.Enrollments, err = scanEnrollments(rows); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+	SELECT s.id, s.form_id, s.entity_id, s.status, s.ip, s.referrer, s.utm, s.data, s.created_at
+	FROM form_submissions s JOIN forms f ON f.id = s.form_id
+	WHERE f.entity = $1 AND s.entity_id = $2 ORDER BY s.id
+	`, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get form submissions: %w", err)
+	}
+	if data.FormSubmissions, err = scanFormSubmissions(rows); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+	SELECT id, entity, entity_id, owner_id, previous_owner_id, rule_id, reason, created_at
+	FROM assignments WHERE entity = $1 AND entity_id = $2 ORDER BY id
+	`, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assignments: %w", err)
+	}
+	if data.Assignments, err = scanAssignments(rows); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+	SELECT `+automationRunColumns+` FROM automation_runs WHERE entity = $1 AND entity_id = $2 ORDER BY id
+	`, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get automation runs: %w", err)
+	}
+	if data.AutomationRuns, err = scanAutomationRuns(rows); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+	SELECT `+auditEventColumns+` FROM audit_events WHERE entity = $1 AND entity_id = $2 ORDER BY id
+	`, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit events: %w", err)
+	}
+	if data.AuditEvents, err = scanAuditEvents(rows); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+	SELECT `+fieldChangeColumns+` FROM field_history WHERE entity = $1 AND entity_id = $2 ORDER BY changed_at, id
+	`, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get field history: %w", err)
+	}
+	if data.FieldHistory, err = scanFieldChanges(rows); err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// ErasePersonalData anonymizes a record and everything logged about it in one
+// transaction. Rows are kept with their IDs, types, statuses, counters and
+// timestamps so reports still add up; names, addresses, content, IPs and
+// user agents are cleared, and attachments are deleted.
+func (r *Repository) ErasePersonalData(ctx context.Context, entity string, entityID int) error {
+	return r.inTx(ctx, nil, func(tx DBConnection) error {
+		return erasePersonalData(ctx, tx, entity, entityID)
+	})
+}
+
+// eraseRecord anonymizes a user, with the time as $1 and the ID as $2. The
+// address must stay unique, .invalid can never receive email.
+const eraseRecord = `
+UPDATE users SET name = 'Erased', email = 'erased-' || id || '@erased.invalid',
+	custom_fields = '{}', score_breakdown = '[]', updated_at = $1, version = version + 1
+WHERE id = $2`
+
+// erasures clear what is logged about a record once the record itself is
+// erased, each runs with the entity as $1 and the ID as $2
+var erasures = []struct {
+	what  string
+	query string
+}{
+	{"email attachments", `DELETE FROM email_attachments WHERE email_id IN (` + recordEmails + `)`},
+	{"email events", `UPDATE email_events SET ip = '', user_agent = '' WHERE email_id IN (` + recordEmails + `)`},
+	{"emails", `
+	UPDATE emails SET from_address = '', to_address = '', subject = '', body_text = '', body_html = '',
+		in_reply_to = '', error = '', links = '[]'
+	WHERE entity = $1 AND entity_id = $2`},
+	{"activities", `UPDATE activities SET summary = '' WHERE entity = $1 AND entity_id = $2`},
+	{"consents", `UPDATE consents SET ip = '', user_agent = '' WHERE entity = $1 AND entity_id = $2`},
+	{"form submissions", `
+	UPDATE form_submissions SET ip = '', referrer = '', data = '{}'
+	WHERE entity_id = $2 AND form_id IN (SELECT id FROM forms WHERE entity = $1)`},
+	{"automation runs", `UPDATE automation_runs SET log = '', error = '' WHERE entity = $1 AND entity_id = $2`},
+	{"sequence enrollments", `
+	UPDATE sequence_enrollments SET status = CASE WHEN status = 'active' THEN 'removed' ELSE status END, error = ''
+	WHERE entity = $1 AND entity_id = $2`},
+	// webhook payloads carry a copy of the record
+	{"webhook deliveries", `
+	UPDATE webhook_deliveries SET payload = jsonb_build_object('id', $2::int, 'erased', true)
+	WHERE outbox_id IN (SELECT id FROM outbox WHERE entity = $1 AND entity_id = $2)`},
+	{"outbox", `
+	UPDATE outbox SET payload = jsonb_build_object('id', $2::int, 'erased', true)
+	WHERE entity = $1 AND entity_id = $2`},
+	// the changes hash keeps the audit chain verifiable
+	{"audit events", `
+	UPDATE audit_events SET changes = '{}', redacted = TRUE
+	WHERE entity = $1 AND entity_id = $2 AND NOT redacted`},
+	// consents are audited with the IP and user agent they were given from
+	{"consent audit events", `
+	UPDATE audit_events SET changes = '{}', redacted = TRUE
+	WHERE entity = '` + domain.AuditConsent + `' AND NOT redacted
+		AND entity_id IN (SELECT id FROM consents WHERE entity = $1 AND entity_id = $2)`},
+	// past values of the record are as personal as the current ones
+	{"field history", `DELETE FROM field_history WHERE entity = $1 AND entity_id = $2`},
+}
+
+// erasePersonalData runs the statements of an erasure in tx
+func erasePersonalData(ctx context.Context, tx DBConnection, entity string, entityID int) error {
+	now := time.Now()
+	result, err := tx.ExecContext(ctx, eraseRecord, now, entityID)
+	if err != nil {
+		return fmt.Errorf("failed to erase record: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to erase record: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("record not found: %w", ErrNotFound)
+	}
+
+	for _, statement := range erasures {
+		if _, err := tx.ExecContext(ctx, statement.query, entity, entityID); err != nil {
+			return fmt.Errorf("failed to erase %s: %w", statement.what, err)
+		}
+	}
+	return nil
+}
+
+// scanPrivacyRequest scans a row selected with privacyRequestColumns
+func scanPrivacyRequest(row RowScanner) (*domain.PrivacyRequest, error) {
+	var request domain.PrivacyRequest
+	var completedAt, remindedAt sql.NullTime
+	if err := row.Scan(
+		&request.ID,
+		&request.Type,
+		&request.Entity,
+		&request.EntityID,
+		&request.Status,
+		&request.Note,
+		&request.DueAt,
+		&completedAt,
+		&remindedAt,
+		&request.CreatedAt,
+		&request.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if completedAt.Valid {
+		request.CompletedAt = &completedAt.Time
+	}
+	if remindedAt.Valid {
+		request.RemindedAt = &remindedAt.Time
+	}
+	return &request, nil
+}
@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/config"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// replicaCheckTimeout bounds each health check of a replica
+const replicaCheckTimeout = 2 * time.Second
+
+// replicaLagQuery returns how many seconds a replica is behind the primary,
+// NULL when it can't tell. A replica with a WAL receiver that has replayed
+// all it received isn't behind, however long ago the last write was. Without
+// one it has replayed all it will ever receive, so it is as far behind as
+// the last transaction it replayed is old. The primary itself returns 0.
+const replicaLagQuery = `SELECT CASE
+	WHEN NOT pg_is_in_recovery() THEN 0
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn()
+		AND EXISTS (SELECT 1 FROM pg_stat_wal_receiver) THEN 0
+	ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+END::float8`
+
+// replica is a read replica and whether its last health check passed
+type replica struct {
+	number  int
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// replicaSet is the read replicas of a Repository, checked in the background
+// until it is closed
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+	maxLag   time.Duration
+	stop     context.CancelFunc
+	stopped  sync.WaitGroup
+}
+
+type replicaReadsKey struct{}
+
+// AllowReplicaReads returns a context whose reads of lists, search and
+// reports may be served by a read replica, so may miss the latest writes.
+// Reads made without it go to the primary, which is what background workers
+// and requests that write need.
+func AllowReplicaReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaReadsKey{}, true)
+}
+
+// ReplicaReadsAllowed reports whether ctx was made with AllowReplicaReads
+func ReplicaReadsAllowed(ctx context.Context) bool {
+	return ctx.Value(replicaReadsKey{}) != nil
+}
+
+// UseReplicas connects the read replicas of cfg. Reads of lists, search and
+// reports made with AllowReplicaReads go to a healthy one in turn; a replica
+// that can't be reached or lags by more than cfg.ReplicaMaxLag is skipped
+// until a later check finds it back, and with none healthy reads stay on the
+// primary. Replicas down at startup don't fail it.
+func (r *Repository) UseReplicas(cfg config.DBConfig) error {
+	if len(cfg.ReplicaDSNs) == 0 {
+		return nil
+	}
+
+	set := &replicaSet{maxLag: cfg.ReplicaMaxLag}
+	for i, dsn := range cfg.ReplicaDSNs {
+		db, err := openPostgresDB(dsn, cfg)
+		if err != nil {
+			set.close()
+			return fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		set.replicas = append(set.replicas, &replica{number: i + 1, db: db})
+	}
+	set.checkAll(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	set.stop = cancel
+	set.stopped.Add(1)
+	go func() {
+		defer set.stopped.Done()
+		ticker := time.NewTicker(cfg.ReplicaCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				set.checkAll(ctx)
+			}
+		}
+	}()
+
+	r.replicas = set
+	return nil
+}
+
+// checkAll checks the health of every replica
+func (s *replicaSet) checkAll(ctx context.Context) {
+	for _, rep := range s.replicas {
+		ctx, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+		var lag sql.NullFloat64
+		err := rep.db.QueryRowContext(ctx, replicaLagQuery).Scan(&lag)
+		cancel()
+
+		switch {
+		case err != nil:
+		case !lag.Valid:
+			err = errors.New("not receiving from the primary and nothing replayed yet")
+		case time.Duration(lag.Float64*float64(time.Second)) > s.maxLag:
+			err = fmt.Errorf("%.1fs behind the primary", lag.Float64)
+		}
+		rep.setHealthy(err)
+	}
+}
+
+// setHealthy marks the replica healthy when err is nil and down otherwise,
+// logging changes
+func (rep *replica) setHealthy(err error) {
+	if was := rep.healthy.Swap(err == nil); was != (err == nil) {
+		if err == nil {
+			log.Printf("Read replica %d is back, reading from it", rep.number)
+		} else {
+			log.Printf("Read replica %d is down, reading from the primary instead: %v", rep.number, err)
+		}
+	}
+}
+
+// pick returns the next healthy replica, nil when there is none
+func (s *replicaSet) pick() *replica {
+	start := s.next.Add(1)
+	for i := range s.replicas {
+		rep := s.replicas[(start+uint64(i))%uint64(len(s.replicas))]
+		if rep.healthy.Load() {
+			return rep
+		}
+	}
+	return nil
+}
+
+// close stops the health checks and disconnects the replicas
+func (s *replicaSet) close() error {
+	if s.stop != nil {
+		s.stop()
+		s.stopped.Wait()
+	}
+	var errs []error
+	for _, rep := range s.replicas {
+		errs = append(errs, rep.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// replicaReads reports whether a read of a list, search or report made with
+// ctx may go to a replica: replicas are in use, ctx allows it and isn't in a
+// unit of work
+func (r *Repository) replicaReads(ctx context.Context) bool {
+	if r.replicas == nil || !ReplicaReadsAllowed(ctx) {
+		return false
+	}
+	_, inTx := r.unitOfWork(ctx)
+	return !inTx
+}
+
+// reader returns what a read of a list, search or report made with ctx should
+// run on: a healthy replica when replicaReads allows one, or what conn returns
+func (r *Repository) reader(ctx context.Context) DBConnection {
+	if r.replicaReads(ctx) {
+		if rep := r.replicas.pick(); rep != nil {
+			return replicaConn{replica: rep, primary: r.conn(ctx)}
+		}
+	}
+	return r.conn(ctx)
+}
+
+// replicaConn reads from a replica. When the replica can't be reached the
+// read is made on the primary and the replica is skipped until it passes a
+// health check again.
+type replicaConn struct {
+	replica *replica
+	primary DBConnection
+}
+
+// unreachable reports whether a failed read should be retried on the
+// primary: not when the replica answered with an error of the query's own,
+// nor when the caller gave up
+func (c replicaConn) unreachable(ctx context.Context, err error) bool {
+	var pgErr *pgconn.PgError
+	if err == nil || ctx.Err() != nil || errors.As(err, &pgErr) {
+		return false
+	}
+	c.replica.setHealthy(err)
+	return true
+}
+
+func (c replicaConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	row := c.replica.db.QueryRowContext(ctx, query, args...)
+	if c.unreachable(ctx, row.Err()) {
+		return c.primary.QueryRowContext(ctx, query, args...)
+	}
+	return row
+}
+
+func (c replicaConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	rows, err := c.replica.db.QueryContext(ctx, query, args...)
+	if c.unreachable(ctx, err) {
+		return c.primary.QueryContext(ctx, query, args...)
+	}
+	return rows, err
+}
+
+// ExecContext writes to the primary, replicas are read-only
+func (c replicaConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.primary.ExecContext(ctx, query, args...)
+}
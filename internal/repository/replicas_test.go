@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/config"
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+func TestReplicaSet_Pick(t *testing.T) {
+	set := &replicaSet{replicas: []*replica{{number: 1}, {number: 2}, {number: 3}}}
+	if rep := set.pick(); rep != nil {
+		t.Fatalf("Expected no replica while none is healthy, got %d", rep.number)
+	}
+
+	set.replicas[0].healthy.Store(true)
+	set.replicas[2].healthy.Store(true)
+	picked := map[int]int{}
+	for i := 0; i < 10; i++ {
+		picked[set.pick().number]++
+	}
+	if picked[2] != 0 || picked[1] == 0 || picked[3] == 0 {
+		t.Errorf("Expected reads spread over the healthy replicas only, got %v", picked)
+	}
+}
+
+func TestRepository_Replicas(t *testing.T) {
+	if testDriver != config.DriverPostgres {
+		t.Skip("Replicas need Postgres; set REPO_TESTS=true to run")
+	}
+	ctx := context.Background()
+
+	// the primary stands in for a healthy replica, next to one that is down
+	cfg := testDBConfig()
+	cfg.MaxConns = 2
+	cfg.ReplicaDSNs = []string{cfg.DSN(), "host=127.0.0.1 port=1 user=postgres dbname=none connect_timeout=1"}
+	cfg.ReplicaCheckInterval = time.Hour
+	cfg.ReplicaMaxLag = time.Second
+
+	db, err := NewPostgresDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	repo := NewRepository(db)
+	if err := repo.UseReplicas(cfg); err != nil {
+		t.Fatalf("UseReplicas: %v", err)
+	}
+	defer repo.Close()
+
+	if !repo.replicas.replicas[0].healthy.Load() || repo.replicas.replicas[1].healthy.Load() {
+		t.Fatal("Expected only the reachable replica to pass its health check")
+	}
+
+	id, err := repo.CreateUser(ctx, domain.User{Name: "Replicated", Email: "replicated@example.com"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	defer repo.DeleteUser(ctx, id)
+
+	if !repo.replicaReads(AllowReplicaReads(ctx)) || repo.replicaReads(ctx) {
+		t.Error("Expected only contexts allowing it to read from replicas")
+	}
+	err = repo.InTx(AllowReplicaReads(ctx), func(ctx context.Context) error {
+		if repo.replicaReads(ctx) {
+			t.Error("Expected a unit of work to read from its own transaction")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("InTx: %v", err)
+	}
+
+	// reads from the down replica fall back to the primary
+	repo.replicas.replicas[1].healthy.Store(true)
+	for i := 0; i < 4; i++ {
+		users, err := repo.GetUsers(AllowReplicaReads(ctx), domain.ListOptions{})
+		if err != nil {
+			t.Fatalf("GetUsers: %v", err)
+		}
+		if len(users) == 0 {
+			t.Fatal("Expected the user to be listed")
+		}
+	}
+	if repo.replicas.replicas[1].healthy.Load() {
+		t.Error("Expected the unreachable replica to be marked down after a failed read")
+	}
+}
@@ -3,30 +3,365 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/segment"
 )
 
 // UserRepository defines the interface for user data operations
 type UserRepository interface {
 	// GetUser retrieves a user by ID
 	GetUser(ctx context.Context, id int) (*domain.User, error)
-	GetUsers(ctx context.Context) ([]*domain.User, error)
-	// CreateUser creates a new user
+	// GetUserByEmail retrieves a user by email address, ignoring case
+	GetUserByEmail(ctx context.Context, email string) (*domain.User, error)
+	// GetUsers lists users matching the filters in opts
+	GetUsers(ctx context.Context, opts domain.ListOptions) ([]*domain.User, error)
+	// GetUserBatch lists up to limit users with an ID above afterID in ID order, for batch jobs
+	GetUserBatch(ctx context.Context, afterID, limit int) ([]*domain.User, error)
+	// GetUsersByID lists the live users among ids in ID order
+	GetUsersByID(ctx context.Context, ids []int) ([]*domain.User, error)
+	// CreateUser creates a new user, or returns ErrDuplicate when a live user
+	// has the email address
 	CreateUser(ctx context.Context, user domain.User) (int, error)
+	// UpdateUser saves the name, email and custom fields of an existing user,
+	// failing with ErrVersionConflict unless it is still at user.Version and
+	// with ErrDuplicate when another live user has the email address
+	UpdateUser(ctx context.Context, user domain.User) error
+	// DeleteUser deletes a user for good, erasing what was logged about them
+	DeleteUser(ctx context.Context, id int) error
+	// WriteUsers applies a batch of creates, updates and trashes in one
+	// transaction and returns each write's error, nil when it was applied.
+	// Atomic batches are rolled back entirely when a write fails, otherwise
+	// only the failed writes are. Created users get their ID set in writes.
+	WriteUsers(ctx context.Context, writes []domain.UserWrite, atomic bool) ([]error, error)
 
 	// Close closes any resources used by the repository
 	Close() error
 }
 
+// SearchRepository defines the interface for full-text search across records
+type SearchRepository interface {
+	// Search returns the best matching records for the query, highest rank first
+	Search(ctx context.Context, query string, limit int) ([]*domain.SearchResult, error)
+}
+
+// CustomFieldRepository defines the interface for custom field definitions
+type CustomFieldRepository interface {
+	// CreateCustomField stores a new field definition, failing with
+	// ErrDuplicate when the entity already has a field with its key
+	CreateCustomField(ctx context.Context, def domain.CustomFieldDefinition) (int, error)
+	// GetCustomFields lists the field definitions for an entity
+	GetCustomFields(ctx context.Context, entity string) ([]*domain.CustomFieldDefinition, error)
+	// GetCustomField retrieves a field definition by ID
+	GetCustomField(ctx context.Context, id int) (*domain.CustomFieldDefinition, error)
+	// UpdateCustomField saves a definition's label and history tracking,
+	// failing with ErrVersionConflict unless it is still at def.Version
+	UpdateCustomField(ctx context.Context, def domain.CustomFieldDefinition) error
+	// DeleteCustomField removes a field definition
+	DeleteCustomField(ctx context.Context, id int) error
+}
+
+// TagRepository defines the interface for the tag registry and tagging records
+type TagRepository interface {
+	// GetTags lists the registered tags with how many records use them
+	GetTags(ctx context.Context) ([]*domain.Tag, error)
+	// CreateTag registers a tag, returning the existing ID if it is already registered
+	CreateTag(ctx context.Context, name string) (int, error)
+	// AddTags tags the records, registering new tags, and returns how many links were added
+	AddTags(ctx context.Context, entity string, ids []int, tags []string) (int, error)
+	// RemoveTags untags the records and returns how many links were removed
+	RemoveTags(ctx context.Context, entity string, ids []int, tags []string) (int, error)
+}
+
+// SegmentRepository defines the interface for saved segments
+type SegmentRepository interface {
+	CreateSegment(ctx context.Context, seg domain.Segment) (int, error)
+	GetSegment(ctx context.Context, id int) (*domain.Segment, error)
+	GetSegments(ctx context.Context) ([]*domain.Segment, error)
+	DeleteSegment(ctx context.Context, id int) error
+	// GetSegmentUsers lists the users matching a parsed segment expression
+	GetSegmentUsers(ctx context.Context, expr segment.Expr) ([]*domain.User, error)
+	// CountSegmentUsers counts the users matching a parsed segment expression
+	CountSegmentUsers(ctx context.Context, expr segment.Expr) (int, error)
+}
+
+// ScoringRepository defines the interface for scoring rules and stored scores
+type ScoringRepository interface {
+	CreateScoringRule(ctx context.Context, rule domain.ScoringRule) (int, error)
+	// GetScoringRules lists the rules for an entity in ID order
+	GetScoringRules(ctx context.Context, entity string) ([]*domain.ScoringRule, error)
+	GetScoringRule(ctx context.Context, id int) (*domain.ScoringRule, error)
+	DeleteScoringRule(ctx context.Context, id int) error
+	// UpdateUserScore stores a recomputed score and the rules that contributed to it
+	UpdateUserScore(ctx context.Context, id int, score int, breakdown []domain.ScoreContribution) error
+}
+
+// AssignmentRepository defines the interface for assignment rules and record owners
+type AssignmentRepository interface {
+	CreateAssignmentRule(ctx context.Context, rule domain.AssignmentRule) (int, error)
+	// GetAssignmentRules lists the rules for an entity in position order
+	GetAssignmentRules(ctx context.Context, entity string) ([]*domain.AssignmentRule, error)
+	GetAssignmentRule(ctx context.Context, id int) (*domain.AssignmentRule, error)
+	DeleteAssignmentRule(ctx context.Context, id int) error
+	// NextAssignmentTurn advances a rule's rotation and returns the turn it was on
+	NextAssignmentTurn(ctx context.Context, ruleID int) (int, error)
+	// GetAvailableUsers returns the given users that exist and are not out of office
+	GetAvailableUsers(ctx context.Context, ids []int) ([]int, error)
+	// CountOwnedRecords counts the records of an entity owned by each of the users
+	CountOwnedRecords(ctx context.Context, entity string, ownerIDs []int) (map[int]int, error)
+	// AssignOwner moves the records to a new owner, logging an assignment for every
+	// record whose owner changed, and returns how many changed
+	AssignOwner(ctx context.Context, entity string, ids []int, ownerID int, ruleID *int, reason string) (int, error)
+	// GetAssignments lists a record's assignment history, newest first
+	GetAssignments(ctx context.Context, entity string, entityID int) ([]*domain.Assignment, error)
+	// SetOutOfOffice marks a user as unavailable for new assignments, failing
+	// with ErrVersionConflict unless the user is still at version
+	SetOutOfOffice(ctx context.Context, userID int, outOfOffice bool, version int) error
+}
+
+// AutomationRepository defines the interface for automations and their run queue
+type AutomationRepository interface {
+	CreateAutomation(ctx context.Context, automation domain.Automation) (int, error)
+	// GetAutomations lists the automations for an entity in ID order
+	GetAutomations(ctx context.Context, entity string) ([]*domain.Automation, error)
+	GetAutomation(ctx context.Context, id int) (*domain.Automation, error)
+	DeleteAutomation(ctx context.Context, id int) error
+	// CreateAutomationRun queues a run. When the run has a dedupe key that was
+	// already used for the same automation and record, nothing is queued and
+	// created is false.
+	CreateAutomationRun(ctx context.Context, run domain.AutomationRun) (id int, created bool, err error)
+	// ClaimAutomationRuns marks up to limit due pending runs as running and returns them
+	ClaimAutomationRuns(ctx context.Context, now time.Time, limit int) ([]*domain.AutomationRun, error)
+	// FinishAutomationRun stores the outcome of a claimed run
+	FinishAutomationRun(ctx context.Context, run domain.AutomationRun) error
+	// GetAutomationRuns lists the latest runs of an automation, newest first
+	GetAutomationRuns(ctx context.Context, automationID int) ([]*domain.AutomationRun, error)
+}
+
+// WebhookRepository defines the interface for webhook subscriptions, the
+// outbox of change events and the delivery queue
+type WebhookRepository interface {
+	CreateWebhookSubscription(ctx context.Context, subscription domain.WebhookSubscription) (int, error)
+	// GetWebhookSubscriptions lists every subscription in ID order
+	GetWebhookSubscriptions(ctx context.Context) ([]*domain.WebhookSubscription, error)
+	GetWebhookSubscription(ctx context.Context, id int) (*domain.WebhookSubscription, error)
+	DeleteWebhookSubscription(ctx context.Context, id int) error
+	// FanOutOutbox queues a delivery for every subscription matching each of up
+	// to limit unprocessed outbox events, marks those events processed and
+	// returns how many events were processed
+	FanOutOutbox(ctx context.Context, now time.Time, limit int) (int, error)
+	// ClaimWebhookDeliveries marks up to limit due pending deliveries as sending and returns them
+	ClaimWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]*domain.WebhookDelivery, error)
+	// FinishWebhookDelivery stores the outcome of a claimed delivery
+	FinishWebhookDelivery(ctx context.Context, delivery domain.WebhookDelivery) error
+	// GetWebhookDeliveries lists the latest deliveries of a subscription, newest first
+	GetWebhookDeliveries(ctx context.Context, subscriptionID int) ([]*domain.WebhookDelivery, error)
+	// RedeliverWebhook queues a delivery to be sent again
+	RedeliverWebhook(ctx context.Context, id int, now time.Time) error
+}
+
+// FormRepository defines the interface for web-to-lead forms and their submissions
+type FormRepository interface {
+	CreateForm(ctx context.Context, form domain.Form) (int, error)
+	// GetForms lists every form in ID order
+	GetForms(ctx context.Context) ([]*domain.Form, error)
+	GetForm(ctx context.Context, id int) (*domain.Form, error)
+	GetFormByKey(ctx context.Context, key string) (*domain.Form, error)
+	DeleteForm(ctx context.Context, id int) error
+	CreateFormSubmission(ctx context.Context, submission domain.FormSubmission) (int, error)
+	// GetFormSubmissions lists the latest submissions of a form, newest first
+	GetFormSubmissions(ctx context.Context, formID int) ([]*domain.FormSubmission, error)
+}
+
+// EmailRepository defines the interface for email templates, sent emails and their tracking
+type EmailRepository interface {
+	CreateEmailTemplate(ctx context.Context, template domain.EmailTemplate) (int, error)
+	// GetEmailTemplates lists every template in name order
+	GetEmailTemplates(ctx context.Context) ([]*domain.EmailTemplate, error)
+	GetEmailTemplate(ctx context.Context, id int) (*domain.EmailTemplate, error)
+	DeleteEmailTemplate(ctx context.Context, id int) error
+	CreateEmail(ctx context.Context, email domain.Email) (int, error)
+	// GetEmails lists a record's emails, newest first
+	GetEmails(ctx context.Context, entity string, entityID int) ([]*domain.Email, error)
+	GetEmailByToken(ctx context.Context, token string) (*domain.Email, error)
+	GetEmail(ctx context.Context, id int) (*domain.Email, error)
+	GetEmailByMessageID(ctx context.Context, messageID string) (*domain.Email, error)
+	// CreateInboundEmail stores a received email, its attachments and the
+	// activities logging it on matched records in one transaction
+	CreateInboundEmail(ctx context.Context, email domain.Email, attachments []domain.EmailAttachment, activities []domain.Activity) (int, error)
+	// GetEmailAttachments lists an email's attachments without their content
+	GetEmailAttachments(ctx context.Context, emailID int) ([]*domain.EmailAttachment, error)
+	GetEmailAttachment(ctx context.Context, emailID, id int) (*domain.EmailAttachment, error)
+	// RecordEmailEvent logs an open or click and updates the email's counters
+	RecordEmailEvent(ctx context.Context, event domain.EmailEvent) error
+}
+
+// ActivityRepository defines the interface for record timelines
+type ActivityRepository interface {
+	CreateActivity(ctx context.Context, activity domain.Activity) (int, error)
+	// GetActivities lists a record's latest activities, newest first
+	GetActivities(ctx context.Context, entity string, entityID int) ([]*domain.Activity, error)
+}
+
+// SequenceRepository defines the interface for outreach sequences and their enrollments
+type SequenceRepository interface {
+	CreateSequence(ctx context.Context, sequence domain.Sequence) (int, error)
+	GetSequences(ctx context.Context) ([]*domain.Sequence, error)
+	GetSequence(ctx context.Context, id int) (*domain.Sequence, error)
+	DeleteSequence(ctx context.Context, id int) error
+	// GetSequenceStats counts enrollments by status, keyed by sequence ID
+	GetSequenceStats(ctx context.Context) (map[int]*domain.SequenceStats, error)
+	// EnrollInSequence enrolls existing records not already active in the sequence
+	EnrollInSequence(ctx context.Context, sequenceID int, entity string, ids []int, startAt time.Time) (int, error)
+	GetSequenceEnrollments(ctx context.Context, sequenceID int) ([]*domain.SequenceEnrollment, error)
+	// StopEnrollments ends active enrollments in one sequence, or all when sequenceID is 0
+	StopEnrollments(ctx context.Context, sequenceID int, entity string, ids []int, status string) (int, error)
+	ClaimSequenceEnrollments(ctx context.Context, now, leaseUntil time.Time, limit int) ([]*domain.SequenceEnrollment, error)
+	FinishSequenceStep(ctx context.Context, enrollment domain.SequenceEnrollment) error
+}
+
+// ConsentRepository defines the interface for consent history and the email suppression list
+type ConsentRepository interface {
+	CreateConsent(ctx context.Context, consent domain.Consent) (int, error)
+	// GetConsents lists a record's consent changes, newest first
+	GetConsents(ctx context.Context, entity string, entityID int) ([]*domain.Consent, error)
+	// AddSuppression suppresses an address, keeping the existing entry if it already is
+	AddSuppression(ctx context.Context, suppression domain.EmailSuppression) error
+	GetSuppression(ctx context.Context, email string) (*domain.EmailSuppression, error)
+	// GetSuppressions lists every suppressed address, newest first
+	GetSuppressions(ctx context.Context) ([]*domain.EmailSuppression, error)
+	DeleteSuppression(ctx context.Context, id int) error
+	// LiftSuppression removes an address's suppression if it has the given reason
+	LiftSuppression(ctx context.Context, email, reason string) error
+}
+
+// PrivacyRepository defines the interface for data subject requests and the
+// data they cover
+type PrivacyRepository interface {
+	CreatePrivacyRequest(ctx context.Context, request domain.PrivacyRequest) (int, error)
+	// GetPrivacyRequests lists the requests with a status, or all of them when
+	// status is empty, soonest deadline first
+	GetPrivacyRequests(ctx context.Context, status string) ([]*domain.PrivacyRequest, error)
+	GetPrivacyRequest(ctx context.Context, id int) (*domain.PrivacyRequest, error)
+	CompletePrivacyRequest(ctx context.Context, id int, completedAt time.Time) error
+	// ClaimPrivacyReminder marks a pending request as reminded unless it already
+	// was after since, and reports whether it did
+	ClaimPrivacyReminder(ctx context.Context, id int, now, since time.Time) (bool, error)
+	// GetPersonalData compiles everything stored about a record
+	GetPersonalData(ctx context.Context, entity string, entityID int) (*domain.PersonalData, error)
+	// ErasePersonalData anonymizes a record and everything logged about it
+	ErasePersonalData(ctx context.Context, entity string, entityID int) error
+}
+
+// AuditRepository defines the interface for the append-only audit log
+type AuditRepository interface {
+	// AppendAuditEvent chains an event onto the end of the log, setting its
+	// time and hashes
+	AppendAuditEvent(ctx context.Context, event domain.AuditEvent) (int, error)
+	// GetAuditEvents lists the events matching the filter, oldest first
+	GetAuditEvents(ctx context.Context, filter domain.AuditFilter) ([]*domain.AuditEvent, error)
+}
+
+// FieldHistoryRepository defines the interface for the history of tracked fields
+type FieldHistoryRepository interface {
+	// RecordFieldChanges stores changes to tracked fields
+	RecordFieldChanges(ctx context.Context, changes []domain.FieldChange) error
+	// GetFieldHistory lists the changes to a record made after since, or all
+	// of them when since is nil, newest first
+	GetFieldHistory(ctx context.Context, entity string, entityID int, since *time.Time) ([]*domain.FieldChange, error)
+}
+
+// TrashRepository defines the interface for soft deleted records. Entity is
+// EntityUser or one of the audited kinds, e.g. AuditForm. Trashed records are
+// left out of every other read and deleted for good with the DeleteX methods.
+type TrashRepository interface {
+	// TrashRecord moves a live record to the trash, failing with
+	// ErrVersionConflict unless it is still at version
+	TrashRecord(ctx context.Context, entity string, id, version int, deletedBy string) error
+	// RestoreRecord takes a trashed record back out of the trash, or returns
+	// ErrDuplicate when a live record has taken its email address or key
+	RestoreRecord(ctx context.Context, entity string, id int) error
+	// GetTrashItem retrieves a trashed record
+	GetTrashItem(ctx context.Context, entity string, id int) (*domain.TrashItem, error)
+	// GetTrash lists the trashed records of an entity, or of every entity when
+	// entity is empty, deleted before the given time when it is set, newest first
+	GetTrash(ctx context.Context, entity string, before *time.Time) ([]*domain.TrashItem, error)
+}
+
+// IdempotencyRepository defines the interface for Idempotency-Key records
+type IdempotencyRepository interface {
+	// ClaimIdempotencyKey stores a new key, or replaces an expired one, and
+	// returns nil. When the key is already taken it returns the stored key.
+	ClaimIdempotencyKey(ctx context.Context, key domain.IdempotencyKey) (*domain.IdempotencyKey, error)
+	// CompleteIdempotencyKey saves the response to replay for a claimed key
+	// until expiresAt
+	CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, headers map[string]string, body []byte, expiresAt time.Time) error
+	// ReleaseIdempotencyKey deletes a key whose request is still running, so a
+	// retry runs it again
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+	// DeleteExpiredIdempotencyKeys deletes keys that expired before now and
+	// returns how many there were
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error)
+}
+
+// ChangeFeedRepository defines the interface for following record changes
+type ChangeFeedRepository interface {
+	// ListenChanges calls fn with every change committed to a record from
+	// now on, by this instance or any other, until ctx is done. It returns
+	// an error when it loses the database, changes made meanwhile are missed.
+	ListenChanges(ctx context.Context, fn func(domain.ChangeEvent)) error
+}
+
+// UnitOfWork defines the interface for running several repository calls as one
+type UnitOfWork interface {
+	// InTx runs fn in a transaction that every repository call made with the
+	// ctx it is given joins. The transaction commits when fn returns nil and
+	// rolls back otherwise. fn may run more than once when the database asks
+	// for a retry, so side effects outside the store belong after InTx.
+	// Nested calls run in a savepoint of the outer transaction.
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Store groups every repository interface the service layer depends on
+type Store interface {
+	UserRepository
+	SearchRepository
+	CustomFieldRepository
+	TagRepository
+	SegmentRepository
+	ScoringRepository
+	AssignmentRepository
+	AutomationRepository
+	WebhookRepository
+	FormRepository
+	EmailRepository
+	ActivityRepository
+	SequenceRepository
+	ConsentRepository
+	PrivacyRepository
+	AuditRepository
+	FieldHistoryRepository
+	TrashRepository
+	IdempotencyRepository
+	ChangeFeedRepository
+	UnitOfWork
+}
+
 // Repository is the concrete implementation of UserRepository using PostgreSQL
 type Repository struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
+	// replicas serve reads of lists, search and reports, see UseReplicas
+	replicas *replicaSet
 }
 
-// Ensure Repository implements UserRepository
-var _ UserRepository = (*Repository)(nil)
+// Ensure Repository implements Store
+var _ Store = (*Repository)(nil)
 
 func NewRepository(db *sql.DB) *Repository {
 	return &Repository{
@@ -34,13 +369,12 @@ func NewRepository(db *sql.DB) *Repository {
 	}
 }
 
-// DBConnection is an interface representing the database connection
-// This allows us to easily mock the database in tests
+// DBConnection is what repository methods run their queries on, satisfied by
+// both *sql.DB and *sql.Tx. See conn for which one a call gets.
 type DBConnection interface {
-	QueryRowContext(ctx context.Context, query string, args ...any) RowScanner
-	QueryContext(ctx context.Context, query string, args ...any) (Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
 	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
-	Close() error
 }
 
 // RowScanner is the interface that wraps the Scan method
@@ -56,30 +390,170 @@ type Rows interface {
 	Scan(dest ...interface{}) error
 }
 
-func (r *Repository) GetUsers(ctx context.Context) ([]*domain.User, error) {
-	query := `SELECT id, name, email, created_at, updated_at FROM users ORDER BY id DESC LIMIT 100`
+func (r *Repository) GetUsers(ctx context.Context, opts domain.ListOptions) ([]*domain.User, error) {
+	query, args, err := usersQuery(r.dialect, opts)
+	if err != nil {
+		return nil, err
+	}
+	return queryUsers(ctx, r.reader(ctx), query, args...)
+}
+
+// usersQuery builds the query listing the users matching opts
+func usersQuery(d dialect, opts domain.ListOptions) (string, []any, error) {
+	query := `SELECT ` + userColumns + ` FROM users`
 
-	rows, err := r.db.QueryContext(ctx, query)
+	var args []any
+	where := []string{"deleted_at IS NULL"}
+	// sort the keys so the generated SQL is stable
+	keys := make([]string, 0, len(opts.CustomFilters))
+	for key := range opts.CustomFilters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := json.Marshal(opts.CustomFilters[key])
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to encode custom field filter: %w", err)
+		}
+		args = append(args, key, string(value))
+		where = append(where, d.jsonContains(fmt.Sprintf("$%d", len(args)-1), fmt.Sprintf("$%d", len(args))))
+	}
+	query += " WHERE " + strings.Join(where, " AND ")
+
+	orderBy, orderArgs := userOrderBy(d, opts.Sort, len(args))
+	args = append(args, orderArgs...)
+	query += " ORDER BY " + orderBy + " LIMIT 100"
+	return query, args, nil
+}
+
+// GetUserBatch lists up to limit users with an ID above afterID, lowest ID first
+func (r *Repository) GetUserBatch(ctx context.Context, afterID, limit int) ([]*domain.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id > $1 AND deleted_at IS NULL ORDER BY id LIMIT $2`
+	return queryUsers(ctx, r.conn(ctx), query, afterID, limit)
+}
+
+// GetUsersByID lists the live users among ids, lowest ID first
+func (r *Repository) GetUsersByID(ctx context.Context, ids []int) ([]*domain.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ANY($1) AND deleted_at IS NULL ORDER BY id`
+	return queryUsers(ctx, r.conn(ctx), query, ids)
+}
+
+// userColumns lists the columns scanUser expects, with the user's tag names
+// selected as a JSON array
+const userColumns = `id, name, email, custom_fields, (
+		SELECT COALESCE(json_agg(t.name ORDER BY t.name), '[]')
+		FROM entity_tags et JOIN tags t ON t.id = et.tag_id
+		WHERE et.entity = 'user' AND et.entity_id = users.id
+	), score, score_breakdown, owner_id, out_of_office, created_at, updated_at, version`
+
+// scanUser scans a row selected with userColumns
+func scanUser(row RowScanner) (*domain.User, error) {
+	var user domain.User
+	var customFields, tags, breakdown []byte
+	var ownerID sql.NullInt64
+	if err := row.Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&customFields,
+		&tags,
+		&user.Score,
+		&breakdown,
+		&ownerID,
+		&user.OutOfOffice,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.Version,
+	); err != nil {
+		return nil, err
+	}
+	if ownerID.Valid {
+		owner := int(ownerID.Int64)
+		user.OwnerID = &owner
+	}
+	if err := decodeUserJSON(customFields, tags, breakdown, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// queryUsers runs a query selecting userColumns from users and scans the rows
+func queryUsers(ctx context.Context, db DBConnection, query string, args ...any) ([]*domain.User, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get users: %w", err)
 	}
 	defer rows.Close()
 	var users []*domain.User
 	for rows.Next() {
-		var user domain.User
-		if err := rows.Scan(
-			&user.ID,
-			&user.Name,
-			&user.Email,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		); err != nil {
+		user, err := scanUser(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan user row: %w", err)
 		}
-		users = append(users, &user)
+		users = append(users, user)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to iterate over user rows: %w", err)
 	}
 	return users, nil
 }
+
+// userSortColumns whitelists the user columns that can be sorted on
+var userSortColumns = map[string]bool{
+	"id":         true,
+	"name":       true,
+	"email":      true,
+	"score":      true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+// userOrderBy builds the ORDER BY clause for a sort option, defaulting to newest first.
+// Custom field keys are passed as a parameter so they never end up in the SQL text.
+func userOrderBy(d dialect, sortOpt string, argCount int) (string, []any) {
+	direction := "ASC"
+	if strings.HasPrefix(sortOpt, "-") {
+		direction = "DESC"
+		sortOpt = strings.TrimPrefix(sortOpt, "-")
+	}
+
+	if key, ok := strings.CutPrefix(sortOpt, "cf."); ok && key != "" {
+		return fmt.Sprintf("%s %s NULLS LAST, id DESC", d.customField(fmt.Sprintf("$%d", argCount+1)), direction), []any{key}
+	}
+	if userSortColumns[sortOpt] {
+		return fmt.Sprintf("%s %s, id DESC", sortOpt, direction), nil
+	}
+	return "id DESC", nil
+}
+
+// decodeUserJSON unpacks the JSON custom_fields, tags and score_breakdown columns onto the user
+func decodeUserJSON(customFields, tags, breakdown []byte, user *domain.User) error {
+	if len(customFields) > 0 {
+		var fields map[string]any
+		if err := json.Unmarshal(customFields, &fields); err != nil {
+			return fmt.Errorf("failed to decode custom fields: %w", err)
+		}
+		if len(fields) > 0 {
+			user.CustomFields = fields
+		}
+	}
+	if len(tags) > 0 {
+		var names []string
+		if err := json.Unmarshal(tags, &names); err != nil {
+			return fmt.Errorf("failed to decode tags: %w", err)
+		}
+		if len(names) > 0 {
+			user.Tags = names
+		}
+	}
+	if len(breakdown) > 0 {
+		var contributions []domain.ScoreContribution
+		if err := json.Unmarshal(breakdown, &contributions); err != nil {
+			return fmt.Errorf("failed to decode score breakdown: %w", err)
+		}
+		if len(contributions) > 0 {
+			user.ScoreBreakdown = contributions
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// CreateScoringRule stores a new scoring rule
+func (r *Repository) CreateScoringRule(ctx context.Context, rule domain.ScoringRule) (int, error) {
+	query := `
+	INSERT INTO scoring_rules (name, entity, type, expression, points, half_life_days, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id
+	`
+
+	now := time.Now()
+	var id int
+	err := r.conn(ctx).QueryRowContext(ctx, query,
+		rule.Name,
+		rule.Entity,
+		rule.Type,
+		rule.Expression,
+		rule.Points,
+		rule.HalfLifeDays,
+		now,
+		now).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create a scoring rule: %w", err)
+	}
+	return id, nil
+}
+
+// scoringRuleColumns lists the columns scanScoringRule expects
+const scoringRuleColumns = `id, name, entity, type, expression, points, half_life_days, created_at, updated_at, version`
+
+// GetScoringRules lists the scoring rules for an entity
+func (r *Repository) GetScoringRules(ctx context.Context, entity string) ([]*domain.ScoringRule, error) {
+	query := `
+	SELECT ` + scoringRuleColumns + `
+	FROM scoring_rules
+	WHERE entity = $1 AND deleted_at IS NULL
+	ORDER BY id
+	`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, entity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scoring rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []*domain.ScoringRule
+	for rows.Next() {
+		rule, err := scanScoringRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan scoring rule row: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over scoring rule rows: %w", err)
+	}
+	return rules, nil
+}
+
+// GetScoringRule retrieves a live scoring rule by ID
+func (r *Repository) GetScoringRule(ctx context.Context, id int) (*domain.ScoringRule, error) {
+	query := `SELECT ` + scoringRuleColumns + ` FROM scoring_rules WHERE id = $1 AND deleted_at IS NULL`
+
+	rule, err := scanScoringRule(r.conn(ctx).QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("scoring rule not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get scoring rule: %w", err)
+	}
+	return rule, nil
+}
+
+// scanScoringRule reads a row selected with scoringRuleColumns
+func scanScoringRule(row RowScanner) (*domain.ScoringRule, error) {
+	var rule domain.ScoringRule
+	if err := row.Scan(
+		&rule.ID,
+		&rule.Name,
+		&rule.Entity,
+		&rule.Type,
+		&rule.Expression,
+		&rule.Points,
+		&rule.HalfLifeDays,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+		&rule.Version,
+	); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// DeleteScoringRule removes a scoring rule. Stored scores keep the old
+// contribution until the record is scored again.
+func (r *Repository) DeleteScoringRule(ctx context.Context, id int) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM scoring_rules WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete scoring rule: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete scoring rule: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("scoring rule not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// UpdateUserScore stores a user's score and breakdown. updated_at is left
+// alone because a rescore isn't a change made by anyone.
+func (r *Repository) UpdateUserScore(ctx context.Context, id int, score int, breakdown []domain.ScoreContribution) error {
+	if breakdown == nil {
+		breakdown = []domain.ScoreContribution{}
+	}
+	data, err := json.Marshal(breakdown)
+	if err != nil {
+		return fmt.Errorf("failed to encode score breakdown: %w", err)
+	}
+
+	result, err := r.conn(ctx).ExecContext(ctx,
+		`UPDATE users SET score = $1, score_breakdown = $2, scored_at = $3 WHERE id = $4`,
+		score, data, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update user score: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update user score: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("user not found: %w", ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// Search runs a weighted full-text search over users with a trigram fallback
+// so partial names and emails still match while the user is typing
+func (r *Repository) Search(ctx context.Context, query string, limit int) ([]*domain.SearchResult, error) {
+	tsQuery := prefixTSQuery(query)
+
+	sqlQuery := `
+	SELECT 'user', id, name,
+		ts_headline('simple', name || ' ' || email, to_tsquery('simple', $1),
+			'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'),
+		GREATEST(
+			CASE WHEN $1 = '' THEN 0 ELSE ts_rank(search_vector, to_tsquery('simple', $1)) END,
+			similarity(name, $2),
+			similarity(email, $2)
+		) AS rank
+	FROM users
+	WHERE deleted_at IS NULL AND (($1 <> '' AND search_vector @@ to_tsquery('simple', $1))
+		OR name % $2
+		OR email % $2
+		OR email ILIKE '%' || $2 || '%')
+	ORDER BY rank DESC, id DESC
+	LIMIT $3
+	`
+
+	rows, err := r.reader(ctx).QueryContext(ctx, sqlQuery, tsQuery, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	return scanSearchResults(rows)
+}
+
+// scanSearchResults scans rows of type, ID, title, snippet and rank
+func scanSearchResults(rows *sql.Rows) ([]*domain.SearchResult, error) {
+	defer rows.Close()
+
+	var results []*domain.SearchResult
+	for rows.Next() {
+		var result domain.SearchResult
+		if err := rows.Scan(
+			&result.Type,
+			&result.ID,
+			&result.Title,
+			&result.Snippet,
+			&result.Rank,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan search row: %w", err)
+		}
+		results = append(results, &result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over search rows: %w", err)
+	}
+	return results, nil
+}
+
+// prefixTSQuery turns free text into a tsquery that prefix matches every term,
+// e.g. "jo smi" becomes "jo:* & smi:*". Anything that isn't a letter or digit is
+// treated as a separator so user input can never break the tsquery syntax.
+func prefixTSQuery(query string) string {
+	terms := searchTerms(query)
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+// searchTerms splits free text into lower case terms of letters and digits
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/segment"
+)
+
+// CreateSegment saves a segment definition
+func (r *Repository) CreateSegment(ctx context.Context, seg domain.Segment) (int, error) {
+	query := `
+	INSERT INTO segments (name, entity, expression, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id
+	`
+
+	now := time.Now()
+	var id int
+	err := r.conn(ctx).QueryRowContext(ctx, query,
+		seg.Name,
+		seg.Entity,
+		seg.Expression,
+		now,
+		now).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create a segment: %w", err)
+	}
+	return id, nil
+}
+
+// GetSegment retrieves a segment by ID
+func (r *Repository) GetSegment(ctx context.Context, id int) (*domain.Segment, error) {
+	query := `SELECT id, name, entity, expression, created_at, updated_at, version FROM segments WHERE id = $1 AND deleted_at IS NULL`
+
+	var seg domain.Segment
+	err := r.conn(ctx).QueryRowContext(ctx, query, id).Scan(
+		&seg.ID,
+		&seg.Name,
+		&seg.Entity,
+		&seg.Expression,
+		&seg.CreatedAt,
+		&seg.UpdatedAt,
+		&seg.Version,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("segment not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get segment: %w", err)
+	}
+	return &seg, nil
+}
+
+// GetSegments lists all saved segments
+func (r *Repository) GetSegments(ctx context.Context) ([]*domain.Segment, error) {
+	query := `SELECT id, name, entity, expression, created_at, updated_at, version FROM segments WHERE deleted_at IS NULL ORDER BY name, id`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get segments: %w", err)
+	}
+	defer rows.Close()
+
+	var segments []*domain.Segment
+	for rows.Next() {
+		var seg domain.Segment
+		if err := rows.Scan(
+			&seg.ID,
+			&seg.Name,
+			&seg.Entity,
+			&seg.Expression,
+			&seg.CreatedAt,
+			&seg.UpdatedAt,
+			&seg.Version,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan segment row: %w", err)
+		}
+		segments = append(segments, &seg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over segment rows: %w", err)
+	}
+	return segments, nil
+}
+
+// DeleteSegment removes a saved segment
+func (r *Repository) DeleteSegment(ctx context.Context, id int) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM segments WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete segment: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete segment: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("segment not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// GetSegmentUsers lists the users matching the expression, newest first
+func (r *Repository) GetSegmentUsers(ctx context.Context, expr segment.Expr) ([]*domain.User, error) {
+	where, args := userSegmentSQL(r.dialect, expr, nil)
+	query := `SELECT ` + userColumns + ` FROM users WHERE deleted_at IS NULL AND ` + where + ` ORDER BY id DESC LIMIT 100`
+
+	return queryUsers(ctx, r.reader(ctx), query, args...)
+}
+
+// CountSegmentUsers counts the users matching the expression
+func (r *Repository) CountSegmentUsers(ctx context.Context, expr segment.Expr) (int, error) {
+	where, args := userSegmentSQL(r.dialect, expr, nil)
+
+	var count int
+	if err := r.reader(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE deleted_at IS NULL AND `+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count segment users: %w", err)
+	}
+	return count, nil
+}
+
+// userSegmentSQL compiles a segment expression into a WHERE clause over users.
+// Every value is passed as a parameter so expressions can't inject SQL.
+func userSegmentSQL(d dialect, expr segment.Expr, args []any) (string, []any) {
+	param := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	switch e := expr.(type) {
+	case segment.And:
+		left, leftArgs := userSegmentSQL(d, e.Left, args)
+		right, rightArgs := userSegmentSQL(d, e.Right, leftArgs)
+		return "(" + left + " AND " + right + ")", rightArgs
+	case segment.Or:
+		left, leftArgs := userSegmentSQL(d, e.Left, args)
+		right, rightArgs := userSegmentSQL(d, e.Right, leftArgs)
+		return "(" + left + " OR " + right + ")", rightArgs
+	case segment.Not:
+		inner, innerArgs := userSegmentSQL(d, e.Expr, args)
+		return "NOT " + inner, innerArgs
+	case segment.Term:
+		// build the clause before returning args, param appends to it
+		var clause string
+		switch e.Field {
+		case segment.FieldTag:
+			clause = `EXISTS (
+				SELECT 1 FROM entity_tags et JOIN tags t ON t.id = et.tag_id
+				WHERE et.entity = 'user' AND et.entity_id = users.id AND t.name = ` + param(e.Value) + `
+			)`
+		case segment.FieldName:
+			clause = "name ILIKE " + param("%"+escapeLike(e.Value)+"%")
+		case segment.FieldEmail:
+			clause = "email ILIKE " + param("%"+escapeLike(e.Value)+"%")
+		case segment.FieldCreatedAfter:
+			clause = "created_at > " + param(e.Time)
+		case segment.FieldCreatedBefore:
+			clause = "created_at < " + param(e.Time)
+		default:
+			if key, ok := strings.CutPrefix(e.Field, segment.CustomFieldPrefix); ok {
+				k, v := param(key), param(e.Value)
+				clause = d.textEquals(k, v)
+			}
+		}
+		if clause != "" {
+			return clause, args
+		}
+	}
+	// unknown nodes never match rather than matching everything
+	return "FALSE", args
+}
+
+// escapeLike escapes the LIKE wildcards in a user supplied value
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,289 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// sequenceColumns lists the columns scanSequence expects
+const sequenceColumns = `id, name, entity, steps, created_at, updated_at, version`
+
+// enrollmentColumns lists the columns scanEnrollments expects
+const enrollmentColumns = `id, sequence_id, entity, entity_id, status, step, attempts, next_run_at,
+	error, created_at, updated_at`
+
+// CreateSequence stores a new sequence
+func (r *Repository) CreateSequence(ctx context.Context, sequence domain.Sequence) (int, error) {
+	steps, err := json.Marshal(sequence.Steps)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode sequence steps: %w", err)
+	}
+
+	query := `
+	INSERT INTO sequences (name, entity, steps, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id
+	`
+
+	now := time.Now()
+	var id int
+	err = r.conn(ctx).QueryRowContext(ctx, query,
+		sequence.Name,
+		sequence.Entity,
+		steps,
+		now,
+		now).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create a sequence: %w", err)
+	}
+	return id, nil
+}
+
+// GetSequences lists every sequence
+func (r *Repository) GetSequences(ctx context.Context) ([]*domain.Sequence, error) {
+	rows, err := r.conn(ctx).QueryContext(ctx, `SELECT `+sequenceColumns+` FROM sequences WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sequences: %w", err)
+	}
+	defer rows.Close()
+
+	var sequences []*domain.Sequence
+	for rows.Next() {
+		sequence, err := scanSequence(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sequence row: %w", err)
+		}
+		sequences = append(sequences, sequence)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over sequence rows: %w", err)
+	}
+	return sequences, nil
+}
+
+// GetSequence retrieves a sequence by ID
+func (r *Repository) GetSequence(ctx context.Context, id int) (*domain.Sequence, error) {
+	sequence, err := scanSequence(r.conn(ctx).QueryRowContext(ctx, `SELECT `+sequenceColumns+` FROM sequences WHERE id = $1 AND deleted_at IS NULL`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("sequence not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get sequence: %w", err)
+	}
+	return sequence, nil
+}
+
+// DeleteSequence removes a sequence along with its enrollments
+func (r *Repository) DeleteSequence(ctx context.Context, id int) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM sequences WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete sequence: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete sequence: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("sequence not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// GetSequenceStats counts the enrollments of every sequence by status
+func (r *Repository) GetSequenceStats(ctx context.Context) (map[int]*domain.SequenceStats, error) {
+	rows, err := r.reader(ctx).QueryContext(ctx, `
+	SELECT sequence_id, status, COUNT(*) FROM sequence_enrollments GROUP BY sequence_id, status
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sequence stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := map[int]*domain.SequenceStats{}
+	for rows.Next() {
+		var sequenceID, count int
+		var status string
+		if err := rows.Scan(&sequenceID, &status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan sequence stats row: %w", err)
+		}
+		if stats[sequenceID] == nil {
+			stats[sequenceID] = &domain.SequenceStats{}
+		}
+		addEnrollmentCount(stats[sequenceID], status, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over sequence stats rows: %w", err)
+	}
+	return stats, nil
+}
+
+// addEnrollmentCount adds enrollments with a status to the stats
+func addEnrollmentCount(stats *domain.SequenceStats, status string, count int) {
+	stats.Enrolled += count
+	switch status {
+	case domain.EnrollmentActive:
+		stats.Active += count
+	case domain.EnrollmentCompleted:
+		stats.Completed += count
+	case domain.EnrollmentReplied:
+		stats.Replied += count
+	case domain.EnrollmentBounced:
+		stats.Bounced += count
+	case domain.EnrollmentUnsubscribed:
+		stats.Unsubscribed += count
+	case domain.EnrollmentRemoved:
+		stats.Removed += count
+	case domain.EnrollmentFailed:
+		stats.Failed += count
+	}
+}
+
+// EnrollInSequence enrolls the existing records among ids, skipping those
+// already active in the sequence, and returns how many were enrolled
+func (r *Repository) EnrollInSequence(ctx context.Context, sequenceID int, entity string, ids []int, startAt time.Time) (int, error) {
+	table, ok := entityTables[entity]
+	if !ok {
+		return 0, fmt.Errorf("failed to enroll in sequence: unknown entity %q", entity)
+	}
+
+	// table comes from entityTables, never from user input
+	query := fmt.Sprintf(`
+	INSERT INTO sequence_enrollments (sequence_id, entity, entity_id, status, next_run_at, created_at, updated_at)
+	SELECT $1, $2, e.id, $3, $4, $5, $5 FROM %s e WHERE e.id = ANY($6)
+	ON CONFLICT (sequence_id, entity, entity_id) WHERE status = 'active' DO NOTHING
+	`, table)
+
+	result, err := r.conn(ctx).ExecContext(ctx, query, sequenceID, entity, domain.EnrollmentActive, startAt, time.Now(), ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to enroll in sequence: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to enroll in sequence: %w", err)
+	}
+	return int(affected), nil
+}
+
+// GetSequenceEnrollments lists the latest 500 enrollments of a sequence
+func (r *Repository) GetSequenceEnrollments(ctx context.Context, sequenceID int) ([]*domain.SequenceEnrollment, error) {
+	query := `SELECT ` + enrollmentColumns + ` FROM sequence_enrollments WHERE sequence_id = $1 ORDER BY id DESC LIMIT 500`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, sequenceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sequence enrollments: %w", err)
+	}
+	return scanEnrollments(rows)
+}
+
+// StopEnrollments ends the active enrollments of records with a status, in
+// one sequence or in every sequence when sequenceID is 0, and returns how
+// many were stopped
+func (r *Repository) StopEnrollments(ctx context.Context, sequenceID int, entity string, ids []int, status string) (int, error) {
+	result, err := r.conn(ctx).ExecContext(ctx, `
+	UPDATE sequence_enrollments SET status = $1, updated_at = $2
+	WHERE status = $3 AND entity = $4 AND entity_id = ANY($5) AND ($6 = 0 OR sequence_id = $6)
+	`, status, time.Now(), domain.EnrollmentActive, entity, ids, sequenceID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stop enrollments: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stop enrollments: %w", err)
+	}
+	return int(affected), nil
+}
+
+// ClaimSequenceEnrollments picks active enrollments that are due, with SKIP
+// LOCKED so concurrent workers never claim the same one. Claimed enrollments
+// are leased until the given time, after which a crashed step is retried.
+// Enrollments in a trashed sequence wait until it is restored or purged.
+func (r *Repository) ClaimSequenceEnrollments(ctx context.Context, now, leaseUntil time.Time, limit int) ([]*domain.SequenceEnrollment, error) {
+	query := `
+	UPDATE sequence_enrollments SET attempts = attempts + 1, next_run_at = $1, updated_at = $2
+	WHERE id IN (
+		SELECT id FROM sequence_enrollments
+		WHERE status = $3 AND next_run_at <= $2
+			AND sequence_id IN (SELECT id FROM sequences WHERE deleted_at IS NULL)
+		ORDER BY next_run_at, id
+		LIMIT $4
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ` + enrollmentColumns
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, leaseUntil, now, domain.EnrollmentActive, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim sequence enrollments: %w", err)
+	}
+	return scanEnrollments(rows)
+}
+
+// FinishSequenceStep stores an enrollment's progress after a step. An
+// enrollment stopped while the step ran, by a reply for example, stays stopped.
+func (r *Repository) FinishSequenceStep(ctx context.Context, enrollment domain.SequenceEnrollment) error {
+	_, err := r.conn(ctx).ExecContext(ctx, `
+	UPDATE sequence_enrollments SET status = $1, step = $2, attempts = $3, next_run_at = $4, error = $5, updated_at = $6
+	WHERE id = $7 AND status = $8
+	`, enrollment.Status, enrollment.Step, enrollment.Attempts, enrollment.NextRunAt, enrollment.Error, time.Now(),
+		enrollment.ID, domain.EnrollmentActive)
+	if err != nil {
+		return fmt.Errorf("failed to finish sequence step: %w", err)
+	}
+	return nil
+}
+
+// scanSequence scans a row selected with sequenceColumns
+func scanSequence(row RowScanner) (*domain.Sequence, error) {
+	var sequence domain.Sequence
+	var steps []byte
+	if err := row.Scan(
+		&sequence.ID,
+		&sequence.Name,
+		&sequence.Entity,
+		&steps,
+		&sequence.CreatedAt,
+		&sequence.UpdatedAt,
+		&sequence.Version,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(steps, &sequence.Steps); err != nil {
+		return nil, fmt.Errorf("failed to decode sequence steps: %w", err)
+	}
+	return &sequence, nil
+}
+
+// scanEnrollments reads enrollment rows selected with enrollmentColumns
+func scanEnrollments(rows *sql.Rows) ([]*domain.SequenceEnrollment, error) {
+	defer rows.Close()
+
+	var enrollments []*domain.SequenceEnrollment
+	for rows.Next() {
+		var enrollment domain.SequenceEnrollment
+		if err := rows.Scan(
+			&enrollment.ID,
+			&enrollment.SequenceID,
+			&enrollment.Entity,
+			&enrollment.EntityID,
+			&enrollment.Status,
+			&enrollment.Step,
+			&enrollment.Attempts,
+			&enrollment.NextRunAt,
+			&enrollment.Error,
+			&enrollment.CreatedAt,
+			&enrollment.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan sequence enrollment row: %w", err)
+		}
+		enrollments = append(enrollments, &enrollment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over sequence enrollment rows: %w", err)
+	}
+	return enrollments, nil
+}
@@ -0,0 +1,486 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/config"
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	sqlitemigrations "github.com/dyrober/AgencyCRM/migrations/sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// NewSQLiteDB opens the SQLite database file at cfg.Path, creating it when
+// needed, and brings its schema up to date. Transactions take the write lock
+// when they begin, so concurrent writers wait for each other for up to five
+// seconds rather than failing halfway through.
+func NewSQLiteDB(cfg config.DBConfig) (*sql.DB, error) {
+	params := url.Values{
+		"_pragma": {"foreign_keys(1)", "journal_mode(WAL)", "busy_timeout(5000)"},
+		"_txlock": {"immediate"},
+	}
+	db, err := sql.Open("sqlite", "file:"+cfg.Path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("unable to open db: %w", err)
+	}
+	db.SetMaxOpenConns(cfg.MaxConns)
+	db.SetMaxIdleConns(cfg.MaxConns)
+	db.SetConnMaxLifetime(cfg.MaxConnLifetime)
+	db.SetConnMaxIdleTime(cfg.MaxConnIdleTime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := migrateSQLite(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrateSQLite applies the SQLite migrations that weren't applied yet, each
+// in a transaction of its own
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version VARCHAR(255) PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	names, err := fs.Glob(sqlitemigrations.FS, "*.sql")
+	if err != nil {
+		return fmt.Errorf("failed to list migrations: %w", err)
+	}
+	for _, name := range names {
+		if err := applySQLiteMigration(ctx, db, name); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// applySQLiteMigration applies the named migration unless it was applied before
+func applySQLiteMigration(ctx context.Context, db *sql.DB, name string) error {
+	script, err := sqlitemigrations.FS.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var applied bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, name).Scan(&applied); err != nil {
+		return err
+	}
+	if applied {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`,
+		name, time.Now().UTC().Format(sqliteTimeFormat)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SQLiteRepository is a Repository on a SQLite database. Queries written for
+// Postgres are rewritten on the way in, see sqliteConn. The statements that
+// can't be rewritten, those changing several tables at once and full-text
+// search, are replaced here.
+type SQLiteRepository struct {
+	*Repository
+}
+
+// Ensure SQLiteRepository implements Store
+var _ Store = (*SQLiteRepository)(nil)
+
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{
+		Repository: &Repository{
+			db:      db,
+			dialect: dialectSQLite,
+		},
+	}
+}
+
+// Search matches every term of the query against user names and emails.
+// There is no full-text index, so names starting with the query rank above
+// names containing it, which rank above emails containing it.
+func (s *SQLiteRepository) Search(ctx context.Context, query string, limit int) ([]*domain.SearchResult, error) {
+	terms := searchTerms(query)
+	args := []any{"%" + escapeLike(query) + "%", escapeLike(query) + "%", limit}
+	var matches []string
+	for _, term := range terms {
+		args = append(args, "%"+escapeLike(term)+"%")
+		matches = append(matches, fmt.Sprintf(`(name LIKE $%[1]d ESCAPE '\' OR email LIKE $%[1]d ESCAPE '\')`, len(args)))
+	}
+	where := `email LIKE $1 ESCAPE '\'`
+	if len(matches) > 0 {
+		where += " OR (" + strings.Join(matches, " AND ") + ")"
+	}
+
+	sqlQuery := `
+	SELECT 'user', id, name, name || ' ' || email,
+		CASE
+			WHEN name LIKE $2 ESCAPE '\' THEN 1.0
+			WHEN name LIKE $1 ESCAPE '\' THEN 0.8
+			WHEN email LIKE $1 ESCAPE '\' THEN 0.5
+			ELSE 0.3
+		END AS rank
+	FROM users
+	WHERE deleted_at IS NULL AND (` + where + `)
+	ORDER BY rank DESC, id DESC
+	LIMIT $3
+	`
+
+	rows, err := s.conn(ctx).QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	results, err := scanSearchResults(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		result.Snippet = markTerms(result.Snippet, terms)
+	}
+	return results, nil
+}
+
+// markTerms wraps where the lower case terms occur in text in <mark> tags,
+// like ts_headline does on Postgres
+func markTerms(text string, terms []string) string {
+	lower := strings.ToLower(text)
+	// lower casing changed the length of some letters, offsets would be off
+	if len(lower) != len(text) {
+		return text
+	}
+
+	marked := make([]bool, len(text)+1)
+	for _, term := range terms {
+		for from := 0; ; {
+			at := strings.Index(lower[from:], term)
+			if at < 0 {
+				break
+			}
+			for i := from + at; i < from+at+len(term); i++ {
+				marked[i] = true
+			}
+			from += at + len(term)
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString("<mark>")
+		}
+		b.WriteByte(text[i])
+		if marked[i] && !marked[i+1] {
+			b.WriteString("</mark>")
+		}
+	}
+	return b.String()
+}
+
+// AddTags registers any new tags and links them to the records that exist,
+// ignoring links that are already there
+func (s *SQLiteRepository) AddTags(ctx context.Context, entity string, ids []int, tags []string) (int, error) {
+	table, ok := entityTables[entity]
+	if !ok {
+		return 0, fmt.Errorf("failed to add tags: unknown entity %q", entity)
+	}
+
+	now := time.Now()
+	var added int
+	err := s.inTx(ctx, nil, func(tx DBConnection) error {
+		_, err := tx.ExecContext(ctx, `
+		INSERT INTO tags (name, created_at)
+		SELECT DISTINCT value, $2 FROM json_each($1) WHERE true
+		ON CONFLICT (name) DO NOTHING
+		`, tags, now)
+		if err != nil {
+			return fmt.Errorf("failed to register tags: %w", err)
+		}
+
+		// table comes from entityTables, never from user input
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+		INSERT INTO entity_tags (tag_id, entity, entity_id, created_at)
+		SELECT t.id, $1, e.id, $2
+		FROM tags t CROSS JOIN %s e
+		WHERE t.name = ANY($3) AND e.id = ANY($4)
+		ON CONFLICT DO NOTHING
+		RETURNING entity_id
+		`, table), entity, now, tags, ids)
+		if err != nil {
+			return fmt.Errorf("failed to add tags: %w", err)
+		}
+		tagged, err := scanIDs(rows)
+		if err != nil {
+			return fmt.Errorf("failed to add tags: %w", err)
+		}
+		added = len(tagged)
+
+		// records that gained a tag get a new version
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET version = version + 1 WHERE id = ANY($1)`, table), tagged); err != nil {
+			return fmt.Errorf("failed to add tags: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// RemoveTags unlinks the tags from the records, which get a new version. Tags
+// stay in the registry.
+func (s *SQLiteRepository) RemoveTags(ctx context.Context, entity string, ids []int, tags []string) (int, error) {
+	table, ok := entityTables[entity]
+	if !ok {
+		return 0, fmt.Errorf("failed to remove tags: unknown entity %q", entity)
+	}
+
+	var removed int
+	err := s.inTx(ctx, nil, func(tx DBConnection) error {
+		rows, err := tx.QueryContext(ctx, `
+		DELETE FROM entity_tags
+		WHERE entity = $1 AND entity_id = ANY($2) AND tag_id IN (SELECT id FROM tags WHERE name = ANY($3))
+		RETURNING entity_id
+		`, entity, ids, tags)
+		if err != nil {
+			return fmt.Errorf("failed to remove tags: %w", err)
+		}
+		untagged, err := scanIDs(rows)
+		if err != nil {
+			return fmt.Errorf("failed to remove tags: %w", err)
+		}
+		removed = len(untagged)
+
+		// table comes from entityTables, never from user input
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET version = version + 1 WHERE id = ANY($1)`, table), untagged); err != nil {
+			return fmt.Errorf("failed to remove tags: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// AssignOwner updates the owner and writes the audit rows in one transaction,
+// so an assignment is never logged without happening or the other way round
+func (s *SQLiteRepository) AssignOwner(ctx context.Context, entity string, ids []int, ownerID int, ruleID *int, reason string) (int, error) {
+	table, ok := entityTables[entity]
+	if !ok {
+		return 0, fmt.Errorf("failed to assign owner: unknown entity %q", entity)
+	}
+
+	now := time.Now()
+	var assigned int
+	err := s.inTx(ctx, nil, func(tx DBConnection) error {
+		// table comes from entityTables, never from user input. The log is
+		// written first, it needs the previous owners.
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO assignments (entity, entity_id, owner_id, previous_owner_id, rule_id, reason, created_at)
+		SELECT $3, id, $2, owner_id, $4, $5, $6 FROM %s
+		WHERE id = ANY($1) AND owner_id IS DISTINCT FROM $2
+		`, table), ids, ownerID, entity, ruleID, reason, now)
+		if err != nil {
+			return fmt.Errorf("failed to assign owner: %w", err)
+		}
+
+		result, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET owner_id = $2, updated_at = $3, version = version + 1
+		WHERE id = ANY($1) AND owner_id IS DISTINCT FROM $2
+		`, table), ids, ownerID, now)
+		if err != nil {
+			return fmt.Errorf("failed to assign owner: %w", err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to assign owner: %w", err)
+		}
+		assigned = int(affected)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return assigned, nil
+}
+
+// FanOutOutbox turns unprocessed outbox events into one pending delivery per
+// matching subscription and marks the events processed, in one transaction.
+// Writers take turns on SQLite, so no other dispatcher sees the batch.
+func (s *SQLiteRepository) FanOutOutbox(ctx context.Context, now time.Time, limit int) (int, error) {
+	var processed int
+	err := s.inTx(ctx, nil, func(tx DBConnection) error {
+		rows, err := tx.QueryContext(ctx, `SELECT id FROM outbox WHERE processed_at IS NULL ORDER BY id LIMIT $1`, limit)
+		if err != nil {
+			return fmt.Errorf("failed to fan out outbox events: %w", err)
+		}
+		batch, err := scanIDs(rows)
+		if err != nil {
+			return fmt.Errorf("failed to fan out outbox events: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (subscription_id, outbox_id, event, payload, status,
+			next_attempt_at, created_at, updated_at)
+		SELECT s.id, b.id, b.event, b.payload, $2, $3, $3, $3
+		FROM outbox b
+		JOIN webhook_subscriptions s
+			ON s.deleted_at IS NULL AND EXISTS (SELECT 1 FROM json_each(s.events) WHERE value IN (b.event, '*'))
+		WHERE b.id = ANY($1)
+		ORDER BY b.id, s.id
+		`, batch, domain.DeliveryPending, now)
+		if err != nil {
+			return fmt.Errorf("failed to fan out outbox events: %w", err)
+		}
+
+		result, err := tx.ExecContext(ctx, `UPDATE outbox SET processed_at = $2 WHERE id = ANY($1)`, batch, now)
+		if err != nil {
+			return fmt.Errorf("failed to fan out outbox events: %w", err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to fan out outbox events: %w", err)
+		}
+		processed = int(affected)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return processed, nil
+}
+
+// RecordEmailEvent logs an open or click and bumps the matching counter in one
+// transaction
+func (s *SQLiteRepository) RecordEmailEvent(ctx context.Context, event domain.EmailEvent) error {
+	now := time.Now()
+	return s.inTx(ctx, nil, func(tx DBConnection) error {
+		_, err := tx.ExecContext(ctx, `
+		INSERT INTO email_events (email_id, type, url, ip, user_agent, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		`, event.EmailID, event.Type, event.URL, event.IP, event.UserAgent, now)
+		if err != nil {
+			return fmt.Errorf("failed to record email event: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		UPDATE emails SET
+			open_count = open_count + CASE WHEN $2 = 'open' THEN 1 ELSE 0 END,
+			click_count = click_count + CASE WHEN $2 = 'click' THEN 1 ELSE 0 END,
+			opened_at = CASE WHEN $2 = 'open' THEN COALESCE(opened_at, $3) ELSE opened_at END
+		WHERE id = $1
+		`, event.EmailID, event.Type, now)
+		if err != nil {
+			return fmt.Errorf("failed to record email event: %w", err)
+		}
+		return nil
+	})
+}
+
+// sqliteChangePoll is how often ListenChanges looks for new changes, and
+// sqliteChangeRetention how long changes are kept for listeners to read
+const (
+	sqliteChangePoll      = 500 * time.Millisecond
+	sqliteChangeRetention = time.Minute
+)
+
+// ListenChanges polls the changes the triggers of the SQLite schema record,
+// SQLite has nothing to notify listeners with
+func (s *SQLiteRepository) ListenChanges(ctx context.Context, fn func(domain.ChangeEvent)) error {
+	var last int
+	if err := s.conn(ctx).QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM changes`).Scan(&last); err != nil {
+		return fmt.Errorf("failed to get the latest change: %w", err)
+	}
+
+	ticker := time.NewTicker(sqliteChangePoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		changes, err := s.changesAfter(ctx, last)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for _, change := range changes {
+			fn(change.ChangeEvent)
+			last = change.id
+		}
+
+		if _, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM changes WHERE created_at < $1`,
+			time.Now().Add(-sqliteChangeRetention)); err != nil && ctx.Err() == nil {
+			return fmt.Errorf("failed to prune changes: %w", err)
+		}
+	}
+}
+
+// sqliteChange is a change with its row ID
+type sqliteChange struct {
+	domain.ChangeEvent
+	id int
+}
+
+// changesAfter lists the changes recorded after the one with ID afterID
+func (s *SQLiteRepository) changesAfter(ctx context.Context, afterID int) ([]sqliteChange, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, `
+	SELECT id, entity, entity_id, op, created_at FROM changes WHERE id > $1 ORDER BY id
+	`, afterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []sqliteChange
+	for rows.Next() {
+		var change sqliteChange
+		if err := rows.Scan(&change.id, &change.Entity, &change.ID, &change.Op, &change.At); err != nil {
+			return nil, fmt.Errorf("failed to scan change: %w", err)
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over changes: %w", err)
+	}
+	return changes, nil
+}
+
+// scanIDs scans and closes rows of a single ID column
+func scanIDs(rows *sql.Rows) ([]int, error) {
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
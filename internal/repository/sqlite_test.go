@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteQuery(t *testing.T) {
+	tests := map[string]string{
+		`SELECT $1::text AS entity WHERE ($2::timestamp IS NULL OR at < $2)`: `SELECT $1 AS entity WHERE ($2 IS NULL OR at < $2)`,
+		`SELECT id FROM users WHERE id = ANY($1) FOR UPDATE SKIP LOCKED`:     `SELECT id FROM users WHERE id IN (SELECT value FROM json_each($1))`,
+		`WHERE name ILIKE $3`: `WHERE name LIKE $3 ESCAPE '\'`,
+		`SET payload = jsonb_build_object('id', $2::int, 'erased', true)`: `SET payload = json_object('id', $2, 'erased', true)`,
+		`SELECT COALESCE(json_agg(t.name ORDER BY t.name), '[]')`:         `SELECT COALESCE(json_group_array(t.name ORDER BY t.name), '[]')`,
+		// literals and quoted identifiers are left alone
+		`SELECT 'a::text, ILIKE' WHERE x ILIKE $1`: `SELECT 'a::text, ILIKE' WHERE x LIKE $1 ESCAPE '\'`,
+		`SELECT 'it''s ANY(' AS "for update"`:      `SELECT 'it''s ANY(' AS "for update"`,
+	}
+	for query, expected := range tests {
+		got, err := sqliteQuery(query)
+		if err != nil || got != expected {
+			t.Errorf("sqliteQuery(%q) = %q, %v, want %q", query, got, err, expected)
+		}
+	}
+
+	// Postgres the rewrites don't cover fails instead of reaching SQLite
+	unsupported := []string{
+		`WHERE name ILIKE '%' || $2 || '%'`,
+		`WHERE name ILIKE ANY($1)`,
+		`SELECT $1::bigint`,
+		`WHERE tags @> $1`,
+		`SELECT custom_fields->>'budget'`,
+		`SELECT DISTINCT ON (email) id`,
+		`WHERE created_at > now() - INTERVAL '1 day'`,
+		`SELECT unnest($1::text[])`,
+		`FOR UPDATE OF users`,
+	}
+	for _, query := range unsupported {
+		if got, err := sqliteQuery(query); err == nil {
+			t.Errorf("sqliteQuery(%q) = %q, want an error", query, got)
+		}
+	}
+	// and never runs, QueryRow returns the error through its Row
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open SQLite: %v", err)
+	}
+	defer db.Close()
+	conn := sqliteConn{c: db}
+	ctx := context.Background()
+	if _, err := conn.ExecContext(ctx, unsupported[0]); err == nil {
+		t.Error("Expected ExecContext to fail on an unsupported query")
+	}
+	if _, err := conn.QueryContext(ctx, unsupported[0]); err == nil {
+		t.Error("Expected QueryContext to fail on an unsupported query")
+	}
+	var n int
+	_, want := sqliteQuery(unsupported[0])
+	if err := conn.QueryRowContext(ctx, unsupported[0]).Scan(&n); err == nil || !strings.Contains(err.Error(), want.Error()) {
+		t.Errorf("Expected QueryRowContext to fail with %v, got %v", want, err)
+	}
+	if err := conn.QueryRowContext(ctx, `SELECT $1::int`, 7).Scan(&n); err != nil || n != 7 {
+		t.Errorf("Expected QueryRowContext to run a supported query, got %d %v", n, err)
+	}
+}
+
+func TestMarkTerms(t *testing.T) {
+	tests := []struct {
+		text     string
+		terms    []string
+		expected string
+	}{
+		{"Jane Smith jane@acme.com", []string{"jane"}, "<mark>Jane</mark> Smith <mark>jane</mark>@acme.com"},
+		{"Jane Smith", []string{"ja", "jan"}, "<mark>Jan</mark>e Smith"},
+		{"Jane Smith", []string{"smith", "h"}, "Jane <mark>Smith</mark>"},
+		{"Jane Smith", nil, "Jane Smith"},
+	}
+	for _, test := range tests {
+		if got := markTerms(test.text, test.terms); got != test.expected {
+			t.Errorf("markTerms(%q, %q) = %q, want %q", test.text, test.terms, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// entityTables maps the entity names used by polymorphic tables to the table
+// holding those records
+var entityTables = map[string]string{
+	domain.EntityUser: "users",
+}
+
+// GetTags lists the tag registry with the number of records using each tag
+func (r *Repository) GetTags(ctx context.Context) ([]*domain.Tag, error) {
+	query := `
+	SELECT t.id, t.name, COUNT(et.tag_id), t.created_at
+	FROM tags t
+	LEFT JOIN entity_tags et ON et.tag_id = t.id
+	GROUP BY t.id
+	ORDER BY t.name
+	`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []*domain.Tag
+	for rows.Next() {
+		var tag domain.Tag
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.UsageCount, &tag.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tag row: %w", err)
+		}
+		tags = append(tags, &tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over tag rows: %w", err)
+	}
+	return tags, nil
+}
+
+// CreateTag registers a tag, returning the existing ID when the name is taken
+func (r *Repository) CreateTag(ctx context.Context, name string) (int, error) {
+	query := `
+	INSERT INTO tags (name, created_at)
+	VALUES ($1, $2)
+	ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+	RETURNING id
+	`
+
+	var id int
+	if err := r.conn(ctx).QueryRowContext(ctx, query, name, time.Now()).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to create a tag: %w", err)
+	}
+	return id, nil
+}
+
+// AddTags registers any new tags and links them to the records that exist,
+// ignoring links that are already there
+func (r *Repository) AddTags(ctx context.Context, entity string, ids []int, tags []string) (int, error) {
+	table, ok := entityTables[entity]
+	if !ok {
+		return 0, fmt.Errorf("failed to add tags: unknown entity %q", entity)
+	}
+
+	now := time.Now()
+	_, err := r.conn(ctx).ExecContext(ctx, `
+	INSERT INTO tags (name, created_at)
+	SELECT DISTINCT unnest($1::text[]), $2::timestamp
+	ON CONFLICT (name) DO NOTHING
+	`, tags, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register tags: %w", err)
+	}
+
+	// table comes from entityTables, never from user input. Records that gained
+	// a tag get a new version.
+	query := fmt.Sprintf(`
+	WITH added AS (
+		INSERT INTO entity_tags (tag_id, entity, entity_id, created_at)
+		SELECT t.id, $1, e.id, $2
+		FROM tags t CROSS JOIN %[1]s e
+		WHERE t.name = ANY($3) AND e.id = ANY($4)
+		ON CONFLICT DO NOTHING
+		RETURNING entity_id
+	), bumped AS (
+		UPDATE %[1]s SET version = version + 1 WHERE id IN (SELECT entity_id FROM added)
+	)
+	SELECT COUNT(*) FROM added
+	`, table)
+
+	var added int
+	if err := r.conn(ctx).QueryRowContext(ctx, query, entity, now, tags, ids).Scan(&added); err != nil {
+		return 0, fmt.Errorf("failed to add tags: %w", err)
+	}
+	return added, nil
+}
+
+// RemoveTags unlinks the tags from the records, which get a new version. Tags
+// stay in the registry.
+func (r *Repository) RemoveTags(ctx context.Context, entity string, ids []int, tags []string) (int, error) {
+	table, ok := entityTables[entity]
+	if !ok {
+		return 0, fmt.Errorf("failed to remove tags: unknown entity %q", entity)
+	}
+
+	// table comes from entityTables, never from user input
+	query := fmt.Sprintf(`
+	WITH removed AS (
+		DELETE FROM entity_tags et
+		USING tags t
+		WHERE et.tag_id = t.id AND et.entity = $1 AND et.entity_id = ANY($2) AND t.name = ANY($3)
+		RETURNING et.entity_id
+	), bumped AS (
+		UPDATE %s SET version = version + 1 WHERE id IN (SELECT entity_id FROM removed)
+	)
+	SELECT COUNT(*) FROM removed
+	`, table)
+
+	var removed int
+	if err := r.conn(ctx).QueryRowContext(ctx, query, entity, ids, tags).Scan(&removed); err != nil {
+		return 0, fmt.Errorf("failed to remove tags: %w", err)
+	}
+	return removed, nil
+}
@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// trashTable is a table with deleted_at and deleted_by columns and the
+// column that names its rows in the trash
+type trashTable struct {
+	table string
+	name  string
+}
+
+// trashTables maps every entity that can be trashed to its table. The other
+// tables have no deleted_at: tags and entity_tags are links that are simply
+// removed, assignments, automation_runs, outbox, webhook_deliveries,
+// form_submissions, emails, email_events, email_attachments, activities and
+// sequence_enrollments log what happened to a record and go with it, and
+// consents, email_suppressions, privacy_requests, audit_events,
+// field_history, idempotency_keys and changes are append-only records that
+// must not be undone.
+var trashTables = map[string]trashTable{
+	domain.EntityUser:          {"users", "name"},
+	domain.AuditCustomField:    {"custom_field_definitions", "label"},
+	domain.AuditSegment:        {"segments", "name"},
+	domain.AuditScoringRule:    {"scoring_rules", "name"},
+	domain.AuditAssignmentRule: {"assignment_rules", "name"},
+	domain.AuditAutomation:     {"automations", "name"},
+	domain.AuditWebhook:        {"webhook_subscriptions", "url"},
+	domain.AuditForm:           {"forms", "name"},
+	domain.AuditEmailTemplate:  {"email_templates", "name"},
+	domain.AuditSequence:       {"sequences", "name"},
+}
+
+// lookupTrashTable returns the table of a trashable entity
+func lookupTrashTable(entity string) (trashTable, error) {
+	table, ok := trashTables[entity]
+	if !ok {
+		return trashTable{}, fmt.Errorf("%s records can't be trashed", entity)
+	}
+	return table, nil
+}
+
+// TrashRecord sets a live record's deleted_at and deleted_by
+func (r *Repository) TrashRecord(ctx context.Context, entity string, id, version int, deletedBy string) error {
+	return trashRow(ctx, r.conn(ctx), entity, id, version, deletedBy)
+}
+
+// trashRow trashes a record through q, which may be a transaction
+func trashRow(ctx context.Context, q execQuerier, entity string, id, version int, deletedBy string) error {
+	t, err := lookupTrashTable(entity)
+	if err != nil {
+		return err
+	}
+
+	result, err := q.ExecContext(ctx,
+		`UPDATE `+t.table+` SET deleted_at = $1, deleted_by = $2, version = version + 1
+		WHERE id = $3 AND deleted_at IS NULL AND ($4 = 0 OR version = $4)`,
+		time.Now(), deletedBy, id, version)
+	if err != nil {
+		return fmt.Errorf("failed to trash %s: %w", entity, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to trash %s: %w", entity, err)
+	}
+	if affected == 0 {
+		return notFoundOrConflict(ctx, q, t.table, entity, id)
+	}
+	return nil
+}
+
+// RestoreRecord clears a trashed record's deleted_at and deleted_by. It
+// returns ErrDuplicate when a live record took the record's unique key, such
+// as a user's email address, while it was in the trash.
+func (r *Repository) RestoreRecord(ctx context.Context, entity string, id int) error {
+	t, err := lookupTrashTable(entity)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.conn(ctx).ExecContext(ctx,
+		`UPDATE `+t.table+` SET deleted_at = NULL, deleted_by = '', version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL`, id)
+	if uniqueViolation(err) {
+		return fmt.Errorf("trashed %s %d: %w", entity, id, ErrDuplicate)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", entity, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", entity, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("trashed %s not found: %w", entity, ErrNotFound)
+	}
+	return nil
+}
+
+// GetTrashItem retrieves a trashed record
+func (r *Repository) GetTrashItem(ctx context.Context, entity string, id int) (*domain.TrashItem, error) {
+	t, err := lookupTrashTable(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	item := domain.TrashItem{Entity: entity}
+	err = r.conn(ctx).QueryRowContext(ctx, `
+	SELECT id, `+t.name+`, deleted_at, deleted_by FROM `+t.table+`
+	WHERE id = $1 AND deleted_at IS NOT NULL
+	`, id).Scan(&item.ID, &item.Name, &item.DeletedAt, &item.DeletedBy)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("trashed %s not found: %w", entity, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get trashed %s: %w", entity, err)
+	}
+	return &item, nil
+}
+
+// GetTrash lists trashed records across the tables of the asked entities,
+// newest first
+func (r *Repository) GetTrash(ctx context.Context, entity string, before *time.Time) ([]*domain.TrashItem, error) {
+	var entities []string
+	if entity != "" {
+		if _, err := lookupTrashTable(entity); err != nil {
+			return nil, err
+		}
+		entities = []string{entity}
+	} else {
+		for name := range trashTables {
+			entities = append(entities, name)
+		}
+		// sort the entities so the generated SQL is stable
+		sort.Strings(entities)
+	}
+
+	selects := make([]string, len(entities))
+	args := []any{nil}
+	if before != nil {
+		args[0] = *before
+	}
+	for i, name := range entities {
+		t := trashTables[name]
+		args = append(args, name)
+		selects[i] = fmt.Sprintf(`
+	SELECT $%d::text AS entity, id, %s::text AS name, deleted_at, deleted_by FROM %s
+	WHERE deleted_at IS NOT NULL AND ($1::timestamp IS NULL OR deleted_at < $1)`, len(args), t.name, t.table)
+	}
+	query := strings.Join(selects, "\n\tUNION ALL") + "\n\tORDER BY deleted_at DESC, entity, id\n\t"
+
+	rows, err := r.reader(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trash: %w", err)
+	}
+	defer rows.Close()
+
+	var items []*domain.TrashItem
+	for rows.Next() {
+		var item domain.TrashItem
+		if err := rows.Scan(&item.Entity, &item.ID, &item.Name, &item.DeletedAt, &item.DeletedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan trash row: %w", err)
+		}
+		items = append(items, &item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over trash rows: %w", err)
+	}
+	return items, nil
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// ErrDuplicate is returned when a write would give a live record a key, such
+// as an email address, that another live record already has
+var ErrDuplicate = errors.New("already exists")
+
+// maxTxAttempts is how many times InTx runs a transaction the database
+// aborted to keep it serializable before giving up
+const maxTxAttempts = 3
+
+// txRetryDelay is how long InTx waits before its first retry, doubled after
+// each one
+const txRetryDelay = 10 * time.Millisecond
+
+// unitOfWork is the transaction InTx puts in a context and the database it
+// belongs to, so a repository on another database doesn't pick it up. conn
+// is the connection the transaction runs on, for driver calls database/sql
+// has no method for.
+type unitOfWork struct {
+	db   *sql.DB
+	conn *sql.Conn
+	tx   *sql.Tx
+}
+
+type unitOfWorkKey struct{}
+
+// InTx runs fn in a serializable transaction carried by ctx, retrying it when
+// the database aborts it for a serialization failure or a deadlock
+func (r *Repository) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := r.unitOfWork(ctx); ok {
+		return r.inTx(ctx, nil, func(DBConnection) error { return fn(ctx) })
+	}
+
+	delay := txRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := r.runTx(ctx, fn)
+		if err == nil || attempt == maxTxAttempts || !retryableTxError(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// runTx makes one attempt at a unit of work
+func (r *Repository) runTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, unitOfWorkKey{}, unitOfWork{db: r.db, conn: conn, tx: tx})); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// unitOfWork returns the transaction of the unit of work ctx is running in,
+// when it is on this repository's database
+func (r *Repository) unitOfWork(ctx context.Context) (*sql.Tx, bool) {
+	uow, ok := ctx.Value(unitOfWorkKey{}).(unitOfWork)
+	if !ok || uow.db != r.db {
+		return nil, false
+	}
+	return uow.tx, true
+}
+
+// unitOfWorkConn returns the connection of the unit of work ctx is running
+// in, when it is on this repository's database
+func (r *Repository) unitOfWorkConn(ctx context.Context) (*sql.Conn, bool) {
+	uow, ok := ctx.Value(unitOfWorkKey{}).(unitOfWork)
+	if !ok || uow.db != r.db {
+		return nil, false
+	}
+	return uow.conn, true
+}
+
+// conn returns what a query made with ctx should run on: the transaction of
+// its unit of work, or the pool outside of one
+func (r *Repository) conn(ctx context.Context) DBConnection {
+	if tx, ok := r.unitOfWork(ctx); ok {
+		return r.dialect.conn(tx)
+	}
+	return r.dialect.conn(r.db)
+}
+
+// inTx runs fn in a transaction of its own, or in a savepoint when ctx is in a
+// unit of work, so a failing fn only undoes its own writes either way
+func (r *Repository) inTx(ctx context.Context, opts *sql.TxOptions, fn func(tx DBConnection) error) error {
+	tx, ok := r.unitOfWork(ctx)
+	if !ok {
+		tx, err := r.db.BeginTx(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		if err := fn(r.dialect.conn(tx)); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT unit_of_work`); err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	if err := fn(r.dialect.conn(tx)); err != nil {
+		if _, rollbackErr := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT unit_of_work`); rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to roll back savepoint: %w", rollbackErr))
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT unit_of_work`); err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return nil
+}
+
+// retryableTxError reports whether err is a serialization failure or a
+// deadlock, or SQLite giving up waiting for the write lock, after which the
+// whole transaction can simply run again
+func retryableTxError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code()&0xff == sqlite3.SQLITE_BUSY
+	}
+	return false
+}
+
+// uniqueViolation reports whether err is the database rejecting a write that
+// breaks a unique constraint or index
+func uniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrVersionConflict is returned when a versioned write expected another
+// version than the record has, because someone else changed it first.
+// Versioned writes bump the version; a version of 0 skips the check, for
+// callers that didn't read the record first.
+var ErrVersionConflict = errors.New("record was changed by someone else")
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// execQuerier is a rowQuerier that can also run statements
+type execQuerier interface {
+	rowQuerier
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// notFoundOrConflict explains why a versioned write matched no row: either the
+// live record is gone or it has another version
+func notFoundOrConflict(ctx context.Context, q rowQuerier, table, what string, id int) error {
+	var exists bool
+	err := q.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM `+table+` WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check %s version: %w", what, err)
+	}
+	if exists {
+		return fmt.Errorf("%s %d: %w", what, id, ErrVersionConflict)
+	}
+	return fmt.Errorf("%s not found: %w", what, ErrNotFound)
+}
+
+// versionMatches mirrors the ($n = 0 OR version = $n) check for the mock
+func versionMatches(current, expected int) bool {
+	return expected == 0 || current == expected
+}
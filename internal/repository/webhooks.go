@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// webhookDeliveryColumns lists the columns scanWebhookDeliveries expects
+const webhookDeliveryColumns = `id, subscription_id, outbox_id, event, payload, status, attempts,
+	response_code, error, next_attempt_at, delivered_at, created_at, updated_at`
+
+// insertOutboxEvent records an event inside the transaction making the change,
+// so the event exists if and only if the change was committed
+func insertOutboxEvent(ctx context.Context, tx DBConnection, event, entity string, entityID int, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", event, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO outbox (event, entity, entity_id, payload, created_at)
+	VALUES ($1, $2, $3, $4, $5)
+	`, event, entity, entityID, data, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record %s event: %w", event, err)
+	}
+	return nil
+}
+
+// CreateWebhookSubscription stores a new webhook subscription
+func (r *Repository) CreateWebhookSubscription(ctx context.Context, subscription domain.WebhookSubscription) (int, error) {
+	events, err := json.Marshal(subscription.Events)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode webhook events: %w", err)
+	}
+
+	query := `
+	INSERT INTO webhook_subscriptions (url, secret, events, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id
+	`
+
+	now := time.Now()
+	var id int
+	err = r.conn(ctx).QueryRowContext(ctx, query,
+		subscription.URL,
+		subscription.Secret,
+		events,
+		now,
+		now).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create a webhook subscription: %w", err)
+	}
+	return id, nil
+}
+
+// GetWebhookSubscriptions lists every webhook subscription
+func (r *Repository) GetWebhookSubscriptions(ctx context.Context) ([]*domain.WebhookSubscription, error) {
+	rows, err := r.conn(ctx).QueryContext(ctx, `SELECT id, url, secret, events, created_at, updated_at, version FROM webhook_subscriptions WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subscriptions []*domain.WebhookSubscription
+	for rows.Next() {
+		subscription, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook subscription row: %w", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over webhook subscription rows: %w", err)
+	}
+	return subscriptions, nil
+}
+
+// GetWebhookSubscription retrieves a webhook subscription by ID
+func (r *Repository) GetWebhookSubscription(ctx context.Context, id int) (*domain.WebhookSubscription, error) {
+	subscription, err := scanWebhookSubscription(r.conn(ctx).QueryRowContext(ctx,
+		`SELECT id, url, secret, events, created_at, updated_at, version FROM webhook_subscriptions WHERE id = $1 AND deleted_at IS NULL`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("webhook subscription not found: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", err)
+	}
+	return subscription, nil
+}
+
+// DeleteWebhookSubscription removes a subscription along with its deliveries
+func (r *Repository) DeleteWebhookSubscription(ctx context.Context, id int) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("webhook subscription not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// FanOutOutbox turns unprocessed outbox events into one pending delivery per
+// matching subscription and marks the events processed, all in one statement.
+// SKIP LOCKED lets several dispatchers run without handing out an event twice.
+func (r *Repository) FanOutOutbox(ctx context.Context, now time.Time, limit int) (int, error) {
+	query := `
+	WITH batch AS (
+		SELECT id, event, payload FROM outbox
+		WHERE processed_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	), queued AS (
+		INSERT INTO webhook_deliveries (subscription_id, outbox_id, event, payload, status,
+			next_attempt_at, created_at, updated_at)
+		SELECT s.id, b.id, b.event, b.payload, $2::text, $3::timestamp, $3::timestamp, $3::timestamp
+		FROM batch b
+		JOIN webhook_subscriptions s
+			ON s.deleted_at IS NULL AND (s.events @> jsonb_build_array(b.event) OR s.events @> '["*"]')
+	), processed AS (
+		UPDATE outbox SET processed_at = $3 WHERE id IN (SELECT id FROM batch)
+		RETURNING id
+	)
+	SELECT COUNT(*) FROM processed
+	`
+
+	var processed int
+	if err := r.conn(ctx).QueryRowContext(ctx, query, limit, domain.DeliveryPending, now).Scan(&processed); err != nil {
+		return 0, fmt.Errorf("failed to fan out outbox events: %w", err)
+	}
+	return processed, nil
+}
+
+// ClaimWebhookDeliveries picks due deliveries with SKIP LOCKED so concurrent
+// dispatchers never send the same delivery. Deliveries to a trashed
+// subscription wait until it is restored or purged.
+func (r *Repository) ClaimWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]*domain.WebhookDelivery, error) {
+	query := `
+	UPDATE webhook_deliveries SET status = $1, attempts = attempts + 1, updated_at = $2
+	WHERE id IN (
+		SELECT id FROM webhook_deliveries
+		WHERE status = $3 AND next_attempt_at <= $2
+			AND subscription_id IN (SELECT id FROM webhook_subscriptions WHERE deleted_at IS NULL)
+		ORDER BY next_attempt_at, id
+		LIMIT $4
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ` + webhookDeliveryColumns
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, domain.DeliverySending, now, domain.DeliveryPending, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	return scanWebhookDeliveries(rows)
+}
+
+// FinishWebhookDelivery stores the outcome of a delivery attempt
+func (r *Repository) FinishWebhookDelivery(ctx context.Context, delivery domain.WebhookDelivery) error {
+	_, err := r.conn(ctx).ExecContext(ctx, `
+	UPDATE webhook_deliveries SET status = $1, response_code = $2, error = $3, next_attempt_at = $4,
+		delivered_at = $5, updated_at = $6
+	WHERE id = $7
+	`, delivery.Status, delivery.ResponseCode, delivery.Error, delivery.NextAttemptAt,
+		delivery.DeliveredAt, time.Now(), delivery.ID)
+	if err != nil {
+		return fmt.Errorf("failed to finish webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// GetWebhookDeliveries lists the latest 100 deliveries of a subscription
+func (r *Repository) GetWebhookDeliveries(ctx context.Context, subscriptionID int) ([]*domain.WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE subscription_id = $1 ORDER BY id DESC LIMIT 100`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook deliveries: %w", err)
+	}
+	return scanWebhookDeliveries(rows)
+}
+
+// RedeliverWebhook queues a delivery to be sent again right away with a fresh
+// set of attempts
+func (r *Repository) RedeliverWebhook(ctx context.Context, id int, now time.Time) error {
+	result, err := r.conn(ctx).ExecContext(ctx, `
+	UPDATE webhook_deliveries SET status = $1, attempts = 0, error = '', next_attempt_at = $2, updated_at = $2
+	WHERE id = $3
+	`, domain.DeliveryPending, now, id)
+	if err != nil {
+		return fmt.Errorf("failed to redeliver webhook: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to redeliver webhook: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("webhook delivery not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// scanWebhookSubscription scans a webhook subscription row
+func scanWebhookSubscription(row RowScanner) (*domain.WebhookSubscription, error) {
+	var subscription domain.WebhookSubscription
+	var events []byte
+	if err := row.Scan(
+		&subscription.ID,
+		&subscription.URL,
+		&subscription.Secret,
+		&events,
+		&subscription.CreatedAt,
+		&subscription.UpdatedAt,
+		&subscription.Version,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(events, &subscription.Events); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook events: %w", err)
+	}
+	return &subscription, nil
+}
+
+// scanWebhookDeliveries scans and closes rows selected with webhookDeliveryColumns
+func scanWebhookDeliveries(rows *sql.Rows) ([]*domain.WebhookDelivery, error) {
+	defer rows.Close()
+
+	var deliveries []*domain.WebhookDelivery
+	for rows.Next() {
+		var delivery domain.WebhookDelivery
+		var payload []byte
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(
+			&delivery.ID,
+			&delivery.SubscriptionID,
+			&delivery.OutboxID,
+			&delivery.Event,
+			&payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.ResponseCode,
+			&delivery.Error,
+			&delivery.NextAttemptAt,
+			&deliveredAt,
+			&delivery.CreatedAt,
+			&delivery.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery row: %w", err)
+		}
+		delivery.Payload = payload
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over webhook delivery rows: %w", err)
+	}
+	return deliveries, nil
+}
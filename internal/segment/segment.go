@@ -0,0 +1,338 @@
+// Package segment parses and evaluates saved segment filter expressions such as
+// `tag:webinar AND created_after:30d`.
+package segment
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Expr is a node in a parsed segment expression
+type Expr interface {
+	isExpr()
+}
+
+// And matches when both sides match
+type And struct {
+	Left, Right Expr
+}
+
+// Or matches when either side matches
+type Or struct {
+	Left, Right Expr
+}
+
+// Not matches when the inner expression does not
+type Not struct {
+	Expr Expr
+}
+
+// Term is a single field:value condition. Time is set for created_after and
+// created_before so relative values like 30d are fixed at parse time.
+type Term struct {
+	Field string
+	Value string
+	Time  time.Time
+}
+
+func (And) isExpr()  {}
+func (Or) isExpr()   {}
+func (Not) isExpr()  {}
+func (Term) isExpr() {}
+
+// Supported fields, custom fields are addressed as cf.<key>
+const (
+	FieldTag           = "tag"
+	FieldName          = "name"
+	FieldEmail         = "email"
+	FieldCreatedAfter  = "created_after"
+	FieldCreatedBefore = "created_before"
+	CustomFieldPrefix  = "cf."
+)
+
+// Record is the view of an entity that expressions are evaluated against in memory
+type Record struct {
+	Name         string
+	Email        string
+	Tags         []string
+	CustomFields map[string]any
+	CreatedAt    time.Time
+}
+
+// Parse turns an expression into an Expr tree. Relative dates are resolved against now.
+func Parse(input string, now time.Time) (Expr, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("expression is empty")
+	}
+
+	p := &parser{tokens: tokens, now: now}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+// Match reports whether the record satisfies the expression
+func Match(expr Expr, rec Record) bool {
+	switch e := expr.(type) {
+	case And:
+		return Match(e.Left, rec) && Match(e.Right, rec)
+	case Or:
+		return Match(e.Left, rec) || Match(e.Right, rec)
+	case Not:
+		return !Match(e.Expr, rec)
+	case Term:
+		return matchTerm(e, rec)
+	}
+	return false
+}
+
+func matchTerm(term Term, rec Record) bool {
+	switch term.Field {
+	case FieldTag:
+		for _, tag := range rec.Tags {
+			if tag == term.Value {
+				return true
+			}
+		}
+		return false
+	case FieldName:
+		return strings.Contains(strings.ToLower(rec.Name), strings.ToLower(term.Value))
+	case FieldEmail:
+		return strings.Contains(strings.ToLower(rec.Email), strings.ToLower(term.Value))
+	case FieldCreatedAfter:
+		return rec.CreatedAt.After(term.Time)
+	case FieldCreatedBefore:
+		return rec.CreatedAt.Before(term.Time)
+	}
+
+	key := strings.TrimPrefix(term.Field, CustomFieldPrefix)
+	switch value := rec.CustomFields[key].(type) {
+	case nil:
+		return false
+	case []any:
+		for _, item := range value {
+			if fmt.Sprint(item) == term.Value {
+				return true
+			}
+		}
+		return false
+	case []string:
+		for _, item := range value {
+			if item == term.Value {
+				return true
+			}
+		}
+		return false
+	default:
+		return fmt.Sprint(value) == term.Value
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// quoted words are never treated as keywords
+	quoted bool
+}
+
+// tokenize splits the input into words and parentheses. Double quotes group a
+// value containing spaces, e.g. tag:"vip client".
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")"})
+			i++
+		default:
+			var word strings.Builder
+			quoted := false
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' {
+				if runes[i] == '"' {
+					end := i + 1
+					for end < len(runes) && runes[end] != '"' {
+						end++
+					}
+					if end == len(runes) {
+						return nil, errors.New("unterminated quote")
+					}
+					word.WriteString(string(runes[i+1 : end]))
+					quoted = true
+					i = end + 1
+					continue
+				}
+				word.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: word.String(), quoted: quoted})
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	now    time.Time
+}
+
+func (p *parser) peekKeyword(keyword string) bool {
+	if p.pos >= len(p.tokens) {
+		return false
+	}
+	tok := p.tokens[p.pos]
+	return tok.kind == tokenWord && !tok.quoted && strings.EqualFold(tok.text, keyword)
+}
+
+// parseOr handles the lowest precedence operator
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseAnd treats adjacent terms without an operator as AND
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) && p.tokens[p.pos].kind != tokenClose && !p.peekKeyword("OR") {
+		if p.peekKeyword("AND") {
+			p.pos++
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end of expression")
+	}
+	if p.peekKeyword("NOT") {
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: inner}, nil
+	}
+
+	tok := p.tokens[p.pos]
+	switch tok.kind {
+	case tokenOpen:
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenClose {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return inner, nil
+	case tokenClose:
+		return nil, errors.New("unexpected )")
+	}
+
+	p.pos++
+	if !tok.quoted && (strings.EqualFold(tok.text, "AND") || strings.EqualFold(tok.text, "OR")) {
+		return nil, fmt.Errorf("unexpected %s", strings.ToUpper(tok.text))
+	}
+	return p.parseTerm(tok.text)
+}
+
+func (p *parser) parseTerm(text string) (Expr, error) {
+	field, value, ok := strings.Cut(text, ":")
+	if !ok || field == "" || value == "" {
+		return nil, fmt.Errorf("expected field:value, got %q", text)
+	}
+	field = strings.ToLower(field)
+
+	switch field {
+	case FieldTag:
+		return Term{Field: field, Value: strings.ToLower(value)}, nil
+	case FieldName, FieldEmail:
+		return Term{Field: field, Value: value}, nil
+	case FieldCreatedAfter, FieldCreatedBefore:
+		at, err := parseTime(value, p.now)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+		return Term{Field: field, Value: value, Time: at}, nil
+	}
+
+	if key, ok := strings.CutPrefix(field, CustomFieldPrefix); ok && key != "" {
+		return Term{Field: field, Value: value}, nil
+	}
+	return nil, fmt.Errorf("unknown field %q", field)
+}
+
+// parseTime accepts a date (2006-01-02), an RFC 3339 timestamp, or a relative
+// age such as 12h, 30d or 2w meaning that long before now
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+	if ts, err := time.Parse(time.RFC3339, value); err == nil {
+		return ts, nil
+	}
+
+	if len(value) >= 2 {
+		amount, err := strconv.Atoi(value[:len(value)-1])
+		if err == nil && amount >= 0 {
+			switch value[len(value)-1] {
+			case 'h':
+				return now.Add(-time.Duration(amount) * time.Hour), nil
+			case 'd':
+				return now.AddDate(0, 0, -amount), nil
+			case 'w':
+				return now.AddDate(0, 0, -7*amount), nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use a date or an age like 30d", value)
+}
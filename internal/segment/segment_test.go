@@ -0,0 +1,136 @@
+package segment
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		input       string
+		expected    Expr
+		expectedErr bool
+	}{
+		{
+			name:     "single term",
+			input:    "tag:Webinar",
+			expected: Term{Field: "tag", Value: "webinar"},
+		},
+		{
+			name:  "and with relative date",
+			input: "tag:webinar AND created_after:30d",
+			expected: And{
+				Left:  Term{Field: "tag", Value: "webinar"},
+				Right: Term{Field: "created_after", Value: "30d", Time: now.AddDate(0, 0, -30)},
+			},
+		},
+		{
+			name:  "implicit and",
+			input: "tag:a tag:b",
+			expected: And{
+				Left:  Term{Field: "tag", Value: "a"},
+				Right: Term{Field: "tag", Value: "b"},
+			},
+		},
+		{
+			name:  "and binds tighter than or",
+			input: "tag:a OR tag:b and tag:c",
+			expected: Or{
+				Left: Term{Field: "tag", Value: "a"},
+				Right: And{
+					Left:  Term{Field: "tag", Value: "b"},
+					Right: Term{Field: "tag", Value: "c"},
+				},
+			},
+		},
+		{
+			name:  "parentheses and not",
+			input: "(tag:a OR tag:b) AND NOT cf.tier:gold",
+			expected: And{
+				Left: Or{
+					Left:  Term{Field: "tag", Value: "a"},
+					Right: Term{Field: "tag", Value: "b"},
+				},
+				Right: Not{Expr: Term{Field: "cf.tier", Value: "gold"}},
+			},
+		},
+		{
+			name:     "quoted value",
+			input:    `tag:"vip client"`,
+			expected: Term{Field: "tag", Value: "vip client"},
+		},
+		{
+			name:     "absolute date",
+			input:    "created_before:2026-01-01",
+			expected: Term{Field: "created_before", Value: "2026-01-01", Time: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{name: "empty", input: "  ", expectedErr: true},
+		{name: "unknown field", input: "status:qualified", expectedErr: true},
+		{name: "missing value", input: "tag:", expectedErr: true},
+		{name: "dangling operator", input: "tag:a AND", expectedErr: true},
+		{name: "leading operator", input: "OR tag:a", expectedErr: true},
+		{name: "unbalanced parentheses", input: "(tag:a", expectedErr: true},
+		{name: "stray close", input: "tag:a)", expectedErr: true},
+		{name: "unterminated quote", input: `tag:"vip`, expectedErr: true},
+		{name: "bad age", input: "created_after:soon", expectedErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			expr, err := Parse(tc.input, now)
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatalf("Expected error for %q, got %#v", tc.input, expr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error for %q: %v", tc.input, err)
+			}
+			if expr != tc.expected {
+				t.Errorf("Parse(%q) = %#v, want %#v", tc.input, expr, tc.expected)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	rec := Record{
+		Name:         "Jane Smith",
+		Email:        "jane@acme.com",
+		Tags:         []string{"webinar", "vip"},
+		CustomFields: map[string]any{"tier": "gold", "channels": []any{"email", "phone"}, "budget": float64(5000)},
+		CreatedAt:    now.AddDate(0, 0, -10),
+	}
+
+	tests := map[string]bool{
+		"tag:webinar":                         true,
+		"tag:webinar AND tag:newsletter":      false,
+		"tag:webinar OR tag:newsletter":       true,
+		"NOT tag:newsletter":                  true,
+		"created_after:30d":                   true,
+		"created_after:7d":                    false,
+		"created_before:7d":                   true,
+		"name:smith email:acme":               true,
+		"email:globex":                        false,
+		"cf.tier:gold":                        true,
+		"cf.channels:phone":                   true,
+		"cf.budget:5000":                      true,
+		"cf.missing:x":                        false,
+		"(tag:vip OR tag:x) AND cf.tier:gold": true,
+	}
+
+	for input, expected := range tests {
+		expr, err := Parse(input, now)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", input, err)
+		}
+		if got := Match(expr, rec); got != expected {
+			t.Errorf("Match(%q) = %v, want %v", input, got, expected)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// lists the assignment rules for ?entity= (defaults to user) in the order they are tried
+func (s *Server) getAssignmentRules(w http.ResponseWriter, r *http.Request) {
+	entity := r.URL.Query().Get("entity")
+	if entity == "" {
+		entity = domain.EntityUser
+	}
+
+	rules, err := s.service.GetAssignmentRules(r.Context(), entity)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting assignment rules: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get assignment rules")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, rules)
+}
+
+// defines a new assignment rule
+func (s *Server) createAssignmentRule(w http.ResponseWriter, r *http.Request) {
+	var req domain.CreateAssignmentRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Entity == "" {
+		req.Entity = domain.EntityUser
+	}
+
+	id, err := s.service.CreateAssignmentRule(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating assignment rule: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create assignment rule")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]int{"id": id})
+}
+
+// grabs an assignment rule by ID
+func (s *Server) getAssignmentRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid assignment rule ID")
+		return
+	}
+
+	rule, err := s.service.GetAssignmentRule(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Assignment rule not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting assignment rule: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get assignment rule")
+		return
+	}
+
+	respondVersioned(w, r, rule.Version, rule)
+}
+
+// removes an assignment rule
+func (s *Server) deleteAssignmentRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid assignment rule ID")
+		return
+	}
+
+	err = s.service.DeleteAssignmentRule(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Assignment rule not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, http.StatusPreconditionFailed, "Assignment rule was changed by someone else")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting assignment rule: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete assignment rule")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lists the assignment history of ?entity=&entity_id=
+func (s *Server) getAssignments(w http.ResponseWriter, r *http.Request) {
+	entity := r.URL.Query().Get("entity")
+	if entity == "" {
+		entity = domain.EntityUser
+	}
+	entityID, err := strconv.Atoi(r.URL.Query().Get("entity_id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid entity_id")
+		return
+	}
+
+	assignments, err := s.service.GetAssignments(r.Context(), entity, entityID)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting assignments: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get assignments")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, assignments)
+}
+
+// moves many records to a new owner
+func (s *Server) reassign(w http.ResponseWriter, r *http.Request) {
+	var req domain.ReassignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Entity == "" {
+		req.Entity = domain.EntityUser
+	}
+
+	changed, err := s.service.Reassign(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reassigning: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to reassign")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]int{"reassigned": changed})
+}
+
+// marks a user as out of office or back
+func (s *Server) setOutOfOffice(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	var req domain.OutOfOfficeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	err = s.service.SetOutOfOffice(r.Context(), id, req.OutOfOffice)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, http.StatusPreconditionFailed, "User was changed by someone else")
+		return
+	}
+	if err != nil {
+		log.Printf("Error setting out of office: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to update availability")
+		return
+	}
+
+	s.setUserETag(r.Context(), w, id)
+	w.WriteHeader(http.StatusNoContent)
+}
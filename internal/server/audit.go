@@ -0,0 +1,206 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/service"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// maxActorLength caps the X-Actor header, longer names are cut
+const maxActorLength = 100
+
+// auditActor tags each request's context with who is making its changes, for
+// the audit log. With fromHeader the actor is named by the X-Actor header when
+// one is sent, until the API has authentication to take it from.
+func auditActor(name string, fromHeader bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actor := domain.Actor{
+				Name:      name,
+				RequestID: middleware.GetReqID(r.Context()),
+				IP:        clientIP(r),
+			}
+			if header := strings.TrimSpace(r.Header.Get("X-Actor")); fromHeader && header != "" {
+				if len(header) > maxActorLength {
+					header = header[:maxActorLength]
+				}
+				actor.Name = header
+			}
+			next.ServeHTTP(w, r.WithContext(service.WithActor(r.Context(), actor)))
+		})
+	}
+}
+
+// auditFilterFromQuery reads audit log filters from the query string
+func auditFilterFromQuery(r *http.Request) (domain.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := domain.AuditFilter{
+		Entity:    q.Get("entity"),
+		Actor:     q.Get("actor"),
+		Action:    q.Get("action"),
+		RequestID: q.Get("request_id"),
+	}
+
+	ints := []struct {
+		name string
+		dest *int
+	}{
+		{"entity_id", &filter.EntityID},
+		{"after_id", &filter.AfterID},
+		{"limit", &filter.Limit},
+	}
+	for _, param := range ints {
+		if value := q.Get(param.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be a number", param.name)
+			}
+			*param.dest = n
+		}
+	}
+
+	times := []struct {
+		name string
+		dest **time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	}
+	for _, param := range times {
+		if value := q.Get(param.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time", param.name)
+			}
+			*param.dest = &t
+		}
+	}
+	return filter, nil
+}
+
+// lists audit events matching the query filters, oldest first
+func (s *Server) getAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilterFromQuery(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	events, err := s.service.GetAuditEvents(r.Context(), filter)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting audit events: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get audit events")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, events)
+}
+
+// downloads every audit event matching the query filters, as ?format=ndjson
+// (the default) or csv
+func (s *Server) exportAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilterFromQuery(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		respondError(w, http.StatusBadRequest, `format must be "ndjson" or "csv"`)
+		return
+	}
+
+	cw := csv.NewWriter(w)
+	enc := json.NewEncoder(w)
+	// headers are only sent once the filters have been checked, after that
+	// errors can only be logged
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit.%s"`, format))
+		if format == "ndjson" {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			return nil
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		return cw.Write([]string{"id", "created_at", "actor", "entity", "entity_id", "action",
+			"changes", "redacted", "request_id", "ip", "prev_hash", "hash"})
+	}
+
+	err = s.service.EachAuditEvent(r.Context(), filter, func(event *domain.AuditEvent) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if format == "ndjson" {
+			return enc.Encode(event)
+		}
+		changes, err := json.Marshal(event.Changes)
+		if err != nil {
+			return err
+		}
+		return cw.Write([]string{
+			strconv.Itoa(event.ID),
+			event.CreatedAt.Format(time.RFC3339Nano),
+			event.Actor,
+			event.Entity,
+			strconv.Itoa(event.EntityID),
+			event.Action,
+			string(changes),
+			strconv.FormatBool(event.Redacted),
+			event.RequestID,
+			event.IP,
+			event.PrevHash,
+			event.Hash,
+		})
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if started {
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+		if err != nil {
+			log.Printf("Error exporting audit events: %v", err)
+		}
+		return
+	}
+
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	log.Printf("Error exporting audit events: %v", err)
+	respondError(w, http.StatusInternalServerError, "Failed to export audit events")
+}
+
+// checks the audit log's hash chain is unbroken
+func (s *Server) verifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	result, err := s.service.VerifyAuditLog(r.Context())
+	if err != nil {
+		log.Printf("Error verifying audit log: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to verify audit log")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, result)
+}
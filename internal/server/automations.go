@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// lists the automations for ?entity= (defaults to user)
+func (s *Server) getAutomations(w http.ResponseWriter, r *http.Request) {
+	entity := r.URL.Query().Get("entity")
+	if entity == "" {
+		entity = domain.EntityUser
+	}
+
+	automations, err := s.service.GetAutomations(r.Context(), entity)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting automations: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get automations")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, automations)
+}
+
+// defines a new automation
+func (s *Server) createAutomation(w http.ResponseWriter, r *http.Request) {
+	var req domain.CreateAutomationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Entity == "" {
+		req.Entity = domain.EntityUser
+	}
+
+	id, err := s.service.CreateAutomation(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating automation: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create automation")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]int{"id": id})
+}
+
+// grabs an automation by ID
+func (s *Server) getAutomation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid automation ID")
+		return
+	}
+
+	automation, err := s.service.GetAutomation(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Automation not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting automation: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get automation")
+		return
+	}
+
+	respondVersioned(w, r, automation.Version, automation)
+}
+
+// removes an automation
+func (s *Server) deleteAutomation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid automation ID")
+		return
+	}
+
+	err = s.service.DeleteAutomation(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Automation not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, http.StatusPreconditionFailed, "Automation was changed by someone else")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting automation: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete automation")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lists an automation's latest runs with their logs
+func (s *Server) getAutomationRuns(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid automation ID")
+		return
+	}
+
+	runs, err := s.service.GetAutomationRuns(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Automation not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting automation runs: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get automation runs")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, runs)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/dyrober/AgencyCRM/internal/service"
+)
+
+// applies a batch of user creates, updates and deletes, answering with the
+// outcome of each
+func (s *Server) bulkUsers(w http.ResponseWriter, r *http.Request) {
+	var req domain.BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	outcomes, err := s.service.Bulk(r.Context(), domain.EntityUser, req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error applying bulk operations: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to apply bulk operations")
+		return
+	}
+
+	response := domain.BulkResponse{Results: make([]domain.BulkResult, len(outcomes))}
+	for i, outcome := range outcomes {
+		result := bulkResult(req.Operations[i].Op, outcome)
+		result.Index = i
+		response.Results[i] = result
+		if outcome.Err == nil {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+	respondJSON(w, http.StatusMultiStatus, response)
+}
+
+// bulkResult gives an operation's outcome the status it would have had as a
+// request of its own
+func bulkResult(op string, outcome service.BulkOutcome) domain.BulkResult {
+	result := domain.BulkResult{Op: op, ID: outcome.ID}
+	err := outcome.Err
+	if msg, ok := validationMessage(err); ok {
+		result.Status, result.Error = http.StatusBadRequest, msg
+		return result
+	}
+
+	switch {
+	case err == nil && op == domain.BulkCreate:
+		result.Status = http.StatusCreated
+	case err == nil:
+		result.Status = http.StatusOK
+	case errors.Is(err, repository.ErrNotFound):
+		result.Status, result.Error = http.StatusNotFound, "User not found"
+	case errors.Is(err, repository.ErrVersionConflict):
+		result.Status, result.Error = http.StatusPreconditionFailed, "User was changed by someone else"
+	case errors.Is(err, service.ErrBulkRolledBack):
+		result.Status, result.Error = http.StatusFailedDependency, "Not applied because another operation failed"
+	default:
+		log.Printf("Error applying bulk %s: %v", op, err)
+		result.Status, result.Error = http.StatusInternalServerError, "Failed to apply the operation"
+	}
+	return result
+}
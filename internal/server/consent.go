@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// unsubscribePage asks for confirmation, so link scanners that follow the
+// link don't unsubscribe anyone. The form posts back to the same URL.
+const unsubscribePage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body><form method="post"><p>Stop receiving marketing email from us?</p>
+<button type="submit">Unsubscribe</button></form></body></html>`
+
+// unsubscribedPage confirms an unsubscribe
+const unsubscribedPage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Unsubscribed</title></head>
+<body><p>You have been unsubscribed.</p></body></html>`
+
+// lists a record's current consents and their history
+func (s *Server) getConsents(w http.ResponseWriter, r *http.Request) {
+	entity := r.URL.Query().Get("entity")
+	if entity == "" {
+		entity = domain.EntityUser
+	}
+	entityID, err := strconv.Atoi(r.URL.Query().Get("entity_id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid entity_id")
+		return
+	}
+
+	consents, err := s.service.GetConsents(r.Context(), entity, entityID)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting consents: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get consents")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, consents)
+}
+
+// records a consent change
+func (s *Server) recordConsent(w http.ResponseWriter, r *http.Request) {
+	var req domain.ConsentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Entity == "" {
+		req.Entity = domain.EntityUser
+	}
+
+	id, err := s.service.RecordConsent(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Record not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error recording consent: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to record consent")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]int{"id": id})
+}
+
+// lists the suppressed addresses
+func (s *Server) getSuppressions(w http.ResponseWriter, r *http.Request) {
+	suppressions, err := s.service.GetSuppressions(r.Context())
+	if err != nil {
+		log.Printf("Error getting suppressions: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get suppressions")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, suppressions)
+}
+
+// suppresses an address
+func (s *Server) createSuppression(w http.ResponseWriter, r *http.Request) {
+	var req domain.CreateSuppressionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	suppression, err := s.service.CreateSuppression(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating suppression: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create suppression")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, suppression)
+}
+
+// removes an address from the suppression list
+func (s *Server) deleteSuppression(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid suppression ID")
+		return
+	}
+
+	err = s.service.DeleteSuppression(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Suppression not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting suppression: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete suppression")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// public unsubscribe confirmation page
+func (s *Server) unsubscribePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(unsubscribePage))
+}
+
+// public unsubscribe, posted by the confirmation page or by mail clients
+// doing an RFC 8058 one-click unsubscribe
+func (s *Server) unsubscribe(w http.ResponseWriter, r *http.Request) {
+	err := s.service.Unsubscribe(r.Context(), chi.URLParam(r, "token"), clientIP(r), r.UserAgent())
+	if _, ok := validationMessage(err); ok {
+		http.Error(w, "Invalid unsubscribe link", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error unsubscribing: %v", err)
+		http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(unsubscribedPage))
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/dyrober/AgencyCRM/internal/service"
+	"github.com/go-chi/chi/v5"
+)
+
+// trackingPixel is a transparent 1x1 GIF
+var trackingPixel = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// lists the email templates
+func (s *Server) getEmailTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := s.service.GetEmailTemplates(r.Context())
+	if err != nil {
+		log.Printf("Error getting email templates: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get email templates")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, templates)
+}
+
+// saves a new email template
+func (s *Server) createEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	var req domain.CreateEmailTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	id, err := s.service.CreateEmailTemplate(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating email template: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create email template")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]int{"id": id})
+}
+
+// grabs an email template by ID
+func (s *Server) getEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid email template ID")
+		return
+	}
+
+	template, err := s.service.GetEmailTemplate(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Email template not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting email template: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get email template")
+		return
+	}
+
+	respondVersioned(w, r, template.Version, template)
+}
+
+// removes an email template
+func (s *Server) deleteEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid email template ID")
+		return
+	}
+
+	err = s.service.DeleteEmailTemplate(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Email template not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, http.StatusPreconditionFailed, "Email template was changed by someone else")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting email template: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete email template")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// emails a user from a template or inline content
+func (s *Server) sendUserEmail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	var req domain.SendEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	email, err := s.service.SendEmail(r.Context(), domain.EntityUser, id, req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error sending email: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to send email")
+		return
+	}
+
+	// a delivery failure is logged on the email, not reported as a request error
+	response := map[string]any{"id": email.ID, "status": email.Status, "message_id": email.MessageID}
+	if email.Error != "" {
+		response["error"] = email.Error
+	}
+	respondJSON(w, http.StatusCreated, response)
+}
+
+// lists the emails sent to a user
+func (s *Server) getUserEmails(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	emails, err := s.service.GetEmails(r.Context(), domain.EntityUser, id)
+	if err != nil {
+		log.Printf("Error getting emails: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get emails")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, emails)
+}
+
+// lists a record's timeline
+func (s *Server) getActivities(w http.ResponseWriter, r *http.Request) {
+	entity := r.URL.Query().Get("entity")
+	if entity == "" {
+		entity = domain.EntityUser
+	}
+	entityID, err := strconv.Atoi(r.URL.Query().Get("entity_id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid entity_id")
+		return
+	}
+
+	activities, err := s.service.GetActivities(r.Context(), entity, entityID)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting activities: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get activities")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, activities)
+}
+
+// public open tracking pixel, answers with the image even for unknown tokens
+func (s *Server) trackOpen(w http.ResponseWriter, r *http.Request) {
+	err := s.service.TrackOpen(r.Context(), chi.URLParam(r, "token"), clientIP(r), r.UserAgent())
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Printf("Error tracking email open: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-store, max-age=0")
+	w.WriteHeader(http.StatusOK)
+	w.Write(trackingPixel)
+}
+
+// public click tracking redirect to a link's original target
+func (s *Server) trackClick(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(chi.URLParam(r, "n"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	target, err := s.service.TrackClick(r.Context(), chi.URLParam(r, "token"), n, clientIP(r), r.UserAgent())
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error tracking email click: %v", err)
+		http.Error(w, "Failed to follow link", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store, max-age=0")
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// logs a raw RFC 5322 message, for mail services that forward email over HTTP
+func (s *Server) receiveEmail(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, service.MaxInboundMessage)
+
+	email, err := s.service.IngestEmail(r.Context(), r.Body)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error receiving email: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to receive email")
+		return
+	}
+
+	if email == nil {
+		respondJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"id": email.ID, "status": "logged"})
+}
+
+// lists the files received with an email
+func (s *Server) getEmailAttachments(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid email ID")
+		return
+	}
+
+	attachments, err := s.service.GetEmailAttachments(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Email not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting email attachments: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get email attachments")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, attachments)
+}
+
+// downloads a file received with an email
+func (s *Server) downloadEmailAttachment(w http.ResponseWriter, r *http.Request) {
+	emailID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid email ID")
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "attachment_id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid attachment ID")
+		return
+	}
+
+	attachment, err := s.service.GetEmailAttachment(r.Context(), emailID, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting email attachment: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get email attachment")
+		return
+	}
+
+	// always download, the content came from outside and could be active HTML
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write(attachment.Data)
+}
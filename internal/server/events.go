@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// eventsPath is the Server-Sent Events stream of record changes
+const eventsPath = "/api/v1/events"
+
+// eventsKeepAlive is how often an idle event stream gets a comment, so
+// proxies don't close it
+const eventsKeepAlive = 25 * time.Second
+
+// requestTimeout is middleware.Timeout for every request but the event
+// stream, which stays open for as long as the client listens
+func requestTimeout(timeout time.Duration) func(http.Handler) http.Handler {
+	limit := middleware.Timeout(timeout)
+	return func(next http.Handler) http.Handler {
+		limited := limit(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == eventsPath {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limited.ServeHTTP(w, r)
+		})
+	}
+}
+
+// streams record changes as Server-Sent Events until the client goes away.
+// ?entity= limits them to one entity. Events only say which record changed,
+// clients fetch it to see how.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	changes, unsubscribe, err := s.service.SubscribeChanges(r.URL.Query().Get("entity"))
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error subscribing to changes: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to subscribe to changes")
+		return
+	}
+	defer unsubscribe()
+
+	// the server's write timeout would cut the stream, clients reconnect
+	// when it does on servers that can't lift it
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if err := rc.Flush(); err != nil {
+		log.Printf("Error streaming events: %v", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		case change := <-changes:
+			data, err := json.Marshal(change)
+			if err != nil {
+				log.Printf("Error encoding change: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxFormBody caps the size of a public form submission
+const maxFormBody = 64 << 10
+
+// lists the forms
+func (s *Server) getForms(w http.ResponseWriter, r *http.Request) {
+	forms, err := s.service.GetForms(r.Context())
+	if err != nil {
+		log.Printf("Error getting forms: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get forms")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, forms)
+}
+
+// defines a new form and returns its public key
+func (s *Server) createForm(w http.ResponseWriter, r *http.Request) {
+	var req domain.CreateFormRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Entity == "" {
+		req.Entity = domain.EntityUser
+	}
+
+	id, key, err := s.service.CreateForm(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating form: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create form")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]any{"id": id, "key": key})
+}
+
+// grabs a form by ID
+func (s *Server) getForm(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid form ID")
+		return
+	}
+
+	form, err := s.service.GetForm(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Form not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting form: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get form")
+		return
+	}
+
+	respondVersioned(w, r, form.Version, form)
+}
+
+// removes a form
+func (s *Server) deleteForm(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid form ID")
+		return
+	}
+
+	err = s.service.DeleteForm(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Form not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, http.StatusPreconditionFailed, "Form was changed by someone else")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting form: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete form")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lists a form's latest submissions
+func (s *Server) getFormSubmissions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid form ID")
+		return
+	}
+
+	submissions, err := s.service.GetFormSubmissions(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Form not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting form submissions: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get form submissions")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, submissions)
+}
+
+// public endpoint marketing sites post submissions to
+func (s *Server) submitForm(w http.ResponseWriter, r *http.Request) {
+	if !s.formLimiter.Allow(clientIP(r), time.Now()) {
+		respondError(w, http.StatusTooManyRequests, "Too many submissions, try again later")
+		return
+	}
+
+	form, ok := s.publicForm(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBody)
+	fields, err := readFormFields(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid form submission")
+		return
+	}
+
+	_, err = s.service.SubmitForm(r.Context(), form.Key, domain.FormInput{
+		Fields:   fields,
+		IP:       clientIP(r),
+		Referrer: r.Referer(),
+	})
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error submitting form %s: %v", form.Key, err)
+		respondError(w, http.StatusInternalServerError, "Failed to submit form")
+		return
+	}
+
+	// spam gets the same answer as a real submission
+	respondJSON(w, http.StatusAccepted, map[string]string{"status": "received"})
+}
+
+// issues a signed token for forms that require one
+func (s *Server) getFormToken(w http.ResponseWriter, r *http.Request) {
+	form, ok := s.publicForm(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := s.service.IssueFormToken(r.Context(), form.Key)
+	if err != nil {
+		log.Printf("Error issuing form token: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to issue form token")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, map[string]string{"token": token})
+}
+
+// answers CORS preflight requests for the public form endpoints
+func (s *Server) formPreflight(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.publicForm(w, r); !ok {
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// publicForm loads the form named in the URL and applies its CORS policy.
+// It writes the error response itself and returns false when the request
+// can't go on.
+func (s *Server) publicForm(w http.ResponseWriter, r *http.Request) (*domain.Form, bool) {
+	form, err := s.service.GetFormByKey(r.Context(), chi.URLParam(r, "form_key"))
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Form not found")
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error getting form: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get form")
+		return nil, false
+	}
+
+	// posts from servers carry no Origin and aren't subject to CORS
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return form, true
+	}
+	if !slices.Contains(form.AllowedOrigins, "*") && !slices.Contains(form.AllowedOrigins, origin) {
+		respondError(w, http.StatusForbidden, "Origin not allowed")
+		return nil, false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Add("Vary", "Origin")
+	return form, true
+}
+
+// readFormFields reads a JSON object or an HTML form post as flat text fields.
+// Lists become comma separated values.
+func readFormFields(r *http.Request) (map[string]string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	fields := map[string]string{}
+
+	if mediaType == "application/json" {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, err
+		}
+		for name, value := range body {
+			text, err := formText(value)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %w", name, err)
+			}
+			if value != nil {
+				fields[name] = text
+			}
+		}
+		return fields, nil
+	}
+
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(maxFormBody); err != nil {
+			return nil, err
+		}
+	} else if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	for name, values := range r.PostForm {
+		fields[name] = strings.Join(values, ",")
+	}
+	return fields, nil
+}
+
+// formText flattens a decoded JSON value to text
+func formText(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64, bool:
+		return fmt.Sprint(v), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			text, err := formText(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, text)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", errors.New("nested objects aren't supported")
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/service"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// replayedHeaders are the response headers stored with an idempotent
+// response, the rest are set again by the middleware stack. ETag keeps the
+// version a replayed write handed out.
+var replayedHeaders = []string{"Content-Type", "Location", "Cache-Control", "ETag"}
+
+// idempotencyLease is how long a key is held for a request still running.
+// Requests are cancelled after requestLimit, so a key held past its lease
+// belongs to a process that died, and a retry may take it over.
+const idempotencyLease = requestLimit + 5*time.Second
+
+// idempotent lets clients safely retry a POST, PUT or PATCH by sending an
+// Idempotency-Key header. The first request with a key runs and its response is stored, a
+// retry with the same key and body gets that response again instead of
+// creating a duplicate. Server errors aren't stored, so they can be retried.
+func (s *Server) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodPatch) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the body is read up front to tell a retry from a different request,
+		// capped at the largest body any route accepts
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, service.MaxInboundMessage))
+		if err != nil {
+			respondError(w, http.StatusRequestEntityTooLarge, "Request body is too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n")
+		hash.Write(body)
+
+		stored, err := s.service.BeginIdempotentRequest(r.Context(), key, hex.EncodeToString(hash.Sum(nil)), idempotencyLease)
+		if msg, ok := validationMessage(err); ok {
+			respondError(w, http.StatusBadRequest, msg)
+			return
+		}
+		if errors.Is(err, service.ErrIdempotencyKeyReused) {
+			respondError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+			return
+		}
+		if errors.Is(err, service.ErrIdempotencyKeyInFlight) {
+			respondError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking idempotency key: %v", err)
+			respondError(w, http.StatusInternalServerError, "Failed to check Idempotency-Key")
+			return
+		}
+
+		if stored != nil {
+			for name, value := range stored.ResponseHeaders {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.StatusCode)
+			w.Write(stored.ResponseBody)
+			return
+		}
+
+		// the outcome is saved even when the client has gone away, that's
+		// when it is most likely to retry
+		ctx := context.WithoutCancel(r.Context())
+		completed := false
+		defer func() {
+			if !completed {
+				if err := s.service.AbandonIdempotentRequest(ctx, key); err != nil {
+					log.Printf("Error releasing idempotency key: %v", err)
+				}
+			}
+		}()
+
+		var response bytes.Buffer
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(&response)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests {
+			return
+		}
+		headers := map[string]string{}
+		for _, name := range replayedHeaders {
+			if value := ww.Header().Get(name); value != "" {
+				headers[name] = value
+			}
+		}
+		if err := s.service.CompleteIdempotentRequest(ctx, key, status, headers, response.Bytes(), s.cfg.IdempotencyKeyTTL); err != nil {
+			log.Printf("Error saving idempotent response: %v", err)
+			return
+		}
+		completed = true
+	})
+}
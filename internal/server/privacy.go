@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// decodePrivacySubject reads the record an export or erasure is for
+func decodePrivacySubject(w http.ResponseWriter, r *http.Request) (domain.PrivacySubject, bool) {
+	var subject domain.PrivacySubject
+	if err := json.NewDecoder(r.Body).Decode(&subject); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return subject, false
+	}
+	if subject.Entity == "" {
+		subject.Entity = domain.EntityUser
+	}
+	return subject, true
+}
+
+// downloads everything stored about a record as a ZIP of JSON files
+func (s *Server) exportPersonalData(w http.ResponseWriter, r *http.Request) {
+	subject, ok := decodePrivacySubject(w, r)
+	if !ok {
+		return
+	}
+
+	archive, request, err := s.service.ExportPersonalData(r.Context(), subject)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Record not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error exporting personal data: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to export personal data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="personal-data-%s-%d.zip"`, subject.Entity, subject.EntityID))
+	w.Header().Set("X-Privacy-Request-ID", strconv.Itoa(request.ID))
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
+
+// anonymizes a record and everything logged about it
+func (s *Server) erasePersonalData(w http.ResponseWriter, r *http.Request) {
+	subject, ok := decodePrivacySubject(w, r)
+	if !ok {
+		return
+	}
+
+	request, err := s.service.ErasePersonalData(r.Context(), subject)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Record not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error erasing personal data: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to erase personal data")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, request)
+}
+
+// lists data subject requests, optionally only those with ?status=
+func (s *Server) getPrivacyRequests(w http.ResponseWriter, r *http.Request) {
+	requests, err := s.service.GetPrivacyRequests(r.Context(), r.URL.Query().Get("status"))
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting privacy requests: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get privacy requests")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, requests)
+}
+
+// logs a data subject request
+func (s *Server) createPrivacyRequest(w http.ResponseWriter, r *http.Request) {
+	var req domain.PrivacyRequestInput
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Entity == "" {
+		req.Entity = domain.EntityUser
+	}
+
+	id, err := s.service.CreatePrivacyRequest(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Record not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating privacy request: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create privacy request")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]int{"id": id})
+}
+
+// gets a data subject request by ID
+func (s *Server) getPrivacyRequest(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid privacy request ID")
+		return
+	}
+
+	request, err := s.service.GetPrivacyRequest(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Privacy request not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting privacy request: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get privacy request")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, request)
+}
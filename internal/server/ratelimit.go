@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter allows each key a fixed number of hits per window
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[string]*rateWindow
+	// swept is when expired windows were last dropped
+	swept time.Time
+}
+
+// rateWindow counts the hits of one key since start
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// newRateLimiter creates a limiter, a limit below 1 disables it
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string]*rateWindow),
+	}
+}
+
+// Allow records a hit for key and reports whether it is within the limit
+func (l *rateLimiter) Allow(key string, now time.Time) bool {
+	if l.limit < 1 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) >= l.window {
+		for k, w := range l.hits {
+			if now.Sub(w.start) >= l.window {
+				delete(l.hits, k)
+			}
+		}
+		l.swept = now
+	}
+
+	w, ok := l.hits[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.hits[key] = w
+	}
+	w.count++
+	return w.count <= l.limit
+}
+
+// clientIP returns the caller's address without the port. realIP has
+// already replaced RemoteAddr with the address a trusted proxy forwarded.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// realIP replaces RemoteAddr with the client address forwarded by a trusted
+// proxy. X-Forwarded-For is read from the right, past the trusted proxies that
+// appended to it, to the address the first of them was reached from; X-Real-IP
+// is used when there is none. Requests from anywhere else keep their own
+// address, whatever headers they send, so they can't pose as other clients.
+func realIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedIP(r, trusted); ip.IsValid() {
+				r.RemoteAddr = ip.String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedIP returns the client address forwarded with a request received
+// from a trusted proxy, and the zero Addr when there is none to believe
+func forwardedIP(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	if !isTrusted(parseIP(clientIP(r)), trusted) {
+		return netip.Addr{}
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	var client netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseIP(hops[i])
+		if !hop.IsValid() {
+			break
+		}
+		client = hop
+		if !isTrusted(hop, trusted) {
+			break
+		}
+	}
+	if client.IsValid() {
+		return client
+	}
+	return parseIP(r.Header.Get("X-Real-IP"))
+}
+
+// parseIP parses an address with or without a port, the zero Addr when it
+// isn't one
+func parseIP(s string) netip.Addr {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// isTrusted reports whether addr is one of the trusted proxies
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if addr.IsValid() && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
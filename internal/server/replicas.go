@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/repository"
+)
+
+// lastWriteHeader and lastWriteCookie carry when a client last wrote, in Unix
+// milliseconds. Responses to writes set both, so browsers send the cookie
+// back and API clients can echo the header.
+const (
+	lastWriteHeader = "X-Last-Write"
+	lastWriteCookie = "last_write"
+)
+
+// readYourWrites lets the lists, search and reports of read-only requests be
+// read from replicas. Requests that write, and reads carrying a write made
+// within the window, read from the primary so clients always see their own
+// changes. The client holds on to when it wrote, so this works across app
+// instances and for clients sharing an address.
+func readYourWrites(window time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				if !wroteWithin(r, window, time.Now()) {
+					r = r.WithContext(repository.AllowReplicaReads(r.Context()))
+				}
+				next.ServeHTTP(w, r)
+			default:
+				next.ServeHTTP(&lastWriteWriter{ResponseWriter: w, window: window}, r)
+			}
+		})
+	}
+}
+
+// wroteWithin reports whether the request carries a write made within the
+// window before now. Clients can only claim a write as recent as a real one
+// would be, marks too far in the future are ignored.
+func wroteWithin(r *http.Request, window time.Duration, now time.Time) bool {
+	mark := r.Header.Get(lastWriteHeader)
+	if cookie, err := r.Cookie(lastWriteCookie); mark == "" && err == nil {
+		mark = cookie.Value
+	}
+	millis, err := strconv.ParseInt(mark, 10, 64)
+	if err != nil {
+		return false
+	}
+	since := now.Sub(time.UnixMilli(millis))
+	return since > -window && since < window
+}
+
+// lastWriteWriter marks the response to a write with when it was done, as
+// the handler starts to respond, which is after the write committed
+type lastWriteWriter struct {
+	http.ResponseWriter
+	window time.Duration
+	marked bool
+}
+
+func (w *lastWriteWriter) WriteHeader(status int) {
+	if !w.marked {
+		w.marked = true
+		mark := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		w.Header().Set(lastWriteHeader, mark)
+		http.SetCookie(w, &http.Cookie{
+			Name:     lastWriteCookie,
+			Value:    mark,
+			Path:     "/",
+			MaxAge:   int(w.window.Seconds()) + 1,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *lastWriteWriter) Write(b []byte) (int, error) {
+	if !w.marked {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the writer underneath
+func (w *lastWriteWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
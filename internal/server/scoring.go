@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// lists the scoring rules for ?entity= (defaults to user)
+func (s *Server) getScoringRules(w http.ResponseWriter, r *http.Request) {
+	entity := r.URL.Query().Get("entity")
+	if entity == "" {
+		entity = domain.EntityUser
+	}
+
+	rules, err := s.service.GetScoringRules(r.Context(), entity)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting scoring rules: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get scoring rules")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, rules)
+}
+
+// defines a new scoring rule
+func (s *Server) createScoringRule(w http.ResponseWriter, r *http.Request) {
+	var req domain.CreateScoringRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Entity == "" {
+		req.Entity = domain.EntityUser
+	}
+
+	id, err := s.service.CreateScoringRule(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating scoring rule: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create scoring rule")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]int{"id": id})
+}
+
+// grabs a scoring rule by ID
+func (s *Server) getScoringRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid scoring rule ID")
+		return
+	}
+
+	rule, err := s.service.GetScoringRule(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Scoring rule not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting scoring rule: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get scoring rule")
+		return
+	}
+
+	respondVersioned(w, r, rule.Version, rule)
+}
+
+// removes a scoring rule
+func (s *Server) deleteScoringRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid scoring rule ID")
+		return
+	}
+
+	err = s.service.DeleteScoringRule(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Scoring rule not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, http.StatusPreconditionFailed, "Scoring rule was changed by someone else")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting scoring rule: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete scoring rule")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recomputes every score now instead of waiting for the next batch
+func (s *Server) recomputeScores(w http.ResponseWriter, r *http.Request) {
+	scored, err := s.service.RescoreAll(r.Context())
+	if err != nil {
+		log.Printf("Error recomputing scores: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to recompute scores")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]int{"scored": scored})
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// lists saved segments with member counts
+func (s *Server) getSegments(w http.ResponseWriter, r *http.Request) {
+	segments, err := s.service.GetSegments(r.Context())
+	if err != nil {
+		log.Printf("Error getting segments: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get segments")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, segments)
+}
+
+// saves a new segment
+func (s *Server) createSegment(w http.ResponseWriter, r *http.Request) {
+	var req domain.CreateSegmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Entity == "" {
+		req.Entity = domain.EntityUser
+	}
+
+	id, err := s.service.CreateSegment(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating segment: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create segment")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]int{"id": id})
+}
+
+// grabs a segment by ID
+func (s *Server) getSegment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid segment ID")
+		return
+	}
+
+	seg, err := s.service.GetSegment(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Segment not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting segment: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get segment")
+		return
+	}
+
+	respondVersioned(w, r, seg.Version, seg)
+}
+
+// lists the records currently in a segment
+func (s *Server) getSegmentMembers(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid segment ID")
+		return
+	}
+
+	members, err := s.service.GetSegmentMembers(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Segment not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting segment members: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get segment members")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, members)
+}
+
+// removes a segment
+func (s *Server) deleteSegment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid segment ID")
+		return
+	}
+
+	err = s.service.DeleteSegment(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Segment not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, http.StatusPreconditionFailed, "Segment was changed by someone else")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting segment: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete segment")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
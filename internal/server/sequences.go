@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// lists the sequences with their stats
+func (s *Server) getSequences(w http.ResponseWriter, r *http.Request) {
+	sequences, err := s.service.GetSequences(r.Context())
+	if err != nil {
+		log.Printf("Error getting sequences: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get sequences")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, sequences)
+}
+
+// saves a new sequence
+func (s *Server) createSequence(w http.ResponseWriter, r *http.Request) {
+	var req domain.CreateSequenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Entity == "" {
+		req.Entity = domain.EntityUser
+	}
+
+	id, err := s.service.CreateSequence(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating sequence: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create sequence")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]int{"id": id})
+}
+
+// gets a sequence with its stats
+func (s *Server) getSequence(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid sequence ID")
+		return
+	}
+
+	sequence, err := s.service.GetSequence(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Sequence not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting sequence: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get sequence")
+		return
+	}
+
+	respondVersioned(w, r, sequence.Version, sequence)
+}
+
+// removes a sequence and its enrollments
+func (s *Server) deleteSequence(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid sequence ID")
+		return
+	}
+
+	err = s.service.DeleteSequence(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Sequence not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, http.StatusPreconditionFailed, "Sequence was changed by someone else")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting sequence: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete sequence")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lists the latest enrollments of a sequence
+func (s *Server) getSequenceEnrollments(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid sequence ID")
+		return
+	}
+
+	enrollments, err := s.service.GetSequenceEnrollments(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Sequence not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting sequence enrollments: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get sequence enrollments")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, enrollments)
+}
+
+// enrolls records in a sequence
+func (s *Server) enrollInSequence(w http.ResponseWriter, r *http.Request) {
+	s.changeEnrollments(w, r, "enrolled", s.service.Enroll)
+}
+
+// removes records from a sequence
+func (s *Server) unenrollFromSequence(w http.ResponseWriter, r *http.Request) {
+	s.changeEnrollments(w, r, "removed", s.service.Unenroll)
+}
+
+// changeEnrollments decodes an enrollment request, applies it and reports the count under key
+func (s *Server) changeEnrollments(w http.ResponseWriter, r *http.Request, key string,
+	apply func(ctx context.Context, id int, req domain.EnrollmentRequest) (int, error)) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid sequence ID")
+		return
+	}
+	var req domain.EnrollmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Entity == "" {
+		req.Entity = domain.EntityUser
+	}
+
+	count, err := apply(r.Context(), id, req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Sequence not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating sequence enrollments: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to update sequence enrollments")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]int{key: count})
+}
@@ -2,26 +2,39 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dyrober/AgencyCRM/internal/config"
 	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
 	"github.com/dyrober/AgencyCRM/internal/service"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+// requestLimit is how long a request may run before it is cancelled
+const requestLimit = 30 * time.Second
+
 // make a server obj
 type Server struct {
 	*http.Server
 	service   *service.Service
 	templates *template.Template
 	cfg       *config.Config
+
+	// formLimiter rate limits public form submissions per IP
+	formLimiter *rateLimiter
+
+	// closing is closed when the server shuts down, to end event streams
+	// that would keep it waiting
+	closing chan struct{}
 }
 
 // create a new http server
@@ -30,10 +43,11 @@ func NewServer(cfg *config.Config, svc *service.Service) *Server {
 
 	//Middle ware stack
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(realIP(cfg.TrustedProxies))
+	r.Use(auditActor("public", false))
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(30 * time.Second))
+	r.Use(requestTimeout(requestLimit))
 	srv := &Server{
 		Server: &http.Server{
 			Addr:         cfg.ServerAddress,
@@ -42,8 +56,17 @@ func NewServer(cfg *config.Config, svc *service.Service) *Server {
 			WriteTimeout: cfg.ServerWriteTimeout,
 			IdleTimeout:  120 * time.Second,
 		},
-		service: svc,
-		cfg:     cfg,
+		service:     svc,
+		cfg:         cfg,
+		formLimiter: newRateLimiter(cfg.FormRateLimit, time.Minute),
+		closing:     make(chan struct{}),
+	}
+	srv.RegisterOnShutdown(func() { close(srv.closing) })
+	// POSTs with an Idempotency-Key can be retried without side effects
+	r.Use(srv.idempotent)
+	// read-only requests may read from replicas, when there are some
+	if len(cfg.DB.ReplicaDSNs) > 0 {
+		r.Use(readYourWrites(cfg.DB.ReadYourWritesWindow))
 	}
 
 	//static file server
@@ -57,11 +80,142 @@ func NewServer(cfg *config.Config, svc *service.Service) *Server {
 	//API Routes
 	r.Get("/health", srv.healthCheck)
 
+	//Public form endpoints, called from client sites
+	r.Post("/forms/{form_key}", srv.submitForm)
+	r.Options("/forms/{form_key}", srv.formPreflight)
+	r.Get("/forms/{form_key}/token", srv.getFormToken)
+	r.Options("/forms/{form_key}/token", srv.formPreflight)
+
+	//Public email tracking endpoints
+	r.Get("/t/o/{token}", srv.trackOpen)
+	r.Get("/t/c/{token}/{n}", srv.trackClick)
+
+	//Public unsubscribe endpoints, linked from every email
+	r.Get("/u/{token}", srv.unsubscribePage)
+	r.Post("/u/{token}", srv.unsubscribe)
+
 	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(auditActor("api", true))
+
 		r.Route("/users", func(r chi.Router) {
 			r.Get("/", srv.getUsers)
 			r.Post("/", srv.createUser)
+			r.Post("/bulk", srv.bulkUsers)
 			r.Get("/{id}", srv.getUser)
+			r.With(requireIfMatch).Patch("/{id}", srv.updateUser)
+			r.With(requireIfMatch).Delete("/{id}", srv.deleteUser)
+			r.With(requireIfMatch).Put("/{id}/out-of-office", srv.setOutOfOffice)
+			r.Get("/{id}/emails", srv.getUserEmails)
+			r.Get("/{id}/history", srv.getUserHistory)
+			r.Post("/{id}/emails", srv.sendUserEmail)
+		})
+		r.Get("/search", srv.search)
+		r.Get("/events", srv.streamEvents)
+		r.Route("/custom-fields", func(r chi.Router) {
+			r.Get("/", srv.getCustomFields)
+			r.Post("/", srv.createCustomField)
+			r.Get("/{id}", srv.getCustomField)
+			r.With(requireIfMatch).Patch("/{id}", srv.updateCustomField)
+			r.With(requireIfMatch).Delete("/{id}", srv.deleteCustomField)
+		})
+		r.Route("/tags", func(r chi.Router) {
+			r.Get("/", srv.getTags)
+			r.Post("/", srv.createTag)
+			r.Post("/add", srv.addTags)
+			r.Post("/remove", srv.removeTags)
+		})
+		r.Route("/segments", func(r chi.Router) {
+			r.Get("/", srv.getSegments)
+			r.Post("/", srv.createSegment)
+			r.Get("/{id}", srv.getSegment)
+			r.With(requireIfMatch).Delete("/{id}", srv.deleteSegment)
+			r.Get("/{id}/members", srv.getSegmentMembers)
+		})
+		r.Route("/scoring-rules", func(r chi.Router) {
+			r.Get("/", srv.getScoringRules)
+			r.Post("/", srv.createScoringRule)
+			r.Get("/{id}", srv.getScoringRule)
+			r.With(requireIfMatch).Delete("/{id}", srv.deleteScoringRule)
+			r.Post("/recompute", srv.recomputeScores)
+		})
+		r.Route("/assignment-rules", func(r chi.Router) {
+			r.Get("/", srv.getAssignmentRules)
+			r.Post("/", srv.createAssignmentRule)
+			r.Get("/{id}", srv.getAssignmentRule)
+			r.With(requireIfMatch).Delete("/{id}", srv.deleteAssignmentRule)
+		})
+		r.Route("/assignments", func(r chi.Router) {
+			r.Get("/", srv.getAssignments)
+			r.Post("/reassign", srv.reassign)
+		})
+		r.Route("/automations", func(r chi.Router) {
+			r.Get("/", srv.getAutomations)
+			r.Post("/", srv.createAutomation)
+			r.Get("/{id}", srv.getAutomation)
+			r.With(requireIfMatch).Delete("/{id}", srv.deleteAutomation)
+			r.Get("/{id}/runs", srv.getAutomationRuns)
+		})
+		r.Route("/webhooks", func(r chi.Router) {
+			r.Get("/", srv.getWebhooks)
+			r.Post("/", srv.createWebhook)
+			r.Get("/{id}", srv.getWebhook)
+			r.With(requireIfMatch).Delete("/{id}", srv.deleteWebhook)
+			r.Get("/{id}/deliveries", srv.getWebhookDeliveries)
+			r.Post("/deliveries/{id}/redeliver", srv.redeliverWebhook)
+		})
+		r.Route("/forms", func(r chi.Router) {
+			r.Get("/", srv.getForms)
+			r.Post("/", srv.createForm)
+			r.Get("/{id}", srv.getForm)
+			r.With(requireIfMatch).Delete("/{id}", srv.deleteForm)
+			r.Get("/{id}/submissions", srv.getFormSubmissions)
+		})
+		r.Route("/email-templates", func(r chi.Router) {
+			r.Get("/", srv.getEmailTemplates)
+			r.Post("/", srv.createEmailTemplate)
+			r.Get("/{id}", srv.getEmailTemplate)
+			r.With(requireIfMatch).Delete("/{id}", srv.deleteEmailTemplate)
+		})
+		r.Route("/sequences", func(r chi.Router) {
+			r.Get("/", srv.getSequences)
+			r.Post("/", srv.createSequence)
+			r.Get("/{id}", srv.getSequence)
+			r.With(requireIfMatch).Delete("/{id}", srv.deleteSequence)
+			r.Get("/{id}/enrollments", srv.getSequenceEnrollments)
+			r.Post("/{id}/enrollments", srv.enrollInSequence)
+			r.Post("/{id}/unenroll", srv.unenrollFromSequence)
+		})
+		r.Route("/emails", func(r chi.Router) {
+			r.Post("/inbound", srv.receiveEmail)
+			r.Get("/{id}/attachments", srv.getEmailAttachments)
+			r.Get("/{id}/attachments/{attachment_id}", srv.downloadEmailAttachment)
+		})
+		r.Get("/activities", srv.getActivities)
+		r.Route("/consents", func(r chi.Router) {
+			r.Get("/", srv.getConsents)
+			r.Post("/", srv.recordConsent)
+		})
+		r.Route("/suppressions", func(r chi.Router) {
+			r.Get("/", srv.getSuppressions)
+			r.Post("/", srv.createSuppression)
+			r.Delete("/{id}", srv.deleteSuppression)
+		})
+		r.Route("/privacy", func(r chi.Router) {
+			r.Post("/export", srv.exportPersonalData)
+			r.Post("/erase", srv.erasePersonalData)
+			r.Get("/requests", srv.getPrivacyRequests)
+			r.Post("/requests", srv.createPrivacyRequest)
+			r.Get("/requests/{id}", srv.getPrivacyRequest)
+		})
+		r.Route("/audit", func(r chi.Router) {
+			r.Get("/", srv.getAuditEvents)
+			r.Get("/export", srv.exportAuditEvents)
+			r.Get("/verify", srv.verifyAuditLog)
+		})
+		r.Route("/trash", func(r chi.Router) {
+			r.Get("/", srv.getTrash)
+			r.Post("/{entity}/{id}/restore", srv.restoreFromTrash)
+			r.Delete("/{entity}/{id}", srv.purgeFromTrash)
 		})
 	})
 	return srv
@@ -117,9 +271,14 @@ func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, response)
 }
 
-// grabs all users
+// grabs all users, custom fields are filtered with cf.<key>=value
 func (s *Server) getUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := s.service.GetUsers(r.Context())
+	opts := listOptionsFromQuery(r)
+	users, err := s.service.GetUsers(r.Context(), opts)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
 	if err != nil {
 		log.Printf("Error getting users: %v", err)
 		respondError(w, http.StatusInternalServerError, "Failed to get users")
@@ -138,15 +297,62 @@ func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// ?as_of= shows the user as it was at that time
+	if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+		t, err := time.Parse(time.RFC3339, asOf)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "as_of must be an RFC 3339 time")
+			return
+		}
+		user, err := s.service.GetUserAsOf(r.Context(), id, t)
+		if errors.Is(err, repository.ErrNotFound) {
+			respondError(w, http.StatusNotFound, "User not found at that time")
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting user as of %s: %v", asOf, err)
+			respondError(w, http.StatusInternalServerError, "Failed to get user")
+			return
+		}
+		respondJSON(w, http.StatusOK, user)
+		return
+	}
+
 	//get the user
 	user, err := s.service.GetUser(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
 	if err != nil {
 		log.Printf("Error getting user: %v", err)
 		respondError(w, http.StatusInternalServerError, "Failed to get user")
 		return
 	}
 
-	respondJSON(w, http.StatusOK, user)
+	respondVersioned(w, r, user.Version, user)
+}
+
+// lists the changes to a user's tracked fields, newest first
+func (s *Server) getUserHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	changes, err := s.service.GetFieldHistory(r.Context(), domain.EntityUser, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting user history: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get user history")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, changes)
 }
 
 // Create a new User
@@ -164,6 +370,10 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 
 	//create user
 	id, err := s.service.CreateUser(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
 	if err != nil {
 		log.Printf("Error creating user: %v", err)
 		respondError(w, http.StatusInternalServerError, "Failed to create user")
@@ -173,6 +383,251 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusCreated, map[string]int{"id": id})
 }
 
+// Update some fields of a user
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	var req domain.UpdateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	err = s.service.UpdateUser(r.Context(), id, req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, http.StatusPreconditionFailed, "User was changed by someone else")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating user: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+
+	s.setUserETag(r.Context(), w, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Move a user to the trash
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	err = s.service.DeleteUser(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, http.StatusPreconditionFailed, "User was changed by someone else")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting user: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Search across records for the global search box
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		respondError(w, http.StatusBadRequest, "Query parameter q is required")
+		return
+	}
+
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 0 {
+			respondError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+	}
+
+	results, err := s.service.Search(r.Context(), query, limit)
+	if err != nil {
+		log.Printf("Error searching: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to search")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, results)
+}
+
+// lists the custom field definitions for ?entity= (defaults to user)
+func (s *Server) getCustomFields(w http.ResponseWriter, r *http.Request) {
+	entity := r.URL.Query().Get("entity")
+	if entity == "" {
+		entity = domain.EntityUser
+	}
+
+	defs, err := s.service.GetCustomFields(r.Context(), entity)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting custom fields: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get custom fields")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, defs)
+}
+
+// defines a new custom field
+func (s *Server) createCustomField(w http.ResponseWriter, r *http.Request) {
+	var req domain.CreateCustomFieldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Entity == "" {
+		req.Entity = domain.EntityUser
+	}
+
+	id, err := s.service.CreateCustomField(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating custom field: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create custom field")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]int{"id": id})
+}
+
+// relabels a custom field or turns its history on or off
+func (s *Server) updateCustomField(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid custom field ID")
+		return
+	}
+	var req domain.UpdateCustomFieldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	err = s.service.UpdateCustomField(r.Context(), id, req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Custom field not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, http.StatusPreconditionFailed, "Custom field was changed by someone else")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating custom field: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to update custom field")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// grabs a custom field definition by ID
+func (s *Server) getCustomField(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid custom field ID")
+		return
+	}
+
+	def, err := s.service.GetCustomField(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Custom field not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting custom field: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get custom field")
+		return
+	}
+
+	respondVersioned(w, r, def.Version, def)
+}
+
+// removes a custom field definition
+func (s *Server) deleteCustomField(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid custom field ID")
+		return
+	}
+
+	err = s.service.DeleteCustomField(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Custom field not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, http.StatusPreconditionFailed, "Custom field was changed by someone else")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting custom field: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete custom field")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listOptionsFromQuery reads ?sort= and cf.<key>= filters from the query string
+func listOptionsFromQuery(r *http.Request) domain.ListOptions {
+	query := r.URL.Query()
+	opts := domain.ListOptions{Sort: query.Get("sort")}
+	for param, values := range query {
+		key, ok := strings.CutPrefix(param, "cf.")
+		if !ok || key == "" || len(values) == 0 {
+			continue
+		}
+		if opts.CustomFilters == nil {
+			opts.CustomFilters = make(map[string]any)
+		}
+		opts.CustomFilters[key] = values[0]
+	}
+	return opts
+}
+
+// validationMessage reports whether err is a service validation error and returns its message
+func validationMessage(err error) (string, bool) {
+	var verr service.ValidationError
+	if errors.As(err, &verr) {
+		return verr.Error(), true
+	}
+	return "", false
+}
+
 // Fun to send Json
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -1,12 +1,18 @@
 package server
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -29,6 +35,8 @@ func setupTestServer() (*Server, *repository.MockRepository) {
 		ServerAddress:      ":8080",
 		ServerReadTimeout:  10 * time.Second,
 		ServerWriteTimeout: 10 * time.Second,
+		FormRateLimit:      5,
+		IdempotencyKeyTTL:  time.Hour,
 	}
 
 	// Create a server with the service
@@ -37,6 +45,40 @@ func setupTestServer() (*Server, *repository.MockRepository) {
 	return srv, mockRepo
 }
 
+// serveJSON sends a request with an optional JSON body through the full router.
+// Writes are sent with If-Match: * so tests that aren't about versions don't
+// need to track them.
+func serveJSON(t *testing.T, srv *Server, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	header := http.Header{}
+	if method != http.MethodGet && method != http.MethodPost {
+		header.Set("If-Match", "*")
+	}
+	return serveJSONWithHeader(t, srv, method, path, body, header)
+}
+
+// serveJSONWithHeader is serveJSON with exactly the given request headers
+func serveJSONWithHeader(t *testing.T, srv *Server, method, path string, body any, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, path, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, req)
+	return rr
+}
+
 func TestHealthCheck(t *testing.T) {
 	srv, _ := setupTestServer()
 
@@ -173,3 +215,1505 @@ func TestCreateUser(t *testing.T) {
 		t.Errorf("handler response does not contain id field")
 	}
 }
+
+func TestSearch(t *testing.T) {
+	srv, mockRepo := setupTestServer()
+
+	for _, user := range []domain.User{
+		{Name: "Alice Jones", Email: "alice@example.com"},
+		{Name: "Bob Smith", Email: "bob@jones.io"},
+		{Name: "Carol White", Email: "carol@example.com"},
+	} {
+		if _, err := mockRepo.CreateUser(context.Background(), user); err != nil {
+			t.Fatalf("Failed to create test user: %v", err)
+		}
+	}
+
+	t.Run("matches names and emails", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/api/v1/search?q=jones", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+
+		var results []domain.SearchResult
+		if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(results))
+		}
+
+		// Name matches rank above email matches
+		if results[0].Title != "Alice Jones" {
+			t.Errorf("expected name match first, got %v", results[0].Title)
+		}
+
+		if results[0].Snippet != "Alice <mark>Jones</mark> alice@example.com" {
+			t.Errorf("unexpected snippet: %v", results[0].Snippet)
+		}
+	})
+
+	t.Run("missing query", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/api/v1/search", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestCustomFields(t *testing.T) {
+	srv, _ := setupTestServer()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		return serveJSON(t, srv, method, path, body)
+	}
+
+	// Define a select field
+	rr := serve("POST", "/api/v1/custom-fields", domain.CreateCustomFieldRequest{
+		Key:     "tier",
+		Label:   "Contract Tier",
+		Type:    domain.CustomFieldSelect,
+		Options: []string{"gold", "silver"},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create custom field returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	// Invalid definitions are rejected
+	rr = serve("POST", "/api/v1/custom-fields", domain.CreateCustomFieldRequest{
+		Key:   "tier",
+		Label: "Tier",
+		Type:  "currency",
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for bad definition, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	// Keys are unique per entity
+	rr = serve("POST", "/api/v1/custom-fields", domain.CreateCustomFieldRequest{
+		Key:   "tier",
+		Label: "Tier",
+		Type:  domain.CustomFieldText,
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for a duplicate key, got %v: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+
+	// Create users with values
+	for _, req := range []domain.CreateUserRequest{
+		{Name: "Gold User", Email: "gold@example.com", CustomFields: map[string]any{"tier": "gold"}},
+		{Name: "Silver User", Email: "silver@example.com", CustomFields: map[string]any{"tier": "silver"}},
+	} {
+		if rr := serve("POST", "/api/v1/users", req); rr.Code != http.StatusCreated {
+			t.Fatalf("create user returned %v: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	// Values that fail validation are a bad request
+	rr = serve("POST", "/api/v1/users", domain.CreateUserRequest{
+		Name: "Bronze User", Email: "bronze@example.com", CustomFields: map[string]any{"tier": "bronze"},
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for invalid value, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	// Filter by custom field
+	rr = serve("GET", "/api/v1/users?cf.tier=gold", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list users returned %v: %s", rr.Code, rr.Body.String())
+	}
+	var users []domain.UserResponse
+	if err := json.NewDecoder(rr.Body).Decode(&users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Name != "Gold User" {
+		t.Fatalf("expected only the gold user, got %+v", users)
+	}
+	if users[0].CustomFields["tier"] != "gold" {
+		t.Errorf("expected custom field in response, got %+v", users[0].CustomFields)
+	}
+
+	// Sort by custom field
+	rr = serve("GET", "/api/v1/users?sort=-cf.tier", nil)
+	users = nil
+	if err := json.NewDecoder(rr.Body).Decode(&users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Name != "Silver User" {
+		t.Errorf("expected silver user first, got %+v", users)
+	}
+
+	// Filtering on an undefined field is a bad request
+	if rr := serve("GET", "/api/v1/users?cf.shoe_size=10", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for unknown filter, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	// Delete the definition
+	if rr := serve("DELETE", "/api/v1/custom-fields/1", nil); rr.Code != http.StatusNoContent {
+		t.Errorf("expected %v deleting field, got %v", http.StatusNoContent, rr.Code)
+	}
+	if rr := serve("DELETE", "/api/v1/custom-fields/1", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v deleting missing field, got %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestTagsAndSegments(t *testing.T) {
+	srv, mockRepo := setupTestServer()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		return serveJSON(t, srv, method, path, body)
+	}
+
+	var ids []int
+	for _, user := range []domain.User{
+		{Name: "Alice", Email: "alice@example.com"},
+		{Name: "Bob", Email: "bob@example.com"},
+		{Name: "Carol", Email: "carol@example.com"},
+	} {
+		id, err := mockRepo.CreateUser(context.Background(), user)
+		if err != nil {
+			t.Fatalf("Failed to create test user: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	// Bulk tag Alice and Bob, then untag Bob
+	rr := serve("POST", "/api/v1/tags/add", domain.BulkTagRequest{IDs: ids[:2], Tags: []string{"Webinar"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("add tags returned %v: %s", rr.Code, rr.Body.String())
+	}
+	rr = serve("POST", "/api/v1/tags/remove", domain.BulkTagRequest{IDs: ids[1:2], Tags: []string{"webinar"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("remove tags returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("POST", "/api/v1/tags/add", domain.BulkTagRequest{Tags: []string{"x"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v without ids, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	// Registry shows usage
+	rr = serve("GET", "/api/v1/tags", nil)
+	var tags []domain.Tag
+	if err := json.NewDecoder(rr.Body).Decode(&tags); err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "webinar" || tags[0].UsageCount != 1 {
+		t.Fatalf("unexpected tag registry: %+v", tags)
+	}
+
+	// Tags appear on the user
+	rr = serve("GET", fmt.Sprintf("/api/v1/users/%d", ids[0]), nil)
+	var user domain.UserResponse
+	if err := json.NewDecoder(rr.Body).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if len(user.Tags) != 1 || user.Tags[0] != "webinar" {
+		t.Errorf("expected user to be tagged, got %+v", user.Tags)
+	}
+
+	// Save a segment and read its members
+	rr = serve("POST", "/api/v1/segments", domain.CreateSegmentRequest{
+		Name:       "Webinar attendees",
+		Expression: "tag:webinar AND created_after:30d",
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create segment returned %v: %s", rr.Code, rr.Body.String())
+	}
+	var created map[string]int
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = serve("GET", fmt.Sprintf("/api/v1/segments/%d", created["id"]), nil)
+	var seg domain.Segment
+	if err := json.NewDecoder(rr.Body).Decode(&seg); err != nil {
+		t.Fatal(err)
+	}
+	if seg.MemberCount != 1 {
+		t.Errorf("expected 1 member, got %d", seg.MemberCount)
+	}
+
+	rr = serve("GET", fmt.Sprintf("/api/v1/segments/%d/members", created["id"]), nil)
+	var members []domain.UserResponse
+	if err := json.NewDecoder(rr.Body).Decode(&members); err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].ID != ids[0] {
+		t.Errorf("expected only Alice in the segment, got %+v", members)
+	}
+
+	// Invalid expressions and missing segments
+	if rr := serve("POST", "/api/v1/segments", domain.CreateSegmentRequest{Name: "Bad", Expression: "status:qualified"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for a bad expression, got %v", http.StatusBadRequest, rr.Code)
+	}
+	if rr := serve("GET", "/api/v1/segments/99/members", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for a missing segment, got %v", http.StatusNotFound, rr.Code)
+	}
+	if rr := serve("DELETE", fmt.Sprintf("/api/v1/segments/%d", created["id"]), nil); rr.Code != http.StatusNoContent {
+		t.Errorf("expected %v deleting segment, got %v", http.StatusNoContent, rr.Code)
+	}
+}
+
+func TestScoring(t *testing.T) {
+	srv, mockRepo := setupTestServer()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		return serveJSON(t, srv, method, path, body)
+	}
+
+	var ids []int
+	for _, user := range []domain.User{
+		{Name: "Alice", Email: "alice@example.com"},
+		{Name: "Bob", Email: "bob@example.com"},
+	} {
+		id, err := mockRepo.CreateUser(context.Background(), user)
+		if err != nil {
+			t.Fatalf("Failed to create test user: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := mockRepo.AddTags(context.Background(), "user", ids[1:], []string{"webinar"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := serve("POST", "/api/v1/scoring-rules", domain.CreateScoringRuleRequest{
+		Name:       "Webinar attendee",
+		Type:       domain.ScoringRuleCondition,
+		Expression: "tag:webinar",
+		Points:     25,
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create scoring rule returned %v: %s", rr.Code, rr.Body.String())
+	}
+	rr = serve("POST", "/api/v1/scoring-rules", domain.CreateScoringRuleRequest{Name: "Broken", Type: domain.ScoringRuleCondition, Points: 5})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for a rule without an expression, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	rr = serve("POST", "/api/v1/scoring-rules/recompute", nil)
+	var recomputed map[string]int
+	if err := json.NewDecoder(rr.Body).Decode(&recomputed); err != nil {
+		t.Fatal(err)
+	}
+	if recomputed["scored"] != 2 {
+		t.Errorf("expected 2 users scored, got %v", recomputed)
+	}
+
+	// Highest score first, with the breakdown explaining it
+	rr = serve("GET", "/api/v1/users?sort=-score", nil)
+	var users []domain.UserResponse
+	if err := json.NewDecoder(rr.Body).Decode(&users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].ID != ids[1] || users[0].Score != 25 {
+		t.Fatalf("expected Bob first with 25 points, got %+v", users)
+	}
+	if len(users[0].ScoreBreakdown) != 1 || users[0].ScoreBreakdown[0].Rule != "Webinar attendee" {
+		t.Errorf("unexpected breakdown: %+v", users[0].ScoreBreakdown)
+	}
+
+	if rr := serve("DELETE", "/api/v1/scoring-rules/99", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v deleting a missing rule, got %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	srv, mockRepo := setupTestServer()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		return serveJSON(t, srv, method, path, body)
+	}
+
+	var reps []int
+	for _, user := range []domain.User{
+		{Name: "Ann", Email: "ann@agency.test"},
+		{Name: "Ben", Email: "ben@agency.test"},
+	} {
+		id, err := mockRepo.CreateUser(context.Background(), user)
+		if err != nil {
+			t.Fatalf("Failed to create test user: %v", err)
+		}
+		reps = append(reps, id)
+	}
+
+	rr := serve("POST", "/api/v1/assignment-rules", domain.CreateAssignmentRuleRequest{
+		Name:     "Inbound",
+		Strategy: domain.AssignRoundRobin,
+		Members:  []domain.AssignmentMember{{UserID: reps[0]}, {UserID: reps[1]}},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create assignment rule returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	// Ann is away, so the new lead goes to Ben
+	if rr := serve("PUT", fmt.Sprintf("/api/v1/users/%d/out-of-office", reps[0]), domain.OutOfOfficeRequest{OutOfOffice: true}); rr.Code != http.StatusNoContent {
+		t.Fatalf("out of office returned %v: %s", rr.Code, rr.Body.String())
+	}
+	rr = serve("POST", "/api/v1/users", domain.CreateUserRequest{Name: "Lead", Email: "lead@example.com"})
+	var created map[string]int
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	lead, _ := mockRepo.GetUser(context.Background(), created["id"])
+	if lead.OwnerID == nil || *lead.OwnerID != reps[1] {
+		t.Fatalf("expected the lead to be assigned to %d, got %v", reps[1], lead.OwnerID)
+	}
+
+	// Bulk reassign back to Ann and read the history
+	rr = serve("POST", "/api/v1/assignments/reassign", domain.ReassignRequest{IDs: []int{created["id"]}, OwnerID: reps[0]})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("reassign returned %v: %s", rr.Code, rr.Body.String())
+	}
+	rr = serve("GET", fmt.Sprintf("/api/v1/assignments?entity_id=%d", created["id"]), nil)
+	var history []domain.Assignment
+	if err := json.NewDecoder(rr.Body).Decode(&history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Reason != domain.AssignmentReasonManual || *history[0].PreviousOwnerID != reps[1] {
+		t.Errorf("unexpected assignment history: %+v", history)
+	}
+
+	if rr := serve("POST", "/api/v1/assignments/reassign", domain.ReassignRequest{IDs: []int{1}, OwnerID: 99}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v reassigning to a missing user, got %v", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestUpdateUserAndAutomations(t *testing.T) {
+	srv, mockRepo := setupTestServer()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		return serveJSON(t, srv, method, path, body)
+	}
+
+	id, err := mockRepo.CreateUser(context.Background(), domain.User{Name: "Alice", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	rr := serve("POST", "/api/v1/automations", domain.CreateAutomationRequest{
+		Name:    "Tag renamed",
+		Trigger: domain.AutomationTrigger{Type: domain.TriggerFieldChanged, Field: "name"},
+		Actions: []domain.AutomationAction{{Type: domain.ActionAddTag, Tag: "renamed"}},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create automation returned %v: %s", rr.Code, rr.Body.String())
+	}
+	var created map[string]int
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	name := "Alice Smith"
+	if rr := serve("PATCH", fmt.Sprintf("/api/v1/users/%d", id), domain.UpdateUserRequest{Name: &name}); rr.Code != http.StatusNoContent {
+		t.Fatalf("update user returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("PATCH", "/api/v1/users/99", domain.UpdateUserRequest{Name: &name}); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v updating a missing user, got %v", http.StatusNotFound, rr.Code)
+	}
+
+	rr = serve("GET", fmt.Sprintf("/api/v1/automations/%d/runs", created["id"]), nil)
+	var runs []domain.AutomationRun
+	if err := json.NewDecoder(rr.Body).Decode(&runs); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Status != domain.RunPending || runs[0].EntityID != id {
+		t.Errorf("expected one pending run, got %+v", runs)
+	}
+
+	if rr := serve("GET", "/api/v1/automations/99/runs", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for a missing automation, got %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	srv, _ := setupTestServer()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		return serveJSON(t, srv, method, path, body)
+	}
+
+	var received []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get(service.WebhookEventHeader))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	if rr := serve("POST", "/api/v1/webhooks", domain.CreateWebhookRequest{URL: receiver.URL, Events: []string{"deal.stage_changed"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for an unknown event, got %v", http.StatusBadRequest, rr.Code)
+	}
+	rr := serve("POST", "/api/v1/webhooks", domain.CreateWebhookRequest{URL: receiver.URL, Events: []string{domain.EventUserCreated}})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create webhook returned %v: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		ID     int    `json:"id"`
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Secret == "" {
+		t.Error("expected the generated secret in the response")
+	}
+
+	if rr := serve("POST", "/api/v1/users", domain.CreateUserRequest{Name: "Lead", Email: "lead@example.com"}); rr.Code != http.StatusCreated {
+		t.Fatalf("create user returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := srv.service.DispatchWebhooks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 || received[0] != domain.EventUserCreated {
+		t.Fatalf("expected one user.created delivery, got %v", received)
+	}
+
+	rr = serve("GET", fmt.Sprintf("/api/v1/webhooks/%d/deliveries", created.ID), nil)
+	var deliveries []domain.WebhookDelivery
+	if err := json.NewDecoder(rr.Body).Decode(&deliveries); err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != domain.DeliveryPending || deliveries[0].ResponseCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected one pending delivery after a 503, got %+v", deliveries)
+	}
+
+	if rr := serve("POST", fmt.Sprintf("/api/v1/webhooks/deliveries/%d/redeliver", deliveries[0].ID), nil); rr.Code != http.StatusAccepted {
+		t.Errorf("redeliver returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := srv.service.DispatchWebhooks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 {
+		t.Errorf("expected the redelivery to be sent, got %v", received)
+	}
+
+	if rr := serve("POST", "/api/v1/webhooks/deliveries/99/redeliver", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for a missing delivery, got %v", http.StatusNotFound, rr.Code)
+	}
+	if rr := serve("GET", "/api/v1/webhooks/99/deliveries", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for a missing webhook, got %v", http.StatusNotFound, rr.Code)
+	}
+	if rr := serve("DELETE", fmt.Sprintf("/api/v1/webhooks/%d", created.ID), nil); rr.Code != http.StatusNoContent {
+		t.Errorf("delete webhook returned %v", rr.Code)
+	}
+}
+
+func TestPublicForms(t *testing.T) {
+	srv, mockRepo := setupTestServer()
+
+	rr := serveJSON(t, srv, "POST", "/api/v1/forms", domain.CreateFormRequest{
+		Name:           "Contact us",
+		FieldMappings:  map[string]string{"name": "name", "email": "email"},
+		AllowedOrigins: []string{"https://client.example"},
+		HoneypotField:  "website",
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create form returned %v: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		ID  int    `json:"id"`
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(origin, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/forms/"+created.Key, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "203.0.113.7:5000"
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	preflight := httptest.NewRequest("OPTIONS", "/forms/"+created.Key, nil)
+	preflight.Header.Set("Origin", "https://client.example")
+	rr = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, preflight)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Origin") != "https://client.example" {
+		t.Errorf("unexpected preflight response %v %v", rr.Code, rr.Header())
+	}
+
+	if rr := post("https://evil.example", "email=a%40example.com"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected %v for a foreign origin, got %v", http.StatusForbidden, rr.Code)
+	}
+	if rr := post("https://client.example", "name=Ada&email=ada%40example.com&utm_source=ads"); rr.Code != http.StatusAccepted {
+		t.Fatalf("submit returned %v: %s", rr.Code, rr.Body.String())
+	}
+	// spam gets the same answer
+	if rr := post("", "email=bot%40example.com&website=spam"); rr.Code != http.StatusAccepted {
+		t.Errorf("expected %v for spam, got %v", http.StatusAccepted, rr.Code)
+	}
+	if user, err := mockRepo.GetUserByEmail(context.Background(), "ada@example.com"); err != nil || user.Name != "Ada" {
+		t.Errorf("expected the submission to create Ada, got %+v %v", user, err)
+	}
+
+	rr = serveJSON(t, srv, "GET", fmt.Sprintf("/api/v1/forms/%d/submissions", created.ID), nil)
+	var submissions []domain.FormSubmission
+	if err := json.NewDecoder(rr.Body).Decode(&submissions); err != nil {
+		t.Fatal(err)
+	}
+	// the rejected origin never reached the service
+	if len(submissions) != 2 || submissions[0].Status != domain.SubmissionSpam || submissions[1].UTM["utm_source"] != "ads" {
+		t.Errorf("unexpected submissions %+v", submissions)
+	}
+
+	// the test config allows 5 submissions per minute, 3 were used
+	post("", "email=c%40example.com")
+	post("", "email=d%40example.com")
+	if rr := post("", "email=e%40example.com"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected %v once the limit is reached, got %v", http.StatusTooManyRequests, rr.Code)
+	}
+	// claiming another address doesn't get around it without a trusted proxy
+	for _, header := range []string{"X-Forwarded-For", "X-Real-IP", "True-Client-IP"} {
+		req := httptest.NewRequest("POST", "/forms/"+created.Key, strings.NewReader("email=f%40example.com"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(header, "198.51.100.9")
+		req.RemoteAddr = "203.0.113.7:5000"
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusTooManyRequests {
+			t.Errorf("expected %v with a forged %s, got %v", http.StatusTooManyRequests, header, rr.Code)
+		}
+	}
+
+	if rr := serveJSON(t, srv, "POST", "/forms/missing", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for a missing form, got %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.1/32")}
+	var got string
+	handler := realIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = clientIP(r)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted proxy", "203.0.113.7:5000", map[string]string{"X-Forwarded-For": "198.51.100.9", "X-Real-IP": "198.51.100.9"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.5:5000", map[string]string{"X-Forwarded-For": "198.51.100.9"}, "198.51.100.9"},
+		// a client can prepend anything, the hop our proxy saw is what counts
+		{"forged hops", "10.0.0.5:5000", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.9, 192.0.2.1"}, "198.51.100.9"},
+		{"real ip", "192.0.2.1:5000", map[string]string{"X-Real-IP": "198.51.100.9"}, "198.51.100.9"},
+		{"garbage", "10.0.0.5:5000", map[string]string{"X-Forwarded-For": "nonsense"}, "10.0.0.5"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/health", nil)
+		req.RemoteAddr = test.remoteAddr
+		for name, value := range test.headers {
+			req.Header.Set(name, value)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if got != test.expected {
+			t.Errorf("%s: expected client %s, got %s", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	srv, _ := setupTestServer()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		return serveJSON(t, srv, method, path, body)
+	}
+
+	if rr := serve("POST", "/api/v1/users", domain.CreateUserRequest{Name: "Ada Lovelace", Email: "ada@example.com"}); rr.Code != http.StatusCreated {
+		t.Fatalf("create user returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("POST", "/api/v1/email-templates", domain.CreateEmailTemplateRequest{Name: "Intro", Subject: "Hi {{.Nope}}", BodyText: "Hello"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for an unknown merge field, got %v", http.StatusBadRequest, rr.Code)
+	}
+	rr := serve("POST", "/api/v1/email-templates", domain.CreateEmailTemplateRequest{
+		Name:     "Intro",
+		Subject:  "Hi {{.FirstName}}",
+		BodyHTML: `<p>See <a href="https://agency.example/pricing">pricing</a></p>`,
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create email template returned %v: %s", rr.Code, rr.Body.String())
+	}
+	var template struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&template); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := serve("POST", "/api/v1/users/99/emails", domain.SendEmailRequest{TemplateID: &template.ID}); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for a missing user, got %v", http.StatusNotFound, rr.Code)
+	}
+	if rr := serve("POST", "/api/v1/users/1/emails", domain.SendEmailRequest{TemplateID: &template.ID}); rr.Code != http.StatusCreated {
+		t.Fatalf("send email returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serve("GET", "/api/v1/users/1/emails", nil)
+	var emails []domain.Email
+	if err := json.NewDecoder(rr.Body).Decode(&emails); err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 1 || emails[0].Subject != "Hi Ada" {
+		t.Fatalf("unexpected emails: %+v", emails)
+	}
+	_, pixel, found := strings.Cut(emails[0].BodyHTML, `src="http://localhost:8080`)
+	if !found {
+		t.Fatalf("expected a tracking pixel in %s", emails[0].BodyHTML)
+	}
+	pixel, _, _ = strings.Cut(pixel, `"`)
+	token := strings.TrimPrefix(pixel, "/t/o/")
+
+	rr = serve("GET", pixel, nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/gif" {
+		t.Errorf("expected the tracking pixel, got %v %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if rr := serve("GET", "/t/o/unknown", nil); rr.Code != http.StatusOK {
+		t.Errorf("expected the pixel for an unknown token, got %v", rr.Code)
+	}
+
+	rr = serve("GET", "/t/c/"+token+"/0", nil)
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "https://agency.example/pricing" {
+		t.Errorf("expected a redirect to the original link, got %v %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if rr := serve("GET", "/t/c/"+token+"/5", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for an unknown link, got %v", http.StatusNotFound, rr.Code)
+	}
+
+	rr = serve("GET", "/api/v1/activities?entity_id=1", nil)
+	var activities []domain.Activity
+	if err := json.NewDecoder(rr.Body).Decode(&activities); err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 || activities[0].Type != domain.ActivityEmailSent {
+		t.Errorf("expected the email on the timeline, got %+v", activities)
+	}
+
+	if rr := serve("DELETE", fmt.Sprintf("/api/v1/email-templates/%d", template.ID), nil); rr.Code != http.StatusNoContent {
+		t.Errorf("delete email template returned %v", rr.Code)
+	}
+}
+
+func TestInboundEmail(t *testing.T) {
+	srv, _ := setupTestServer()
+
+	if rr := serveJSON(t, srv, "POST", "/api/v1/users", domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"}); rr.Code != http.StatusCreated {
+		t.Fatalf("create user returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	receive := func(raw string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/emails/inbound", strings.NewReader(raw))
+		req.Header.Set("Content-Type", "message/rfc822")
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+	raw := "From: ada@example.com\r\nTo: sales@agency.example\r\nSubject: Brief\r\nMessage-ID: <brief@example.com>\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee attached\r\n" +
+		"--b\r\nContent-Type: text/html\r\nContent-Disposition: attachment; filename=\"brief.html\"\r\n\r\n<script>alert(1)</script>\r\n" +
+		"--b--\r\n"
+
+	rr := receive(raw)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("receive email returned %v: %s", rr.Code, rr.Body.String())
+	}
+	var logged struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&logged); err != nil {
+		t.Fatal(err)
+	}
+	if rr := receive(raw); rr.Code != http.StatusOK {
+		t.Errorf("expected a duplicate to be ignored, got %v", rr.Code)
+	}
+	if rr := receive("nonsense"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for an unreadable message, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	rr = serveJSON(t, srv, "GET", fmt.Sprintf("/api/v1/emails/%d/attachments", logged.ID), nil)
+	var attachments []domain.EmailAttachment
+	if err := json.NewDecoder(rr.Body).Decode(&attachments); err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 1 || attachments[0].Filename != "brief.html" {
+		t.Fatalf("unexpected attachments: %+v", attachments)
+	}
+
+	rr = serveJSON(t, srv, "GET", fmt.Sprintf("/api/v1/emails/%d/attachments/%d", logged.ID, attachments[0].ID), nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("expected a download, got %v %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rr.Header().Get("Content-Disposition"), `filename=brief.html`) {
+		t.Errorf("unexpected Content-Disposition %q", rr.Header().Get("Content-Disposition"))
+	}
+	if rr := serveJSON(t, srv, "GET", "/api/v1/emails/99/attachments", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for a missing email, got %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestSequences(t *testing.T) {
+	srv, _ := setupTestServer()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		return serveJSON(t, srv, method, path, body)
+	}
+
+	if rr := serve("POST", "/api/v1/users", domain.CreateUserRequest{Name: "Ada Lovelace", Email: "ada@example.com"}); rr.Code != http.StatusCreated {
+		t.Fatalf("create user returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("POST", "/api/v1/email-templates", domain.CreateEmailTemplateRequest{Name: "Intro", Subject: "Hi", BodyText: "Hello"}); rr.Code != http.StatusCreated {
+		t.Fatalf("create email template returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := serve("POST", "/api/v1/sequences", domain.CreateSequenceRequest{
+		Name:  "Outreach",
+		Steps: []domain.SequenceStep{{Type: domain.StepEmail, TemplateID: 99}},
+	}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for a missing template, got %v", http.StatusBadRequest, rr.Code)
+	}
+	rr := serve("POST", "/api/v1/sequences", domain.CreateSequenceRequest{
+		Name:  "Outreach",
+		Steps: []domain.SequenceStep{{Type: domain.StepEmail, TemplateID: 1}, {Type: domain.StepWait, Days: 3}},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create sequence returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serve("POST", "/api/v1/sequences/1/enrollments", domain.EnrollmentRequest{IDs: []int{1}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"enrolled":1`) {
+		t.Fatalf("enroll returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("POST", "/api/v1/sequences/9/enrollments", domain.EnrollmentRequest{IDs: []int{1}}); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for a missing sequence, got %v", http.StatusNotFound, rr.Code)
+	}
+
+	rr = serve("GET", "/api/v1/sequences/1/enrollments", nil)
+	var enrollments []domain.SequenceEnrollment
+	if err := json.NewDecoder(rr.Body).Decode(&enrollments); err != nil {
+		t.Fatal(err)
+	}
+	if len(enrollments) != 1 || enrollments[0].Status != domain.EnrollmentActive {
+		t.Fatalf("unexpected enrollments: %+v", enrollments)
+	}
+
+	rr = serve("POST", "/api/v1/sequences/1/unenroll", domain.EnrollmentRequest{IDs: []int{1}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"removed":1`) {
+		t.Fatalf("unenroll returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serve("GET", "/api/v1/sequences/1", nil)
+	var sequence domain.Sequence
+	if err := json.NewDecoder(rr.Body).Decode(&sequence); err != nil {
+		t.Fatal(err)
+	}
+	if sequence.Stats == nil || sequence.Stats.Enrolled != 1 || sequence.Stats.Removed != 1 {
+		t.Errorf("unexpected sequence stats: %+v", sequence.Stats)
+	}
+
+	if rr := serve("DELETE", "/api/v1/sequences/1", nil); rr.Code != http.StatusNoContent {
+		t.Errorf("delete sequence returned %v", rr.Code)
+	}
+	if rr := serve("GET", "/api/v1/sequences/1", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v after delete, got %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	srv, _ := setupTestServer()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		return serveJSON(t, srv, method, path, body)
+	}
+
+	if rr := serve("POST", "/api/v1/users", domain.CreateUserRequest{Name: "Ada Lovelace", Email: "ada@example.com"}); rr.Code != http.StatusCreated {
+		t.Fatalf("create user returned %v: %s", rr.Code, rr.Body.String())
+	}
+	link := srv.service.UnsubscribeURL(domain.EntityUser, 1)
+	path := link[strings.Index(link, service.UnsubscribePath):]
+
+	// opening the link only asks for confirmation
+	if rr := serve("GET", path, nil); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "<form") {
+		t.Fatalf("unsubscribe page returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("GET", "/api/v1/suppressions", nil); strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Fatalf("expected no suppressions after GET, got %s", rr.Body.String())
+	}
+
+	// RFC 8058 one-click POST
+	req := httptest.NewRequest("POST", path, strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unsubscribe returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("POST", path+"x", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for a tampered link, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	rr = serve("GET", "/api/v1/consents?entity_id=1", nil)
+	var consents domain.ConsentSummary
+	if err := json.NewDecoder(rr.Body).Decode(&consents); err != nil {
+		t.Fatal(err)
+	}
+	if len(consents.Current) != 1 || consents.Current[0].Status != domain.ConsentWithdrawn {
+		t.Fatalf("unexpected consents: %+v", consents)
+	}
+
+	if rr := serve("POST", "/api/v1/users/1/emails", domain.SendEmailRequest{Subject: "Hi", BodyText: "Hello"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v emailing a suppressed address, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	if rr := serve("POST", "/api/v1/consents", domain.ConsentRequest{
+		EntityID: 1, Channel: domain.ChannelEmail, Status: domain.ConsentGranted, LawfulBasis: domain.BasisConsent, Source: "web_form",
+	}); rr.Code != http.StatusCreated {
+		t.Fatalf("record consent returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("POST", "/api/v1/consents", domain.ConsentRequest{
+		EntityID: 99, Channel: domain.ChannelEmail, Status: domain.ConsentGranted, LawfulBasis: domain.BasisConsent, Source: "web_form",
+	}); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for a missing record, got %v", http.StatusNotFound, rr.Code)
+	}
+
+	rr = serve("POST", "/api/v1/suppressions", domain.CreateSuppressionRequest{Email: "spam-trap@example.net"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create suppression returned %v: %s", rr.Code, rr.Body.String())
+	}
+	var suppression domain.EmailSuppression
+	if err := json.NewDecoder(rr.Body).Decode(&suppression); err != nil {
+		t.Fatal(err)
+	}
+	if rr := serve("DELETE", fmt.Sprintf("/api/v1/suppressions/%d", suppression.ID), nil); rr.Code != http.StatusNoContent {
+		t.Errorf("delete suppression returned %v", rr.Code)
+	}
+	if rr := serve("DELETE", fmt.Sprintf("/api/v1/suppressions/%d", suppression.ID), nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v deleting twice, got %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestPrivacy(t *testing.T) {
+	srv, _ := setupTestServer()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		return serveJSON(t, srv, method, path, body)
+	}
+
+	if rr := serve("POST", "/api/v1/users", domain.CreateUserRequest{Name: "Ada Lovelace", Email: "ada@example.com"}); rr.Code != http.StatusCreated {
+		t.Fatalf("create user returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := serve("POST", "/api/v1/privacy/requests", domain.PrivacyRequestInput{Type: domain.PrivacyExport, EntityID: 1})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create privacy request returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("POST", "/api/v1/privacy/requests", domain.PrivacyRequestInput{Type: "forget", EntityID: 1}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for an unknown type, got %v", http.StatusBadRequest, rr.Code)
+	}
+	if rr := serve("GET", "/api/v1/privacy/requests?status=pending", nil); !strings.Contains(rr.Body.String(), `"status":"pending"`) {
+		t.Errorf("expected the pending request to be listed, got %s", rr.Body.String())
+	}
+
+	requestID := 1
+	rr = serve("POST", "/api/v1/privacy/export", domain.PrivacySubject{EntityID: 1, RequestID: &requestID})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("export returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("expected a zip, got %q", ct)
+	}
+	if got := rr.Header().Get("Content-Disposition"); got != `attachment; filename="personal-data-user-1.zip"` {
+		t.Errorf("unexpected Content-Disposition %q", got)
+	}
+	if got := rr.Header().Get("X-Privacy-Request-ID"); got != "1" {
+		t.Errorf("expected the export to fulfil request 1, got %q", got)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("export is not a zip: %v", err)
+	}
+	if len(archive.File) == 0 || archive.File[0].Name != "record.json" {
+		t.Errorf("expected record.json first in the export")
+	}
+
+	rr = serve("GET", "/api/v1/privacy/requests/1", nil)
+	var request domain.PrivacyRequest
+	if err := json.NewDecoder(rr.Body).Decode(&request); err != nil {
+		t.Fatal(err)
+	}
+	if request.Status != domain.PrivacyCompleted {
+		t.Errorf("expected request 1 to be completed, got %q", request.Status)
+	}
+	if rr := serve("GET", "/api/v1/privacy/requests/99", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for a missing request, got %v", http.StatusNotFound, rr.Code)
+	}
+
+	if rr := serve("POST", "/api/v1/privacy/erase", domain.PrivacySubject{EntityID: 99}); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v erasing a missing record, got %v", http.StatusNotFound, rr.Code)
+	}
+	if rr := serve("POST", "/api/v1/privacy/erase", domain.PrivacySubject{EntityID: 1}); rr.Code != http.StatusOK {
+		t.Fatalf("erase returned %v: %s", rr.Code, rr.Body.String())
+	}
+	rr = serve("GET", "/api/v1/users/1", nil)
+	if strings.Contains(rr.Body.String(), "ada@example.com") {
+		t.Errorf("expected the address to be erased, got %s", rr.Body.String())
+	}
+}
+
+func TestAudit(t *testing.T) {
+	srv, _ := setupTestServer()
+
+	req := httptest.NewRequest("POST", "/api/v1/users", strings.NewReader(`{"name":"Ada Lovelace","email":"ada@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Actor", "jane@agency.example")
+	req.RemoteAddr = "203.0.113.7:5000"
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create user returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serveJSON(t, srv, "POST", "/api/v1/tags", domain.CreateTagRequest{Name: "vip"}); rr.Code != http.StatusCreated {
+		t.Fatalf("create tag returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serveJSON(t, srv, "GET", "/api/v1/audit?entity=user&entity_id=1", nil)
+	var events []domain.AuditEvent
+	if err := json.NewDecoder(rr.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event for the user, got %d", len(events))
+	}
+	if events[0].Actor != "jane@agency.example" || events[0].IP != "203.0.113.7" || events[0].RequestID == "" {
+		t.Errorf("expected the actor, IP and request ID to be recorded, got %+v", events[0])
+	}
+	if events[0].Action != domain.AuditCreate || events[0].Changes["name"].To != "Ada Lovelace" {
+		t.Errorf("expected the create to be diffed, got %+v", events[0])
+	}
+
+	rr = serveJSON(t, srv, "GET", "/api/v1/audit?actor=api", nil)
+	if err := json.NewDecoder(rr.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Entity != domain.AuditTag {
+		t.Errorf("expected changes without X-Actor to be the api's, got %+v", events)
+	}
+
+	for _, path := range []string{"/api/v1/audit?since=yesterday", "/api/v1/audit?limit=5000", "/api/v1/audit/export?format=xml"} {
+		if rr := serveJSON(t, srv, "GET", path, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected %v for %s, got %v", http.StatusBadRequest, path, rr.Code)
+		}
+	}
+
+	rr = serveJSON(t, srv, "GET", "/api/v1/audit/export", nil)
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected NDJSON, got %q", ct)
+	}
+	if lines := strings.Count(rr.Body.String(), "\n"); lines != 2 {
+		t.Errorf("expected 2 exported events, got %d lines", lines)
+	}
+	rr = serveJSON(t, srv, "GET", "/api/v1/audit/export?format=csv&entity=tag", nil)
+	if !strings.HasPrefix(rr.Body.String(), "id,created_at,actor") || strings.Count(rr.Body.String(), "\n") != 2 {
+		t.Errorf("expected a CSV header and the tag's event, got %s", rr.Body.String())
+	}
+
+	rr = serveJSON(t, srv, "GET", "/api/v1/audit/verify", nil)
+	var result domain.AuditVerification
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Checked != 2 {
+		t.Errorf("expected the 2 events to verify, got %+v", result)
+	}
+}
+
+func TestFieldHistory(t *testing.T) {
+	srv, _ := setupTestServer()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		return serveJSON(t, srv, method, path, body)
+	}
+
+	if rr := serve("POST", "/api/v1/users", domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"}); rr.Code != http.StatusCreated {
+		t.Fatalf("create user returned %v: %s", rr.Code, rr.Body.String())
+	}
+	created := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	name := "Ada Lovelace"
+	if rr := serve("PATCH", "/api/v1/users/1", domain.UpdateUserRequest{Name: &name}); rr.Code != http.StatusNoContent {
+		t.Fatalf("update user returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := serve("GET", "/api/v1/users/1/history", nil)
+	var changes []domain.FieldChange
+	if err := json.NewDecoder(rr.Body).Decode(&changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Field != "name" || changes[0].From != "Ada" || changes[0].Actor != "api" {
+		t.Errorf("expected the rename in the history, got %+v", changes)
+	}
+	if rr := serve("GET", "/api/v1/users/99/history", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for a missing user, got %v", http.StatusNotFound, rr.Code)
+	}
+
+	rr = serve("GET", "/api/v1/users/1?as_of="+url.QueryEscape(created.Format(time.RFC3339Nano)), nil)
+	var user domain.UserResponse
+	if err := json.NewDecoder(rr.Body).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Ada" {
+		t.Errorf("expected the name before the rename, got %q", user.Name)
+	}
+	if rr := serve("GET", "/api/v1/users/1?as_of=last-tuesday", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for a bad as_of, got %v", http.StatusBadRequest, rr.Code)
+	}
+	if rr := serve("GET", "/api/v1/users/1?as_of=2000-01-01T00:00:00Z", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v before the user existed, got %v", http.StatusNotFound, rr.Code)
+	}
+
+	rr = serve("POST", "/api/v1/custom-fields", domain.CreateCustomFieldRequest{Entity: "user", Key: "budget", Label: "Budget", Type: domain.CustomFieldNumber})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create custom field returned %v: %s", rr.Code, rr.Body.String())
+	}
+	track := true
+	if rr := serve("PATCH", "/api/v1/custom-fields/1", domain.UpdateCustomFieldRequest{TrackHistory: &track}); rr.Code != http.StatusNoContent {
+		t.Fatalf("update custom field returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("GET", "/api/v1/custom-fields?entity=user", nil); !strings.Contains(rr.Body.String(), `"track_history":true`) {
+		t.Errorf("expected the field to be tracked, got %s", rr.Body.String())
+	}
+	if rr := serve("PATCH", "/api/v1/custom-fields/99", domain.UpdateCustomFieldRequest{TrackHistory: &track}); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for a missing field, got %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	srv, _ := setupTestServer()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		return serveJSON(t, srv, method, path, body)
+	}
+
+	if rr := serve("POST", "/api/v1/users", domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"}); rr.Code != http.StatusCreated {
+		t.Fatalf("create user returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("DELETE", "/api/v1/users/1", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("delete user returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("GET", "/api/v1/users/1", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v for a trashed user, got %v", http.StatusNotFound, rr.Code)
+	}
+	if rr := serve("DELETE", "/api/v1/users/1", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v deleting a trashed user again, got %v", http.StatusNotFound, rr.Code)
+	}
+
+	rr := serve("GET", "/api/v1/trash?entity=user", nil)
+	var items []domain.TrashItem
+	if err := json.NewDecoder(rr.Body).Decode(&items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Name != "Ada" || items[0].DeletedBy != "api" {
+		t.Errorf("expected the user in the trash, got %+v", items)
+	}
+	if rr := serve("GET", "/api/v1/trash?entity=deal", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for an unknown entity, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	if rr := serve("POST", "/api/v1/trash/user/1/restore", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("restore returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("GET", "/api/v1/users/1", nil); rr.Code != http.StatusOK {
+		t.Errorf("expected the restored user, got %v", rr.Code)
+	}
+	if rr := serve("POST", "/api/v1/trash/user/1/restore", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v restoring a live user, got %v", http.StatusNotFound, rr.Code)
+	}
+	if rr := serve("DELETE", "/api/v1/trash/user/1", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %v purging a live user, got %v", http.StatusNotFound, rr.Code)
+	}
+
+	// a new user can take a trashed user's email, which keeps the trashed
+	// one from being restored
+	if rr := serve("DELETE", "/api/v1/users/1", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("delete user returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("POST", "/api/v1/users", domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"}); rr.Code != http.StatusCreated {
+		t.Fatalf("expected the trashed user's email to be free, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("POST", "/api/v1/users", domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for a live user's email, got %v", http.StatusBadRequest, rr.Code)
+	}
+	if rr := serve("POST", "/api/v1/trash/user/1/restore", nil); rr.Code != http.StatusConflict {
+		t.Errorf("expected %v restoring a user whose email was taken, got %v", http.StatusConflict, rr.Code)
+	}
+
+	if rr := serve("DELETE", "/api/v1/trash/user/1", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("purge returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("GET", "/api/v1/trash", nil); strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("expected an empty trash, got %s", rr.Body.String())
+	}
+}
+
+func TestVersions(t *testing.T) {
+	srv, _ := setupTestServer()
+	serve := func(method, path string, body any, header http.Header) *httptest.ResponseRecorder {
+		return serveJSONWithHeader(t, srv, method, path, body, header)
+	}
+	ifMatch := func(value string) http.Header {
+		return http.Header{"If-Match": {value}}
+	}
+
+	if rr := serve("POST", "/api/v1/users", domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"}, nil); rr.Code != http.StatusCreated {
+		t.Fatalf("create user returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := serve("GET", "/api/v1/users/1", nil, nil)
+	if etag := rr.Header().Get("ETag"); etag != `"1"` {
+		t.Fatalf(`expected ETag "1", got %q`, etag)
+	}
+	var user domain.UserResponse
+	if err := json.NewDecoder(rr.Body).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Version != 1 {
+		t.Errorf("expected version 1 in the body, got %d", user.Version)
+	}
+	if rr := serve("GET", "/api/v1/users/1", nil, http.Header{"If-None-Match": {`"7", W/"1"`}}); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("expected an empty %v, got %v: %s", http.StatusNotModified, rr.Code, rr.Body.String())
+	}
+	if rr := serve("GET", "/api/v1/users/1", nil, http.Header{"If-None-Match": {`"7"`}}); rr.Code != http.StatusOK {
+		t.Errorf("expected %v for another version, got %v", http.StatusOK, rr.Code)
+	}
+
+	name := "Ada Lovelace"
+	update := domain.UpdateUserRequest{Name: &name}
+	if rr := serve("PATCH", "/api/v1/users/1", update, nil); rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("expected %v without If-Match, got %v", http.StatusPreconditionRequired, rr.Code)
+	}
+	if rr := serve("PATCH", "/api/v1/users/1", update, ifMatch("1")); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected %v for an unquoted version, got %v", http.StatusPreconditionFailed, rr.Code)
+	}
+	if rr := serve("PATCH", "/api/v1/users/1", update, ifMatch(`"1"`)); rr.Code != http.StatusNoContent {
+		t.Fatalf("update user returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("GET", "/api/v1/users/1", nil, nil); rr.Header().Get("ETag") != `"2"` {
+		t.Errorf(`expected ETag "2" after the update, got %q`, rr.Header().Get("ETag"))
+	}
+
+	// a second writer still holding version 1 is turned away
+	if rr := serve("PATCH", "/api/v1/users/1", update, ifMatch(`"1"`)); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected %v for a stale update, got %v", http.StatusPreconditionFailed, rr.Code)
+	}
+	if rr := serve("DELETE", "/api/v1/users/1", nil, ifMatch(`"1"`)); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected %v for a stale delete, got %v", http.StatusPreconditionFailed, rr.Code)
+	}
+	if rr := serve("DELETE", "/api/v1/users/1", nil, nil); rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("expected %v deleting without If-Match, got %v", http.StatusPreconditionRequired, rr.Code)
+	}
+	if rr := serve("DELETE", "/api/v1/users/1", nil, ifMatch(`"2"`)); rr.Code != http.StatusNoContent {
+		t.Errorf("delete user returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := serve("POST", "/api/v1/segments", domain.CreateSegmentRequest{Name: "VIPs", Entity: domain.EntityUser, Expression: "tag:vip"}, nil); rr.Code != http.StatusCreated {
+		t.Fatalf("create segment returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("GET", "/api/v1/segments/1", nil, http.Header{"If-None-Match": {"*"}}); rr.Code != http.StatusNotModified {
+		t.Errorf("expected %v for If-None-Match: *, got %v", http.StatusNotModified, rr.Code)
+	}
+	if rr := serve("DELETE", "/api/v1/segments/1", nil, ifMatch("*")); rr.Code != http.StatusNoContent {
+		t.Errorf("expected If-Match: * to delete any version, got %v", rr.Code)
+	}
+}
+
+func TestVersionedDeletes(t *testing.T) {
+	srv, _ := setupTestServer()
+	serve := func(method, path string, body any, header http.Header) *httptest.ResponseRecorder {
+		return serveJSONWithHeader(t, srv, method, path, body, header)
+	}
+
+	if rr := serve("POST", "/api/v1/users", domain.CreateUserRequest{Name: "Ann", Email: "ann@example.com"}, nil); rr.Code != http.StatusCreated {
+		t.Fatalf("create user returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	// every record that is deleted with If-Match hands out its ETag
+	resources := []struct {
+		path string
+		body any
+	}{
+		{"/api/v1/custom-fields", domain.CreateCustomFieldRequest{Entity: "user", Key: "budget", Label: "Budget", Type: domain.CustomFieldNumber}},
+		{"/api/v1/scoring-rules", domain.CreateScoringRuleRequest{Name: "Webinar", Type: domain.ScoringRuleCondition, Expression: "tag:webinar", Points: 25}},
+		{"/api/v1/assignment-rules", domain.CreateAssignmentRuleRequest{Name: "Inbound", Strategy: domain.AssignRoundRobin, Members: []domain.AssignmentMember{{UserID: 1}}}},
+		{"/api/v1/automations", domain.CreateAutomationRequest{
+			Name:    "Tag renamed",
+			Trigger: domain.AutomationTrigger{Type: domain.TriggerFieldChanged, Field: "name"},
+			Actions: []domain.AutomationAction{{Type: domain.ActionAddTag, Tag: "renamed"}},
+		}},
+		{"/api/v1/webhooks", domain.CreateWebhookRequest{URL: "https://hooks.example.com/crm", Events: []string{domain.EventUserCreated}}},
+		{"/api/v1/forms", domain.CreateFormRequest{Name: "Contact us", FieldMappings: map[string]string{"email": "email"}}},
+		{"/api/v1/email-templates", domain.CreateEmailTemplateRequest{Name: "Intro", Subject: "Hi", BodyText: "Hello"}},
+	}
+	for _, resource := range resources {
+		rr := serve("POST", resource.path, resource.body, nil)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("create %s returned %v: %s", resource.path, rr.Code, rr.Body.String())
+		}
+		var created struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+		path := fmt.Sprintf("%s/%d", resource.path, created.ID)
+
+		rr = serve("GET", path, nil, nil)
+		etag := rr.Header().Get("ETag")
+		if rr.Code != http.StatusOK || etag != `"1"` {
+			t.Fatalf(`expected %s with ETag "1", got %v %q: %s`, path, rr.Code, etag, rr.Body.String())
+		}
+		var record struct {
+			Version int    `json:"version"`
+			Secret  string `json:"secret"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Version != 1 || record.Secret != "" {
+			t.Errorf("expected version 1 and no secret from %s, got %+v", path, record)
+		}
+		if rr := serve("DELETE", path, nil, http.Header{"If-Match": {`"2"`}}); rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("expected %v deleting %s at a stale version, got %v", http.StatusPreconditionFailed, path, rr.Code)
+		}
+		if rr := serve("DELETE", path, nil, http.Header{"If-Match": {etag}}); rr.Code != http.StatusNoContent {
+			t.Errorf("delete %s with its ETag returned %v: %s", path, rr.Code, rr.Body.String())
+		}
+		if rr := serve("GET", path, nil, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected %v for trashed %s, got %v", http.StatusNotFound, path, rr.Code)
+		}
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	srv, _ := setupTestServer()
+	serve := func(key string, body any) *httptest.ResponseRecorder {
+		return serveJSONWithHeader(t, srv, "POST", "/api/v1/users", body, http.Header{"Idempotency-Key": {key}})
+	}
+	ada := domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"}
+
+	first := serve("create-ada", ada)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("create user returned %v: %s", first.Code, first.Body.String())
+	}
+	retry := serve("create-ada", ada)
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Errorf("expected the retry to get the first response, got %v: %s", retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected replay headers, got %v", retry.Header())
+	}
+
+	rr := serveJSON(t, srv, "GET", "/api/v1/users", nil)
+	var users []domain.UserResponse
+	if err := json.NewDecoder(rr.Body).Decode(&users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Errorf("expected the retry not to create another user, got %d users", len(users))
+	}
+
+	if rr := serve("create-ada", domain.CreateUserRequest{Name: "Grace", Email: "grace@example.com"}); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected %v reusing a key for another body, got %v", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	// validation failures are replayed too, the same request fails the same way
+	if rr := serve("invalid", domain.CreateUserRequest{Name: "Ada"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for a missing email, got %v", http.StatusBadRequest, rr.Code)
+	}
+	if rr := serve("invalid", domain.CreateUserRequest{Name: "Ada"}); rr.Code != http.StatusBadRequest || rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected a replayed %v, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	// a replayed update keeps the version it handed out
+	name := "Ada Lovelace"
+	update := func() *httptest.ResponseRecorder {
+		return serveJSONWithHeader(t, srv, "PATCH", "/api/v1/users/1", domain.UpdateUserRequest{Name: &name}, http.Header{"Idempotency-Key": {"rename-ada"}, "If-Match": {`"1"`}})
+	}
+	updated := update()
+	if updated.Code != http.StatusNoContent || updated.Header().Get("ETag") != `"2"` {
+		t.Fatalf("expected the update to hand out ETag \"2\", got %v %q", updated.Code, updated.Header().Get("ETag"))
+	}
+	if rr := update(); rr.Header().Get("Idempotent-Replayed") != "true" || rr.Header().Get("ETag") != `"2"` {
+		t.Errorf("expected the replayed update to keep ETag \"2\", got %v", rr.Header())
+	}
+}
+
+func TestBulkUsers(t *testing.T) {
+	srv, _ := setupTestServer()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		return serveJSON(t, srv, method, path, body)
+	}
+	name, email := "Ada", "ada@example.com"
+
+	rr := serve("POST", "/api/v1/users/bulk", domain.BulkRequest{Operations: []domain.BulkOperation{
+		{Op: domain.BulkCreate, Data: domain.UpdateUserRequest{Name: &name, Email: &email}},
+		{Op: domain.BulkUpdate, ID: 99, Data: domain.UpdateUserRequest{Name: &name}},
+		{Op: domain.BulkCreate, Data: domain.UpdateUserRequest{Name: &name}},
+	}})
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("bulk returned %v: %s", rr.Code, rr.Body.String())
+	}
+	var response domain.BulkResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Succeeded != 1 || response.Failed != 2 || len(response.Results) != 3 {
+		t.Fatalf("unexpected bulk response: %+v", response)
+	}
+	statuses := []int{response.Results[0].Status, response.Results[1].Status, response.Results[2].Status}
+	if statuses[0] != http.StatusCreated || statuses[1] != http.StatusNotFound || statuses[2] != http.StatusBadRequest {
+		t.Errorf("unexpected statuses %v", statuses)
+	}
+	if response.Results[0].ID != 1 || response.Results[2].Index != 2 || response.Results[2].Error == "" {
+		t.Errorf("unexpected results %+v", response.Results)
+	}
+
+	rr = serve("POST", "/api/v1/users/bulk", domain.BulkRequest{Atomic: true, Operations: []domain.BulkOperation{
+		{Op: domain.BulkDelete, ID: 1},
+		{Op: domain.BulkDelete, ID: 99},
+	}})
+	response = domain.BulkResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Failed != 2 || response.Results[0].Status != http.StatusFailedDependency {
+		t.Errorf("expected the atomic batch to be rolled back, got %+v", response)
+	}
+	if rr := serve("GET", "/api/v1/users/1", nil); rr.Code != http.StatusOK {
+		t.Errorf("expected the user to survive the rolled back delete, got %v", rr.Code)
+	}
+
+	if rr := serve("POST", "/api/v1/users/bulk", domain.BulkRequest{}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v without operations, got %v", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+	var replicaReads bool
+	handler := readYourWrites(10 * time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replicaReads = repository.ReplicaReadsAllowed(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(method string, mark func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/users", nil)
+		if mark != nil {
+			mark(req)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serve(http.MethodGet, nil); !replicaReads || rr.Header().Get(lastWriteHeader) != "" {
+		t.Error("Expected a read without a write to go to replicas, unmarked")
+	}
+
+	rr := serve(http.MethodPost, nil)
+	mark := rr.Header().Get(lastWriteHeader)
+	cookies := rr.Result().Cookies()
+	if mark == "" || len(cookies) != 1 || cookies[0].Name != lastWriteCookie || cookies[0].Value != mark {
+		t.Fatalf("Expected the write to be marked in a header and a cookie, got %q %v", mark, cookies)
+	}
+
+	// the client's mark keeps its reads on the primary, whichever instance
+	// or address they come from
+	serve(http.MethodGet, func(r *http.Request) { r.Header.Set(lastWriteHeader, mark) })
+	if replicaReads {
+		t.Error("Expected a read sending the header to read from the primary")
+	}
+	serve(http.MethodGet, func(r *http.Request) { r.AddCookie(cookies[0]) })
+	if replicaReads {
+		t.Error("Expected a read sending the cookie to read from the primary")
+	}
+
+	now := time.Now()
+	for name, at := range map[string]time.Time{
+		"expired":   now.Add(-11 * time.Second),
+		"in future": now.Add(time.Hour),
+	} {
+		serve(http.MethodGet, func(r *http.Request) { r.Header.Set(lastWriteHeader, strconv.FormatInt(at.UnixMilli(), 10)) })
+		if !replicaReads {
+			t.Errorf("Expected a read with an %s mark to go to replicas", name)
+		}
+	}
+	serve(http.MethodGet, func(r *http.Request) { r.Header.Set(lastWriteHeader, "soon") })
+	if !replicaReads {
+		t.Error("Expected a read with a malformed mark to go to replicas")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	srv, _ := setupTestServer()
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	srv.service.StartChangeFeed(ctx)
+
+	rr := serveJSON(t, srv, http.MethodGet, "/api/v1/events?entity=spaceship", nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unsupported entity, got %d", rr.Code)
+	}
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/v1/events?entity=user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+	// the stream is open once the retry hint arrives
+	lines := bufio.NewScanner(resp.Body)
+	if !lines.Scan() || lines.Text() != "retry: 3000" {
+		t.Fatalf("Expected the retry hint first, got %q", lines.Text())
+	}
+
+	rr = serveJSON(t, srv, http.MethodPost, "/api/v1/users", domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	received := make(chan domain.ChangeEvent, 1)
+	go func() {
+		for lines.Scan() {
+			if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+				var change domain.ChangeEvent
+				if err := json.Unmarshal([]byte(data), &change); err == nil {
+					received <- change
+				}
+				return
+			}
+		}
+	}()
+	select {
+	case change := <-received:
+		if change.Entity != domain.EntityUser || change.ID != 1 || change.Op != domain.ChangeCreated {
+			t.Errorf("Expected user 1 to be reported created, got %+v", change)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the change event")
+	}
+}
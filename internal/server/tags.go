@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// lists the tag registry
+func (s *Server) getTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := s.service.GetTags(r.Context())
+	if err != nil {
+		log.Printf("Error getting tags: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get tags")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, tags)
+}
+
+// registers a tag
+func (s *Server) createTag(w http.ResponseWriter, r *http.Request) {
+	var req domain.CreateTagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	id, err := s.service.CreateTag(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating tag: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create tag")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]int{"id": id})
+}
+
+// tags many records at once
+func (s *Server) addTags(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBulkTagRequest(w, r)
+	if !ok {
+		return
+	}
+
+	added, err := s.service.AddTags(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error adding tags: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to add tags")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]int{"added": added})
+}
+
+// untags many records at once
+func (s *Server) removeTags(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBulkTagRequest(w, r)
+	if !ok {
+		return
+	}
+
+	removed, err := s.service.RemoveTags(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error removing tags: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to remove tags")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]int{"removed": removed})
+}
+
+// decodeBulkTagRequest reads a bulk tag body, defaulting the entity to user
+func decodeBulkTagRequest(w http.ResponseWriter, r *http.Request) (domain.BulkTagRequest, bool) {
+	var req domain.BulkTagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return req, false
+	}
+	if req.Entity == "" {
+		req.Entity = domain.EntityUser
+	}
+	return req, true
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// lists deleted records that can still be restored, optionally of one entity
+func (s *Server) getTrash(w http.ResponseWriter, r *http.Request) {
+	items, err := s.service.GetTrash(r.Context(), r.URL.Query().Get("entity"))
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting trash: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get trash")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, items)
+}
+
+// takes a record back out of the trash
+func (s *Server) restoreFromTrash(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid record ID")
+		return
+	}
+
+	err = s.service.RestoreFromTrash(r.Context(), chi.URLParam(r, "entity"), id)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Record not found in the trash")
+		return
+	}
+	if errors.Is(err, repository.ErrDuplicate) {
+		respondError(w, http.StatusConflict, "Another record has taken this record's email or key")
+		return
+	}
+	if err != nil {
+		log.Printf("Error restoring from trash: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to restore record")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deletes a trashed record for good
+func (s *Server) purgeFromTrash(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid record ID")
+		return
+	}
+
+	err = s.service.PurgeFromTrash(r.Context(), chi.URLParam(r, "entity"), id)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Record not found in the trash")
+		return
+	}
+	if err != nil {
+		log.Printf("Error purging from trash: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to purge record")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
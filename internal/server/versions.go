@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dyrober/AgencyCRM/internal/service"
+)
+
+// etag formats a record version as a strong ETag
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// requireIfMatch makes writes name the version they were based on, so two
+// people editing the same record can't silently overwrite each other. The
+// If-Match header must be the ETag from a GET, or * to write regardless.
+func requireIfMatch(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := strings.TrimSpace(r.Header.Get("If-Match"))
+		if header == "" {
+			respondError(w, http.StatusPreconditionRequired, "If-Match header is required")
+			return
+		}
+		if header == "*" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// only strong ETags we handed out can match, anything else never will
+		unquoted, ok := strings.CutPrefix(header, `"`)
+		if ok {
+			unquoted, ok = strings.CutSuffix(unquoted, `"`)
+		}
+		version, err := strconv.Atoi(unquoted)
+		if !ok || err != nil || version <= 0 {
+			respondError(w, http.StatusPreconditionFailed, "If-Match doesn't match the current version")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(service.WithExpectedVersion(r.Context(), version)))
+	})
+}
+
+// setUserETag hands out the version a write left the user at, so the client
+// can make its next change without reading the user again. Automations may
+// have changed the user too, so it is read back rather than worked out.
+func (s *Server) setUserETag(ctx context.Context, w http.ResponseWriter, id int) {
+	user, err := s.service.GetUser(ctx, id)
+	if err != nil {
+		log.Printf("Error getting version of user %d: %v", id, err)
+		return
+	}
+	w.Header().Set("ETag", etag(user.Version))
+}
+
+// respondVersioned sends a record with its version as the ETag, or 304 Not
+// Modified when the client's If-None-Match says it already has that version
+func respondVersioned(w http.ResponseWriter, r *http.Request, version int, data interface{}) {
+	tag := etag(version)
+	w.Header().Set("ETag", tag)
+
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		for _, candidate := range strings.Split(header, ",") {
+			// If-None-Match uses the weak comparison
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == tag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+
+	respondJSON(w, http.StatusOK, data)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// lists the webhook subscriptions
+func (s *Server) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := s.service.GetWebhooks(r.Context())
+	if err != nil {
+		log.Printf("Error getting webhooks: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get webhooks")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, webhooks)
+}
+
+// subscribes a URL to events. The signing secret is only ever returned here.
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req domain.CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	id, secret, err := s.service.CreateWebhook(r.Context(), req)
+	if msg, ok := validationMessage(err); ok {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating webhook: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]any{"id": id, "secret": secret})
+}
+
+// grabs a webhook subscription, without its secret, by ID
+func (s *Server) getWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	subscription, err := s.service.GetWebhook(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting webhook: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get webhook")
+		return
+	}
+
+	respondVersioned(w, r, subscription.Version, subscription)
+}
+
+// removes a webhook subscription
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	err = s.service.DeleteWebhook(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, http.StatusPreconditionFailed, "Webhook was changed by someone else")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting webhook: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lists a webhook's latest deliveries
+func (s *Server) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	deliveries, err := s.service.GetWebhookDeliveries(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting webhook deliveries: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get webhook deliveries")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, deliveries)
+}
+
+// queues a delivery to be sent again
+func (s *Server) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	err = s.service.RedeliverWebhook(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Delivery not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error redelivering webhook: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to redeliver webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
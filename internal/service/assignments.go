@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/segment"
+)
+
+// assignment limits
+const (
+	MaxAssignmentRuleName = 255
+	MaxAssignmentMembers  = 100
+	MaxAssignmentWeight   = 100
+	MaxBulkReassignIDs    = 1000
+)
+
+// CreateAssignmentRule saves an assignment rule after checking its members exist.
+// Territories are expressed as conditions on custom fields, e.g. cf.country:US cf.state:CA.
+func (s *Service) CreateAssignmentRule(ctx context.Context, req domain.CreateAssignmentRuleRequest) (int, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return 0, ValidationError("name is required")
+	}
+	if utf8.RuneCountInString(name) > MaxAssignmentRuleName {
+		return 0, ValidationError(fmt.Sprintf("names are limited to %d characters", MaxAssignmentRuleName))
+	}
+	if !supportedEntities[req.Entity] {
+		return 0, ValidationError(fmt.Sprintf("unsupported entity %q", req.Entity))
+	}
+	switch req.Strategy {
+	case domain.AssignRoundRobin, domain.AssignWeighted, domain.AssignLoadBalanced:
+	default:
+		return 0, ValidationError(fmt.Sprintf("unsupported strategy %q", req.Strategy))
+	}
+
+	if len(req.Members) == 0 {
+		return 0, ValidationError("members are required")
+	}
+	if len(req.Members) > MaxAssignmentMembers {
+		return 0, ValidationError(fmt.Sprintf("rules are limited to %d members", MaxAssignmentMembers))
+	}
+	members := make([]domain.AssignmentMember, 0, len(req.Members))
+	seen := make([]int, 0, len(req.Members))
+	for _, member := range req.Members {
+		if slices.Contains(seen, member.UserID) {
+			return 0, ValidationError(fmt.Sprintf("user %d is listed twice", member.UserID))
+		}
+		seen = append(seen, member.UserID)
+		if _, err := s.repo.GetUser(ctx, member.UserID); err != nil {
+			return 0, ValidationError(fmt.Sprintf("user %d does not exist", member.UserID))
+		}
+
+		// only weighted rules care about weights, the others give everyone one turn
+		weight := 1
+		if req.Strategy == domain.AssignWeighted {
+			if member.Weight < 1 || member.Weight > MaxAssignmentWeight {
+				return 0, ValidationError(fmt.Sprintf("weights must be between 1 and %d", MaxAssignmentWeight))
+			}
+			weight = member.Weight
+		}
+		members = append(members, domain.AssignmentMember{UserID: member.UserID, Weight: weight})
+	}
+
+	expression := strings.TrimSpace(req.Expression)
+	if expression != "" {
+		if _, err := s.parseSegment(ctx, req.Entity, expression); err != nil {
+			return 0, err
+		}
+	}
+
+	rule := domain.AssignmentRule{
+		Name:       name,
+		Entity:     req.Entity,
+		Position:   req.Position,
+		Expression: expression,
+		Strategy:   req.Strategy,
+		Members:    members,
+	}
+	var id int
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.repo.CreateAssignmentRule(ctx, rule)
+		if err != nil {
+			return fmt.Errorf("service error - create assignment rule: %w", err)
+		}
+		rule.ID = id
+		return s.audit(ctx, domain.AuditAssignmentRule, id, domain.AuditCreate, nil, rule)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetAssignmentRules lists the assignment rules for an entity in the order they are tried
+func (s *Service) GetAssignmentRules(ctx context.Context, entity string) ([]*domain.AssignmentRule, error) {
+	if !supportedEntities[entity] {
+		return nil, ValidationError(fmt.Sprintf("unsupported entity %q", entity))
+	}
+
+	rules, err := s.repo.GetAssignmentRules(ctx, entity)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get assignment rules: %w", err)
+	}
+	if rules == nil {
+		rules = []*domain.AssignmentRule{}
+	}
+	return rules, nil
+}
+
+// GetAssignmentRule retrieves an assignment rule
+func (s *Service) GetAssignmentRule(ctx context.Context, id int) (*domain.AssignmentRule, error) {
+	rule, err := s.repo.GetAssignmentRule(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get assignment rule: %w", err)
+	}
+	return rule, nil
+}
+
+// DeleteAssignmentRule moves an assignment rule to the trash
+func (s *Service) DeleteAssignmentRule(ctx context.Context, id int) error {
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.TrashRecord(ctx, domain.AuditAssignmentRule, id, expectedVersion(ctx), actorFrom(ctx).Name); err != nil {
+			return fmt.Errorf("service error - delete assignment rule: %w", err)
+		}
+		return s.audit(ctx, domain.AuditAssignmentRule, id, domain.AuditDelete, nil, nil)
+	})
+}
+
+// Reassign moves many records to a new owner by hand and returns how many changed owner
+func (s *Service) Reassign(ctx context.Context, req domain.ReassignRequest) (int, error) {
+	if !supportedEntities[req.Entity] {
+		return 0, ValidationError(fmt.Sprintf("unsupported entity %q", req.Entity))
+	}
+	if len(req.IDs) == 0 {
+		return 0, ValidationError("ids are required")
+	}
+	if len(req.IDs) > MaxBulkReassignIDs {
+		return 0, ValidationError(fmt.Sprintf("at most %d ids can be reassigned at once", MaxBulkReassignIDs))
+	}
+	if _, err := s.repo.GetUser(ctx, req.OwnerID); err != nil {
+		return 0, ValidationError(fmt.Sprintf("user %d does not exist", req.OwnerID))
+	}
+
+	var changed int
+	err := s.auditUsers(ctx, req.IDs, func(ctx context.Context) (err error) {
+		changed, err = s.repo.AssignOwner(ctx, req.Entity, req.IDs, req.OwnerID, nil, domain.AssignmentReasonManual)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("service error - reassign: %w", err)
+	}
+	return changed, nil
+}
+
+// GetAssignments lists the assignment history of a record
+func (s *Service) GetAssignments(ctx context.Context, entity string, entityID int) ([]*domain.Assignment, error) {
+	if !supportedEntities[entity] {
+		return nil, ValidationError(fmt.Sprintf("unsupported entity %q", entity))
+	}
+
+	assignments, err := s.repo.GetAssignments(ctx, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get assignments: %w", err)
+	}
+	if assignments == nil {
+		assignments = []*domain.Assignment{}
+	}
+	return assignments, nil
+}
+
+// SetOutOfOffice changes whether a user is picked for new assignments
+func (s *Service) SetOutOfOffice(ctx context.Context, userID int, outOfOffice bool) error {
+	version := expectedVersion(ctx)
+	err := s.auditUsers(ctx, []int{userID}, func(ctx context.Context) error {
+		return s.repo.SetOutOfOffice(ctx, userID, outOfOffice, version)
+	})
+	if err != nil {
+		return fmt.Errorf("service error - set out of office: %w", err)
+	}
+	return nil
+}
+
+// assignNewRecord runs the assignment rules against a freshly created record.
+// The first matching rule with an available member assigns it; if none does the
+// record stays unowned.
+func (s *Service) assignNewRecord(ctx context.Context, entity string, id int, record segment.Record) error {
+	rules, err := s.repo.GetAssignmentRules(ctx, entity)
+	if err != nil {
+		return fmt.Errorf("service error - get assignment rules: %w", err)
+	}
+
+	for _, rule := range rules {
+		if rule.Expression != "" {
+			expr, err := segment.Parse(rule.Expression, time.Now())
+			if err != nil {
+				log.Printf("Skipping assignment rule %d: %v", rule.ID, err)
+				continue
+			}
+			if !segment.Match(expr, record) {
+				continue
+			}
+		}
+
+		ownerID, ok, err := s.pickOwner(ctx, rule)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		ruleID := rule.ID
+		err = s.auditUsers(ctx, []int{id}, func(ctx context.Context) error {
+			_, err := s.repo.AssignOwner(ctx, entity, []int{id}, ownerID, &ruleID, domain.AssignmentReasonRule)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("service error - assign owner: %w", err)
+		}
+		return nil
+	}
+	return nil
+}
+
+// pickOwner chooses a member of the rule using its strategy, skipping members
+// who are out of office. ok is false when nobody is available.
+func (s *Service) pickOwner(ctx context.Context, rule *domain.AssignmentRule) (int, bool, error) {
+	ids := make([]int, 0, len(rule.Members))
+	for _, member := range rule.Members {
+		ids = append(ids, member.UserID)
+	}
+	available, err := s.repo.GetAvailableUsers(ctx, ids)
+	if err != nil {
+		return 0, false, fmt.Errorf("service error - get available users: %w", err)
+	}
+	if len(available) == 0 {
+		return 0, false, nil
+	}
+
+	if rule.Strategy == domain.AssignLoadBalanced {
+		counts, err := s.repo.CountOwnedRecords(ctx, rule.Entity, available)
+		if err != nil {
+			return 0, false, fmt.Errorf("service error - count owned records: %w", err)
+		}
+		// ties go to whoever is listed first on the rule
+		best := -1
+		for _, id := range ids {
+			if slices.Contains(available, id) && (best == -1 || counts[id] < counts[best]) {
+				best = id
+			}
+		}
+		return best, true, nil
+	}
+
+	// round robin and weighted rotate through a list of turns, where a
+	// member with weight 3 gets three consecutive turns
+	var turns []int
+	for _, member := range rule.Members {
+		weight := max(member.Weight, 1)
+		if rule.Strategy == domain.AssignRoundRobin {
+			weight = 1
+		}
+		for range weight {
+			turns = append(turns, member.UserID)
+		}
+	}
+
+	turn, err := s.repo.NextAssignmentTurn(ctx, rule.ID)
+	if err != nil {
+		return 0, false, fmt.Errorf("service error - next assignment turn: %w", err)
+	}
+	for i := range turns {
+		candidate := turns[(turn+i)%len(turns)]
+		if slices.Contains(available, candidate) {
+			return candidate, true, nil
+		}
+	}
+	return 0, false, nil
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createReps adds users to act as sales reps and returns their IDs
+func createReps(t *testing.T, service *Service, names ...string) []int {
+	t.Helper()
+	var ids []int
+	for _, name := range names {
+		id, err := service.CreateUser(context.Background(), domain.CreateUserRequest{Name: name, Email: name + "@agency.test"})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// ownersOfNewLeads creates n leads and returns who each one was assigned to
+func ownersOfNewLeads(t *testing.T, service *Service, n int) []int {
+	t.Helper()
+	var owners []int
+	for i := 0; i < n; i++ {
+		id, err := service.CreateUser(context.Background(), domain.CreateUserRequest{Name: "Lead", Email: fmt.Sprintf("lead%d@example.com", i)})
+		require.NoError(t, err)
+		user, err := service.GetUser(context.Background(), id)
+		require.NoError(t, err)
+		owner := 0
+		if user.OwnerID != nil {
+			owner = *user.OwnerID
+		}
+		owners = append(owners, owner)
+	}
+	return owners
+}
+
+func TestAssignmentStrategies(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("round robin skips out of office", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		reps := createReps(t, service, "ann", "ben", "cat")
+		_, err := service.CreateAssignmentRule(ctx, domain.CreateAssignmentRuleRequest{
+			Name: "Everyone", Entity: "user", Strategy: domain.AssignRoundRobin,
+			Members: []domain.AssignmentMember{{UserID: reps[0]}, {UserID: reps[1]}, {UserID: reps[2]}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, service.SetOutOfOffice(ctx, reps[1], true))
+
+		assert.Equal(t, []int{reps[0], reps[2], reps[2], reps[0]}, ownersOfNewLeads(t, service, 4))
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		reps := createReps(t, service, "ann", "ben")
+		_, err := service.CreateAssignmentRule(ctx, domain.CreateAssignmentRuleRequest{
+			Name: "Senior gets more", Entity: "user", Strategy: domain.AssignWeighted,
+			Members: []domain.AssignmentMember{{UserID: reps[0], Weight: 2}, {UserID: reps[1], Weight: 1}},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{reps[0], reps[0], reps[1], reps[0]}, ownersOfNewLeads(t, service, 4))
+	})
+
+	t.Run("load balanced", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		reps := createReps(t, service, "ann", "ben")
+		leads := createReps(t, service, "old1", "old2")
+		_, err := service.Reassign(ctx, domain.ReassignRequest{Entity: "user", IDs: leads, OwnerID: reps[0]})
+		require.NoError(t, err)
+		_, err = service.CreateAssignmentRule(ctx, domain.CreateAssignmentRuleRequest{
+			Name: "Least busy", Entity: "user", Strategy: domain.AssignLoadBalanced,
+			Members: []domain.AssignmentMember{{UserID: reps[0]}, {UserID: reps[1]}},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{reps[1], reps[1], reps[0]}, ownersOfNewLeads(t, service, 3))
+	})
+
+	t.Run("territory rules run in position order", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		_, err := service.CreateCustomField(ctx, domain.CreateCustomFieldRequest{Entity: "user", Key: "state", Label: "State", Type: domain.CustomFieldText})
+		require.NoError(t, err)
+		reps := createReps(t, service, "west", "rest")
+		_, err = service.CreateAssignmentRule(ctx, domain.CreateAssignmentRuleRequest{
+			Name: "Everyone else", Entity: "user", Position: 2, Strategy: domain.AssignRoundRobin,
+			Members: []domain.AssignmentMember{{UserID: reps[1]}},
+		})
+		require.NoError(t, err)
+		_, err = service.CreateAssignmentRule(ctx, domain.CreateAssignmentRuleRequest{
+			Name: "West coast", Entity: "user", Position: 1, Expression: "cf.state:CA OR cf.state:OR", Strategy: domain.AssignRoundRobin,
+			Members: []domain.AssignmentMember{{UserID: reps[0]}},
+		})
+		require.NoError(t, err)
+
+		westID, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Lead", Email: "ca@example.com", CustomFields: map[string]any{"state": "CA"}})
+		require.NoError(t, err)
+		otherID, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Lead", Email: "ny@example.com", CustomFields: map[string]any{"state": "NY"}})
+		require.NoError(t, err)
+
+		west, err := service.GetUser(ctx, westID)
+		require.NoError(t, err)
+		other, err := service.GetUser(ctx, otherID)
+		require.NoError(t, err)
+		assert.Equal(t, reps[0], *west.OwnerID)
+		assert.Equal(t, reps[1], *other.OwnerID)
+
+		history, err := service.GetAssignments(ctx, "user", westID)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		assert.Equal(t, domain.AssignmentReasonRule, history[0].Reason)
+		assert.NotNil(t, history[0].RuleID)
+	})
+}
+
+func TestCreateAssignmentRuleValidation(t *testing.T) {
+	service := NewService(repository.NewMockRepository())
+	reps := createReps(t, service, "ann")
+
+	tests := []struct {
+		name    string
+		request domain.CreateAssignmentRuleRequest
+	}{
+		{"no members", domain.CreateAssignmentRuleRequest{Name: "Empty", Entity: "user", Strategy: domain.AssignRoundRobin}},
+		{"unknown member", domain.CreateAssignmentRuleRequest{Name: "Ghost", Entity: "user", Strategy: domain.AssignRoundRobin, Members: []domain.AssignmentMember{{UserID: 99}}}},
+		{"duplicate member", domain.CreateAssignmentRuleRequest{Name: "Twice", Entity: "user", Strategy: domain.AssignRoundRobin, Members: []domain.AssignmentMember{{UserID: reps[0]}, {UserID: reps[0]}}}},
+		{"missing weight", domain.CreateAssignmentRuleRequest{Name: "Weighted", Entity: "user", Strategy: domain.AssignWeighted, Members: []domain.AssignmentMember{{UserID: reps[0]}}}},
+		{"unknown strategy", domain.CreateAssignmentRuleRequest{Name: "Random", Entity: "user", Strategy: "random", Members: []domain.AssignmentMember{{UserID: reps[0]}}}},
+		{"bad expression", domain.CreateAssignmentRuleRequest{Name: "Broken", Entity: "user", Strategy: domain.AssignRoundRobin, Expression: "tag:a AND", Members: []domain.AssignmentMember{{UserID: reps[0]}}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := service.CreateAssignmentRule(context.Background(), tc.request)
+			assert.IsType(t, ValidationError(""), err)
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+)
+
+// audit log limits
+const (
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+// ActorSystem is the actor of changes made outside a request, e.g. by workers
+const ActorSystem = "system"
+
+// auditSkippedFields aren't diffed, they change on every write
+var auditSkippedFields = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+	"version":    true,
+}
+
+// auditMaskedFields are diffed without their values
+var auditMaskedFields = map[string]bool{
+	"secret": true,
+}
+
+// actorKey is the context key of the Actor
+type actorKey struct{}
+
+// WithActor returns a context whose changes are audited as made by actor
+func WithActor(ctx context.Context, actor domain.Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFrom returns the actor of a context, ActorSystem when none was set
+func actorFrom(ctx context.Context) domain.Actor {
+	actor, ok := ctx.Value(actorKey{}).(domain.Actor)
+	if !ok || actor.Name == "" {
+		actor.Name = ActorSystem
+	}
+	return actor
+}
+
+// audit appends a change to the audit log. before is nil for creates and
+// after is nil for deletes, updates that changed nothing are skipped. It runs
+// in the unit of work making the change, so a change never commits without
+// its audit event.
+func (s *Service) audit(ctx context.Context, entity string, id int, action string, before, after any) error {
+	changes, err := auditDiff(before, after)
+	if err != nil {
+		return fmt.Errorf("service error - diff %s %d for the audit log: %w", entity, id, err)
+	}
+	if action == domain.AuditUpdate && len(changes) == 0 {
+		return nil
+	}
+
+	actor := actorFrom(ctx)
+	if _, err := s.repo.AppendAuditEvent(ctx, domain.AuditEvent{
+		Actor:     actor.Name,
+		Entity:    entity,
+		EntityID:  id,
+		Action:    action,
+		Changes:   changes,
+		RequestID: actor.RequestID,
+		IP:        actor.IP,
+	}); err != nil {
+		return fmt.Errorf("service error - audit %s of %s %d: %w", action, entity, id, err)
+	}
+	return nil
+}
+
+// auditUsers runs change and audits how it changed each of the users, keeping
+// the history of their tracked fields, in one unit of work. It is for changes
+// such as tagging that the repository makes in bulk.
+func (s *Service) auditUsers(ctx context.Context, ids []int, change func(ctx context.Context) error) error {
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		before := make(map[int]*domain.User, len(ids))
+		for _, id := range ids {
+			if user, err := s.repo.GetUser(ctx, id); err == nil {
+				before[id] = user
+			}
+		}
+		if err := change(ctx); err != nil {
+			return err
+		}
+		for id, old := range before {
+			user, err := s.repo.GetUser(ctx, id)
+			if err != nil {
+				return fmt.Errorf("service error - get user: %w", err)
+			}
+			if err := s.recordUserUpdated(ctx, old, user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// auditDiff compares the JSON fields of two values, descending into objects
+// so nested fields are keyed like "custom_fields.company"
+func auditDiff(before, after any) (map[string]domain.AuditChange, error) {
+	old, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := map[string]domain.AuditChange{}
+	diffFields("", old, updated, changes)
+	return changes, nil
+}
+
+// auditFields converts a value to its JSON fields
+func auditFields(value any) (map[string]any, error) {
+	fields := map[string]any{}
+	if value == nil || reflect.ValueOf(value).Kind() == reflect.Pointer && reflect.ValueOf(value).IsNil() {
+		return fields, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// diffFields adds the differences between two sets of fields to changes
+func diffFields(prefix string, old, updated map[string]any, changes map[string]domain.AuditChange) {
+	keys := map[string]bool{}
+	for key := range old {
+		keys[key] = true
+	}
+	for key := range updated {
+		keys[key] = true
+	}
+	for key := range keys {
+		if prefix == "" && auditSkippedFields[key] {
+			continue
+		}
+		from, to := old[key], updated[key]
+		fromObject, fromIsObject := from.(map[string]any)
+		toObject, toIsObject := to.(map[string]any)
+		if (fromIsObject || from == nil) && (toIsObject || to == nil) && (fromIsObject || toIsObject) {
+			diffFields(prefix+key+".", fromObject, toObject, changes)
+			continue
+		}
+		if reflect.DeepEqual(from, to) {
+			continue
+		}
+		if auditMaskedFields[key] {
+			from, to = maskValue(from), maskValue(to)
+		}
+		changes[prefix+key] = domain.AuditChange{From: from, To: to}
+	}
+}
+
+// maskValue hides a secret value, keeping whether it was set
+func maskValue(value any) any {
+	if value == nil || value == "" {
+		return value
+	}
+	return "********"
+}
+
+// GetAuditEvents lists the audit events matching the filter, oldest first.
+// Page through them by passing the last ID as filter.AfterID.
+func (s *Service) GetAuditEvents(ctx context.Context, filter domain.AuditFilter) ([]*domain.AuditEvent, error) {
+	if filter.Limit == 0 {
+		filter.Limit = DefaultAuditLimit
+	}
+	if filter.Limit < 0 || filter.Limit > MaxAuditLimit {
+		return nil, ValidationError(fmt.Sprintf("limit must be between 1 and %d", MaxAuditLimit))
+	}
+	if filter.Since != nil && filter.Until != nil && !filter.Since.Before(*filter.Until) {
+		return nil, ValidationError("since must be before until")
+	}
+
+	events, err := s.repo.GetAuditEvents(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get audit events: %w", err)
+	}
+	if events == nil {
+		events = []*domain.AuditEvent{}
+	}
+	return events, nil
+}
+
+// EachAuditEvent calls fn with every audit event matching the filter, oldest
+// first, reading them a page at a time. filter.Limit is ignored.
+func (s *Service) EachAuditEvent(ctx context.Context, filter domain.AuditFilter, fn func(*domain.AuditEvent) error) error {
+	filter.Limit = MaxAuditLimit
+	for {
+		events, err := s.GetAuditEvents(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		if len(events) < filter.Limit {
+			return nil
+		}
+		filter.AfterID = events[len(events)-1].ID
+	}
+}
+
+// VerifyAuditLog walks the whole audit log checking every event links to the
+// one before and that its hashes match its contents
+func (s *Service) VerifyAuditLog(ctx context.Context) (*domain.AuditVerification, error) {
+	result := &domain.AuditVerification{Valid: true}
+	prevHash := repository.AuditGenesisHash
+	err := s.EachAuditEvent(ctx, domain.AuditFilter{}, func(event *domain.AuditEvent) error {
+		result.Checked++
+		intact := event.PrevHash == prevHash && event.Hash == repository.AuditHash(*event)
+		// redacted events keep their changes hash, their diff is gone
+		if !event.Redacted && event.ChangesHash != repository.AuditChangesHash(event.Changes) {
+			intact = false
+		}
+		if !intact && result.Valid {
+			id := event.ID
+			result.Valid = false
+			result.BrokenAt = &id
+		}
+		prevHash = event.Hash
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Head = prevHash
+	return result, nil
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tamperedRepository alters audit events as they are read back
+type tamperedRepository struct {
+	*repository.MockRepository
+	tamper func(*domain.AuditEvent)
+}
+
+func (r *tamperedRepository) GetAuditEvents(ctx context.Context, filter domain.AuditFilter) ([]*domain.AuditEvent, error) {
+	events, err := r.MockRepository.GetAuditEvents(ctx, filter)
+	for _, event := range events {
+		r.tamper(event)
+	}
+	return events, err
+}
+
+// unauditedRepository can't append to the audit log
+type unauditedRepository struct {
+	*repository.MockRepository
+}
+
+func (r *unauditedRepository) AppendAuditEvent(ctx context.Context, event domain.AuditEvent) (int, error) {
+	return 0, errors.New("audit log unavailable")
+}
+
+func TestAudit(t *testing.T) {
+	actor := domain.Actor{Name: "jane", RequestID: "req-1", IP: "203.0.113.7"}
+	ctx := WithActor(context.Background(), actor)
+
+	t.Run("creates, updates and deletes are diffed", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+		name := "Ada Lovelace"
+		require.NoError(t, service.UpdateUser(ctx, id, domain.UpdateUserRequest{Name: &name}))
+		// an update that changes nothing isn't logged
+		require.NoError(t, service.UpdateUser(ctx, id, domain.UpdateUserRequest{Name: &name}))
+		_, err = service.AddTags(ctx, domain.BulkTagRequest{Entity: domain.EntityUser, IDs: []int{id}, Tags: []string{"vip"}})
+		require.NoError(t, err)
+
+		events, err := service.GetAuditEvents(ctx, domain.AuditFilter{Entity: domain.EntityUser, EntityID: id})
+		require.NoError(t, err)
+		require.Len(t, events, 3)
+
+		assert.Equal(t, domain.AuditCreate, events[0].Action)
+		assert.Equal(t, "jane", events[0].Actor)
+		assert.Equal(t, "req-1", events[0].RequestID)
+		assert.Equal(t, "203.0.113.7", events[0].IP)
+		assert.Equal(t, "ada@example.com", events[0].Changes["email"].To)
+		assert.NotContains(t, events[0].Changes, "created_at")
+
+		assert.Equal(t, domain.AuditUpdate, events[1].Action)
+		assert.Equal(t, map[string]domain.AuditChange{"name": {From: "Ada", To: "Ada Lovelace"}}, events[1].Changes)
+		assert.Equal(t, []any{"vip"}, events[2].Changes["tags"].To)
+
+		tag, err := service.CreateTag(ctx, domain.CreateTagRequest{Name: "cold"})
+		require.NoError(t, err)
+		events, err = service.GetAuditEvents(ctx, domain.AuditFilter{Entity: domain.AuditTag, EntityID: tag})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, "cold", events[0].Changes["name"].To)
+	})
+
+	t.Run("changes are undone when they can't be audited", func(t *testing.T) {
+		repo := repository.NewMockRepository()
+		service := NewService(repo)
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+
+		service.repo = &unauditedRepository{repo}
+		_, err = service.CreateUser(ctx, domain.CreateUserRequest{Name: "Grace", Email: "grace@example.com"})
+		assert.Error(t, err)
+		name := "Ada Lovelace"
+		assert.Error(t, service.UpdateUser(ctx, id, domain.UpdateUserRequest{Name: &name}))
+		_, err = service.AddTags(ctx, domain.BulkTagRequest{Entity: domain.EntityUser, IDs: []int{id}, Tags: []string{"vip"}})
+		assert.Error(t, err)
+		assert.Error(t, service.DeleteUser(ctx, id))
+
+		users, err := repo.GetUsers(ctx, domain.ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, "Ada", users[0].Name)
+		assert.Empty(t, users[0].Tags)
+		history, err := repo.GetFieldHistory(ctx, domain.EntityUser, id, nil)
+		require.NoError(t, err)
+		assert.Empty(t, history)
+	})
+
+	t.Run("deleting a scoring rule is audited, rescoring isn't", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		userID, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+		ruleID, err := service.CreateScoringRule(ctx, domain.CreateScoringRuleRequest{
+			Name: "Named", Entity: domain.EntityUser, Type: domain.ScoringRuleCondition, Expression: "name:Ada", Points: 5,
+		})
+		require.NoError(t, err)
+		require.NoError(t, service.RescoreUser(ctx, userID))
+		require.NoError(t, service.DeleteScoringRule(ctx, ruleID))
+
+		events, err := service.GetAuditEvents(ctx, domain.AuditFilter{Entity: domain.AuditScoringRule})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, ruleID, events[1].EntityID)
+		assert.Equal(t, domain.AuditDelete, events[1].Action)
+	})
+
+	t.Run("changes without an actor are the system's", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		_, err := service.CreateUser(context.Background(), domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+
+		events, err := service.GetAuditEvents(ctx, domain.AuditFilter{})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, ActorSystem, events[0].Actor)
+	})
+
+	t.Run("nested fields and secrets", func(t *testing.T) {
+		changes, err := auditDiff(
+			map[string]any{"custom_fields": map[string]any{"company": "Acme"}, "secret": "old"},
+			map[string]any{"custom_fields": map[string]any{"company": "Globex"}, "secret": "new"},
+		)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]domain.AuditChange{
+			"custom_fields.company": {From: "Acme", To: "Globex"},
+			"secret":                {From: "********", To: "********"},
+		}, changes)
+	})
+
+	t.Run("the chain verifies", func(t *testing.T) {
+		repo := repository.NewMockRepository()
+		service := NewService(repo)
+		for _, name := range []string{"Ada", "Grace", "Edsger"} {
+			_, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: name, Email: name + "@example.com"})
+			require.NoError(t, err)
+		}
+
+		result, err := service.VerifyAuditLog(ctx)
+		require.NoError(t, err)
+		assert.True(t, result.Valid)
+		assert.Equal(t, 3, result.Checked)
+		assert.Nil(t, result.BrokenAt)
+		assert.NotEqual(t, repository.AuditGenesisHash, result.Head)
+
+		tampered := NewService(&tamperedRepository{MockRepository: repo, tamper: func(event *domain.AuditEvent) {
+			if event.ID == 2 {
+				event.Actor = "someone else"
+			}
+		}})
+		result, err = tampered.VerifyAuditLog(ctx)
+		require.NoError(t, err)
+		assert.False(t, result.Valid)
+		require.NotNil(t, result.BrokenAt)
+		assert.Equal(t, 2, *result.BrokenAt)
+
+		edited := NewService(&tamperedRepository{MockRepository: repo, tamper: func(event *domain.AuditEvent) {
+			if event.ID == 3 {
+				event.Changes["name"] = domain.AuditChange{To: "Alan"}
+			}
+		}})
+		result, err = edited.VerifyAuditLog(ctx)
+		require.NoError(t, err)
+		assert.False(t, result.Valid)
+		require.NotNil(t, result.BrokenAt)
+		assert.Equal(t, 3, *result.BrokenAt)
+	})
+
+	t.Run("erasure redacts the diffs and the chain still verifies", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+		_, err = service.ErasePersonalData(ctx, domain.PrivacySubject{Entity: domain.EntityUser, EntityID: id})
+		require.NoError(t, err)
+
+		events, err := service.GetAuditEvents(ctx, domain.AuditFilter{Entity: domain.EntityUser, EntityID: id})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.True(t, events[0].Redacted)
+		assert.Empty(t, events[0].Changes)
+		assert.Equal(t, domain.AuditErase, events[1].Action)
+
+		result, err := service.VerifyAuditLog(ctx)
+		require.NoError(t, err)
+		assert.True(t, result.Valid)
+	})
+
+	t.Run("filters are checked", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		_, err := service.GetAuditEvents(ctx, domain.AuditFilter{Limit: MaxAuditLimit + 1})
+		assert.ErrorAs(t, err, new(ValidationError))
+	})
+
+	t.Run("every event is visited across pages", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		for i := 0; i < MaxAuditLimit+5; i++ {
+			require.NoError(t, service.audit(ctx, domain.AuditTag, i+1, domain.AuditCreate, nil, domain.Tag{ID: i + 1}))
+		}
+		visited := 0
+		require.NoError(t, service.EachAuditEvent(ctx, domain.AuditFilter{}, func(*domain.AuditEvent) error {
+			visited++
+			return nil
+		}))
+		assert.Equal(t, MaxAuditLimit+5, visited)
+	})
+}
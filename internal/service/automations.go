@@ -0,0 +1,573 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/segment"
+)
+
+// automation limits
+const (
+	MaxAutomationName    = 255
+	MaxAutomationActions = 10
+	MaxAutomationOffset  = 365
+	// MaxAutomationDepth stops automations that trigger each other from looping
+	MaxAutomationDepth = 5
+	// MaxAutomationAttempts is how many times a failing run is tried
+	MaxAutomationAttempts = 3
+	automationRetryDelay  = time.Minute
+	automationClaimBatch  = 50
+)
+
+// recordEvent describes a change to a record that automations can react to
+type recordEvent struct {
+	entity  string
+	id      int
+	trigger domain.AutomationTriggerType
+	// changed lists the fields an update changed, "name", "email" or "cf.<key>"
+	changed []string
+	// depth counts how many automations led to this change
+	depth int
+}
+
+// CreateAutomation saves an automation after checking its trigger, condition and actions
+func (s *Service) CreateAutomation(ctx context.Context, req domain.CreateAutomationRequest) (int, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return 0, ValidationError("name is required")
+	}
+	if utf8.RuneCountInString(name) > MaxAutomationName {
+		return 0, ValidationError(fmt.Sprintf("names are limited to %d characters", MaxAutomationName))
+	}
+	if !supportedEntities[req.Entity] {
+		return 0, ValidationError(fmt.Sprintf("unsupported entity %q", req.Entity))
+	}
+
+	defs, err := s.customFieldsByKey(ctx, req.Entity)
+	if err != nil {
+		return 0, err
+	}
+	if err := validateTrigger(req.Trigger, defs); err != nil {
+		return 0, err
+	}
+
+	condition := strings.TrimSpace(req.Condition)
+	if condition != "" {
+		if _, err := s.parseSegment(ctx, req.Entity, condition); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(req.Actions) == 0 {
+		return 0, ValidationError("at least one action is required")
+	}
+	if len(req.Actions) > MaxAutomationActions {
+		return 0, ValidationError(fmt.Sprintf("automations are limited to %d actions", MaxAutomationActions))
+	}
+	actions := make([]domain.AutomationAction, 0, len(req.Actions))
+	for i, action := range req.Actions {
+		normalized, err := s.validateAction(ctx, action, defs)
+		if err != nil {
+			return 0, ValidationError(fmt.Sprintf("action %d: %v", i+1, err))
+		}
+		actions = append(actions, normalized)
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	automation := domain.Automation{
+		Name:      name,
+		Entity:    req.Entity,
+		Enabled:   enabled,
+		Trigger:   req.Trigger,
+		Condition: condition,
+		Actions:   actions,
+	}
+	var id int
+	err = s.repo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.repo.CreateAutomation(ctx, automation)
+		if err != nil {
+			return fmt.Errorf("service error - create automation: %w", err)
+		}
+		automation.ID = id
+		return s.audit(ctx, domain.AuditAutomation, id, domain.AuditCreate, nil, automation)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetAutomations lists the automations for an entity
+func (s *Service) GetAutomations(ctx context.Context, entity string) ([]*domain.Automation, error) {
+	if !supportedEntities[entity] {
+		return nil, ValidationError(fmt.Sprintf("unsupported entity %q", entity))
+	}
+
+	automations, err := s.repo.GetAutomations(ctx, entity)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get automations: %w", err)
+	}
+	if automations == nil {
+		automations = []*domain.Automation{}
+	}
+	return automations, nil
+}
+
+// GetAutomation retrieves an automation
+func (s *Service) GetAutomation(ctx context.Context, id int) (*domain.Automation, error) {
+	automation, err := s.repo.GetAutomation(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get automation: %w", err)
+	}
+	return automation, nil
+}
+
+// DeleteAutomation moves an automation to the trash, its pending runs wait
+// until it is restored
+func (s *Service) DeleteAutomation(ctx context.Context, id int) error {
+	before, err := s.repo.GetAutomation(ctx, id)
+	if err != nil {
+		return fmt.Errorf("service error - get automation: %w", err)
+	}
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.TrashRecord(ctx, domain.AuditAutomation, id, expectedVersion(ctx), actorFrom(ctx).Name); err != nil {
+			return fmt.Errorf("service error - delete automation: %w", err)
+		}
+		return s.audit(ctx, domain.AuditAutomation, id, domain.AuditDelete, before, nil)
+	})
+}
+
+// GetAutomationRuns lists the latest runs of an automation with their logs
+func (s *Service) GetAutomationRuns(ctx context.Context, id int) ([]*domain.AutomationRun, error) {
+	if _, err := s.repo.GetAutomation(ctx, id); err != nil {
+		return nil, fmt.Errorf("service error - get automation: %w", err)
+	}
+
+	runs, err := s.repo.GetAutomationRuns(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get automation runs: %w", err)
+	}
+	if runs == nil {
+		runs = []*domain.AutomationRun{}
+	}
+	return runs, nil
+}
+
+// validateTrigger checks the trigger type and that its field exists
+func validateTrigger(trigger domain.AutomationTrigger, defs map[string]*domain.CustomFieldDefinition) error {
+	switch trigger.Type {
+	case domain.TriggerRecordCreated, domain.TriggerRecordUpdated:
+		if trigger.Field != "" || trigger.OffsetDays != 0 {
+			return ValidationError("record triggers don't take a field or offset")
+		}
+	case domain.TriggerFieldChanged:
+		if !isRecordField(trigger.Field, defs) {
+			return ValidationError(fmt.Sprintf("unknown trigger field %q", trigger.Field))
+		}
+	case domain.TriggerDateReached:
+		key, isCustom := strings.CutPrefix(trigger.Field, segment.CustomFieldPrefix)
+		if trigger.Field != "created_at" && (!isCustom || defs[key] == nil || defs[key].Type != domain.CustomFieldDate) {
+			return ValidationError("date triggers need created_at or a date custom field")
+		}
+		if trigger.OffsetDays < -MaxAutomationOffset || trigger.OffsetDays > MaxAutomationOffset {
+			return ValidationError(fmt.Sprintf("offset_days must be between -%d and %d", MaxAutomationOffset, MaxAutomationOffset))
+		}
+	default:
+		return ValidationError(fmt.Sprintf("unsupported trigger %q", trigger.Type))
+	}
+	return nil
+}
+
+// validateAction checks an action's settings and returns it with its value normalized
+func (s *Service) validateAction(ctx context.Context, action domain.AutomationAction, defs map[string]*domain.CustomFieldDefinition) (domain.AutomationAction, error) {
+	switch action.Type {
+	case domain.ActionUpdateField:
+		if !isRecordField(action.Field, defs) {
+			return action, fmt.Errorf("unknown field %q", action.Field)
+		}
+		if key, ok := strings.CutPrefix(action.Field, segment.CustomFieldPrefix); ok {
+			// a null value clears the field
+			if action.Value == nil {
+				return action, nil
+			}
+			value, err := s.normalizeCustomValue(ctx, defs[key], action.Value)
+			if err != nil {
+				return action, err
+			}
+			action.Value = value
+			return action, nil
+		}
+		if text, ok := action.Value.(string); !ok || strings.TrimSpace(text) == "" {
+			return action, fmt.Errorf("%s must be a non-empty string", action.Field)
+		}
+		return action, nil
+
+	case domain.ActionAddTag:
+		tag, err := normalizeTag(action.Tag)
+		if err != nil {
+			return action, err
+		}
+		action.Tag = tag
+		return action, nil
+
+	case domain.ActionAssignOwner:
+		if _, err := s.repo.GetUser(ctx, action.OwnerID); err != nil {
+			return action, fmt.Errorf("user %d does not exist", action.OwnerID)
+		}
+		return action, nil
+
+	case domain.ActionCallWebhook:
+		target, err := url.Parse(action.URL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return action, errors.New("url must be an absolute http or https URL")
+		}
+		return action, nil
+	}
+	return action, fmt.Errorf("unsupported action %q", action.Type)
+}
+
+// isRecordField reports whether field names a built-in or defined custom field
+func isRecordField(field string, defs map[string]*domain.CustomFieldDefinition) bool {
+	if field == segment.FieldName || field == segment.FieldEmail {
+		return true
+	}
+	key, ok := strings.CutPrefix(field, segment.CustomFieldPrefix)
+	return ok && defs[key] != nil
+}
+
+// dispatchEvent queues a run for every enabled automation the event triggers.
+// Runs past the depth limit are logged as skipped instead of queued.
+func (s *Service) dispatchEvent(ctx context.Context, event recordEvent) error {
+	automations, err := s.repo.GetAutomations(ctx, event.entity)
+	if err != nil {
+		return fmt.Errorf("service error - get automations: %w", err)
+	}
+
+	var record *segment.Record
+	for _, automation := range automations {
+		if !automation.Enabled || !triggeredBy(automation.Trigger, event) {
+			continue
+		}
+
+		if automation.Condition != "" {
+			if record == nil {
+				user, err := s.repo.GetUser(ctx, event.id)
+				if err != nil {
+					return fmt.Errorf("service error - get user: %w", err)
+				}
+				rec := userRecord(user)
+				record = &rec
+			}
+			expr, err := segment.Parse(automation.Condition, time.Now())
+			if err != nil {
+				log.Printf("Skipping automation %d: %v", automation.ID, err)
+				continue
+			}
+			if !segment.Match(expr, *record) {
+				continue
+			}
+		}
+
+		run := domain.AutomationRun{
+			AutomationID:  automation.ID,
+			Entity:        event.entity,
+			EntityID:      event.id,
+			Status:        domain.RunPending,
+			Depth:         event.depth,
+			NextAttemptAt: time.Now(),
+		}
+		if event.depth >= MaxAutomationDepth {
+			run.Status = domain.RunSkipped
+			run.Log = fmt.Sprintf("skipped: %d automations in a row changed this record, stopping a possible loop", event.depth)
+		}
+		if _, _, err := s.repo.CreateAutomationRun(ctx, run); err != nil {
+			return fmt.Errorf("service error - queue automation run: %w", err)
+		}
+	}
+	return nil
+}
+
+// triggeredBy reports whether a record event fires the trigger
+func triggeredBy(trigger domain.AutomationTrigger, event recordEvent) bool {
+	switch trigger.Type {
+	case domain.TriggerRecordCreated, domain.TriggerRecordUpdated:
+		return trigger.Type == event.trigger
+	case domain.TriggerFieldChanged:
+		return event.trigger == domain.TriggerRecordUpdated && slices.Contains(event.changed, trigger.Field)
+	}
+	return false
+}
+
+// ScheduleDateAutomations queues runs for records whose trigger date has passed.
+// A run is queued once per record and date, and only for dates after the
+// automation was created so new automations don't fire for old records.
+func (s *Service) ScheduleDateAutomations(ctx context.Context, now time.Time) (int, error) {
+	automations, err := s.repo.GetAutomations(ctx, domain.EntityUser)
+	if err != nil {
+		return 0, fmt.Errorf("service error - get automations: %w", err)
+	}
+
+	queued := 0
+	for _, automation := range automations {
+		if !automation.Enabled || automation.Trigger.Type != domain.TriggerDateReached {
+			continue
+		}
+		var expr segment.Expr
+		if automation.Condition != "" {
+			if expr, err = segment.Parse(automation.Condition, now); err != nil {
+				log.Printf("Skipping automation %d: %v", automation.ID, err)
+				continue
+			}
+		}
+		since := automation.CreatedAt.Truncate(24 * time.Hour)
+
+		afterID := 0
+		for {
+			users, err := s.repo.GetUserBatch(ctx, afterID, ScoringBatchSize)
+			if err != nil {
+				return queued, fmt.Errorf("service error - get user batch: %w", err)
+			}
+			for _, user := range users {
+				afterID = user.ID
+				date, ok := triggerDate(automation.Trigger.Field, user)
+				if !ok {
+					continue
+				}
+				due := date.AddDate(0, 0, automation.Trigger.OffsetDays)
+				if due.After(now) || due.Before(since) {
+					continue
+				}
+				if expr != nil && !segment.Match(expr, userRecord(user)) {
+					continue
+				}
+
+				_, created, err := s.repo.CreateAutomationRun(ctx, domain.AutomationRun{
+					AutomationID:  automation.ID,
+					Entity:        domain.EntityUser,
+					EntityID:      user.ID,
+					Status:        domain.RunPending,
+					DedupeKey:     due.Format(time.DateOnly),
+					NextAttemptAt: now,
+				})
+				if err != nil {
+					return queued, fmt.Errorf("service error - queue automation run: %w", err)
+				}
+				if created {
+					queued++
+				}
+			}
+			if len(users) < ScoringBatchSize {
+				break
+			}
+		}
+	}
+	return queued, nil
+}
+
+// triggerDate reads the date a date trigger is relative to
+func triggerDate(field string, user *domain.User) (time.Time, bool) {
+	if field == "created_at" {
+		return user.CreatedAt, true
+	}
+	key, _ := strings.CutPrefix(field, segment.CustomFieldPrefix)
+	text, ok := user.CustomFields[key].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	date, err := time.Parse(time.DateOnly, text)
+	return date, err == nil
+}
+
+// RunAutomations executes the runs that are due and returns how many it processed
+func (s *Service) RunAutomations(ctx context.Context) (int, error) {
+	runs, err := s.repo.ClaimAutomationRuns(ctx, time.Now(), automationClaimBatch)
+	if err != nil {
+		return 0, fmt.Errorf("service error - claim automation runs: %w", err)
+	}
+
+	for _, run := range runs {
+		logLines, runErr := s.executeRun(ctx, run)
+		run.Log = strings.Join(logLines, "\n")
+		run.Error = ""
+
+		switch {
+		case runErr == nil:
+			run.Status = domain.RunSucceeded
+		case errors.Is(runErr, errSkipRun):
+			run.Status = domain.RunSkipped
+		case run.Attempts < MaxAutomationAttempts:
+			// back off 1, 2, 4... minutes before trying again
+			run.Status = domain.RunPending
+			run.Error = runErr.Error()
+			run.NextAttemptAt = time.Now().Add(automationRetryDelay << (run.Attempts - 1))
+		default:
+			run.Status = domain.RunFailed
+			run.Error = runErr.Error()
+		}
+
+		if err := s.repo.FinishAutomationRun(ctx, *run); err != nil {
+			return 0, fmt.Errorf("service error - finish automation run: %w", err)
+		}
+	}
+	return len(runs), nil
+}
+
+// errSkipRun marks a run that had nothing to do
+var errSkipRun = errors.New("skipped")
+
+// executeRun performs an automation's actions in order, stopping at the first failure
+func (s *Service) executeRun(ctx context.Context, run *domain.AutomationRun) ([]string, error) {
+	automation, err := s.repo.GetAutomation(ctx, run.AutomationID)
+	if err != nil {
+		return nil, fmt.Errorf("get automation: %w", err)
+	}
+	if !automation.Enabled {
+		return []string{"automation is disabled"}, errSkipRun
+	}
+
+	var logLines []string
+	for i, action := range automation.Actions {
+		detail, err := s.executeAction(ctx, automation, run, action)
+		if err != nil {
+			logLines = append(logLines, fmt.Sprintf("action %d %s failed: %v", i+1, action.Type, err))
+			return logLines, err
+		}
+		logLines = append(logLines, fmt.Sprintf("action %d %s: %s", i+1, action.Type, detail))
+	}
+	return logLines, nil
+}
+
+// executeAction performs a single action and describes what it did
+func (s *Service) executeAction(ctx context.Context, automation *domain.Automation, run *domain.AutomationRun, action domain.AutomationAction) (string, error) {
+	// changes are audited as made by the automation, in the request that triggered it
+	actor := actorFrom(ctx)
+	actor.Name = fmt.Sprintf("automation:%d", automation.ID)
+	ctx = WithActor(ctx, actor)
+
+	switch action.Type {
+	case domain.ActionUpdateField:
+		var req domain.UpdateUserRequest
+		if key, ok := strings.CutPrefix(action.Field, segment.CustomFieldPrefix); ok {
+			req.CustomFields = map[string]any{key: action.Value}
+		} else if text, ok := action.Value.(string); ok && action.Field == segment.FieldName {
+			req.Name = &text
+		} else if text, ok := action.Value.(string); ok && action.Field == segment.FieldEmail {
+			req.Email = &text
+		}
+		if err := s.updateUser(ctx, run.EntityID, req, 0, run.Depth+1); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("set %s to %v", action.Field, action.Value), nil
+
+	case domain.ActionAddTag:
+		var added int
+		err := s.auditUsers(ctx, []int{run.EntityID}, func(ctx context.Context) (err error) {
+			added, err = s.repo.AddTags(ctx, run.Entity, []int{run.EntityID}, []string{action.Tag})
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		s.markForRescore(run.EntityID)
+		if added == 0 {
+			return fmt.Sprintf("already tagged %s", action.Tag), nil
+		}
+		return fmt.Sprintf("tagged %s", action.Tag), nil
+
+	case domain.ActionAssignOwner:
+		err := s.auditUsers(ctx, []int{run.EntityID}, func(ctx context.Context) error {
+			_, err := s.repo.AssignOwner(ctx, run.Entity, []int{run.EntityID}, action.OwnerID, nil, domain.AssignmentReasonAutomation)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("assigned to user %d", action.OwnerID), nil
+
+	case domain.ActionCallWebhook:
+		status, err := s.callAutomationWebhook(ctx, automation, run, action.URL)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s answered %d", action.URL, status), nil
+	}
+	return "", fmt.Errorf("unsupported action %q", action.Type)
+}
+
+// callAutomationWebhook posts the record to the URL, treating any non-2xx answer as a failure
+func (s *Service) callAutomationWebhook(ctx context.Context, automation *domain.Automation, run *domain.AutomationRun, target string) (int, error) {
+	user, err := s.repo.GetUser(ctx, run.EntityID)
+	if err != nil {
+		return 0, fmt.Errorf("get user: %w", err)
+	}
+	body, err := json.Marshal(map[string]any{
+		"automation_id": automation.ID,
+		"run_id":        run.ID,
+		"entity":        run.Entity,
+		"entity_id":     run.EntityID,
+		"record":        toUserResponse(user),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%s answered %d", target, resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// StartAutomations runs the automation worker until ctx is done, executing due
+// runs every poll interval and looking for reached dates once a minute
+func (s *Service) StartAutomations(ctx context.Context, poll time.Duration) {
+	go func() {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+
+		var lastDateScan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if now.Sub(lastDateScan) >= time.Minute {
+					lastDateScan = now
+					if _, err := s.ScheduleDateAutomations(ctx, now); err != nil {
+						log.Printf("Error scheduling date automations: %v", err)
+					}
+				}
+				if _, err := s.RunAutomations(ctx); err != nil {
+					log.Printf("Error running automations: %v", err)
+				}
+			}
+		}
+	}()
+}
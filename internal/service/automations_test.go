@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runAllAutomations runs the worker until the queue has nothing due
+func runAllAutomations(t *testing.T, service *Service) {
+	t.Helper()
+	for i := 0; i < 20; i++ {
+		processed, err := service.RunAutomations(context.Background())
+		require.NoError(t, err)
+		if processed == 0 {
+			return
+		}
+	}
+	t.Fatal("automation queue never drained")
+}
+
+func TestAutomations(t *testing.T) {
+	ctx := context.Background()
+	newService := func(t *testing.T) *Service {
+		service := NewService(repository.NewMockRepository())
+		_, err := service.CreateCustomField(ctx, domain.CreateCustomFieldRequest{
+			Entity: "user", Key: "stage", Label: "Stage", Type: domain.CustomFieldSelect, Options: []string{"new", "proposal", "won"},
+		})
+		require.NoError(t, err)
+		return service
+	}
+	stage := func(value string) domain.UpdateUserRequest {
+		return domain.UpdateUserRequest{CustomFields: map[string]any{"stage": value}}
+	}
+
+	t.Run("field changed runs actions", func(t *testing.T) {
+		service := newService(t)
+		automationID, err := service.CreateAutomation(ctx, domain.CreateAutomationRequest{
+			Name:      "Proposal follow up",
+			Entity:    "user",
+			Trigger:   domain.AutomationTrigger{Type: domain.TriggerFieldChanged, Field: "cf.stage"},
+			Condition: "cf.stage:proposal",
+			Actions:   []domain.AutomationAction{{Type: domain.ActionAddTag, Tag: "Follow-up"}},
+		})
+		require.NoError(t, err)
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Lead", Email: "lead@example.com"})
+		require.NoError(t, err)
+
+		// the condition doesn't match yet
+		require.NoError(t, service.UpdateUser(ctx, id, stage("new")))
+		require.NoError(t, service.UpdateUser(ctx, id, stage("proposal")))
+		runAllAutomations(t, service)
+
+		user, err := service.GetUser(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"follow-up"}, user.Tags)
+
+		runs, err := service.GetAutomationRuns(ctx, automationID)
+		require.NoError(t, err)
+		require.Len(t, runs, 1)
+		assert.Equal(t, domain.RunSucceeded, runs[0].Status)
+		assert.Contains(t, runs[0].Log, "tagged follow-up")
+	})
+
+	t.Run("loops are stopped", func(t *testing.T) {
+		service := newService(t)
+		for _, flip := range [][2]string{{"new", "proposal"}, {"proposal", "new"}} {
+			_, err := service.CreateAutomation(ctx, domain.CreateAutomationRequest{
+				Name:      "Flip " + flip[0],
+				Entity:    "user",
+				Trigger:   domain.AutomationTrigger{Type: domain.TriggerFieldChanged, Field: "cf.stage"},
+				Condition: "cf.stage:" + flip[0],
+				Actions:   []domain.AutomationAction{{Type: domain.ActionUpdateField, Field: "cf.stage", Value: flip[1]}},
+			})
+			require.NoError(t, err)
+		}
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Lead", Email: "lead@example.com"})
+		require.NoError(t, err)
+
+		require.NoError(t, service.UpdateUser(ctx, id, stage("new")))
+		runAllAutomations(t, service)
+
+		var skipped int
+		for _, automationID := range []int{1, 2} {
+			runs, err := service.GetAutomationRuns(ctx, automationID)
+			require.NoError(t, err)
+			for _, run := range runs {
+				if run.Status == domain.RunSkipped {
+					skipped++
+				}
+			}
+		}
+		assert.Equal(t, 1, skipped)
+	})
+
+	t.Run("failed webhooks are retried later", func(t *testing.T) {
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer receiver.Close()
+
+		service := newService(t)
+		automationID, err := service.CreateAutomation(ctx, domain.CreateAutomationRequest{
+			Name:    "Notify",
+			Entity:  "user",
+			Trigger: domain.AutomationTrigger{Type: domain.TriggerRecordCreated},
+			Actions: []domain.AutomationAction{{Type: domain.ActionCallWebhook, URL: receiver.URL}},
+		})
+		require.NoError(t, err)
+		_, err = service.CreateUser(ctx, domain.CreateUserRequest{Name: "Lead", Email: "lead@example.com"})
+		require.NoError(t, err)
+
+		runAllAutomations(t, service)
+
+		runs, err := service.GetAutomationRuns(ctx, automationID)
+		require.NoError(t, err)
+		require.Len(t, runs, 1)
+		assert.Equal(t, domain.RunPending, runs[0].Status)
+		assert.Equal(t, 1, runs[0].Attempts)
+		assert.Contains(t, runs[0].Error, "503")
+		assert.True(t, runs[0].NextAttemptAt.After(time.Now()))
+	})
+
+	t.Run("date triggers fire once", func(t *testing.T) {
+		service := newService(t)
+		_, err := service.CreateAutomation(ctx, domain.CreateAutomationRequest{
+			Name:    "Welcome",
+			Entity:  "user",
+			Trigger: domain.AutomationTrigger{Type: domain.TriggerDateReached, Field: "created_at"},
+			Actions: []domain.AutomationAction{{Type: domain.ActionAddTag, Tag: "welcomed"}},
+		})
+		require.NoError(t, err)
+		_, err = service.CreateUser(ctx, domain.CreateUserRequest{Name: "Lead", Email: "lead@example.com"})
+		require.NoError(t, err)
+
+		queued, err := service.ScheduleDateAutomations(ctx, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, 1, queued)
+		queued, err = service.ScheduleDateAutomations(ctx, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, 0, queued)
+	})
+}
+
+func TestCreateAutomationValidation(t *testing.T) {
+	service := NewService(repository.NewMockRepository())
+	tag := []domain.AutomationAction{{Type: domain.ActionAddTag, Tag: "x"}}
+
+	tests := []struct {
+		name    string
+		request domain.CreateAutomationRequest
+	}{
+		{"unknown trigger", domain.CreateAutomationRequest{Name: "A", Entity: "user", Trigger: domain.AutomationTrigger{Type: "stage_changed"}, Actions: tag}},
+		{"unknown field", domain.CreateAutomationRequest{Name: "A", Entity: "user", Trigger: domain.AutomationTrigger{Type: domain.TriggerFieldChanged, Field: "cf.missing"}, Actions: tag}},
+		{"date on text field", domain.CreateAutomationRequest{Name: "A", Entity: "user", Trigger: domain.AutomationTrigger{Type: domain.TriggerDateReached, Field: "name"}, Actions: tag}},
+		{"no actions", domain.CreateAutomationRequest{Name: "A", Entity: "user", Trigger: domain.AutomationTrigger{Type: domain.TriggerRecordCreated}}},
+		{"bad webhook url", domain.CreateAutomationRequest{Name: "A", Entity: "user", Trigger: domain.AutomationTrigger{Type: domain.TriggerRecordCreated}, Actions: []domain.AutomationAction{{Type: domain.ActionCallWebhook, URL: "ftp://example.com"}}}},
+		{"missing owner", domain.CreateAutomationRequest{Name: "A", Entity: "user", Trigger: domain.AutomationTrigger{Type: domain.TriggerRecordCreated}, Actions: []domain.AutomationAction{{Type: domain.ActionAssignOwner, OwnerID: 42}}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := service.CreateAutomation(context.Background(), tc.request)
+			assert.IsType(t, ValidationError(""), err)
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+)
+
+// bulk limits. Each batch is written in one transaction, an atomic request is
+// written in a single one.
+const (
+	MaxBulkOperations = 1000
+	bulkBatchSize     = 100
+)
+
+// ErrBulkRolledBack is the outcome of the operations of an atomic request
+// that weren't applied because another one failed
+var ErrBulkRolledBack = errors.New("not applied because another operation failed")
+
+// BulkOutcome is what happened to one operation of a bulk request: the ID of
+// its record and, when it wasn't applied, why
+type BulkOutcome struct {
+	ID  int
+	Err error
+}
+
+// bulkWrite is a prepared write and what is needed to follow it up
+type bulkWrite struct {
+	index   int
+	before  *domain.User
+	changed []string
+}
+
+// Bulk applies a batch of creates, updates and deletes and returns the
+// outcome of each operation in request order. Only users can be changed in
+// bulk for now.
+func (s *Service) Bulk(ctx context.Context, entity string, req domain.BulkRequest) ([]BulkOutcome, error) {
+	if entity != domain.EntityUser {
+		return nil, ValidationError(fmt.Sprintf("%s records can't be changed in bulk", entity))
+	}
+	if len(req.Operations) == 0 {
+		return nil, ValidationError("operations are required")
+	}
+	if len(req.Operations) > MaxBulkOperations {
+		return nil, ValidationError(fmt.Sprintf("at most %d operations can be sent at once", MaxBulkOperations))
+	}
+
+	outcomes := make([]BulkOutcome, len(req.Operations))
+	batchSize := bulkBatchSize
+	if req.Atomic {
+		batchSize = len(req.Operations)
+	}
+	for start := 0; start < len(req.Operations); start += batchSize {
+		end := min(start+batchSize, len(req.Operations))
+		if err := s.bulkUsers(ctx, req.Operations[start:end], outcomes[start:end], req.Atomic); err != nil {
+			// earlier batches are already written, so only this one fails
+			for i := start; i < end; i++ {
+				outcomes[i].Err = err
+			}
+		}
+	}
+	return outcomes, nil
+}
+
+// bulkUsers prepares and writes one batch of user operations, filling in
+// their outcomes. The users are read and written in one transaction, so none
+// of them can change between the checks and the writes.
+func (s *Service) bulkUsers(ctx context.Context, ops []domain.BulkOperation, outcomes []BulkOutcome, atomic bool) error {
+	var writes []domain.UserWrite
+	var prepared []bulkWrite
+	var errs []error
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		clear(outcomes)
+		writes, prepared, errs = nil, nil, nil
+
+		// read every user the batch touches at once, later operations on the
+		// same user see the earlier ones
+		var ids []int
+		for _, op := range ops {
+			if op.Op != domain.BulkCreate {
+				ids = append(ids, op.ID)
+			}
+		}
+		users := map[int]*domain.User{}
+		if len(ids) > 0 {
+			found, err := s.repo.GetUsersByID(ctx, ids)
+			if err != nil {
+				return fmt.Errorf("service error - get users: %w", err)
+			}
+			for _, user := range found {
+				users[user.ID] = user
+			}
+		}
+
+		for i, op := range ops {
+			write, followUp, err := s.prepareUserWrite(ctx, op, users)
+			outcomes[i].ID = op.ID
+			if err != nil {
+				outcomes[i].Err = err
+				if atomic {
+					rollBackOutcomes(outcomes)
+					writes = nil
+					return nil
+				}
+				continue
+			}
+			if write == nil {
+				// an update that changes nothing
+				continue
+			}
+			followUp.index = i
+			writes = append(writes, *write)
+			prepared = append(prepared, followUp)
+		}
+		if len(writes) == 0 {
+			return nil
+		}
+
+		var err error
+		errs, err = s.repo.WriteUsers(ctx, writes, atomic)
+		if err != nil {
+			return fmt.Errorf("service error - write users: %w", err)
+		}
+		if atomic && errors.Join(errs...) != nil {
+			return nil
+		}
+
+		// the writes that went through are audited with them
+		for i, write := range writes {
+			if errs[i] != nil {
+				continue
+			}
+			switch write.Op {
+			case domain.BulkCreate:
+				err = s.recordUserCreated(ctx, write.User)
+			case domain.BulkUpdate:
+				err = s.recordUserUpdated(ctx, prepared[i].before, &write.User)
+			case domain.BulkDelete:
+				err = s.audit(ctx, domain.EntityUser, write.User.ID, domain.AuditDelete, prepared[i].before, nil)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, write := range writes {
+		switch {
+		case errors.Is(errs[i], repository.ErrDuplicate):
+			outcomes[prepared[i].index].Err = emailTaken(write.User.Email)
+		case errs[i] != nil:
+			outcomes[prepared[i].index].Err = fmt.Errorf("service error - %s user: %w", write.Op, errs[i])
+		}
+	}
+	if atomic && errors.Join(errs...) != nil {
+		rollBackOutcomes(outcomes)
+		return nil
+	}
+
+	for i, write := range writes {
+		if errs[i] != nil {
+			continue
+		}
+		switch write.Op {
+		case domain.BulkCreate:
+			outcomes[prepared[i].index].ID = write.User.ID
+			s.userCreated(ctx, write.User)
+		case domain.BulkUpdate:
+			s.userUpdated(ctx, &write.User, prepared[i].changed, 0)
+		}
+	}
+	return nil
+}
+
+// prepareUserWrite validates an operation against the users read for its
+// batch and returns the write to make, nil when there is nothing to write
+func (s *Service) prepareUserWrite(ctx context.Context, op domain.BulkOperation, users map[int]*domain.User) (*domain.UserWrite, bulkWrite, error) {
+	if op.Op == domain.BulkCreate {
+		req := domain.CreateUserRequest{CustomFields: op.Data.CustomFields}
+		if op.Data.Name != nil {
+			req.Name = strings.TrimSpace(*op.Data.Name)
+		}
+		if op.Data.Email != nil {
+			req.Email = strings.TrimSpace(*op.Data.Email)
+		}
+		if req.Name == "" || req.Email == "" {
+			return nil, bulkWrite{}, ValidationError("name and email are required")
+		}
+		user, err := s.newUser(ctx, req)
+		if err != nil {
+			return nil, bulkWrite{}, err
+		}
+		return &domain.UserWrite{Op: op.Op, User: user}, bulkWrite{}, nil
+	}
+	if op.Op != domain.BulkUpdate && op.Op != domain.BulkDelete {
+		return nil, bulkWrite{}, ValidationError(fmt.Sprintf("unknown operation %q", op.Op))
+	}
+
+	current, exists := users[op.ID]
+	if !exists {
+		return nil, bulkWrite{}, fmt.Errorf("service error - get user: %w", repository.ErrNotFound)
+	}
+	if op.Version != 0 && current.Version != op.Version {
+		return nil, bulkWrite{}, fmt.Errorf("service error - %s user: %w", op.Op, repository.ErrVersionConflict)
+	}
+	before := *current
+
+	if op.Op == domain.BulkDelete {
+		delete(users, op.ID)
+		write := domain.UserWrite{Op: op.Op, User: before, DeletedBy: actorFrom(ctx).Name}
+		return &write, bulkWrite{before: &before}, nil
+	}
+
+	updated := before
+	changed, err := s.applyUserUpdate(ctx, &updated, op.Data)
+	if err != nil || len(changed) == 0 {
+		return nil, bulkWrite{}, err
+	}
+	next := updated
+	next.Version++
+	users[op.ID] = &next
+	return &domain.UserWrite{Op: op.Op, User: updated}, bulkWrite{before: &before, changed: changed}, nil
+}
+
+// rollBackOutcomes marks every operation of a failed atomic batch that didn't
+// fail itself as rolled back
+func rollBackOutcomes(outcomes []BulkOutcome) {
+	for i := range outcomes {
+		if outcomes[i].Err == nil {
+			outcomes[i].Err = ErrBulkRolledBack
+		}
+	}
+}
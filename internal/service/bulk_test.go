@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulk(t *testing.T) {
+	ctx := WithActor(context.Background(), domain.Actor{Name: "billing-sync"})
+	ptr := func(s string) *string { return &s }
+
+	t.Run("operations succeed or fail on their own", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		ada, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+		grace, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Grace", Email: "grace@example.com"})
+		require.NoError(t, err)
+
+		outcomes, err := service.Bulk(ctx, domain.EntityUser, domain.BulkRequest{Operations: []domain.BulkOperation{
+			{Op: domain.BulkCreate, Data: domain.UpdateUserRequest{Name: ptr("Alan"), Email: ptr("alan@example.com")}},
+			{Op: domain.BulkUpdate, ID: ada, Data: domain.UpdateUserRequest{Name: ptr("Ada Lovelace")}},
+			{Op: domain.BulkUpdate, ID: ada, Version: 1, Data: domain.UpdateUserRequest{Name: ptr("Augusta")}},
+			{Op: domain.BulkDelete, ID: grace},
+			{Op: domain.BulkUpdate, ID: 99, Data: domain.UpdateUserRequest{Name: ptr("Nobody")}},
+			{Op: domain.BulkCreate, Data: domain.UpdateUserRequest{Name: ptr("No email")}},
+			{Op: "merge", ID: ada},
+		}})
+		require.NoError(t, err)
+		require.Len(t, outcomes, 7)
+
+		assert.NoError(t, outcomes[0].Err)
+		assert.NotZero(t, outcomes[0].ID)
+		assert.NoError(t, outcomes[1].Err)
+		assert.True(t, errors.Is(outcomes[2].Err, repository.ErrVersionConflict), "expected a stale version to conflict, got %v", outcomes[2].Err)
+		assert.NoError(t, outcomes[3].Err)
+		assert.True(t, errors.Is(outcomes[4].Err, repository.ErrNotFound), "expected a missing user, got %v", outcomes[4].Err)
+		assert.ErrorAs(t, outcomes[5].Err, new(ValidationError))
+		assert.ErrorAs(t, outcomes[6].Err, new(ValidationError))
+
+		user, err := service.GetUser(ctx, ada)
+		require.NoError(t, err)
+		assert.Equal(t, "Ada Lovelace", user.Name)
+		_, err = service.GetUser(ctx, grace)
+		assert.True(t, errors.Is(err, repository.ErrNotFound), "expected the deleted user in the trash, got %v", err)
+		created, err := service.GetUser(ctx, outcomes[0].ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Alan", created.Name)
+
+		// the usual follow ups run for each applied operation
+		events, err := service.GetAuditEvents(ctx, domain.AuditFilter{Entity: domain.EntityUser, EntityID: ada})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, "billing-sync", events[1].Actor)
+	})
+
+	t.Run("atomic requests apply all or nothing", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		ada, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+
+		outcomes, err := service.Bulk(ctx, domain.EntityUser, domain.BulkRequest{Atomic: true, Operations: []domain.BulkOperation{
+			{Op: domain.BulkCreate, Data: domain.UpdateUserRequest{Name: ptr("Alan"), Email: ptr("alan@example.com")}},
+			{Op: domain.BulkUpdate, ID: ada, Data: domain.UpdateUserRequest{Name: ptr("Ada Lovelace")}},
+			{Op: domain.BulkDelete, ID: 99},
+		}})
+		require.NoError(t, err)
+		assert.ErrorIs(t, outcomes[0].Err, ErrBulkRolledBack)
+		assert.ErrorIs(t, outcomes[1].Err, ErrBulkRolledBack)
+		assert.True(t, errors.Is(outcomes[2].Err, repository.ErrNotFound), "expected a missing user, got %v", outcomes[2].Err)
+
+		users, err := service.GetUsers(ctx, domain.ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, "Ada", users[0].Name)
+
+		outcomes, err = service.Bulk(ctx, domain.EntityUser, domain.BulkRequest{Atomic: true, Operations: []domain.BulkOperation{
+			{Op: domain.BulkUpdate, ID: ada, Data: domain.UpdateUserRequest{Name: ptr("Ada Lovelace")}},
+			{Op: domain.BulkUpdate, ID: ada, Data: domain.UpdateUserRequest{Email: ptr("ada@lovelace.example")}},
+		}})
+		require.NoError(t, err)
+		assert.NoError(t, errors.Join(outcomes[0].Err, outcomes[1].Err))
+		user, err := service.GetUser(ctx, ada)
+		require.NoError(t, err)
+		assert.Equal(t, "Ada Lovelace", user.Name)
+		assert.Equal(t, "ada@lovelace.example", user.Email)
+		assert.Equal(t, 3, user.Version)
+	})
+
+	t.Run("requests are checked", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		_, err := service.Bulk(ctx, "deal", domain.BulkRequest{Operations: []domain.BulkOperation{{Op: domain.BulkDelete, ID: 1}}})
+		assert.ErrorAs(t, err, new(ValidationError))
+		_, err = service.Bulk(ctx, domain.EntityUser, domain.BulkRequest{})
+		assert.ErrorAs(t, err, new(ValidationError))
+		_, err = service.Bulk(ctx, domain.EntityUser, domain.BulkRequest{Operations: make([]domain.BulkOperation, MaxBulkOperations+1)})
+		assert.ErrorAs(t, err, new(ValidationError))
+	})
+}
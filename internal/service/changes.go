@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// changeBuffer is how many changes a subscriber can fall behind by before it
+// misses some
+const changeBuffer = 64
+
+// changeListenRetry is how long the change feed waits before listening again
+// after losing the database
+const changeListenRetry = 5 * time.Second
+
+// changeFeed fans the changes the repository reports out to subscribers
+type changeFeed struct {
+	mu          sync.Mutex
+	subscribers map[chan domain.ChangeEvent]string
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{subscribers: make(map[chan domain.ChangeEvent]string)}
+}
+
+// publish hands a change to every subscriber following its entity. A
+// subscriber that is too far behind misses it rather than holding up the
+// others.
+func (f *changeFeed) publish(change domain.ChangeEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for changes, entity := range f.subscribers {
+		if entity != "" && entity != change.Entity {
+			continue
+		}
+		select {
+		case changes <- change:
+		default:
+		}
+	}
+}
+
+// StartChangeFeed listens for record changes until ctx is done and passes them
+// to the subscribers of SubscribeChanges, listening again after a failure
+func (s *Service) StartChangeFeed(ctx context.Context) {
+	go func() {
+		for {
+			err := s.repo.ListenChanges(ctx, s.changes.publish)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Error listening for changes, retrying in %s: %v", changeListenRetry, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(changeListenRetry):
+			}
+		}
+	}()
+}
+
+// SubscribeChanges returns a channel receiving the changes made to records of
+// entity, or to all records when entity is empty, and a func ending the
+// subscription. Changes only say which record changed, subscribers read it
+// through the service to see how.
+func (s *Service) SubscribeChanges(entity string) (<-chan domain.ChangeEvent, func(), error) {
+	if entity != "" && !supportedEntities[entity] {
+		return nil, nil, ValidationError(fmt.Sprintf("unsupported entity %q", entity))
+	}
+
+	changes := make(chan domain.ChangeEvent, changeBuffer)
+	s.changes.mu.Lock()
+	s.changes.subscribers[changes] = entity
+	s.changes.mu.Unlock()
+
+	unsubscribe := func() {
+		s.changes.mu.Lock()
+		delete(s.changes.subscribers, changes)
+		s.changes.mu.Unlock()
+	}
+	return changes, unsubscribe, nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeFeed(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("subscribers get the changes of their entity", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		users, unsubscribeUsers, err := service.SubscribeChanges(domain.EntityUser)
+		require.NoError(t, err)
+		all, unsubscribeAll, err := service.SubscribeChanges("")
+		require.NoError(t, err)
+		defer unsubscribeAll()
+
+		service.changes.publish(domain.ChangeEvent{Entity: "deal", ID: 1, Op: domain.ChangeCreated})
+		service.changes.publish(domain.ChangeEvent{Entity: domain.EntityUser, ID: 2, Op: domain.ChangeUpdated})
+
+		assert.Equal(t, 2, (<-users).ID)
+		assert.Empty(t, users, "expected other entities to be filtered out")
+		assert.Equal(t, 1, (<-all).ID)
+		assert.Equal(t, 2, (<-all).ID)
+
+		unsubscribeUsers()
+		service.changes.publish(domain.ChangeEvent{Entity: domain.EntityUser, ID: 3, Op: domain.ChangeDeleted})
+		assert.Empty(t, users, "expected nothing after unsubscribing")
+		assert.Equal(t, 3, (<-all).ID)
+	})
+
+	t.Run("slow subscribers miss changes instead of blocking", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		changes, unsubscribe, err := service.SubscribeChanges("")
+		require.NoError(t, err)
+		defer unsubscribe()
+
+		for i := 0; i < changeBuffer+10; i++ {
+			service.changes.publish(domain.ChangeEvent{Entity: domain.EntityUser, ID: i})
+		}
+		assert.Len(t, changes, changeBuffer)
+	})
+
+	t.Run("unsupported entities are rejected", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		_, _, err := service.SubscribeChanges("spaceship")
+		assert.IsType(t, ValidationError(""), err)
+	})
+
+	t.Run("writes reach subscribers", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		feedCtx, stop := context.WithCancel(ctx)
+		defer stop()
+		changes, unsubscribe, err := service.SubscribeChanges(domain.EntityUser)
+		require.NoError(t, err)
+		defer unsubscribe()
+		service.StartChangeFeed(feedCtx)
+		// let the listener see the store before the write
+		time.Sleep(50 * time.Millisecond)
+
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+		select {
+		case change := <-changes:
+			assert.Equal(t, domain.ChangeEvent{Entity: domain.EntityUser, ID: id, Op: domain.ChangeCreated, At: change.At}, change)
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for the change")
+		}
+	})
+}
@@ -0,0 +1,351 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+)
+
+// consent limits
+const (
+	MaxConsentPurpose = 50
+	MaxConsentSource  = 100
+)
+
+// UnsubscribePath is the public one-click unsubscribe endpoint, relative to
+// EmailSettings.TrackingURL
+const UnsubscribePath = "/u/"
+
+// SourceUnsubscribeLink is the consent source of unsubscribe link clicks
+const SourceUnsubscribeLink = "unsubscribe_link"
+
+// consentChannels lists the channels consent can be recorded for
+var consentChannels = map[string]bool{
+	domain.ChannelEmail: true,
+	domain.ChannelSMS:   true,
+	domain.ChannelPhone: true,
+}
+
+// lawfulBases lists the GDPR Article 6 bases
+var lawfulBases = map[string]bool{
+	domain.BasisConsent:            true,
+	domain.BasisContract:           true,
+	domain.BasisLegalObligation:    true,
+	domain.BasisVitalInterests:     true,
+	domain.BasisPublicTask:         true,
+	domain.BasisLegitimateInterest: true,
+}
+
+// suppressionReasons lists the reasons an address can be suppressed for
+var suppressionReasons = map[string]bool{
+	domain.SuppressionUnsubscribed: true,
+	domain.SuppressionBounced:      true,
+	domain.SuppressionComplaint:    true,
+	domain.SuppressionManual:       true,
+}
+
+// consentPurposePattern keeps purposes to simple keys such as "marketing"
+var consentPurposePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// RecordConsent records a change to a record's consent. Withdrawing email
+// marketing consent suppresses the record's address and stops its sequences,
+// granting it again lifts a suppression that came from unsubscribing.
+func (s *Service) RecordConsent(ctx context.Context, req domain.ConsentRequest) (int, error) {
+	if !supportedEntities[req.Entity] {
+		return 0, ValidationError(fmt.Sprintf("unsupported entity %q", req.Entity))
+	}
+	if !consentChannels[req.Channel] {
+		return 0, ValidationError(fmt.Sprintf("unknown channel %q", req.Channel))
+	}
+	purpose := strings.TrimSpace(req.Purpose)
+	if purpose == "" {
+		purpose = domain.PurposeMarketing
+	}
+	if len(purpose) > MaxConsentPurpose || !consentPurposePattern.MatchString(purpose) {
+		return 0, ValidationError(fmt.Sprintf("purposes are lowercase keys of up to %d characters", MaxConsentPurpose))
+	}
+	if req.Status != domain.ConsentGranted && req.Status != domain.ConsentWithdrawn {
+		return 0, ValidationError(fmt.Sprintf("status must be %q or %q", domain.ConsentGranted, domain.ConsentWithdrawn))
+	}
+	if !lawfulBases[req.LawfulBasis] {
+		return 0, ValidationError(fmt.Sprintf("unknown lawful basis %q", req.LawfulBasis))
+	}
+	source := strings.TrimSpace(req.Source)
+	if source == "" {
+		return 0, ValidationError("source is required")
+	}
+	if len(source) > MaxConsentSource {
+		return 0, ValidationError(fmt.Sprintf("sources are limited to %d characters", MaxConsentSource))
+	}
+	if _, err := s.repo.GetUser(ctx, req.EntityID); err != nil {
+		return 0, fmt.Errorf("service error - get user: %w", err)
+	}
+
+	return s.recordConsent(ctx, domain.Consent{
+		Entity:      req.Entity,
+		EntityID:    req.EntityID,
+		Channel:     req.Channel,
+		Purpose:     purpose,
+		Status:      req.Status,
+		LawfulBasis: req.LawfulBasis,
+		Source:      source,
+	})
+}
+
+// recordConsent stores a consent change and applies it to the suppression
+// list and sequences, all in one unit of work: an unsubscribe that fails part
+// way is retried whole rather than seen as done
+func (s *Service) recordConsent(ctx context.Context, consent domain.Consent) (int, error) {
+	var id int
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.repo.CreateConsent(ctx, consent)
+		if err != nil {
+			return fmt.Errorf("service error - create consent: %w", err)
+		}
+		consent.ID = id
+		if err := s.audit(ctx, domain.AuditConsent, id, domain.AuditCreate, nil, consent); err != nil {
+			return err
+		}
+		return s.applyConsent(ctx, consent)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// applyConsent suppresses the address of a record that withdrew its email
+// marketing consent and stops its sequences, or lifts the suppression when
+// the consent is granted again
+func (s *Service) applyConsent(ctx context.Context, consent domain.Consent) error {
+	if consent.Channel != domain.ChannelEmail || consent.Purpose != domain.PurposeMarketing {
+		return nil
+	}
+
+	user, err := s.repo.GetUser(ctx, consent.EntityID)
+	if err != nil {
+		return fmt.Errorf("service error - get user: %w", err)
+	}
+	address := strings.ToLower(user.Email)
+	if consent.Status == domain.ConsentGranted {
+		if address == "" {
+			return nil
+		}
+		return s.liftSuppression(ctx, address, domain.SuppressionUnsubscribed)
+	}
+
+	if address != "" {
+		if err := s.addSuppression(ctx, domain.EmailSuppression{
+			Email:  address,
+			Reason: domain.SuppressionUnsubscribed,
+			Source: consent.Source,
+		}); err != nil {
+			return err
+		}
+	}
+	return s.stopSequences(ctx, consent.Entity, consent.EntityID, domain.EnrollmentUnsubscribed)
+}
+
+// GetConsents returns a record's current consents and their history
+func (s *Service) GetConsents(ctx context.Context, entity string, id int) (*domain.ConsentSummary, error) {
+	if !supportedEntities[entity] {
+		return nil, ValidationError(fmt.Sprintf("unsupported entity %q", entity))
+	}
+	history, err := s.repo.GetConsents(ctx, entity, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get consents: %w", err)
+	}
+
+	summary := &domain.ConsentSummary{Current: []*domain.Consent{}, History: []*domain.Consent{}}
+	seen := map[string]bool{}
+	for _, consent := range history {
+		summary.History = append(summary.History, consent)
+		key := consent.Channel + "/" + consent.Purpose
+		if !seen[key] {
+			seen[key] = true
+			summary.Current = append(summary.Current, consent)
+		}
+	}
+	return summary, nil
+}
+
+// UnsubscribeURL returns the signed link that unsubscribes a record from
+// marketing email
+func (s *Service) UnsubscribeURL(entity string, id int) string {
+	payload := entity + "." + strconv.Itoa(id)
+	return s.emailSettings.TrackingURL + UnsubscribePath + payload + "." + s.signUnsubscribe(payload)
+}
+
+// signUnsubscribe computes the signature of an unsubscribe link payload
+func (s *Service) signUnsubscribe(payload string) string {
+	mac := hmac.New(sha256.New, []byte(s.emailSettings.UnsubscribeSecret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Unsubscribe withdraws the email marketing consent of the record an
+// unsubscribe link was made for. Using a link again changes nothing.
+func (s *Service) Unsubscribe(ctx context.Context, token, ip, userAgent string) error {
+	entity, id, ok := s.parseUnsubscribeToken(token)
+	if !ok {
+		return ValidationError("invalid unsubscribe link")
+	}
+
+	history, err := s.repo.GetConsents(ctx, entity, id)
+	if err != nil {
+		return fmt.Errorf("service error - get consents: %w", err)
+	}
+	for _, consent := range history {
+		if consent.Channel == domain.ChannelEmail && consent.Purpose == domain.PurposeMarketing {
+			if consent.Status == domain.ConsentWithdrawn {
+				return nil
+			}
+			break
+		}
+	}
+
+	_, err = s.recordConsent(ctx, domain.Consent{
+		Entity:      entity,
+		EntityID:    id,
+		Channel:     domain.ChannelEmail,
+		Purpose:     domain.PurposeMarketing,
+		Status:      domain.ConsentWithdrawn,
+		LawfulBasis: domain.BasisConsent,
+		Source:      SourceUnsubscribeLink,
+		IP:          ip,
+		UserAgent:   userAgent,
+	})
+	return err
+}
+
+// parseUnsubscribeToken checks the signature of an unsubscribe token and
+// returns the record it was made for
+func (s *Service) parseUnsubscribeToken(token string) (string, int, bool) {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return "", 0, false
+	}
+	payload, signature := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.signUnsubscribe(payload))) {
+		return "", 0, false
+	}
+	entity, rawID, ok := strings.Cut(payload, ".")
+	id, err := strconv.Atoi(rawID)
+	if !ok || err != nil || !supportedEntities[entity] {
+		return "", 0, false
+	}
+	return entity, id, true
+}
+
+// CreateSuppression adds an address to the suppression list by hand
+func (s *Service) CreateSuppression(ctx context.Context, req domain.CreateSuppressionRequest) (*domain.EmailSuppression, error) {
+	address, err := mail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil || address.Name != "" {
+		return nil, ValidationError("a valid email address is required")
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = domain.SuppressionManual
+	}
+	if !suppressionReasons[reason] {
+		return nil, ValidationError(fmt.Sprintf("unknown suppression reason %q", reason))
+	}
+
+	email := strings.ToLower(address.Address)
+	if err := s.addSuppression(ctx, domain.EmailSuppression{Email: email, Reason: reason, Source: "api"}); err != nil {
+		return nil, err
+	}
+	suppression, err := s.repo.GetSuppression(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get suppression: %w", err)
+	}
+	return suppression, nil
+}
+
+// GetSuppressions lists the suppressed addresses
+func (s *Service) GetSuppressions(ctx context.Context) ([]*domain.EmailSuppression, error) {
+	suppressions, err := s.repo.GetSuppressions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get suppressions: %w", err)
+	}
+	if suppressions == nil {
+		suppressions = []*domain.EmailSuppression{}
+	}
+	return suppressions, nil
+}
+
+// DeleteSuppression lets email reach an address again
+func (s *Service) DeleteSuppression(ctx context.Context, id int) error {
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.DeleteSuppression(ctx, id); err != nil {
+			return fmt.Errorf("service error - delete suppression: %w", err)
+		}
+		return s.audit(ctx, domain.AuditSuppression, id, domain.AuditDelete, nil, nil)
+	})
+}
+
+// addSuppression suppresses an address unless it already is, auditing new entries
+func (s *Service) addSuppression(ctx context.Context, suppression domain.EmailSuppression) error {
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		existing, err := s.suppression(ctx, suppression.Email)
+		if err != nil || existing != nil {
+			return err
+		}
+		if err := s.repo.AddSuppression(ctx, suppression); err != nil {
+			return fmt.Errorf("service error - add suppression: %w", err)
+		}
+		added, err := s.suppression(ctx, suppression.Email)
+		if err != nil || added == nil {
+			return err
+		}
+		return s.audit(ctx, domain.AuditSuppression, added.ID, domain.AuditCreate, nil, added)
+	})
+}
+
+// liftSuppression removes an address's suppression if it has the given reason
+func (s *Service) liftSuppression(ctx context.Context, address, reason string) error {
+	existing, err := s.suppression(ctx, address)
+	if err != nil || existing == nil || existing.Reason != reason {
+		return err
+	}
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.LiftSuppression(ctx, existing.Email, reason); err != nil {
+			return fmt.Errorf("service error - lift suppression: %w", err)
+		}
+		return s.audit(ctx, domain.AuditSuppression, existing.ID, domain.AuditDelete, existing, nil)
+	})
+}
+
+// suppression returns the suppression of an address, or nil when email can be sent to it
+func (s *Service) suppression(ctx context.Context, address string) (*domain.EmailSuppression, error) {
+	suppression, err := s.repo.GetSuppression(ctx, strings.ToLower(address))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("service error - get suppression: %w", err)
+	}
+	return suppression, nil
+}
+
+// newUnsubscribeSecret makes a random key for signing unsubscribe links
+// when none is configured
+func newUnsubscribeSecret() string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return hex.EncodeToString(key)
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/mailer"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unsuppressibleRepository can't add to the suppression list while failing is set
+type unsuppressibleRepository struct {
+	*repository.MockRepository
+	failing bool
+}
+
+func (r *unsuppressibleRepository) AddSuppression(ctx context.Context, suppression domain.EmailSuppression) error {
+	if r.failing {
+		return errors.New("suppression list unavailable")
+	}
+	return r.MockRepository.AddSuppression(ctx, suppression)
+}
+
+func TestConsent(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*Service, *mailer.Capture, int) {
+		service := NewService(repository.NewMockRepository())
+		capture := mailer.NewCapture()
+		service.UseMailer(capture, EmailSettings{From: "sales@agency.example", TrackingURL: "https://crm.example", UnsubscribeSecret: "secret"})
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "Ada@Example.com"})
+		require.NoError(t, err)
+		return service, capture, id
+	}
+
+	t.Run("emails carry a one-click unsubscribe link", func(t *testing.T) {
+		service, capture, ada := setup(t)
+		_, err := service.SendEmail(ctx, domain.EntityUser, ada, domain.SendEmailRequest{
+			Subject:  "News",
+			BodyHTML: `<a href="{{.UnsubscribeURL}}">Unsubscribe</a>`,
+		})
+		require.NoError(t, err)
+
+		msg := capture.Messages()[0]
+		link := service.UnsubscribeURL(domain.EntityUser, ada)
+		assert.Equal(t, "<"+link+">", msg.Headers["List-Unsubscribe"])
+		assert.Equal(t, "List-Unsubscribe=One-Click", msg.Headers["List-Unsubscribe-Post"])
+		// the link isn't rewritten for click tracking
+		assert.Contains(t, msg.HTML, `href="`+link+`"`)
+
+		token := strings.TrimPrefix(link, "https://crm.example"+UnsubscribePath)
+		require.NoError(t, service.Unsubscribe(ctx, token, "203.0.113.7", "Mail"))
+		require.NoError(t, service.Unsubscribe(ctx, token, "203.0.113.7", "Mail"))
+
+		consents, err := service.GetConsents(ctx, domain.EntityUser, ada)
+		require.NoError(t, err)
+		require.Len(t, consents.History, 1)
+		assert.Equal(t, domain.ConsentWithdrawn, consents.Current[0].Status)
+		assert.Equal(t, SourceUnsubscribeLink, consents.Current[0].Source)
+		assert.Equal(t, "203.0.113.7", consents.Current[0].IP)
+
+		// every send path now skips the address
+		_, err = service.SendEmail(ctx, domain.EntityUser, ada, domain.SendEmailRequest{Subject: "News", BodyText: "Hi"})
+		assert.IsType(t, ValidationError(""), err)
+		assert.Len(t, capture.Messages(), 1)
+	})
+
+	t.Run("tampered unsubscribe links are rejected", func(t *testing.T) {
+		service, _, ada := setup(t)
+		token := strings.TrimPrefix(service.UnsubscribeURL(domain.EntityUser, ada), "https://crm.example"+UnsubscribePath)
+		forged := strings.Replace(token, "user.1.", "user.2.", 1)
+		for _, bad := range []string{forged, "user.1", "", "nonsense"} {
+			assert.IsType(t, ValidationError(""), service.Unsubscribe(ctx, bad, "", ""), "token %q", bad)
+		}
+	})
+
+	t.Run("consent changes are kept as history", func(t *testing.T) {
+		service, _, ada := setup(t)
+		for _, status := range []string{domain.ConsentGranted, domain.ConsentWithdrawn, domain.ConsentGranted} {
+			_, err := service.RecordConsent(ctx, domain.ConsentRequest{
+				Entity:      domain.EntityUser,
+				EntityID:    ada,
+				Channel:     domain.ChannelEmail,
+				Status:      status,
+				LawfulBasis: domain.BasisConsent,
+				Source:      "web_form",
+			})
+			require.NoError(t, err)
+		}
+		_, err := service.RecordConsent(ctx, domain.ConsentRequest{
+			Entity:      domain.EntityUser,
+			EntityID:    ada,
+			Channel:     domain.ChannelPhone,
+			Purpose:     "sales_calls",
+			Status:      domain.ConsentGranted,
+			LawfulBasis: domain.BasisLegitimateInterest,
+			Source:      "call",
+		})
+		require.NoError(t, err)
+
+		consents, err := service.GetConsents(ctx, domain.EntityUser, ada)
+		require.NoError(t, err)
+		assert.Len(t, consents.History, 4)
+		require.Len(t, consents.Current, 2)
+		assert.Equal(t, domain.ChannelPhone, consents.Current[0].Channel)
+		assert.Equal(t, domain.PurposeMarketing, consents.Current[1].Purpose)
+		assert.Equal(t, domain.ConsentGranted, consents.Current[1].Status)
+
+		// granting consent again lifted the suppression the withdrawal added
+		suppressions, err := service.GetSuppressions(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, suppressions)
+
+		for _, req := range []domain.ConsentRequest{
+			{Entity: domain.EntityUser, EntityID: ada, Channel: "fax", Status: domain.ConsentGranted, LawfulBasis: domain.BasisConsent, Source: "x"},
+			{Entity: domain.EntityUser, EntityID: ada, Channel: domain.ChannelEmail, Status: "maybe", LawfulBasis: domain.BasisConsent, Source: "x"},
+			{Entity: domain.EntityUser, EntityID: ada, Channel: domain.ChannelEmail, Status: domain.ConsentGranted, LawfulBasis: "because", Source: "x"},
+			{Entity: domain.EntityUser, EntityID: ada, Channel: domain.ChannelEmail, Status: domain.ConsentGranted, LawfulBasis: domain.BasisConsent},
+			{Entity: domain.EntityUser, EntityID: ada, Channel: domain.ChannelEmail, Purpose: "Bad Purpose", Status: domain.ConsentGranted, LawfulBasis: domain.BasisConsent, Source: "x"},
+		} {
+			_, err := service.RecordConsent(ctx, req)
+			assert.IsType(t, ValidationError(""), err, "request %+v", req)
+		}
+	})
+
+	t.Run("withdrawing consent stops sequences", func(t *testing.T) {
+		service, capture, ada := setup(t)
+		template, err := service.CreateEmailTemplate(ctx, domain.CreateEmailTemplateRequest{Name: "Intro", Subject: "Hi", BodyText: "Hello"})
+		require.NoError(t, err)
+		sequence, err := service.CreateSequence(ctx, domain.CreateSequenceRequest{
+			Name:   "Outreach",
+			Entity: domain.EntityUser,
+			Steps:  []domain.SequenceStep{{Type: domain.StepEmail, TemplateID: template}},
+		})
+		require.NoError(t, err)
+		_, err = service.Enroll(ctx, sequence, domain.EnrollmentRequest{Entity: domain.EntityUser, IDs: []int{ada}})
+		require.NoError(t, err)
+
+		_, err = service.RecordConsent(ctx, domain.ConsentRequest{
+			Entity:      domain.EntityUser,
+			EntityID:    ada,
+			Channel:     domain.ChannelEmail,
+			Status:      domain.ConsentWithdrawn,
+			LawfulBasis: domain.BasisConsent,
+			Source:      "phone_call",
+		})
+		require.NoError(t, err)
+
+		ran, err := service.RunSequences(ctx, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, 0, ran)
+		assert.Empty(t, capture.Messages())
+		got, err := service.GetSequence(ctx, sequence)
+		require.NoError(t, err)
+		assert.Equal(t, 1, got.Stats.Unsubscribed)
+	})
+
+	t.Run("an unsubscribe that fails part way leaves nothing behind", func(t *testing.T) {
+		repo := &unsuppressibleRepository{MockRepository: repository.NewMockRepository(), failing: true}
+		service := NewService(repo)
+		service.UseMailer(mailer.NewCapture(), EmailSettings{TrackingURL: "https://crm.example", UnsubscribeSecret: "secret"})
+		ada, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+		template, err := service.CreateEmailTemplate(ctx, domain.CreateEmailTemplateRequest{Name: "Intro", Subject: "Hi", BodyText: "Hello"})
+		require.NoError(t, err)
+		sequence, err := service.CreateSequence(ctx, domain.CreateSequenceRequest{
+			Name:   "Outreach",
+			Entity: domain.EntityUser,
+			Steps:  []domain.SequenceStep{{Type: domain.StepEmail, TemplateID: template}},
+		})
+		require.NoError(t, err)
+		_, err = service.Enroll(ctx, sequence, domain.EnrollmentRequest{Entity: domain.EntityUser, IDs: []int{ada}})
+		require.NoError(t, err)
+
+		token := strings.TrimPrefix(service.UnsubscribeURL(domain.EntityUser, ada), "https://crm.example"+UnsubscribePath)
+		require.Error(t, service.Unsubscribe(ctx, token, "203.0.113.7", "Mail"))
+
+		consents, err := service.GetConsents(ctx, domain.EntityUser, ada)
+		require.NoError(t, err)
+		assert.Empty(t, consents.History)
+		events, err := service.GetAuditEvents(ctx, domain.AuditFilter{Entity: domain.AuditConsent})
+		require.NoError(t, err)
+		assert.Empty(t, events)
+		got, err := service.GetSequence(ctx, sequence)
+		require.NoError(t, err)
+		assert.Equal(t, 0, got.Stats.Unsubscribed)
+
+		// so a retry unsubscribes for real
+		repo.failing = false
+		require.NoError(t, service.Unsubscribe(ctx, token, "203.0.113.7", "Mail"))
+		suppressions, err := service.GetSuppressions(ctx)
+		require.NoError(t, err)
+		assert.Len(t, suppressions, 1)
+		got, err = service.GetSequence(ctx, sequence)
+		require.NoError(t, err)
+		assert.Equal(t, 1, got.Stats.Unsubscribed)
+	})
+
+	t.Run("suppressed addresses stop sequences when their step comes", func(t *testing.T) {
+		service, capture, ada := setup(t)
+		template, err := service.CreateEmailTemplate(ctx, domain.CreateEmailTemplateRequest{Name: "Intro", Subject: "Hi", BodyText: "Hello"})
+		require.NoError(t, err)
+		sequence, err := service.CreateSequence(ctx, domain.CreateSequenceRequest{
+			Name:   "Outreach",
+			Entity: domain.EntityUser,
+			Steps:  []domain.SequenceStep{{Type: domain.StepEmail, TemplateID: template}},
+		})
+		require.NoError(t, err)
+		_, err = service.Enroll(ctx, sequence, domain.EnrollmentRequest{Entity: domain.EntityUser, IDs: []int{ada}})
+		require.NoError(t, err)
+
+		suppression, err := service.CreateSuppression(ctx, domain.CreateSuppressionRequest{Email: "ADA@example.com", Reason: domain.SuppressionBounced})
+		require.NoError(t, err)
+		assert.Equal(t, "ada@example.com", suppression.Email)
+
+		_, err = service.RunSequences(ctx, time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, capture.Messages())
+		got, err := service.GetSequence(ctx, sequence)
+		require.NoError(t, err)
+		assert.Equal(t, 1, got.Stats.Bounced)
+
+		require.NoError(t, service.DeleteSuppression(ctx, suppression.ID))
+		_, err = service.SendEmail(ctx, domain.EntityUser, ada, domain.SendEmailRequest{Subject: "Hi", BodyText: "Hello"})
+		assert.NoError(t, err)
+
+		_, err = service.CreateSuppression(ctx, domain.CreateSuppressionRequest{Email: "not an address"})
+		assert.IsType(t, ValidationError(""), err)
+	})
+}
@@ -0,0 +1,326 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+)
+
+// customFieldKeyPattern keeps keys safe to use in query strings and JSON paths
+var customFieldKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// CreateCustomField defines a new custom field on an entity
+func (s *Service) CreateCustomField(ctx context.Context, req domain.CreateCustomFieldRequest) (int, error) {
+	if !supportedEntities[req.Entity] {
+		return 0, ValidationError(fmt.Sprintf("unsupported entity %q", req.Entity))
+	}
+	if !customFieldKeyPattern.MatchString(req.Key) {
+		return 0, ValidationError("key must start with a letter and contain only lowercase letters, digits and underscores")
+	}
+	if strings.TrimSpace(req.Label) == "" {
+		return 0, ValidationError("label is required")
+	}
+
+	switch req.Type {
+	case domain.CustomFieldSelect, domain.CustomFieldMultiSelect:
+		if len(req.Options) == 0 {
+			return 0, ValidationError("select fields need at least one option")
+		}
+		for i, option := range req.Options {
+			if strings.TrimSpace(option) == "" {
+				return 0, ValidationError("options cannot be blank")
+			}
+			if slices.Contains(req.Options[:i], option) {
+				return 0, ValidationError(fmt.Sprintf("duplicate option %q", option))
+			}
+		}
+	case domain.CustomFieldText, domain.CustomFieldNumber, domain.CustomFieldDate,
+		domain.CustomFieldBoolean, domain.CustomFieldUser:
+		if len(req.Options) > 0 {
+			return 0, ValidationError("options are only allowed on select fields")
+		}
+	default:
+		return 0, ValidationError(fmt.Sprintf("unsupported field type %q", req.Type))
+	}
+
+	def := domain.CustomFieldDefinition{
+		Entity:       req.Entity,
+		Key:          req.Key,
+		Label:        strings.TrimSpace(req.Label),
+		Type:         req.Type,
+		Options:      req.Options,
+		TrackHistory: req.TrackHistory,
+	}
+
+	var id int
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.repo.CreateCustomField(ctx, def)
+		if errors.Is(err, repository.ErrDuplicate) {
+			return ValidationError(fmt.Sprintf("a %s field with key %q already exists", req.Entity, req.Key))
+		}
+		if err != nil {
+			return fmt.Errorf("service error - create custom field: %w", err)
+		}
+		def.ID = id
+		return s.audit(ctx, domain.AuditCustomField, id, domain.AuditCreate, nil, def)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetCustomFields lists the custom field definitions for an entity
+func (s *Service) GetCustomFields(ctx context.Context, entity string) ([]*domain.CustomFieldDefinition, error) {
+	if !supportedEntities[entity] {
+		return nil, ValidationError(fmt.Sprintf("unsupported entity %q", entity))
+	}
+
+	defs, err := s.repo.GetCustomFields(ctx, entity)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get custom fields: %w", err)
+	}
+	if defs == nil {
+		defs = []*domain.CustomFieldDefinition{}
+	}
+	return defs, nil
+}
+
+// GetCustomField retrieves a custom field definition
+func (s *Service) GetCustomField(ctx context.Context, id int) (*domain.CustomFieldDefinition, error) {
+	def, err := s.repo.GetCustomField(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get custom field: %w", err)
+	}
+	return def, nil
+}
+
+// UpdateCustomField relabels a custom field or turns its history on or off
+func (s *Service) UpdateCustomField(ctx context.Context, id int, req domain.UpdateCustomFieldRequest) error {
+	def, err := s.repo.GetCustomField(ctx, id)
+	if err != nil {
+		return fmt.Errorf("service error - get custom field: %w", err)
+	}
+	if version := expectedVersion(ctx); version != 0 && def.Version != version {
+		return fmt.Errorf("service error - update custom field: %w", repository.ErrVersionConflict)
+	}
+	before := *def
+
+	if req.Label != nil {
+		if strings.TrimSpace(*req.Label) == "" {
+			return ValidationError("label is required")
+		}
+		def.Label = strings.TrimSpace(*req.Label)
+	}
+	if req.TrackHistory != nil {
+		def.TrackHistory = *req.TrackHistory
+	}
+	if def.Label == before.Label && def.TrackHistory == before.TrackHistory {
+		return nil
+	}
+
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.UpdateCustomField(ctx, *def); err != nil {
+			return fmt.Errorf("service error - update custom field: %w", err)
+		}
+		return s.audit(ctx, domain.AuditCustomField, id, domain.AuditUpdate, before, def)
+	})
+}
+
+// DeleteCustomField moves a custom field definition to the trash
+func (s *Service) DeleteCustomField(ctx context.Context, id int) error {
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.TrashRecord(ctx, domain.AuditCustomField, id, expectedVersion(ctx), actorFrom(ctx).Name); err != nil {
+			return fmt.Errorf("service error - delete custom field: %w", err)
+		}
+		return s.audit(ctx, domain.AuditCustomField, id, domain.AuditDelete, nil, nil)
+	})
+}
+
+// customFieldsByKey loads the definitions for an entity keyed by field key
+func (s *Service) customFieldsByKey(ctx context.Context, entity string) (map[string]*domain.CustomFieldDefinition, error) {
+	defs, err := s.repo.GetCustomFields(ctx, entity)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get custom fields: %w", err)
+	}
+	byKey := make(map[string]*domain.CustomFieldDefinition, len(defs))
+	for _, def := range defs {
+		byKey[def.Key] = def
+	}
+	return byKey, nil
+}
+
+// validateCustomFields checks every value against its definition and returns
+// the values converted to the canonical type stored in JSONB
+func (s *Service) validateCustomFields(ctx context.Context, entity string, values map[string]any) (map[string]any, error) {
+	defs, err := s.customFieldsByKey(ctx, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]any, len(values))
+	for key, value := range values {
+		def, ok := defs[key]
+		if !ok {
+			return nil, ValidationError(fmt.Sprintf("unknown custom field %q", key))
+		}
+		// null clears the field
+		if value == nil {
+			continue
+		}
+		normalized, err := s.normalizeCustomValue(ctx, def, value)
+		if err != nil {
+			return nil, ValidationError(fmt.Sprintf("custom field %q: %v", key, err))
+		}
+		fields[key] = normalized
+	}
+	return fields, nil
+}
+
+// normalizeCustomValue converts a decoded JSON value to the field's canonical type
+func (s *Service) normalizeCustomValue(ctx context.Context, def *domain.CustomFieldDefinition, value any) (any, error) {
+	switch def.Type {
+	case domain.CustomFieldText:
+		text, ok := value.(string)
+		if !ok {
+			return nil, errors.New("must be a string")
+		}
+		return text, nil
+
+	case domain.CustomFieldNumber:
+		number, ok := value.(float64)
+		if !ok || math.IsNaN(number) || math.IsInf(number, 0) {
+			return nil, errors.New("must be a number")
+		}
+		return number, nil
+
+	case domain.CustomFieldDate:
+		text, ok := value.(string)
+		if !ok {
+			return nil, errors.New("must be a date string")
+		}
+		return normalizeDate(text)
+
+	case domain.CustomFieldSelect:
+		option, ok := value.(string)
+		if !ok || !slices.Contains(def.Options, option) {
+			return nil, fmt.Errorf("must be one of %s", strings.Join(def.Options, ", "))
+		}
+		return option, nil
+
+	case domain.CustomFieldMultiSelect:
+		items, ok := value.([]any)
+		if !ok {
+			return nil, errors.New("must be a list of options")
+		}
+		selected := make([]string, 0, len(items))
+		for _, item := range items {
+			option, ok := item.(string)
+			if !ok || !slices.Contains(def.Options, option) {
+				return nil, fmt.Errorf("must only contain %s", strings.Join(def.Options, ", "))
+			}
+			if !slices.Contains(selected, option) {
+				selected = append(selected, option)
+			}
+		}
+		return selected, nil
+
+	case domain.CustomFieldBoolean:
+		flag, ok := value.(bool)
+		if !ok {
+			return nil, errors.New("must be true or false")
+		}
+		return flag, nil
+
+	case domain.CustomFieldUser:
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) || number <= 0 {
+			return nil, errors.New("must be a user ID")
+		}
+		id := int(number)
+		if _, err := s.repo.GetUser(ctx, id); err != nil {
+			return nil, fmt.Errorf("user %d does not exist", id)
+		}
+		return id, nil
+	}
+	return nil, fmt.Errorf("unsupported field type %q", def.Type)
+}
+
+// normalizeDate accepts a plain date or an RFC 3339 timestamp and stores the date part,
+// so dates sort correctly as strings in JSONB
+func normalizeDate(text string) (string, error) {
+	if date, err := time.Parse(time.DateOnly, text); err == nil {
+		return date.Format(time.DateOnly), nil
+	}
+	if ts, err := time.Parse(time.RFC3339, text); err == nil {
+		return ts.Format(time.DateOnly), nil
+	}
+	return "", errors.New("must be a date like 2006-01-02")
+}
+
+// parseCustomFilter converts a raw query string value to the field's canonical type
+func parseCustomFilter(def *domain.CustomFieldDefinition, raw string) (any, error) {
+	switch def.Type {
+	case domain.CustomFieldText, domain.CustomFieldSelect, domain.CustomFieldMultiSelect:
+		return raw, nil
+	case domain.CustomFieldNumber:
+		return strconv.ParseFloat(raw, 64)
+	case domain.CustomFieldDate:
+		return normalizeDate(raw)
+	case domain.CustomFieldBoolean:
+		return strconv.ParseBool(raw)
+	case domain.CustomFieldUser:
+		return strconv.Atoi(raw)
+	}
+	return nil, fmt.Errorf("unsupported field type %q", def.Type)
+}
+
+// normalizeListOptions validates the sort and custom field filters of a list query.
+// Filter values arrive as strings from the query string and leave typed.
+func (s *Service) normalizeListOptions(ctx context.Context, entity string, opts domain.ListOptions) (domain.ListOptions, error) {
+	sortKey, isCustomSort := strings.CutPrefix(strings.TrimPrefix(opts.Sort, "-"), "cf.")
+	if len(opts.CustomFilters) == 0 && !isCustomSort {
+		return opts, nil
+	}
+
+	defs, err := s.customFieldsByKey(ctx, entity)
+	if err != nil {
+		return opts, err
+	}
+
+	if isCustomSort {
+		if _, ok := defs[sortKey]; !ok {
+			return opts, ValidationError(fmt.Sprintf("unknown custom field %q", sortKey))
+		}
+	}
+
+	filters := make(map[string]any, len(opts.CustomFilters))
+	for key, value := range opts.CustomFilters {
+		def, ok := defs[key]
+		if !ok {
+			return opts, ValidationError(fmt.Sprintf("unknown custom field %q", key))
+		}
+		raw, ok := value.(string)
+		if !ok {
+			filters[key] = value
+			continue
+		}
+		typed, err := parseCustomFilter(def, raw)
+		if err != nil {
+			return opts, ValidationError(fmt.Sprintf("invalid filter value for custom field %q", key))
+		}
+		filters[key] = typed
+	}
+	opts.CustomFilters = filters
+	return opts, nil
+}
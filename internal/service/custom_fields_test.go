@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateCustomField(t *testing.T) {
+	tests := []struct {
+		name        string
+		request     domain.CreateCustomFieldRequest
+		expectRepo  bool
+		expectedErr bool
+	}{
+		{
+			name:       "text field",
+			request:    domain.CreateCustomFieldRequest{Entity: "user", Key: "industry", Label: "Industry", Type: domain.CustomFieldText},
+			expectRepo: true,
+		},
+		{
+			name:       "select field",
+			request:    domain.CreateCustomFieldRequest{Entity: "user", Key: "tier", Label: "Tier", Type: domain.CustomFieldSelect, Options: []string{"gold", "silver"}},
+			expectRepo: true,
+		},
+		{
+			name:        "select without options",
+			request:     domain.CreateCustomFieldRequest{Entity: "user", Key: "tier", Label: "Tier", Type: domain.CustomFieldSelect},
+			expectedErr: true,
+		},
+		{
+			name:        "options on a text field",
+			request:     domain.CreateCustomFieldRequest{Entity: "user", Key: "industry", Label: "Industry", Type: domain.CustomFieldText, Options: []string{"a"}},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid key",
+			request:     domain.CreateCustomFieldRequest{Entity: "user", Key: "Budget Band", Label: "Budget", Type: domain.CustomFieldText},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown type",
+			request:     domain.CreateCustomFieldRequest{Entity: "user", Key: "budget", Label: "Budget", Type: "currency"},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown entity",
+			request:     domain.CreateCustomFieldRequest{Entity: "spaceship", Key: "budget", Label: "Budget", Type: domain.CustomFieldText},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockUserRepository)
+			if tc.expectRepo {
+				mockRepo.On("CreateCustomField", mock.Anything, mock.AnythingOfType("domain.CustomFieldDefinition")).Return(1, nil)
+				mockRepo.On("AppendAuditEvent", mock.Anything, mock.AnythingOfType("domain.AuditEvent")).Return(1, nil)
+			}
+
+			service := NewService(mockRepo)
+			id, err := service.CreateCustomField(context.Background(), tc.request)
+
+			if tc.expectedErr {
+				var verr ValidationError
+				assert.True(t, errors.As(err, &verr), "expected a validation error, got %v", err)
+				assert.Equal(t, 0, id)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, id)
+			}
+
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateUserWithCustomFields(t *testing.T) {
+	defs := []*domain.CustomFieldDefinition{
+		{ID: 1, Entity: "user", Key: "industry", Type: domain.CustomFieldText},
+		{ID: 2, Entity: "user", Key: "budget", Type: domain.CustomFieldNumber},
+		{ID: 3, Entity: "user", Key: "renewal", Type: domain.CustomFieldDate},
+		{ID: 4, Entity: "user", Key: "tier", Type: domain.CustomFieldSelect, Options: []string{"gold", "silver"}},
+		{ID: 5, Entity: "user", Key: "channels", Type: domain.CustomFieldMultiSelect, Options: []string{"email", "phone"}},
+		{ID: 6, Entity: "user", Key: "vip", Type: domain.CustomFieldBoolean},
+		{ID: 7, Entity: "user", Key: "manager", Type: domain.CustomFieldUser},
+	}
+
+	tests := []struct {
+		name        string
+		fields      map[string]any
+		expected    map[string]any
+		expectedErr bool
+	}{
+		{
+			name: "all types",
+			fields: map[string]any{
+				"industry": "Retail",
+				"budget":   float64(5000),
+				"renewal":  "2026-10-01T12:00:00Z",
+				"tier":     "gold",
+				"channels": []any{"email", "email", "phone"},
+				"vip":      true,
+				"manager":  float64(1),
+			},
+			expected: map[string]any{
+				"industry": "Retail",
+				"budget":   float64(5000),
+				"renewal":  "2026-10-01",
+				"tier":     "gold",
+				"channels": []string{"email", "phone"},
+				"vip":      true,
+				"manager":  1,
+			},
+		},
+		{
+			name:        "unknown field",
+			fields:      map[string]any{"shoe_size": "10"},
+			expectedErr: true,
+		},
+		{
+			name:        "number given as string",
+			fields:      map[string]any{"budget": "5000"},
+			expectedErr: true,
+		},
+		{
+			name:        "option not allowed",
+			fields:      map[string]any{"tier": "bronze"},
+			expectedErr: true,
+		},
+		{
+			name:        "bad date",
+			fields:      map[string]any{"renewal": "next tuesday"},
+			expectedErr: true,
+		},
+		{
+			name:        "missing user reference",
+			fields:      map[string]any{"manager": float64(42)},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockUserRepository)
+			mockRepo.On("GetCustomFields", mock.Anything, "user").Return(defs, nil)
+			mockRepo.On("GetUser", mock.Anything, 1).Return(&domain.User{ID: 1}, nil).Maybe()
+			mockRepo.On("GetUser", mock.Anything, 42).Return(nil, errors.New("user not found")).Maybe()
+
+			request := domain.CreateUserRequest{Name: "John Doe", Email: "john@example.com", CustomFields: tc.fields}
+			if !tc.expectedErr {
+				mockRepo.On("CreateUser", mock.Anything, domain.User{
+					Name:         request.Name,
+					Email:        request.Email,
+					CustomFields: tc.expected,
+				}).Return(10, nil)
+				mockRepo.On("GetAssignmentRules", mock.Anything, "user").Return(nil, nil)
+				mockRepo.On("GetAutomations", mock.Anything, "user").Return(nil, nil)
+				mockRepo.On("AppendAuditEvent", mock.Anything, mock.AnythingOfType("domain.AuditEvent")).Return(1, nil)
+			}
+
+			service := NewService(mockRepo)
+			id, err := service.CreateUser(context.Background(), request)
+
+			if tc.expectedErr {
+				var verr ValidationError
+				assert.True(t, errors.As(err, &verr), "expected a validation error, got %v", err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, 10, id)
+			}
+
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetUsersCustomFieldFilters(t *testing.T) {
+	defs := []*domain.CustomFieldDefinition{
+		{ID: 1, Entity: "user", Key: "budget", Type: domain.CustomFieldNumber},
+		{ID: 2, Entity: "user", Key: "vip", Type: domain.CustomFieldBoolean},
+	}
+
+	t.Run("filters are typed", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		mockRepo.On("GetCustomFields", mock.Anything, "user").Return(defs, nil)
+		mockRepo.On("GetUsers", mock.Anything, domain.ListOptions{
+			Sort:          "-cf.budget",
+			CustomFilters: map[string]any{"budget": float64(100), "vip": true},
+		}).Return([]*domain.User{}, nil)
+
+		service := NewService(mockRepo)
+		_, err := service.GetUsers(context.Background(), domain.ListOptions{
+			Sort:          "-cf.budget",
+			CustomFilters: map[string]any{"budget": "100", "vip": "true"},
+		})
+
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("unknown sort field", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		mockRepo.On("GetCustomFields", mock.Anything, "user").Return(defs, nil)
+
+		service := NewService(mockRepo)
+		_, err := service.GetUsers(context.Background(), domain.ListOptions{Sort: "cf.missing"})
+
+		var verr ValidationError
+		assert.True(t, errors.As(err, &verr))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("plain list skips definitions", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		mockRepo.On("GetUsers", mock.Anything, domain.ListOptions{Sort: "name"}).Return([]*domain.User{}, nil)
+
+		service := NewService(mockRepo)
+		_, err := service.GetUsers(context.Background(), domain.ListOptions{Sort: "name"})
+
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+}
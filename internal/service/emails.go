@@ -0,0 +1,469 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"unicode/utf8"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/mailer"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+)
+
+// email limits
+const (
+	MaxEmailTemplateName = 255
+	MaxEmailSubject      = 998
+)
+
+// Paths of the public tracking endpoints, relative to EmailSettings.TrackingURL
+const (
+	OpenTrackingPath  = "/t/o/"
+	ClickTrackingPath = "/t/c/"
+)
+
+// EmailSettings configures outgoing email
+type EmailSettings struct {
+	// From is the sender address of every email
+	From string
+	// TrackingURL is the public base URL the tracking pixel and links point to
+	TrackingURL string
+	// UnsubscribeSecret signs unsubscribe links. When it is empty a random
+	// key is used, so links stop working when the app restarts.
+	UnsubscribeSecret string
+	// PrivacyContact receives reminders of data subject requests nearing their
+	// deadline. They are only logged when it is empty.
+	PrivacyContact string
+}
+
+// trackedLinkPattern matches absolute http(s) links of anchors, quoted either way
+var trackedLinkPattern = regexp.MustCompile(`(?i)(<a\b[^>]*?\bhref\s*=\s*)(?:"(https?://[^"]*)"|'(https?://[^']*)')`)
+
+// mergeData holds the merge fields templates can use, e.g. {{.FirstName}}
+type mergeData struct {
+	Name      string
+	FirstName string
+	LastName  string
+	Email     string
+	// CustomFields holds the record's custom values formatted as text, so a
+	// missing key renders as nothing
+	CustomFields map[string]string
+	Tags         []string
+	Score        int
+	Owner        mergeOwner
+	// UnsubscribeURL is the record's one-click unsubscribe link
+	UnsubscribeURL string
+}
+
+// mergeOwner is the owner of the record, empty when it has none
+type mergeOwner struct {
+	Name  string
+	Email string
+}
+
+// sampleMergeData is used to check templates render before they are saved
+var sampleMergeData = mergeData{
+	Name:           "Ada Lovelace",
+	FirstName:      "Ada",
+	LastName:       "Lovelace",
+	Email:          "ada@example.com",
+	CustomFields:   map[string]string{},
+	Tags:           []string{"sample"},
+	Owner:          mergeOwner{Name: "Owner", Email: "owner@example.com"},
+	UnsubscribeURL: "https://crm.example/u/sample",
+}
+
+// emailTemplates are the parsed subject and bodies of an email, a nil body is left out
+type emailTemplates struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// UseMailer sets how emails are delivered and the addresses they use
+func (s *Service) UseMailer(m mailer.Mailer, settings EmailSettings) {
+	settings.TrackingURL = strings.TrimRight(settings.TrackingURL, "/")
+	if settings.UnsubscribeSecret == "" {
+		settings.UnsubscribeSecret = s.emailSettings.UnsubscribeSecret
+	}
+	s.mailer = m
+	s.emailSettings = settings
+}
+
+// CreateEmailTemplate saves an email template after checking it renders
+func (s *Service) CreateEmailTemplate(ctx context.Context, req domain.CreateEmailTemplateRequest) (int, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return 0, ValidationError("name is required")
+	}
+	if utf8.RuneCountInString(name) > MaxEmailTemplateName {
+		return 0, ValidationError(fmt.Sprintf("names are limited to %d characters", MaxEmailTemplateName))
+	}
+	templates, err := parseEmailTemplates(req.Subject, req.BodyText, req.BodyHTML)
+	if err != nil {
+		return 0, err
+	}
+	if _, _, _, err := templates.render(sampleMergeData); err != nil {
+		return 0, err
+	}
+
+	template := domain.EmailTemplate{
+		Name:     name,
+		Subject:  req.Subject,
+		BodyText: req.BodyText,
+		BodyHTML: req.BodyHTML,
+	}
+	var id int
+	err = s.repo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.repo.CreateEmailTemplate(ctx, template)
+		if err != nil {
+			return fmt.Errorf("service error - create email template: %w", err)
+		}
+		template.ID = id
+		return s.audit(ctx, domain.AuditEmailTemplate, id, domain.AuditCreate, nil, template)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetEmailTemplates lists the email templates
+func (s *Service) GetEmailTemplates(ctx context.Context) ([]*domain.EmailTemplate, error) {
+	templates, err := s.repo.GetEmailTemplates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get email templates: %w", err)
+	}
+	if templates == nil {
+		templates = []*domain.EmailTemplate{}
+	}
+	return templates, nil
+}
+
+// GetEmailTemplate retrieves an email template
+func (s *Service) GetEmailTemplate(ctx context.Context, id int) (*domain.EmailTemplate, error) {
+	template, err := s.repo.GetEmailTemplate(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get email template: %w", err)
+	}
+	return template, nil
+}
+
+// DeleteEmailTemplate moves an email template to the trash
+func (s *Service) DeleteEmailTemplate(ctx context.Context, id int) error {
+	before, err := s.repo.GetEmailTemplate(ctx, id)
+	if err != nil {
+		return fmt.Errorf("service error - get email template: %w", err)
+	}
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.TrashRecord(ctx, domain.AuditEmailTemplate, id, expectedVersion(ctx), actorFrom(ctx).Name); err != nil {
+			return fmt.Errorf("service error - delete email template: %w", err)
+		}
+		return s.audit(ctx, domain.AuditEmailTemplate, id, domain.AuditDelete, before, nil)
+	})
+}
+
+// SendEmail renders an email for a record, sends it with tracking and logs it
+// on the record's timeline. A delivery failure is stored on the returned email
+// rather than returned as an error.
+func (s *Service) SendEmail(ctx context.Context, entity string, id int, req domain.SendEmailRequest) (*domain.Email, error) {
+	if !supportedEntities[entity] {
+		return nil, ValidationError(fmt.Sprintf("unsupported entity %q", entity))
+	}
+	user, err := s.repo.GetUser(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get user: %w", err)
+	}
+	if user.Email == "" {
+		return nil, ValidationError("the record has no email address")
+	}
+	suppression, err := s.suppression(ctx, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	if suppression != nil {
+		return nil, ValidationError(fmt.Sprintf("%s is on the suppression list (%s)", user.Email, suppression.Reason))
+	}
+
+	subject, bodyText, bodyHTML := req.Subject, req.BodyText, req.BodyHTML
+	if req.TemplateID != nil {
+		template, err := s.repo.GetEmailTemplate(ctx, *req.TemplateID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ValidationError(fmt.Sprintf("email template %d does not exist", *req.TemplateID))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("service error - get email template: %w", err)
+		}
+		subject, bodyText, bodyHTML = template.Subject, template.BodyText, template.BodyHTML
+	}
+	templates, err := parseEmailTemplates(subject, bodyText, bodyHTML)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.mergeData(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	data.UnsubscribeURL = s.UnsubscribeURL(entity, id)
+	subject, bodyText, bodyHTML, err = templates.render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := randomHex(16)
+	if err != nil {
+		return nil, fmt.Errorf("service error - generate tracking token: %w", err)
+	}
+	bodyHTML, links := s.addTracking(bodyHTML, token)
+
+	email := domain.Email{
+		Entity:        entity,
+		EntityID:      id,
+		TemplateID:    req.TemplateID,
+		From:          s.emailSettings.From,
+		To:            user.Email,
+		Subject:       subject,
+		BodyText:      bodyText,
+		BodyHTML:      bodyHTML,
+		MessageID:     messageID(token, s.emailSettings.From),
+		Direction:     domain.EmailOutbound,
+		Status:        domain.EmailSent,
+		TrackingToken: token,
+		Links:         links,
+	}
+	sendErr := s.mailer.Send(ctx, mailer.Message{
+		From:    email.From,
+		To:      []string{email.To},
+		Subject: email.Subject,
+		Text:    email.BodyText,
+		HTML:    email.BodyHTML,
+		Headers: map[string]string{
+			"Message-ID": email.MessageID,
+			// RFC 8058 one-click unsubscribe, mail clients POST to the link
+			"List-Unsubscribe":      "<" + data.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+	if sendErr != nil {
+		email.Status = domain.EmailFailed
+		email.Error = sendErr.Error()
+	}
+
+	email.ID, err = s.repo.CreateEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("service error - create email: %w", err)
+	}
+	if email.Status == domain.EmailSent {
+		emailID := email.ID
+		if _, err := s.repo.CreateActivity(ctx, domain.Activity{
+			Entity:   entity,
+			EntityID: id,
+			Type:     domain.ActivityEmailSent,
+			Summary:  "Email sent: " + email.Subject,
+			EmailID:  &emailID,
+		}); err != nil {
+			return nil, fmt.Errorf("service error - create activity: %w", err)
+		}
+	}
+	return &email, nil
+}
+
+// GetEmails lists the emails sent to a record
+func (s *Service) GetEmails(ctx context.Context, entity string, id int) ([]*domain.Email, error) {
+	if !supportedEntities[entity] {
+		return nil, ValidationError(fmt.Sprintf("unsupported entity %q", entity))
+	}
+	emails, err := s.repo.GetEmails(ctx, entity, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get emails: %w", err)
+	}
+	if emails == nil {
+		emails = []*domain.Email{}
+	}
+	return emails, nil
+}
+
+// GetActivities lists a record's timeline, newest first
+func (s *Service) GetActivities(ctx context.Context, entity string, id int) ([]*domain.Activity, error) {
+	if !supportedEntities[entity] {
+		return nil, ValidationError(fmt.Sprintf("unsupported entity %q", entity))
+	}
+	activities, err := s.repo.GetActivities(ctx, entity, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get activities: %w", err)
+	}
+	if activities == nil {
+		activities = []*domain.Activity{}
+	}
+	return activities, nil
+}
+
+// TrackOpen records that the email with the tracking token was opened
+func (s *Service) TrackOpen(ctx context.Context, token, ip, userAgent string) error {
+	email, err := s.repo.GetEmailByToken(ctx, token)
+	if err != nil {
+		return fmt.Errorf("service error - get email: %w", err)
+	}
+	if err := s.repo.RecordEmailEvent(ctx, domain.EmailEvent{
+		EmailID:   email.ID,
+		Type:      domain.EmailEventOpen,
+		IP:        ip,
+		UserAgent: userAgent,
+	}); err != nil {
+		return fmt.Errorf("service error - record email open: %w", err)
+	}
+	return nil
+}
+
+// TrackClick records a click on the nth tracked link of an email and returns
+// the link's original target
+func (s *Service) TrackClick(ctx context.Context, token string, n int, ip, userAgent string) (string, error) {
+	email, err := s.repo.GetEmailByToken(ctx, token)
+	if err != nil {
+		return "", fmt.Errorf("service error - get email: %w", err)
+	}
+	if n < 0 || n >= len(email.Links) {
+		return "", fmt.Errorf("service error - get email link: %w", repository.ErrNotFound)
+	}
+	if err := s.repo.RecordEmailEvent(ctx, domain.EmailEvent{
+		EmailID:   email.ID,
+		Type:      domain.EmailEventClick,
+		URL:       email.Links[n],
+		IP:        ip,
+		UserAgent: userAgent,
+	}); err != nil {
+		return "", fmt.Errorf("service error - record email click: %w", err)
+	}
+	return email.Links[n], nil
+}
+
+// parseEmailTemplates parses a subject and bodies, at least one body is required
+func parseEmailTemplates(subject, bodyText, bodyHTML string) (*emailTemplates, error) {
+	if strings.TrimSpace(subject) == "" {
+		return nil, ValidationError("subject is required")
+	}
+	if utf8.RuneCountInString(subject) > MaxEmailSubject {
+		return nil, ValidationError(fmt.Sprintf("subjects are limited to %d characters", MaxEmailSubject))
+	}
+	if strings.TrimSpace(bodyText) == "" && strings.TrimSpace(bodyHTML) == "" {
+		return nil, ValidationError("a text or HTML body is required")
+	}
+
+	var templates emailTemplates
+	var err error
+	if templates.subject, err = texttemplate.New("subject").Option("missingkey=zero").Parse(subject); err != nil {
+		return nil, ValidationError(fmt.Sprintf("invalid subject template: %v", err))
+	}
+	if strings.TrimSpace(bodyText) != "" {
+		if templates.text, err = texttemplate.New("text").Option("missingkey=zero").Parse(bodyText); err != nil {
+			return nil, ValidationError(fmt.Sprintf("invalid text template: %v", err))
+		}
+	}
+	if strings.TrimSpace(bodyHTML) != "" {
+		if templates.html, err = htmltemplate.New("html").Option("missingkey=zero").Parse(bodyHTML); err != nil {
+			return nil, ValidationError(fmt.Sprintf("invalid HTML template: %v", err))
+		}
+	}
+	return &templates, nil
+}
+
+// render executes the templates, the subject is kept on one line
+func (t *emailTemplates) render(data mergeData) (string, string, string, error) {
+	var subject, text, body bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return "", "", "", ValidationError(fmt.Sprintf("failed to render the subject: %v", err))
+	}
+	if t.text != nil {
+		if err := t.text.Execute(&text, data); err != nil {
+			return "", "", "", ValidationError(fmt.Sprintf("failed to render the text body: %v", err))
+		}
+	}
+	if t.html != nil {
+		if err := t.html.Execute(&body, data); err != nil {
+			return "", "", "", ValidationError(fmt.Sprintf("failed to render the HTML body: %v", err))
+		}
+	}
+	oneLine := strings.Join(strings.Fields(subject.String()), " ")
+	return oneLine, text.String(), body.String(), nil
+}
+
+// mergeData collects the merge fields of a user and its owner
+func (s *Service) mergeData(ctx context.Context, user *domain.User) (mergeData, error) {
+	data := mergeData{
+		Name:         user.Name,
+		Email:        user.Email,
+		CustomFields: map[string]string{},
+		Tags:         user.Tags,
+		Score:        user.Score,
+	}
+	data.FirstName, data.LastName, _ = strings.Cut(strings.TrimSpace(user.Name), " ")
+	data.LastName = strings.TrimSpace(data.LastName)
+	for key, value := range user.CustomFields {
+		if value != nil {
+			data.CustomFields[key] = fmt.Sprint(value)
+		}
+	}
+
+	if user.OwnerID != nil {
+		owner, err := s.repo.GetUser(ctx, *user.OwnerID)
+		switch {
+		case err == nil:
+			data.Owner = mergeOwner{Name: owner.Name, Email: owner.Email}
+		case !errors.Is(err, repository.ErrNotFound):
+			return mergeData{}, fmt.Errorf("service error - get owner: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// addTracking points the absolute links of an HTML body at the click tracking
+// endpoint and appends the open tracking pixel. It returns the new body and
+// the original link targets, in order.
+func (s *Service) addTracking(body, token string) (string, []string) {
+	if body == "" {
+		return body, nil
+	}
+
+	var links []string
+	body = trackedLinkPattern.ReplaceAllStringFunc(body, func(anchor string) string {
+		parts := trackedLinkPattern.FindStringSubmatch(anchor)
+		target := parts[2]
+		if target == "" {
+			target = parts[3]
+		}
+		// unsubscribe links must keep working without a redirect
+		if strings.HasPrefix(target, s.emailSettings.TrackingURL+UnsubscribePath) {
+			return anchor
+		}
+		tracked := s.emailSettings.TrackingURL + ClickTrackingPath + token + "/" + strconv.Itoa(len(links))
+		links = append(links, html.UnescapeString(target))
+		return parts[1] + `"` + tracked + `"`
+	})
+
+	pixel := `<img src="` + s.emailSettings.TrackingURL + OpenTrackingPath + token + `" width="1" height="1" alt="" style="display:none">`
+	if i := strings.LastIndex(strings.ToLower(body), "</body>"); i >= 0 {
+		return body[:i] + pixel + body[i:], links
+	}
+	return body + pixel, links
+}
+
+// messageID builds a Message-ID header value on the sender's domain
+func messageID(token, from string) string {
+	domainPart := "localhost"
+	if address, err := mail.ParseAddress(from); err == nil {
+		if _, host, ok := strings.Cut(address.Address, "@"); ok && host != "" {
+			domainPart = host
+		}
+	}
+	return "<" + token + "@" + domainPart + ">"
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/mailer"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingMailer rejects every message
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	return errors.New("connection refused")
+}
+
+func TestSendEmail(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*Service, *mailer.Capture, int) {
+		service := NewService(repository.NewMockRepository())
+		capture := mailer.NewCapture()
+		service.UseMailer(capture, EmailSettings{From: "Sales <sales@agency.example>", TrackingURL: "https://crm.example/"})
+
+		_, err := service.CreateCustomField(ctx, domain.CreateCustomFieldRequest{
+			Entity: domain.EntityUser, Key: "company", Label: "Company", Type: domain.CustomFieldText,
+		})
+		require.NoError(t, err)
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{
+			Name: "Ada Lovelace", Email: "ada@example.com", CustomFields: map[string]any{"company": "Analytical"},
+		})
+		require.NoError(t, err)
+		return service, capture, id
+	}
+
+	t.Run("templates are merged, tracked and logged", func(t *testing.T) {
+		service, capture, id := setup(t)
+		templateID, err := service.CreateEmailTemplate(ctx, domain.CreateEmailTemplateRequest{
+			Name:     "Intro",
+			Subject:  "Hello {{.FirstName}} from {{.CustomFields.company}}",
+			BodyText: "Hi {{.Name}}, see https://agency.example/pricing{{.CustomFields.missing}}",
+			BodyHTML: `<html><body><p>Hi {{.FirstName}}</p><a href="https://agency.example/pricing?a=1&b=2">Pricing</a></body></html>`,
+		})
+		require.NoError(t, err)
+
+		email, err := service.SendEmail(ctx, domain.EntityUser, id, domain.SendEmailRequest{TemplateID: &templateID})
+		require.NoError(t, err)
+		assert.Equal(t, domain.EmailSent, email.Status)
+		assert.Equal(t, "Hello Ada from Analytical", email.Subject)
+		assert.Equal(t, "Hi Ada Lovelace, see https://agency.example/pricing", email.BodyText)
+		assert.Equal(t, []string{"https://agency.example/pricing?a=1&b=2"}, email.Links)
+		assert.Contains(t, email.BodyHTML, `href="https://crm.example/t/c/`+email.TrackingToken+`/0"`)
+		assert.Contains(t, email.BodyHTML, `src="https://crm.example/t/o/`+email.TrackingToken+`"`)
+		assert.True(t, strings.HasSuffix(email.MessageID, "@agency.example>"))
+
+		messages := capture.Messages()
+		require.Len(t, messages, 1)
+		assert.Equal(t, []string{"ada@example.com"}, messages[0].To)
+		assert.Equal(t, email.MessageID, messages[0].Headers["Message-ID"])
+
+		activities, err := service.GetActivities(ctx, domain.EntityUser, id)
+		require.NoError(t, err)
+		require.Len(t, activities, 1)
+		assert.Equal(t, domain.ActivityEmailSent, activities[0].Type)
+		assert.Equal(t, email.ID, *activities[0].EmailID)
+	})
+
+	t.Run("opens and clicks are tracked", func(t *testing.T) {
+		service, _, id := setup(t)
+		email, err := service.SendEmail(ctx, domain.EntityUser, id, domain.SendEmailRequest{
+			Subject:  "Quick question",
+			BodyHTML: `<p><a href='https://agency.example/a'>A</a> <a href="https://agency.example/b">B</a></p>`,
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, service.TrackOpen(ctx, email.TrackingToken, "203.0.113.7", "Mail"))
+		target, err := service.TrackClick(ctx, email.TrackingToken, 1, "203.0.113.7", "Mail")
+		require.NoError(t, err)
+		assert.Equal(t, "https://agency.example/b", target)
+
+		_, err = service.TrackClick(ctx, email.TrackingToken, 2, "", "")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		assert.ErrorIs(t, service.TrackOpen(ctx, "unknown", "", ""), repository.ErrNotFound)
+
+		emails, err := service.GetEmails(ctx, domain.EntityUser, id)
+		require.NoError(t, err)
+		require.Len(t, emails, 1)
+		assert.Equal(t, 1, emails[0].OpenCount)
+		assert.Equal(t, 1, emails[0].ClickCount)
+		assert.NotNil(t, emails[0].OpenedAt)
+	})
+
+	t.Run("delivery failures are stored without an activity", func(t *testing.T) {
+		service, _, id := setup(t)
+		service.UseMailer(failingMailer{}, EmailSettings{From: "sales@agency.example", TrackingURL: "https://crm.example"})
+
+		email, err := service.SendEmail(ctx, domain.EntityUser, id, domain.SendEmailRequest{Subject: "Hi", BodyText: "Hello"})
+		require.NoError(t, err)
+		assert.Equal(t, domain.EmailFailed, email.Status)
+		assert.Equal(t, "connection refused", email.Error)
+
+		activities, err := service.GetActivities(ctx, domain.EntityUser, id)
+		require.NoError(t, err)
+		assert.Empty(t, activities)
+	})
+}
+
+func TestEmailTemplateValidation(t *testing.T) {
+	service := NewService(repository.NewMockRepository())
+
+	tests := []struct {
+		name    string
+		request domain.CreateEmailTemplateRequest
+	}{
+		{"no name", domain.CreateEmailTemplateRequest{Subject: "Hi", BodyText: "Hello"}},
+		{"no subject", domain.CreateEmailTemplateRequest{Name: "Intro", BodyText: "Hello"}},
+		{"no body", domain.CreateEmailTemplateRequest{Name: "Intro", Subject: "Hi"}},
+		{"bad syntax", domain.CreateEmailTemplateRequest{Name: "Intro", Subject: "Hi {{.Name", BodyText: "Hello"}},
+		{"unknown merge field", domain.CreateEmailTemplateRequest{Name: "Intro", Subject: "Hi", BodyText: "Hello {{.Company}}"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := service.CreateEmailTemplate(context.Background(), tc.request)
+			assert.IsType(t, ValidationError(""), err)
+		})
+	}
+}
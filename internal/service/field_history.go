@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+)
+
+// historyFields are the built-in fields whose history is always kept, custom
+// fields opt in with TrackHistory. Scores aren't tracked, they are recomputed
+// too often for their history to be useful.
+var historyFields = []string{"name", "email", "owner_id", "tags"}
+
+// customFieldHistoryPrefix names custom fields in the history
+const customFieldHistoryPrefix = "custom_fields."
+
+// trackedFields lists the fields of an entity whose history is kept
+func (s *Service) trackedFields(ctx context.Context, entity string) ([]string, error) {
+	defs, err := s.repo.GetCustomFields(ctx, entity)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get custom fields: %w", err)
+	}
+	fields := append([]string{}, historyFields...)
+	for _, def := range defs {
+		if def.TrackHistory {
+			fields = append(fields, customFieldHistoryPrefix+def.Key)
+		}
+	}
+	return fields, nil
+}
+
+// trackHistory keeps the changes between two versions of a user to its
+// tracked fields. Like auditing it runs in the unit of work making the
+// change.
+func (s *Service) trackHistory(ctx context.Context, before, after *domain.User) error {
+	tracked, err := s.trackedFields(ctx, domain.EntityUser)
+	if err != nil {
+		return err
+	}
+	old, err := auditFields(before)
+	if err != nil {
+		return fmt.Errorf("service error - read user %d for its history: %w", after.ID, err)
+	}
+	updated, err := auditFields(after)
+	if err != nil {
+		return fmt.Errorf("service error - read user %d for its history: %w", after.ID, err)
+	}
+
+	actor := actorFrom(ctx).Name
+	var changes []domain.FieldChange
+	for _, field := range tracked {
+		from, to := historyValue(old, field), historyValue(updated, field)
+		if reflect.DeepEqual(from, to) {
+			continue
+		}
+		changes = append(changes, domain.FieldChange{
+			Entity:   domain.EntityUser,
+			EntityID: after.ID,
+			Field:    field,
+			From:     from,
+			To:       to,
+			Actor:    actor,
+		})
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := s.repo.RecordFieldChanges(ctx, changes); err != nil {
+		return fmt.Errorf("service error - record the history of user %d: %w", after.ID, err)
+	}
+	return nil
+}
+
+// historyValue reads a field from a record's JSON fields, nil when unset
+func historyValue(fields map[string]any, field string) any {
+	if key, ok := strings.CutPrefix(field, customFieldHistoryPrefix); ok {
+		custom, _ := fields["custom_fields"].(map[string]any)
+		return custom[key]
+	}
+	return fields[field]
+}
+
+// setHistoryValue sets a field in a record's JSON fields, removing it when nil
+func setHistoryValue(fields map[string]any, field string, value any) {
+	if key, ok := strings.CutPrefix(field, customFieldHistoryPrefix); ok {
+		custom, _ := fields["custom_fields"].(map[string]any)
+		if custom == nil {
+			custom = map[string]any{}
+			fields["custom_fields"] = custom
+		}
+		fields = custom
+		field = key
+	}
+	if value == nil {
+		delete(fields, field)
+		return
+	}
+	fields[field] = value
+}
+
+// GetFieldHistory lists the changes to a record's tracked fields, newest first
+func (s *Service) GetFieldHistory(ctx context.Context, entity string, id int) ([]*domain.FieldChange, error) {
+	if !supportedEntities[entity] {
+		return nil, ValidationError(fmt.Sprintf("unsupported entity %q", entity))
+	}
+	if _, err := s.repo.GetUser(ctx, id); err != nil {
+		return nil, fmt.Errorf("service error - get user: %w", err)
+	}
+
+	changes, err := s.repo.GetFieldHistory(ctx, entity, id, nil)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get field history: %w", err)
+	}
+	if changes == nil {
+		changes = []*domain.FieldChange{}
+	}
+	return changes, nil
+}
+
+// GetUserAsOf reconstructs a user as it was at a point in time by undoing the
+// changes made since. Only tracked fields are rolled back, the rest keep their
+// current values, and a field's history starts when it was first tracked.
+func (s *Service) GetUserAsOf(ctx context.Context, id int, asOf time.Time) (*domain.UserResponse, error) {
+	user, err := s.repo.GetUser(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get user: %w", err)
+	}
+	if asOf.Before(user.CreatedAt) {
+		return nil, fmt.Errorf("service error - user %d was created after %s: %w", id, asOf.Format(time.RFC3339), repository.ErrNotFound)
+	}
+
+	changes, err := s.repo.GetFieldHistory(ctx, domain.EntityUser, id, &asOf)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get field history: %w", err)
+	}
+	if len(changes) == 0 {
+		return toUserResponse(user), nil
+	}
+
+	fields, err := auditFields(user)
+	if err != nil {
+		return nil, fmt.Errorf("service error - read user: %w", err)
+	}
+	// changes are newest first, so each undo steps further back
+	for _, change := range changes {
+		setHistoryValue(fields, change.Field, change.From)
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("service error - rebuild user: %w", err)
+	}
+	var past domain.User
+	if err := json.Unmarshal(data, &past); err != nil {
+		return nil, fmt.Errorf("service error - rebuild user: %w", err)
+	}
+	return toUserResponse(&past), nil
+}
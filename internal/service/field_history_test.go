@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldHistory(t *testing.T) {
+	ctx := WithActor(context.Background(), domain.Actor{Name: "jane"})
+	service := NewService(repository.NewMockRepository())
+
+	budget, err := service.CreateCustomField(ctx, domain.CreateCustomFieldRequest{
+		Entity: domain.EntityUser, Key: "budget", Label: "Budget", Type: domain.CustomFieldNumber, TrackHistory: true,
+	})
+	require.NoError(t, err)
+	_, err = service.CreateCustomField(ctx, domain.CreateCustomFieldRequest{
+		Entity: domain.EntityUser, Key: "notes", Label: "Notes", Type: domain.CustomFieldText,
+	})
+	require.NoError(t, err)
+
+	id, err := service.CreateUser(ctx, domain.CreateUserRequest{
+		Name: "Ada", Email: "ada@example.com", CustomFields: map[string]any{"budget": float64(100), "notes": "first call"},
+	})
+	require.NoError(t, err)
+	// step past the wall clock so each point in time falls between changes
+	tick := func() time.Time {
+		time.Sleep(2 * time.Millisecond)
+		now := time.Now()
+		time.Sleep(2 * time.Millisecond)
+		return now
+	}
+	created := tick()
+
+	name := "Ada Lovelace"
+	require.NoError(t, service.UpdateUser(ctx, id, domain.UpdateUserRequest{
+		Name: &name, CustomFields: map[string]any{"budget": float64(250), "notes": "second call"},
+	}))
+	_, err = service.AddTags(ctx, domain.BulkTagRequest{Entity: domain.EntityUser, IDs: []int{id}, Tags: []string{"vip"}})
+	require.NoError(t, err)
+	renamed := tick()
+
+	require.NoError(t, service.UpdateUser(ctx, id, domain.UpdateUserRequest{CustomFields: map[string]any{"budget": nil}}))
+
+	t.Run("tracked fields are kept", func(t *testing.T) {
+		changes, err := service.GetFieldHistory(ctx, domain.EntityUser, id)
+		require.NoError(t, err)
+		fields := make([]string, len(changes))
+		for i, change := range changes {
+			fields[i] = change.Field
+		}
+		// newest first, untracked notes are left out
+		assert.Equal(t, []string{"custom_fields.budget", "tags", "custom_fields.budget", "name"}, fields)
+		assert.Equal(t, float64(250), changes[0].From)
+		assert.Nil(t, changes[0].To)
+		assert.Equal(t, "Ada", changes[3].From)
+		assert.Equal(t, "jane", changes[3].Actor)
+	})
+
+	t.Run("as of a point in time", func(t *testing.T) {
+		past, err := service.GetUserAsOf(ctx, id, created)
+		require.NoError(t, err)
+		assert.Equal(t, "Ada", past.Name)
+		assert.Empty(t, past.Tags)
+		assert.Equal(t, float64(100), past.CustomFields["budget"])
+		// untracked fields keep their current value
+		assert.Equal(t, "second call", past.CustomFields["notes"])
+
+		past, err = service.GetUserAsOf(ctx, id, renamed)
+		require.NoError(t, err)
+		assert.Equal(t, "Ada Lovelace", past.Name)
+		assert.Equal(t, []string{"vip"}, past.Tags)
+		assert.Equal(t, float64(250), past.CustomFields["budget"])
+
+		now, err := service.GetUserAsOf(ctx, id, time.Now())
+		require.NoError(t, err)
+		assert.NotContains(t, now.CustomFields, "budget")
+
+		_, err = service.GetUserAsOf(ctx, id, time.Now().Add(-time.Hour))
+		assert.True(t, errors.Is(err, repository.ErrNotFound), "expected not found before the user existed, got %v", err)
+	})
+
+	t.Run("history can be turned off", func(t *testing.T) {
+		off := false
+		require.NoError(t, service.UpdateCustomField(ctx, budget, domain.UpdateCustomFieldRequest{TrackHistory: &off}))
+		before, err := service.GetFieldHistory(ctx, domain.EntityUser, id)
+		require.NoError(t, err)
+		require.NoError(t, service.UpdateUser(ctx, id, domain.UpdateUserRequest{CustomFields: map[string]any{"budget": float64(5)}}))
+		after, err := service.GetFieldHistory(ctx, domain.EntityUser, id)
+		require.NoError(t, err)
+		assert.Len(t, after, len(before))
+
+		blank := " "
+		err = service.UpdateCustomField(ctx, budget, domain.UpdateCustomFieldRequest{Label: &blank})
+		assert.ErrorAs(t, err, new(ValidationError))
+	})
+
+	t.Run("erasure removes the history", func(t *testing.T) {
+		_, err := service.ErasePersonalData(ctx, domain.PrivacySubject{Entity: domain.EntityUser, EntityID: id})
+		require.NoError(t, err)
+		changes, err := service.GetFieldHistory(ctx, domain.EntityUser, id)
+		require.NoError(t, err)
+		assert.Empty(t, changes)
+	})
+}
@@ -0,0 +1,451 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/dyrober/AgencyCRM/internal/segment"
+)
+
+// form limits
+const (
+	MaxFormName   = 255
+	MaxFormFields = 50
+	MaxFormValue  = 5000
+	// MaxSubmittedFields caps the fields of a submission, mapped or not
+	MaxSubmittedFields = 100
+	// MinFormTokenAge rejects tokens used faster than a person can fill a form
+	MinFormTokenAge = 2 * time.Second
+	MaxFormTokenAge = 24 * time.Hour
+)
+
+// Fields with a special meaning in submissions
+const (
+	FormTokenField    = "_token"
+	FormReferrerField = "_referrer"
+)
+
+// utmFields lists the campaign parameters captured from submissions
+var utmFields = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"}
+
+// CreateForm defines a form after checking its mappings and origins, and
+// returns the form ID with its public key
+func (s *Service) CreateForm(ctx context.Context, req domain.CreateFormRequest) (int, string, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return 0, "", ValidationError("name is required")
+	}
+	if utf8.RuneCountInString(name) > MaxFormName {
+		return 0, "", ValidationError(fmt.Sprintf("names are limited to %d characters", MaxFormName))
+	}
+	if !supportedEntities[req.Entity] {
+		return 0, "", ValidationError(fmt.Sprintf("unsupported entity %q", req.Entity))
+	}
+
+	defs, err := s.customFieldsByKey(ctx, req.Entity)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(req.FieldMappings) == 0 || len(req.FieldMappings) > MaxFormFields {
+		return 0, "", ValidationError(fmt.Sprintf("forms need 1 to %d field mappings", MaxFormFields))
+	}
+	mapsEmail := false
+	for field, target := range req.FieldMappings {
+		if field == "" || strings.HasPrefix(field, "_") {
+			return 0, "", ValidationError(fmt.Sprintf("invalid form field %q", field))
+		}
+		if !isRecordField(target, defs) {
+			return 0, "", ValidationError(fmt.Sprintf("form field %q maps to unknown field %q", field, target))
+		}
+		mapsEmail = mapsEmail || target == segment.FieldEmail
+	}
+	if !mapsEmail {
+		return 0, "", ValidationError("a form field must map to email")
+	}
+	if _, mapped := req.FieldMappings[req.HoneypotField]; mapped {
+		return 0, "", ValidationError("the honeypot field can't be mapped")
+	}
+
+	for _, origin := range req.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+			parsed.Path != "" || parsed.RawQuery != "" {
+			return 0, "", ValidationError(fmt.Sprintf("origin %q must look like https://example.com", origin))
+		}
+	}
+
+	key, err := randomHex(12)
+	if err != nil {
+		return 0, "", fmt.Errorf("service error - generate form key: %w", err)
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return 0, "", fmt.Errorf("service error - generate form secret: %w", err)
+	}
+
+	origins := req.AllowedOrigins
+	if origins == nil {
+		origins = []string{}
+	}
+	form := domain.Form{
+		Key:            key,
+		Name:           name,
+		Entity:         req.Entity,
+		FieldMappings:  req.FieldMappings,
+		AllowedOrigins: origins,
+		HoneypotField:  req.HoneypotField,
+		RequireToken:   req.RequireToken,
+		Secret:         secret,
+	}
+	var id int
+	err = s.repo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.repo.CreateForm(ctx, form)
+		if err != nil {
+			return fmt.Errorf("service error - create form: %w", err)
+		}
+		form.ID = id
+		return s.audit(ctx, domain.AuditForm, id, domain.AuditCreate, nil, form)
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	return id, key, nil
+}
+
+// randomHex returns n random bytes hex encoded
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// GetForms lists the forms
+func (s *Service) GetForms(ctx context.Context) ([]*domain.Form, error) {
+	forms, err := s.repo.GetForms(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get forms: %w", err)
+	}
+	if forms == nil {
+		forms = []*domain.Form{}
+	}
+	return forms, nil
+}
+
+// GetForm retrieves a form
+func (s *Service) GetForm(ctx context.Context, id int) (*domain.Form, error) {
+	form, err := s.repo.GetForm(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get form: %w", err)
+	}
+	return form, nil
+}
+
+// GetFormByKey looks up a form by its public key
+func (s *Service) GetFormByKey(ctx context.Context, key string) (*domain.Form, error) {
+	form, err := s.repo.GetFormByKey(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get form: %w", err)
+	}
+	return form, nil
+}
+
+// DeleteForm moves a form to the trash, it stops taking submissions
+func (s *Service) DeleteForm(ctx context.Context, id int) error {
+	before, err := s.repo.GetForm(ctx, id)
+	if err != nil {
+		return fmt.Errorf("service error - get form: %w", err)
+	}
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.TrashRecord(ctx, domain.AuditForm, id, expectedVersion(ctx), actorFrom(ctx).Name); err != nil {
+			return fmt.Errorf("service error - delete form: %w", err)
+		}
+		return s.audit(ctx, domain.AuditForm, id, domain.AuditDelete, before, nil)
+	})
+}
+
+// GetFormSubmissions lists the latest submissions of a form
+func (s *Service) GetFormSubmissions(ctx context.Context, formID int) ([]*domain.FormSubmission, error) {
+	if _, err := s.repo.GetForm(ctx, formID); err != nil {
+		return nil, fmt.Errorf("service error - get form: %w", err)
+	}
+	submissions, err := s.repo.GetFormSubmissions(ctx, formID)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get form submissions: %w", err)
+	}
+	if submissions == nil {
+		submissions = []*domain.FormSubmission{}
+	}
+	return submissions, nil
+}
+
+// IssueFormToken signs a token the embed snippet sends back with the submission
+func (s *Service) IssueFormToken(ctx context.Context, key string) (string, error) {
+	form, err := s.GetFormByKey(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return formToken(form.Secret, form.Key, time.Now()), nil
+}
+
+// formToken is "<unix issue time>.<hex HMAC-SHA256 of key.time>"
+func formToken(secret, key string, issuedAt time.Time) string {
+	issued := strconv.FormatInt(issuedAt.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(key + "." + issued))
+	return issued + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validFormToken checks the signature and age of a form token
+func validFormToken(form *domain.Form, token string, now time.Time) bool {
+	issued, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return false
+	}
+	issuedAt := time.Unix(unix, 0)
+	if age := now.Sub(issuedAt); age < MinFormTokenAge || age > MaxFormTokenAge {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(formToken(form.Secret, form.Key, issuedAt)))
+}
+
+// SubmitForm turns a submission into a new record, or merges it into the
+// record with the same email. Anyone can submit someone else's email, so a
+// merge only fills in the custom fields the record has no value for, the
+// submission keeps the rest. Submissions that fill the honeypot are logged
+// as spam and otherwise ignored, so bots can't tell they were caught.
+func (s *Service) SubmitForm(ctx context.Context, key string, input domain.FormInput) (*domain.FormSubmission, error) {
+	form, err := s.GetFormByKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if form.RequireToken && !validFormToken(form, input.Fields[FormTokenField], time.Now()) {
+		return nil, ValidationError("invalid or expired form token")
+	}
+	if len(input.Fields) > MaxSubmittedFields {
+		return nil, ValidationError("too many fields")
+	}
+
+	submission := domain.FormSubmission{
+		FormID:   form.ID,
+		IP:       input.IP,
+		Referrer: input.Referrer,
+		UTM:      map[string]string{},
+		Data:     map[string]string{},
+	}
+	if referrer := input.Fields[FormReferrerField]; referrer != "" {
+		submission.Referrer = referrer
+	}
+	for field, value := range input.Fields {
+		if utf8.RuneCountInString(value) > MaxFormValue {
+			return nil, ValidationError(fmt.Sprintf("field %q is limited to %d characters", field, MaxFormValue))
+		}
+		if strings.HasPrefix(field, "_") || field == form.HoneypotField {
+			continue
+		}
+		if slices.Contains(utmFields, field) {
+			submission.UTM[field] = value
+		}
+		submission.Data[field] = value
+	}
+
+	if form.HoneypotField != "" && strings.TrimSpace(input.Fields[form.HoneypotField]) != "" {
+		submission.Status = domain.SubmissionSpam
+		return s.logSubmission(ctx, submission)
+	}
+
+	name, email, customFields, err := s.mapFormFields(ctx, form, input.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// the lookup and the writes share a transaction, so two submissions with
+	// the same new email can't both create a record. The record is audited
+	// and routed in it too, automations and scoring follow once it has
+	// committed.
+	var created, updated *domain.User
+	var changed []string
+	err = s.repo.InTx(ctx, func(ctx context.Context) error {
+		created, updated, changed = nil, nil, nil
+
+		existing, err := s.repo.GetUserByEmail(ctx, email)
+		switch {
+		case err == nil:
+			submission.Status = domain.SubmissionMerged
+			submission.EntityID = &existing.ID
+			was := *existing
+			fields, err := s.applyUserUpdate(ctx, existing, domain.UpdateUserRequest{CustomFields: missingFields(existing, customFields)})
+			if err != nil {
+				return err
+			}
+			if len(fields) > 0 {
+				if err := s.repo.UpdateUser(ctx, *existing); err != nil {
+					return fmt.Errorf("service error - update user: %w", err)
+				}
+				if err := s.recordUserUpdated(ctx, &was, existing); err != nil {
+					return err
+				}
+				updated, changed = existing, fields
+			}
+
+		case errors.Is(err, repository.ErrNotFound):
+			user, err := s.newUser(ctx, domain.CreateUserRequest{Name: name, Email: email, CustomFields: customFields})
+			if err != nil {
+				return err
+			}
+			user.ID, err = s.repo.CreateUser(ctx, user)
+			if err != nil {
+				return fmt.Errorf("service error - create user: %w", err)
+			}
+			if err := s.recordUserCreated(ctx, user); err != nil {
+				return err
+			}
+			submission.Status = domain.SubmissionCreated
+			submission.EntityID = &user.ID
+			created = &user
+
+		default:
+			return fmt.Errorf("service error - find user by email: %w", err)
+		}
+
+		submission.ID, err = s.repo.CreateFormSubmission(ctx, submission)
+		if err != nil {
+			return fmt.Errorf("service error - log form submission: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if created != nil {
+		s.userCreated(ctx, *created)
+	}
+	if updated != nil {
+		s.userUpdated(ctx, updated, changed, 0)
+	}
+	return &submission, nil
+}
+
+// missingFields returns the custom fields of values that user has no value for
+func missingFields(user *domain.User, values map[string]any) map[string]any {
+	missing := map[string]any{}
+	for key, value := range values {
+		if current := user.CustomFields[key]; current == nil || current == "" {
+			missing[key] = value
+		}
+	}
+	return missing
+}
+
+// logSubmission stores a submission and returns it with its ID
+func (s *Service) logSubmission(ctx context.Context, submission domain.FormSubmission) (*domain.FormSubmission, error) {
+	id, err := s.repo.CreateFormSubmission(ctx, submission)
+	if err != nil {
+		return nil, fmt.Errorf("service error - log form submission: %w", err)
+	}
+	submission.ID = id
+	return &submission, nil
+}
+
+// mapFormFields applies the form's mappings to the submitted values. Custom
+// field values arrive as text and are converted to the field's type.
+func (s *Service) mapFormFields(ctx context.Context, form *domain.Form, fields map[string]string) (string, string, map[string]any, error) {
+	defs, err := s.customFieldsByKey(ctx, form.Entity)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	var name, email string
+	customFields := map[string]any{}
+	for field, target := range form.FieldMappings {
+		value := strings.TrimSpace(fields[field])
+		if value == "" {
+			continue
+		}
+		switch target {
+		case segment.FieldName:
+			name = value
+		case segment.FieldEmail:
+			address, err := mail.ParseAddress(value)
+			if err != nil {
+				return "", "", nil, ValidationError("a valid email address is required")
+			}
+			email = address.Address
+		default:
+			key, _ := strings.CutPrefix(target, segment.CustomFieldPrefix)
+			def, ok := defs[key]
+			if !ok {
+				// the field was deleted after the form was made
+				continue
+			}
+			converted, err := formFieldValue(def, value)
+			if err != nil {
+				return "", "", nil, ValidationError(fmt.Sprintf("field %q: %v", field, err))
+			}
+			customFields[key] = converted
+		}
+	}
+
+	if email == "" {
+		return "", "", nil, ValidationError("a valid email address is required")
+	}
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	return name, email, customFields, nil
+}
+
+// formFieldValue converts submitted text to the JSON type validateCustomFields expects
+func formFieldValue(def *domain.CustomFieldDefinition, raw string) (any, error) {
+	switch def.Type {
+	case domain.CustomFieldNumber, domain.CustomFieldUser:
+		number, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, errors.New("must be a number")
+		}
+		return number, nil
+	case domain.CustomFieldBoolean:
+		// checkboxes post "on" when ticked
+		if raw == "on" {
+			return true, nil
+		}
+		flag, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, errors.New("must be true or false")
+		}
+		return flag, nil
+	case domain.CustomFieldMultiSelect:
+		var items []any
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	}
+	return raw, nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmitForm(t *testing.T) {
+	ctx := context.Background()
+	newForm := func(t *testing.T, requireToken bool) (*Service, *domain.Form) {
+		service := NewService(repository.NewMockRepository())
+		_, err := service.CreateCustomField(ctx, domain.CreateCustomFieldRequest{Entity: "user", Key: "budget", Label: "Budget", Type: domain.CustomFieldNumber})
+		require.NoError(t, err)
+		_, key, err := service.CreateForm(ctx, domain.CreateFormRequest{
+			Name:          "Contact us",
+			Entity:        "user",
+			FieldMappings: map[string]string{"full_name": "name", "email": "email", "budget": "cf.budget"},
+			HoneypotField: "website",
+			RequireToken:  requireToken,
+		})
+		require.NoError(t, err)
+		form, err := service.GetFormByKey(ctx, key)
+		require.NoError(t, err)
+		return service, form
+	}
+
+	t.Run("creates then merges by email", func(t *testing.T) {
+		service, form := newForm(t, false)
+
+		first, err := service.SubmitForm(ctx, form.Key, domain.FormInput{
+			Fields: map[string]string{"full_name": "Ada Lovelace", "email": "ada@example.com", "utm_source": "newsletter"},
+			IP:     "203.0.113.7",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, domain.SubmissionCreated, first.Status)
+		assert.Equal(t, "newsletter", first.UTM["utm_source"])
+
+		second, err := service.SubmitForm(ctx, form.Key, domain.FormInput{
+			Fields:   map[string]string{"email": "ADA@example.com", "budget": "5000", "_referrer": "https://search.example/"},
+			Referrer: "https://client.example/contact",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, domain.SubmissionMerged, second.Status)
+		assert.Equal(t, *first.EntityID, *second.EntityID)
+		assert.Equal(t, "https://search.example/", second.Referrer)
+
+		user, err := service.GetUser(ctx, *first.EntityID)
+		require.NoError(t, err)
+		assert.Equal(t, "Ada Lovelace", user.Name)
+		assert.Equal(t, 5000.0, user.CustomFields["budget"])
+
+		// fields the record already has are kept, the submission holds the rest
+		third, err := service.SubmitForm(ctx, form.Key, domain.FormInput{
+			Fields: map[string]string{"email": "ada@example.com", "budget": "1"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, domain.SubmissionMerged, third.Status)
+		assert.Equal(t, "1", third.Data["budget"])
+		user, err = service.GetUser(ctx, *first.EntityID)
+		require.NoError(t, err)
+		assert.Equal(t, 5000.0, user.CustomFields["budget"])
+		assert.Equal(t, 2, user.Version)
+
+		submissions, err := service.GetFormSubmissions(ctx, form.ID)
+		require.NoError(t, err)
+		assert.Len(t, submissions, 3)
+	})
+
+	t.Run("honeypot submissions are logged as spam", func(t *testing.T) {
+		service, form := newForm(t, false)
+
+		submission, err := service.SubmitForm(ctx, form.Key, domain.FormInput{
+			Fields: map[string]string{"email": "bot@example.com", "website": "http://spam.example"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, domain.SubmissionSpam, submission.Status)
+		assert.Nil(t, submission.EntityID)
+
+		users, err := service.GetUsers(ctx, domain.ListOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, users)
+	})
+
+	t.Run("signed tokens", func(t *testing.T) {
+		service, form := newForm(t, true)
+		submit := func(token string) error {
+			_, err := service.SubmitForm(ctx, form.Key, domain.FormInput{
+				Fields: map[string]string{"email": "ada@example.com", FormTokenField: token},
+			})
+			return err
+		}
+
+		assert.IsType(t, ValidationError(""), submit(""))
+		fresh, err := service.IssueFormToken(ctx, form.Key)
+		require.NoError(t, err)
+		assert.IsType(t, ValidationError(""), submit(fresh), "tokens used instantly look like bots")
+		assert.IsType(t, ValidationError(""), submit(formToken("wrong secret", form.Key, time.Now().Add(-time.Minute))))
+		assert.IsType(t, ValidationError(""), submit(formToken(form.Secret, form.Key, time.Now().Add(-48*time.Hour))))
+		assert.NoError(t, submit(formToken(form.Secret, form.Key, time.Now().Add(-time.Minute))))
+	})
+
+	t.Run("invalid values are rejected", func(t *testing.T) {
+		service, form := newForm(t, false)
+
+		_, err := service.SubmitForm(ctx, form.Key, domain.FormInput{Fields: map[string]string{"email": "not an email"}})
+		assert.IsType(t, ValidationError(""), err)
+		_, err = service.SubmitForm(ctx, form.Key, domain.FormInput{Fields: map[string]string{"email": "ada@example.com", "budget": "lots"}})
+		assert.IsType(t, ValidationError(""), err)
+	})
+}
+
+func TestCreateFormValidation(t *testing.T) {
+	service := NewService(repository.NewMockRepository())
+
+	tests := []struct {
+		name    string
+		request domain.CreateFormRequest
+	}{
+		{"no email mapping", domain.CreateFormRequest{Name: "A", Entity: "user", FieldMappings: map[string]string{"name": "name"}}},
+		{"unknown target", domain.CreateFormRequest{Name: "A", Entity: "user", FieldMappings: map[string]string{"email": "email", "x": "cf.missing"}}},
+		{"reserved field", domain.CreateFormRequest{Name: "A", Entity: "user", FieldMappings: map[string]string{"_token": "email"}}},
+		{"mapped honeypot", domain.CreateFormRequest{Name: "A", Entity: "user", FieldMappings: map[string]string{"email": "email"}, HoneypotField: "email"}},
+		{"bad origin", domain.CreateFormRequest{Name: "A", Entity: "user", FieldMappings: map[string]string{"email": "email"}, AllowedOrigins: []string{"example.com"}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := service.CreateForm(context.Background(), tc.request)
+			assert.IsType(t, ValidationError(""), err)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// maxIdempotencyKeyLength matches the idempotency_keys.key column
+const maxIdempotencyKeyLength = 255
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key comes back with a
+	// different request than the one it was first used for
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used for a different request")
+	// ErrIdempotencyKeyInFlight is returned when a key's first request is
+	// still running
+	ErrIdempotencyKeyInFlight = errors.New("idempotency key is in use by a request that hasn't finished")
+)
+
+// BeginIdempotentRequest claims an Idempotency-Key for a request, identified
+// by requestHash. It returns nil when the request should run, or the stored
+// key whose response to replay when it already ran. The claim is a lease: a
+// request that hasn't completed within it is taken to have died with its
+// process, and a retry may claim the key again.
+func (s *Service) BeginIdempotentRequest(ctx context.Context, key, requestHash string, lease time.Duration) (*domain.IdempotencyKey, error) {
+	if strings.TrimSpace(key) == "" {
+		return nil, ValidationError("Idempotency-Key cannot be blank")
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, ValidationError(fmt.Sprintf("Idempotency-Key can be at most %d characters", maxIdempotencyKeyLength))
+	}
+
+	now := time.Now()
+	stored, err := s.repo.ClaimIdempotencyKey(ctx, domain.IdempotencyKey{
+		Key:         key,
+		RequestHash: requestHash,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(lease),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("service error - claim idempotency key: %w", err)
+	}
+	switch {
+	case stored == nil:
+		return nil, nil
+	case stored.RequestHash != requestHash:
+		return nil, ErrIdempotencyKeyReused
+	case stored.StatusCode == 0:
+		return nil, ErrIdempotencyKeyInFlight
+	}
+	return stored, nil
+}
+
+// CompleteIdempotentRequest stores the response of a claimed key's request
+// for its retries within ttl
+func (s *Service) CompleteIdempotentRequest(ctx context.Context, key string, statusCode int, headers map[string]string, body []byte, ttl time.Duration) error {
+	if err := s.repo.CompleteIdempotencyKey(ctx, key, statusCode, headers, body, time.Now().Add(ttl)); err != nil {
+		return fmt.Errorf("service error - complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+// AbandonIdempotentRequest frees a claimed key without a response, so a retry
+// runs the request again
+func (s *Service) AbandonIdempotentRequest(ctx context.Context, key string) error {
+	if err := s.repo.ReleaseIdempotencyKey(ctx, key); err != nil {
+		return fmt.Errorf("service error - release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// PurgeExpiredIdempotencyKeys deletes keys that expired before now and returns
+// how many there were
+func (s *Service) PurgeExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	deleted, err := s.repo.DeleteExpiredIdempotencyKeys(ctx, now)
+	if err != nil {
+		return 0, fmt.Errorf("service error - purge idempotency keys: %w", err)
+	}
+	return deleted, nil
+}
+
+// StartIdempotencyKeyPurge deletes expired idempotency keys every poll until
+// ctx is cancelled. Expired keys are already ignored, this only keeps the
+// table small.
+func (s *Service) StartIdempotencyKeyPurge(ctx context.Context, poll time.Duration) {
+	go func() {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.PurgeExpiredIdempotencyKeys(ctx, time.Now()); err != nil {
+					log.Printf("Error purging idempotency keys: %v", err)
+				}
+			}
+		}
+	}()
+}
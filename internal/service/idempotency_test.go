@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotency(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("retries replay the stored response", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		stored, err := service.BeginIdempotentRequest(ctx, "retry-1", "hash", time.Hour)
+		require.NoError(t, err)
+		assert.Nil(t, stored, "expected the first request to run")
+
+		_, err = service.BeginIdempotentRequest(ctx, "retry-1", "hash", time.Hour)
+		assert.ErrorIs(t, err, ErrIdempotencyKeyInFlight)
+
+		require.NoError(t, service.CompleteIdempotentRequest(ctx, "retry-1", 201, map[string]string{"Content-Type": "application/json"}, []byte(`{"id":1}`), time.Hour))
+		stored, err = service.BeginIdempotentRequest(ctx, "retry-1", "hash", time.Hour)
+		require.NoError(t, err)
+		require.NotNil(t, stored)
+		assert.Equal(t, 201, stored.StatusCode)
+		assert.Equal(t, `{"id":1}`, string(stored.ResponseBody))
+		assert.Equal(t, "application/json", stored.ResponseHeaders["Content-Type"])
+
+		_, err = service.BeginIdempotentRequest(ctx, "retry-1", "other hash", time.Hour)
+		assert.ErrorIs(t, err, ErrIdempotencyKeyReused)
+	})
+
+	t.Run("abandoned and expired keys can be used again", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		_, err := service.BeginIdempotentRequest(ctx, "retry-2", "hash", time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, service.AbandonIdempotentRequest(ctx, "retry-2"))
+		stored, err := service.BeginIdempotentRequest(ctx, "retry-2", "hash", time.Hour)
+		require.NoError(t, err)
+		assert.Nil(t, stored)
+
+		_, err = service.BeginIdempotentRequest(ctx, "short-lived", "hash", -time.Second)
+		require.NoError(t, err)
+		stored, err = service.BeginIdempotentRequest(ctx, "short-lived", "other hash", time.Hour)
+		require.NoError(t, err)
+		assert.Nil(t, stored, "expected an expired key to be claimed again")
+
+		purged, err := service.PurgeExpiredIdempotencyKeys(ctx, time.Now().Add(2*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, 2, purged)
+	})
+
+	t.Run("running keys are taken over once their lease runs out", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		// the process running the request died before its lease ran out
+		_, err := service.BeginIdempotentRequest(ctx, "crashed", "hash", -time.Second)
+		require.NoError(t, err)
+		stored, err := service.BeginIdempotentRequest(ctx, "crashed", "hash", time.Minute)
+		require.NoError(t, err)
+		assert.Nil(t, stored, "expected the retry to run the request")
+
+		// a completed response is kept for the TTL, not the lease
+		require.NoError(t, service.CompleteIdempotentRequest(ctx, "crashed", 201, nil, []byte(`{"id":1}`), time.Hour))
+		stored, err = service.BeginIdempotentRequest(ctx, "crashed", "hash", -time.Second)
+		require.NoError(t, err)
+		require.NotNil(t, stored)
+		assert.Equal(t, 201, stored.StatusCode)
+	})
+
+	t.Run("keys are checked", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		_, err := service.BeginIdempotentRequest(ctx, " ", "hash", time.Hour)
+		assert.ErrorAs(t, err, new(ValidationError))
+		_, err = service.BeginIdempotentRequest(ctx, string(make([]byte, 256)), "hash", time.Hour)
+		assert.ErrorAs(t, err, new(ValidationError))
+	})
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/mailer"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+)
+
+// inbound email limits
+const (
+	MaxInboundMessage     = 25 << 20
+	MaxInboundAttachments = 20
+	maxAttachmentName     = 255
+)
+
+// IngestEmail logs a received message, such as one the CRM was BCC'd on, on
+// the records of the users who sent or received it. A reply to a known email
+// joins its thread and is logged on that email's record even when no address
+// matches. A reply stops the sender's sequences, and a bounce notification
+// suppresses the failed recipients and stops their sequences. It returns nil without an error when
+// the message was already logged or matches no record.
+func (s *Service) IngestEmail(ctx context.Context, raw io.Reader) (*domain.Email, error) {
+	in, err := mailer.Parse(io.LimitReader(raw, MaxInboundMessage))
+	if err != nil {
+		return nil, ValidationError(err.Error())
+	}
+
+	// the same message can arrive twice, and our own emails can come back in copy
+	if in.MessageID != "" {
+		_, err := s.repo.GetEmailByMessageID(ctx, in.MessageID)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			return nil, fmt.Errorf("service error - get email: %w", err)
+		}
+	}
+	for _, address := range in.Bounced {
+		if err := s.addSuppression(ctx, domain.EmailSuppression{
+			Email:  address,
+			Reason: domain.SuppressionBounced,
+			Source: "bounce",
+		}); err != nil {
+			return nil, err
+		}
+		user, err := s.repo.GetUserByEmail(ctx, address)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("service error - get user by email: %w", err)
+		}
+		if err := s.stopSequences(ctx, domain.EntityUser, user.ID, domain.EnrollmentBounced); err != nil {
+			return nil, err
+		}
+	}
+	parent, err := s.threadParent(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	// the sender received nothing from us, the recipients were emailed
+	var activities []domain.Activity
+	matched := map[int]bool{}
+	match := func(addresses []*mail.Address, activityType, summary string) error {
+		for _, address := range addresses {
+			user, err := s.repo.GetUserByEmail(ctx, address.Address)
+			if errors.Is(err, repository.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("service error - get user by email: %w", err)
+			}
+			if matched[user.ID] {
+				continue
+			}
+			matched[user.ID] = true
+			activities = append(activities, domain.Activity{
+				Entity:   domain.EntityUser,
+				EntityID: user.ID,
+				Type:     activityType,
+				Summary:  summary + in.Subject,
+			})
+		}
+		return nil
+	}
+	if err := match([]*mail.Address{in.From}, domain.ActivityEmailReceived, "Email received: "); err != nil {
+		return nil, err
+	}
+	if err := match(append(in.To, in.Cc...), domain.ActivityEmailSent, "Email sent: "); err != nil {
+		return nil, err
+	}
+	if len(activities) == 0 && parent != nil {
+		activities = append(activities, domain.Activity{
+			Entity:   parent.Entity,
+			EntityID: parent.EntityID,
+			Type:     domain.ActivityEmailReceived,
+			Summary:  "Email received: " + in.Subject,
+		})
+	}
+	if len(activities) == 0 {
+		return nil, nil
+	}
+
+	var recipients []string
+	for _, address := range append(in.To, in.Cc...) {
+		recipients = append(recipients, address.Address)
+	}
+	email := domain.Email{
+		Entity:    activities[0].Entity,
+		EntityID:  activities[0].EntityID,
+		From:      in.From.Address,
+		To:        strings.Join(recipients, ", "),
+		Subject:   in.Subject,
+		BodyText:  in.Text,
+		BodyHTML:  in.HTML,
+		MessageID: in.MessageID,
+		Direction: domain.EmailInbound,
+		InReplyTo: in.InReplyTo,
+		Status:    domain.EmailReceived,
+	}
+	if parent != nil {
+		email.ThreadID = parent.ThreadID
+		if email.ThreadID == nil {
+			email.ThreadID = &parent.ID
+		}
+	}
+
+	var attachments []domain.EmailAttachment
+	for i, attachment := range in.Attachments {
+		if i == MaxInboundAttachments {
+			log.Printf("Dropping %d attachments over the limit of email %s", len(in.Attachments)-i, in.MessageID)
+			break
+		}
+		attachments = append(attachments, domain.EmailAttachment{
+			Filename:    attachmentName(attachment.Filename),
+			ContentType: attachment.ContentType,
+			Data:        attachment.Data,
+		})
+	}
+
+	email.ID, err = s.repo.CreateInboundEmail(ctx, email, attachments, activities)
+	if err != nil {
+		return nil, fmt.Errorf("service error - create inbound email: %w", err)
+	}
+
+	// a bounce notification isn't an answer from the record
+	if len(in.Bounced) == 0 {
+		for _, activity := range activities {
+			if activity.Type != domain.ActivityEmailReceived {
+				continue
+			}
+			if err := s.stopSequences(ctx, activity.Entity, activity.EntityID, domain.EnrollmentReplied); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &email, nil
+}
+
+// threadParent finds the stored email a message replies to, trying
+// In-Reply-To and then References from the most recent
+func (s *Service) threadParent(ctx context.Context, in *mailer.Inbound) (*domain.Email, error) {
+	candidates := []string{in.InReplyTo}
+	for i := len(in.References) - 1; i >= 0; i-- {
+		candidates = append(candidates, in.References[i])
+	}
+	for _, messageID := range candidates {
+		if messageID == "" {
+			continue
+		}
+		parent, err := s.repo.GetEmailByMessageID(ctx, messageID)
+		if err == nil {
+			return parent, nil
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			return nil, fmt.Errorf("service error - get email: %w", err)
+		}
+	}
+	return nil, nil
+}
+
+// attachmentName keeps the base name of a file and limits its length
+func attachmentName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" {
+		name = "attachment"
+	}
+	if runes := []rune(name); len(runes) > maxAttachmentName {
+		name = string(runes[:maxAttachmentName])
+	}
+	return name
+}
+
+// GetEmailAttachments lists the files received with an email
+func (s *Service) GetEmailAttachments(ctx context.Context, emailID int) ([]*domain.EmailAttachment, error) {
+	if _, err := s.repo.GetEmail(ctx, emailID); err != nil {
+		return nil, fmt.Errorf("service error - get email: %w", err)
+	}
+	attachments, err := s.repo.GetEmailAttachments(ctx, emailID)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get email attachments: %w", err)
+	}
+	if attachments == nil {
+		attachments = []*domain.EmailAttachment{}
+	}
+	return attachments, nil
+}
+
+// GetEmailAttachment retrieves a file received with an email, with its content
+func (s *Service) GetEmailAttachment(ctx context.Context, emailID, id int) (*domain.EmailAttachment, error) {
+	attachment, err := s.repo.GetEmailAttachment(ctx, emailID, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get email attachment: %w", err)
+	}
+	return attachment, nil
+}
+
+// IngestMaildir logs the new messages of a maildir and returns how many were
+// logged. Unreadable messages are set aside with the trashed flag, a storage
+// error leaves the message to be retried on the next run.
+func (s *Service) IngestMaildir(ctx context.Context, dir mailer.Maildir) (int, error) {
+	names, err := dir.Pending()
+	if err != nil {
+		return 0, fmt.Errorf("service error - read maildir: %w", err)
+	}
+
+	logged := 0
+	for _, name := range names {
+		file, err := dir.Open(name)
+		if err != nil {
+			return logged, fmt.Errorf("service error - open message: %w", err)
+		}
+		email, err := s.IngestEmail(ctx, file)
+		file.Close()
+
+		var invalid ValidationError
+		unreadable := errors.As(err, &invalid)
+		if unreadable {
+			log.Printf("Skipping unreadable message %s: %v", name, err)
+		} else if err != nil {
+			return logged, err
+		}
+		if err := dir.Done(name, unreadable); err != nil {
+			return logged, fmt.Errorf("service error - finish message: %w", err)
+		}
+		if email != nil {
+			logged++
+		}
+	}
+	return logged, nil
+}
+
+// StartInbound ingests the maildir every poll until ctx is done
+func (s *Service) StartInbound(ctx context.Context, dir mailer.Maildir, poll time.Duration) {
+	go func() {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.IngestMaildir(ctx, dir); err != nil {
+					log.Printf("Error ingesting inbound email: %v", err)
+				}
+			}
+		}
+	}()
+}
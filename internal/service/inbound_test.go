@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/mailer"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawEmail builds a plain text message with extra headers
+func rawEmail(from, to, subject, messageID string, headers ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMessage-ID: %s\r\n", from, to, subject, messageID)
+	for _, header := range headers {
+		b.WriteString(header + "\r\n")
+	}
+	b.WriteString("\r\nHello\r\n")
+	return b.String()
+}
+
+func TestIngestEmail(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*Service, int, int) {
+		service := NewService(repository.NewMockRepository())
+		service.UseMailer(mailer.NewCapture(), EmailSettings{From: "sales@agency.example", TrackingURL: "https://crm.example"})
+		ada, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+		grace, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Grace", Email: "grace@example.com"})
+		require.NoError(t, err)
+		return service, ada, grace
+	}
+
+	t.Run("messages are logged on every matched record", func(t *testing.T) {
+		service, ada, grace := setup(t)
+		raw := "From: Ada <ADA@example.com>\r\n" +
+			"To: grace@example.com, stranger@example.net\r\n" +
+			"Subject: Intro\r\n" +
+			"Message-ID: <intro@example.com>\r\n" +
+			"Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain\r\n\r\nMeet Grace\r\n" +
+			"--b\r\nContent-Type: text/csv\r\nContent-Disposition: attachment; filename=\"../../leads.csv\"\r\n\r\na,b\r\n" +
+			"--b--\r\n"
+
+		email, err := service.IngestEmail(ctx, strings.NewReader(raw))
+		require.NoError(t, err)
+		require.NotNil(t, email)
+		assert.Equal(t, domain.EmailInbound, email.Direction)
+		assert.Equal(t, ada, email.EntityID)
+		assert.Equal(t, "grace@example.com, stranger@example.net", email.To)
+		assert.Equal(t, "Meet Grace", email.BodyText)
+
+		adaTimeline, err := service.GetActivities(ctx, domain.EntityUser, ada)
+		require.NoError(t, err)
+		require.Len(t, adaTimeline, 1)
+		assert.Equal(t, domain.ActivityEmailReceived, adaTimeline[0].Type)
+		graceTimeline, err := service.GetActivities(ctx, domain.EntityUser, grace)
+		require.NoError(t, err)
+		require.Len(t, graceTimeline, 1)
+		assert.Equal(t, domain.ActivityEmailSent, graceTimeline[0].Type)
+		assert.Equal(t, email.ID, *graceTimeline[0].EmailID)
+
+		attachments, err := service.GetEmailAttachments(ctx, email.ID)
+		require.NoError(t, err)
+		require.Len(t, attachments, 1)
+		assert.Equal(t, "leads.csv", attachments[0].Filename)
+		attachment, err := service.GetEmailAttachment(ctx, email.ID, attachments[0].ID)
+		require.NoError(t, err)
+		assert.Equal(t, "a,b", string(attachment.Data))
+
+		// the same message again is skipped
+		again, err := service.IngestEmail(ctx, strings.NewReader(raw))
+		require.NoError(t, err)
+		assert.Nil(t, again)
+	})
+
+	t.Run("replies join the thread of the email they answer", func(t *testing.T) {
+		service, ada, _ := setup(t)
+		sent, err := service.SendEmail(ctx, domain.EntityUser, ada, domain.SendEmailRequest{Subject: "Proposal", BodyText: "Attached"})
+		require.NoError(t, err)
+
+		// sent from an address no record has, so only the thread places it
+		reply, err := service.IngestEmail(ctx, strings.NewReader(rawEmail(
+			"assistant@example.com", "sales@agency.example", "Re: Proposal", "<reply1@example.com>",
+			"In-Reply-To: "+sent.MessageID,
+		)))
+		require.NoError(t, err)
+		require.NotNil(t, reply)
+		assert.Equal(t, ada, reply.EntityID)
+		assert.Equal(t, sent.ID, *reply.ThreadID)
+
+		second, err := service.IngestEmail(ctx, strings.NewReader(rawEmail(
+			"ada@example.com", "sales@agency.example", "Re: Re: Proposal", "<reply2@example.com>",
+			"References: "+sent.MessageID+" <reply1@example.com>",
+		)))
+		require.NoError(t, err)
+		require.NotNil(t, second)
+		assert.Equal(t, sent.ID, *second.ThreadID)
+	})
+
+	t.Run("unmatched and unreadable messages", func(t *testing.T) {
+		service, _, _ := setup(t)
+		email, err := service.IngestEmail(ctx, strings.NewReader(rawEmail("a@example.net", "b@example.net", "Hi", "<x@example.net>")))
+		require.NoError(t, err)
+		assert.Nil(t, email)
+
+		_, err = service.IngestEmail(ctx, strings.NewReader("not a message"))
+		assert.IsType(t, ValidationError(""), err)
+	})
+}
+
+func TestIngestMaildir(t *testing.T) {
+	ctx := context.Background()
+	service := NewService(repository.NewMockRepository())
+	_, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "new"), 0o700))
+	messages := map[string]string{
+		"1.host": rawEmail("ada@example.com", "sales@agency.example", "Hi", "<1@example.com>"),
+		"2.host": "garbage",
+	}
+	for name, content := range messages {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "new", name), []byte(content), 0o600))
+	}
+
+	logged, err := service.IngestMaildir(ctx, mailer.Maildir(dir))
+	require.NoError(t, err)
+	assert.Equal(t, 1, logged)
+
+	pending, err := mailer.Maildir(dir).Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	assert.FileExists(t, filepath.Join(dir, "cur", "1.host:2,S"))
+	assert.FileExists(t, filepath.Join(dir, "cur", "2.host:2,ST"))
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/mailer"
+)
+
+// privacy request limits
+const (
+	MaxPrivacyNote = 2000
+	// PrivacyReminderWindow is how long before its deadline a pending request
+	// starts being reminded about, once a day
+	PrivacyReminderWindow = 7 * 24 * time.Hour
+)
+
+// privacyRequestTypes lists the data subject requests that can be tracked
+var privacyRequestTypes = map[string]bool{
+	domain.PrivacyExport:  true,
+	domain.PrivacyErasure: true,
+}
+
+// CreatePrivacyRequest logs a data subject request. It is due one month
+// after it was received.
+func (s *Service) CreatePrivacyRequest(ctx context.Context, req domain.PrivacyRequestInput) (int, error) {
+	if !privacyRequestTypes[req.Type] {
+		return 0, ValidationError(fmt.Sprintf("type must be %q or %q", domain.PrivacyExport, domain.PrivacyErasure))
+	}
+	if !supportedEntities[req.Entity] {
+		return 0, ValidationError(fmt.Sprintf("unsupported entity %q", req.Entity))
+	}
+	note := strings.TrimSpace(req.Note)
+	if len(note) > MaxPrivacyNote {
+		return 0, ValidationError(fmt.Sprintf("notes are limited to %d characters", MaxPrivacyNote))
+	}
+	if _, err := s.repo.GetUser(ctx, req.EntityID); err != nil {
+		return 0, fmt.Errorf("service error - get user: %w", err)
+	}
+
+	request := domain.PrivacyRequest{
+		Type:     req.Type,
+		Entity:   req.Entity,
+		EntityID: req.EntityID,
+		Status:   domain.PrivacyPending,
+		Note:     note,
+		DueAt:    time.Now().AddDate(0, 1, 0),
+	}
+	var id int
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.repo.CreatePrivacyRequest(ctx, request)
+		if err != nil {
+			return fmt.Errorf("service error - create privacy request: %w", err)
+		}
+		request.ID = id
+		return s.audit(ctx, domain.AuditPrivacyRequest, id, domain.AuditCreate, nil, request)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetPrivacyRequests lists the data subject requests with a status, or all of
+// them when status is empty, soonest deadline first
+func (s *Service) GetPrivacyRequests(ctx context.Context, status string) ([]*domain.PrivacyRequest, error) {
+	if status != "" && status != domain.PrivacyPending && status != domain.PrivacyCompleted {
+		return nil, ValidationError(fmt.Sprintf("status must be %q or %q", domain.PrivacyPending, domain.PrivacyCompleted))
+	}
+	requests, err := s.repo.GetPrivacyRequests(ctx, status)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get privacy requests: %w", err)
+	}
+	if requests == nil {
+		requests = []*domain.PrivacyRequest{}
+	}
+	return requests, nil
+}
+
+// GetPrivacyRequest retrieves a data subject request by ID
+func (s *Service) GetPrivacyRequest(ctx context.Context, id int) (*domain.PrivacyRequest, error) {
+	request, err := s.repo.GetPrivacyRequest(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get privacy request: %w", err)
+	}
+	return request, nil
+}
+
+// ExportPersonalData compiles everything stored about a record into a ZIP of
+// JSON files, with received attachments under attachments/. It fulfils the
+// subject's pending export request, or logs a fulfilled one when none is given.
+func (s *Service) ExportPersonalData(ctx context.Context, subject domain.PrivacySubject) ([]byte, *domain.PrivacyRequest, error) {
+	if err := s.checkPrivacySubject(ctx, subject, domain.PrivacyExport); err != nil {
+		return nil, nil, err
+	}
+	data, err := s.repo.GetPersonalData(ctx, subject.Entity, subject.EntityID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("service error - get personal data: %w", err)
+	}
+
+	archive, err := personalDataArchive(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("service error - write export: %w", err)
+	}
+	var request *domain.PrivacyRequest
+	err = s.repo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		request, err = s.completePrivacyRequest(ctx, subject, domain.PrivacyExport)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return archive, request, nil
+}
+
+// ErasePersonalData anonymizes a record and everything logged about it. Rows
+// are kept with their types, statuses, counts and dates so reports still add
+// up, while names, addresses, content and network details are cleared and
+// attachments deleted. It fulfils the subject's pending erasure request, or
+// logs a fulfilled one when none is given.
+func (s *Service) ErasePersonalData(ctx context.Context, subject domain.PrivacySubject) (*domain.PrivacyRequest, error) {
+	if err := s.checkPrivacySubject(ctx, subject, domain.PrivacyErasure); err != nil {
+		return nil, err
+	}
+	var request *domain.PrivacyRequest
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.ErasePersonalData(ctx, subject.Entity, subject.EntityID); err != nil {
+			return fmt.Errorf("service error - erase personal data: %w", err)
+		}
+		if err := s.audit(ctx, subject.Entity, subject.EntityID, domain.AuditErase, nil, nil); err != nil {
+			return err
+		}
+		var err error
+		request, err = s.completePrivacyRequest(ctx, subject, domain.PrivacyErasure)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// checkPrivacySubject checks the record exists and, when a request is given,
+// that it is a pending request of the right type for that record
+func (s *Service) checkPrivacySubject(ctx context.Context, subject domain.PrivacySubject, requestType string) error {
+	if !supportedEntities[subject.Entity] {
+		return ValidationError(fmt.Sprintf("unsupported entity %q", subject.Entity))
+	}
+	if subject.RequestID != nil {
+		request, err := s.repo.GetPrivacyRequest(ctx, *subject.RequestID)
+		if err != nil {
+			return fmt.Errorf("service error - get privacy request: %w", err)
+		}
+		if request.Type != requestType || request.Entity != subject.Entity || request.EntityID != subject.EntityID {
+			return ValidationError(fmt.Sprintf("privacy request %d is not an %s request for this record", request.ID, requestType))
+		}
+		if request.Status != domain.PrivacyPending {
+			return ValidationError(fmt.Sprintf("privacy request %d is already %s", request.ID, request.Status))
+		}
+	}
+	if _, err := s.repo.GetUser(ctx, subject.EntityID); err != nil {
+		return fmt.Errorf("service error - get user: %w", err)
+	}
+	return nil
+}
+
+// completePrivacyRequest marks the subject's request as fulfilled, logging a
+// new one when the work was done without a request, and audits it. It runs in
+// the unit of work doing the work.
+func (s *Service) completePrivacyRequest(ctx context.Context, subject domain.PrivacySubject, requestType string) (*domain.PrivacyRequest, error) {
+	now := time.Now()
+	id := 0
+	var before *domain.PrivacyRequest
+	if subject.RequestID != nil {
+		id = *subject.RequestID
+		var err error
+		before, err = s.repo.GetPrivacyRequest(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("service error - get privacy request: %w", err)
+		}
+		if err := s.repo.CompletePrivacyRequest(ctx, id, now); err != nil {
+			return nil, fmt.Errorf("service error - complete privacy request: %w", err)
+		}
+	} else {
+		var err error
+		id, err = s.repo.CreatePrivacyRequest(ctx, domain.PrivacyRequest{
+			Type:        requestType,
+			Entity:      subject.Entity,
+			EntityID:    subject.EntityID,
+			Status:      domain.PrivacyCompleted,
+			DueAt:       now.AddDate(0, 1, 0),
+			CompletedAt: &now,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("service error - create privacy request: %w", err)
+		}
+	}
+
+	request, err := s.repo.GetPrivacyRequest(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get privacy request: %w", err)
+	}
+	if before != nil {
+		err = s.audit(ctx, domain.AuditPrivacyRequest, id, domain.AuditUpdate, before, request)
+	} else {
+		err = s.audit(ctx, domain.AuditPrivacyRequest, id, domain.AuditCreate, nil, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// personalDataArchive writes a record's data as a ZIP with one JSON file per kind
+func personalDataArchive(data *domain.PersonalData) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"record.json", data.Record},
+		{"emails.json", emptyIfNilSlice(data.Emails)},
+		{"email_events.json", emptyIfNilSlice(data.EmailEvents)},
+		{"attachments.json", emptyIfNilSlice(data.Attachments)},
+		{"activities.json", emptyIfNilSlice(data.Activities)},
+		{"consents.json", emptyIfNilSlice(data.Consents)},
+		{"sequence_enrollments.json", emptyIfNilSlice(data.Enrollments)},
+		{"form_submissions.json", emptyIfNilSlice(data.FormSubmissions)},
+		{"assignments.json", emptyIfNilSlice(data.Assignments)},
+		{"automation_runs.json", emptyIfNilSlice(data.AutomationRuns)},
+		{"audit_events.json", emptyIfNilSlice(data.AuditEvents)},
+		{"field_history.json", emptyIfNilSlice(data.FieldHistory)},
+	}
+	for _, file := range files {
+		content, err := json.MarshalIndent(file.content, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, attachment := range data.Attachments {
+		// IDs keep names unique, path.Base keeps the file inside attachments/
+		name := fmt.Sprintf("attachments/%d-%d-%s", attachment.EmailID, attachment.ID, path.Base("/"+attachment.Filename))
+		w, err := archive.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(attachment.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// emptyIfNilSlice returns an empty slice instead of nil, so it is written as []
+func emptyIfNilSlice[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+// SendPrivacyReminders reminds the privacy contact of pending requests whose
+// deadline is less than PrivacyReminderWindow away, or has passed, at most
+// once a day per request. Reminders are only logged when no privacy contact is
+// configured. It returns how many requests were reminded about.
+func (s *Service) SendPrivacyReminders(ctx context.Context, now time.Time) (int, error) {
+	requests, err := s.repo.GetPrivacyRequests(ctx, domain.PrivacyPending)
+	if err != nil {
+		return 0, fmt.Errorf("service error - get privacy requests: %w", err)
+	}
+
+	reminded := 0
+	for _, request := range requests {
+		if request.DueAt.Sub(now) > PrivacyReminderWindow {
+			// requests are sorted by deadline
+			break
+		}
+		claimed, err := s.repo.ClaimPrivacyReminder(ctx, request.ID, now, now.Add(-24*time.Hour))
+		if err != nil {
+			return reminded, fmt.Errorf("service error - claim privacy reminder: %w", err)
+		}
+		if !claimed {
+			continue
+		}
+
+		subject := fmt.Sprintf("Privacy request %d (%s of %s %d) is due %s",
+			request.ID, request.Type, request.Entity, request.EntityID, request.DueAt.Format("2 Jan 2006"))
+		if request.DueAt.Before(now) {
+			subject = fmt.Sprintf("Privacy request %d (%s of %s %d) is overdue since %s",
+				request.ID, request.Type, request.Entity, request.EntityID, request.DueAt.Format("2 Jan 2006"))
+		}
+		if s.emailSettings.PrivacyContact == "" {
+			log.Printf("%s", subject)
+		} else if err := s.mailer.Send(ctx, mailer.Message{
+			From:    s.emailSettings.From,
+			To:      []string{s.emailSettings.PrivacyContact},
+			Subject: subject,
+			Text:    subject + ".\n\nReceived " + request.CreatedAt.Format("2 Jan 2006") + ". " + request.Note + "\n",
+		}); err != nil {
+			return reminded, fmt.Errorf("service error - send privacy reminder: %w", err)
+		}
+		reminded++
+	}
+	return reminded, nil
+}
+
+// StartPrivacyReminders sends privacy request reminders every poll until ctx is done
+func (s *Service) StartPrivacyReminders(ctx context.Context, poll time.Duration) {
+	go func() {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.SendPrivacyReminders(ctx, time.Now()); err != nil {
+					log.Printf("Error sending privacy reminders: %v", err)
+				}
+			}
+		}
+	}()
+}
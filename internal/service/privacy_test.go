@@ -0,0 +1,216 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/mailer"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unzip reads the files of a ZIP archive by name
+func unzip(t *testing.T, archive []byte) map[string]string {
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	require.NoError(t, err)
+	files := map[string]string{}
+	for _, file := range reader.File {
+		r, err := file.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(r)
+		require.NoError(t, err)
+		files[file.Name] = string(content)
+	}
+	return files
+}
+
+func TestPrivacy(t *testing.T) {
+	ctx := context.Background()
+
+	// setup creates Ada with an email sent, a reply with an attachment, and a consent
+	setup := func(t *testing.T) (*Service, *mailer.Capture, int) {
+		service := NewService(repository.NewMockRepository())
+		capture := mailer.NewCapture()
+		service.UseMailer(capture, EmailSettings{
+			From:           "sales@agency.example",
+			TrackingURL:    "https://crm.example",
+			PrivacyContact: "dpo@agency.example",
+		})
+		ada, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada Lovelace", Email: "ada@example.com"})
+		require.NoError(t, err)
+
+		_, err = service.SendEmail(ctx, domain.EntityUser, ada, domain.SendEmailRequest{Subject: "Proposal", BodyText: "Hi Ada"})
+		require.NoError(t, err)
+		_, err = service.IngestEmail(ctx, strings.NewReader("From: ada@example.com\r\n"+
+			"To: sales@agency.example\r\n"+
+			"Subject: Re: Proposal\r\n"+
+			"Message-ID: <reply@example.com>\r\n"+
+			"Content-Type: multipart/mixed; boundary=b\r\n\r\n"+
+			"--b\r\nContent-Type: text/plain\r\n\r\nSigned copy attached\r\n"+
+			"--b\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=\"contract.txt\"\r\n\r\nsigned\r\n"+
+			"--b--\r\n"))
+		require.NoError(t, err)
+		_, err = service.RecordConsent(ctx, domain.ConsentRequest{
+			Entity:      domain.EntityUser,
+			EntityID:    ada,
+			Channel:     domain.ChannelEmail,
+			Status:      domain.ConsentGranted,
+			LawfulBasis: domain.BasisConsent,
+			Source:      "web_form",
+		})
+		require.NoError(t, err)
+		return service, capture, ada
+	}
+
+	t.Run("exports are a zip of json files", func(t *testing.T) {
+		service, _, ada := setup(t)
+		archive, request, err := service.ExportPersonalData(ctx, domain.PrivacySubject{Entity: domain.EntityUser, EntityID: ada})
+		require.NoError(t, err)
+		assert.Equal(t, domain.PrivacyExport, request.Type)
+		assert.Equal(t, domain.PrivacyCompleted, request.Status)
+		require.NotNil(t, request.CompletedAt)
+
+		files := unzip(t, archive)
+
+		var record domain.User
+		require.NoError(t, json.Unmarshal([]byte(files["record.json"]), &record))
+		assert.Equal(t, "ada@example.com", record.Email)
+		var emails []*domain.Email
+		require.NoError(t, json.Unmarshal([]byte(files["emails.json"]), &emails))
+		assert.Len(t, emails, 2)
+		var consents []*domain.Consent
+		require.NoError(t, json.Unmarshal([]byte(files["consents.json"]), &consents))
+		assert.Len(t, consents, 1)
+		assert.Contains(t, files["activities.json"], domain.ActivityEmailReceived)
+		assert.Contains(t, files["attachments.json"], "contract.txt")
+		// kinds with nothing stored are still listed
+		assert.Equal(t, "[]", files["automation_runs.json"])
+		assert.Equal(t, "signed", files["attachments/2-1-contract.txt"])
+	})
+
+	t.Run("erasure anonymizes the record and its history", func(t *testing.T) {
+		service, _, ada := setup(t)
+		request, err := service.ErasePersonalData(ctx, domain.PrivacySubject{Entity: domain.EntityUser, EntityID: ada})
+		require.NoError(t, err)
+		assert.Equal(t, domain.PrivacyErasure, request.Type)
+		assert.Equal(t, domain.PrivacyCompleted, request.Status)
+
+		user, err := service.GetUser(ctx, ada)
+		require.NoError(t, err)
+		assert.Equal(t, "Erased", user.Name)
+		assert.Equal(t, "erased-1@erased.invalid", user.Email)
+
+		archive, _, err := service.ExportPersonalData(ctx, domain.PrivacySubject{Entity: domain.EntityUser, EntityID: ada})
+		require.NoError(t, err)
+		for name, content := range unzip(t, archive) {
+			assert.NotContains(t, content, "ada@example.com", name)
+			assert.NotContains(t, content, "Proposal", name)
+			assert.NotContains(t, name, "contract", name)
+		}
+
+		// the emails and activities still count towards reports
+		emails, err := service.GetEmails(ctx, domain.EntityUser, ada)
+		require.NoError(t, err)
+		assert.Len(t, emails, 2)
+		attachments, err := service.GetEmailAttachments(ctx, emails[0].ID)
+		require.NoError(t, err)
+		assert.Empty(t, attachments)
+		activities, err := service.GetActivities(ctx, domain.EntityUser, ada)
+		require.NoError(t, err)
+		assert.Len(t, activities, 2)
+		consents, err := service.GetConsents(ctx, domain.EntityUser, ada)
+		require.NoError(t, err)
+		assert.Len(t, consents.History, 1)
+		// so does the consent's audit event, without what was recorded with it
+		events, err := service.GetAuditEvents(ctx, domain.AuditFilter{Entity: domain.AuditConsent, EntityID: consents.History[0].ID})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.True(t, events[0].Redacted)
+		assert.Empty(t, events[0].Changes)
+	})
+
+	t.Run("logged requests are fulfilled once", func(t *testing.T) {
+		service, _, ada := setup(t)
+		id, err := service.CreatePrivacyRequest(ctx, domain.PrivacyRequestInput{
+			Type:     domain.PrivacyErasure,
+			Entity:   domain.EntityUser,
+			EntityID: ada,
+			Note:     "Asked by phone",
+		})
+		require.NoError(t, err)
+		request, err := service.GetPrivacyRequest(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, domain.PrivacyPending, request.Status)
+		assert.WithinDuration(t, time.Now().AddDate(0, 1, 0), request.DueAt, time.Minute)
+
+		// the request must match what is done
+		_, _, err = service.ExportPersonalData(ctx, domain.PrivacySubject{Entity: domain.EntityUser, EntityID: ada, RequestID: &id})
+		assert.IsType(t, ValidationError(""), err)
+
+		done, err := service.ErasePersonalData(ctx, domain.PrivacySubject{Entity: domain.EntityUser, EntityID: ada, RequestID: &id})
+		require.NoError(t, err)
+		assert.Equal(t, id, done.ID)
+		assert.Equal(t, domain.PrivacyCompleted, done.Status)
+
+		_, err = service.ErasePersonalData(ctx, domain.PrivacySubject{Entity: domain.EntityUser, EntityID: ada, RequestID: &id})
+		assert.IsType(t, ValidationError(""), err)
+
+		pending, err := service.GetPrivacyRequests(ctx, domain.PrivacyPending)
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+
+	t.Run("invalid requests are rejected", func(t *testing.T) {
+		service, _, ada := setup(t)
+		for _, req := range []domain.PrivacyRequestInput{
+			{Type: "rectification", Entity: domain.EntityUser, EntityID: ada},
+			{Type: domain.PrivacyExport, Entity: "deal", EntityID: ada},
+			{Type: domain.PrivacyExport, Entity: domain.EntityUser, EntityID: ada, Note: strings.Repeat("x", MaxPrivacyNote+1)},
+		} {
+			_, err := service.CreatePrivacyRequest(ctx, req)
+			assert.IsType(t, ValidationError(""), err, "request %+v", req)
+		}
+		_, err := service.CreatePrivacyRequest(ctx, domain.PrivacyRequestInput{Type: domain.PrivacyExport, Entity: domain.EntityUser, EntityID: 99})
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		_, err = service.GetPrivacyRequests(ctx, "open")
+		assert.IsType(t, ValidationError(""), err)
+	})
+
+	t.Run("requests near their deadline are reminded about daily", func(t *testing.T) {
+		service, capture, ada := setup(t)
+		_, err := service.CreatePrivacyRequest(ctx, domain.PrivacyRequestInput{Type: domain.PrivacyExport, Entity: domain.EntityUser, EntityID: ada})
+		require.NoError(t, err)
+		sent := len(capture.Messages())
+
+		now := time.Now()
+		reminded, err := service.SendPrivacyReminders(ctx, now)
+		require.NoError(t, err)
+		assert.Equal(t, 0, reminded)
+
+		nearDeadline := now.AddDate(0, 1, -3)
+		reminded, err = service.SendPrivacyReminders(ctx, nearDeadline)
+		require.NoError(t, err)
+		assert.Equal(t, 1, reminded)
+		reminded, err = service.SendPrivacyReminders(ctx, nearDeadline.Add(time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, 0, reminded)
+
+		reminded, err = service.SendPrivacyReminders(ctx, now.AddDate(0, 1, 2))
+		require.NoError(t, err)
+		assert.Equal(t, 1, reminded)
+
+		messages := capture.Messages()[sent:]
+		require.Len(t, messages, 2)
+		assert.Equal(t, []string{"dpo@agency.example"}, messages[0].To)
+		assert.Contains(t, messages[0].Subject, "is due")
+		assert.Contains(t, messages[1].Subject, "is overdue")
+	})
+}
@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/segment"
+)
+
+// scoring limits
+const (
+	MaxScoringRuleName = 255
+	MaxRulePoints      = 1000
+	ScoringBatchSize   = 500
+	rescoreQueueSize   = 1024
+)
+
+// rescoreEverything is queued instead of an ID when every record needs scoring
+const rescoreEverything = 0
+
+// CreateScoringRule saves a scoring rule after checking it can be evaluated
+func (s *Service) CreateScoringRule(ctx context.Context, req domain.CreateScoringRuleRequest) (int, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return 0, ValidationError("name is required")
+	}
+	if utf8.RuneCountInString(name) > MaxScoringRuleName {
+		return 0, ValidationError(fmt.Sprintf("names are limited to %d characters", MaxScoringRuleName))
+	}
+	if !supportedEntities[req.Entity] {
+		return 0, ValidationError(fmt.Sprintf("unsupported entity %q", req.Entity))
+	}
+	if req.Points == 0 || req.Points < -MaxRulePoints || req.Points > MaxRulePoints {
+		return 0, ValidationError(fmt.Sprintf("points must be between -%d and %d and not zero", MaxRulePoints, MaxRulePoints))
+	}
+
+	expression := strings.TrimSpace(req.Expression)
+	switch req.Type {
+	case domain.ScoringRuleCondition:
+		if expression == "" {
+			return 0, ValidationError("condition rules need an expression")
+		}
+		if req.HalfLifeDays != 0 {
+			return 0, ValidationError("half_life_days is only allowed on recency rules")
+		}
+	case domain.ScoringRuleRecency:
+		if req.HalfLifeDays <= 0 {
+			return 0, ValidationError("recency rules need a positive half_life_days")
+		}
+	default:
+		return 0, ValidationError(fmt.Sprintf("unsupported rule type %q", req.Type))
+	}
+	// recency rules may use the expression to only apply to some records
+	if expression != "" {
+		if _, err := s.parseSegment(ctx, req.Entity, expression); err != nil {
+			return 0, err
+		}
+	}
+
+	rule := domain.ScoringRule{
+		Name:         name,
+		Entity:       req.Entity,
+		Type:         req.Type,
+		Expression:   expression,
+		Points:       req.Points,
+		HalfLifeDays: req.HalfLifeDays,
+	}
+	var id int
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.repo.CreateScoringRule(ctx, rule)
+		if err != nil {
+			return fmt.Errorf("service error - create scoring rule: %w", err)
+		}
+		rule.ID = id
+		return s.audit(ctx, domain.AuditScoringRule, id, domain.AuditCreate, nil, rule)
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.markForRescore(rescoreEverything)
+	return id, nil
+}
+
+// GetScoringRules lists the scoring rules for an entity
+func (s *Service) GetScoringRules(ctx context.Context, entity string) ([]*domain.ScoringRule, error) {
+	if !supportedEntities[entity] {
+		return nil, ValidationError(fmt.Sprintf("unsupported entity %q", entity))
+	}
+
+	rules, err := s.repo.GetScoringRules(ctx, entity)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get scoring rules: %w", err)
+	}
+	if rules == nil {
+		rules = []*domain.ScoringRule{}
+	}
+	return rules, nil
+}
+
+// GetScoringRule retrieves a scoring rule
+func (s *Service) GetScoringRule(ctx context.Context, id int) (*domain.ScoringRule, error) {
+	rule, err := s.repo.GetScoringRule(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get scoring rule: %w", err)
+	}
+	return rule, nil
+}
+
+// DeleteScoringRule moves a scoring rule to the trash
+func (s *Service) DeleteScoringRule(ctx context.Context, id int) error {
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.TrashRecord(ctx, domain.AuditScoringRule, id, expectedVersion(ctx), actorFrom(ctx).Name); err != nil {
+			return fmt.Errorf("service error - delete scoring rule: %w", err)
+		}
+		return s.audit(ctx, domain.AuditScoringRule, id, domain.AuditDelete, nil, nil)
+	})
+	if err != nil {
+		return err
+	}
+	s.markForRescore(rescoreEverything)
+	return nil
+}
+
+// RescoreUser recomputes and stores the score of a single user
+func (s *Service) RescoreUser(ctx context.Context, id int) error {
+	rules, err := s.loadScoringRules(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	user, err := s.repo.GetUser(ctx, id)
+	if err != nil {
+		return fmt.Errorf("service error - get user: %w", err)
+	}
+
+	score, breakdown := scoreUser(rules, user, time.Now())
+	if err := s.repo.UpdateUserScore(ctx, id, score, breakdown); err != nil {
+		return fmt.Errorf("service error - update user score: %w", err)
+	}
+	return nil
+}
+
+// RescoreAll recomputes every user's score in batches and returns how many were scored
+func (s *Service) RescoreAll(ctx context.Context) (int, error) {
+	now := time.Now()
+	rules, err := s.loadScoringRules(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	scored, afterID := 0, 0
+	for {
+		users, err := s.repo.GetUserBatch(ctx, afterID, ScoringBatchSize)
+		if err != nil {
+			return scored, fmt.Errorf("service error - get user batch: %w", err)
+		}
+		for _, user := range users {
+			score, breakdown := scoreUser(rules, user, now)
+			if err := s.repo.UpdateUserScore(ctx, user.ID, score, breakdown); err != nil {
+				return scored, fmt.Errorf("service error - update user score: %w", err)
+			}
+			scored++
+			afterID = user.ID
+		}
+		if len(users) < ScoringBatchSize {
+			return scored, nil
+		}
+	}
+}
+
+// StartScoring runs the scoring worker until ctx is done. It rescores records
+// queued by events as they arrive and every record once per interval.
+func (s *Service) StartScoring(ctx context.Context, interval time.Duration) {
+	s.rescore = make(chan int, rescoreQueueSize)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.RescoreAll(ctx); err != nil {
+					log.Printf("Error rescoring users: %v", err)
+				}
+			case id := <-s.rescore:
+				if id == rescoreEverything {
+					if _, err := s.RescoreAll(ctx); err != nil {
+						log.Printf("Error rescoring users: %v", err)
+					}
+					continue
+				}
+				if err := s.RescoreUser(ctx, id); err != nil {
+					log.Printf("Error rescoring user %d: %v", id, err)
+				}
+			}
+		}
+	}()
+}
+
+// markForRescore queues records for the scoring worker. A full queue drops the
+// request, the periodic batch picks the record up later.
+func (s *Service) markForRescore(ids ...int) {
+	if s.rescore == nil {
+		return
+	}
+	for _, id := range ids {
+		select {
+		case s.rescore <- id:
+		default:
+			return
+		}
+	}
+}
+
+// compiledRule is a scoring rule with its expression parsed
+type compiledRule struct {
+	rule *domain.ScoringRule
+	expr segment.Expr
+}
+
+// loadScoringRules fetches and parses the user scoring rules. Rules whose
+// expression no longer parses are skipped rather than failing the whole run.
+func (s *Service) loadScoringRules(ctx context.Context, now time.Time) ([]compiledRule, error) {
+	rules, err := s.repo.GetScoringRules(ctx, domain.EntityUser)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get scoring rules: %w", err)
+	}
+
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		var expr segment.Expr
+		if rule.Expression != "" {
+			expr, err = segment.Parse(rule.Expression, now)
+			if err != nil {
+				log.Printf("Skipping scoring rule %d: %v", rule.ID, err)
+				continue
+			}
+		}
+		compiled = append(compiled, compiledRule{rule: rule, expr: expr})
+	}
+	return compiled, nil
+}
+
+// scoreUser adds up the points every rule awards the user
+func scoreUser(rules []compiledRule, user *domain.User, now time.Time) (int, []domain.ScoreContribution) {
+	record := userRecord(user)
+
+	score := 0
+	breakdown := []domain.ScoreContribution{}
+	for _, compiled := range rules {
+		if compiled.expr != nil && !segment.Match(compiled.expr, record) {
+			continue
+		}
+
+		points := compiled.rule.Points
+		if compiled.rule.Type == domain.ScoringRuleRecency {
+			points = decayPoints(points, compiled.rule.HalfLifeDays, now.Sub(user.CreatedAt))
+		}
+		if points == 0 {
+			continue
+		}
+
+		score += points
+		breakdown = append(breakdown, domain.ScoreContribution{
+			RuleID: compiled.rule.ID,
+			Rule:   compiled.rule.Name,
+			Points: points,
+		})
+	}
+	return score, breakdown
+}
+
+// decayPoints halves the points every halfLifeDays of age
+func decayPoints(points, halfLifeDays int, age time.Duration) int {
+	if age < 0 {
+		age = 0
+	}
+	days := age.Hours() / 24
+	return int(math.Round(float64(points) * math.Pow(0.5, days/float64(halfLifeDays))))
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateScoringRule(t *testing.T) {
+	tests := []struct {
+		name        string
+		request     domain.CreateScoringRuleRequest
+		expectedErr bool
+	}{
+		{
+			name:    "condition",
+			request: domain.CreateScoringRuleRequest{Name: "Webinar", Entity: "user", Type: domain.ScoringRuleCondition, Expression: "tag:webinar", Points: 10},
+		},
+		{
+			name:    "recency",
+			request: domain.CreateScoringRuleRequest{Name: "Fresh", Entity: "user", Type: domain.ScoringRuleRecency, Points: 20, HalfLifeDays: 7},
+		},
+		{
+			name:        "condition without expression",
+			request:     domain.CreateScoringRuleRequest{Name: "Empty", Entity: "user", Type: domain.ScoringRuleCondition, Points: 10},
+			expectedErr: true,
+		},
+		{
+			name:        "recency without half life",
+			request:     domain.CreateScoringRuleRequest{Name: "Fresh", Entity: "user", Type: domain.ScoringRuleRecency, Points: 20},
+			expectedErr: true,
+		},
+		{
+			name:        "zero points",
+			request:     domain.CreateScoringRuleRequest{Name: "Nothing", Entity: "user", Type: domain.ScoringRuleCondition, Expression: "tag:a"},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown type",
+			request:     domain.CreateScoringRuleRequest{Name: "Opens", Entity: "user", Type: "email_opens", Points: 5},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockUserRepository)
+			if !tc.expectedErr {
+				mockRepo.On("CreateScoringRule", mock.Anything, mock.AnythingOfType("domain.ScoringRule")).Return(1, nil)
+				mockRepo.On("AppendAuditEvent", mock.Anything, mock.AnythingOfType("domain.AuditEvent")).Return(1, nil)
+			}
+
+			service := NewService(mockRepo)
+			id, err := service.CreateScoringRule(context.Background(), tc.request)
+
+			if tc.expectedErr {
+				assert.Error(t, err)
+				assert.IsType(t, ValidationError(""), err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, id)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRescoreAll(t *testing.T) {
+	now := time.Now()
+	rules := []*domain.ScoringRule{
+		{ID: 1, Name: "Webinar", Entity: "user", Type: domain.ScoringRuleCondition, Expression: "tag:webinar", Points: 10},
+		{ID: 2, Name: "Fresh", Entity: "user", Type: domain.ScoringRuleRecency, Points: 20, HalfLifeDays: 7},
+	}
+	users := []*domain.User{
+		{ID: 1, Name: "New Attendee", Tags: []string{"webinar"}, CreatedAt: now},
+		{ID: 2, Name: "Old Contact", CreatedAt: now.Add(-7 * 24 * time.Hour)},
+	}
+
+	mockRepo := new(MockUserRepository)
+	mockRepo.On("GetScoringRules", mock.Anything, "user").Return(rules, nil)
+	mockRepo.On("GetUserBatch", mock.Anything, 0, ScoringBatchSize).Return(users, nil)
+	mockRepo.On("UpdateUserScore", mock.Anything, 1, 30, []domain.ScoreContribution{
+		{RuleID: 1, Rule: "Webinar", Points: 10},
+		{RuleID: 2, Rule: "Fresh", Points: 20},
+	}).Return(nil)
+	mockRepo.On("UpdateUserScore", mock.Anything, 2, 10, []domain.ScoreContribution{
+		{RuleID: 2, Rule: "Fresh", Points: 10},
+	}).Return(nil)
+
+	service := NewService(mockRepo)
+	scored, err := service.RescoreAll(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, scored)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestDecayPoints(t *testing.T) {
+	day := 24 * time.Hour
+
+	assert.Equal(t, 20, decayPoints(20, 7, 0))
+	assert.Equal(t, 10, decayPoints(20, 7, 7*day))
+	assert.Equal(t, 5, decayPoints(20, 7, 14*day))
+	assert.Equal(t, 20, decayPoints(20, 7, -day))
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/segment"
+)
+
+// MaxSegmentName limits the length of segment names
+const MaxSegmentName = 255
+
+// CreateSegment saves a segment after checking its expression parses
+func (s *Service) CreateSegment(ctx context.Context, req domain.CreateSegmentRequest) (int, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return 0, ValidationError("name is required")
+	}
+	if utf8.RuneCountInString(name) > MaxSegmentName {
+		return 0, ValidationError(fmt.Sprintf("names are limited to %d characters", MaxSegmentName))
+	}
+	if !supportedEntities[req.Entity] {
+		return 0, ValidationError(fmt.Sprintf("unsupported entity %q", req.Entity))
+	}
+	if _, err := s.parseSegment(ctx, req.Entity, req.Expression); err != nil {
+		return 0, err
+	}
+
+	seg := domain.Segment{
+		Name:       name,
+		Entity:     req.Entity,
+		Expression: strings.TrimSpace(req.Expression),
+	}
+	var id int
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.repo.CreateSegment(ctx, seg)
+		if err != nil {
+			return fmt.Errorf("service error - create segment: %w", err)
+		}
+		seg.ID = id
+		return s.audit(ctx, domain.AuditSegment, id, domain.AuditCreate, nil, seg)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetSegments lists the saved segments with their current member counts
+func (s *Service) GetSegments(ctx context.Context) ([]*domain.Segment, error) {
+	segments, err := s.repo.GetSegments(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get segments: %w", err)
+	}
+	for _, seg := range segments {
+		if err := s.countSegment(ctx, seg); err != nil {
+			return nil, err
+		}
+	}
+	if segments == nil {
+		segments = []*domain.Segment{}
+	}
+	return segments, nil
+}
+
+// GetSegment retrieves a segment with its current member count
+func (s *Service) GetSegment(ctx context.Context, id int) (*domain.Segment, error) {
+	seg, err := s.repo.GetSegment(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get segment: %w", err)
+	}
+	if err := s.countSegment(ctx, seg); err != nil {
+		return nil, err
+	}
+	return seg, nil
+}
+
+// GetSegmentMembers evaluates a segment and returns the records in it right now
+func (s *Service) GetSegmentMembers(ctx context.Context, id int) ([]*domain.UserResponse, error) {
+	seg, err := s.repo.GetSegment(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get segment: %w", err)
+	}
+	expr, err := s.parseSegment(ctx, seg.Entity, seg.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("service error - segment %d is no longer valid: %w", id, err)
+	}
+
+	users, err := s.repo.GetSegmentUsers(ctx, expr)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get segment members: %w", err)
+	}
+	response := make([]*domain.UserResponse, 0, len(users))
+	for _, user := range users {
+		response = append(response, toUserResponse(user))
+	}
+	return response, nil
+}
+
+// DeleteSegment moves a saved segment to the trash
+func (s *Service) DeleteSegment(ctx context.Context, id int) error {
+	before, err := s.repo.GetSegment(ctx, id)
+	if err != nil {
+		return fmt.Errorf("service error - get segment: %w", err)
+	}
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.TrashRecord(ctx, domain.AuditSegment, id, expectedVersion(ctx), actorFrom(ctx).Name); err != nil {
+			return fmt.Errorf("service error - delete segment: %w", err)
+		}
+		return s.audit(ctx, domain.AuditSegment, id, domain.AuditDelete, before, nil)
+	})
+}
+
+// countSegment fills in the member count of a segment
+func (s *Service) countSegment(ctx context.Context, seg *domain.Segment) error {
+	expr, err := s.parseSegment(ctx, seg.Entity, seg.Expression)
+	if err != nil {
+		return fmt.Errorf("service error - segment %d is no longer valid: %w", seg.ID, err)
+	}
+	count, err := s.repo.CountSegmentUsers(ctx, expr)
+	if err != nil {
+		return fmt.Errorf("service error - count segment members: %w", err)
+	}
+	seg.MemberCount = count
+	return nil
+}
+
+// parseSegment parses an expression and checks any custom fields it uses are defined.
+// Relative dates are resolved now, so membership is always evaluated fresh.
+func (s *Service) parseSegment(ctx context.Context, entity, expression string) (segment.Expr, error) {
+	expr, err := segment.Parse(expression, time.Now())
+	if err != nil {
+		return nil, ValidationError(fmt.Sprintf("invalid expression: %v", err))
+	}
+
+	keys := customFieldKeys(expr, nil)
+	if len(keys) > 0 {
+		defs, err := s.customFieldsByKey(ctx, entity)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if _, ok := defs[key]; !ok {
+				return nil, ValidationError(fmt.Sprintf("invalid expression: unknown custom field %q", key))
+			}
+		}
+	}
+	return expr, nil
+}
+
+// customFieldKeys collects the custom field keys referenced by an expression
+func customFieldKeys(expr segment.Expr, keys []string) []string {
+	switch e := expr.(type) {
+	case segment.And:
+		return customFieldKeys(e.Right, customFieldKeys(e.Left, keys))
+	case segment.Or:
+		return customFieldKeys(e.Right, customFieldKeys(e.Left, keys))
+	case segment.Not:
+		return customFieldKeys(e.Expr, keys)
+	case segment.Term:
+		if key, ok := strings.CutPrefix(e.Field, segment.CustomFieldPrefix); ok {
+			return append(keys, key)
+		}
+	}
+	return keys
+}
+
+// userRecord exposes a user to expression matching
+func userRecord(user *domain.User) segment.Record {
+	return segment.Record{
+		Name:         user.Name,
+		Email:        user.Email,
+		Tags:         user.Tags,
+		CustomFields: user.CustomFields,
+		CreatedAt:    user.CreatedAt,
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/segment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateSegment(t *testing.T) {
+	defs := []*domain.CustomFieldDefinition{
+		{ID: 1, Entity: "user", Key: "tier", Type: domain.CustomFieldSelect, Options: []string{"gold"}},
+	}
+
+	tests := []struct {
+		name        string
+		request     domain.CreateSegmentRequest
+		needsDefs   bool
+		expectedErr bool
+	}{
+		{
+			name:    "tags and dates",
+			request: domain.CreateSegmentRequest{Name: "Webinar leads", Entity: "user", Expression: "tag:webinar AND created_after:30d"},
+		},
+		{
+			name:      "custom field",
+			request:   domain.CreateSegmentRequest{Name: "Gold", Entity: "user", Expression: "cf.tier:gold"},
+			needsDefs: true,
+		},
+		{
+			name:        "unknown custom field",
+			request:     domain.CreateSegmentRequest{Name: "Size", Entity: "user", Expression: "cf.size:large"},
+			needsDefs:   true,
+			expectedErr: true,
+		},
+		{
+			name:        "syntax error",
+			request:     domain.CreateSegmentRequest{Name: "Broken", Entity: "user", Expression: "tag:a AND"},
+			expectedErr: true,
+		},
+		{
+			name:        "missing name",
+			request:     domain.CreateSegmentRequest{Entity: "user", Expression: "tag:a"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockUserRepository)
+			if tc.needsDefs {
+				mockRepo.On("GetCustomFields", mock.Anything, "user").Return(defs, nil)
+			}
+			if !tc.expectedErr {
+				mockRepo.On("CreateSegment", mock.Anything, domain.Segment{
+					Name:       tc.request.Name,
+					Entity:     tc.request.Entity,
+					Expression: tc.request.Expression,
+				}).Return(1, nil)
+				mockRepo.On("AppendAuditEvent", mock.Anything, mock.AnythingOfType("domain.AuditEvent")).Return(1, nil)
+			}
+
+			service := NewService(mockRepo)
+			id, err := service.CreateSegment(context.Background(), tc.request)
+
+			if tc.expectedErr {
+				var verr ValidationError
+				assert.True(t, errors.As(err, &verr), "expected a validation error, got %v", err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, id)
+			}
+
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetSegmentMembers(t *testing.T) {
+	mockRepo := new(MockUserRepository)
+	mockRepo.On("GetSegment", mock.Anything, 3).Return(&domain.Segment{ID: 3, Entity: "user", Expression: "tag:vip"}, nil)
+	mockRepo.On("GetSegmentUsers", mock.Anything, segment.Term{Field: "tag", Value: "vip"}).Return([]*domain.User{
+		{ID: 7, Name: "Jane", Email: "jane@example.com", Tags: []string{"vip"}},
+	}, nil)
+
+	service := NewService(mockRepo)
+	members, err := service.GetSegmentMembers(context.Background(), 3)
+
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+	assert.Equal(t, []string{"vip"}, members[0].Tags)
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,376 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+)
+
+// sequence limits
+const (
+	MaxSequenceName  = 255
+	MaxSequenceSteps = 20
+	MaxWaitDays      = 90
+	MaxTaskLength    = 500
+	MaxEnrollmentIDs = 1000
+	// MaxSequenceAttempts is how many times a failing email step is tried
+	// before the enrollment is counted as bounced
+	MaxSequenceAttempts = 3
+	sequenceRetryDelay  = time.Hour
+	// sequenceLease is how long a claimed step may run before it is retried
+	sequenceLease      = 5 * time.Minute
+	sequenceClaimBatch = 50
+)
+
+// CreateSequence saves a sequence after checking its steps
+func (s *Service) CreateSequence(ctx context.Context, req domain.CreateSequenceRequest) (int, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return 0, ValidationError("name is required")
+	}
+	if utf8.RuneCountInString(name) > MaxSequenceName {
+		return 0, ValidationError(fmt.Sprintf("names are limited to %d characters", MaxSequenceName))
+	}
+	if !supportedEntities[req.Entity] {
+		return 0, ValidationError(fmt.Sprintf("unsupported entity %q", req.Entity))
+	}
+
+	if len(req.Steps) == 0 {
+		return 0, ValidationError("at least one step is required")
+	}
+	if len(req.Steps) > MaxSequenceSteps {
+		return 0, ValidationError(fmt.Sprintf("sequences are limited to %d steps", MaxSequenceSteps))
+	}
+	steps := make([]domain.SequenceStep, 0, len(req.Steps))
+	for i, step := range req.Steps {
+		normalized, err := s.validateStep(ctx, step)
+		if err != nil {
+			return 0, ValidationError(fmt.Sprintf("step %d: %v", i+1, err))
+		}
+		steps = append(steps, normalized)
+	}
+
+	sequence := domain.Sequence{
+		Name:   name,
+		Entity: req.Entity,
+		Steps:  steps,
+	}
+	var id int
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.repo.CreateSequence(ctx, sequence)
+		if err != nil {
+			return fmt.Errorf("service error - create sequence: %w", err)
+		}
+		sequence.ID = id
+		return s.audit(ctx, domain.AuditSequence, id, domain.AuditCreate, nil, sequence)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// validateStep checks a step and keeps only the settings of its type
+func (s *Service) validateStep(ctx context.Context, step domain.SequenceStep) (domain.SequenceStep, error) {
+	switch step.Type {
+	case domain.StepEmail:
+		if _, err := s.repo.GetEmailTemplate(ctx, step.TemplateID); err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return step, fmt.Errorf("email template %d does not exist", step.TemplateID)
+			}
+			return step, err
+		}
+		return domain.SequenceStep{Type: step.Type, TemplateID: step.TemplateID}, nil
+	case domain.StepWait:
+		if step.Days < 1 || step.Days > MaxWaitDays {
+			return step, fmt.Errorf("waits must be between 1 and %d business days", MaxWaitDays)
+		}
+		return domain.SequenceStep{Type: step.Type, Days: step.Days}, nil
+	case domain.StepTask:
+		task := strings.TrimSpace(step.Task)
+		if task == "" {
+			return step, errors.New("task is required")
+		}
+		if utf8.RuneCountInString(task) > MaxTaskLength {
+			return step, fmt.Errorf("tasks are limited to %d characters", MaxTaskLength)
+		}
+		return domain.SequenceStep{Type: step.Type, Task: task}, nil
+	default:
+		return step, fmt.Errorf("unknown step type %q", step.Type)
+	}
+}
+
+// GetSequences lists the sequences with their enrollment stats
+func (s *Service) GetSequences(ctx context.Context) ([]*domain.Sequence, error) {
+	sequences, err := s.repo.GetSequences(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get sequences: %w", err)
+	}
+	stats, err := s.repo.GetSequenceStats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get sequence stats: %w", err)
+	}
+	for _, sequence := range sequences {
+		sequence.Stats = statsOrEmpty(stats[sequence.ID])
+	}
+	if sequences == nil {
+		sequences = []*domain.Sequence{}
+	}
+	return sequences, nil
+}
+
+// GetSequence retrieves a sequence with its enrollment stats
+func (s *Service) GetSequence(ctx context.Context, id int) (*domain.Sequence, error) {
+	sequence, err := s.repo.GetSequence(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get sequence: %w", err)
+	}
+	stats, err := s.repo.GetSequenceStats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get sequence stats: %w", err)
+	}
+	sequence.Stats = statsOrEmpty(stats[id])
+	return sequence, nil
+}
+
+// statsOrEmpty returns zero counts for a sequence nobody was enrolled in
+func statsOrEmpty(stats *domain.SequenceStats) *domain.SequenceStats {
+	if stats == nil {
+		return &domain.SequenceStats{}
+	}
+	return stats
+}
+
+// DeleteSequence moves a sequence to the trash, its enrollments pause until
+// it is restored
+func (s *Service) DeleteSequence(ctx context.Context, id int) error {
+	before, err := s.repo.GetSequence(ctx, id)
+	if err != nil {
+		return fmt.Errorf("service error - get sequence: %w", err)
+	}
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.TrashRecord(ctx, domain.AuditSequence, id, expectedVersion(ctx), actorFrom(ctx).Name); err != nil {
+			return fmt.Errorf("service error - delete sequence: %w", err)
+		}
+		return s.audit(ctx, domain.AuditSequence, id, domain.AuditDelete, before, nil)
+	})
+}
+
+// GetSequenceEnrollments lists the latest enrollments of a sequence
+func (s *Service) GetSequenceEnrollments(ctx context.Context, id int) ([]*domain.SequenceEnrollment, error) {
+	if _, err := s.repo.GetSequence(ctx, id); err != nil {
+		return nil, fmt.Errorf("service error - get sequence: %w", err)
+	}
+	enrollments, err := s.repo.GetSequenceEnrollments(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get sequence enrollments: %w", err)
+	}
+	if enrollments == nil {
+		enrollments = []*domain.SequenceEnrollment{}
+	}
+	return enrollments, nil
+}
+
+// Enroll starts records on a sequence's first step and returns how many were
+// enrolled. Missing records and records already active in it are skipped.
+func (s *Service) Enroll(ctx context.Context, id int, req domain.EnrollmentRequest) (int, error) {
+	sequence, err := s.checkEnrollment(ctx, id, req)
+	if err != nil {
+		return 0, err
+	}
+	enrolled, err := s.repo.EnrollInSequence(ctx, sequence.ID, req.Entity, req.IDs, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("service error - enroll in sequence: %w", err)
+	}
+	return enrolled, nil
+}
+
+// Unenroll removes records from a sequence and returns how many were active in it
+func (s *Service) Unenroll(ctx context.Context, id int, req domain.EnrollmentRequest) (int, error) {
+	sequence, err := s.checkEnrollment(ctx, id, req)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := s.repo.StopEnrollments(ctx, sequence.ID, req.Entity, req.IDs, domain.EnrollmentRemoved)
+	if err != nil {
+		return 0, fmt.Errorf("service error - stop enrollments: %w", err)
+	}
+	return removed, nil
+}
+
+// checkEnrollment validates an enrollment request against its sequence
+func (s *Service) checkEnrollment(ctx context.Context, id int, req domain.EnrollmentRequest) (*domain.Sequence, error) {
+	sequence, err := s.repo.GetSequence(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get sequence: %w", err)
+	}
+	if req.Entity != sequence.Entity {
+		return nil, ValidationError(fmt.Sprintf("the sequence enrolls %s records", sequence.Entity))
+	}
+	if len(req.IDs) == 0 {
+		return nil, ValidationError("ids are required")
+	}
+	if len(req.IDs) > MaxEnrollmentIDs {
+		return nil, ValidationError(fmt.Sprintf("at most %d ids can be enrolled at once", MaxEnrollmentIDs))
+	}
+	return sequence, nil
+}
+
+// stopSequences ends a record's active enrollments in every sequence, for
+// example when it replies
+func (s *Service) stopSequences(ctx context.Context, entity string, id int, status string) error {
+	if _, err := s.repo.StopEnrollments(ctx, 0, entity, []int{id}, status); err != nil {
+		return fmt.Errorf("service error - stop enrollments: %w", err)
+	}
+	return nil
+}
+
+// RunSequences runs the steps that are due at now and returns how many ran.
+// Each enrollment runs one step per pass; a wait step schedules the next.
+func (s *Service) RunSequences(ctx context.Context, now time.Time) (int, error) {
+	enrollments, err := s.repo.ClaimSequenceEnrollments(ctx, now, now.Add(sequenceLease), sequenceClaimBatch)
+	if err != nil {
+		return 0, fmt.Errorf("service error - claim sequence enrollments: %w", err)
+	}
+
+	sequences := map[int]*domain.Sequence{}
+	for _, enrollment := range enrollments {
+		sequence, ok := sequences[enrollment.SequenceID]
+		if !ok {
+			sequence, err = s.repo.GetSequence(ctx, enrollment.SequenceID)
+			if err != nil {
+				return 0, fmt.Errorf("service error - get sequence: %w", err)
+			}
+			sequences[enrollment.SequenceID] = sequence
+		}
+
+		if err := s.runStep(ctx, sequence, enrollment, now); err != nil {
+			return 0, err
+		}
+		if err := s.repo.FinishSequenceStep(ctx, *enrollment); err != nil {
+			return 0, fmt.Errorf("service error - finish sequence step: %w", err)
+		}
+	}
+	return len(enrollments), nil
+}
+
+// runStep runs an enrollment's current step and moves it along. Problems with
+// the record or the sequence end the enrollment, other errors are returned.
+func (s *Service) runStep(ctx context.Context, sequence *domain.Sequence, enrollment *domain.SequenceEnrollment, now time.Time) error {
+	if enrollment.Step >= len(sequence.Steps) {
+		enrollment.Status = domain.EnrollmentCompleted
+		return nil
+	}
+	step := sequence.Steps[enrollment.Step]
+	next := now
+
+	switch step.Type {
+	case domain.StepEmail:
+		stopped, err := s.suppressedEnrollment(ctx, enrollment)
+		if err != nil || stopped {
+			return err
+		}
+		templateID := step.TemplateID
+		email, err := s.SendEmail(ctx, enrollment.Entity, enrollment.EntityID, domain.SendEmailRequest{TemplateID: &templateID})
+		var invalid ValidationError
+		if errors.As(err, &invalid) || errors.Is(err, repository.ErrNotFound) {
+			enrollment.Status = domain.EnrollmentFailed
+			enrollment.Error = err.Error()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if email.Status == domain.EmailFailed {
+			enrollment.Error = email.Error
+			if enrollment.Attempts < MaxSequenceAttempts {
+				enrollment.NextRunAt = now.Add(sequenceRetryDelay)
+			} else {
+				enrollment.Status = domain.EnrollmentBounced
+			}
+			return nil
+		}
+	case domain.StepWait:
+		next = addBusinessDays(now, step.Days)
+	case domain.StepTask:
+		if _, err := s.repo.CreateActivity(ctx, domain.Activity{
+			Entity:   enrollment.Entity,
+			EntityID: enrollment.EntityID,
+			Type:     domain.ActivityTask,
+			Summary:  "Task: " + step.Task,
+		}); err != nil {
+			return fmt.Errorf("service error - create activity: %w", err)
+		}
+	default:
+		log.Printf("Unknown step type %q in sequence %d", step.Type, sequence.ID)
+	}
+
+	enrollment.Step++
+	enrollment.Attempts = 0
+	enrollment.Error = ""
+	enrollment.NextRunAt = next
+	if enrollment.Step >= len(sequence.Steps) {
+		enrollment.Status = domain.EnrollmentCompleted
+	}
+	return nil
+}
+
+// suppressedEnrollment ends an enrollment whose record's address is on the
+// suppression list, as unsubscribed or bounced, and reports whether it did
+func (s *Service) suppressedEnrollment(ctx context.Context, enrollment *domain.SequenceEnrollment) (bool, error) {
+	user, err := s.repo.GetUser(ctx, enrollment.EntityID)
+	if errors.Is(err, repository.ErrNotFound) {
+		// SendEmail fails the enrollment
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("service error - get user: %w", err)
+	}
+	suppression, err := s.suppression(ctx, user.Email)
+	if err != nil || suppression == nil {
+		return false, err
+	}
+	enrollment.Status = domain.EnrollmentUnsubscribed
+	if suppression.Reason == domain.SuppressionBounced {
+		enrollment.Status = domain.EnrollmentBounced
+	}
+	return true, nil
+}
+
+// addBusinessDays moves t forward by days, skipping Saturdays and Sundays
+func addBusinessDays(t time.Time, days int) time.Time {
+	for days > 0 {
+		t = t.AddDate(0, 0, 1)
+		if t.Weekday() != time.Saturday && t.Weekday() != time.Sunday {
+			days--
+		}
+	}
+	return t
+}
+
+// StartSequences runs due sequence steps every poll until ctx is done
+func (s *Service) StartSequences(ctx context.Context, poll time.Duration) {
+	go func() {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.RunSequences(ctx, time.Now()); err != nil {
+					log.Printf("Error running sequences: %v", err)
+				}
+			}
+		}
+	}()
+}
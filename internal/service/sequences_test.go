@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/mailer"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequences(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*Service, *mailer.Capture, int, int) {
+		service := NewService(repository.NewMockRepository())
+		capture := mailer.NewCapture()
+		service.UseMailer(capture, EmailSettings{From: "sales@agency.example", TrackingURL: "https://crm.example"})
+		_, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada Lovelace", Email: "ada@example.com"})
+		require.NoError(t, err)
+		intro, err := service.CreateEmailTemplate(ctx, domain.CreateEmailTemplateRequest{Name: "Intro", Subject: "Hi {{.FirstName}}", BodyText: "Hello"})
+		require.NoError(t, err)
+		followUp, err := service.CreateEmailTemplate(ctx, domain.CreateEmailTemplateRequest{Name: "Follow up", Subject: "Following up", BodyText: "Any news?"})
+		require.NoError(t, err)
+
+		id, err := service.CreateSequence(ctx, domain.CreateSequenceRequest{
+			Name:   "Outreach",
+			Entity: domain.EntityUser,
+			Steps: []domain.SequenceStep{
+				{Type: domain.StepEmail, TemplateID: intro},
+				{Type: domain.StepWait, Days: 2},
+				{Type: domain.StepTask, Task: "Call Ada"},
+				{Type: domain.StepEmail, TemplateID: followUp},
+			},
+		})
+		require.NoError(t, err)
+		return service, capture, id, 1
+	}
+
+	t.Run("records run through every step", func(t *testing.T) {
+		service, capture, id, ada := setup(t)
+		enrolled, err := service.Enroll(ctx, id, domain.EnrollmentRequest{Entity: domain.EntityUser, IDs: []int{ada, 99}})
+		require.NoError(t, err)
+		assert.Equal(t, 1, enrolled)
+		// enrolling twice is a no-op while the first enrollment is active
+		enrolled, err = service.Enroll(ctx, id, domain.EnrollmentRequest{Entity: domain.EntityUser, IDs: []int{ada}})
+		require.NoError(t, err)
+		assert.Equal(t, 0, enrolled)
+
+		now := time.Now()
+		for i := 0; i < 2; i++ {
+			ran, err := service.RunSequences(ctx, now)
+			require.NoError(t, err)
+			assert.Equal(t, 1, ran)
+		}
+		require.Len(t, capture.Messages(), 1)
+		assert.Equal(t, "Hi Ada", capture.Messages()[0].Subject)
+
+		// waiting for two business days
+		ran, err := service.RunSequences(ctx, now)
+		require.NoError(t, err)
+		assert.Equal(t, 0, ran)
+		later := addBusinessDays(now, 2)
+		for i := 0; i < 2; i++ {
+			_, err := service.RunSequences(ctx, later)
+			require.NoError(t, err)
+		}
+		require.Len(t, capture.Messages(), 2)
+		assert.Equal(t, "Following up", capture.Messages()[1].Subject)
+
+		timeline, err := service.GetActivities(ctx, domain.EntityUser, ada)
+		require.NoError(t, err)
+		require.Len(t, timeline, 3)
+		assert.Equal(t, domain.ActivityTask, timeline[1].Type)
+		assert.Equal(t, "Task: Call Ada", timeline[1].Summary)
+
+		sequence, err := service.GetSequence(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, domain.SequenceStats{Enrolled: 1, Completed: 1}, *sequence.Stats)
+	})
+
+	t.Run("a reply stops the sequence", func(t *testing.T) {
+		service, capture, id, ada := setup(t)
+		_, err := service.Enroll(ctx, id, domain.EnrollmentRequest{Entity: domain.EntityUser, IDs: []int{ada}})
+		require.NoError(t, err)
+		_, err = service.RunSequences(ctx, time.Now())
+		require.NoError(t, err)
+
+		_, err = service.IngestEmail(ctx, strings.NewReader(rawEmail(
+			"ada@example.com", "sales@agency.example", "Re: Hi Ada", "<reply@example.com>",
+		)))
+		require.NoError(t, err)
+		ran, err := service.RunSequences(ctx, time.Now().AddDate(0, 1, 0))
+		require.NoError(t, err)
+		assert.Equal(t, 0, ran)
+		assert.Len(t, capture.Messages(), 1)
+
+		enrollments, err := service.GetSequenceEnrollments(ctx, id)
+		require.NoError(t, err)
+		require.Len(t, enrollments, 1)
+		assert.Equal(t, domain.EnrollmentReplied, enrollments[0].Status)
+		assert.Equal(t, 1, enrollments[0].Step)
+	})
+
+	t.Run("a bounce notification stops the sequence", func(t *testing.T) {
+		service, _, id, ada := setup(t)
+		_, err := service.Enroll(ctx, id, domain.EnrollmentRequest{Entity: domain.EntityUser, IDs: []int{ada}})
+		require.NoError(t, err)
+
+		bounce := "From: MAILER-DAEMON@mx.example.com\r\nTo: sales@agency.example\r\nSubject: Undelivered\r\n" +
+			"Content-Type: multipart/report; report-type=delivery-status; boundary=r\r\n\r\n" +
+			"--r\r\nContent-Type: message/delivery-status\r\n\r\n" +
+			"Final-Recipient: rfc822; ada@example.com\r\nAction: failed\r\n" +
+			"--r--\r\n"
+		_, err = service.IngestEmail(ctx, strings.NewReader(bounce))
+		require.NoError(t, err)
+
+		sequence, err := service.GetSequence(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, domain.SequenceStats{Enrolled: 1, Bounced: 1}, *sequence.Stats)
+
+		suppressions, err := service.GetSuppressions(ctx)
+		require.NoError(t, err)
+		require.Len(t, suppressions, 1)
+		assert.Equal(t, domain.SuppressionBounced, suppressions[0].Reason)
+	})
+
+	t.Run("failed sends are retried before counting as bounced", func(t *testing.T) {
+		service, _, id, ada := setup(t)
+		service.UseMailer(failingMailer{}, EmailSettings{From: "sales@agency.example"})
+		_, err := service.Enroll(ctx, id, domain.EnrollmentRequest{Entity: domain.EntityUser, IDs: []int{ada}})
+		require.NoError(t, err)
+
+		now := time.Now()
+		for i := 0; i < MaxSequenceAttempts; i++ {
+			ran, err := service.RunSequences(ctx, now)
+			require.NoError(t, err)
+			assert.Equal(t, 1, ran)
+			now = now.Add(sequenceRetryDelay)
+		}
+		enrollments, err := service.GetSequenceEnrollments(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, domain.EnrollmentBounced, enrollments[0].Status)
+		assert.Equal(t, "connection refused", enrollments[0].Error)
+	})
+
+	t.Run("records can be removed", func(t *testing.T) {
+		service, _, id, ada := setup(t)
+		_, err := service.Enroll(ctx, id, domain.EnrollmentRequest{Entity: domain.EntityUser, IDs: []int{ada}})
+		require.NoError(t, err)
+		removed, err := service.Unenroll(ctx, id, domain.EnrollmentRequest{Entity: domain.EntityUser, IDs: []int{ada}})
+		require.NoError(t, err)
+		assert.Equal(t, 1, removed)
+
+		sequences, err := service.GetSequences(ctx)
+		require.NoError(t, err)
+		require.Len(t, sequences, 1)
+		assert.Equal(t, 1, sequences[0].Stats.Removed)
+	})
+
+	t.Run("invalid sequences are rejected", func(t *testing.T) {
+		service, _, _, _ := setup(t)
+		for _, steps := range [][]domain.SequenceStep{
+			nil,
+			{{Type: domain.StepEmail, TemplateID: 99}},
+			{{Type: domain.StepWait, Days: 0}},
+			{{Type: domain.StepWait, Days: MaxWaitDays + 1}},
+			{{Type: domain.StepTask, Task: "  "}},
+			{{Type: "sms"}},
+		} {
+			_, err := service.CreateSequence(ctx, domain.CreateSequenceRequest{Name: "Bad", Entity: domain.EntityUser, Steps: steps})
+			assert.IsType(t, ValidationError(""), err, "steps %+v", steps)
+		}
+		_, err := service.CreateSequence(ctx, domain.CreateSequenceRequest{Name: "Bad", Entity: "deal", Steps: []domain.SequenceStep{{Type: domain.StepWait, Days: 1}}})
+		assert.IsType(t, ValidationError(""), err)
+	})
+}
+
+func TestAddBusinessDays(t *testing.T) {
+	thursday := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC), addBusinessDays(thursday, 1))
+	assert.Equal(t, time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC), addBusinessDays(thursday, 2))
+	assert.Equal(t, time.Date(2026, 10, 22, 9, 0, 0, 0, time.UTC), addBusinessDays(thursday, 5))
+}
@@ -2,38 +2,80 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/mailer"
 	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/dyrober/AgencyCRM/internal/segment"
 )
 
 // Service provides buisness logic operations
 type Service struct {
-	repo repository.UserRepository
+	repo repository.Store
+
+	// rescore queues records for the scoring worker, nil until StartScoring runs
+	rescore chan int
+
+	// changes fans record changes out to SubscribeChanges, fed once
+	// StartChangeFeed runs
+	changes *changeFeed
+
+	// httpClient makes outgoing calls such as automation webhooks
+	httpClient *http.Client
+
+	// mailer sends emails, it captures them until UseMailer is called
+	mailer        mailer.Mailer
+	emailSettings EmailSettings
 }
 
 // New Service creates a new service instance
-func NewService(repo repository.UserRepository) *Service {
+func NewService(repo repository.Store) *Service {
 	return &Service{
-		repo: repo,
+		repo:       repo,
+		changes:    newChangeFeed(),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		mailer:     mailer.NewCapture(),
+		emailSettings: EmailSettings{
+			From:              "crm@localhost",
+			TrackingURL:       "http://localhost:8080",
+			UnsubscribeSecret: newUnsubscribeSecret(),
+		},
 	}
 }
 
-// GetUsers retrevies all users
-func (s *Service) GetUsers(ctx context.Context) ([]*domain.UserResponse, error) {
-	users, err := s.repo.GetUsers(ctx)
+// supportedEntities lists the entities that can carry custom fields, tags and segments
+var supportedEntities = map[string]bool{
+	domain.EntityUser: true,
+}
+
+// ValidationError is returned when request data breaks a business rule,
+// its message is safe to show to the caller
+type ValidationError string
+
+func (e ValidationError) Error() string {
+	return string(e)
+}
+
+// GetUsers retrevies all users matching the list options
+func (s *Service) GetUsers(ctx context.Context, opts domain.ListOptions) ([]*domain.UserResponse, error) {
+	opts, err := s.normalizeListOptions(ctx, domain.EntityUser, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := s.repo.GetUsers(ctx, opts)
 	if err != nil {
 		return nil, fmt.Errorf("service error - get users: %w", err)
 	}
 	var response []*domain.UserResponse
 	for _, user := range users {
-		response = append(response, &domain.UserResponse{
-			ID:        user.ID,
-			Name:      user.Name,
-			Email:     user.Email,
-			CreatedAt: user.CreatedAt,
-		})
+		response = append(response, toUserResponse(user))
 	}
 	return response, nil
 }
@@ -44,24 +86,246 @@ func (s *Service) GetUser(ctx context.Context, id int) (*domain.UserResponse, er
 	if err != nil {
 		return nil, fmt.Errorf("Service error - get user: %w", err)
 	}
+	return toUserResponse(user), nil
+}
+
+// toUserResponse maps a stored user to its API representation
+func toUserResponse(user *domain.User) *domain.UserResponse {
 	return &domain.UserResponse{
-		ID:        user.ID,
-		Name:      user.Name,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt,
-	}, nil
+		ID:             user.ID,
+		Name:           user.Name,
+		Email:          user.Email,
+		CustomFields:   user.CustomFields,
+		Tags:           user.Tags,
+		Score:          user.Score,
+		ScoreBreakdown: user.ScoreBreakdown,
+		OwnerID:        user.OwnerID,
+		OutOfOffice:    user.OutOfOffice,
+		CreatedAt:      user.CreatedAt,
+		Version:        user.Version,
+	}
 }
 
 // Creates a new user
 func (s *Service) CreateUser(ctx context.Context, req domain.CreateUserRequest) (int, error) {
+	user, err := s.newUser(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.repo.InTx(ctx, func(ctx context.Context) error {
+		id, err := s.repo.CreateUser(ctx, user)
+		if errors.Is(err, repository.ErrDuplicate) {
+			return emailTaken(user.Email)
+		}
+		if err != nil {
+			return fmt.Errorf("Service error- create user: %w", err)
+		}
+		user.ID = id
+		return s.recordUserCreated(ctx, user)
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.userCreated(ctx, user)
+	return user.ID, nil
+}
+
+// emailTaken is the error for a user given the email address of another
+// live user
+func emailTaken(email string) error {
+	return ValidationError(fmt.Sprintf("a user with email %q already exists", email))
+}
+
+// newUser builds the user a create request asks for
+func (s *Service) newUser(ctx context.Context, req domain.CreateUserRequest) (domain.User, error) {
 	user := domain.User{
 		Name:  req.Name,
 		Email: req.Email,
 	}
 
-	id, err := s.repo.CreateUser(ctx, user)
+	if len(req.CustomFields) > 0 {
+		fields, err := s.validateCustomFields(ctx, domain.EntityUser, req.CustomFields)
+		if err != nil {
+			return user, err
+		}
+		user.CustomFields = fields
+	}
+	return user, nil
+}
+
+// recordUserCreated audits and routes a user in the unit of work creating it
+func (s *Service) recordUserCreated(ctx context.Context, user domain.User) error {
+	id := user.ID
+	if err := s.audit(ctx, domain.EntityUser, id, domain.AuditCreate, nil, user); err != nil {
+		return err
+	}
+
+	// a routing failure shouldn't fail the request, it only undoes its own writes
+	user.CreatedAt = time.Now()
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		return s.assignNewRecord(ctx, domain.EntityUser, id, userRecord(&user))
+	})
 	if err != nil {
-		return 0, fmt.Errorf("Service error- create user: %w", err)
+		log.Printf("Error assigning user %d: %v", id, err)
+	}
+	return nil
+}
+
+// userCreated runs the automations of a user once its creation has
+// committed and queues it for scoring
+func (s *Service) userCreated(ctx context.Context, user domain.User) {
+	id := user.ID
+	if err := s.dispatchEvent(ctx, recordEvent{entity: domain.EntityUser, id: id, trigger: domain.TriggerRecordCreated}); err != nil {
+		log.Printf("Error running automations for user %d: %v", id, err)
+	}
+	s.markForRescore(id)
+}
+
+// UpdateUser applies a partial update to a user
+func (s *Service) UpdateUser(ctx context.Context, id int, req domain.UpdateUserRequest) error {
+	return s.updateUser(ctx, id, req, expectedVersion(ctx), 0)
+}
+
+// updateUser applies the update, when the user still has version (0 for any),
+// and raises an updated event at the given automation depth, so changes made by
+// automations can't loop forever
+func (s *Service) updateUser(ctx context.Context, id int, req domain.UpdateUserRequest, version, depth int) error {
+	user, err := s.repo.GetUser(ctx, id)
+	if err != nil {
+		return fmt.Errorf("service error - get user: %w", err)
+	}
+	if version != 0 && user.Version != version {
+		return fmt.Errorf("service error - update user: %w", repository.ErrVersionConflict)
+	}
+	before := *user
+
+	changed, err := s.applyUserUpdate(ctx, user, req)
+	if err != nil || len(changed) == 0 {
+		return err
+	}
+
+	err = s.repo.InTx(ctx, func(ctx context.Context) error {
+		err := s.repo.UpdateUser(ctx, *user)
+		if errors.Is(err, repository.ErrDuplicate) {
+			return emailTaken(user.Email)
+		}
+		if err != nil {
+			return fmt.Errorf("service error - update user: %w", err)
+		}
+		return s.recordUserUpdated(ctx, &before, user)
+	})
+	if err != nil {
+		return err
+	}
+	s.userUpdated(ctx, user, changed, depth)
+	return nil
+}
+
+// applyUserUpdate applies a partial update to user and returns the fields
+// that changed
+func (s *Service) applyUserUpdate(ctx context.Context, user *domain.User, req domain.UpdateUserRequest) ([]string, error) {
+	var changed []string
+	if req.Name != nil && *req.Name != user.Name {
+		if strings.TrimSpace(*req.Name) == "" {
+			return nil, ValidationError("name cannot be blank")
+		}
+		user.Name = *req.Name
+		changed = append(changed, "name")
+	}
+	if req.Email != nil && *req.Email != user.Email {
+		if strings.TrimSpace(*req.Email) == "" {
+			return nil, ValidationError("email cannot be blank")
+		}
+		user.Email = *req.Email
+		changed = append(changed, "email")
+	}
+	if len(req.CustomFields) > 0 {
+		values, err := s.validateCustomFields(ctx, domain.EntityUser, req.CustomFields)
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]any, len(user.CustomFields)+len(values))
+		for key, value := range user.CustomFields {
+			fields[key] = value
+		}
+		for key := range req.CustomFields {
+			value, set := values[key]
+			old, had := fields[key]
+			switch {
+			case !set && had:
+				delete(fields, key)
+			case set && (!had || fmt.Sprint(old) != fmt.Sprint(value)):
+				fields[key] = value
+			default:
+				continue
+			}
+			changed = append(changed, segment.CustomFieldPrefix+key)
+		}
+		user.CustomFields = fields
+	}
+	return changed, nil
+}
+
+// recordUserUpdated audits a user and keeps the history of its tracked
+// fields in the unit of work updating it
+func (s *Service) recordUserUpdated(ctx context.Context, before, user *domain.User) error {
+	if err := s.audit(ctx, domain.EntityUser, user.ID, domain.AuditUpdate, *before, user); err != nil {
+		return err
+	}
+	return s.trackHistory(ctx, before, user)
+}
+
+// userUpdated raises the updated event of a user once its update has
+// committed, at the given automation depth, and queues it for scoring
+func (s *Service) userUpdated(ctx context.Context, user *domain.User, changed []string, depth int) {
+	id := user.ID
+	event := recordEvent{entity: domain.EntityUser, id: id, trigger: domain.TriggerRecordUpdated, changed: changed, depth: depth}
+	if err := s.dispatchEvent(ctx, event); err != nil {
+		log.Printf("Error running automations for user %d: %v", id, err)
+	}
+	s.markForRescore(id)
+}
+
+// DeleteUser moves a user to the trash
+func (s *Service) DeleteUser(ctx context.Context, id int) error {
+	before, err := s.repo.GetUser(ctx, id)
+	if err != nil {
+		return fmt.Errorf("service error - get user: %w", err)
+	}
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.TrashRecord(ctx, domain.EntityUser, id, expectedVersion(ctx), actorFrom(ctx).Name); err != nil {
+			return fmt.Errorf("service error - delete user: %w", err)
+		}
+		return s.audit(ctx, domain.EntityUser, id, domain.AuditDelete, before, nil)
+	})
+}
+
+// search limits keep typeahead responses small
+const (
+	DefaultSearchLimit = 10
+	MaxSearchLimit     = 50
+)
+
+// Search finds records matching the query across every searchable entity
+func (s *Service) Search(ctx context.Context, query string, limit int) ([]*domain.SearchResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return []*domain.SearchResult{}, nil
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	results, err := s.repo.Search(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("service error - search: %w", err)
+	}
+	if results == nil {
+		results = []*domain.SearchResult{}
 	}
-	return id, nil
+	return results, nil
 }
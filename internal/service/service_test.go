@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/segment"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -17,44 +18,893 @@ type MockUserRepository struct {
 	mock.Mock
 }
 
-func (m *MockUserRepository) GetUsers(ctx context.Context) ([]*domain.User, error) {
+func (m *MockUserRepository) GetUsers(ctx context.Context, opts domain.ListOptions) ([]*domain.User, error) {
+	args := m.Called(ctx, opts)
+
+	// Handle the first return value, which should be []*domain.User
+	users, ok := args.Get(0).([]*domain.User)
+	if !ok && args.Get(0) != nil {
+		// This will happen if the wrong type was returned from the mock setup
+		return nil, fmt.Errorf("unexpected type for users return value")
+	}
+
+	// Handle the second return value, which should be an error
+	var err error
+	if errArg := args.Error(1); errArg != nil {
+		err = errArg
+	}
+
+	return users, err
+}
+
+// Mock implementation of GetUser
+func (m *MockUserRepository) GetUser(ctx context.Context, id int) (*domain.User, error) {
+	args := m.Called(ctx, id)
+
+	// If we're expected to return a user, return the value provided to the mock
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.User), args.Error(1)
+	}
+
+	// Otherwise return nil and the error
+	return nil, args.Error(1)
+}
+
+// Mock implementation of CreateUser
+func (m *MockUserRepository) CreateUser(ctx context.Context, user domain.User) (int, error) {
+	args := m.Called(ctx, user)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of Search
+func (m *MockUserRepository) Search(ctx context.Context, query string, limit int) ([]*domain.SearchResult, error) {
+	args := m.Called(ctx, query, limit)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.SearchResult), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of CreateCustomField
+func (m *MockUserRepository) CreateCustomField(ctx context.Context, def domain.CustomFieldDefinition) (int, error) {
+	args := m.Called(ctx, def)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetCustomFields
+func (m *MockUserRepository) GetCustomFields(ctx context.Context, entity string) ([]*domain.CustomFieldDefinition, error) {
+	args := m.Called(ctx, entity)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.CustomFieldDefinition), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of DeleteCustomField
+func (m *MockUserRepository) DeleteCustomField(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// Mock implementation of GetTags
+func (m *MockUserRepository) GetTags(ctx context.Context) ([]*domain.Tag, error) {
+	args := m.Called(ctx)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.Tag), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of CreateTag
+func (m *MockUserRepository) CreateTag(ctx context.Context, name string) (int, error) {
+	args := m.Called(ctx, name)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of AddTags
+func (m *MockUserRepository) AddTags(ctx context.Context, entity string, ids []int, tags []string) (int, error) {
+	args := m.Called(ctx, entity, ids, tags)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of RemoveTags
+func (m *MockUserRepository) RemoveTags(ctx context.Context, entity string, ids []int, tags []string) (int, error) {
+	args := m.Called(ctx, entity, ids, tags)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of CreateSegment
+func (m *MockUserRepository) CreateSegment(ctx context.Context, seg domain.Segment) (int, error) {
+	args := m.Called(ctx, seg)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetSegment
+func (m *MockUserRepository) GetSegment(ctx context.Context, id int) (*domain.Segment, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.Segment), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetSegments
+func (m *MockUserRepository) GetSegments(ctx context.Context) ([]*domain.Segment, error) {
+	args := m.Called(ctx)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.Segment), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of DeleteSegment
+func (m *MockUserRepository) DeleteSegment(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// Mock implementation of GetSegmentUsers
+func (m *MockUserRepository) GetSegmentUsers(ctx context.Context, expr segment.Expr) ([]*domain.User, error) {
+	args := m.Called(ctx, expr)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.User), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of CountSegmentUsers
+func (m *MockUserRepository) CountSegmentUsers(ctx context.Context, expr segment.Expr) (int, error) {
+	args := m.Called(ctx, expr)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetUserBatch
+func (m *MockUserRepository) GetUserBatch(ctx context.Context, afterID, limit int) ([]*domain.User, error) {
+	args := m.Called(ctx, afterID, limit)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.User), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of CreateScoringRule
+func (m *MockUserRepository) CreateScoringRule(ctx context.Context, rule domain.ScoringRule) (int, error) {
+	args := m.Called(ctx, rule)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetScoringRules
+func (m *MockUserRepository) GetScoringRules(ctx context.Context, entity string) ([]*domain.ScoringRule, error) {
+	args := m.Called(ctx, entity)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.ScoringRule), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetScoringRule
+func (m *MockUserRepository) GetScoringRule(ctx context.Context, id int) (*domain.ScoringRule, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.ScoringRule), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of DeleteScoringRule
+func (m *MockUserRepository) DeleteScoringRule(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// Mock implementation of UpdateUserScore
+func (m *MockUserRepository) UpdateUserScore(ctx context.Context, id int, score int, breakdown []domain.ScoreContribution) error {
+	args := m.Called(ctx, id, score, breakdown)
+	return args.Error(0)
+}
+
+// Mock implementation of CreateAssignmentRule
+func (m *MockUserRepository) CreateAssignmentRule(ctx context.Context, rule domain.AssignmentRule) (int, error) {
+	args := m.Called(ctx, rule)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetAssignmentRules
+func (m *MockUserRepository) GetAssignmentRules(ctx context.Context, entity string) ([]*domain.AssignmentRule, error) {
+	args := m.Called(ctx, entity)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.AssignmentRule), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetAssignmentRule
+func (m *MockUserRepository) GetAssignmentRule(ctx context.Context, id int) (*domain.AssignmentRule, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.AssignmentRule), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of DeleteAssignmentRule
+func (m *MockUserRepository) DeleteAssignmentRule(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// Mock implementation of NextAssignmentTurn
+func (m *MockUserRepository) NextAssignmentTurn(ctx context.Context, ruleID int) (int, error) {
+	args := m.Called(ctx, ruleID)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetAvailableUsers
+func (m *MockUserRepository) GetAvailableUsers(ctx context.Context, ids []int) ([]int, error) {
+	args := m.Called(ctx, ids)
+	if args.Get(0) != nil {
+		return args.Get(0).([]int), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of CountOwnedRecords
+func (m *MockUserRepository) CountOwnedRecords(ctx context.Context, entity string, ownerIDs []int) (map[int]int, error) {
+	args := m.Called(ctx, entity, ownerIDs)
+	if args.Get(0) != nil {
+		return args.Get(0).(map[int]int), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of AssignOwner
+func (m *MockUserRepository) AssignOwner(ctx context.Context, entity string, ids []int, ownerID int, ruleID *int, reason string) (int, error) {
+	args := m.Called(ctx, entity, ids, ownerID, ruleID, reason)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetAssignments
+func (m *MockUserRepository) GetAssignments(ctx context.Context, entity string, entityID int) ([]*domain.Assignment, error) {
+	args := m.Called(ctx, entity, entityID)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.Assignment), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of SetOutOfOffice
+func (m *MockUserRepository) SetOutOfOffice(ctx context.Context, userID int, outOfOffice bool, version int) error {
+	args := m.Called(ctx, userID, outOfOffice, version)
+	return args.Error(0)
+}
+
+// Mock implementation of UpdateUser
+func (m *MockUserRepository) UpdateUser(ctx context.Context, user domain.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+// Mock implementation of CreateAutomation
+func (m *MockUserRepository) CreateAutomation(ctx context.Context, automation domain.Automation) (int, error) {
+	args := m.Called(ctx, automation)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetAutomations
+func (m *MockUserRepository) GetAutomations(ctx context.Context, entity string) ([]*domain.Automation, error) {
+	args := m.Called(ctx, entity)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.Automation), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetAutomation
+func (m *MockUserRepository) GetAutomation(ctx context.Context, id int) (*domain.Automation, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.Automation), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of DeleteAutomation
+func (m *MockUserRepository) DeleteAutomation(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// Mock implementation of CreateAutomationRun
+func (m *MockUserRepository) CreateAutomationRun(ctx context.Context, run domain.AutomationRun) (int, bool, error) {
+	args := m.Called(ctx, run)
+	return args.Int(0), args.Bool(1), args.Error(2)
+}
+
+// Mock implementation of ClaimAutomationRuns
+func (m *MockUserRepository) ClaimAutomationRuns(ctx context.Context, now time.Time, limit int) ([]*domain.AutomationRun, error) {
+	args := m.Called(ctx, now, limit)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.AutomationRun), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of FinishAutomationRun
+func (m *MockUserRepository) FinishAutomationRun(ctx context.Context, run domain.AutomationRun) error {
+	args := m.Called(ctx, run)
+	return args.Error(0)
+}
+
+// Mock implementation of GetAutomationRuns
+func (m *MockUserRepository) GetAutomationRuns(ctx context.Context, automationID int) ([]*domain.AutomationRun, error) {
+	args := m.Called(ctx, automationID)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.AutomationRun), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of CreateWebhookSubscription
+func (m *MockUserRepository) CreateWebhookSubscription(ctx context.Context, subscription domain.WebhookSubscription) (int, error) {
+	args := m.Called(ctx, subscription)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetWebhookSubscriptions
+func (m *MockUserRepository) GetWebhookSubscriptions(ctx context.Context) ([]*domain.WebhookSubscription, error) {
+	args := m.Called(ctx)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.WebhookSubscription), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetWebhookSubscription
+func (m *MockUserRepository) GetWebhookSubscription(ctx context.Context, id int) (*domain.WebhookSubscription, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.WebhookSubscription), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of DeleteWebhookSubscription
+func (m *MockUserRepository) DeleteWebhookSubscription(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// Mock implementation of FanOutOutbox
+func (m *MockUserRepository) FanOutOutbox(ctx context.Context, now time.Time, limit int) (int, error) {
+	args := m.Called(ctx, now, limit)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of ClaimWebhookDeliveries
+func (m *MockUserRepository) ClaimWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]*domain.WebhookDelivery, error) {
+	args := m.Called(ctx, now, limit)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.WebhookDelivery), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of FinishWebhookDelivery
+func (m *MockUserRepository) FinishWebhookDelivery(ctx context.Context, delivery domain.WebhookDelivery) error {
+	args := m.Called(ctx, delivery)
+	return args.Error(0)
+}
+
+// Mock implementation of GetWebhookDeliveries
+func (m *MockUserRepository) GetWebhookDeliveries(ctx context.Context, subscriptionID int) ([]*domain.WebhookDelivery, error) {
+	args := m.Called(ctx, subscriptionID)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.WebhookDelivery), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of RedeliverWebhook
+func (m *MockUserRepository) RedeliverWebhook(ctx context.Context, id int, now time.Time) error {
+	args := m.Called(ctx, id, now)
+	return args.Error(0)
+}
+
+// Mock implementation of GetUserByEmail
+func (m *MockUserRepository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	args := m.Called(ctx, email)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.User), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of CreateForm
+func (m *MockUserRepository) CreateForm(ctx context.Context, form domain.Form) (int, error) {
+	args := m.Called(ctx, form)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetForms
+func (m *MockUserRepository) GetForms(ctx context.Context) ([]*domain.Form, error) {
+	args := m.Called(ctx)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.Form), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetForm
+func (m *MockUserRepository) GetForm(ctx context.Context, id int) (*domain.Form, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.Form), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetFormByKey
+func (m *MockUserRepository) GetFormByKey(ctx context.Context, key string) (*domain.Form, error) {
+	args := m.Called(ctx, key)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.Form), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of DeleteForm
+func (m *MockUserRepository) DeleteForm(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// Mock implementation of CreateFormSubmission
+func (m *MockUserRepository) CreateFormSubmission(ctx context.Context, submission domain.FormSubmission) (int, error) {
+	args := m.Called(ctx, submission)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetFormSubmissions
+func (m *MockUserRepository) GetFormSubmissions(ctx context.Context, formID int) ([]*domain.FormSubmission, error) {
+	args := m.Called(ctx, formID)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.FormSubmission), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of CreateEmailTemplate
+func (m *MockUserRepository) CreateEmailTemplate(ctx context.Context, template domain.EmailTemplate) (int, error) {
+	args := m.Called(ctx, template)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetEmailTemplates
+func (m *MockUserRepository) GetEmailTemplates(ctx context.Context) ([]*domain.EmailTemplate, error) {
+	args := m.Called(ctx)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.EmailTemplate), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetEmailTemplate
+func (m *MockUserRepository) GetEmailTemplate(ctx context.Context, id int) (*domain.EmailTemplate, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.EmailTemplate), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of DeleteEmailTemplate
+func (m *MockUserRepository) DeleteEmailTemplate(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// Mock implementation of CreateEmail
+func (m *MockUserRepository) CreateEmail(ctx context.Context, email domain.Email) (int, error) {
+	args := m.Called(ctx, email)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetEmails
+func (m *MockUserRepository) GetEmails(ctx context.Context, entity string, entityID int) ([]*domain.Email, error) {
+	args := m.Called(ctx, entity, entityID)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.Email), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetEmailByToken
+func (m *MockUserRepository) GetEmailByToken(ctx context.Context, token string) (*domain.Email, error) {
+	args := m.Called(ctx, token)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.Email), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of RecordEmailEvent
+func (m *MockUserRepository) RecordEmailEvent(ctx context.Context, event domain.EmailEvent) error {
+	args := m.Called(ctx, event)
+	return args.Error(0)
+}
+
+// Mock implementation of CreateActivity
+func (m *MockUserRepository) CreateActivity(ctx context.Context, activity domain.Activity) (int, error) {
+	args := m.Called(ctx, activity)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetActivities
+func (m *MockUserRepository) GetActivities(ctx context.Context, entity string, entityID int) ([]*domain.Activity, error) {
+	args := m.Called(ctx, entity, entityID)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.Activity), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetEmail
+func (m *MockUserRepository) GetEmail(ctx context.Context, id int) (*domain.Email, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.Email), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetEmailByMessageID
+func (m *MockUserRepository) GetEmailByMessageID(ctx context.Context, messageID string) (*domain.Email, error) {
+	args := m.Called(ctx, messageID)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.Email), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of CreateInboundEmail
+func (m *MockUserRepository) CreateInboundEmail(ctx context.Context, email domain.Email, attachments []domain.EmailAttachment, activities []domain.Activity) (int, error) {
+	args := m.Called(ctx, email, attachments, activities)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetEmailAttachments
+func (m *MockUserRepository) GetEmailAttachments(ctx context.Context, emailID int) ([]*domain.EmailAttachment, error) {
+	args := m.Called(ctx, emailID)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.EmailAttachment), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetEmailAttachment
+func (m *MockUserRepository) GetEmailAttachment(ctx context.Context, emailID, id int) (*domain.EmailAttachment, error) {
+	args := m.Called(ctx, emailID, id)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.EmailAttachment), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of CreateSequence
+func (m *MockUserRepository) CreateSequence(ctx context.Context, sequence domain.Sequence) (int, error) {
+	args := m.Called(ctx, sequence)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetSequences
+func (m *MockUserRepository) GetSequences(ctx context.Context) ([]*domain.Sequence, error) {
 	args := m.Called(ctx)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.Sequence), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
 
-	// Handle the first return value, which should be []*domain.User
-	users, ok := args.Get(0).([]*domain.User)
-	if !ok && args.Get(0) != nil {
-		// This will happen if the wrong type was returned from the mock setup
-		return nil, fmt.Errorf("unexpected type for users return value")
+// Mock implementation of GetSequence
+func (m *MockUserRepository) GetSequence(ctx context.Context, id int) (*domain.Sequence, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.Sequence), args.Error(1)
 	}
+	return nil, args.Error(1)
+}
 
-	// Handle the second return value, which should be an error
-	var err error
-	if errArg := args.Error(1); errArg != nil {
-		err = errArg
+// Mock implementation of DeleteSequence
+func (m *MockUserRepository) DeleteSequence(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// Mock implementation of GetSequenceStats
+func (m *MockUserRepository) GetSequenceStats(ctx context.Context) (map[int]*domain.SequenceStats, error) {
+	args := m.Called(ctx)
+	if args.Get(0) != nil {
+		return args.Get(0).(map[int]*domain.SequenceStats), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of EnrollInSequence
+func (m *MockUserRepository) EnrollInSequence(ctx context.Context, sequenceID int, entity string, ids []int, startAt time.Time) (int, error) {
+	args := m.Called(ctx, sequenceID, entity, ids, startAt)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetSequenceEnrollments
+func (m *MockUserRepository) GetSequenceEnrollments(ctx context.Context, sequenceID int) ([]*domain.SequenceEnrollment, error) {
+	args := m.Called(ctx, sequenceID)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.SequenceEnrollment), args.Error(1)
 	}
+	return nil, args.Error(1)
+}
 
-	return users, err
+// Mock implementation of StopEnrollments
+func (m *MockUserRepository) StopEnrollments(ctx context.Context, sequenceID int, entity string, ids []int, status string) (int, error) {
+	args := m.Called(ctx, sequenceID, entity, ids, status)
+	return args.Int(0), args.Error(1)
 }
 
-// Mock implementation of GetUser
-func (m *MockUserRepository) GetUser(ctx context.Context, id int) (*domain.User, error) {
+// Mock implementation of ClaimSequenceEnrollments
+func (m *MockUserRepository) ClaimSequenceEnrollments(ctx context.Context, now, leaseUntil time.Time, limit int) ([]*domain.SequenceEnrollment, error) {
+	args := m.Called(ctx, now, leaseUntil, limit)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.SequenceEnrollment), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of FinishSequenceStep
+func (m *MockUserRepository) FinishSequenceStep(ctx context.Context, enrollment domain.SequenceEnrollment) error {
+	args := m.Called(ctx, enrollment)
+	return args.Error(0)
+}
+
+// Mock implementation of CreateConsent
+func (m *MockUserRepository) CreateConsent(ctx context.Context, consent domain.Consent) (int, error) {
+	args := m.Called(ctx, consent)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetConsents
+func (m *MockUserRepository) GetConsents(ctx context.Context, entity string, entityID int) ([]*domain.Consent, error) {
+	args := m.Called(ctx, entity, entityID)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.Consent), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of AddSuppression
+func (m *MockUserRepository) AddSuppression(ctx context.Context, suppression domain.EmailSuppression) error {
+	args := m.Called(ctx, suppression)
+	return args.Error(0)
+}
+
+// Mock implementation of GetSuppression
+func (m *MockUserRepository) GetSuppression(ctx context.Context, email string) (*domain.EmailSuppression, error) {
+	args := m.Called(ctx, email)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.EmailSuppression), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetSuppressions
+func (m *MockUserRepository) GetSuppressions(ctx context.Context) ([]*domain.EmailSuppression, error) {
+	args := m.Called(ctx)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.EmailSuppression), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of DeleteSuppression
+func (m *MockUserRepository) DeleteSuppression(ctx context.Context, id int) error {
 	args := m.Called(ctx, id)
+	return args.Error(0)
+}
 
-	// If we're expected to return a user, return the value provided to the mock
+// Mock implementation of LiftSuppression
+func (m *MockUserRepository) LiftSuppression(ctx context.Context, email, reason string) error {
+	args := m.Called(ctx, email, reason)
+	return args.Error(0)
+}
+
+// Mock implementation of CreatePrivacyRequest
+func (m *MockUserRepository) CreatePrivacyRequest(ctx context.Context, request domain.PrivacyRequest) (int, error) {
+	args := m.Called(ctx, request)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetPrivacyRequests
+func (m *MockUserRepository) GetPrivacyRequests(ctx context.Context, status string) ([]*domain.PrivacyRequest, error) {
+	args := m.Called(ctx, status)
 	if args.Get(0) != nil {
-		return args.Get(0).(*domain.User), args.Error(1)
+		return args.Get(0).([]*domain.PrivacyRequest), args.Error(1)
 	}
+	return nil, args.Error(1)
+}
 
-	// Otherwise return nil and the error
+// Mock implementation of GetPrivacyRequest
+func (m *MockUserRepository) GetPrivacyRequest(ctx context.Context, id int) (*domain.PrivacyRequest, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.PrivacyRequest), args.Error(1)
+	}
 	return nil, args.Error(1)
 }
 
-// Mock implementation of CreateUser
-func (m *MockUserRepository) CreateUser(ctx context.Context, user domain.User) (int, error) {
-	args := m.Called(ctx, user)
+// Mock implementation of CompletePrivacyRequest
+func (m *MockUserRepository) CompletePrivacyRequest(ctx context.Context, id int, completedAt time.Time) error {
+	args := m.Called(ctx, id, completedAt)
+	return args.Error(0)
+}
+
+// Mock implementation of ClaimPrivacyReminder
+func (m *MockUserRepository) ClaimPrivacyReminder(ctx context.Context, id int, now, since time.Time) (bool, error) {
+	args := m.Called(ctx, id, now, since)
+	return args.Bool(0), args.Error(1)
+}
+
+// Mock implementation of GetPersonalData
+func (m *MockUserRepository) GetPersonalData(ctx context.Context, entity string, entityID int) (*domain.PersonalData, error) {
+	args := m.Called(ctx, entity, entityID)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.PersonalData), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of ErasePersonalData
+func (m *MockUserRepository) ErasePersonalData(ctx context.Context, entity string, entityID int) error {
+	args := m.Called(ctx, entity, entityID)
+	return args.Error(0)
+}
+
+// Mock implementation of AppendAuditEvent
+func (m *MockUserRepository) AppendAuditEvent(ctx context.Context, event domain.AuditEvent) (int, error) {
+	args := m.Called(ctx, event)
+	return args.Int(0), args.Error(1)
+}
+
+// Mock implementation of GetAuditEvents
+func (m *MockUserRepository) GetAuditEvents(ctx context.Context, filter domain.AuditFilter) ([]*domain.AuditEvent, error) {
+	args := m.Called(ctx, filter)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.AuditEvent), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetCustomField
+func (m *MockUserRepository) GetCustomField(ctx context.Context, id int) (*domain.CustomFieldDefinition, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.CustomFieldDefinition), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of UpdateCustomField
+func (m *MockUserRepository) UpdateCustomField(ctx context.Context, def domain.CustomFieldDefinition) error {
+	args := m.Called(ctx, def)
+	return args.Error(0)
+}
+
+// Mock implementation of RecordFieldChanges
+func (m *MockUserRepository) RecordFieldChanges(ctx context.Context, changes []domain.FieldChange) error {
+	args := m.Called(ctx, changes)
+	return args.Error(0)
+}
+
+// Mock implementation of GetFieldHistory
+func (m *MockUserRepository) GetFieldHistory(ctx context.Context, entity string, entityID int, since *time.Time) ([]*domain.FieldChange, error) {
+	args := m.Called(ctx, entity, entityID, since)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.FieldChange), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of DeleteUser
+func (m *MockUserRepository) DeleteUser(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// Mock implementation of TrashRecord
+func (m *MockUserRepository) TrashRecord(ctx context.Context, entity string, id, version int, deletedBy string) error {
+	args := m.Called(ctx, entity, id, version, deletedBy)
+	return args.Error(0)
+}
+
+// Mock implementation of RestoreRecord
+func (m *MockUserRepository) RestoreRecord(ctx context.Context, entity string, id int) error {
+	args := m.Called(ctx, entity, id)
+	return args.Error(0)
+}
+
+// Mock implementation of GetTrashItem
+func (m *MockUserRepository) GetTrashItem(ctx context.Context, entity string, id int) (*domain.TrashItem, error) {
+	args := m.Called(ctx, entity, id)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.TrashItem), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of GetTrash
+func (m *MockUserRepository) GetTrash(ctx context.Context, entity string, before *time.Time) ([]*domain.TrashItem, error) {
+	args := m.Called(ctx, entity, before)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.TrashItem), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of ClaimIdempotencyKey
+func (m *MockUserRepository) ClaimIdempotencyKey(ctx context.Context, key domain.IdempotencyKey) (*domain.IdempotencyKey, error) {
+	args := m.Called(ctx, key)
+	if args.Get(0) != nil {
+		return args.Get(0).(*domain.IdempotencyKey), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of CompleteIdempotencyKey
+func (m *MockUserRepository) CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, headers map[string]string, body []byte, expiresAt time.Time) error {
+	args := m.Called(ctx, key, statusCode, headers, body, expiresAt)
+	return args.Error(0)
+}
+
+// Mock implementation of ReleaseIdempotencyKey
+func (m *MockUserRepository) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
+
+// Mock implementation of DeleteExpiredIdempotencyKeys
+func (m *MockUserRepository) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	args := m.Called(ctx, now)
 	return args.Int(0), args.Error(1)
 }
 
+// Mock implementation of GetUsersByID
+func (m *MockUserRepository) GetUsersByID(ctx context.Context, ids []int) ([]*domain.User, error) {
+	args := m.Called(ctx, ids)
+	if args.Get(0) != nil {
+		return args.Get(0).([]*domain.User), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of WriteUsers
+func (m *MockUserRepository) WriteUsers(ctx context.Context, writes []domain.UserWrite, atomic bool) ([]error, error) {
+	args := m.Called(ctx, writes, atomic)
+	if args.Get(0) != nil {
+		return args.Get(0).([]error), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Mock implementation of InTx, which runs fn without expecting a call so
+// tests only set up the calls made inside it
+func (m *MockUserRepository) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// Mock implementation of ListenChanges
+func (m *MockUserRepository) ListenChanges(ctx context.Context, fn func(domain.ChangeEvent)) error {
+	args := m.Called(ctx, fn)
+	return args.Error(0)
+}
+
 func (m *MockUserRepository) Close() error {
 	args := m.Called()
 	return args.Error(0)
@@ -167,6 +1017,11 @@ func TestCreateUser(t *testing.T) {
 
 			// Set expectations on mock
 			mockRepo.On("CreateUser", mock.Anything, expectedUser).Return(tc.mockID, tc.mockErr)
+			if tc.mockErr == nil {
+				mockRepo.On("GetAssignmentRules", mock.Anything, "user").Return(nil, nil)
+				mockRepo.On("GetAutomations", mock.Anything, "user").Return(nil, nil)
+				mockRepo.On("AppendAuditEvent", mock.Anything, mock.AnythingOfType("domain.AuditEvent")).Return(1, nil)
+			}
 
 			// Create service with mock repo
 			service := NewService(mockRepo)
@@ -188,3 +1043,70 @@ func TestCreateUser(t *testing.T) {
 		})
 	}
 }
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		limit         int
+		expectedLimit int
+		mockResults   []*domain.SearchResult
+		mockErr       error
+		expectedLen   int
+		expectedErr   bool
+		skipRepo      bool
+	}{
+		{
+			name:          "success with default limit",
+			query:         "john",
+			limit:         0,
+			expectedLimit: DefaultSearchLimit,
+			mockResults:   []*domain.SearchResult{{Type: "user", ID: 1, Title: "John Doe"}},
+			expectedLen:   1,
+		},
+		{
+			name:          "limit is capped",
+			query:         "john",
+			limit:         1000,
+			expectedLimit: MaxSearchLimit,
+			mockResults:   nil,
+			expectedLen:   0,
+		},
+		{
+			name:        "blank query skips repository",
+			query:       "   ",
+			expectedLen: 0,
+			skipRepo:    true,
+		},
+		{
+			name:          "repository error",
+			query:         "john",
+			expectedLimit: DefaultSearchLimit,
+			mockErr:       errors.New("database error"),
+			expectedErr:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockUserRepository)
+			if !tc.skipRepo {
+				mockRepo.On("Search", mock.Anything, tc.query, tc.expectedLimit).Return(tc.mockResults, tc.mockErr)
+			}
+
+			service := NewService(mockRepo)
+			results, err := service.Search(context.Background(), tc.query, tc.limit)
+
+			if tc.expectedErr {
+				assert.Error(t, err)
+				assert.Nil(t, results)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, results)
+				assert.Len(t, results, tc.expectedLen)
+			}
+
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
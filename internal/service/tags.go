@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// limits for tags and bulk tagging
+const (
+	MaxTagLength  = 50
+	MaxBulkTagIDs = 1000
+)
+
+// GetTags lists the tag registry with usage counts
+func (s *Service) GetTags(ctx context.Context) ([]*domain.Tag, error) {
+	tags, err := s.repo.GetTags(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get tags: %w", err)
+	}
+	if tags == nil {
+		tags = []*domain.Tag{}
+	}
+	return tags, nil
+}
+
+// CreateTag adds a tag to the registry
+func (s *Service) CreateTag(ctx context.Context, req domain.CreateTagRequest) (int, error) {
+	name, err := normalizeTag(req.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = s.repo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.repo.CreateTag(ctx, name)
+		if err != nil {
+			return fmt.Errorf("service error - create tag: %w", err)
+		}
+		return s.audit(ctx, domain.AuditTag, id, domain.AuditCreate, nil, domain.Tag{ID: id, Name: name})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// AddTags tags every record in the request, registering new tags on the way
+func (s *Service) AddTags(ctx context.Context, req domain.BulkTagRequest) (int, error) {
+	tags, err := validateBulkTagRequest(req)
+	if err != nil {
+		return 0, err
+	}
+
+	var added int
+	err = s.auditUsers(ctx, req.IDs, func(ctx context.Context) (err error) {
+		added, err = s.repo.AddTags(ctx, req.Entity, req.IDs, tags)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("service error - add tags: %w", err)
+	}
+	s.markForRescore(req.IDs...)
+	return added, nil
+}
+
+// RemoveTags untags every record in the request
+func (s *Service) RemoveTags(ctx context.Context, req domain.BulkTagRequest) (int, error) {
+	tags, err := validateBulkTagRequest(req)
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int
+	err = s.auditUsers(ctx, req.IDs, func(ctx context.Context) (err error) {
+		removed, err = s.repo.RemoveTags(ctx, req.Entity, req.IDs, tags)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("service error - remove tags: %w", err)
+	}
+	s.markForRescore(req.IDs...)
+	return removed, nil
+}
+
+// validateBulkTagRequest checks the request and returns the normalized, de-duplicated tags
+func validateBulkTagRequest(req domain.BulkTagRequest) ([]string, error) {
+	if !supportedEntities[req.Entity] {
+		return nil, ValidationError(fmt.Sprintf("unsupported entity %q", req.Entity))
+	}
+	if len(req.IDs) == 0 {
+		return nil, ValidationError("ids are required")
+	}
+	if len(req.IDs) > MaxBulkTagIDs {
+		return nil, ValidationError(fmt.Sprintf("at most %d ids can be tagged at once", MaxBulkTagIDs))
+	}
+	if len(req.Tags) == 0 {
+		return nil, ValidationError("tags are required")
+	}
+
+	tags := make([]string, 0, len(req.Tags))
+	for _, raw := range req.Tags {
+		tag, err := normalizeTag(raw)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// normalizeTag lowercases and trims a tag so "Webinar " and "webinar" are the same tag
+func normalizeTag(raw string) (string, error) {
+	tag := strings.ToLower(strings.TrimSpace(raw))
+	if tag == "" {
+		return "", ValidationError("tag names cannot be blank")
+	}
+	if utf8.RuneCountInString(tag) > MaxTagLength {
+		return "", ValidationError(fmt.Sprintf("tag names are limited to %d characters", MaxTagLength))
+	}
+	if strings.ContainsAny(tag, `"()`) {
+		return "", ValidationError(`tag names cannot contain quotes or parentheses`)
+	}
+	return tag, nil
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAddTags(t *testing.T) {
+	tests := []struct {
+		name         string
+		request      domain.BulkTagRequest
+		expectedTags []string
+		expectedErr  bool
+	}{
+		{
+			name:         "normalizes and de-duplicates tags",
+			request:      domain.BulkTagRequest{Entity: "user", IDs: []int{1, 2}, Tags: []string{"Webinar ", "webinar", "VIP"}},
+			expectedTags: []string{"webinar", "vip"},
+		},
+		{
+			name:        "unknown entity",
+			request:     domain.BulkTagRequest{Entity: "lead", IDs: []int{1}, Tags: []string{"a"}},
+			expectedErr: true,
+		},
+		{
+			name:        "no ids",
+			request:     domain.BulkTagRequest{Entity: "user", Tags: []string{"a"}},
+			expectedErr: true,
+		},
+		{
+			name:        "no tags",
+			request:     domain.BulkTagRequest{Entity: "user", IDs: []int{1}},
+			expectedErr: true,
+		},
+		{
+			name:        "blank tag",
+			request:     domain.BulkTagRequest{Entity: "user", IDs: []int{1}, Tags: []string{"  "}},
+			expectedErr: true,
+		},
+		{
+			name:        "tag too long",
+			request:     domain.BulkTagRequest{Entity: "user", IDs: []int{1}, Tags: []string{strings.Repeat("a", MaxTagLength+1)}},
+			expectedErr: true,
+		},
+		{
+			name:        "tag with quotes",
+			request:     domain.BulkTagRequest{Entity: "user", IDs: []int{1}, Tags: []string{`say "hi"`}},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockUserRepository)
+			if !tc.expectedErr {
+				// users that can't be read before the change aren't audited
+				mockRepo.On("GetUser", mock.Anything, mock.Anything).Return(nil, repository.ErrNotFound)
+				mockRepo.On("AddTags", mock.Anything, tc.request.Entity, tc.request.IDs, tc.expectedTags).Return(4, nil)
+			}
+
+			service := NewService(mockRepo)
+			added, err := service.AddTags(context.Background(), tc.request)
+
+			if tc.expectedErr {
+				var verr ValidationError
+				assert.True(t, errors.As(err, &verr), "expected a validation error, got %v", err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, 4, added)
+			}
+
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+)
+
+// trashPurgers deletes a trashed record of each entity that can be trashed
+// for good
+var trashPurgers = map[string]func(repository.Store, context.Context, int) error{
+	domain.EntityUser:          repository.Store.DeleteUser,
+	domain.AuditCustomField:    repository.Store.DeleteCustomField,
+	domain.AuditSegment:        repository.Store.DeleteSegment,
+	domain.AuditScoringRule:    repository.Store.DeleteScoringRule,
+	domain.AuditAssignmentRule: repository.Store.DeleteAssignmentRule,
+	domain.AuditAutomation:     repository.Store.DeleteAutomation,
+	domain.AuditWebhook:        repository.Store.DeleteWebhookSubscription,
+	domain.AuditForm:           repository.Store.DeleteForm,
+	domain.AuditEmailTemplate:  repository.Store.DeleteEmailTemplate,
+	domain.AuditSequence:       repository.Store.DeleteSequence,
+}
+
+// checkTrashEntity rejects entities that can't be trashed
+func checkTrashEntity(entity string) error {
+	if _, ok := trashPurgers[entity]; !ok {
+		return ValidationError(fmt.Sprintf("unsupported entity %q", entity))
+	}
+	return nil
+}
+
+// GetTrash lists the trashed records of an entity, or of every entity when
+// entity is empty, newest first
+func (s *Service) GetTrash(ctx context.Context, entity string) ([]*domain.TrashItem, error) {
+	if entity != "" {
+		if err := checkTrashEntity(entity); err != nil {
+			return nil, err
+		}
+	}
+	items, err := s.repo.GetTrash(ctx, entity, nil)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get trash: %w", err)
+	}
+	if items == nil {
+		items = []*domain.TrashItem{}
+	}
+	return items, nil
+}
+
+// RestoreFromTrash takes a trashed record back out of the trash. It fails
+// with repository.ErrDuplicate when a live record took the record's email
+// address or key while it was in the trash.
+func (s *Service) RestoreFromTrash(ctx context.Context, entity string, id int) error {
+	if err := checkTrashEntity(entity); err != nil {
+		return err
+	}
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.RestoreRecord(ctx, entity, id); err != nil {
+			return fmt.Errorf("service error - restore record: %w", err)
+		}
+		return s.audit(ctx, entity, id, domain.AuditRestore, nil, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	switch entity {
+	case domain.EntityUser:
+		s.markForRescore(id)
+	case domain.AuditScoringRule:
+		s.markForRescore(rescoreEverything)
+	}
+	return nil
+}
+
+// PurgeFromTrash deletes a trashed record for good. Live records have to be
+// trashed first.
+func (s *Service) PurgeFromTrash(ctx context.Context, entity string, id int) error {
+	if err := checkTrashEntity(entity); err != nil {
+		return err
+	}
+	item, err := s.repo.GetTrashItem(ctx, entity, id)
+	if err != nil {
+		return fmt.Errorf("service error - get trash item: %w", err)
+	}
+	return s.purge(ctx, item)
+}
+
+// PurgeExpiredTrash deletes the records trashed before the given time for
+// good and returns how many it deleted
+func (s *Service) PurgeExpiredTrash(ctx context.Context, before time.Time) (int, error) {
+	items, err := s.repo.GetTrash(ctx, "", &before)
+	if err != nil {
+		return 0, fmt.Errorf("service error - get trash: %w", err)
+	}
+	for i, item := range items {
+		if err := s.purge(ctx, item); err != nil {
+			return i, err
+		}
+	}
+	return len(items), nil
+}
+
+// purge deletes a trashed record for good and audits it
+func (s *Service) purge(ctx context.Context, item *domain.TrashItem) error {
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := trashPurgers[item.Entity](s.repo, ctx, item.ID); err != nil {
+			return fmt.Errorf("service error - purge %s: %w", item.Entity, err)
+		}
+		return s.audit(ctx, item.Entity, item.ID, domain.AuditPurge, nil, nil)
+	})
+}
+
+// StartTrashPurge starts a background worker that deletes records that have
+// been in the trash longer than retention for good
+func (s *Service) StartTrashPurge(ctx context.Context, poll, retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.PurgeExpiredTrash(ctx, time.Now().Add(-retention)); err != nil {
+					log.Printf("Error purging the trash: %v", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrash(t *testing.T) {
+	ctx := WithActor(context.Background(), domain.Actor{Name: "jane"})
+
+	t.Run("deleted records are trashed and can be restored", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+		segment, err := service.CreateSegment(ctx, domain.CreateSegmentRequest{Name: "VIPs", Entity: domain.EntityUser, Expression: "tag:vip"})
+		require.NoError(t, err)
+
+		require.NoError(t, service.DeleteUser(ctx, id))
+		require.NoError(t, service.DeleteSegment(ctx, segment))
+
+		_, err = service.GetUser(ctx, id)
+		assert.True(t, errors.Is(err, repository.ErrNotFound), "expected a trashed user to be hidden, got %v", err)
+		users, err := service.GetUsers(ctx, domain.ListOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, users)
+
+		items, err := service.GetTrash(ctx, "")
+		require.NoError(t, err)
+		require.Len(t, items, 2)
+		assert.Equal(t, domain.AuditSegment, items[0].Entity)
+		assert.Equal(t, "VIPs", items[0].Name)
+		assert.Equal(t, "jane", items[1].DeletedBy)
+
+		items, err = service.GetTrash(ctx, domain.EntityUser)
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+		assert.Equal(t, "Ada", items[0].Name)
+
+		require.NoError(t, service.RestoreFromTrash(ctx, domain.EntityUser, id))
+		user, err := service.GetUser(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, "Ada", user.Name)
+
+		err = service.RestoreFromTrash(ctx, domain.EntityUser, id)
+		assert.True(t, errors.Is(err, repository.ErrNotFound), "expected a live user not to be restorable, got %v", err)
+
+		events, err := service.GetAuditEvents(ctx, domain.AuditFilter{Entity: domain.EntityUser, EntityID: id})
+		require.NoError(t, err)
+		require.Len(t, events, 3)
+		assert.Equal(t, domain.AuditDelete, events[1].Action)
+		assert.Equal(t, domain.AuditRestore, events[2].Action)
+	})
+
+	t.Run("purged records are gone for good", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+
+		err = service.PurgeFromTrash(ctx, domain.EntityUser, id)
+		assert.True(t, errors.Is(err, repository.ErrNotFound), "expected live records to need trashing first, got %v", err)
+
+		require.NoError(t, service.DeleteUser(ctx, id))
+		require.NoError(t, service.PurgeFromTrash(ctx, domain.EntityUser, id))
+
+		items, err := service.GetTrash(ctx, "")
+		require.NoError(t, err)
+		assert.Empty(t, items)
+		err = service.RestoreFromTrash(ctx, domain.EntityUser, id)
+		assert.True(t, errors.Is(err, repository.ErrNotFound), "expected a purged user to be gone, got %v", err)
+
+		// the user's personal data was erased before the row went
+		events, err := service.GetAuditEvents(ctx, domain.AuditFilter{Entity: domain.EntityUser, EntityID: id})
+		require.NoError(t, err)
+		require.NotEmpty(t, events)
+		assert.True(t, events[0].Redacted)
+		assert.Equal(t, domain.AuditPurge, events[len(events)-1].Action)
+	})
+
+	t.Run("the retention job purges expired records", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		form, _, err := service.CreateForm(ctx, domain.CreateFormRequest{
+			Name: "Contact", Entity: domain.EntityUser, FieldMappings: map[string]string{"email": "email"},
+		})
+		require.NoError(t, err)
+		require.NoError(t, service.DeleteForm(ctx, form))
+
+		purged, err := service.PurgeExpiredTrash(ctx, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Zero(t, purged)
+
+		purged, err = service.PurgeExpiredTrash(ctx, time.Now().Add(time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, 1, purged)
+		items, err := service.GetTrash(ctx, domain.AuditForm)
+		require.NoError(t, err)
+		assert.Empty(t, items)
+	})
+
+	t.Run("unknown entities are rejected", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		_, err := service.GetTrash(ctx, "deal")
+		assert.ErrorAs(t, err, new(ValidationError))
+		err = service.RestoreFromTrash(ctx, domain.AuditSuppression, 1)
+		assert.ErrorAs(t, err, new(ValidationError))
+	})
+}
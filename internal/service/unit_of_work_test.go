@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitOfWork(t *testing.T) {
+	ctx := context.Background()
+	failed := errors.New("give up")
+
+	t.Run("a failed unit leaves the store as it was", func(t *testing.T) {
+		repo := repository.NewMockRepository()
+		service := NewService(repo)
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+
+		err = repo.InTx(ctx, func(ctx context.Context) error {
+			name := "Ada Lovelace"
+			if err := service.UpdateUser(ctx, id, domain.UpdateUserRequest{Name: &name}); err != nil {
+				return err
+			}
+			if _, err := service.AddTags(ctx, domain.BulkTagRequest{Entity: domain.EntityUser, IDs: []int{id}, Tags: []string{"vip"}}); err != nil {
+				return err
+			}
+			if _, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Grace", Email: "grace@example.com"}); err != nil {
+				return err
+			}
+			return failed
+		})
+		assert.ErrorIs(t, err, failed)
+
+		user, err := service.GetUser(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, "Ada", user.Name)
+		assert.Empty(t, user.Tags)
+		assert.Equal(t, 1, user.Version)
+		users, err := service.GetUsers(ctx, domain.ListOptions{})
+		require.NoError(t, err)
+		assert.Len(t, users, 1)
+
+		// IDs handed out by the rolled back unit are handed out again
+		next, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Grace", Email: "grace@example.com"})
+		require.NoError(t, err)
+		assert.Equal(t, id+1, next)
+	})
+
+	t.Run("a failed nested unit only undoes its own part", func(t *testing.T) {
+		repo := repository.NewMockRepository()
+		service := NewService(repo)
+
+		var kept int
+		err := repo.InTx(ctx, func(ctx context.Context) error {
+			var err error
+			kept, err = service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+			if err != nil {
+				return err
+			}
+			nested := repo.InTx(ctx, func(ctx context.Context) error {
+				if err := service.DeleteUser(ctx, kept); err != nil {
+					return err
+				}
+				return failed
+			})
+			assert.ErrorIs(t, nested, failed)
+			return nil
+		})
+		require.NoError(t, err)
+
+		_, err = service.GetUser(ctx, kept)
+		assert.NoError(t, err)
+		trash, err := service.GetTrash(ctx, "")
+		require.NoError(t, err)
+		assert.Empty(t, trash)
+	})
+}
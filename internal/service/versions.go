@@ -0,0 +1,20 @@
+package service
+
+import "context"
+
+// versionKey is the context key of the expected version
+type versionKey struct{}
+
+// WithExpectedVersion returns a context whose writes only go through while
+// the record still has version, e.g. the one sent in an If-Match header
+func WithExpectedVersion(ctx context.Context, version int) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+// expectedVersion returns the version a context expects, 0 to skip the check.
+// Only the record a request names is checked, so public methods read it and
+// pass it down rather than letting automations and other side effects see it.
+func expectedVersion(ctx context.Context) int {
+	version, _ := ctx.Value(versionKey{}).(int)
+	return version
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("every change bumps the version", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+		version := func() int {
+			user, err := service.GetUser(ctx, id)
+			require.NoError(t, err)
+			return user.Version
+		}
+		assert.Equal(t, 1, version())
+
+		name := "Ada Lovelace"
+		require.NoError(t, service.UpdateUser(ctx, id, domain.UpdateUserRequest{Name: &name}))
+		assert.Equal(t, 2, version())
+		_, err = service.AddTags(ctx, domain.BulkTagRequest{Entity: domain.EntityUser, IDs: []int{id}, Tags: []string{"vip"}})
+		require.NoError(t, err)
+		assert.Equal(t, 3, version())
+		require.NoError(t, service.SetOutOfOffice(ctx, id, true))
+		assert.Equal(t, 4, version())
+
+		// updates that change nothing keep the version
+		require.NoError(t, service.UpdateUser(ctx, id, domain.UpdateUserRequest{Name: &name}))
+		assert.Equal(t, 4, version())
+	})
+
+	t.Run("stale writes are rejected", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+		require.NoError(t, err)
+		stale := WithExpectedVersion(ctx, 1)
+
+		name := "Ada Lovelace"
+		require.NoError(t, service.UpdateUser(stale, id, domain.UpdateUserRequest{Name: &name}))
+
+		other := "Augusta"
+		err = service.UpdateUser(stale, id, domain.UpdateUserRequest{Name: &other})
+		assert.True(t, errors.Is(err, repository.ErrVersionConflict), "expected a conflict, got %v", err)
+		err = service.SetOutOfOffice(stale, id, true)
+		assert.True(t, errors.Is(err, repository.ErrVersionConflict), "expected a conflict, got %v", err)
+		err = service.DeleteUser(stale, id)
+		assert.True(t, errors.Is(err, repository.ErrVersionConflict), "expected a conflict, got %v", err)
+
+		user, err := service.GetUser(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, "Ada Lovelace", user.Name)
+		require.NoError(t, service.DeleteUser(WithExpectedVersion(ctx, user.Version), id))
+	})
+
+	t.Run("other records check their versions too", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		field, err := service.CreateCustomField(ctx, domain.CreateCustomFieldRequest{
+			Entity: domain.EntityUser, Key: "budget", Label: "Budget", Type: domain.CustomFieldNumber,
+		})
+		require.NoError(t, err)
+		segment, err := service.CreateSegment(ctx, domain.CreateSegmentRequest{Name: "VIPs", Entity: domain.EntityUser, Expression: "tag:vip"})
+		require.NoError(t, err)
+
+		label := "Annual budget"
+		err = service.UpdateCustomField(WithExpectedVersion(ctx, 2), field, domain.UpdateCustomFieldRequest{Label: &label})
+		assert.True(t, errors.Is(err, repository.ErrVersionConflict), "expected a conflict, got %v", err)
+		require.NoError(t, service.UpdateCustomField(WithExpectedVersion(ctx, 1), field, domain.UpdateCustomFieldRequest{Label: &label}))
+		err = service.DeleteCustomField(WithExpectedVersion(ctx, 1), field)
+		assert.True(t, errors.Is(err, repository.ErrVersionConflict), "expected a conflict, got %v", err)
+
+		err = service.DeleteSegment(WithExpectedVersion(ctx, 3), segment)
+		assert.True(t, errors.Is(err, repository.ErrVersionConflict), "expected a conflict, got %v", err)
+		require.NoError(t, service.DeleteSegment(WithExpectedVersion(ctx, 1), segment))
+	})
+}
@@ -0,0 +1,271 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+)
+
+// webhook limits
+const (
+	// MaxWebhookAttempts is how many times a failing delivery is tried
+	MaxWebhookAttempts = 8
+	MinWebhookSecret   = 16
+	MaxWebhookSecret   = 255
+	webhookRetryDelay  = 30 * time.Second
+	webhookBatchSize   = 100
+)
+
+// Headers sent with every webhook delivery
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// webhookEvents lists the events subscribers can ask for
+var webhookEvents = []string{domain.EventUserCreated, domain.EventUserUpdated, domain.EventAll}
+
+// CreateWebhook subscribes a URL to events and returns the subscription ID with
+// its signing secret, generating one when the request has none
+func (s *Service) CreateWebhook(ctx context.Context, req domain.CreateWebhookRequest) (int, string, error) {
+	target, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return 0, "", ValidationError("url must be an absolute http or https URL")
+	}
+	if len(req.Events) == 0 {
+		return 0, "", ValidationError("at least one event is required")
+	}
+	var events []string
+	for _, event := range req.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return 0, "", ValidationError(fmt.Sprintf("unsupported event %q", event))
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+
+	secret := req.Secret
+	switch {
+	case secret == "":
+		secret, err = randomHex(32)
+		if err != nil {
+			return 0, "", fmt.Errorf("service error - generate webhook secret: %w", err)
+		}
+	case len(secret) < MinWebhookSecret || len(secret) > MaxWebhookSecret:
+		return 0, "", ValidationError(fmt.Sprintf("secrets must be %d to %d characters", MinWebhookSecret, MaxWebhookSecret))
+	}
+
+	subscription := domain.WebhookSubscription{
+		URL:    target.String(),
+		Secret: secret,
+		Events: events,
+	}
+	var id int
+	err = s.repo.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.repo.CreateWebhookSubscription(ctx, subscription)
+		if err != nil {
+			return fmt.Errorf("service error - create webhook: %w", err)
+		}
+		subscription.ID = id
+		return s.audit(ctx, domain.AuditWebhook, id, domain.AuditCreate, nil, subscription)
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	return id, secret, nil
+}
+
+// GetWebhooks lists the webhook subscriptions without their secrets
+func (s *Service) GetWebhooks(ctx context.Context) ([]*domain.WebhookSubscription, error) {
+	subscriptions, err := s.repo.GetWebhookSubscriptions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get webhooks: %w", err)
+	}
+	if subscriptions == nil {
+		subscriptions = []*domain.WebhookSubscription{}
+	}
+	for _, subscription := range subscriptions {
+		subscription.Secret = ""
+	}
+	return subscriptions, nil
+}
+
+// GetWebhook retrieves a webhook subscription without its secret
+func (s *Service) GetWebhook(ctx context.Context, id int) (*domain.WebhookSubscription, error) {
+	subscription, err := s.repo.GetWebhookSubscription(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get webhook: %w", err)
+	}
+	subscription.Secret = ""
+	return subscription, nil
+}
+
+// DeleteWebhook moves a webhook subscription to the trash, its pending
+// deliveries wait until it is restored
+func (s *Service) DeleteWebhook(ctx context.Context, id int) error {
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.TrashRecord(ctx, domain.AuditWebhook, id, expectedVersion(ctx), actorFrom(ctx).Name); err != nil {
+			return fmt.Errorf("service error - delete webhook: %w", err)
+		}
+		return s.audit(ctx, domain.AuditWebhook, id, domain.AuditDelete, nil, nil)
+	})
+}
+
+// GetWebhookDeliveries lists the latest deliveries of a subscription
+func (s *Service) GetWebhookDeliveries(ctx context.Context, subscriptionID int) ([]*domain.WebhookDelivery, error) {
+	if _, err := s.repo.GetWebhookSubscription(ctx, subscriptionID); err != nil {
+		return nil, fmt.Errorf("service error - get webhook: %w", err)
+	}
+	deliveries, err := s.repo.GetWebhookDeliveries(ctx, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("service error - get webhook deliveries: %w", err)
+	}
+	if deliveries == nil {
+		deliveries = []*domain.WebhookDelivery{}
+	}
+	return deliveries, nil
+}
+
+// RedeliverWebhook queues a delivery to be sent again on the next dispatch
+func (s *Service) RedeliverWebhook(ctx context.Context, id int) error {
+	if err := s.repo.RedeliverWebhook(ctx, id, time.Now()); err != nil {
+		return fmt.Errorf("service error - redeliver webhook: %w", err)
+	}
+	return nil
+}
+
+// DispatchWebhooks queues deliveries for new outbox events, then sends the
+// deliveries that are due and returns how many it attempted
+func (s *Service) DispatchWebhooks(ctx context.Context) (int, error) {
+	for {
+		processed, err := s.repo.FanOutOutbox(ctx, time.Now(), webhookBatchSize)
+		if err != nil {
+			return 0, fmt.Errorf("service error - fan out outbox: %w", err)
+		}
+		if processed < webhookBatchSize {
+			break
+		}
+	}
+
+	deliveries, err := s.repo.ClaimWebhookDeliveries(ctx, time.Now(), webhookBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("service error - claim webhook deliveries: %w", err)
+	}
+
+	subscriptions := map[int]*domain.WebhookSubscription{}
+	for _, delivery := range deliveries {
+		subscription, ok := subscriptions[delivery.SubscriptionID]
+		if !ok {
+			subscription, err = s.repo.GetWebhookSubscription(ctx, delivery.SubscriptionID)
+			if err != nil {
+				return 0, fmt.Errorf("service error - get webhook: %w", err)
+			}
+			subscriptions[delivery.SubscriptionID] = subscription
+		}
+
+		code, sendErr := s.sendWebhook(ctx, subscription, delivery)
+		delivery.ResponseCode = code
+		delivery.Error = ""
+
+		switch {
+		case sendErr == nil:
+			now := time.Now()
+			delivery.Status = domain.DeliveryDelivered
+			delivery.DeliveredAt = &now
+		case delivery.Attempts < MaxWebhookAttempts:
+			// back off 30s, 1m, 2m... before trying again
+			delivery.Status = domain.DeliveryPending
+			delivery.Error = sendErr.Error()
+			delivery.NextAttemptAt = time.Now().Add(webhookRetryDelay << (delivery.Attempts - 1))
+		default:
+			delivery.Status = domain.DeliveryFailed
+			delivery.Error = sendErr.Error()
+		}
+
+		if err := s.repo.FinishWebhookDelivery(ctx, *delivery); err != nil {
+			return 0, fmt.Errorf("service error - finish webhook delivery: %w", err)
+		}
+	}
+	return len(deliveries), nil
+}
+
+// sendWebhook POSTs a signed delivery to the subscriber. Any 2xx answer counts as delivered.
+func (s *Service) sendWebhook(ctx context.Context, subscription *domain.WebhookSubscription, delivery *domain.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(map[string]any{
+		"id":         delivery.OutboxID,
+		"event":      delivery.Event,
+		"created_at": delivery.CreatedAt,
+		"data":       delivery.Payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(subscription.Secret, timestamp, body))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%s answered %d", subscription.URL, resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook computes the signature header value for a delivery: the hex
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the subscription secret.
+// Receivers recompute it and compare with hmac.Equal.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// StartWebhooks runs the webhook dispatcher every poll until ctx is done
+func (s *Service) StartWebhooks(ctx context.Context, poll time.Duration) {
+	go func() {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.DispatchWebhooks(ctx); err != nil {
+					log.Printf("Error dispatching webhooks: %v", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dyrober/AgencyCRM/internal/domain"
+	"github.com/dyrober/AgencyCRM/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookReceiver records the events it receives and answers with status
+type webhookReceiver struct {
+	secret string
+	status int
+	events []map[string]any
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	expected := SignWebhook(rcv.secret, r.Header.Get(WebhookTimestampHeader), body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(WebhookSignatureHeader))) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var event map[string]any
+	_ = json.Unmarshal(body, &event)
+	rcv.events = append(rcv.events, event)
+	w.WriteHeader(rcv.status)
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	const secret = "0123456789abcdef0123"
+
+	t.Run("events are signed and delivered", func(t *testing.T) {
+		receiver := &webhookReceiver{secret: secret, status: http.StatusOK}
+		server := httptest.NewServer(receiver)
+		defer server.Close()
+
+		service := NewService(repository.NewMockRepository())
+		webhookID, _, err := service.CreateWebhook(ctx, domain.CreateWebhookRequest{
+			URL: server.URL, Secret: secret, Events: []string{domain.EventUserCreated},
+		})
+		require.NoError(t, err)
+
+		id, err := service.CreateUser(ctx, domain.CreateUserRequest{Name: "Lead", Email: "lead@example.com"})
+		require.NoError(t, err)
+		// updates aren't subscribed to
+		name := "Renamed"
+		require.NoError(t, service.UpdateUser(ctx, id, domain.UpdateUserRequest{Name: &name}))
+
+		sent, err := service.DispatchWebhooks(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, sent)
+
+		require.Len(t, receiver.events, 1)
+		assert.Equal(t, domain.EventUserCreated, receiver.events[0]["event"])
+		data := receiver.events[0]["data"].(map[string]any)
+		assert.Equal(t, "lead@example.com", data["email"])
+
+		deliveries, err := service.GetWebhookDeliveries(ctx, webhookID)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, domain.DeliveryDelivered, deliveries[0].Status)
+		assert.Equal(t, http.StatusOK, deliveries[0].ResponseCode)
+		assert.NotNil(t, deliveries[0].DeliveredAt)
+	})
+
+	t.Run("failures back off and can be redelivered", func(t *testing.T) {
+		// the receiver has the wrong secret so every signature check fails
+		receiver := &webhookReceiver{secret: "another-secret-entirely", status: http.StatusOK}
+		server := httptest.NewServer(receiver)
+		defer server.Close()
+
+		service := NewService(repository.NewMockRepository())
+		webhookID, _, err := service.CreateWebhook(ctx, domain.CreateWebhookRequest{
+			URL: server.URL, Secret: secret, Events: []string{domain.EventAll},
+		})
+		require.NoError(t, err)
+		_, err = service.CreateUser(ctx, domain.CreateUserRequest{Name: "Lead", Email: "lead@example.com"})
+		require.NoError(t, err)
+
+		_, err = service.DispatchWebhooks(ctx)
+		require.NoError(t, err)
+		deliveries, err := service.GetWebhookDeliveries(ctx, webhookID)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, domain.DeliveryPending, deliveries[0].Status)
+		assert.Equal(t, http.StatusUnauthorized, deliveries[0].ResponseCode)
+		assert.True(t, deliveries[0].NextAttemptAt.After(time.Now().Add(20*time.Second)))
+
+		// nothing is due until the backoff has passed
+		sent, err := service.DispatchWebhooks(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, sent)
+
+		receiver.secret = secret
+		require.NoError(t, service.RedeliverWebhook(ctx, deliveries[0].ID))
+		sent, err = service.DispatchWebhooks(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, sent)
+
+		deliveries, err = service.GetWebhookDeliveries(ctx, webhookID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.DeliveryDelivered, deliveries[0].Status)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+	})
+
+	t.Run("secrets are generated and hidden from listings", func(t *testing.T) {
+		service := NewService(repository.NewMockRepository())
+		_, generated, err := service.CreateWebhook(ctx, domain.CreateWebhookRequest{
+			URL: "https://example.com/hook", Events: []string{domain.EventUserUpdated},
+		})
+		require.NoError(t, err)
+		assert.Len(t, generated, 64)
+
+		webhooks, err := service.GetWebhooks(ctx)
+		require.NoError(t, err)
+		require.Len(t, webhooks, 1)
+		assert.Empty(t, webhooks[0].Secret)
+	})
+}
+
+func TestCreateWebhookValidation(t *testing.T) {
+	service := NewService(repository.NewMockRepository())
+
+	tests := []struct {
+		name    string
+		request domain.CreateWebhookRequest
+	}{
+		{"relative url", domain.CreateWebhookRequest{URL: "/hook", Events: []string{domain.EventUserCreated}}},
+		{"no events", domain.CreateWebhookRequest{URL: "https://example.com/hook"}},
+		{"unknown event", domain.CreateWebhookRequest{URL: "https://example.com/hook", Events: []string{"deal.stage_changed"}}},
+		{"short secret", domain.CreateWebhookRequest{URL: "https://example.com/hook", Secret: "short", Events: []string{domain.EventUserCreated}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := service.CreateWebhook(context.Background(), tc.request)
+			assert.IsType(t, ValidationError(""), err)
+		})
+	}
+}
@@ -10,9 +10,11 @@ CREATE TABLE IF NOT EXISTS users (
 -- Create index on email for faster lookups
 CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
 
--- Add sample data (optional)
+-- Add sample data (optional). The conflict has no target, so it is the unique
+-- email on a new database and the live index on LOWER(email) from migration
+-- 022 when this is run again, deleted_at doesn't exist yet to name that one.
 INSERT INTO users (name, email, created_at, updated_at)
 VALUES 
     ('John Doe', 'john@example.com', NOW(), NOW()),
     ('Jane Smith', 'jane@example.com', NOW(), NOW())
-ON CONFLICT (email) DO NOTHING;
\ No newline at end of file
+ON CONFLICT DO NOTHING;
\ No newline at end of file
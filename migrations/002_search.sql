@@ -0,0 +1,14 @@
+-- Enable trigram matching for partial names and emails
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Weighted search document: names rank above emails
+ALTER TABLE users ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(email, '')), 'B')
+    ) STORED;
+
+-- Create indexes for full-text and trigram lookups
+CREATE INDEX IF NOT EXISTS idx_users_search ON users USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_users_name_trgm ON users USING GIN (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON users USING GIN (email gin_trgm_ops);
@@ -0,0 +1,18 @@
+-- Create table for admin-defined custom fields
+CREATE TABLE IF NOT EXISTS custom_field_definitions (
+    id SERIAL PRIMARY KEY,
+    entity VARCHAR(50) NOT NULL,
+    key VARCHAR(63) NOT NULL,
+    label VARCHAR(255) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    options JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE (entity, key)
+);
+
+-- Custom field values live next to the record they belong to
+ALTER TABLE users ADD COLUMN IF NOT EXISTS custom_fields JSONB NOT NULL DEFAULT '{}';
+
+-- Create index for custom field filters
+CREATE INDEX IF NOT EXISTS idx_users_custom_fields ON users USING GIN (custom_fields);
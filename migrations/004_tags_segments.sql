@@ -0,0 +1,28 @@
+-- Create tag registry
+CREATE TABLE IF NOT EXISTS tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Tags can be attached to any entity, entity_id points into that entity's table
+CREATE TABLE IF NOT EXISTS entity_tags (
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tag_id, entity, entity_id)
+);
+
+-- Create index for loading the tags of a record
+CREATE INDEX IF NOT EXISTS idx_entity_tags_entity ON entity_tags(entity, entity_id);
+
+-- Create table for saved segments, the expression is evaluated on every read
+CREATE TABLE IF NOT EXISTS segments (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    expression TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,20 @@
+-- Create table for admin-defined scoring rules
+CREATE TABLE IF NOT EXISTS scoring_rules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    expression TEXT NOT NULL DEFAULT '',
+    points INTEGER NOT NULL,
+    half_life_days INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Stored score and the rules that produced it
+ALTER TABLE users ADD COLUMN IF NOT EXISTS score INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS score_breakdown JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS scored_at TIMESTAMP;
+
+-- Create index for sorting by score
+CREATE INDEX IF NOT EXISTS idx_users_score ON users(score DESC);
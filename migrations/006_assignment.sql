@@ -0,0 +1,33 @@
+-- Create table for lead assignment rules
+CREATE TABLE IF NOT EXISTS assignment_rules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    expression TEXT NOT NULL DEFAULT '',
+    strategy VARCHAR(20) NOT NULL,
+    members JSONB NOT NULL DEFAULT '[]',
+    turn INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Record owners and availability for new assignments
+ALTER TABLE users ADD COLUMN IF NOT EXISTS owner_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS out_of_office BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_users_owner_id ON users(owner_id);
+
+-- Audit trail of every owner change
+CREATE TABLE IF NOT EXISTS assignments (
+    id SERIAL PRIMARY KEY,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    owner_id INTEGER NOT NULL,
+    previous_owner_id INTEGER,
+    rule_id INTEGER,
+    reason VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_assignments_entity ON assignments(entity, entity_id);
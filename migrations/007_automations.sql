@@ -0,0 +1,36 @@
+-- Create table for admin-defined automations
+CREATE TABLE IF NOT EXISTS automations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    trigger JSONB NOT NULL,
+    condition TEXT NOT NULL DEFAULT '',
+    actions JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Queue and log of automation runs
+CREATE TABLE IF NOT EXISTS automation_runs (
+    id SERIAL PRIMARY KEY,
+    automation_id INTEGER NOT NULL REFERENCES automations(id) ON DELETE CASCADE,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    depth INTEGER NOT NULL DEFAULT 0,
+    dedupe_key VARCHAR(100),
+    log TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Date triggers fire once per record and date
+CREATE UNIQUE INDEX IF NOT EXISTS idx_automation_runs_dedupe
+    ON automation_runs(automation_id, entity, entity_id, dedupe_key);
+
+-- Create index for the worker picking up due runs
+CREATE INDEX IF NOT EXISTS idx_automation_runs_due ON automation_runs(status, next_attempt_at);
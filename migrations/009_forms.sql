@@ -0,0 +1,33 @@
+-- Create table for public web-to-lead forms
+CREATE TABLE IF NOT EXISTS forms (
+    id SERIAL PRIMARY KEY,
+    key VARCHAR(64) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    field_mappings JSONB NOT NULL DEFAULT '{}',
+    allowed_origins JSONB NOT NULL DEFAULT '[]',
+    honeypot_field VARCHAR(100) NOT NULL DEFAULT '',
+    require_token BOOLEAN NOT NULL DEFAULT FALSE,
+    secret VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Log of form submissions
+CREATE TABLE IF NOT EXISTS form_submissions (
+    id SERIAL PRIMARY KEY,
+    form_id INTEGER NOT NULL REFERENCES forms(id) ON DELETE CASCADE,
+    entity_id INTEGER,
+    status VARCHAR(20) NOT NULL,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    referrer TEXT NOT NULL DEFAULT '',
+    utm JSONB NOT NULL DEFAULT '{}',
+    data JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Create index for listing a form's submissions
+CREATE INDEX IF NOT EXISTS idx_form_submissions_form ON form_submissions(form_id);
+
+-- Create index for deduping submissions against existing users
+CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users(LOWER(email));
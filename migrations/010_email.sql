@@ -0,0 +1,60 @@
+-- Create table for email templates
+CREATE TABLE IF NOT EXISTS email_templates (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    subject TEXT NOT NULL,
+    body_text TEXT NOT NULL DEFAULT '',
+    body_html TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Create table for emails sent to records
+CREATE TABLE IF NOT EXISTS emails (
+    id SERIAL PRIMARY KEY,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    template_id INTEGER REFERENCES email_templates(id) ON DELETE SET NULL,
+    from_address TEXT NOT NULL,
+    to_address TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    body_text TEXT NOT NULL DEFAULT '',
+    body_html TEXT NOT NULL DEFAULT '',
+    message_id VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    tracking_token VARCHAR(64) NOT NULL UNIQUE,
+    links JSONB NOT NULL DEFAULT '[]',
+    open_count INTEGER NOT NULL DEFAULT 0,
+    click_count INTEGER NOT NULL DEFAULT 0,
+    opened_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Create index for listing a record's emails
+CREATE INDEX IF NOT EXISTS idx_emails_entity ON emails(entity, entity_id);
+
+-- Log of email opens and clicks
+CREATE TABLE IF NOT EXISTS email_events (
+    id SERIAL PRIMARY KEY,
+    email_id INTEGER NOT NULL REFERENCES emails(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL,
+    url TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Timeline of activities on records
+CREATE TABLE IF NOT EXISTS activities (
+    id SERIAL PRIMARY KEY,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    summary TEXT NOT NULL DEFAULT '',
+    email_id INTEGER REFERENCES emails(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Create index for reading a record's timeline
+CREATE INDEX IF NOT EXISTS idx_activities_entity ON activities(entity, entity_id, created_at);
@@ -0,0 +1,22 @@
+-- Inbound emails share the emails table, they have no tracking token
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS direction VARCHAR(10) NOT NULL DEFAULT 'outbound';
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS in_reply_to VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS thread_id INTEGER REFERENCES emails(id) ON DELETE SET NULL;
+ALTER TABLE emails ALTER COLUMN tracking_token DROP NOT NULL;
+
+-- Create indexes for threading replies and skipping duplicates
+CREATE INDEX IF NOT EXISTS idx_emails_message_id ON emails(message_id);
+CREATE INDEX IF NOT EXISTS idx_emails_thread_id ON emails(thread_id);
+
+-- Files received with inbound emails
+CREATE TABLE IF NOT EXISTS email_attachments (
+    id SERIAL PRIMARY KEY,
+    email_id INTEGER NOT NULL REFERENCES emails(id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size INTEGER NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_attachments_email_id ON email_attachments(email_id);
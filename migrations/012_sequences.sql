@@ -0,0 +1,32 @@
+-- Create table for outreach sequences
+CREATE TABLE IF NOT EXISTS sequences (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    steps JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Records enrolled in sequences and their progress
+CREATE TABLE IF NOT EXISTS sequence_enrollments (
+    id SERIAL PRIMARY KEY,
+    sequence_id INTEGER NOT NULL REFERENCES sequences(id) ON DELETE CASCADE,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    step INTEGER NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_run_at TIMESTAMP NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- A record is enrolled in a sequence at most once at a time
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sequence_enrollments_active
+    ON sequence_enrollments(sequence_id, entity, entity_id) WHERE status = 'active';
+
+-- Create indexes for the scheduler and for stopping a record's enrollments
+CREATE INDEX IF NOT EXISTS idx_sequence_enrollments_due ON sequence_enrollments(next_run_at) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_sequence_enrollments_entity ON sequence_enrollments(entity, entity_id);
@@ -0,0 +1,26 @@
+-- Create table for the history of consent changes, rows are never updated
+CREATE TABLE IF NOT EXISTS consents (
+    id SERIAL PRIMARY KEY,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    channel VARCHAR(20) NOT NULL,
+    purpose VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    lawful_basis VARCHAR(30) NOT NULL,
+    source VARCHAR(100) NOT NULL,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Create table for addresses no email is sent to
+CREATE TABLE IF NOT EXISTS email_suppressions (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    reason VARCHAR(20) NOT NULL,
+    source VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Create index for a record's consent history
+CREATE INDEX IF NOT EXISTS idx_consents_entity ON consents(entity, entity_id, id);
@@ -0,0 +1,22 @@
+-- Create table for tracking data subject requests until they are fulfilled
+CREATE TABLE IF NOT EXISTS privacy_requests (
+    id SERIAL PRIMARY KEY,
+    type VARCHAR(20) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    due_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP,
+    reminded_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Create index for open requests by deadline
+CREATE INDEX IF NOT EXISTS idx_privacy_requests_due ON privacy_requests(due_at) WHERE status = 'pending';
+
+-- Create indexes for compiling and erasing a record's data
+CREATE INDEX IF NOT EXISTS idx_form_submissions_entity_id ON form_submissions(entity_id);
+CREATE INDEX IF NOT EXISTS idx_automation_runs_entity ON automation_runs(entity, entity_id);
+CREATE INDEX IF NOT EXISTS idx_outbox_entity ON outbox(entity, entity_id);
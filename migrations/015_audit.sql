@@ -0,0 +1,40 @@
+-- Append-only log of data changes, hash chained for tamper evidence
+CREATE TABLE IF NOT EXISTS audit_events (
+    id SERIAL PRIMARY KEY,
+    actor VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    changes JSONB NOT NULL DEFAULT '{}',
+    changes_hash CHAR(64) NOT NULL,
+    redacted BOOLEAN NOT NULL DEFAULT FALSE,
+    request_id VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL UNIQUE
+);
+
+-- Create indexes for the audit filters
+CREATE INDEX IF NOT EXISTS idx_audit_events_entity ON audit_events(entity, entity_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+
+-- Events can't be changed or removed. The only exception is a GDPR erasure
+-- clearing a diff, the changes hash keeps the chain verifiable.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.redacted AND NEW.changes = '{}'::jsonb
+        AND (NEW.id, NEW.actor, NEW.entity, NEW.entity_id, NEW.action, NEW.changes_hash, NEW.request_id,
+             NEW.ip, NEW.created_at, NEW.prev_hash, NEW.hash)
+        IS NOT DISTINCT FROM (OLD.id, OLD.actor, OLD.entity, OLD.entity_id, OLD.action, OLD.changes_hash,
+             OLD.request_id, OLD.ip, OLD.created_at, OLD.prev_hash, OLD.hash) THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,17 @@
+-- Custom fields can opt in to keeping a history of their values
+ALTER TABLE custom_field_definitions ADD COLUMN IF NOT EXISTS track_history BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Create table for the history of tracked fields, null values are unset
+CREATE TABLE IF NOT EXISTS field_history (
+    id SERIAL PRIMARY KEY,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    field VARCHAR(100) NOT NULL,
+    old_value JSONB,
+    new_value JSONB,
+    actor VARCHAR(255) NOT NULL,
+    changed_at TIMESTAMP NOT NULL
+);
+
+-- Create index for reading a record's history newest first
+CREATE INDEX IF NOT EXISTS idx_field_history_entity ON field_history(entity, entity_id, changed_at);
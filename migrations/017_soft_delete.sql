@@ -0,0 +1,34 @@
+-- Records are moved to the trash before they are deleted for good, deleted_at
+-- is null for live rows
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE custom_field_definitions ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE custom_field_definitions ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE scoring_rules ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE scoring_rules ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE assignment_rules ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE assignment_rules ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE automations ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE automations ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE webhook_subscriptions ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE webhook_subscriptions ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE forms ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE forms ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE email_templates ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE email_templates ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE sequences ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE sequences ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Create partial indexes for listing the trash and finding expired rows
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_custom_field_definitions_deleted_at ON custom_field_definitions(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_segments_deleted_at ON segments(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_scoring_rules_deleted_at ON scoring_rules(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_assignment_rules_deleted_at ON assignment_rules(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_automations_deleted_at ON automations(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_deleted_at ON webhook_subscriptions(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_forms_deleted_at ON forms(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_email_templates_deleted_at ON email_templates(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_sequences_deleted_at ON sequences(deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,14 @@
+-- Create table for Idempotency-Key headers and the responses to replay to retries,
+-- a status_code of 0 means the first request is still running
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    request_hash VARCHAR(64) NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    response_headers JSONB NOT NULL DEFAULT '{}',
+    response_body BYTEA NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Create index for purging expired keys
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
@@ -0,0 +1,44 @@
+-- Writes to users notify the change feed on the crm_changes channel, sent when
+-- their transaction commits. Trashing a user reports it deleted and restoring
+-- it created, changes to a trashed user aren't reported.
+CREATE OR REPLACE FUNCTION notify_change() RETURNS trigger AS $$
+DECLARE
+    id INTEGER;
+    op TEXT;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        id := NEW.id;
+        op := 'created';
+    ELSIF TG_OP = 'DELETE' THEN
+        IF OLD.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        id := OLD.id;
+        op := 'deleted';
+    ELSE
+        id := NEW.id;
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+            op := 'deleted';
+        ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+            op := 'created';
+        ELSIF NEW.deleted_at IS NULL THEN
+            op := 'updated';
+        ELSE
+            RETURN NULL;
+        END IF;
+    END IF;
+
+    PERFORM pg_notify('crm_changes', json_build_object(
+        'entity', TG_ARGV[0], 'id', id, 'op', op, 'at', now()
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_notify_change ON users;
+CREATE TRIGGER users_notify_change AFTER INSERT OR DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION notify_change('user');
+
+DROP TRIGGER IF EXISTS users_notify_update ON users;
+CREATE TRIGGER users_notify_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN (OLD.* IS DISTINCT FROM NEW.*) EXECUTE FUNCTION notify_change('user');
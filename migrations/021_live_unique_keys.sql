@@ -0,0 +1,9 @@
+-- Only live rows hold on to their unique keys: a trashed user's email address
+-- or a trashed custom field's key can be taken by a new record, restoring the
+-- trashed one then fails instead of making a duplicate
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_live ON users(email) WHERE deleted_at IS NULL;
+
+ALTER TABLE custom_field_definitions DROP CONSTRAINT IF EXISTS custom_field_definitions_entity_key_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_custom_field_definitions_key_live
+    ON custom_field_definitions(entity, key) WHERE deleted_at IS NULL;
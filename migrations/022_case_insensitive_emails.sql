@@ -0,0 +1,12 @@
+-- Email addresses are looked up without regard to case, so a live user's
+-- address is unique whatever its case. Live users sharing an address in
+-- another case are moved to the trash first, all but the oldest, which is the
+-- one lookups found.
+UPDATE users SET deleted_at = NOW(), deleted_by = 'migration', version = version + 1
+WHERE deleted_at IS NULL AND EXISTS (
+    SELECT 1 FROM users older
+    WHERE older.deleted_at IS NULL AND LOWER(older.email) = LOWER(users.email) AND older.id < users.id
+);
+
+DROP INDEX IF EXISTS idx_users_email_live;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_live ON users(LOWER(email)) WHERE deleted_at IS NULL;
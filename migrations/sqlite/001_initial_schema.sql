@@ -0,0 +1,467 @@
+-- SQLite schema matching Postgres migrations 001 to 019. JSON is stored as
+-- text and times as UTC text, see the repository's SQLite dialect.
+
+-- Create users table
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    custom_fields TEXT NOT NULL DEFAULT '{}',
+    score INTEGER NOT NULL DEFAULT 0,
+    score_breakdown TEXT NOT NULL DEFAULT '[]',
+    scored_at TIMESTAMP,
+    owner_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    out_of_office BOOLEAN NOT NULL DEFAULT FALSE,
+    deleted_at TIMESTAMP,
+    deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users(LOWER(email));
+CREATE INDEX IF NOT EXISTS idx_users_score ON users(score DESC);
+CREATE INDEX IF NOT EXISTS idx_users_owner_id ON users(owner_id);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Create table for admin-defined custom fields
+CREATE TABLE IF NOT EXISTS custom_field_definitions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entity VARCHAR(50) NOT NULL,
+    key VARCHAR(63) NOT NULL,
+    label VARCHAR(255) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    options TEXT NOT NULL DEFAULT '[]',
+    track_history BOOLEAN NOT NULL DEFAULT FALSE,
+    deleted_at TIMESTAMP,
+    deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE (entity, key)
+);
+
+-- Create tag registry
+CREATE TABLE IF NOT EXISTS tags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(50) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Tags can be attached to any entity, entity_id points into that entity's table
+CREATE TABLE IF NOT EXISTS entity_tags (
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tag_id, entity, entity_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_entity_tags_entity ON entity_tags(entity, entity_id);
+
+-- Create table for saved segments, the expression is evaluated on every read
+CREATE TABLE IF NOT EXISTS segments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    expression TEXT NOT NULL,
+    deleted_at TIMESTAMP,
+    deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Create table for admin-defined scoring rules
+CREATE TABLE IF NOT EXISTS scoring_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    expression TEXT NOT NULL DEFAULT '',
+    points INTEGER NOT NULL,
+    half_life_days INTEGER NOT NULL DEFAULT 0,
+    deleted_at TIMESTAMP,
+    deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Create table for lead assignment rules
+CREATE TABLE IF NOT EXISTS assignment_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    expression TEXT NOT NULL DEFAULT '',
+    strategy VARCHAR(20) NOT NULL,
+    members TEXT NOT NULL DEFAULT '[]',
+    turn INTEGER NOT NULL DEFAULT 0,
+    deleted_at TIMESTAMP,
+    deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Audit trail of every owner change
+CREATE TABLE IF NOT EXISTS assignments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    owner_id INTEGER NOT NULL,
+    previous_owner_id INTEGER,
+    rule_id INTEGER,
+    reason VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_assignments_entity ON assignments(entity, entity_id);
+
+-- Create table for admin-defined automations
+CREATE TABLE IF NOT EXISTS automations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    trigger TEXT NOT NULL,
+    condition TEXT NOT NULL DEFAULT '',
+    actions TEXT NOT NULL DEFAULT '[]',
+    deleted_at TIMESTAMP,
+    deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Queue and log of automation runs
+CREATE TABLE IF NOT EXISTS automation_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    automation_id INTEGER NOT NULL REFERENCES automations(id) ON DELETE CASCADE,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    depth INTEGER NOT NULL DEFAULT 0,
+    dedupe_key VARCHAR(100),
+    log TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Date triggers fire once per record and date
+CREATE UNIQUE INDEX IF NOT EXISTS idx_automation_runs_dedupe
+    ON automation_runs(automation_id, entity, entity_id, dedupe_key);
+
+CREATE INDEX IF NOT EXISTS idx_automation_runs_due ON automation_runs(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_automation_runs_entity ON automation_runs(entity, entity_id);
+
+-- Outbox of changes, written in the same transaction as the change itself
+CREATE TABLE IF NOT EXISTS outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event VARCHAR(100) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    processed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_unprocessed ON outbox(id) WHERE processed_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_entity ON outbox(entity, entity_id);
+
+-- Create table for webhook subscriptions
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    events TEXT NOT NULL DEFAULT '[]',
+    deleted_at TIMESTAMP,
+    deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Queue and log of webhook deliveries
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    subscription_id INTEGER NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    outbox_id INTEGER NOT NULL REFERENCES outbox(id),
+    event VARCHAR(100) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_code INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NOT NULL,
+    delivered_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription ON webhook_deliveries(subscription_id);
+
+-- Create table for public web-to-lead forms
+CREATE TABLE IF NOT EXISTS forms (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    key VARCHAR(64) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    field_mappings TEXT NOT NULL DEFAULT '{}',
+    allowed_origins TEXT NOT NULL DEFAULT '[]',
+    honeypot_field VARCHAR(100) NOT NULL DEFAULT '',
+    require_token BOOLEAN NOT NULL DEFAULT FALSE,
+    secret VARCHAR(255) NOT NULL,
+    deleted_at TIMESTAMP,
+    deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Log of form submissions
+CREATE TABLE IF NOT EXISTS form_submissions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    form_id INTEGER NOT NULL REFERENCES forms(id) ON DELETE CASCADE,
+    entity_id INTEGER,
+    status VARCHAR(20) NOT NULL,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    referrer TEXT NOT NULL DEFAULT '',
+    utm TEXT NOT NULL DEFAULT '{}',
+    data TEXT NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_form_submissions_form ON form_submissions(form_id);
+CREATE INDEX IF NOT EXISTS idx_form_submissions_entity_id ON form_submissions(entity_id);
+
+-- Create table for email templates
+CREATE TABLE IF NOT EXISTS email_templates (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    subject TEXT NOT NULL,
+    body_text TEXT NOT NULL DEFAULT '',
+    body_html TEXT NOT NULL DEFAULT '',
+    deleted_at TIMESTAMP,
+    deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Create table for emails sent to and received from records. Inbound emails
+-- have no tracking token.
+CREATE TABLE IF NOT EXISTS emails (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    template_id INTEGER REFERENCES email_templates(id) ON DELETE SET NULL,
+    from_address TEXT NOT NULL,
+    to_address TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    body_text TEXT NOT NULL DEFAULT '',
+    body_html TEXT NOT NULL DEFAULT '',
+    message_id VARCHAR(255) NOT NULL,
+    direction VARCHAR(10) NOT NULL DEFAULT 'outbound',
+    in_reply_to VARCHAR(255) NOT NULL DEFAULT '',
+    thread_id INTEGER REFERENCES emails(id) ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    tracking_token VARCHAR(64) UNIQUE,
+    links TEXT NOT NULL DEFAULT '[]',
+    open_count INTEGER NOT NULL DEFAULT 0,
+    click_count INTEGER NOT NULL DEFAULT 0,
+    opened_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_emails_entity ON emails(entity, entity_id);
+CREATE INDEX IF NOT EXISTS idx_emails_message_id ON emails(message_id);
+CREATE INDEX IF NOT EXISTS idx_emails_thread_id ON emails(thread_id);
+
+-- Log of email opens and clicks
+CREATE TABLE IF NOT EXISTS email_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email_id INTEGER NOT NULL REFERENCES emails(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL,
+    url TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Files received with inbound emails
+CREATE TABLE IF NOT EXISTS email_attachments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email_id INTEGER NOT NULL REFERENCES emails(id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size INTEGER NOT NULL,
+    data BLOB NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_attachments_email_id ON email_attachments(email_id);
+
+-- Timeline of activities on records
+CREATE TABLE IF NOT EXISTS activities (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    summary TEXT NOT NULL DEFAULT '',
+    email_id INTEGER REFERENCES emails(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_activities_entity ON activities(entity, entity_id, created_at);
+
+-- Create table for outreach sequences
+CREATE TABLE IF NOT EXISTS sequences (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    steps TEXT NOT NULL DEFAULT '[]',
+    deleted_at TIMESTAMP,
+    deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Records enrolled in sequences and their progress
+CREATE TABLE IF NOT EXISTS sequence_enrollments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sequence_id INTEGER NOT NULL REFERENCES sequences(id) ON DELETE CASCADE,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    step INTEGER NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_run_at TIMESTAMP NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- A record is enrolled in a sequence at most once at a time
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sequence_enrollments_active
+    ON sequence_enrollments(sequence_id, entity, entity_id) WHERE status = 'active';
+
+CREATE INDEX IF NOT EXISTS idx_sequence_enrollments_due ON sequence_enrollments(next_run_at) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_sequence_enrollments_entity ON sequence_enrollments(entity, entity_id);
+
+-- Create table for the history of consent changes, rows are never updated
+CREATE TABLE IF NOT EXISTS consents (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    channel VARCHAR(20) NOT NULL,
+    purpose VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    lawful_basis VARCHAR(30) NOT NULL,
+    source VARCHAR(100) NOT NULL,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_consents_entity ON consents(entity, entity_id, id);
+
+-- Create table for addresses no email is sent to
+CREATE TABLE IF NOT EXISTS email_suppressions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    reason VARCHAR(20) NOT NULL,
+    source VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Create table for tracking data subject requests until they are fulfilled
+CREATE TABLE IF NOT EXISTS privacy_requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    type VARCHAR(20) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    due_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP,
+    reminded_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_privacy_requests_due ON privacy_requests(due_at) WHERE status = 'pending';
+
+-- Append-only log of data changes, hash chained for tamper evidence
+CREATE TABLE IF NOT EXISTS audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor VARCHAR(255) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    changes TEXT NOT NULL DEFAULT '{}',
+    changes_hash CHAR(64) NOT NULL,
+    redacted BOOLEAN NOT NULL DEFAULT FALSE,
+    request_id VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_entity ON audit_events(entity, entity_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+
+-- Events can't be changed or removed. The only exception is a GDPR erasure
+-- clearing a diff, the changes hash keeps the chain verifiable.
+CREATE TRIGGER IF NOT EXISTS audit_events_append_only BEFORE UPDATE ON audit_events
+WHEN NOT (NEW.redacted AND NEW.changes = '{}'
+    AND NEW.id IS OLD.id AND NEW.actor IS OLD.actor AND NEW.entity IS OLD.entity
+    AND NEW.entity_id IS OLD.entity_id AND NEW.action IS OLD.action
+    AND NEW.changes_hash IS OLD.changes_hash AND NEW.request_id IS OLD.request_id
+    AND NEW.ip IS OLD.ip AND NEW.created_at IS OLD.created_at
+    AND NEW.prev_hash IS OLD.prev_hash AND NEW.hash IS OLD.hash)
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+-- Create table for the history of tracked fields, null values are unset
+CREATE TABLE IF NOT EXISTS field_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    field VARCHAR(100) NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    actor VARCHAR(255) NOT NULL,
+    changed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_field_history_entity ON field_history(entity, entity_id, changed_at);
+
+-- Create table for Idempotency-Key headers and the responses to replay to retries,
+-- a status_code of 0 means the first request is still running
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    request_hash VARCHAR(64) NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    response_headers TEXT NOT NULL DEFAULT '{}',
+    response_body BLOB NOT NULL DEFAULT X'',
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
@@ -0,0 +1,31 @@
+-- SQLite has no NOTIFY: writes to users record their change here and the
+-- change feed polls the table, see Postgres migration 020. Rows are pruned
+-- by the listeners once read.
+CREATE TABLE IF NOT EXISTS changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    op VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE TRIGGER IF NOT EXISTS users_change_insert AFTER INSERT ON users
+BEGIN
+    INSERT INTO changes (entity, entity_id, op) VALUES ('user', NEW.id, 'created');
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_change_update AFTER UPDATE ON users
+WHEN NEW.deleted_at IS NULL OR OLD.deleted_at IS NULL
+BEGIN
+    INSERT INTO changes (entity, entity_id, op) VALUES ('user', NEW.id, CASE
+        WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN 'deleted'
+        WHEN OLD.deleted_at IS NOT NULL THEN 'created'
+        ELSE 'updated'
+    END);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_change_delete AFTER DELETE ON users
+WHEN OLD.deleted_at IS NULL
+BEGIN
+    INSERT INTO changes (entity, entity_id, op) VALUES ('user', OLD.id, 'deleted');
+END;
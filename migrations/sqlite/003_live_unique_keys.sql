@@ -0,0 +1,97 @@
+-- Only live rows hold on to their unique keys, see Postgres migration 021.
+-- SQLite can't drop a UNIQUE constraint, so users and custom_field_definitions
+-- are rebuilt: the old table is renamed first, which points the owner_id
+-- reference at it, so dropping it doesn't null the owners of the copied rows.
+-- The change feed triggers are dropped for the copy and created again after.
+DROP TRIGGER IF EXISTS users_change_insert;
+DROP TRIGGER IF EXISTS users_change_update;
+DROP TRIGGER IF EXISTS users_change_delete;
+
+ALTER TABLE users RENAME TO users_old;
+
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    custom_fields TEXT NOT NULL DEFAULT '{}',
+    score INTEGER NOT NULL DEFAULT 0,
+    score_breakdown TEXT NOT NULL DEFAULT '[]',
+    scored_at TIMESTAMP,
+    owner_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    out_of_office BOOLEAN NOT NULL DEFAULT FALSE,
+    deleted_at TIMESTAMP,
+    deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO users (id, name, email, custom_fields, score, score_breakdown, scored_at, owner_id,
+    out_of_office, deleted_at, deleted_by, version, created_at, updated_at)
+SELECT id, name, email, custom_fields, score, score_breakdown, scored_at, owner_id,
+    out_of_office, deleted_at, deleted_by, version, created_at, updated_at
+FROM users_old;
+
+-- keep handing out IDs after those of users deleted for good
+DELETE FROM sqlite_sequence WHERE name = 'users';
+UPDATE sqlite_sequence SET name = 'users' WHERE name = 'users_old';
+
+DROP TABLE users_old;
+
+CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users(LOWER(email));
+CREATE INDEX IF NOT EXISTS idx_users_score ON users(score DESC);
+CREATE INDEX IF NOT EXISTS idx_users_owner_id ON users(owner_id);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_live ON users(email) WHERE deleted_at IS NULL;
+
+CREATE TRIGGER IF NOT EXISTS users_change_insert AFTER INSERT ON users
+BEGIN
+    INSERT INTO changes (entity, entity_id, op) VALUES ('user', NEW.id, 'created');
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_change_update AFTER UPDATE ON users
+WHEN NEW.deleted_at IS NULL OR OLD.deleted_at IS NULL
+BEGIN
+    INSERT INTO changes (entity, entity_id, op) VALUES ('user', NEW.id, CASE
+        WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN 'deleted'
+        WHEN OLD.deleted_at IS NOT NULL THEN 'created'
+        ELSE 'updated'
+    END);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_change_delete AFTER DELETE ON users
+WHEN OLD.deleted_at IS NULL
+BEGIN
+    INSERT INTO changes (entity, entity_id, op) VALUES ('user', OLD.id, 'deleted');
+END;
+
+ALTER TABLE custom_field_definitions RENAME TO custom_field_definitions_old;
+
+CREATE TABLE custom_field_definitions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entity VARCHAR(50) NOT NULL,
+    key VARCHAR(63) NOT NULL,
+    label VARCHAR(255) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    options TEXT NOT NULL DEFAULT '[]',
+    track_history BOOLEAN NOT NULL DEFAULT FALSE,
+    deleted_at TIMESTAMP,
+    deleted_by VARCHAR(255) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO custom_field_definitions (id, entity, key, label, type, options, track_history,
+    deleted_at, deleted_by, version, created_at, updated_at)
+SELECT id, entity, key, label, type, options, track_history,
+    deleted_at, deleted_by, version, created_at, updated_at
+FROM custom_field_definitions_old;
+
+DELETE FROM sqlite_sequence WHERE name = 'custom_field_definitions';
+UPDATE sqlite_sequence SET name = 'custom_field_definitions' WHERE name = 'custom_field_definitions_old';
+
+DROP TABLE custom_field_definitions_old;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_custom_field_definitions_key_live
+    ON custom_field_definitions(entity, key) WHERE deleted_at IS NULL;
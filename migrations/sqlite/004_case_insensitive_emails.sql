@@ -0,0 +1,11 @@
+-- A live user's address is unique whatever its case, see Postgres migration
+-- 022. Times are padded to the microseconds the repository stores.
+UPDATE users SET deleted_at = strftime('%Y-%m-%d %H:%M:%f', 'now') || '000', deleted_by = 'migration',
+    version = version + 1
+WHERE deleted_at IS NULL AND EXISTS (
+    SELECT 1 FROM users older
+    WHERE older.deleted_at IS NULL AND LOWER(older.email) = LOWER(users.email) AND older.id < users.id
+);
+
+DROP INDEX IF EXISTS idx_users_email_live;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_live ON users(LOWER(email)) WHERE deleted_at IS NULL;
@@ -0,0 +1,11 @@
+// Package sqlite holds the migrations of the SQLite schema. They are embedded
+// in the binary and applied by the repository when it opens the database,
+// there is no init container doing it as for Postgres.
+package sqlite
+
+import "embed"
+
+// FS holds the migrations, applied in file name order
+//
+//go:embed *.sql
+var FS embed.FS
@@ -99,8 +99,87 @@ func setupTestDatabase(db *sql.DB) error {
             name VARCHAR(255) NOT NULL,
             email VARCHAR(255) NOT NULL UNIQUE,
             created_at TIMESTAMP NOT NULL,
+            updated_at TIMESTAMP NOT NULL,
+            custom_fields JSONB NOT NULL DEFAULT '{}',
+            score INTEGER NOT NULL DEFAULT 0,
+            score_breakdown JSONB NOT NULL DEFAULT '[]',
+            scored_at TIMESTAMP,
+            owner_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+            out_of_office BOOLEAN NOT NULL DEFAULT FALSE
+        );
+
+        DROP TABLE IF EXISTS assignment_rules;
+
+        CREATE TABLE assignment_rules (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            entity VARCHAR(50) NOT NULL,
+            position INTEGER NOT NULL DEFAULT 0,
+            expression TEXT NOT NULL DEFAULT '',
+            strategy VARCHAR(20) NOT NULL,
+            members JSONB NOT NULL DEFAULT '[]',
+            turn INTEGER NOT NULL DEFAULT 0,
+            created_at TIMESTAMP NOT NULL,
             updated_at TIMESTAMP NOT NULL
         );
+
+        DROP TABLE IF EXISTS automation_runs;
+        DROP TABLE IF EXISTS automations;
+
+        CREATE TABLE automations (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            entity VARCHAR(50) NOT NULL,
+            enabled BOOLEAN NOT NULL DEFAULT TRUE,
+            trigger JSONB NOT NULL,
+            condition TEXT NOT NULL DEFAULT '',
+            actions JSONB NOT NULL DEFAULT '[]',
+            created_at TIMESTAMP NOT NULL,
+            updated_at TIMESTAMP NOT NULL
+        );
+
+        DROP TABLE IF EXISTS custom_field_definitions;
+        DROP TABLE IF EXISTS entity_tags;
+        DROP TABLE IF EXISTS tags;
+
+        CREATE TABLE custom_field_definitions (
+            id SERIAL PRIMARY KEY,
+            entity VARCHAR(50) NOT NULL,
+            key VARCHAR(63) NOT NULL,
+            label VARCHAR(255) NOT NULL,
+            type VARCHAR(20) NOT NULL,
+            options JSONB NOT NULL DEFAULT '[]',
+            created_at TIMESTAMP NOT NULL,
+            updated_at TIMESTAMP NOT NULL,
+            UNIQUE (entity, key)
+        );
+
+        CREATE TABLE tags (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR(50) NOT NULL UNIQUE,
+            created_at TIMESTAMP NOT NULL
+        );
+
+        CREATE TABLE entity_tags (
+            tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+            entity VARCHAR(50) NOT NULL,
+            entity_id INTEGER NOT NULL,
+            created_at TIMESTAMP NOT NULL,
+            PRIMARY KEY (tag_id, entity, entity_id)
+        );
+
+        DROP TABLE IF EXISTS webhook_deliveries;
+        DROP TABLE IF EXISTS outbox;
+
+        CREATE TABLE outbox (
+            id SERIAL PRIMARY KEY,
+            event VARCHAR(100) NOT NULL,
+            entity VARCHAR(50) NOT NULL,
+            entity_id INTEGER NOT NULL,
+            payload JSONB NOT NULL,
+            created_at TIMESTAMP NOT NULL,
+            processed_at TIMESTAMP
+        );
     `)
 	return err
 }
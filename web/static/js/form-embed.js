@@ -0,0 +1,137 @@
+// Embeddable web-to-lead snippet for client sites.
+//
+// Usage, on the page holding the form:
+//
+//   <form id="contact-form">
+//     <input name="email" type="email" required>
+//     <input name="website" style="display:none" tabindex="-1" autocomplete="off">
+//     <button type="submit">Send</button>
+//   </form>
+//   <script src="https://crm.example.com/static/js/form-embed.js"
+//           data-form-key="FORM_KEY" data-form="#contact-form"
+//           data-token="true" data-success="Thanks, we'll be in touch!"></script>
+//
+// The snippet posts the form to the CRM, adding the visitor's UTM parameters
+// and referrer. With data-token it fetches a signed token when the page loads,
+// for forms created with require_token.
+(function() {
+    const script = document.currentScript;
+    if (!script) {
+        return;
+    }
+
+    const formKey = script.dataset.formKey;
+    const form = document.querySelector(script.dataset.form || 'form');
+    if (!formKey || !form) {
+        console.error('form-embed: data-form-key and a form are required');
+        return;
+    }
+
+    const endpoint = new URL('/forms/' + encodeURIComponent(formKey), script.src).href;
+    const utmFields = ['utm_source', 'utm_medium', 'utm_campaign', 'utm_term', 'utm_content'];
+    let token = null;
+
+    // Campaign parameters of the landing page, kept for the visit so they
+    // survive navigating to the page with the form
+    function campaign() {
+        const params = new URLSearchParams(window.location.search);
+        const found = {};
+        utmFields.forEach(function(name) {
+            const value = params.get(name);
+            if (value) {
+                found[name] = value;
+            }
+        });
+        try {
+            if (Object.keys(found).length > 0) {
+                sessionStorage.setItem('crm_utm', JSON.stringify(found));
+                sessionStorage.setItem('crm_referrer', document.referrer);
+                return { utm: found, referrer: document.referrer };
+            }
+            const stored = sessionStorage.getItem('crm_utm');
+            if (stored) {
+                return { utm: JSON.parse(stored), referrer: sessionStorage.getItem('crm_referrer') || '' };
+            }
+        } catch (e) {
+            // storage can be disabled, fall back to this page only
+        }
+        return { utm: found, referrer: document.referrer };
+    }
+
+    function fetchToken() {
+        return fetch(endpoint + '/token')
+            .then(response => {
+                if (!response.ok) {
+                    throw new Error('Failed to fetch form token');
+                }
+                return response.json();
+            })
+            .then(data => {
+                token = data.token;
+            })
+            .catch(error => console.error('form-embed:', error));
+    }
+
+    function showMessage(text, isError) {
+        let message = form.querySelector('.crm-form-message');
+        if (!message) {
+            message = document.createElement('p');
+            message.className = 'crm-form-message';
+            form.appendChild(message);
+        }
+        message.textContent = text;
+        message.style.color = isError ? '#b00020' : '';
+    }
+
+    const visit = campaign();
+    if (script.dataset.token === 'true') {
+        fetchToken();
+    }
+
+    form.addEventListener('submit', function(e) {
+        e.preventDefault();
+
+        const body = {};
+        new FormData(form).forEach(function(value, name) {
+            if (typeof value !== 'string') {
+                return;
+            }
+            body[name] = name in body ? body[name] + ',' + value : value;
+        });
+        Object.keys(visit.utm).forEach(function(name) {
+            if (!(name in body)) {
+                body[name] = visit.utm[name];
+            }
+        });
+        if (visit.referrer) {
+            body._referrer = visit.referrer;
+        }
+        if (token) {
+            body._token = token;
+        }
+
+        const button = form.querySelector('[type="submit"]');
+        if (button) {
+            button.disabled = true;
+        }
+
+        fetch(endpoint, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        })
+            .then(response => response.json().then(data => {
+                if (!response.ok) {
+                    throw new Error(data.error || 'Failed to send the form');
+                }
+                form.reset();
+                showMessage(script.dataset.success || 'Thank you!', false);
+            }))
+            .catch(error => showMessage(error.message, true))
+            .finally(() => {
+                if (button) {
+                    button.disabled = false;
+                }
+            });
+    });
+})();
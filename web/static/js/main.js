@@ -1,4 +1,104 @@
 // Common functionality for all pages
 document.addEventListener('DOMContentLoaded', function() {
     console.log('Application initialized');
-});
\ No newline at end of file
+
+    setupSearch();
+});
+
+// Typeahead for the global search box in the header
+function setupSearch() {
+    const input = document.getElementById('global-search');
+    const results = document.getElementById('search-results');
+    if (!input || !results) {
+        return;
+    }
+
+    let timer = null;
+    let lastQuery = '';
+
+    input.addEventListener('input', function() {
+        clearTimeout(timer);
+        const query = input.value.trim();
+        if (query.length < 2) {
+            results.hidden = true;
+            return;
+        }
+        // Wait for the user to pause typing before hitting the API
+        timer = setTimeout(function() {
+            lastQuery = query;
+            fetch('/api/v1/search?q=' + encodeURIComponent(query))
+                .then(response => {
+                    if (!response.ok) {
+                        throw new Error('Failed to search');
+                    }
+                    return response.json();
+                })
+                .then(data => {
+                    // Ignore responses for queries the user has already typed past
+                    if (query !== lastQuery) {
+                        return;
+                    }
+                    renderSearchResults(results, data);
+                })
+                .catch(error => {
+                    console.error('Error:', error);
+                });
+        }, 200);
+    });
+
+    input.addEventListener('keydown', function(e) {
+        if (e.key === 'Escape') {
+            results.hidden = true;
+        }
+    });
+
+    document.addEventListener('click', function(e) {
+        if (!results.contains(e.target) && e.target !== input) {
+            results.hidden = true;
+        }
+    });
+}
+
+function renderSearchResults(container, data) {
+    container.innerHTML = '';
+
+    if (data.length === 0) {
+        container.innerHTML = '<p class="search-empty">No matches.</p>';
+        container.hidden = false;
+        return;
+    }
+
+    data.forEach(result => {
+        const link = document.createElement('a');
+        link.className = 'search-result';
+        link.href = searchResultURL(result);
+        link.innerHTML = `
+            <span class="search-type">${escapeHTML(result.type)}</span>
+            <span class="search-snippet">${highlightSnippet(result.snippet)}</span>
+        `;
+        container.appendChild(link);
+    });
+    container.hidden = false;
+}
+
+function searchResultURL(result) {
+    switch (result.type) {
+        case 'user':
+            return '/users#user-' + result.id;
+        default:
+            return '#';
+    }
+}
+
+// Escape the snippet and then restore only the <mark> tags added by the server
+function highlightSnippet(snippet) {
+    return escapeHTML(snippet)
+        .replace(/&lt;mark&gt;/g, '<mark>')
+        .replace(/&lt;\/mark&gt;/g, '</mark>');
+}
+
+function escapeHTML(value) {
+    const div = document.createElement('div');
+    div.textContent = value == null ? '' : String(value);
+    return div.innerHTML;
+}
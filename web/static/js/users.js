@@ -1,7 +1,10 @@
+// Custom field definitions for users, loaded once per page
+let customFieldDefs = [];
+
 document.addEventListener('DOMContentLoaded', function() {
 
-    fetchUsers();
-    
+    loadCustomFields().then(fetchUsers);
+    subscribeToChanges();
 
     const form = document.getElementById('create-user-form');
     if (form) {
@@ -13,10 +16,167 @@ document.addEventListener('DOMContentLoaded', function() {
 });
 
 
+// Whether the change feed is connected and keeps the list up to date
+let liveUpdates = false;
+let refetchTimer = null;
+
+
+// Follow user changes, teammates' included, and reload the list when there are
+// some. Bursts of changes, like a bulk update, cause a single reload.
+function subscribeToChanges() {
+    if (!window.EventSource) {
+        return;
+    }
+    const events = new EventSource('/api/v1/events?entity=user');
+    let reconnecting = false;
+    events.addEventListener('open', function() {
+        // changes made while disconnected were missed
+        if (reconnecting) {
+            fetchUsers();
+        }
+        liveUpdates = true;
+        reconnecting = false;
+    });
+    events.addEventListener('change', function() {
+        clearTimeout(refetchTimer);
+        refetchTimer = setTimeout(fetchUsers, 250);
+    });
+    events.addEventListener('error', function() {
+        // the browser reconnects on its own
+        liveUpdates = false;
+        reconnecting = true;
+    });
+}
+
+
+function loadCustomFields() {
+    return fetch('/api/v1/custom-fields?entity=user')
+        .then(response => {
+            if (!response.ok) {
+                throw new Error('Failed to fetch custom fields');
+            }
+            return response.json();
+        })
+        .then(data => {
+            customFieldDefs = data;
+            renderCustomFieldInputs();
+        })
+        .catch(error => {
+            console.error('Error:', error);
+        });
+}
+
+
+function renderCustomFieldInputs() {
+    const container = document.getElementById('custom-fields');
+    if (!container) {
+        return;
+    }
+    container.innerHTML = '';
+
+    customFieldDefs.forEach(def => {
+        const group = document.createElement('div');
+        group.className = 'form-group';
+
+        const label = document.createElement('label');
+        label.htmlFor = 'cf-' + def.key;
+        label.textContent = def.label + ':';
+        group.appendChild(label);
+
+        let input;
+        switch (def.type) {
+            case 'select':
+            case 'multi_select':
+                input = document.createElement('select');
+                input.multiple = def.type === 'multi_select';
+                if (!input.multiple) {
+                    input.appendChild(new Option('', ''));
+                }
+                def.options.forEach(option => input.appendChild(new Option(option, option)));
+                break;
+            case 'user':
+                input = document.createElement('select');
+                input.appendChild(new Option('', ''));
+                fetch('/api/v1/users')
+                    .then(response => response.json())
+                    .then(users => users.forEach(user => input.appendChild(new Option(user.name, user.id))))
+                    .catch(error => console.error('Error:', error));
+                break;
+            case 'boolean':
+                input = document.createElement('input');
+                input.type = 'checkbox';
+                break;
+            case 'number':
+                input = document.createElement('input');
+                input.type = 'number';
+                input.step = 'any';
+                break;
+            case 'date':
+                input = document.createElement('input');
+                input.type = 'date';
+                break;
+            default:
+                input = document.createElement('input');
+                input.type = 'text';
+        }
+        input.id = 'cf-' + def.key;
+        input.dataset.key = def.key;
+        input.dataset.type = def.type;
+        group.appendChild(input);
+
+        container.appendChild(group);
+    });
+}
+
+
+// Collect custom field inputs into the JSON shape the API validates
+function collectCustomFields() {
+    const fields = {};
+    document.querySelectorAll('#custom-fields [data-key]').forEach(input => {
+        const key = input.dataset.key;
+        switch (input.dataset.type) {
+            case 'multi_select': {
+                const selected = Array.from(input.selectedOptions).map(option => option.value);
+                if (selected.length > 0) {
+                    fields[key] = selected;
+                }
+                break;
+            }
+            case 'boolean':
+                fields[key] = input.checked;
+                break;
+            case 'number':
+            case 'user':
+                if (input.value !== '') {
+                    fields[key] = Number(input.value);
+                }
+                break;
+            default:
+                if (input.value !== '') {
+                    fields[key] = input.value;
+                }
+        }
+    });
+    return fields;
+}
+
+
+function formatCustomValue(value) {
+    if (Array.isArray(value)) {
+        return value.join(', ');
+    }
+    if (typeof value === 'boolean') {
+        return value ? 'Yes' : 'No';
+    }
+    return String(value);
+}
+
+
 function fetchUsers() {
     const userList = document.getElementById('user-list');
     
-    fetch('/api/v1/users')
+    // hottest leads first
+    fetch('/api/v1/users?sort=-score')
         .then(response => {
             if (!response.ok) {
                 throw new Error('Failed to fetch users');
@@ -34,11 +194,40 @@ function fetchUsers() {
             data.forEach(user => {
                 const userElement = document.createElement('div');
                 userElement.className = 'user-card';
+                userElement.id = 'user-' + user.id;
                 userElement.innerHTML = `
                     <h3>${user.name}</h3>
                     <p>Email: ${user.email}</p>
                     <p>Created: ${new Date(user.created_at).toLocaleDateString()}</p>
                 `;
+                const score = document.createElement('p');
+                score.className = 'score';
+                score.textContent = `Score: ${user.score || 0}`;
+                if (user.score_breakdown) {
+                    score.title = user.score_breakdown
+                        .map(part => `${part.rule}: ${part.points > 0 ? '+' : ''}${part.points}`)
+                        .join('\n');
+                }
+                userElement.appendChild(score);
+                if (user.tags && user.tags.length > 0) {
+                    const tags = document.createElement('p');
+                    tags.className = 'tags';
+                    user.tags.forEach(tag => {
+                        const badge = document.createElement('span');
+                        badge.className = 'tag';
+                        badge.textContent = tag;
+                        tags.appendChild(badge);
+                    });
+                    userElement.appendChild(tags);
+                }
+                customFieldDefs.forEach(def => {
+                    if (user.custom_fields && user.custom_fields[def.key] !== undefined) {
+                        const field = document.createElement('p');
+                        field.textContent = `${def.label}: ${formatCustomValue(user.custom_fields[def.key])}`;
+                        userElement.appendChild(field);
+                    }
+                });
+                userElement.appendChild(renderHistoryTab(user));
                 userList.appendChild(userElement);
             });
         })
@@ -49,6 +238,132 @@ function fetchUsers() {
 }
 
 
+// History tab of a user card, loaded the first time it is opened
+function renderHistoryTab(user) {
+    const tab = document.createElement('details');
+    tab.className = 'history';
+    const summary = document.createElement('summary');
+    summary.textContent = 'History';
+    tab.appendChild(summary);
+
+    const asOf = document.createElement('div');
+    asOf.className = 'form-group';
+    const label = document.createElement('label');
+    label.htmlFor = 'as-of-' + user.id;
+    label.textContent = 'View as of:';
+    const input = document.createElement('input');
+    input.type = 'datetime-local';
+    input.id = 'as-of-' + user.id;
+    const snapshot = document.createElement('div');
+    snapshot.className = 'history-snapshot';
+    input.addEventListener('change', () => showUserAsOf(user.id, input.value, snapshot));
+    asOf.append(label, input);
+    tab.append(asOf, snapshot);
+
+    const list = document.createElement('ul');
+    list.className = 'history-list';
+    tab.appendChild(list);
+
+    tab.addEventListener('toggle', () => {
+        if (tab.open && !tab.dataset.loaded) {
+            tab.dataset.loaded = 'true';
+            loadHistory(user.id, list);
+        }
+    });
+    return tab;
+}
+
+
+function historyFieldLabel(field) {
+    const key = field.startsWith('custom_fields.') ? field.slice('custom_fields.'.length) : null;
+    if (key === null) {
+        return field.replace('_id', '').replace('_', ' ');
+    }
+    const def = customFieldDefs.find(def => def.key === key);
+    return def ? def.label : key;
+}
+
+
+function formatHistoryValue(value) {
+    if (value === null || value === undefined) {
+        return '(empty)';
+    }
+    return formatCustomValue(value);
+}
+
+
+function loadHistory(userId, list) {
+    list.innerHTML = '<li>Loading history...</li>';
+    fetch(`/api/v1/users/${userId}/history`)
+        .then(response => {
+            if (!response.ok) {
+                throw new Error('Failed to fetch history');
+            }
+            return response.json();
+        })
+        .then(changes => {
+            list.innerHTML = '';
+            if (changes.length === 0) {
+                list.innerHTML = '<li>No changes yet.</li>';
+                return;
+            }
+            changes.forEach(change => {
+                const item = document.createElement('li');
+                item.textContent = `${new Date(change.changed_at).toLocaleString()} - ${change.actor} changed ` +
+                    `${historyFieldLabel(change.field)} from ${formatHistoryValue(change.from)} to ${formatHistoryValue(change.to)}`;
+                list.appendChild(item);
+            });
+        })
+        .catch(error => {
+            console.error('Error:', error);
+            list.innerHTML = '';
+            const item = document.createElement('li');
+            item.textContent = `Error loading history: ${error.message}`;
+            list.appendChild(item);
+        });
+}
+
+
+// Show the user's tracked fields as they were at a local date and time
+function showUserAsOf(userId, value, snapshot) {
+    snapshot.innerHTML = '';
+    if (value === '') {
+        return;
+    }
+    const asOf = new Date(value).toISOString();
+    fetch(`/api/v1/users/${userId}?as_of=${encodeURIComponent(asOf)}`)
+        .then(response => {
+            if (!response.ok) {
+                return response.json().then(body => {
+                    throw new Error(body.error || 'Failed to fetch user');
+                });
+            }
+            return response.json();
+        })
+        .then(user => {
+            const lines = [`Name: ${user.name}`, `Email: ${user.email}`];
+            if (user.tags && user.tags.length > 0) {
+                lines.push(`Tags: ${user.tags.join(', ')}`);
+            }
+            customFieldDefs.forEach(def => {
+                if (user.custom_fields && user.custom_fields[def.key] !== undefined) {
+                    lines.push(`${def.label}: ${formatCustomValue(user.custom_fields[def.key])}`);
+                }
+            });
+            lines.forEach(line => {
+                const p = document.createElement('p');
+                p.textContent = line;
+                snapshot.appendChild(p);
+            });
+        })
+        .catch(error => {
+            const p = document.createElement('p');
+            p.textContent = error.message;
+            snapshot.appendChild(p);
+        });
+}
+
+
 function createUser() {
     const name = document.getElementById('name').value;
     const email = document.getElementById('email').value;
@@ -60,22 +375,26 @@ function createUser() {
         },
         body: JSON.stringify({
             name: name,
-            email: email
+            email: email,
+            custom_fields: collectCustomFields()
         })
     })
     .then(response => {
         if (!response.ok) {
-            throw new Error('Failed to create user');
+            return response.json().then(body => {
+                throw new Error(body.error || 'Failed to create user');
+            });
         }
         return response.json();
     })
     .then(data => {
         // Clear form
-        document.getElementById('name').value = '';
-        document.getElementById('email').value = '';
-        
-        // Reload user list
-        fetchUsers();
+        document.getElementById('create-user-form').reset();
+
+        // The change feed reloads the list when it is connected
+        if (!liveUpdates) {
+            fetchUsers();
+        }
         
         alert('User created successfully!');
     })
@@ -83,4 +402,4 @@ function createUser() {
         console.error('Error:', error);
         alert(`Error creating user: ${error.message}`);
     });
-}
\ No newline at end of file
+}